@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/getAlby/lndhub.go/db"
+	"github.com/getAlby/lndhub.go/db/migrations"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/uptrace/bun/migrate"
+)
+
+// Imports the users, balances and invoices of a Redis dump of the Node.js LndHub
+// Usage: import-lndhub <dump.jsonl>, the dump is read from stdin if no file is given
+func main() {
+	c := &service.Config{}
+
+	// Load configruation from environment variables
+	err := godotenv.Load(".env")
+	if err != nil {
+		fmt.Println("Failed to load .env file")
+	}
+	err = envconfig.Process("", c)
+	if err != nil {
+		log.Fatalf("Error loading environment variables: %v", err)
+	}
+	logger := lib.Logger(c.LogFilePath, nil)
+
+	var dump io.Reader = os.Stdin
+	if len(os.Args) > 1 {
+		file, err := os.Open(os.Args[1])
+		if err != nil {
+			logger.Fatalf("Error opening the dump: %v", err)
+		}
+		defer file.Close()
+		dump = file
+	}
+
+	dbConn, err := db.Open(c.DatabaseUri)
+	if err != nil {
+		logger.Fatalf("Error initializing db connection: %v", err)
+	}
+
+	// Migrate the DB
+	ctx := context.Background()
+	migrator := migrate.NewMigrator(dbConn, migrations.For(dbConn))
+	err = migrator.Init(ctx)
+	if err != nil {
+		logger.Fatalf("Error initializing db migrator: %v", err)
+	}
+	_, err = migrator.Migrate(ctx)
+	if err != nil {
+		logger.Fatalf("Error migrating database: %v", err)
+	}
+
+	svc := &service.LndhubService{
+		Config: c,
+		DB:     dbConn,
+		Logger: logger,
+	}
+	report, err := svc.ImportLegacyLndhub(ctx, dump)
+	if report != nil {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		logger.Fatalf("Error importing the dump: %v", err)
+	}
+}
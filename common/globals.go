@@ -5,14 +5,96 @@ const (
 	InvoiceTypePaid     = "paid_invoice"
 	InvoiceTypeIncoming = "incoming"
 	InvoiceTypeUser     = "user_invoice"
+	InvoiceTypeSwapCost = "swap_cost"
 
 	InvoiceStateSettled     = "settled"
 	InvoiceStateInitialized = "initialized"
 	InvoiceStateOpen        = "open"
 	InvoiceStateError       = "error"
+	InvoiceStateExpired     = "expired"
+	InvoiceStateCanceled    = "canceled"
 
-	AccountTypeIncoming = "incoming"
-	AccountTypeCurrent  = "current"
-	AccountTypeOutgoing = "outgoing"
-	AccountTypeFees     = "fees"
+	AccountTypeIncoming    = "incoming"
+	AccountTypeCurrent     = "current"
+	AccountTypeOutgoing    = "outgoing"
+	AccountTypeInFlight    = "in_flight"
+	AccountTypeFees        = "fees"
+	AccountTypeServiceFees = "service_fees"
+	AccountTypeSwapCosts   = "swap_costs"
+	AccountTypeAdjustments = "adjustments"
+
+	WebhookDeliveryStatePending   = "pending"
+	WebhookDeliveryStateDelivered = "delivered"
+	WebhookDeliveryStateDead      = "dead"
+	WebhookDeliveryStateDiscarded = "discarded"
+
+	WebhookEventInvoiceSettled           = "invoice.settled"
+	WebhookEventInvoiceExpired           = "invoice.expired"
+	WebhookEventPaymentRepeatedlyFailing = "payment.repeatedly_failing"
+	WebhookEventRecurringInvoicePaid     = "recurring_invoice.paid"
+	WebhookEventRecurringInvoiceMissed   = "recurring_invoice.missed"
+	WebhookEventBalanceNegative          = "balance.negative"
+
+	NotificationTypePaymentRepeatedlyFailing = "payment_repeatedly_failing"
+
+	JobStatePending   = "pending"
+	JobStateRunning   = "running"
+	JobStateCompleted = "completed"
+	JobStateFailed    = "failed"
+
+	JobTypeInvoiceSettled = "invoice_settled"
+	JobTypePrismSplits    = "prism_splits"
+
+	ContactTypeUser             = "user"
+	ContactTypeLightningAddress = "lightning_address"
+	ContactTypePubkey           = "pubkey"
+
+	BalanceClaimStateOpen     = "open"
+	BalanceClaimStateRedeemed = "redeemed"
+	BalanceClaimStateCanceled = "canceled"
+
+	SwapTypeLoopOut = "loop_out"
+	SwapTypeLoopIn  = "loop_in"
+
+	SwapStatePending   = "pending"
+	SwapStateSucceeded = "succeeded"
+	SwapStateFailed    = "failed"
+
+	SwapInitiatorAdmin = "admin"
+	SwapInitiatorAuto  = "auto"
+
+	BackupMethodCommand = "command"
+	BackupMethodWebhook = "webhook"
+
+	BackupStateRunning   = "running"
+	BackupStateSucceeded = "succeeded"
+	BackupStateFailed    = "failed"
+
+	UserTierBasic    = "basic"
+	UserTierVerified = "verified"
+	UserTierMerchant = "merchant"
+
+	DisplayUnitSats = "sats"
+	DisplayUnitBTC  = "btc"
+	DisplayUnitFiat = "fiat"
+
+	CapabilityOnchainWithdrawals = "onchain_withdrawals"
+	CapabilityAPIKeys            = "api_keys"
+
+	IntegrityIncidentDuplicateSettlement = "duplicate_settlement"
+	IntegrityIncidentWrongAccountType    = "wrong_account_type"
+	IntegrityIncidentOrphanedFee         = "orphaned_fee"
+	IntegrityIncidentBalanceMismatch     = "balance_mismatch"
+	IntegrityIncidentMissingSettlement   = "missing_settlement"
+	IntegrityIncidentUnbalancedInvoice   = "unbalanced_invoice"
+	IntegrityIncidentOrphanedEntry       = "orphaned_entry"
+	IntegrityIncidentNegativeBalance     = "negative_balance"
+
+	InvoiceMetadataSource     = "source"
+	InvoiceSourceDonationPage = "donation_page"
+	InvoiceSourceLndhubImport = "lndhub_import"
+	InvoiceSourceAdjustment   = "adjustment"
+	InvoiceSourceTransfer     = "transfer"
+	InvoiceSourceAutoWithdraw = "auto_withdraw"
+	InvoiceSourcePrism        = "prism"
 )
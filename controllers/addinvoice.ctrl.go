@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
+	"github.com/getAlby/lndhub.go/db/models"
 	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/getAlby/lndhub.go/lib/service"
 	"github.com/getsentry/sentry-go"
@@ -22,12 +25,27 @@ type AddInvoiceRequestBody struct {
 	Amount          interface{} `json:"amt"` // amount in Satoshi
 	Memo            string      `json:"memo"`
 	DescriptionHash string      `json:"description_hash" validate:"omitempty,hexadecimal,len=64"`
+	ZapRequest      string      `json:"zap_request"`             // NIP-57 zap request, also accepted as ?nostr= like LNURL-pay callbacks
+	Expiry          int64       `json:"expiry" validate:"gte=0"` // in seconds, 0 uses the default expiry
+	Preimage        string      `json:"preimage" validate:"omitempty,hexadecimal,len=64"`
+	RHash           string      `json:"r_hash" validate:"omitempty,hexadecimal,len=64,excluded_with=Preimage"` // makes a hold invoice, settled with the preimage later
+	WebhookUrl      string      `json:"webhook_url" validate:"omitempty,url,max=2048"`                         // receives the settlement and expiry of this invoice
+}
+
+type SettleHoldInvoiceRequestBody struct {
+	Preimage string `json:"preimage" validate:"required,hexadecimal,len=64"`
+}
+
+type HoldInvoiceResponseBody struct {
+	RHash string `json:"r_hash"`
+	State string `json:"state"`
 }
 
 type AddInvoiceResponseBody struct {
 	RHash          string `json:"r_hash"`
 	PaymentRequest string `json:"payment_request"`
 	PayReq         string `json:"pay_req"`
+	Warning        string `json:"warning,omitempty"`
 }
 
 // AddInvoice : Add invoice Controller
@@ -50,21 +68,155 @@ func AddInvoice(c echo.Context, svc *service.LndhubService, userID int64) error
 	}
 
 	amount, err := svc.ParseInt(body.Amount)
-	if err != nil {
+	if err != nil || amount < 0 {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	// only the owner of the account controls the preimage and webhook, invoices created for others always get a random preimage
+	if _, owner := c.Get("UserID").(int64); !owner && (body.Preimage != "" || body.RHash != "" || body.WebhookUrl != "") {
 		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
 	}
-	c.Logger().Infof("Adding invoice: user_id=%v memo=%s value=%v description_hash=%s", userID, body.Memo, amount, body.DescriptionHash)
+	c.Logger().Infof("Adding invoice: user_id=%v memo=%s value=%v description_hash=%s hold=%v", userID, body.Memo, amount, body.DescriptionHash, body.RHash != "")
 
-	invoice, err := svc.AddIncomingInvoice(c.Request().Context(), userID, amount, body.Memo, body.DescriptionHash)
+	zapRequest := body.ZapRequest
+	if zapRequest == "" {
+		zapRequest = c.QueryParam("nostr")
+	}
+	var invoice *models.Invoice
+	if zapRequest != "" {
+		invoice, err = svc.AddZapInvoice(c.Request().Context(), userID, amount, zapRequest)
+	} else {
+		invoice, err = svc.AddIncomingInvoice(c.Request().Context(), userID, amount, body.Memo, body.DescriptionHash, body.Expiry, body.Preimage, body.RHash, body.WebhookUrl)
+	}
 	if err != nil {
-		c.Logger().Errorf("Error creating invoice: %v", err)
-		sentry.CaptureException(err)
-		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+		return addInvoiceErrorResponse(c, err)
 	}
+	c.Set(idempotencyInvoiceIDKey, invoice.ID)
 	responseBody := AddInvoiceResponseBody{}
 	responseBody.RHash = invoice.RHash
 	responseBody.PaymentRequest = invoice.PaymentRequest
 	responseBody.PayReq = invoice.PaymentRequest
+	responseBody.Warning = inboundLiquidityWarning(c, svc, invoice.Amount)
 
 	return c.JSON(http.StatusOK, &responseBody)
 }
+
+// SettleHoldInvoice : Settle a hold invoice with its preimage
+func (controller *AddInvoiceController) SettleHoldInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body SettleHoldInvoiceRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load settle hold invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid settle hold invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	invoice, err := controller.svc.SettleHoldInvoice(c.Request().Context(), userID, c.Param("payment_hash"), body.Preimage)
+	if err != nil {
+		return holdInvoiceErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, &HoldInvoiceResponseBody{RHash: invoice.RHash, State: invoice.State})
+}
+
+// CancelHoldInvoice : Cancel a hold invoice, a held payment is returned to the payer
+func (controller *AddInvoiceController) CancelHoldInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	invoice, err := controller.svc.CancelHoldInvoice(c.Request().Context(), userID, c.Param("payment_hash"))
+	if err != nil {
+		return holdInvoiceErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, &HoldInvoiceResponseBody{RHash: invoice.RHash, State: invoice.State})
+}
+
+// CancelInvoice : Cancel an open incoming invoice, it can not be paid anymore
+func (controller *AddInvoiceController) CancelInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	invoice, err := controller.svc.CancelInvoice(c.Request().Context(), userID, c.Param("payment_hash"))
+	if errors.Is(err, service.ErrInvoiceNotCancelable) {
+		return c.JSON(http.StatusNotFound, responses.InvoiceNotCancelableError)
+	}
+	if err != nil {
+		c.Logger().Errorf("Error canceling invoice: %v", err)
+		sentry.CaptureException(err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &HoldInvoiceResponseBody{RHash: invoice.RHash, State: invoice.State})
+}
+
+func holdInvoiceErrorResponse(c echo.Context, err error) error {
+	if errors.Is(err, service.ErrHoldInvoiceNotFound) || errors.Is(err, service.ErrInvoiceNotCancelable) {
+		return c.JSON(http.StatusNotFound, responses.HoldInvoiceNotFoundError)
+	}
+	if errors.Is(err, service.ErrInvalidPreimage) {
+		return c.JSON(http.StatusBadRequest, responses.InvalidPreimageError)
+	}
+	c.Logger().Errorf("Error updating hold invoice: %v", err)
+	sentry.CaptureException(err)
+	return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+}
+
+func addInvoiceErrorResponse(c echo.Context, err error) error {
+	var quotaError *service.InvoiceQuotaExceededError
+	if errors.As(err, &quotaError) {
+		retryAfter := int64(quotaError.RetryAfter.Seconds())
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+		return c.JSON(http.StatusTooManyRequests, echo.Map{
+			"error":       true,
+			"code":        responses.InvoiceQuotaExceededError.Code,
+			"message":     responses.InvoiceQuotaExceededError.Message,
+			"quota":       quotaError.Quota,
+			"limit":       quotaError.Limit,
+			"retry_after": retryAfter,
+		})
+	}
+	if errors.Is(err, service.ErrInboundLiquidityInsufficient) {
+		return c.JSON(http.StatusBadRequest, responses.InboundLiquidityInsufficientError)
+	}
+	if errors.Is(err, service.ErrInvalidZapRequest) || errors.Is(err, service.ErrZapsDisabled) {
+		c.Logger().Errorf("Invalid zap invoice: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if errors.Is(err, service.ErrInvalidPreimage) {
+		return c.JSON(http.StatusBadRequest, responses.InvalidPreimageError)
+	}
+	if errors.Is(err, service.ErrInvalidWebhookUrl) || errors.Is(err, service.ErrWebhookAddressNotAllowed) {
+		return c.JSON(http.StatusBadRequest, responses.InvalidWebhookUrlError)
+	}
+	if errors.Is(err, service.ErrInvalidInvoiceExpiry) {
+		return c.JSON(http.StatusBadRequest, responses.InvalidInvoiceExpiryError)
+	}
+	var amountError *service.ReceiveAmountError
+	if errors.As(err, &amountError) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error":          true,
+			"code":           responses.AmountOutOfRangeError.Code,
+			"message":        amountError.Error(),
+			"min_receivable": amountError.MinReceivable,
+			"max_receivable": amountError.MaxReceivable,
+		})
+	}
+	if errors.Is(err, service.ErrAmountOutOfRange) {
+		return c.JSON(http.StatusBadRequest, responses.AmountOutOfRangeError)
+	}
+	if errors.Is(err, service.ErrReceivingPaused) {
+		return c.JSON(http.StatusBadRequest, responses.ReceivingPausedError)
+	}
+	if errors.Is(err, service.ErrAccountFrozen) {
+		return c.JSON(http.StatusBadRequest, responses.AccountFrozenError)
+	}
+	c.Logger().Errorf("Error creating invoice: %v", err)
+	sentry.CaptureException(err)
+	return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+}
+
+// inboundLiquidityWarning warns the client about invoices the node can currently not receive
+func inboundLiquidityWarning(c echo.Context, svc *service.LndhubService, amount int64) string {
+	if svc.Config.InboundLiquidityCheck != service.InboundLiquidityCheckWarn {
+		return ""
+	}
+	if err := svc.CheckInboundLiquidity(c.Request().Context(), amount); err != nil {
+		return responses.InboundLiquidityInsufficientError.Message
+	}
+	return ""
+}
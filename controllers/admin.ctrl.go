@@ -0,0 +1,432 @@
+package controllers
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// AdminController : Admin controller struct
+type AdminController struct {
+	svc *service.LndhubService
+}
+
+func NewAdminController(svc *service.LndhubService) *AdminController {
+	return &AdminController{svc: svc}
+}
+
+type ForceFailInvoiceRequestBody struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+type ForceFailInvoiceResponseBody struct {
+	ID            int64  `json:"id"`
+	UserID        int64  `json:"user_id"`
+	State         string `json:"state"`
+	ErrorMessage  string `json:"error_message"`
+	FailureReason string `json:"failure_reason"`
+}
+
+type SetUserTierRequestBody struct {
+	Tier string `json:"tier" validate:"required"`
+}
+
+// SetUserTier : Assign a user to a tier
+func (controller *AdminController) SetUserTier(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	reqBody := SetUserTierRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load set tier request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid set tier request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := controller.svc.SetUserTier(c.Request().Context(), id, reqBody.Tier); err != nil {
+		c.Logger().Errorf("Failed to set tier user_id=%v tier=%s: %v", id, reqBody.Tier, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, controller.svc.TierSettingsFor(reqBody.Tier))
+}
+
+type FreezeUserRequestBody struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// FreezeUser : Block authentication, payments and invoice creation of a user without deleting any data
+func (controller *AdminController) FreezeUser(c echo.Context) error {
+	return controller.setUserFrozen(c, true)
+}
+
+// UnfreezeUser : Lift the freeze of a user
+func (controller *AdminController) UnfreezeUser(c echo.Context) error {
+	return controller.setUserFrozen(c, false)
+}
+
+func (controller *AdminController) setUserFrozen(c echo.Context, frozen bool) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	reqBody := FreezeUserRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load freeze user request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid freeze user request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	ctx := c.Request().Context()
+	if _, err := controller.svc.FindUser(ctx, id); err != nil {
+		c.Logger().Errorf("Failed to find user user_id=%v: %v", id, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if frozen {
+		err = controller.svc.FreezeUser(ctx, id, reqBody.Reason)
+	} else {
+		err = controller.svc.UnfreezeUser(ctx, id, reqBody.Reason)
+	}
+	if err != nil {
+		return err
+	}
+	user, err := controller.svc.FindUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	var frozenAt *int64
+	if !user.FrozenAt.IsZero() {
+		unix := user.FrozenAt.Time.Unix()
+		frozenAt = &unix
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":        user.ID,
+		"frozen":    !user.FrozenAt.IsZero(),
+		"frozen_at": frozenAt,
+	})
+}
+
+type AdjustBalanceRequestBody struct {
+	Amount int64  `json:"amount" validate:"required"` // positive amounts are credited, negative amounts debited
+	Reason string `json:"reason" validate:"required"`
+	Memo   string `json:"memo"` // shown to the user with the transaction
+}
+
+// AdjustBalance : Credit or debit a user, e.g. for support refunds and corrections, the reason is recorded in the audit log
+func (controller *AdminController) AdjustBalance(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	reqBody := AdjustBalanceRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load adjust balance request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid adjust balance request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	ctx := c.Request().Context()
+	if _, err := controller.svc.FindUser(ctx, id); err != nil {
+		c.Logger().Errorf("Failed to find user user_id=%v: %v", id, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	invoice, err := controller.svc.AdjustBalance(ctx, id, reqBody.Amount, reqBody.Reason, reqBody.Memo)
+	if errors.Is(err, service.ErrInvalidAdjustment) || errors.Is(err, service.ErrAdjustmentExceedsBalance) {
+		c.Logger().Errorf("Failed to adjust balance user_id=%v: %v", id, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	balance, err := controller.svc.CurrentUserBalance(ctx, id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"invoice_id": invoice.ID,
+		"user_id":    id,
+		"amount":     reqBody.Amount,
+		"memo":       invoice.Memo,
+		"balance":    balance,
+	})
+}
+
+type StartSwapRequestBody struct {
+	Type   string `json:"type" validate:"required,oneof=loop_out loop_in"`
+	Amount int64  `json:"amount" validate:"required,gt=0"`
+}
+
+// Swaps : List the latest submarine swaps
+func (controller *AdminController) Swaps(c echo.Context) error {
+	swaps, err := controller.svc.Swaps(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &swaps)
+}
+
+// SwapQuote : Quote the maximum costs of a loop out or loop in
+func (controller *AdminController) SwapQuote(c echo.Context) error {
+	amount, err := strconv.ParseInt(c.QueryParam("amount"), 10, 64)
+	if err != nil || amount <= 0 {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	swapType := c.QueryParam("type")
+	if swapType != common.SwapTypeLoopOut && swapType != common.SwapTypeLoopIn {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	quote, err := controller.svc.SwapQuote(c.Request().Context(), swapType, amount)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"type":     swapType,
+		"amount":   amount,
+		"quote":    quote,
+		"max_cost": quote.Total(),
+	})
+}
+
+// StartSwap : Start a loop out for more inbound or a loop in for more outbound liquidity
+func (controller *AdminController) StartSwap(c echo.Context) error {
+	reqBody := StartSwapRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load swap request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid swap request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	swap, err := controller.svc.StartSwap(c.Request().Context(), reqBody.Type, reqBody.Amount, common.SwapInitiatorAdmin)
+	if errors.Is(err, service.ErrSwapTooExpensive) {
+		return c.JSON(http.StatusBadRequest, responses.SwapTooExpensiveError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, swap)
+}
+
+// ReconcileSettlements : Backfill settlements the invoice subscription missed
+func (controller *AdminController) ReconcileSettlements(c echo.Context) error {
+	backfilled, err := controller.svc.ReconcileSettlements(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"backfilled": backfilled})
+}
+
+// RetentionReport : Dry run of the retention policy, the invoices it would purge or anonymize
+func (controller *AdminController) RetentionReport(c echo.Context) error {
+	results, err := controller.svc.ApplyRetentionPolicy(c.Request().Context(), true)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &results)
+}
+
+// ApplyRetention : Purge and anonymize invoices according to the retention policy right away
+func (controller *AdminController) ApplyRetention(c echo.Context) error {
+	results, err := controller.svc.ApplyRetentionPolicy(c.Request().Context(), false)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &results)
+}
+
+// BackupRuns : List the latest database backup runs
+func (controller *AdminController) BackupRuns(c echo.Context) error {
+	runs, err := controller.svc.BackupRuns(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &runs)
+}
+
+// RunBackup : Verify the ledger and trigger a database backup right away
+func (controller *AdminController) RunBackup(c echo.Context) error {
+	run, err := controller.svc.RunBackup(c.Request().Context())
+	if errors.Is(err, service.ErrBackupsDisabled) {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, run)
+}
+
+// IntegrityIncidents : List the latest ledger integrity incidents
+func (controller *AdminController) IntegrityIncidents(c echo.Context) error {
+	incidents, err := controller.svc.IntegrityIncidents(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &incidents)
+}
+
+// Metrics : Runtime and payment latency metrics in expvar format
+func (controller *AdminController) Metrics(c echo.Context) error {
+	expvar.Handler().ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// DeadWebhooks : List dead-lettered webhook deliveries including their attempt history
+func (controller *AdminController) DeadWebhooks(c echo.Context) error {
+	deliveries, err := controller.svc.DeadWebhookDeliveries(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &deliveries)
+}
+
+// ReplayWebhook : Queue a dead-lettered webhook delivery again
+func (controller *AdminController) ReplayWebhook(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := controller.svc.ReplayWebhookDelivery(c.Request().Context(), id); err != nil {
+		c.Logger().Errorf("Failed to replay webhook delivery delivery_id=%v: %v", id, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DiscardWebhook : Discard a dead-lettered webhook delivery
+func (controller *AdminController) DiscardWebhook(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := controller.svc.DiscardWebhookDelivery(c.Request().Context(), id); err != nil {
+		c.Logger().Errorf("Failed to discard webhook delivery delivery_id=%v: %v", id, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PaymentRoute : Hops, fees and time-lock of a successful outgoing payment
+func (controller *AdminController) PaymentRoute(c echo.Context) error {
+	invoiceId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	route, err := controller.svc.PaymentRouteFor(c.Request().Context(), invoiceId)
+	if err != nil {
+		c.Logger().Errorf("Failed to load payment route invoice_id=%v: %v", invoiceId, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, route)
+}
+
+// ForceFailInvoice : Fail an outgoing invoice that is stuck in-flight and revert the user's debit
+func (controller *AdminController) ForceFailInvoice(c echo.Context) error {
+	invoiceId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	var body ForceFailInvoiceRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load force fail request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid force fail request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	invoice, err := controller.svc.ForceFailOutgoingInvoice(c.Request().Context(), invoiceId, body.Reason)
+	if errors.Is(err, service.ErrPaymentNotFailed) {
+		c.Logger().Errorf("Failed to force fail invoice invoice_id=%v: %v", invoiceId, err)
+		return c.JSON(http.StatusConflict, responses.PaymentNotFailedError)
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to force fail invoice invoice_id=%v: %v", invoiceId, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	return c.JSON(http.StatusOK, &ForceFailInvoiceResponseBody{
+		ID:            invoice.ID,
+		UserID:        invoice.UserID,
+		State:         invoice.State,
+		ErrorMessage:  invoice.ErrorMessage,
+		FailureReason: invoice.FailureReason,
+	})
+}
+
+type AdminStatsResponseBody struct {
+	InFlight            *service.InFlightExposure `json:"in_flight"`
+	MaxInFlightExposure int64                     `json:"max_in_flight_exposure"`
+	OutboundLiquidity   int64                     `json:"outbound_liquidity"`
+}
+
+// Stats : Amount locked in in-flight payments and the node's outbound liquidity
+func (controller *AdminController) Stats(c echo.Context) error {
+	exposure, err := controller.svc.InFlightExposure(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &AdminStatsResponseBody{
+		InFlight:            exposure,
+		MaxInFlightExposure: controller.svc.Config.MaxInFlightExposure,
+		OutboundLiquidity:   controller.svc.Liquidity().Outbound,
+	})
+}
+
+// FeeReport : Routing fees paid by all users per period in UTC, ?user_id= limits the report to one user
+func (controller *AdminController) FeeReport(c echo.Context) error {
+	period, from, to, err := parseFeeReportRange(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	var userId int64
+	if value := c.QueryParam("user_id"); value != "" {
+		userId, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+		}
+	}
+	report, err := controller.svc.RoutingFeeReport(service.WithReadReplica(c.Request().Context()), userId, period, from, to, time.UTC)
+	if errors.Is(err, service.ErrInvalidFeeReportPeriod) {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// BalanceAudit : Compare the balances of the ledger with the node's channel and on-chain balance
+func (controller *AdminController) BalanceAudit(c echo.Context) error {
+	audit, err := controller.svc.AuditBalances(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, audit)
+}
+
+// SupportBundle : Redacted diagnostic bundle to attach to bug reports
+func (controller *AdminController) SupportBundle(c echo.Context) error {
+	bundle, err := controller.svc.SupportBundle(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"lndhub-support-%s.json\"", bundle.GeneratedAt.UTC().Format("20060102T150405Z")))
+	return c.JSON(http.StatusOK, bundle)
+}
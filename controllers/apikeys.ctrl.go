@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// APIKeysController : API keys controller struct
+type APIKeysController struct {
+	svc *service.LndhubService
+}
+
+func NewAPIKeysController(svc *service.LndhubService) *APIKeysController {
+	return &APIKeysController{svc: svc}
+}
+
+type CreateAPIKeyRequestBody struct {
+	Name   string   `json:"name" validate:"required,max=255"`
+	Scopes []string `json:"scopes" validate:"required,min=1"`
+	// spend limit of the payments made with the key, zero for no limit
+	MaxAmountPerPayment int64 `json:"max_amount_per_payment" validate:"gte=0"`
+	MaxAmountPerDay     int64 `json:"max_amount_per_day" validate:"gte=0"`
+}
+
+type CreateAPIKeyResponseBody struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+// GetAPIKeys : List the user's api keys, the keys themselves are not returned
+func (controller *APIKeysController) GetAPIKeys(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	apiKeys, err := controller.svc.APIKeysFor(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &apiKeys)
+}
+
+// CreateAPIKey : Create an api key with scopes, the key is only returned in this response
+func (controller *APIKeysController) CreateAPIKey(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body CreateAPIKeyRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load api key request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid api key request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	apiKey, key, err := controller.svc.CreateAPIKey(c.Request().Context(), userID, body.Name, body.Scopes, body.MaxAmountPerPayment, body.MaxAmountPerDay)
+	if errors.Is(err, service.ErrInvalidAPIKey) || errors.Is(err, service.ErrTooManyAPIKeys) || errors.Is(err, service.ErrInvalidSpendLimit) {
+		c.Logger().Errorf("Could not create api key user_id=%v: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &CreateAPIKeyResponseBody{APIKey: apiKey, Key: key})
+}
+
+// DeleteAPIKey : Revoke an api key
+func (controller *APIKeysController) DeleteAPIKey(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	apiKeyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := controller.svc.DeleteAPIKey(c.Request().Context(), userID, apiKeyID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
 	"github.com/labstack/echo/v4"
 )
 
@@ -23,6 +25,7 @@ type AuthRequestBody struct {
 	Login        string `json:"login"`
 	Password     string `json:"password"`
 	RefreshToken string `json:"refresh_token"`
+	Device       string `json:"device"` // name of the session, the user agent is used when empty
 }
 type AuthResponseBody struct {
 	RefreshToken string `json:"refresh_token"`
@@ -42,7 +45,14 @@ func (controller *AuthController) Auth(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
 	}
 
-	accessToken, refreshToken, err := controller.svc.GenerateToken(c.Request().Context(), body.Login, body.Password, body.RefreshToken)
+	device := body.Device
+	if device == "" {
+		device = c.Request().UserAgent()
+	}
+	accessToken, refreshToken, err := controller.svc.GenerateToken(c.Request().Context(), body.Login, body.Password, body.RefreshToken, device)
+	if errors.Is(err, service.ErrAccountFrozen) {
+		return c.JSON(http.StatusBadRequest, responses.AccountFrozenError)
+	}
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, responses.BadAuthError)
 	}
@@ -52,3 +62,15 @@ func (controller *AuthController) Auth(c echo.Context) error {
 		AccessToken:  accessToken,
 	})
 }
+
+// Logout : Revoke the access token of the request and sign the device of its session out
+func (controller *AuthController) Logout(c echo.Context) error {
+	accessToken, ok := c.Get("AccessToken").(*tokens.AccessToken)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, responses.BadAuthError)
+	}
+	if err := controller.svc.Logout(c.Request().Context(), accessToken); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// AutoWithdrawalController : Auto-withdrawal controller struct
+type AutoWithdrawalController struct {
+	svc *service.LndhubService
+}
+
+func NewAutoWithdrawalController(svc *service.LndhubService) *AutoWithdrawalController {
+	return &AutoWithdrawalController{svc: svc}
+}
+
+type SetAutoWithdrawalRequestBody struct {
+	Threshold   int64  `json:"threshold" validate:"gte=0"` // in satoshis, the balance that is kept
+	Destination string `json:"destination" validate:"required"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// GetAutoWithdrawal : Get the user's auto-withdrawal and the result of the last payout
+func (controller *AutoWithdrawalController) GetAutoWithdrawal(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	autoWithdrawal, err := controller.svc.AutoWithdrawalFor(c.Request().Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, autoWithdrawal)
+}
+
+// SetAutoWithdrawal : Pay out the balance above the threshold to a lightning address
+func (controller *AutoWithdrawalController) SetAutoWithdrawal(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body SetAutoWithdrawalRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load auto-withdrawal request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid auto-withdrawal request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	autoWithdrawal, err := controller.svc.SetAutoWithdrawal(c.Request().Context(), userID, body.Threshold, body.Destination, body.Enabled)
+	if errors.Is(err, service.ErrInvalidAutoWithdrawal) {
+		c.Logger().Errorf("Invalid auto-withdrawal user_id=%v: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, autoWithdrawal)
+}
+
+// DeleteAutoWithdrawal : Stop paying out the balance
+func (controller *AutoWithdrawalController) DeleteAutoWithdrawal(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	if err := controller.svc.DeleteAutoWithdrawal(c.Request().Context(), userID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
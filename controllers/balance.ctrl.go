@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/getAlby/lndhub.go/lib/service"
 	"github.com/labstack/echo/v4"
 )
@@ -22,10 +25,10 @@ type BalanceResponse struct {
 	}
 }
 
-// Balance : Balance Controller
+// Balance : Balance Controller, read from the read replica if one is configured
 func (controller *BalanceController) Balance(c echo.Context) error {
 	userId := c.Get("UserID").(int64)
-	balance, err := controller.svc.CurrentUserBalance(c.Request().Context(), userId)
+	balance, err := controller.svc.CurrentUserBalance(service.WithReadReplica(c.Request().Context()), userId)
 	if err != nil {
 		return err
 	}
@@ -35,3 +38,33 @@ func (controller *BalanceController) Balance(c echo.Context) error {
 		},
 	})
 }
+
+// BalanceHistory : Balance of the user at the end of every ?granularity= (day, week or month, default day) between
+// ?from= and ?to= (unix timestamps), the periods start in the user's timezone
+func (controller *BalanceController) BalanceHistory(c echo.Context) error {
+	userId := c.Get("UserID").(int64)
+	granularity := c.QueryParam("granularity")
+	if granularity == "" {
+		granularity = service.FeeReportPeriodDay
+	}
+	from, to, err := parseTimeRange(c)
+	if err != nil || (!from.IsZero() && !to.IsZero() && to.Before(from)) {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	preferences, err := controller.svc.PreferencesFor(c.Request().Context(), userId)
+	if err != nil {
+		return err
+	}
+	location, err := time.LoadLocation(preferences.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	history, err := controller.svc.BalanceHistory(service.WithReadReplica(c.Request().Context()), userId, granularity, from, to, location)
+	if errors.Is(err, service.ErrInvalidBalanceHistory) {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, history)
+}
@@ -2,7 +2,7 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net/http"
 
 	"github.com/getAlby/lndhub.go/lib"
@@ -20,6 +20,8 @@ type FetchInvoiceRequestBody struct {
 	Amount int64  `json:"amt" validate:"required"` // todo: validate properly, amount not strictly needed always amount in Satoshi
 	Memo   string `json:"memo"`
 	Offer  string `json:"offer" validate:"required"`
+	// returned by an earlier attempt of an unusual payment
+	ConfirmationToken string `json:"confirmation_token"`
 }
 
 func NewBolt12Controller(svc *service.LndhubService) *Bolt12Controller {
@@ -84,6 +86,9 @@ func (controller *Bolt12Controller) PayBolt12(c echo.Context) error {
 	}
 
 	invoice, err := controller.svc.AddOutgoingInvoice(c.Request().Context(), userID, bolt12.Encoded, decodedPaymentRequest)
+	if errors.Is(err, service.ErrDestinationNotAllowed) {
+		return paymentErrorResponse(c, err)
+	}
 	if err != nil {
 		return err
 	}
@@ -92,22 +97,29 @@ func (controller *Bolt12Controller) PayBolt12(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	tier, err := controller.svc.UserTierSettings(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	// the maximum routing fee is reserved until the payment settles
+	feeReserve, err := controller.svc.FeeReserveFor(invoice)
+	if err != nil {
+		return err
+	}
 
-	if currentBalance < invoice.Amount {
+	if currentBalance < invoice.Amount+tier.OutgoingServiceFeeFor(invoice.Amount)+feeReserve {
 		c.Logger().Errorf("User does not have enough balance invoice_id=%v user_id=%v balance=%v amount=%v", invoice.ID, userID, currentBalance, invoice.Amount)
 
 		return c.JSON(http.StatusBadRequest, responses.NotEnoughBalanceError)
 	}
+	if err := controller.svc.CheckPaymentConfirmation(c.Request().Context(), invoice, body.ConfirmationToken); err != nil {
+		return paymentErrorResponse(c, err)
+	}
 
 	sendPaymentResponse, err := controller.svc.PayInvoice(c.Request().Context(), invoice)
 	if err != nil {
-		c.Logger().Errorf("Payment failed: %v", err)
-		sentry.CaptureException(err)
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error":   true,
-			"code":    10,
-			"message": fmt.Sprintf("Payment failed. Does the receiver have enough inbound capacity? (%v)", err),
-		})
+		return paymentErrorResponse(c, err)
 	}
 
 	var responseBody struct {
@@ -120,6 +132,7 @@ func (controller *Bolt12Controller) PayBolt12(c echo.Context) error {
 		PaymentError       string                `json:"payment_error,omitempty"`
 		PaymentPreimage    *lib.JavaScriptBuffer `json:"payment_preimage,omitempty"`
 		PaymentRoute       *service.Route        `json:"route,omitempty"`
+		ServiceFee         int64                 `json:"service_fee,omitempty"`
 	}
 
 	responseBody.RHash = &lib.JavaScriptBuffer{Data: sendPaymentResponse.PaymentHash}
@@ -129,6 +142,7 @@ func (controller *Bolt12Controller) PayBolt12(c echo.Context) error {
 	responseBody.PaymentError = sendPaymentResponse.PaymentError
 	responseBody.PaymentPreimage = &lib.JavaScriptBuffer{Data: sendPaymentResponse.PaymentPreimage}
 	responseBody.PaymentRoute = sendPaymentResponse.PaymentRoute
+	responseBody.ServiceFee = invoice.ServiceFee
 
 	return c.JSON(http.StatusOK, &responseBody)
 }
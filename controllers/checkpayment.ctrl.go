@@ -13,8 +13,16 @@ type CheckPaymentController struct {
 	svc *service.LndhubService
 }
 
+// CheckPaymentResponseBody : paid is the LndHub field, the other fields are only set if known
 type CheckPaymentResponseBody struct {
-	IsPaid bool `json:"paid"`
+	IsPaid          bool   `json:"paid"`
+	State           string `json:"state,omitempty"`
+	Type            string `json:"type,omitempty"`
+	Amount          int64  `json:"amount,omitempty"`
+	Fee             int64  `json:"fee,omitempty"`
+	ServiceFee      int64  `json:"service_fee,omitempty"`
+	SettledAt       int64  `json:"settled_at,omitempty"` // unix timestamp
+	PaymentPreimage string `json:"payment_preimage,omitempty"`
 }
 
 func NewCheckPaymentController(svc *service.LndhubService) *CheckPaymentController {
@@ -36,5 +44,15 @@ func (controller *CheckPaymentController) CheckPayment(c echo.Context) error {
 
 	responseBody := &CheckPaymentResponseBody{}
 	responseBody.IsPaid = !invoice.SettledAt.IsZero()
+	responseBody.State = invoice.State
+	responseBody.Type = invoice.Type
+	responseBody.Amount = invoice.Amount
+	responseBody.Fee = invoice.Fee
+	responseBody.ServiceFee = invoice.ServiceFee
+	if responseBody.IsPaid {
+		responseBody.SettledAt = invoice.SettledAt.Unix()
+		// the invoice belongs to the user, the preimage is the proof of the payment
+		responseBody.PaymentPreimage = invoice.Preimage
+	}
 	return c.JSON(http.StatusOK, &responseBody)
 }
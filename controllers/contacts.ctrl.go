@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// ContactsController : Contacts controller struct
+type ContactsController struct {
+	svc *service.LndhubService
+}
+
+func NewContactsController(svc *service.LndhubService) *ContactsController {
+	return &ContactsController{svc: svc}
+}
+
+type CreateContactRequestBody struct {
+	Name  string `json:"name" validate:"required,max=100"`
+	Type  string `json:"type" validate:"required,oneof=user lightning_address pubkey"`
+	Value string `json:"value" validate:"required,max=255"`
+}
+
+type ContactResponseBody struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Value        string `json:"value"`
+	PaymentCount int64  `json:"payment_count"`
+	TotalAmount  int64  `json:"total_amount"`
+	TotalFees    int64  `json:"total_fees"`
+	LastPaidAt   int64  `json:"last_paid_at,omitempty"`
+}
+
+type ContactPayment struct {
+	PaymentHash interface{} `json:"payment_hash"`
+	Value       int64       `json:"value"`
+	Fee         int64       `json:"fee"`
+	Memo        string      `json:"memo"`
+	Timestamp   int64       `json:"timestamp"`
+}
+
+// GetContacts : List the user's contacts including payment stats
+func (controller *ContactsController) GetContacts(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	contacts, err := controller.svc.ContactsFor(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	response := make([]ContactResponseBody, len(contacts))
+	for i := range contacts {
+		stats, err := controller.svc.ContactStatsFor(c.Request().Context(), &contacts[i])
+		if err != nil {
+			return err
+		}
+		response[i] = ContactResponseBody{
+			ID:           contacts[i].ID,
+			Name:         contacts[i].Name,
+			Type:         contacts[i].Type,
+			Value:        contacts[i].Value,
+			PaymentCount: stats.PaymentCount,
+			TotalAmount:  stats.TotalAmount,
+			TotalFees:    stats.TotalFees,
+		}
+		if !stats.LastPaidAt.IsZero() {
+			response[i].LastPaidAt = stats.LastPaidAt.Unix()
+		}
+	}
+	return c.JSON(http.StatusOK, &response)
+}
+
+// CreateContact : Save a new contact
+func (controller *ContactsController) CreateContact(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body CreateContactRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load contact request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid contact request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	contact, err := controller.svc.CreateContact(c.Request().Context(), userID, body.Name, body.Type, body.Value)
+	if err != nil {
+		c.Logger().Errorf("Failed to create contact user_id=%v: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, &ContactResponseBody{
+		ID:    contact.ID,
+		Name:  contact.Name,
+		Type:  contact.Type,
+		Value: contact.Value,
+	})
+}
+
+// DeleteContact : Remove a contact
+func (controller *ContactsController) DeleteContact(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	contactID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := controller.svc.DeleteContact(c.Request().Context(), userID, contactID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetContactPayments : Payment history with one contact
+func (controller *ContactsController) GetContactPayments(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	contactID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	contact, err := controller.svc.FindContact(c.Request().Context(), userID, contactID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	invoices, err := controller.svc.ContactPayments(c.Request().Context(), contact)
+	if err != nil {
+		return err
+	}
+
+	response := make([]ContactPayment, len(invoices))
+	for i, invoice := range invoices {
+		rhash, _ := lib.ToJavaScriptBuffer(invoice.RHash)
+		response[i] = ContactPayment{
+			PaymentHash: rhash,
+			Value:       invoice.Amount,
+			Fee:         invoice.Fee,
+			Memo:        invoice.Memo,
+			Timestamp:   invoice.CreatedAt.Unix(),
+		}
+	}
+	return c.JSON(http.StatusOK, &response)
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// CountersController : Counters controller struct
+type CountersController struct {
+	svc *service.LndhubService
+}
+
+func NewCountersController(svc *service.LndhubService) *CountersController {
+	return &CountersController{svc: svc}
+}
+
+// GetCounters : Badge counts of the user, ?cursor= is the cursor of the previous response
+func (controller *CountersController) GetCounters(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var cursor int64
+	if c.QueryParam("cursor") != "" {
+		var err error
+		cursor, err = strconv.ParseInt(c.QueryParam("cursor"), 10, 64)
+		if err != nil || cursor < 0 {
+			return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+		}
+	}
+	counts, err := controller.svc.InvoiceCounts(c.Request().Context(), userID, cursor)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, counts)
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+	"github.com/skip2/go-qrcode"
+)
+
+// DonationPageController : Donation page controller struct
+type DonationPageController struct {
+	svc  *service.LndhubService
+	html string
+}
+
+func NewDonationPageController(svc *service.LndhubService, html string) *DonationPageController {
+	return &DonationPageController{svc: svc, html: html}
+}
+
+type SaveDonationPageRequestBody struct {
+	Slug             string  `json:"slug" validate:"required"`
+	DisplayName      string  `json:"display_name" validate:"required"`
+	Description      string  `json:"description"`
+	SuggestedAmounts []int64 `json:"suggested_amounts"`
+	Enabled          bool    `json:"enabled"`
+}
+
+type PublicDonationPageResponseBody struct {
+	DisplayName      string  `json:"display_name"`
+	Description      string  `json:"description,omitempty"`
+	Lnurl            string  `json:"lnurl"`
+	SuggestedAmounts []int64 `json:"suggested_amounts"`
+	Supporters       int     `json:"supporters"` // settled donations in the last 30 days
+	QRCode           string  `json:"-"`
+}
+
+type LnurlPayResponseBody struct {
+	Tag            string `json:"tag"`
+	Callback       string `json:"callback"`
+	MinSendable    int64  `json:"minSendable"`
+	MaxSendable    int64  `json:"maxSendable"`
+	Metadata       string `json:"metadata"`
+	CommentAllowed int    `json:"commentAllowed"`
+}
+
+type LnurlPayCallbackResponseBody struct {
+	Pr     string        `json:"pr"`
+	Routes []interface{} `json:"routes"`
+}
+
+type LnurlErrorResponseBody struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// GetDonationPage : Donation page settings of the user
+func (controller *DonationPageController) GetDonationPage(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	page, err := controller.svc.DonationPageFor(c.Request().Context(), userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to load donation page user_id=%v: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// SaveDonationPage : Create or update the user's donation page, it is only public while enabled
+func (controller *DonationPageController) SaveDonationPage(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body SaveDonationPageRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load donation page request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid donation page request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	page, err := controller.svc.SaveDonationPage(c.Request().Context(), &models.DonationPage{
+		UserID:           userID,
+		Slug:             body.Slug,
+		DisplayName:      body.DisplayName,
+		Description:      body.Description,
+		SuggestedAmounts: body.SuggestedAmounts,
+		Enabled:          body.Enabled,
+	})
+	if err != nil {
+		c.Logger().Errorf("Failed to save donation page user_id=%v: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// DonationPageJSON : Public donation page data
+func (controller *DonationPageController) DonationPageJSON(c echo.Context) error {
+	content, err := controller.publicContent(c)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, content)
+}
+
+// DonationPage : Public donation page with a LNURL-pay QR code
+func (controller *DonationPageController) DonationPage(c echo.Context) error {
+	content, err := controller.publicContent(c)
+	if err != nil {
+		return c.String(http.StatusNotFound, "Not found")
+	}
+	png, err := qrcode.Encode(content.Lnurl, qrcode.Medium, 256)
+	if err != nil {
+		return err
+	}
+	content.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	tmpl, err := template.New("donate").Parse(controller.html)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, content); err != nil {
+		return err
+	}
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
+
+// LnurlPay : LNURL-pay request of the donation page (LUD-06)
+func (controller *DonationPageController) LnurlPay(c echo.Context) error {
+	page, err := controller.svc.PublicDonationPage(c.Request().Context(), c.Param("slug"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &LnurlErrorResponseBody{Status: "ERROR", Reason: "donation page not found"})
+	}
+	metadata, err := service.DonationLnurlMetadata(page)
+	if err != nil {
+		return err
+	}
+	minSendable, maxSendable := controller.svc.AmountLimits(nil).LnurlSendable()
+	return c.JSON(http.StatusOK, &LnurlPayResponseBody{
+		Tag:         "payRequest",
+		Callback:    donationPageUrl(c, page) + "/lnurlp/callback",
+		MinSendable: minSendable,
+		MaxSendable: maxSendable,
+		Metadata:    metadata,
+	})
+}
+
+// LnurlPayCallback : Invoice for a donation, the amount is given in millisatoshis
+func (controller *DonationPageController) LnurlPayCallback(c echo.Context) error {
+	page, err := controller.svc.PublicDonationPage(c.Request().Context(), c.Param("slug"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &LnurlErrorResponseBody{Status: "ERROR", Reason: "donation page not found"})
+	}
+	amountMsat, err := strconv.ParseInt(c.QueryParam("amount"), 10, 64)
+	if err != nil || amountMsat%1000 != 0 {
+		return c.JSON(http.StatusBadRequest, &LnurlErrorResponseBody{Status: "ERROR", Reason: "amount must be whole satoshis in millisatoshis"})
+	}
+	invoice, err := controller.svc.AddDonationInvoice(c.Request().Context(), page, amountMsat/1000)
+	var amountError *service.ReceiveAmountError
+	if errors.As(err, &amountError) {
+		return c.JSON(http.StatusBadRequest, &LnurlErrorResponseBody{Status: "ERROR", Reason: amountError.Error()})
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to create donation invoice slug=%s: %v", page.Slug, err)
+		return c.JSON(http.StatusBadRequest, &LnurlErrorResponseBody{Status: "ERROR", Reason: "could not create an invoice"})
+	}
+	return c.JSON(http.StatusOK, &LnurlPayCallbackResponseBody{Pr: invoice.PaymentRequest, Routes: []interface{}{}})
+}
+
+func (controller *DonationPageController) publicContent(c echo.Context) (*PublicDonationPageResponseBody, error) {
+	ctx := c.Request().Context()
+	page, err := controller.svc.PublicDonationPage(ctx, c.Param("slug"))
+	if err != nil {
+		return nil, err
+	}
+	lnurl, err := service.EncodeLnurl(donationPageUrl(c, page) + "/lnurlp")
+	if err != nil {
+		return nil, err
+	}
+	supporters, err := controller.svc.DonationSupporterCount(ctx, page)
+	if err != nil {
+		c.Logger().Errorf("Failed to count supporters slug=%s: %v", page.Slug, err)
+	}
+	suggestedAmounts := page.SuggestedAmounts
+	if suggestedAmounts == nil {
+		suggestedAmounts = []int64{}
+	}
+	return &PublicDonationPageResponseBody{
+		DisplayName:      page.DisplayName,
+		Description:      page.Description,
+		Lnurl:            lnurl,
+		SuggestedAmounts: suggestedAmounts,
+		Supporters:       supporters,
+	}, nil
+}
+
+func donationPageUrl(c echo.Context, page *models.DonationPage) string {
+	return c.Scheme() + "://" + c.Request().Host + "/donate/" + url.PathEscape(page.Slug)
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// FeesController : Routing fees report controller struct
+type FeesController struct {
+	svc *service.LndhubService
+}
+
+func NewFeesController(svc *service.LndhubService) *FeesController {
+	return &FeesController{svc: svc}
+}
+
+// parseFeeReportRange reads ?period= (day, week or month, default month), ?from= and ?to= (unix timestamps)
+func parseFeeReportRange(c echo.Context) (period string, from, to time.Time, err error) {
+	period = c.QueryParam("period")
+	if period == "" {
+		period = service.FeeReportPeriodMonth
+	}
+	from, to, err = parseTimeRange(c)
+	if err == nil && !from.IsZero() && !to.IsZero() && to.Before(from) {
+		err = errors.New("to is before from")
+	}
+	return period, from, to, err
+}
+
+// GetFeeReport : Routing fees the user paid per period, the periods start in the user's timezone
+func (controller *FeesController) GetFeeReport(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	period, from, to, err := parseFeeReportRange(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	preferences, err := controller.svc.PreferencesFor(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	location, err := time.LoadLocation(preferences.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	report, err := controller.svc.RoutingFeeReport(service.WithReadReplica(c.Request().Context()), userID, period, from, to, location)
+	if errors.Is(err, service.ErrInvalidFeeReportPeriod) {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, report)
+}
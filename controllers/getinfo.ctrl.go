@@ -5,6 +5,7 @@ import (
 
 	"github.com/getAlby/lndhub.go/lib/service"
 	"github.com/labstack/echo/v4"
+	"github.com/lightningnetwork/lnd/lnrpc"
 )
 
 // GetInfoController : GetInfoController struct
@@ -16,6 +17,21 @@ func NewGetInfoController(svc *service.LndhubService) *GetInfoController {
 	return &GetInfoController{svc: svc}
 }
 
+// GetInfoResponseBody is the node info of LND extended with the live node alias
+// `alias` is replaced by CUSTOM_NAME if configured, `node_alias` is always the alias of the node
+// The health fields shadow the fields of lnrpc to include them even if they are false or 0
+type GetInfoResponseBody struct {
+	*lnrpc.GetInfoResponse
+	NodeAlias     string `json:"node_alias"`
+	BlockHeight   uint32 `json:"block_height"`
+	SyncedToChain bool   `json:"synced_to_chain"`
+	SyncedToGraph bool   `json:"synced_to_graph"`
+	// ReceivingPaused is set while PAUSE_RECEIVING_WITHOUT_INBOUND denies new invoices
+	ReceivingPaused bool `json:"receiving_paused"`
+	// AmountLimits are the hub-wide limits, the send limit of a user's tier can differ
+	AmountLimits service.AmountLimits `json:"amount_limits"`
+}
+
 // GetInfo : GetInfo handler
 func (controller *GetInfoController) GetInfo(c echo.Context) error {
 
@@ -24,10 +40,19 @@ func (controller *GetInfoController) GetInfo(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	responseBody := GetInfoResponseBody{
+		GetInfoResponse: info,
+		NodeAlias:       info.Alias,
+		BlockHeight:     info.BlockHeight,
+		SyncedToChain:   info.SyncedToChain,
+		SyncedToGraph:   info.SyncedToGraph,
+		ReceivingPaused: controller.svc.ReceivingPaused(),
+		AmountLimits:    controller.svc.AmountLimits(nil),
+	}
 	if controller.svc.Config.CustomName != "" {
 		info.Alias = controller.svc.Config.CustomName
 	}
 	// BlueWallet right now requires a `identity_pubkey` in the response
 	// https://github.com/BlueWallet/BlueWallet/blob/a28a2b96bce0bff6d1a24a951b59dc972369e490/class/wallets/lightning-custodian-wallet.js#L578
-	return c.JSON(http.StatusOK, info)
+	return c.JSON(http.StatusOK, &responseBody)
 }
@@ -1,10 +1,18 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/getAlby/lndhub.go/common"
 	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/export"
+	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/getAlby/lndhub.go/lib/service"
 	"github.com/labstack/echo/v4"
 )
@@ -27,70 +35,328 @@ type OutgoingInvoice struct {
 	Fee             int64       `json:"fee"`
 	Timestamp       int64       `json:"timestamp"`
 	Memo            string      `json:"memo"`
+	EncryptedMemo   string      `json:"encrypted_memo,omitempty"`
+	MemoKeyHint     string      `json:"memo_key_hint,omitempty"`
+	Label           string      `json:"label,omitempty"`
+	Pending         bool        `json:"pending,omitempty"`
+	ServiceFee      int64       `json:"service_fee,omitempty"`
+	FormattedAmount string      `json:"formatted_amount,omitempty"`
+	FormattedTime   string      `json:"formatted_time,omitempty"`
 }
 
 type IncomingInvoice struct {
-	RHash          interface{} `json:"r_hash,omitempty"`
-	PaymentHash    interface{} `json:"payment_hash"`
-	PaymentRequest string      `json:"payment_request"`
-	Description    string      `json:"description"`
-	PayReq         string      `json:"pay_req"`
-	Timestamp      int64       `json:"timestamp"`
-	Type           string      `json:"type"`
-	ExpireTime     int64       `json:"expire_time"`
-	Amount         int64       `json:"amt"`
-	IsPaid         bool        `json:"ispaid"`
+	RHash           interface{}       `json:"r_hash,omitempty"`
+	PaymentHash     interface{}       `json:"payment_hash"`
+	PaymentRequest  string            `json:"payment_request"`
+	Description     string            `json:"description"`
+	EncryptedMemo   string            `json:"encrypted_memo,omitempty"`
+	MemoKeyHint     string            `json:"memo_key_hint,omitempty"`
+	Label           string            `json:"label,omitempty"`
+	PayReq          string            `json:"pay_req"`
+	Timestamp       int64             `json:"timestamp"`
+	Type            string            `json:"type"`
+	ExpireTime      int64             `json:"expire_time"`
+	Amount          int64             `json:"amt"`
+	IsPaid          bool              `json:"ispaid"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	ServiceFee      int64             `json:"service_fee,omitempty"`
+	FormattedAmount string            `json:"formatted_amount,omitempty"`
+	FormattedTime   string            `json:"formatted_time,omitempty"`
 }
 
-// GetTXS : Get TXS Controller
+// parseTransactionFilter reads the filter of ?type= (all, incoming, outgoing or keysend), ?from= and ?to= (unix timestamps),
+// ?limit= and ?cursor=
+func parseTransactionFilter(c echo.Context) (service.TransactionFilter, error) {
+	filter := service.TransactionFilter{Type: c.QueryParam("type")}
+	var err error
+	filter.From, filter.To, err = parseTimeRange(c)
+	if err != nil {
+		return filter, err
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil {
+			return filter, err
+		}
+	}
+	if value := c.QueryParam("cursor"); value != "" {
+		filter.Cursor, err = service.ParseTransactionCursor(value)
+		if err != nil {
+			return filter, err
+		}
+	}
+	return filter, filter.Validate()
+}
+
+// parseTimeRange reads the unix timestamps of ?from= and ?to=, unset times are zero
+func parseTimeRange(c echo.Context) (from, to time.Time, err error) {
+	for param, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if value := c.QueryParam(param); value != "" {
+			timestamp, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return from, to, err
+			}
+			*t = time.Unix(timestamp, 0)
+		}
+	}
+	return from, to, nil
+}
+
+// GetTXS : Get TXS Controller, the latest 100 outgoing transactions unless filtered, see parseTransactionFilter
+// If there may be more transactions the cursor of the next page is returned in the X-Next-Cursor header
+// With ?include_pending=true open incoming invoices and in-flight payments are included in the first page and marked as pending
+// With ?formatted=true amounts and times are also formatted with the user's display preferences
+// The transactions are read from the read replica if one is configured
 func (controller *GetTXSController) GetTXS(c echo.Context) error {
 	userId := c.Get("UserID").(int64)
+	ctx := service.WithReadReplica(c.Request().Context())
 
-	invoices, err := controller.svc.InvoicesFor(c.Request().Context(), userId, common.InvoiceTypeOutgoing)
+	filter, err := parseTransactionFilter(c)
+	if err != nil {
+		c.Logger().Errorf("Invalid transaction filter: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	invoices, nextCursor, err := controller.svc.TransactionsFor(ctx, userId, filter)
 	if err != nil {
 		return err
 	}
+	if nextCursor != nil {
+		c.Response().Header().Set("X-Next-Cursor", nextCursor.String())
+	}
 
-	response := make([]OutgoingInvoice, len(invoices))
-	for i, invoice := range invoices {
+	response := make([]OutgoingInvoice, 0, len(invoices))
+	if c.QueryParam("include_pending") == "true" && filter.Cursor == nil {
+		pendingInvoices, err := controller.svc.PendingInvoicesFor(ctx, userId)
+		if err != nil {
+			return err
+		}
+		for _, invoice := range pendingInvoices {
+			rhash, _ := lib.ToJavaScriptBuffer(invoice.RHash)
+			txType := common.InvoiceTypePaid
+			if invoice.Type == common.InvoiceTypeIncoming {
+				txType = common.InvoiceTypeUser
+			}
+			response = append(response, OutgoingInvoice{
+				RHash:       rhash,
+				PaymentHash: rhash,
+				Value:       invoice.Amount,
+				Type:        txType,
+				Timestamp:   invoice.CreatedAt.Unix(),
+				Memo:        invoice.Memo,
+				Label:       invoice.Label,
+				Pending:     true,
+			})
+		}
+	}
+
+	for _, invoice := range invoices {
 		rhash, _ := lib.ToJavaScriptBuffer(invoice.RHash)
-		response[i] = OutgoingInvoice{
+		txType := common.InvoiceTypePaid
+		if invoice.Type == common.InvoiceTypeIncoming {
+			txType = common.InvoiceTypeUser
+		}
+		response = append(response, OutgoingInvoice{
 			RHash:           rhash,
 			PaymentHash:     rhash,
 			PaymentPreimage: invoice.Preimage,
 			Value:           invoice.Amount,
-			Type:            common.InvoiceTypePaid,
+			Type:            txType,
 			Fee:             0, //TODO charge fees
 			Timestamp:       invoice.CreatedAt.Unix(),
 			Memo:            invoice.Memo,
+			EncryptedMemo:   invoice.EncryptedMemo,
+			MemoKeyHint:     invoice.MemoKeyHint,
+			Label:           invoice.Label,
+			ServiceFee:      invoice.ServiceFee,
+		})
+	}
+	sort.SliceStable(response, func(i, j int) bool { return response[i].Timestamp > response[j].Timestamp })
+	if c.QueryParam("formatted") == "true" {
+		formatter, err := controller.svc.AmountFormatterFor(c.Request().Context(), userId)
+		if err != nil {
+			return err
+		}
+		for i := range response {
+			response[i].FormattedAmount = formatter.FormatAmount(response[i].Value)
+			response[i].FormattedTime = formatter.FormatTime(time.Unix(response[i].Timestamp, 0))
 		}
 	}
 	return c.JSON(http.StatusOK, &response)
 }
 
+// parseInvoiceFilter reads the filter of ?type=, ?state= (comma separated), ?from= and ?to= (unix timestamps),
+// ?limit=, ?offset=, ?cursor= and ?archived=true
+func parseInvoiceFilter(c echo.Context) (service.InvoiceFilter, error) {
+	filter := service.InvoiceFilter{Type: common.InvoiceTypeIncoming}
+	if invoiceType := c.QueryParam("type"); invoiceType != "" {
+		filter.Type = invoiceType
+	}
+	if states := c.QueryParam("state"); states != "" {
+		filter.States = strings.Split(states, ",")
+	}
+	var err error
+	filter.From, filter.To, err = parseTimeRange(c)
+	if err != nil {
+		return filter, err
+	}
+	for param, n := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if value := c.QueryParam(param); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return filter, err
+			}
+			*n = parsed
+		}
+	}
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return filter, err
+		}
+		filter.Cursor = cursor
+	}
+	filter.Archived = c.QueryParam("archived") == "true"
+	return filter, filter.Validate()
+}
+
+// GetUserInvoices : Get the user's incoming invoices, the latest 100 unless a page is requested
+// The invoices can be filtered and paged, see parseInvoiceFilter. The number of matching invoices is returned in
+// the X-Total-Count header and, if there may be more, the cursor of the next page in the X-Next-Cursor header
+// With ?formatted=true amounts and times are also formatted with the user's display preferences
+// The invoices are read from the read replica if one is configured
 func (controller *GetTXSController) GetUserInvoices(c echo.Context) error {
 	userId := c.Get("UserID").(int64)
 
-	invoices, err := controller.svc.InvoicesFor(c.Request().Context(), userId, common.InvoiceTypeIncoming)
+	filter, err := parseInvoiceFilter(c)
+	if err != nil {
+		c.Logger().Errorf("Invalid invoice filter: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	invoices, total, err := controller.svc.FilteredInvoicesFor(service.WithReadReplica(c.Request().Context()), userId, filter)
 	if err != nil {
 		return err
 	}
+	c.Response().Header().Set("X-Total-Count", strconv.Itoa(total))
+	if len(invoices) == filter.Limit {
+		c.Response().Header().Set("X-Next-Cursor", strconv.FormatInt(invoices[len(invoices)-1].ID, 10))
+	}
+	var formatter *service.AmountFormatter
+	if c.QueryParam("formatted") == "true" {
+		formatter, err = controller.svc.AmountFormatterFor(c.Request().Context(), userId)
+		if err != nil {
+			return err
+		}
+	}
 
 	response := make([]IncomingInvoice, len(invoices))
 	for i, invoice := range invoices {
+		// large metadata values may be kept in the blob store
+		if err := controller.svc.ResolveInvoiceBlobs(c.Request().Context(), &invoice); err != nil {
+			return err
+		}
 		rhash, _ := lib.ToJavaScriptBuffer(invoice.RHash)
 		response[i] = IncomingInvoice{
 			RHash:          rhash,
 			PaymentHash:    invoice.RHash,
 			PaymentRequest: invoice.PaymentRequest,
 			Description:    invoice.Memo,
+			EncryptedMemo:  invoice.EncryptedMemo,
+			MemoKeyHint:    invoice.MemoKeyHint,
+			Label:          invoice.Label,
 			PayReq:         invoice.PaymentRequest,
 			Timestamp:      invoice.CreatedAt.Unix(),
 			Type:           common.InvoiceTypeUser,
 			ExpireTime:     3600 * 24,
 			Amount:         invoice.Amount,
 			IsPaid:         invoice.State == common.InvoiceStateSettled,
+			Metadata:       invoice.Metadata,
+			ServiceFee:     invoice.ServiceFee,
+		}
+		if !invoice.ExpiresAt.IsZero() {
+			response[i].ExpireTime = int64(invoice.ExpiresAt.Sub(invoice.CreatedAt).Seconds())
+		}
+		if formatter != nil {
+			response[i].FormattedAmount = formatter.FormatAmount(invoice.Amount)
+			response[i].FormattedTime = formatter.FormatTime(invoice.CreatedAt)
 		}
 	}
 	return c.JSON(http.StatusOK, &response)
 }
+
+// ExportTransactions : Stream all settled transactions of the user for bookkeeping, oldest first
+// ?format= is csv (default) or json, ?from= and ?to= (unix timestamps) limit the export to a time range.
+// The export format version is sent upfront in the X-Export-Format-Version header, the row count and checksum
+// of the exported data follow the body in the X-Export-Row-Count and X-Export-Checksum trailers
+func (controller *GetTXSController) ExportTransactions(c echo.Context) error {
+	userId := c.Get("UserID").(int64)
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case export.FormatCSV:
+	case export.FormatJSON:
+		contentType = echo.MIMEApplicationJSONCharsetUTF8
+	default:
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	from, to, err := parseTimeRange(c)
+	if err != nil || (!from.IsZero() && !to.IsZero() && to.Before(from)) {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"transactions-%s.%s\"", time.Now().UTC().Format("20060102"), format))
+	header.Set("X-Export-Format-Version", export.FormatVersion)
+	header.Set("Trailer", "X-Export-Row-Count, X-Export-Checksum")
+	c.Response().WriteHeader(http.StatusOK)
+
+	writer, err := export.NewWriter(c.Response(), format, service.TransactionExportColumns)
+	if err != nil {
+		return err
+	}
+	err = controller.svc.ExportTransactions(service.WithReadReplica(c.Request().Context()), userId, from, to, writer, c.Response().Flush)
+	if err != nil {
+		// the response is already committed, the missing trailers tell the client that the export is incomplete
+		c.Logger().Errorf("Failed to export transactions user_id=%v: %v", userId, err)
+		return err
+	}
+	manifest, err := writer.Close()
+	if err != nil {
+		return err
+	}
+	header.Set("X-Export-Row-Count", strconv.Itoa(manifest.RowCount))
+	header.Set("X-Export-Checksum", fmt.Sprintf("%s=%s", manifest.ChecksumAlgorithm, manifest.Checksum))
+	return nil
+}
+
+type SetLabelRequestBody struct {
+	Label string `json:"label"`
+}
+
+type SetLabelResponseBody struct {
+	PaymentHash string `json:"payment_hash"`
+	Label       string `json:"label"`
+}
+
+// SetLabel : Set the user's label of the transactions with the payment hash, an empty label removes it
+func (controller *GetTXSController) SetLabel(c echo.Context) error {
+	userId := c.Get("UserID").(int64)
+	var body SetLabelRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load set label request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	paymentHash := c.Param("payment_hash")
+	label, err := controller.svc.SetInvoiceLabel(c.Request().Context(), userId, paymentHash, body.Label)
+	if errors.Is(err, service.ErrInvoiceNotFound) {
+		return c.JSON(http.StatusNotFound, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &SetLabelResponseBody{PaymentHash: paymentHash, Label: label})
+}
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// HealthController : HealthController struct
+type HealthController struct {
+	svc *service.LndhubService
+}
+
+func NewHealthController(svc *service.LndhubService) *HealthController {
+	return &HealthController{svc: svc}
+}
+
+type ReadyResponseBody struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Ready : Readiness probe, not ready if the database is unreachable or too much is locked in in-flight payments
+func (controller *HealthController) Ready(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := controller.svc.DB.PingContext(ctx); err != nil {
+		c.Logger().Errorf("Readiness check failed, database unreachable: %v", err)
+		return c.JSON(http.StatusServiceUnavailable, &ReadyResponseBody{Ready: false, Reason: "database unreachable"})
+	}
+	exposure, err := controller.svc.CheckInFlightExposure(ctx)
+	if errors.Is(err, service.ErrInFlightExposureHigh) {
+		c.Logger().Errorf("Readiness check failed, in-flight exposure amount:%v payments:%v", exposure.Amount, exposure.Payments)
+		return c.JSON(http.StatusServiceUnavailable, &ReadyResponseBody{Ready: false, Reason: "in-flight exposure too high"})
+	}
+	if err != nil {
+		c.Logger().Errorf("Readiness check failed: %v", err)
+		return c.JSON(http.StatusServiceUnavailable, &ReadyResponseBody{Ready: false, Reason: "in-flight exposure unavailable"})
+	}
+	return c.JSON(http.StatusOK, &ReadyResponseBody{Ready: true})
+}
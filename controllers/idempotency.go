@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	idempotencyInvoiceIDKey  = "IdempotencyInvoiceID"
+	idempotencyRecordKey     = "IdempotencyRecord"
+	maxIdempotencyKeyLength  = 255
+)
+
+// idempotencyResponseWriter keeps a copy of the response body for replays
+type idempotencyResponseWriter struct {
+	http.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *idempotencyResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// IdempotencyMiddleware : Replay the response of a request with the same Idempotency-Key header instead of running it again
+// Responses are stored unless the request failed with a server error before a payment was booked, the client can retry those with the same key
+func IdempotencyMiddleware(svc *service.LndhubService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				return next(c)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+			}
+			userID := c.Get("UserID").(int64)
+			body, err := ioutil.ReadAll(c.Request().Body)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+			}
+			c.Request().Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			ctx := c.Request().Context()
+			requestHash := service.IdempotencyRequestHash(c.Request().Method, c.Request().URL.Path, body)
+			record, replay, err := svc.BeginIdempotentRequest(ctx, userID, key, requestHash)
+			if errors.Is(err, service.ErrIdempotencyKeyInUse) {
+				return c.JSON(http.StatusConflict, responses.IdempotencyKeyInUseError)
+			}
+			if errors.Is(err, service.ErrIdempotencyKeyMismatch) {
+				return c.JSON(http.StatusUnprocessableEntity, responses.IdempotencyKeyMismatchError)
+			}
+			if err != nil {
+				return err
+			}
+			if replay {
+				c.Logger().Infof("Replaying idempotent request user_id=%v key=%s invoice_id=%v", userID, key, record.InvoiceID)
+				c.Response().Header().Set(IdempotentReplayedHeader, "true")
+				return c.Blob(record.StatusCode, echo.MIMEApplicationJSONCharsetUTF8, []byte(record.Response))
+			}
+
+			c.Set(idempotencyRecordKey, record)
+			writer := &idempotencyResponseWriter{ResponseWriter: c.Response().Writer, body: &bytes.Buffer{}}
+			c.Response().Writer = writer
+			if err := next(c); err != nil {
+				// the error response is written here so that it can be stored with the key
+				c.Error(err)
+			}
+			invoiceID, _ := c.Get(idempotencyInvoiceIDKey).(int64)
+			// the request context may be canceled by now, the key has to be completed or released regardless
+			if c.Response().Status >= http.StatusInternalServerError {
+				sent, sentErr := svc.IdempotentPaymentSent(context.Background(), invoiceID)
+				if sentErr != nil {
+					c.Logger().Errorf("Failed to check the payment of idempotent request user_id=%v key=%s invoice_id=%v: %v", userID, key, invoiceID, sentErr)
+				}
+				// a payment that may have been sent is not retried with the key, its failure response is replayed
+				if sentErr == nil && !sent {
+					if abortErr := svc.AbortIdempotentRequest(context.Background(), record); abortErr != nil {
+						c.Logger().Errorf("Failed to release idempotency key user_id=%v key=%s: %v", userID, key, abortErr)
+					}
+					return nil
+				}
+			}
+			if completeErr := svc.CompleteIdempotentRequest(context.Background(), record, invoiceID, c.Response().Status, writer.body.Bytes()); completeErr != nil {
+				c.Logger().Errorf("Failed to store idempotent response user_id=%v key=%s: %v", userID, key, completeErr)
+			}
+			return nil
+		}
+	}
+}
+
+// setIdempotencyInvoice links the invoice to the idempotency key of the request, if it has one
+// It is stored right away, so the key is not released while the payment of the invoice may be in flight
+func setIdempotencyInvoice(c echo.Context, svc *service.LndhubService, invoiceID int64) {
+	c.Set(idempotencyInvoiceIDKey, invoiceID)
+	record, ok := c.Get(idempotencyRecordKey).(*models.IdempotencyKey)
+	if !ok {
+		return
+	}
+	if err := svc.AttachIdempotentInvoice(c.Request().Context(), record, invoiceID); err != nil {
+		c.Logger().Errorf("Failed to link invoice to idempotency key user_id=%v key=%s invoice_id=%v: %v", record.UserID, record.Key, invoiceID, err)
+	}
+}
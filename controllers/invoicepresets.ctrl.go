@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// InvoicePresetsController : Invoice presets controller struct
+type InvoicePresetsController struct {
+	svc *service.LndhubService
+}
+
+func NewInvoicePresetsController(svc *service.LndhubService) *InvoicePresetsController {
+	return &InvoicePresetsController{svc: svc}
+}
+
+type SaveInvoicePresetRequestBody struct {
+	Name     string            `json:"name" validate:"required,max=100"`
+	Amount   int64             `json:"amt" validate:"gte=0"` // amount in Satoshi, 0 leaves the amount open
+	Memo     string            `json:"memo" validate:"max=640"`
+	Expiry   int64             `json:"expiry" validate:"gte=0"` // in seconds, 0 uses the default expiry
+	Metadata map[string]string `json:"metadata" validate:"omitempty"`
+}
+
+type AddPresetInvoiceRequestBody struct {
+	Amount interface{} `json:"amt"` // amount in Satoshi, only used if the preset leaves the amount open
+}
+
+// GetPresets : List the user's invoice presets
+func (controller *InvoicePresetsController) GetPresets(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	presets, err := controller.svc.InvoicePresetsFor(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &presets)
+}
+
+// SavePreset : Save a named invoice preset
+func (controller *InvoicePresetsController) SavePreset(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body SaveInvoicePresetRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load invoice preset request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid invoice preset request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	preset, err := controller.svc.SaveInvoicePreset(c.Request().Context(), &models.InvoicePreset{
+		UserID:   userID,
+		Name:     body.Name,
+		Amount:   body.Amount,
+		Memo:     body.Memo,
+		Expiry:   body.Expiry,
+		Metadata: body.Metadata,
+	})
+	if errors.Is(err, service.ErrInvalidInvoiceExpiry) {
+		return c.JSON(http.StatusBadRequest, responses.InvalidInvoiceExpiryError)
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to save invoice preset user_id=%v: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, preset)
+}
+
+// DeletePreset : Remove an invoice preset
+func (controller *InvoicePresetsController) DeletePreset(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	presetID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := controller.svc.DeleteInvoicePreset(c.Request().Context(), userID, presetID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// AddPresetInvoice : Create an invoice from a preset
+func (controller *InvoicePresetsController) AddPresetInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	presetID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	var body AddPresetInvoiceRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load preset invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	preset, err := controller.svc.FindInvoicePreset(c.Request().Context(), userID, presetID)
+	if err != nil {
+		c.Logger().Errorf("Failed to find invoice preset user_id=%v preset_id=%v: %v", userID, presetID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	var amount int64
+	if preset.Amount == 0 && body.Amount != nil {
+		amount, err = controller.svc.ParseInt(body.Amount)
+		if err != nil || amount < 0 {
+			return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+		}
+	}
+	c.Logger().Infof("Adding invoice from preset: user_id=%v preset_id=%v value=%v", userID, presetID, amount)
+
+	invoice, err := controller.svc.AddIncomingInvoiceFromPreset(c.Request().Context(), preset, amount)
+	if err != nil {
+		return addInvoiceErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, &AddInvoiceResponseBody{
+		RHash:          invoice.RHash,
+		PaymentRequest: invoice.PaymentRequest,
+		PayReq:         invoice.PaymentRequest,
+		Warning:        inboundLiquidityWarning(c, controller.svc, invoice.Amount),
+	})
+}
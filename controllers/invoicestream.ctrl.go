@@ -32,6 +32,10 @@ func (controller *InvoiceStreamController) StreamInvoices(c echo.Context) error
 	if err != nil {
 		return err
 	}
+	formatter, err := controller.svc.AmountFormatterFor(c.Request().Context(), userId)
+	if err != nil {
+		return err
+	}
 	invoiceChan := make(chan models.Invoice)
 	controller.svc.InvoiceSubscribers[userId] = invoiceChan
 	ctx := c.Request().Context()
@@ -65,14 +69,16 @@ SocketLoop:
 				&InvoiceEventWrapper{
 					Type: "invoice",
 					Invoice: &IncomingInvoice{
-						PaymentHash:    invoice.RHash,
-						PaymentRequest: invoice.PaymentRequest,
-						Description:    invoice.Memo,
-						PayReq:         invoice.PaymentRequest,
-						Timestamp:      invoice.CreatedAt.Unix(),
-						Type:           common.InvoiceTypeUser,
-						Amount:         invoice.Amount,
-						IsPaid:         invoice.State == common.InvoiceStateSettled,
+						PaymentHash:     invoice.RHash,
+						PaymentRequest:  invoice.PaymentRequest,
+						Description:     invoice.Memo,
+						PayReq:          invoice.PaymentRequest,
+						Timestamp:       invoice.CreatedAt.Unix(),
+						Type:            common.InvoiceTypeUser,
+						Amount:          invoice.Amount,
+						IsPaid:          invoice.State == common.InvoiceStateSettled,
+						FormattedAmount: formatter.FormatAmount(invoice.Amount),
+						FormattedTime:   formatter.FormatTime(invoice.CreatedAt),
 					}})
 			if err != nil {
 				controller.svc.Logger.Error(err)
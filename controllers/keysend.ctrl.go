@@ -1,15 +1,15 @@
 package controllers
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/getAlby/lndhub.go/lib"
 	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/getAlby/lndhub.go/lib/service"
 	"github.com/getAlby/lndhub.go/lnd"
-	"github.com/getsentry/sentry-go"
 	"github.com/labstack/echo/v4"
 	"github.com/lightningnetwork/lnd/lnrpc"
 )
@@ -24,21 +24,43 @@ func NewKeySendController(svc *service.LndhubService) *KeySendController {
 }
 
 type KeySendRequestBody struct {
-	Amount        int64             `json:"amount" validate:"required"`
-	Destination   string            `json:"destination" validate:"required"`
-	Memo          string            `json:"memo" validate:"omitempty"`
-	CustomRecords map[string]string `json:"customRecords" validate:"omitempty"`
+	Amount          int64             `json:"amount" validate:"required_without=AmountMsat,gte=0"`
+	AmountMsat      int64             `json:"amount_msat" validate:"omitempty,gt=0"` // takes precedence over amount, e.g. for boosts of a fraction of a satoshi
+	Destination     string            `json:"destination" validate:"required_without=DestinationName"`
+	DestinationName string            `json:"destination_name" validate:"omitempty"` // name of a saved keysend destination
+	Memo            string            `json:"memo" validate:"omitempty"`
+	CustomRecords   map[string]string `json:"customRecords" validate:"omitempty"`
+	// routing constraints, only accepted if the operator allows them
+	OutgoingChanId uint64 `json:"outgoing_chan_id" validate:"omitempty"`
+	LastHopPubkey  string `json:"last_hop_pubkey" validate:"omitempty,hexadecimal,len=66"`
+	// returned by an earlier attempt of an unusual payment
+	ConfirmationToken string `json:"confirmation_token" validate:"omitempty"`
 }
 
 type KeySendResponseBody struct {
-	RHash              *lib.JavaScriptBuffer `json:"payment_hash,omitempty"`
-	Amount             int64                 `json:"num_satoshis,omitempty"`
-	Description        string                `json:"description,omitempty"`
-	Destination        string                `json:"destination,omitempty"`
-	DescriptionHashStr string                `json:"description_hash,omitempty"`
-	PaymentError       string                `json:"payment_error,omitempty"`
-	PaymentPreimage    *lib.JavaScriptBuffer `json:"payment_preimage,omitempty"`
-	PaymentRoute       *service.Route        `json:"route,omitempty"`
+	RHash              *lib.JavaScriptBuffer        `json:"payment_hash,omitempty"`
+	Amount             int64                        `json:"num_satoshis,omitempty"`
+	Description        string                       `json:"description,omitempty"`
+	Destination        string                       `json:"destination,omitempty"`
+	DescriptionHashStr string                       `json:"description_hash,omitempty"`
+	PaymentError       string                       `json:"payment_error,omitempty"`
+	PaymentPreimage    *lib.JavaScriptBuffer        `json:"payment_preimage,omitempty"`
+	PaymentRoute       *service.Route               `json:"route,omitempty"`
+	ServiceFee         int64                        `json:"service_fee,omitempty"`
+	Timings            []service.PaymentStageTiming `json:"timings,omitempty"`
+}
+
+type MultiKeySendRequestBody struct {
+	Keysends []KeySendRequestBody `json:"keysends" validate:"required,min=1,max=50,dive"`
+}
+
+type KeySendResult struct {
+	Keysend *KeySendResponseBody `json:"keysend,omitempty"`
+	Error   interface{}          `json:"error,omitempty"`
+}
+
+type MultiKeySendResponseBody struct {
+	Keysends []KeySendResult `json:"keysends"`
 }
 
 // KeySend : Key send Controller
@@ -55,47 +77,181 @@ func (controller *KeySendController) KeySend(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
 	}
 
+	responseBody, errorBody, err := controller.SingleKeySend(c, &reqBody, userID)
+	if err != nil {
+		return err
+	}
+	if errorBody != nil {
+		return c.JSON(http.StatusBadRequest, errorBody)
+	}
+	return c.JSON(http.StatusOK, responseBody)
+}
+
+// MultiKeySend : Multiple key sends in one request, e.g. for podcast value splits
+// Every key send is a separate payment and the result is returned per destination
+func (controller *KeySendController) MultiKeySend(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := MultiKeySendRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load multi keysend request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid multi keysend request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	responseBody := &MultiKeySendResponseBody{Keysends: make([]KeySendResult, len(reqBody.Keysends))}
+	for i := range reqBody.Keysends {
+		keysendResponse, errorBody, err := controller.SingleKeySend(c, &reqBody.Keysends[i], userID)
+		if err != nil {
+			c.Logger().Errorf("Keysend failed destination=%s: %v", reqBody.Keysends[i].Destination, err)
+			errorBody = responses.GeneralServerError
+		}
+		responseBody.Keysends[i] = KeySendResult{Keysend: keysendResponse, Error: errorBody}
+	}
+	return c.JSON(http.StatusOK, responseBody)
+}
+
+// SingleKeySend executes one key send payment to a node pubkey, a saved destination or a lightning address
+// It returns either the response, an error body for the client or an internal error
+func (controller *KeySendController) SingleKeySend(c echo.Context, reqBody *KeySendRequestBody, userID int64) (*KeySendResponseBody, interface{}, error) {
+	destination := reqBody.Destination
+	customRecords := reqBody.CustomRecords
+	// saved destinations provide the pubkey and default custom records, records of the request take precedence
+	if reqBody.DestinationName != "" {
+		savedDestination, err := controller.svc.FindKeysendDestination(c.Request().Context(), userID, reqBody.DestinationName)
+		if err != nil {
+			c.Logger().Errorf("Keysend destination not found user_id=%v name=%s", userID, reqBody.DestinationName)
+			return nil, responses.BadArgumentsError, nil
+		}
+		destination = savedDestination.DestinationPubkeyHex
+		customRecords = map[string]string{}
+		for key, value := range savedDestination.CustomRecords {
+			customRecords[key] = value
+		}
+		for key, value := range reqBody.CustomRecords {
+			customRecords[key] = value
+		}
+	}
+
+	amount := reqBody.Amount
+	if reqBody.AmountMsat > 0 {
+		var err error
+		amount, err = lnd.MsatToSatRoundUp(reqBody.AmountMsat)
+		if err != nil {
+			return nil, responses.BadArgumentsError, nil
+		}
+	}
+
+	// invalid routing constraints and custom records are rejected before the outgoing invoice is created
+	if err := controller.svc.CheckRoutingConstraints(reqBody.OutgoingChanId, reqBody.LastHopPubkey); err != nil {
+		c.Logger().Errorf("Invalid routing constraints user_id=%v: %v", userID, err)
+		return nil, responses.BadArgumentsError, nil
+	}
+	for key := range customRecords {
+		if _, err := strconv.Atoi(key); err != nil {
+			return nil, responses.BadArgumentsError, nil
+		}
+	}
+
+	ctx, timer := service.PaymentTimerFromContext(c.Request().Context())
 	lnPayReq := &lnd.LNPayReq{
 		PayReq: &lnrpc.PayReq{
-			Destination: reqBody.Destination,
-			NumSatoshis: reqBody.Amount,
+			Destination: destination,
+			NumSatoshis: amount,
+			NumMsat:     reqBody.AmountMsat,
 			Description: reqBody.Memo,
 		},
 		Keysend: true,
 	}
+	paymentRequest := ""
+	// lightning addresses are paid with keysend if the recipient publishes a keysend pubkey, otherwise with an LNURL-pay invoice
+	// the custom records of the request are sent in both cases
+	if strings.Contains(destination, "@") {
+		keysendParams, err := controller.svc.ResolveKeysendAddress(ctx, destination)
+		if err == nil {
+			lnPayReq.PayReq.Destination = keysendParams.Pubkey
+			addressRecords := keysendParams.CustomRecords()
+			for key, value := range customRecords {
+				addressRecords[key] = value
+			}
+			customRecords = addressRecords
+		} else {
+			c.Logger().Infof("No keysend destination for lightning address %s, falling back to lnurl-pay: %v", destination, err)
+			params, err := controller.svc.ResolveLnurlPay(ctx, destination)
+			if err != nil {
+				c.Logger().Errorf("Failed to resolve lightning address %s: %v", destination, err)
+				return nil, responses.LnurlPayFailedError, nil
+			}
+			paymentRequest, lnPayReq.PayReq, err = controller.svc.FetchLnurlPayInvoice(ctx, params, amount, reqBody.Memo)
+			if errors.Is(err, service.ErrLnurlAmountOutOfRange) || errors.Is(err, lib.ErrAmountOverflow) {
+				return nil, responses.BadArgumentsError, nil
+			}
+			if err != nil {
+				c.Logger().Errorf("Failed to fetch invoice of lightning address %s: %v", destination, err)
+				return nil, responses.LnurlPayFailedError, nil
+			}
+			lnPayReq.Keysend = false
+		}
+	}
 
-	invoice, err := controller.svc.AddOutgoingInvoice(c.Request().Context(), userID, "", lnPayReq)
+	invoice, err := controller.svc.AddOutgoingInvoice(ctx, userID, paymentRequest, lnPayReq)
+	if errors.Is(err, service.ErrDestinationNotAllowed) {
+		return nil, responses.DestinationNotAllowedError, nil
+	}
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	setIdempotencyInvoice(c, controller.svc, invoice.ID)
+	if err := controller.svc.SetRoutingConstraints(invoice, reqBody.OutgoingChanId, reqBody.LastHopPubkey); err != nil {
+		return nil, nil, err
 	}
 
-	currentBalance, err := controller.svc.CurrentUserBalance(c.Request().Context(), userID)
+	currentBalance, err := controller.svc.CurrentUserBalance(ctx, userID)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	tier, err := controller.svc.UserTierSettings(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the maximum routing fee is reserved until the payment settles
+	feeReserve, err := controller.svc.FeeReserveFor(invoice)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if currentBalance < invoice.Amount {
+	total, err := lib.AddAmounts(invoice.Amount, tier.OutgoingServiceFeeFor(invoice.Amount))
+	if err == nil {
+		total, err = lib.AddAmounts(total, feeReserve)
+	}
+	if err != nil {
+		c.Logger().Errorf("Payment amount and fees overflow invoice_id=%v user_id=%v amount=%v: %v", invoice.ID, userID, invoice.Amount, err)
+		return nil, responses.BadArgumentsError, nil
+	}
+	if currentBalance < total {
 		c.Logger().Errorf("User does not have enough balance invoice_id=%v user_id=%v balance=%v amount=%v", invoice.ID, userID, currentBalance, invoice.Amount)
-		return c.JSON(http.StatusBadRequest, responses.NotEnoughBalanceError)
+		return nil, responses.NotEnoughBalanceError, nil
 	}
 
 	invoice.DestinationCustomRecords = map[uint64][]byte{}
-	for key, value := range reqBody.CustomRecords {
+	for key, value := range customRecords {
 		intKey, err := strconv.Atoi(key)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+			return nil, responses.BadArgumentsError, nil
 		}
 		invoice.DestinationCustomRecords[uint64(intKey)] = []byte(value)
 	}
-	sendPaymentResponse, err := controller.svc.PayInvoice(c.Request().Context(), invoice)
+	if err := controller.svc.CheckPaymentConfirmation(ctx, invoice, reqBody.ConfirmationToken); err != nil {
+		return nil, paymentErrorBody(c, err), nil
+	}
+	timer.Mark(service.PaymentStageBalanceCheck)
+	sendPaymentResponse, err := controller.svc.PayInvoice(ctx, invoice)
 	if err != nil {
-		c.Logger().Errorf("Payment failed: %v", err)
-		sentry.CaptureException(err)
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error":   true,
-			"code":    10,
-			"message": fmt.Sprintf("Payment failed. Does the receiver have enough inbound capacity? (%v)", err),
-		})
+		return nil, paymentErrorBody(c, err), nil
 	}
 
 	responseBody := &KeySendResponseBody{}
@@ -107,6 +263,10 @@ func (controller *KeySendController) KeySend(c echo.Context) error {
 	responseBody.PaymentError = sendPaymentResponse.PaymentError
 	responseBody.PaymentPreimage = &lib.JavaScriptBuffer{Data: sendPaymentResponse.PaymentPreimage}
 	responseBody.PaymentRoute = sendPaymentResponse.PaymentRoute
+	responseBody.ServiceFee = invoice.ServiceFee
+	if controller.svc.Config.DebugPaymentTimings {
+		responseBody.Timings = timer.Timings()
+	}
 
-	return c.JSON(http.StatusOK, responseBody)
+	return responseBody, nil, nil
 }
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// KeysendDestinationsController : Keysend address book controller struct
+type KeysendDestinationsController struct {
+	svc *service.LndhubService
+}
+
+func NewKeysendDestinationsController(svc *service.LndhubService) *KeysendDestinationsController {
+	return &KeysendDestinationsController{svc: svc}
+}
+
+type SaveKeysendDestinationRequestBody struct {
+	Name          string            `json:"name" validate:"required,max=100"`
+	Destination   string            `json:"destination" validate:"required,hexadecimal,len=66"`
+	CustomRecords map[string]string `json:"customRecords" validate:"omitempty"`
+}
+
+type KeysendDestinationResponseBody struct {
+	Name          string            `json:"name"`
+	Destination   string            `json:"destination"`
+	CustomRecords map[string]string `json:"customRecords,omitempty"`
+	PaymentCount  int64             `json:"payment_count"`
+	TotalAmount   int64             `json:"total_amount"`
+	TotalFees     int64             `json:"total_fees"`
+}
+
+// GetDestinations : List the user's saved keysend destinations including spend stats
+func (controller *KeysendDestinationsController) GetDestinations(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	destinations, err := controller.svc.KeysendDestinationsFor(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	stats, err := controller.svc.KeysendStatsFor(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	response := make([]KeysendDestinationResponseBody, len(destinations))
+	for i, destination := range destinations {
+		destinationStats := stats[destination.DestinationPubkeyHex]
+		response[i] = KeysendDestinationResponseBody{
+			Name:          destination.Name,
+			Destination:   destination.DestinationPubkeyHex,
+			CustomRecords: destination.CustomRecords,
+			PaymentCount:  destinationStats.PaymentCount,
+			TotalAmount:   destinationStats.TotalAmount,
+			TotalFees:     destinationStats.TotalFees,
+		}
+	}
+	return c.JSON(http.StatusOK, &response)
+}
+
+// SaveDestination : Save a named keysend destination
+func (controller *KeysendDestinationsController) SaveDestination(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body SaveKeysendDestinationRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load keysend destination request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid keysend destination request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	destination, err := controller.svc.SaveKeysendDestination(c.Request().Context(), userID, body.Name, body.Destination, body.CustomRecords)
+	if err != nil {
+		c.Logger().Errorf("Failed to save keysend destination user_id=%v: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, &KeysendDestinationResponseBody{
+		Name:          destination.Name,
+		Destination:   destination.DestinationPubkeyHex,
+		CustomRecords: destination.CustomRecords,
+	})
+}
+
+// DeleteDestination : Remove a saved keysend destination
+func (controller *KeysendDestinationsController) DeleteDestination(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	if err := controller.svc.DeleteKeysendDestination(c.Request().Context(), userID, c.Param("name")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// MemoKeyController : Memo encryption key controller struct
+type MemoKeyController struct {
+	svc *service.LndhubService
+}
+
+func NewMemoKeyController(svc *service.LndhubService) *MemoKeyController {
+	return &MemoKeyController{svc: svc}
+}
+
+type SetMemoKeyRequestBody struct {
+	PublicKey string `json:"public_key" validate:"required,base64"`
+}
+
+type MemoKeyResponseBody struct {
+	PublicKey string `json:"public_key,omitempty"`
+	KeyHint   string `json:"key_hint,omitempty"`
+}
+
+// GetMemoKey : Get the user's own memo public key, empty if none is set
+func (controller *MemoKeyController) GetMemoKey(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	user, err := controller.svc.FindUser(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	responseBody := &MemoKeyResponseBody{}
+	if user.MemoPublicKey != "" {
+		responseBody.PublicKey = user.MemoPublicKey
+		responseBody.KeyHint = service.MemoKeyHint(user.MemoPublicKey)
+	}
+	return c.JSON(http.StatusOK, responseBody)
+}
+
+// SetMemoKey : Set the public key other users encrypt memos to
+func (controller *MemoKeyController) SetMemoKey(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body SetMemoKeyRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load memo key request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid memo key request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	err := controller.svc.SetMemoPublicKey(c.Request().Context(), userID, body.PublicKey)
+	if errors.Is(err, service.ErrInvalidMemoPublicKey) {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &MemoKeyResponseBody{PublicKey: body.PublicKey, KeyHint: service.MemoKeyHint(body.PublicKey)})
+}
+
+// GetRecipientMemoKey : Get the memo public key of the user who created the invoice in ?invoice=
+// Only invoices of users of this hub have a recipient key
+func (controller *MemoKeyController) GetRecipientMemoKey(c echo.Context) error {
+	paymentRequest := c.QueryParam("invoice")
+	if paymentRequest == "" {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	publicKey, err := controller.svc.RecipientMemoPublicKey(c.Request().Context(), paymentRequest)
+	switch {
+	case errors.Is(err, service.ErrEncryptedMemoNotInternal):
+		return c.JSON(http.StatusBadRequest, responses.EncryptedMemoNotInternalError)
+	case errors.Is(err, service.ErrNoMemoPublicKey):
+		c.Logger().Errorf("Recipient has no memo public key")
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	case err != nil:
+		return err
+	}
+	return c.JSON(http.StatusOK, &MemoKeyResponseBody{PublicKey: publicKey, KeyHint: service.MemoKeyHint(publicKey)})
+}
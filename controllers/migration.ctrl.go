@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getsentry/sentry-go"
+	"github.com/labstack/echo/v4"
+)
+
+// MigrationController : Balance migration between hubs controller struct
+type MigrationController struct {
+	svc *service.LndhubService
+}
+
+func NewMigrationController(svc *service.LndhubService) *MigrationController {
+	return &MigrationController{svc: svc}
+}
+
+type ExportBalanceResponseBody struct {
+	Claim     string `json:"claim"`
+	Amount    int64  `json:"amount"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type RedeemBalanceClaimRequestBody struct {
+	Claim   string `json:"claim" validate:"required"`
+	Invoice string `json:"invoice" validate:"required"`
+}
+
+type RedeemBalanceClaimResponseBody struct {
+	PaymentHash     *lib.JavaScriptBuffer `json:"payment_hash,omitempty"`
+	PaymentPreimage *lib.JavaScriptBuffer `json:"payment_preimage,omitempty"`
+	Amount          int64                 `json:"num_satoshis"`
+}
+
+type ImportBalanceRequestBody struct {
+	Claim  string `json:"claim" validate:"required"`
+	HubUrl string `json:"hub_url" validate:"required,url"`
+}
+
+type ImportBalanceResponseBody struct {
+	PaymentHash string `json:"payment_hash"`
+	Amount      int64  `json:"amount"`
+}
+
+// ExportBalance : Create a one-time claim on the user's balance for another hub
+func (controller *MigrationController) ExportBalance(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	claim, token, err := controller.svc.ExportBalance(c.Request().Context(), userID)
+	if errors.Is(err, service.ErrBalanceTooLow) {
+		return c.JSON(http.StatusBadRequest, responses.NotEnoughBalanceError)
+	}
+	if errors.Is(err, service.ErrAccountFrozen) {
+		return c.JSON(http.StatusBadRequest, responses.AccountFrozenError)
+	}
+	if err != nil {
+		return err
+	}
+	c.Logger().Infof("Exported balance claim user_id=%v claim_id=%v amount=%v", userID, claim.ID, claim.Amount)
+	return c.JSON(http.StatusOK, &ExportBalanceResponseBody{
+		Claim:     token,
+		Amount:    claim.Amount,
+		ExpiresAt: claim.ExpiresAt.Unix(),
+	})
+}
+
+// RedeemClaim : Pay the destination hub's invoice for a claim issued by this hub
+func (controller *MigrationController) RedeemClaim(c echo.Context) error {
+	var body RedeemBalanceClaimRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load redeem claim request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid redeem claim request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	decodedPaymentRequest, err := controller.svc.DecodePaymentRequest(c.Request().Context(), body.Invoice)
+	if err != nil {
+		c.Logger().Errorf("Invalid payment request: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	paymentResponse, err := controller.svc.RedeemBalanceClaim(c.Request().Context(), body.Claim, body.Invoice, decodedPaymentRequest)
+	if errors.Is(err, service.ErrInvalidBalanceClaim) {
+		return c.JSON(http.StatusBadRequest, responses.InvalidBalanceClaimError)
+	}
+	if err != nil {
+		return paymentErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, &RedeemBalanceClaimResponseBody{
+		PaymentHash:     &lib.JavaScriptBuffer{Data: paymentResponse.PaymentHash},
+		PaymentPreimage: &lib.JavaScriptBuffer{Data: paymentResponse.PaymentPreimage},
+		Amount:          decodedPaymentRequest.NumSatoshis,
+	})
+}
+
+// ImportBalance : Redeem a claim issued by another hub into the user's balance
+func (controller *MigrationController) ImportBalance(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body ImportBalanceRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load import balance request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid import balance request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	invoice, err := controller.svc.ImportBalance(c.Request().Context(), userID, body.HubUrl, body.Claim)
+	switch {
+	case errors.Is(err, service.ErrInvalidBalanceClaim):
+		return c.JSON(http.StatusBadRequest, responses.InvalidBalanceClaimError)
+	case errors.Is(err, service.ErrInvalidHubUrl):
+		c.Logger().Errorf("Invalid hub url user_id=%v hub_url=%s", userID, body.HubUrl)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	case errors.Is(err, service.ErrBalanceImportFailed):
+		c.Logger().Errorf("Balance import failed user_id=%v hub_url=%s: %v", userID, body.HubUrl, err)
+		return c.JSON(http.StatusBadRequest, responses.BalanceImportFailedError)
+	case err != nil:
+		c.Logger().Errorf("Error importing balance: %v", err)
+		sentry.CaptureException(err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, &ImportBalanceResponseBody{
+		PaymentHash: invoice.RHash,
+		Amount:      invoice.Amount,
+	})
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// NotificationsController : Notifications controller struct
+type NotificationsController struct {
+	svc *service.LndhubService
+}
+
+func NewNotificationsController(svc *service.LndhubService) *NotificationsController {
+	return &NotificationsController{svc: svc}
+}
+
+// GetNotifications : List the user's latest notifications, with ?unread=true only the unread ones
+func (controller *NotificationsController) GetNotifications(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	notifications, err := controller.svc.Notifications(c.Request().Context(), userID, c.QueryParam("unread") == "true")
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &notifications)
+}
+
+// MarkNotificationsRead : Mark all notifications of the user as read
+func (controller *NotificationsController) MarkNotificationsRead(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	if err := controller.svc.MarkNotificationsRead(c.Request().Context(), userID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
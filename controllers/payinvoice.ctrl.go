@@ -1,15 +1,19 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/getAlby/lndhub.go/db/models"
 	"github.com/getAlby/lndhub.go/lib"
 	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/getAlby/lndhub.go/lib/service"
 	"github.com/getAlby/lndhub.go/lnd"
 	"github.com/getsentry/sentry-go"
 	"github.com/labstack/echo/v4"
+	"github.com/lightningnetwork/lnd/lnrpc"
 )
 
 // PayInvoiceController : Pay invoice controller struct
@@ -22,19 +26,57 @@ func NewPayInvoiceController(svc *service.LndhubService) *PayInvoiceController {
 }
 
 type PayInvoiceRequestBody struct {
-	Invoice string      `json:"invoice" validate:"required"`
-	Amount  interface{} `json:"amount" validate:"omitempty"`
+	Invoice       string      `json:"invoice" validate:"required"`
+	Amount        interface{} `json:"amount" validate:"omitempty"`
+	EncryptedMemo string      `json:"encrypted_memo" validate:"omitempty,base64"`
+	MemoKeyHint   string      `json:"memo_key_hint" validate:"required_with=EncryptedMemo,omitempty,hexadecimal"`
+	// routing constraints, only accepted if the operator allows them
+	OutgoingChanId uint64 `json:"outgoing_chan_id" validate:"omitempty"`
+	LastHopPubkey  string `json:"last_hop_pubkey" validate:"omitempty,hexadecimal,len=66"`
+	// returned by an earlier attempt of an unusual payment
+	ConfirmationToken string `json:"confirmation_token" validate:"omitempty"`
 }
 type PayInvoiceResponseBody struct {
-	RHash              *lib.JavaScriptBuffer `json:"payment_hash,omitempty"`
-	PaymentRequest     string                `json:"payment_request,omitempty"`
-	PayReq             string                `json:"pay_req,omitempty"`
-	Amount             int64                 `json:"num_satoshis,omitempty"`
-	Description        string                `json:"description,omitempty"`
-	DescriptionHashStr string                `json:"description_hash,omitempty"`
-	PaymentError       string                `json:"payment_error,omitempty"`
-	PaymentPreimage    *lib.JavaScriptBuffer `json:"payment_preimage,omitempty"`
-	PaymentRoute       *service.Route        `json:"route,omitempty"`
+	RHash              *lib.JavaScriptBuffer        `json:"payment_hash,omitempty"`
+	PaymentRequest     string                       `json:"payment_request,omitempty"`
+	PayReq             string                       `json:"pay_req,omitempty"`
+	Amount             int64                        `json:"num_satoshis,omitempty"`
+	Description        string                       `json:"description,omitempty"`
+	DescriptionHashStr string                       `json:"description_hash,omitempty"`
+	PaymentError       string                       `json:"payment_error,omitempty"`
+	PaymentPreimage    *lib.JavaScriptBuffer        `json:"payment_preimage,omitempty"`
+	PaymentRoute       *service.Route               `json:"route,omitempty"`
+	ServiceFee         int64                        `json:"service_fee,omitempty"`
+	Timings            []service.PaymentStageTiming `json:"timings,omitempty"`
+}
+
+type PayLnurlRequestBody struct {
+	Destination string `json:"destination" validate:"required"`
+	Amount      int64  `json:"amount" validate:"required,gt=0"`
+	Comment     string `json:"comment" validate:"omitempty,max=640"`
+}
+
+type TransferRequestBody struct {
+	Login  string `json:"login" validate:"required"`
+	Amount int64  `json:"amount" validate:"required,gt=0"`
+	Memo   string `json:"memo" validate:"omitempty"`
+	// returned by an earlier attempt of an unusual payment
+	ConfirmationToken string `json:"confirmation_token" validate:"omitempty"`
+}
+
+type BulkPayInvoiceRequestBody struct {
+	Invoices []string `json:"invoices" validate:"required,min=1,max=50,dive,required"`
+	// confirmation tokens of unusual payments by the index of their invoice, returned by an earlier attempt
+	ConfirmationTokens []string `json:"confirmation_tokens" validate:"omitempty,max=50"`
+}
+
+type PayInvoiceResult struct {
+	Payment *PayInvoiceResponseBody `json:"payment,omitempty"`
+	Error   interface{}             `json:"error,omitempty"`
+}
+
+type BulkPayInvoiceResponseBody struct {
+	Payments []PayInvoiceResult `json:"payments"`
 }
 
 // PayInvoice : Pay invoice Controller
@@ -51,13 +93,17 @@ func (controller *PayInvoiceController) PayInvoice(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
 	}
 
+	ctx, timer := service.PaymentTimerFromContext(c.Request().Context())
+	c.SetRequest(c.Request().WithContext(ctx))
+
 	paymentRequest := reqBody.Invoice
-	decodedPaymentRequest, err := controller.svc.DecodePaymentRequest(c.Request().Context(), paymentRequest)
+	decodedPaymentRequest, err := controller.svc.DecodePaymentRequest(ctx, paymentRequest)
 	if err != nil {
 		c.Logger().Errorf("Invalid payment request: %v", err)
 		sentry.CaptureException(err)
 		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
 	}
+	timer.Mark(service.PaymentStageDecode)
 	// TODO: zero amount invoices
 	/*
 		_, err = controller.svc.ParseInt(reqBody.Amount)
@@ -70,47 +116,378 @@ func (controller *PayInvoiceController) PayInvoice(c echo.Context) error {
 		}
 	*/
 
-	lnPayReq := &lnd.LNPayReq{
-		PayReq:  decodedPaymentRequest,
-		Keysend: false,
+	responseBody, errorBody, err := controller.SinglePayInvoice(c, paymentRequest, decodedPaymentRequest, userID, &reqBody)
+	if err != nil {
+		return err
+	}
+	if errorBody != nil {
+		return c.JSON(http.StatusBadRequest, errorBody)
+	}
+	return c.JSON(http.StatusOK, responseBody)
+}
+
+// BulkPayInvoice : Pay multiple invoices in one request, e.g. for payout batches
+// The total amount is checked against the balance upfront, then the invoices are paid one after another
+// Unusual payments fail with a confirmation token, the batch is confirmed by sending it again with the tokens at the index of their invoices
+func (controller *PayInvoiceController) BulkPayInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := BulkPayInvoiceRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load bulk payinvoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid bulk payinvoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if len(reqBody.ConfirmationTokens) > len(reqBody.Invoices) {
+		c.Logger().Errorf("Invalid bulk payinvoice request body: more confirmation tokens than invoices")
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
 	}
 
-	invoice, err := controller.svc.AddOutgoingInvoice(c.Request().Context(), userID, paymentRequest, lnPayReq)
+	tier, err := controller.svc.UserTierSettings(c.Request().Context(), userID)
 	if err != nil {
 		return err
 	}
+	decodedPaymentRequests := make([]*lnrpc.PayReq, len(reqBody.Invoices))
+	var totalAmount int64
+	for i, paymentRequest := range reqBody.Invoices {
+		decodedPaymentRequest, err := controller.svc.DecodePaymentRequest(c.Request().Context(), paymentRequest)
+		if err != nil {
+			c.Logger().Errorf("Invalid payment request: %v", err)
+			return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+		}
+		// the maximum routing fee of every payment is reserved until it settles
+		feeReserve, err := controller.svc.FeeReserveFor(&models.Invoice{Amount: decodedPaymentRequest.NumSatoshis, DestinationPubkeyHex: decodedPaymentRequest.Destination})
+		if err != nil {
+			return err
+		}
+		amount, err := lib.AddAmounts(decodedPaymentRequest.NumSatoshis, tier.OutgoingServiceFeeFor(decodedPaymentRequest.NumSatoshis))
+		if err == nil {
+			amount, err = lib.AddAmounts(amount, feeReserve)
+		}
+		if err == nil {
+			totalAmount, err = lib.AddAmounts(totalAmount, amount)
+		}
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+		}
+		decodedPaymentRequests[i] = decodedPaymentRequest
+	}
 
 	currentBalance, err := controller.svc.CurrentUserBalance(c.Request().Context(), userID)
 	if err != nil {
 		return err
 	}
+	if currentBalance < totalAmount {
+		c.Logger().Errorf("User does not have enough balance for bulk payment user_id=%v balance=%v amount=%v", userID, currentBalance, totalAmount)
+		return c.JSON(http.StatusBadRequest, responses.NotEnoughBalanceError)
+	}
+
+	// Payments are executed sequentially: the balance check of the DB locks the user's account
+	// and does not wait for concurrent transactions of the same account
+	responseBody := &BulkPayInvoiceResponseBody{Payments: make([]PayInvoiceResult, len(reqBody.Invoices))}
+	for i, paymentRequest := range reqBody.Invoices {
+		itemBody := &PayInvoiceRequestBody{Invoice: paymentRequest}
+		if i < len(reqBody.ConfirmationTokens) {
+			itemBody.ConfirmationToken = reqBody.ConfirmationTokens[i]
+		}
+		paymentResponse, errorBody, err := controller.SinglePayInvoice(c, paymentRequest, decodedPaymentRequests[i], userID, itemBody)
+		if err != nil {
+			c.Logger().Errorf("Bulk payment failed payment_request=%s: %v", paymentRequest, err)
+			errorBody = responses.GeneralServerError
+		}
+		responseBody.Payments[i] = PayInvoiceResult{Payment: paymentResponse, Error: errorBody}
+	}
+	return c.JSON(http.StatusOK, responseBody)
+}
+
+// PayLnurl : Pay a lightning address (user@domain) or LNURL-pay link
+// The pay request is resolved, an invoice for the amount is fetched from the recipient and paid like any other invoice
+func (controller *PayInvoiceController) PayLnurl(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := PayLnurlRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load lnurl pay request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid lnurl pay request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	ctx, timer := service.PaymentTimerFromContext(c.Request().Context())
+	c.SetRequest(c.Request().WithContext(ctx))
+
+	params, err := controller.svc.ResolveLnurlPay(ctx, reqBody.Destination)
+	if errors.Is(err, service.ErrInvalidLnurl) {
+		c.Logger().Errorf("Invalid lnurl destination=%s: %v", reqBody.Destination, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to resolve lnurl destination=%s: %v", reqBody.Destination, err)
+		return c.JSON(http.StatusBadRequest, responses.LnurlPayFailedError)
+	}
+	paymentRequest, decodedPaymentRequest, err := controller.svc.FetchLnurlPayInvoice(ctx, params, reqBody.Amount, reqBody.Comment)
+	if errors.Is(err, service.ErrLnurlAmountOutOfRange) || errors.Is(err, lib.ErrAmountOverflow) {
+		c.Logger().Errorf("Lnurl amount out of range destination=%s amount=%v", reqBody.Destination, reqBody.Amount)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to fetch lnurl invoice destination=%s: %v", reqBody.Destination, err)
+		return c.JSON(http.StatusBadRequest, responses.LnurlPayFailedError)
+	}
+	timer.Mark(service.PaymentStageDecode)
+
+	responseBody, errorBody, err := controller.SinglePayInvoice(c, paymentRequest, decodedPaymentRequest, userID, nil)
+	if err != nil {
+		return err
+	}
+	if errorBody != nil {
+		return c.JSON(http.StatusBadRequest, errorBody)
+	}
+	return c.JSON(http.StatusOK, responseBody)
+}
+
+// Transfer : Transfer sats to another user of this hub by login, settled in the ledger without a bolt11 invoice
+func (controller *PayInvoiceController) Transfer(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := TransferRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load transfer request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid transfer request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	invoice, err := controller.svc.AddTransferInvoice(c.Request().Context(), userID, reqBody.Login, reqBody.Amount, reqBody.Memo)
+	if errors.Is(err, service.ErrTransferRecipientNotFound) {
+		c.Logger().Errorf("Transfer recipient not found user_id=%v login=%s", userID, reqBody.Login)
+		return c.JSON(http.StatusBadRequest, responses.TransferRecipientNotFoundError)
+	}
+	if errors.Is(err, service.ErrSelfPayment) || errors.Is(err, service.ErrAmountOutOfRange) {
+		return paymentErrorResponse(c, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	responseBody, errorBody, err := controller.payOutgoingInvoice(c, invoice, reqBody.ConfirmationToken, controller.svc.PayInvoice)
+	if err != nil {
+		return err
+	}
+	if errorBody != nil {
+		return c.JSON(http.StatusBadRequest, errorBody)
+	}
+	return c.JSON(http.StatusOK, responseBody)
+}
+
+type RetryPaymentRequestBody struct {
+	// returned by an earlier attempt of an unusual payment
+	ConfirmationToken string `json:"confirmation_token" validate:"omitempty"`
+}
+
+// RetryPayment : Pay the stored invoice of a failed payment again
+// The balance, limits and the expiry of the invoice are checked again like for a new payment
+func (controller *PayInvoiceController) RetryPayment(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := RetryPaymentRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load payment retry request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	ctx, _ := service.PaymentTimerFromContext(c.Request().Context())
+	c.SetRequest(c.Request().WithContext(ctx))
+
+	invoice, err := controller.svc.FailedPaymentFor(ctx, userID, c.Param("payment_hash"))
+	if err != nil {
+		return paymentErrorResponse(c, err)
+	}
+	responseBody, errorBody, err := controller.payOutgoingInvoice(c, invoice, reqBody.ConfirmationToken, controller.svc.RetryFailedPayment)
+	if err != nil {
+		return err
+	}
+	if errorBody != nil {
+		return c.JSON(http.StatusBadRequest, errorBody)
+	}
+	return c.JSON(http.StatusOK, responseBody)
+}
+
+// SinglePayInvoice pays one decoded payment request
+// Encrypted memos of the request body are attached to payments to other users of the hub
+// It returns either the response, an error body for the client or an internal error
+func (controller *PayInvoiceController) SinglePayInvoice(c echo.Context, paymentRequest string, decodedPaymentRequest *lnrpc.PayReq, userID int64, reqBody *PayInvoiceRequestBody) (*PayInvoiceResponseBody, interface{}, error) {
+	lnPayReq := &lnd.LNPayReq{
+		PayReq:  decodedPaymentRequest,
+		Keysend: false,
+	}
+	ctx, _ := service.PaymentTimerFromContext(c.Request().Context())
+	c.SetRequest(c.Request().WithContext(ctx))
 
-	if currentBalance < invoice.Amount {
+	// invalid routing constraints are rejected before the outgoing invoice is created
+	if reqBody != nil {
+		if err := controller.svc.CheckRoutingConstraints(reqBody.OutgoingChanId, reqBody.LastHopPubkey); err != nil {
+			c.Logger().Errorf("Invalid routing constraints user_id=%v: %v", userID, err)
+			return nil, responses.BadArgumentsError, nil
+		}
+	}
+	invoice, err := controller.svc.AddOutgoingInvoice(ctx, userID, paymentRequest, lnPayReq)
+	if errors.Is(err, service.ErrDestinationNotAllowed) {
+		return nil, responses.DestinationNotAllowedError, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if reqBody != nil {
+		if err := controller.svc.SetRoutingConstraints(invoice, reqBody.OutgoingChanId, reqBody.LastHopPubkey); err != nil {
+			return nil, nil, err
+		}
+	}
+	if reqBody != nil && reqBody.EncryptedMemo != "" {
+		err = controller.svc.AttachEncryptedMemo(ctx, invoice, reqBody.EncryptedMemo, reqBody.MemoKeyHint)
+		if errors.Is(err, service.ErrEncryptedMemoNotInternal) {
+			return nil, responses.EncryptedMemoNotInternalError, nil
+		}
+		if err != nil {
+			c.Logger().Errorf("Failed to attach encrypted memo invoice_id=%v: %v", invoice.ID, err)
+			return nil, responses.BadArgumentsError, nil
+		}
+	}
+
+	confirmationToken := ""
+	if reqBody != nil {
+		confirmationToken = reqBody.ConfirmationToken
+	}
+	return controller.payOutgoingInvoice(c, invoice, confirmationToken, controller.svc.PayInvoice)
+}
+
+// payOutgoingInvoice checks the user's balance and the payment confirmation of a stored outgoing invoice before it is paid with pay
+func (controller *PayInvoiceController) payOutgoingInvoice(c echo.Context, invoice *models.Invoice, confirmationToken string, pay func(context.Context, *models.Invoice) (*service.SendPaymentResponse, error)) (*PayInvoiceResponseBody, interface{}, error) {
+	userID := invoice.UserID
+	ctx, timer := service.PaymentTimerFromContext(c.Request().Context())
+	setIdempotencyInvoice(c, controller.svc, invoice.ID)
+
+	currentBalance, err := controller.svc.CurrentUserBalance(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	tier, err := controller.svc.UserTierSettings(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the maximum routing fee is reserved until the payment settles
+	feeReserve, err := controller.svc.FeeReserveFor(invoice)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	total, err := lib.AddAmounts(invoice.Amount, tier.OutgoingServiceFeeFor(invoice.Amount))
+	if err == nil {
+		total, err = lib.AddAmounts(total, feeReserve)
+	}
+	if err != nil {
+		c.Logger().Errorf("Payment amount and fees overflow invoice_id=%v user_id=%v amount=%v: %v", invoice.ID, userID, invoice.Amount, err)
+		return nil, responses.BadArgumentsError, nil
+	}
+	if currentBalance < total {
 		c.Logger().Errorf("User does not have enough balance invoice_id=%v user_id=%v balance=%v amount=%v", invoice.ID, userID, currentBalance, invoice.Amount)
 
-		return c.JSON(http.StatusBadRequest, responses.NotEnoughBalanceError)
+		return nil, responses.NotEnoughBalanceError, nil
+	}
+	if err := controller.svc.CheckPaymentConfirmation(ctx, invoice, confirmationToken); err != nil {
+		return nil, paymentErrorBody(c, err), nil
 	}
+	timer.Mark(service.PaymentStageBalanceCheck)
 
-	sendPaymentResponse, err := controller.svc.PayInvoice(c.Request().Context(), invoice)
+	sendPaymentResponse, err := pay(ctx, invoice)
 	if err != nil {
-		c.Logger().Errorf("Payment failed: %v", err)
-		sentry.CaptureException(err)
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error":   true,
-			"code":    10,
-			"message": fmt.Sprintf("Payment failed. Does the receiver have enough inbound capacity? (%v)", err),
-		})
+		return nil, paymentErrorBody(c, err), nil
 	}
 	responseBody := &PayInvoiceResponseBody{}
 	responseBody.RHash = &lib.JavaScriptBuffer{Data: sendPaymentResponse.PaymentHash}
-	responseBody.PaymentRequest = paymentRequest
-	responseBody.PayReq = paymentRequest
+	responseBody.PaymentRequest = invoice.PaymentRequest
+	responseBody.PayReq = invoice.PaymentRequest
 	responseBody.Amount = invoice.Amount
 	responseBody.Description = invoice.Memo
 	responseBody.DescriptionHashStr = invoice.DescriptionHash
 	responseBody.PaymentError = sendPaymentResponse.PaymentError
 	responseBody.PaymentPreimage = &lib.JavaScriptBuffer{Data: sendPaymentResponse.PaymentPreimage}
 	responseBody.PaymentRoute = sendPaymentResponse.PaymentRoute
+	responseBody.ServiceFee = invoice.ServiceFee
+	if controller.svc.Config.DebugPaymentTimings {
+		responseBody.Timings = timer.Timings()
+	}
 
-	return c.JSON(http.StatusOK, responseBody)
+	return responseBody, nil, nil
+}
+
+// paymentErrorResponse maps errors of the payment flow to the error response returned to the client
+func paymentErrorResponse(c echo.Context, err error) error {
+	return c.JSON(http.StatusBadRequest, paymentErrorBody(c, err))
+}
+
+func paymentErrorBody(c echo.Context, err error) interface{} {
+	var sendLimitError *service.SendLimitExceededError
+	var confirmationRequiredError *service.PaymentConfirmationRequiredError
+	switch {
+	case errors.Is(err, service.ErrDestinationNotAllowed):
+		return responses.DestinationNotAllowedError
+	case errors.Is(err, service.ErrMaxSendAmountExceeded):
+		return responses.MaxSendAmountExceededError
+	case errors.Is(err, service.ErrAmountOutOfRange):
+		return responses.AmountOutOfRangeError
+	case errors.Is(err, service.ErrSelfPayment):
+		return responses.SelfPaymentError
+	case errors.Is(err, service.ErrInvoiceExpired):
+		return responses.InvoiceExpiredError
+	case errors.Is(err, service.ErrOutboundLiquidityLow):
+		return responses.OutboundLiquidityLowError
+	case errors.Is(err, service.ErrAccountFrozen):
+		return responses.AccountFrozenError
+	case errors.Is(err, service.ErrInvalidPaymentConfirmation):
+		return responses.InvalidPaymentConfirmationError
+	case errors.Is(err, service.ErrPaymentNotRetryable):
+		return responses.PaymentNotRetryableError
+	case errors.Is(err, service.ErrPaymentInProgress):
+		return responses.PaymentInProgressError
+	case errors.Is(err, service.ErrNotEnoughBalance):
+		return responses.NotEnoughBalanceError
+	case errors.Is(err, service.ErrSpendLimitExceeded):
+		return responses.SpendLimitExceededError
+	case errors.As(err, &confirmationRequiredError):
+		return echo.Map{
+			"error":              true,
+			"code":               responses.PaymentConfirmationRequiredError.Code,
+			"message":            responses.PaymentConfirmationRequiredError.Message,
+			"confirmation_token": confirmationRequiredError.Token,
+			"expires_at":         confirmationRequiredError.ExpiresAt.Unix(),
+			"average_amount":     confirmationRequiredError.AverageAmount,
+		}
+	case errors.As(err, &sendLimitError):
+		return echo.Map{
+			"error":    true,
+			"code":     responses.SendLimitExceededError.Code,
+			"message":  responses.SendLimitExceededError.Message,
+			"period":   sendLimitError.Period,
+			"limit":    sendLimitError.Limit,
+			"reset_at": sendLimitError.ResetAt.Unix(),
+		}
+	}
+	c.Logger().Errorf("Payment failed: %v", err)
+	sentry.CaptureException(err)
+	reason := service.ClassifyPaymentFailure(err.Error())
+	return echo.Map{
+		"error":            true,
+		"code":             10,
+		"message":          fmt.Sprintf("Payment failed. Does the receiver have enough inbound capacity? (%v)", err),
+		"reason":           reason,
+		"suggested_action": service.PaymentFailureAction(reason),
+	}
 }
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// PersonalDataController : Personal data export and account deletion controller struct
+type PersonalDataController struct {
+	svc *service.LndhubService
+}
+
+func NewPersonalDataController(svc *service.LndhubService) *PersonalDataController {
+	return &PersonalDataController{svc: svc}
+}
+
+// ExportPersonalData : Download everything stored about the user as one JSON document
+func (controller *PersonalDataController) ExportPersonalData(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	export, err := controller.svc.ExportPersonalData(service.WithReadReplica(c.Request().Context()), userID)
+	if err != nil {
+		return err
+	}
+	c.Logger().Infof("Exported personal data user_id=%v", userID)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"lndhub-personal-data-%s.json\"", export.GeneratedAt.Format("20060102T150405Z")))
+	return c.JSON(http.StatusOK, export)
+}
+
+type DeleteAccountRequestBody struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// DeleteAccount : Delete the account of the user, the balance must be withdrawn first
+func (controller *PersonalDataController) DeleteAccount(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body DeleteAccountRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load delete account request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid delete account request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	err := controller.svc.DeleteUser(c.Request().Context(), userID, body.Password)
+	switch {
+	case errors.Is(err, service.ErrWrongPassword):
+		return c.JSON(http.StatusUnauthorized, responses.BadAuthError)
+	case errors.Is(err, service.ErrAccountNotEmpty):
+		return c.JSON(http.StatusBadRequest, responses.AccountNotEmptyError)
+	case err != nil:
+		return err
+	}
+	c.Logger().Infof("Deleted account user_id=%v", userID)
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// PreferencesController : Display preferences controller struct
+type PreferencesController struct {
+	svc *service.LndhubService
+}
+
+func NewPreferencesController(svc *service.LndhubService) *PreferencesController {
+	return &PreferencesController{svc: svc}
+}
+
+type SetPreferencesRequestBody struct {
+	DisplayUnit string `json:"display_unit" validate:"required,oneof=sats btc fiat"`
+	Currency    string `json:"currency" validate:"omitempty,len=3"`
+	Timezone    string `json:"timezone" validate:"omitempty"`
+}
+
+// GetPreferences : Get the user's display preferences
+func (controller *PreferencesController) GetPreferences(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	preferences, err := controller.svc.PreferencesFor(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, preferences)
+}
+
+// SetPreferences : Set the unit, currency and timezone used for formatted amounts and times
+func (controller *PreferencesController) SetPreferences(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body SetPreferencesRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load preferences request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid preferences request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	preferences := &service.UserPreferences{DisplayUnit: body.DisplayUnit, Currency: body.Currency, Timezone: body.Timezone}
+	if preferences.Timezone == "" {
+		preferences.Timezone = "UTC"
+	}
+	err := controller.svc.SetPreferences(c.Request().Context(), userID, preferences)
+	if errors.Is(err, service.ErrInvalidPreferences) {
+		c.Logger().Errorf("Invalid preferences user_id=%v: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, preferences)
+}
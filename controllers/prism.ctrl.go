@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// PrismController : Prism payment splitting controller struct
+type PrismController struct {
+	svc *service.LndhubService
+}
+
+func NewPrismController(svc *service.LndhubService) *PrismController {
+	return &PrismController{svc: svc}
+}
+
+type PrismSplitRequestBody struct {
+	Destination string `json:"destination" validate:"required"`     // login of a user of this hub or a lightning address
+	Percentage  int64  `json:"percentage" validate:"gte=1,lte=100"` // share of every incoming payment
+}
+
+type SetPrismSplitsRequestBody struct {
+	Splits []PrismSplitRequestBody `json:"splits" validate:"dive"`
+}
+
+// GetPrismSplits : List the splits applied to the user's incoming payments
+func (controller *PrismController) GetPrismSplits(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	splits, err := controller.svc.PrismSplitsFor(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &splits)
+}
+
+// SetPrismSplits : Replace the splits applied to the user's incoming payments
+func (controller *PrismController) SetPrismSplits(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body SetPrismSplitsRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load prism request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid prism request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	splits := make([]models.PrismSplit, len(body.Splits))
+	for i, split := range body.Splits {
+		splits[i] = models.PrismSplit{Destination: split.Destination, Percentage: split.Percentage}
+	}
+	splits, err := controller.svc.SetPrismSplits(c.Request().Context(), userID, splits)
+	if errors.Is(err, service.ErrInvalidPrismSplits) {
+		c.Logger().Errorf("Invalid prism splits user_id=%v: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &splits)
+}
+
+// DeletePrismSplits : Stop splitting incoming payments
+func (controller *PrismController) DeletePrismSplits(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	if _, err := controller.svc.SetPrismSplits(c.Request().Context(), userID, []models.PrismSplit{}); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
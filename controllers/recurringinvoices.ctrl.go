@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// RecurringInvoicesController : Recurring invoices controller struct
+type RecurringInvoicesController struct {
+	svc *service.LndhubService
+}
+
+func NewRecurringInvoicesController(svc *service.LndhubService) *RecurringInvoicesController {
+	return &RecurringInvoicesController{svc: svc}
+}
+
+type CreateRecurringInvoiceRequestBody struct {
+	Amount   int64  `json:"amt" validate:"gte=0"` // amount in Satoshi, 0 leaves the amount open
+	Memo     string `json:"memo" validate:"max=640"`
+	Interval int64  `json:"interval" validate:"gt=0"` // in seconds
+}
+
+type UpdateRecurringInvoiceRequestBody struct {
+	Amount  int64  `json:"amt" validate:"gte=0"`
+	Memo    string `json:"memo" validate:"max=640"`
+	Enabled bool   `json:"enabled"`
+}
+
+type CurrentRecurringInvoiceResponseBody struct {
+	RecurringInvoiceID int64     `json:"recurring_invoice_id"`
+	RHash              string    `json:"r_hash"`
+	PaymentRequest     string    `json:"payment_request"`
+	PayReq             string    `json:"pay_req"`
+	Amount             int64     `json:"amount"`
+	State              string    `json:"state"`
+	ExpiresAt          time.Time `json:"expires_at"`
+	NextRunAt          time.Time `json:"next_run_at"`
+}
+
+// GetRecurringInvoices : List the user's recurring invoices
+func (controller *RecurringInvoicesController) GetRecurringInvoices(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	recurring, err := controller.svc.RecurringInvoicesFor(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &recurring)
+}
+
+// CreateRecurringInvoice : Create a recurring invoice and the invoice of its first period
+func (controller *RecurringInvoicesController) CreateRecurringInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body CreateRecurringInvoiceRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load recurring invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid recurring invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	recurring, err := controller.svc.CreateRecurringInvoice(c.Request().Context(), &models.RecurringInvoice{
+		UserID:   userID,
+		Amount:   body.Amount,
+		Memo:     body.Memo,
+		Interval: body.Interval,
+	})
+	if errors.Is(err, service.ErrInvalidRecurringInterval) {
+		return c.JSON(http.StatusBadRequest, responses.InvalidRecurringIntervalError)
+	}
+	if err != nil {
+		return addInvoiceErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, recurring)
+}
+
+// UpdateRecurringInvoice : Change the amount, memo or enabled state of a recurring invoice
+func (controller *RecurringInvoicesController) UpdateRecurringInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	recurringID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	var body UpdateRecurringInvoiceRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load recurring invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid recurring invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	recurring, err := controller.svc.UpdateRecurringInvoice(c.Request().Context(), userID, recurringID, body.Amount, body.Memo, body.Enabled)
+	if err != nil {
+		c.Logger().Errorf("Failed to update recurring invoice user_id=%v recurring_invoice_id=%v: %v", userID, recurringID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, recurring)
+}
+
+// DeleteRecurringInvoice : Stop and remove a recurring invoice
+func (controller *RecurringInvoicesController) DeleteRecurringInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	recurringID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := controller.svc.DeleteRecurringInvoice(c.Request().Context(), userID, recurringID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetCurrentInvoice : Return the invoice of the running period of a recurring invoice
+func (controller *RecurringInvoicesController) GetCurrentInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	recurringID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	recurring, err := controller.svc.FindRecurringInvoice(c.Request().Context(), userID, recurringID)
+	if err != nil {
+		c.Logger().Errorf("Failed to find recurring invoice user_id=%v recurring_invoice_id=%v: %v", userID, recurringID, err)
+		return c.JSON(http.StatusNotFound, responses.BadArgumentsError)
+	}
+	invoice, err := controller.svc.CurrentRecurringInvoice(c.Request().Context(), recurring)
+	if err != nil {
+		c.Logger().Errorf("Failed to find current recurring invoice user_id=%v recurring_invoice_id=%v: %v", userID, recurringID, err)
+		return c.JSON(http.StatusNotFound, responses.BadArgumentsError)
+	}
+	return c.JSON(http.StatusOK, &CurrentRecurringInvoiceResponseBody{
+		RecurringInvoiceID: recurring.ID,
+		RHash:              invoice.RHash,
+		PaymentRequest:     invoice.PaymentRequest,
+		PayReq:             invoice.PaymentRequest,
+		Amount:             invoice.Amount,
+		State:              invoice.State,
+		ExpiresAt:          invoice.ExpiresAt.Time,
+		NextRunAt:          recurring.NextRunAt,
+	})
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// SessionsController : Login sessions controller struct
+type SessionsController struct {
+	svc *service.LndhubService
+}
+
+func NewSessionsController(svc *service.LndhubService) *SessionsController {
+	return &SessionsController{svc: svc}
+}
+
+// GetSessions : List the devices that are logged in to the user's account
+func (controller *SessionsController) GetSessions(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	sessions, err := controller.svc.SessionsFor(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &sessions)
+}
+
+// RevokeSession : Sign a device out, its refresh token can not be used anymore
+func (controller *SessionsController) RevokeSession(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	sessionID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := controller.svc.RevokeSession(c.Request().Context(), userID, sessionID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type CreateReadOnlyTokenRequestBody struct {
+	Device string `json:"device" validate:"required,max=255"` // name of the dashboard or tool, shown in the sessions
+}
+
+// CreateReadOnlyToken : Issue tokens that can read the balance and the transactions but not pay or create invoices
+func (controller *SessionsController) CreateReadOnlyToken(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body CreateReadOnlyTokenRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load read-only token request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid read-only token request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	accessToken, refreshToken, err := controller.svc.CreateReadOnlyToken(c.Request().Context(), userID, body.Device)
+	if errors.Is(err, service.ErrAccountFrozen) {
+		return c.JSON(http.StatusBadRequest, responses.AccountFrozenError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &AuthResponseBody{
+		RefreshToken: refreshToken,
+		AccessToken:  accessToken,
+	})
+}
+
+type CreateSpendLimitedTokenRequestBody struct {
+	Device              string `json:"device" validate:"required,max=255"` // name of the automation, shown in the sessions
+	MaxAmountPerPayment int64  `json:"max_amount_per_payment" validate:"gte=0"`
+	MaxAmountPerDay     int64  `json:"max_amount_per_day" validate:"gte=0"`
+}
+
+// CreateSpendLimitedToken : Issue tokens whose payments are limited per payment and per day
+func (controller *SessionsController) CreateSpendLimitedToken(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body CreateSpendLimitedTokenRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load spend limited token request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid spend limited token request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	accessToken, refreshToken, err := controller.svc.CreateSpendLimitedToken(c.Request().Context(), userID, body.Device, body.MaxAmountPerPayment, body.MaxAmountPerDay)
+	if errors.Is(err, service.ErrInvalidSpendLimit) {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if errors.Is(err, service.ErrAccountFrozen) {
+		return c.JSON(http.StatusBadRequest, responses.AccountFrozenError)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &AuthResponseBody{
+		RefreshToken: refreshToken,
+		AccessToken:  accessToken,
+	})
+}
@@ -14,6 +14,9 @@ func init() {
 			fmt.Printf("\033[1;31m%s\033[0m", "You are not using PostgreSQL. DB level checks can not be enabled!\n")
 			return nil
 		}
+		if skip, err := skipOnCockroachDB(ctx, db); skip || err != nil {
+			return err
+		}
 		sql := `
 			-- make sure transfers happen from one account to another one
 				alter table transaction_entries
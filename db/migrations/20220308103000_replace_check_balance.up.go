@@ -14,6 +14,9 @@ func init() {
 			fmt.Printf("\033[1;31m%s\033[0m", "You are not using PostgreSQL. DB level checks can not be enabled!\n")
 			return nil
 		}
+		if skip, err := skipOnCockroachDB(ctx, db); skip || err != nil {
+			return err
+		}
 		sql := `
 			-- make sure that account balances >= 0 (except for incoming and fees accounts)
 				CREATE OR REPLACE FUNCTION check_balance()
@@ -0,0 +1 @@
+alter table invoices add column payment_attempts integer;
\ No newline at end of file
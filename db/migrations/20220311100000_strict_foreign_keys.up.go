@@ -0,0 +1,105 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/uptrace/bun"
+)
+
+// quarantineStatements move the rows that break the constraints to quarantine tables, so the operator can review them
+// Each statement is run on its own to report how many rows it moved
+var quarantineStatements = []struct {
+	description string
+	sql         string
+}{
+	{
+		// the entries of invoices without a user go first, the invoices are moved by the next statement
+		description: "transaction entries without an invoice or of an invoice without a user",
+		sql: `WITH moved AS (
+				DELETE FROM transaction_entries
+				WHERE NOT EXISTS (SELECT 1 FROM invoices WHERE invoices.id = transaction_entries.invoice_id AND invoices.user_id IS NOT NULL)
+				RETURNING *
+			)
+			INSERT INTO quarantined_transaction_entries SELECT moved.*, 'missing invoice' FROM moved`,
+	},
+	{
+		description: "invoices without a user",
+		sql: `WITH moved AS (DELETE FROM invoices WHERE user_id IS NULL RETURNING *)
+			INSERT INTO quarantined_invoices SELECT moved.*, 'missing user' FROM moved`,
+	},
+	{
+		// fee entries are one level deep, their parents are never fee entries themselves
+		description: "transaction entries without a parent entry",
+		sql: `WITH moved AS (
+				DELETE FROM transaction_entries
+				WHERE parent_id IS NOT NULL
+				AND NOT EXISTS (SELECT 1 FROM transaction_entries parents WHERE parents.id = transaction_entries.parent_id)
+				RETURNING *
+			)
+			INSERT INTO quarantined_transaction_entries SELECT moved.*, 'missing parent entry' FROM moved`,
+	},
+	{
+		description: "invoices without an amount, the amount was set to 0",
+		sql:         `UPDATE invoices SET amount = 0 WHERE amount IS NULL`,
+	},
+	{
+		description: "invoices without a state, the state was set to initialized",
+		sql:         `UPDATE invoices SET state = 'initialized' WHERE state IS NULL`,
+	},
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+
+		if db.Dialect().Name().String() != "pg" {
+			fmt.Printf("\033[1;31m%s\033[0m", "You are not using PostgreSQL. DB level checks can not be enabled!\n")
+			return nil
+		}
+		// the quarantine tables have the columns of the tables at the time of this migration and the reason
+		_, err := db.ExecContext(ctx, `
+			CREATE TABLE quarantined_invoices (LIKE invoices);
+			ALTER TABLE quarantined_invoices ADD COLUMN quarantine_reason text NOT NULL;
+			CREATE TABLE quarantined_transaction_entries (LIKE transaction_entries);
+			ALTER TABLE quarantined_transaction_entries ADD COLUMN quarantine_reason text NOT NULL;
+		`)
+		if err != nil {
+			return err
+		}
+		for _, statement := range quarantineStatements {
+			res, err := db.ExecContext(ctx, statement.sql)
+			if err != nil {
+				return err
+			}
+			if rows, err := res.RowsAffected(); err == nil && rows > 0 {
+				log.Printf("Strict foreign keys: %d %s, review them in the quarantined_ tables", rows, statement.description)
+			}
+		}
+		sql := `
+			-- every invoice belongs to a user and has an amount and a state
+				alter table invoices
+				ALTER COLUMN user_id SET NOT NULL,
+				ALTER COLUMN amount SET NOT NULL,
+				ALTER COLUMN state SET NOT NULL;
+
+			-- transaction entries are pegged to their invoice, invoices with entries can not be deleted
+				alter table transaction_entries
+				ADD CONSTRAINT fk_invoice
+				FOREIGN KEY(invoice_id)
+				REFERENCES invoices(id)
+				ON DELETE RESTRICT;
+
+			-- fee entries belong to their parent entry, entries with fee entries can not be deleted
+				alter table transaction_entries
+				ADD CONSTRAINT fk_parent
+				FOREIGN KEY(parent_id)
+				REFERENCES transaction_entries(id)
+				ON DELETE RESTRICT;
+		`
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+		return nil
+	}, nil)
+}
@@ -0,0 +1,16 @@
+CREATE TABLE public.audit_logs (
+    id SERIAL PRIMARY KEY,
+    action character varying NOT NULL,
+    user_id bigint,
+    invoice_id bigint,
+    details character varying,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE SET NULL,
+    CONSTRAINT fk_invoice
+        FOREIGN KEY(invoice_id)
+        REFERENCES invoices(id)
+        ON DELETE SET NULL
+);
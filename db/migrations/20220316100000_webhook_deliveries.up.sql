@@ -0,0 +1,31 @@
+CREATE TABLE public.webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    url character varying NOT NULL,
+    event character varying NOT NULL,
+    payload text NOT NULL,
+    state character varying DEFAULT 'pending'::character varying NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    last_error character varying,
+    next_attempt_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone,
+    delivered_at timestamp with time zone
+);
+
+--bun:split
+
+CREATE INDEX index_webhook_deliveries_on_state_and_next_attempt_at ON public.webhook_deliveries (state, next_attempt_at);
+
+--bun:split
+
+CREATE TABLE public.webhook_delivery_attempts (
+    id SERIAL PRIMARY KEY,
+    webhook_delivery_id bigint NOT NULL,
+    status_code integer,
+    error character varying,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT fk_webhook_delivery
+        FOREIGN KEY(webhook_delivery_id)
+        REFERENCES webhook_deliveries(id)
+        ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+CREATE TABLE public.keysend_destinations (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    name character varying NOT NULL,
+    destination_pubkey_hex character varying NOT NULL,
+    custom_records text,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_keysend_destination_name
+        UNIQUE(user_id, name)
+);
@@ -0,0 +1,15 @@
+CREATE TABLE public.contacts (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    name character varying NOT NULL,
+    type character varying NOT NULL,
+    value character varying NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_contact
+        UNIQUE(user_id, type, value)
+);
@@ -0,0 +1,19 @@
+CREATE TABLE public.invoice_presets (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    name character varying NOT NULL,
+    amount bigint DEFAULT 0 NOT NULL,
+    memo character varying,
+    expiry bigint,
+    metadata text,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_invoice_preset_name
+        UNIQUE(user_id, name)
+);
+--bun:split
+alter table invoices add column metadata text;
@@ -0,0 +1,19 @@
+CREATE TABLE public.balance_claims (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    amount bigint NOT NULL,
+    state character varying DEFAULT 'open' NOT NULL,
+    invoice_id bigint,
+    expires_at timestamp with time zone NOT NULL,
+    redeemed_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_invoice
+        FOREIGN KEY(invoice_id)
+        REFERENCES invoices(id)
+        ON DELETE SET NULL
+);
@@ -0,0 +1,15 @@
+CREATE TABLE public.integrity_incidents (
+    id SERIAL PRIMARY KEY,
+    kind character varying NOT NULL,
+    fingerprint character varying NOT NULL,
+    user_id bigint,
+    invoice_id bigint,
+    transaction_entry_id bigint,
+    details text,
+    user_frozen boolean DEFAULT false NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT unique_integrity_incident_fingerprint
+        UNIQUE(fingerprint)
+);
+--bun:split
+alter table users add column frozen_at timestamp with time zone;
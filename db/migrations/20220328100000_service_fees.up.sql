@@ -0,0 +1,5 @@
+alter table invoices add column service_fee bigint;
+--bun:split
+INSERT INTO accounts (user_id, type)
+SELECT users.id, 'service_fees' FROM users
+WHERE NOT EXISTS (SELECT 1 FROM accounts WHERE accounts.user_id = users.id AND accounts.type = 'service_fees');
@@ -0,0 +1,16 @@
+CREATE TABLE public.jobs (
+    id SERIAL PRIMARY KEY,
+    type character varying NOT NULL,
+    payload text NOT NULL,
+    state character varying DEFAULT 'pending'::character varying NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    last_error character varying,
+    run_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone,
+    completed_at timestamp with time zone
+);
+
+--bun:split
+
+CREATE INDEX index_jobs_on_state_and_run_at ON public.jobs (state, run_at);
@@ -0,0 +1,25 @@
+CREATE TABLE public.swaps (
+    id SERIAL PRIMARY KEY,
+    swap_id character varying NOT NULL UNIQUE,
+    type character varying NOT NULL,
+    amount bigint NOT NULL,
+    state character varying DEFAULT 'pending'::character varying NOT NULL,
+    initiator character varying NOT NULL,
+    htlc_address character varying,
+    failure_reason character varying,
+    cost_server bigint DEFAULT 0 NOT NULL,
+    cost_onchain bigint DEFAULT 0 NOT NULL,
+    cost_offchain bigint DEFAULT 0 NOT NULL,
+    invoice_id bigint,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone,
+    completed_at timestamp with time zone,
+    CONSTRAINT fk_invoice
+        FOREIGN KEY(invoice_id)
+        REFERENCES invoices(id)
+        ON DELETE SET NULL
+);
+
+--bun:split
+
+CREATE INDEX index_swaps_on_state ON public.swaps (state);
@@ -0,0 +1 @@
+alter table users add column tier character varying DEFAULT 'basic'::character varying NOT NULL;
@@ -0,0 +1,6 @@
+CREATE TABLE public.invoice_subscription_states (
+    node_pubkey character varying PRIMARY KEY,
+    add_index bigint DEFAULT 0 NOT NULL,
+    settle_index bigint DEFAULT 0 NOT NULL,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE public.backup_runs (
+    id SERIAL PRIMARY KEY,
+    method character varying NOT NULL,
+    state character varying NOT NULL,
+    error character varying,
+    integrity_findings integer DEFAULT 0 NOT NULL,
+    started_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    finished_at timestamp with time zone
+);
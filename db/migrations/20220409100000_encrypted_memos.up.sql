@@ -0,0 +1,5 @@
+alter table users add column memo_public_key character varying;
+--bun:split
+alter table invoices add column encrypted_memo text;
+--bun:split
+alter table invoices add column memo_key_hint character varying;
@@ -0,0 +1,5 @@
+alter table users add column display_unit character varying DEFAULT 'sats'::character varying NOT NULL;
+--bun:split
+alter table users add column currency character varying;
+--bun:split
+alter table users add column timezone character varying DEFAULT 'UTC'::character varying NOT NULL;
@@ -0,0 +1,15 @@
+CREATE TABLE public.notifications (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    type character varying NOT NULL,
+    message character varying NOT NULL,
+    payload jsonb,
+    read_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
+--bun:split
+CREATE INDEX index_notifications_on_user_id ON public.notifications (user_id);
@@ -0,0 +1,3 @@
+INSERT INTO accounts (user_id, type)
+SELECT users.id, 'in_flight' FROM users
+WHERE NOT EXISTS (SELECT 1 FROM accounts WHERE accounts.user_id = users.id AND accounts.type = 'in_flight');
@@ -0,0 +1,14 @@
+CREATE TABLE public.payment_confirmations (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    amount bigint NOT NULL,
+    destination_pubkey_hex character varying NOT NULL,
+    r_hash character varying,
+    expires_at timestamp with time zone NOT NULL,
+    confirmed_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
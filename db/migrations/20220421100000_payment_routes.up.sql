@@ -0,0 +1,13 @@
+CREATE TABLE public.payment_routes (
+    id SERIAL PRIMARY KEY,
+    invoice_id bigint NOT NULL UNIQUE,
+    total_amt_msat bigint NOT NULL,
+    total_fees_msat bigint NOT NULL,
+    total_time_lock bigint NOT NULL,
+    hops jsonb,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT fk_invoice
+        FOREIGN KEY(invoice_id)
+            REFERENCES invoices(id)
+            ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+CREATE TABLE public.donation_pages (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL UNIQUE,
+    slug character varying NOT NULL UNIQUE,
+    display_name character varying NOT NULL,
+    description character varying,
+    suggested_amounts jsonb,
+    enabled boolean DEFAULT false NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
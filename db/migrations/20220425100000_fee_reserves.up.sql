@@ -0,0 +1 @@
+alter table invoices add column fee_reserve bigint;
@@ -0,0 +1 @@
+alter table invoices add column failure_reason character varying;
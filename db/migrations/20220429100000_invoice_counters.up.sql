@@ -0,0 +1,30 @@
+CREATE TABLE public.invoice_counters (
+    user_id bigint PRIMARY KEY,
+    open_invoices bigint DEFAULT 0 NOT NULL,
+    settled_invoices bigint DEFAULT 0 NOT NULL,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
+--bun:split
+CREATE TABLE public.payment_failure_counters (
+    user_id bigint NOT NULL,
+    hour timestamp with time zone NOT NULL,
+    failures bigint DEFAULT 0 NOT NULL,
+    PRIMARY KEY (user_id, hour),
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
+--bun:split
+alter table invoices add column open_counted boolean DEFAULT false NOT NULL;
+--bun:split
+CREATE INDEX index_invoices_on_user_id_open_counted ON public.invoices (user_id, expires_at) WHERE open_counted;
+--bun:split
+UPDATE invoices SET open_counted = true WHERE type = 'incoming' AND state = 'open' AND expires_at > CURRENT_TIMESTAMP;
+--bun:split
+INSERT INTO invoice_counters (user_id, open_invoices)
+    SELECT user_id, COUNT(*) FROM invoices WHERE open_counted GROUP BY user_id;
@@ -0,0 +1 @@
+CREATE INDEX index_invoices_on_user_id_created_at_id ON public.invoices (user_id, created_at DESC, id DESC);
@@ -0,0 +1,20 @@
+CREATE TABLE public.recurring_invoices (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    amount bigint DEFAULT 0 NOT NULL,
+    memo character varying,
+    interval bigint NOT NULL,
+    enabled boolean DEFAULT true NOT NULL,
+    current_invoice_id bigint,
+    next_run_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+--bun:split
+CREATE INDEX index_recurring_invoices_on_next_run_at ON public.recurring_invoices (next_run_at) WHERE enabled;
+--bun:split
+CREATE INDEX index_recurring_invoices_on_current_invoice_id ON public.recurring_invoices (current_invoice_id);
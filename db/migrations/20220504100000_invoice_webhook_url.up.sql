@@ -0,0 +1 @@
+alter table invoices add column webhook_url character varying;
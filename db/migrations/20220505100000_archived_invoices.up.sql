@@ -0,0 +1,8 @@
+-- archived_invoices has the columns of invoices in the same order, columns added to invoices must be added here as well
+CREATE TABLE public.archived_invoices (LIKE public.invoices INCLUDING DEFAULTS);
+--bun:split
+ALTER TABLE public.archived_invoices ADD COLUMN archived_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL;
+--bun:split
+ALTER TABLE public.archived_invoices ADD PRIMARY KEY (id);
+--bun:split
+CREATE INDEX index_archived_invoices_on_user_id ON public.archived_invoices (user_id);
@@ -0,0 +1,17 @@
+CREATE TABLE public.idempotency_keys (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    key character varying NOT NULL,
+    request_hash character varying NOT NULL,
+    invoice_id bigint,
+    status_code integer,
+    response text,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    completed_at timestamp with time zone,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_idempotency_key
+        UNIQUE(user_id, key)
+);
@@ -0,0 +1,143 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+
+		if db.Dialect().Name().String() != "pg" {
+			fmt.Printf("\033[1;31m%s\033[0m", "You are not using PostgreSQL. Account balances can not be maintained!\n")
+			return nil
+		}
+		if skip, err := skipOnCockroachDB(ctx, db); skip || err != nil {
+			return err
+		}
+		sql := `
+			-- block ledger inserts until the balances are backfilled and the trigger is in place
+				LOCK TABLE transaction_entries IN SHARE ROW EXCLUSIVE MODE;
+
+				CREATE TABLE account_balances (
+					account_id bigint PRIMARY KEY,
+					balance bigint DEFAULT 0 NOT NULL,
+					updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+					CONSTRAINT fk_account
+						FOREIGN KEY(account_id)
+						REFERENCES accounts(id)
+						ON DELETE CASCADE
+				);
+
+				CREATE OR REPLACE FUNCTION add_account_balance(account BIGINT, delta BIGINT)
+					RETURNS VOID AS $$
+				BEGIN
+					INSERT INTO account_balances (account_id, balance, updated_at)
+					VALUES (account, delta, current_timestamp)
+					ON CONFLICT (account_id) DO UPDATE
+					SET balance = account_balances.balance + EXCLUDED.balance, updated_at = EXCLUDED.updated_at;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				CREATE OR REPLACE FUNCTION book_account_balances(debit_account BIGINT, credit_account BIGINT, amount BIGINT)
+					RETURNS VOID AS $$
+				BEGIN
+					-- IMPORTANT: always update the lower account id first.
+					--   The balance rows stay locked until the end of the transaction,
+					--   a fixed order makes sure two parallel transactions can not deadlock on them
+					IF debit_account < credit_account
+					THEN
+						PERFORM add_account_balance(debit_account, 0 - amount);
+						PERFORM add_account_balance(credit_account, amount);
+					ELSE
+						PERFORM add_account_balance(credit_account, amount);
+						PERFORM add_account_balance(debit_account, 0 - amount);
+					END IF;
+				END;
+				$$ LANGUAGE plpgsql;
+
+			-- keep account_balances in sync with the ledger, within the transaction inserting the entry
+				CREATE OR REPLACE FUNCTION update_account_balances()
+					RETURNS TRIGGER AS $$
+				BEGIN
+					IF TG_OP = 'UPDATE' OR TG_OP = 'DELETE'
+					THEN
+						PERFORM book_account_balances(OLD.debit_account_id, OLD.credit_account_id, 0 - OLD.amount);
+					END IF;
+					IF TG_OP = 'INSERT' OR TG_OP = 'UPDATE'
+					THEN
+						PERFORM book_account_balances(NEW.debit_account_id, NEW.credit_account_id, NEW.amount);
+					END IF;
+					RETURN NULL;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				CREATE TRIGGER update_account_balances
+				AFTER INSERT OR UPDATE OR DELETE ON transaction_entries
+				FOR EACH ROW EXECUTE PROCEDURE update_account_balances();
+
+				INSERT INTO account_balances (account_id, balance)
+				SELECT account_id, SUM(amount) FROM account_ledgers GROUP BY account_id;
+
+			-- check the balance constraint against the maintained balance instead of summing the ledger
+				CREATE OR REPLACE FUNCTION check_balance()
+					RETURNS TRIGGER AS $$
+				DECLARE
+					sum BIGINT;
+					debit_account_type VARCHAR;
+					credit_account_type VARCHAR;
+				BEGIN
+
+					-- LOCK the account if the transaction is not from an incoming account
+					--  This makes sure we always check the balance of the account before commiting a transaction
+					--  (incoming accounts can be negative, so we do not care about those)
+					SELECT INTO debit_account_type type
+					FROM accounts
+					WHERE id = NEW.debit_account_id AND type <> 'incoming'
+					-- IMPORTANT: lock rows but do not wait for another lock to be released.
+					--   Waiting would result in a deadlock because two parallel transactions could try to lock the same rows
+					--   NOWAIT reports an error rather than waiting for the lock to be released
+					--   This can happen when two transactions try to access the same account
+					FOR UPDATE NOWAIT;
+
+					-- check if credit_account type is fees, if it's fees we don't check for negative balance constraint
+					SELECT INTO credit_account_type type
+					FROM accounts
+					WHERE id = NEW.credit_account_id AND type <> 'fees'
+					-- IMPORTANT: lock rows but do not wait for another lock to be released.
+					--   Waiting would result in a deadlock because two parallel transactions could try to lock the same rows
+					--   NOWAIT reports an error rather than waiting for the lock to be released
+					--   This can happen when two transactions try to access the same account
+					FOR UPDATE NOWAIT;
+
+					-- If it is an debit incoming account or fees credit account return; otherwise check the balance
+					IF debit_account_type IS NULL OR credit_account_type IS NULL
+					THEN
+						RETURN NEW;
+					END IF;
+
+					-- The account balance, including all entries of this transaction
+					SELECT INTO sum balance
+					FROM account_balances
+					WHERE account_balances.account_id = NEW.debit_account_id;
+
+					-- IF the account would go negative raise an exception
+					IF sum < 0
+					THEN
+						RAISE EXCEPTION 'invalid balance [user_id:%] [debit_account_id:%] balance [%]',
+						NEW.user_id,
+						NEW.debit_account_id,
+						sum;
+					END IF;
+					RETURN NEW;
+				END;
+				$$ LANGUAGE plpgsql;
+		`
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+		return nil
+	}, nil)
+}
@@ -0,0 +1,8 @@
+-- amounts are denominated in the asset of their account, existing rows are bitcoin amounts in sats
+alter table accounts add column asset character varying DEFAULT 'BTC' NOT NULL;
+--bun:split
+alter table transaction_entries add column asset character varying DEFAULT 'BTC' NOT NULL;
+--bun:split
+alter table invoices add column asset character varying DEFAULT 'BTC' NOT NULL;
+--bun:split
+alter table archived_invoices add column asset character varying DEFAULT 'BTC' NOT NULL;
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+
+		if db.Dialect().Name().String() != "pg" {
+			fmt.Printf("\033[1;31m%s\033[0m", "You are not using PostgreSQL. Entry assets can not be checked!\n")
+			return nil
+		}
+		if skip, err := skipOnCockroachDB(ctx, db); skip || err != nil {
+			return err
+		}
+		sql := `
+			-- an entry can only move funds between accounts of its own asset
+				CREATE OR REPLACE FUNCTION check_entry_assets()
+					RETURNS TRIGGER AS $$
+				BEGIN
+					IF EXISTS (
+						SELECT 1 FROM accounts
+						WHERE id IN (NEW.debit_account_id, NEW.credit_account_id) AND asset <> NEW.asset
+					)
+					THEN
+						RAISE EXCEPTION 'asset mismatch [transaction_entry_id:%] [asset:%] [debit_account_id:%] [credit_account_id:%]',
+						NEW.id,
+						NEW.asset,
+						NEW.debit_account_id,
+						NEW.credit_account_id;
+					END IF;
+					RETURN NEW;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				CREATE TRIGGER check_entry_assets
+				BEFORE INSERT OR UPDATE ON transaction_entries
+				FOR EACH ROW EXECUTE PROCEDURE check_entry_assets();
+		`
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+		return nil
+	}, nil)
+}
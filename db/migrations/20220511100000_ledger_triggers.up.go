@@ -0,0 +1,176 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+
+		if db.Dialect().Name().String() != "pg" {
+			fmt.Printf("\033[1;31m%s\033[0m", "You are not using PostgreSQL. DB level checks can not be enabled!\n")
+			return nil
+		}
+		// PostgreSQL keeps the triggers of the earlier migrations, among them the deferrable constraint trigger check_balance
+		cockroach, err := isCockroachDB(ctx, db)
+		if err != nil || !cockroach {
+			return err
+		}
+		// the checks of 20220120000700_add_constraints, which was skipped together with its trigger
+		_, err = db.ExecContext(ctx, `
+			alter table transaction_entries
+			ADD CONSTRAINT check_not_same_account
+			CHECK (debit_account_id != credit_account_id);
+
+			alter table invoices
+			ADD CONSTRAINT check_primage_exists
+			CHECK (
+				(state <> 'settled') OR
+				(preimage IS NOT NULL AND state = 'settled')
+			);
+		`)
+		if err != nil {
+			return err
+		}
+		// CockroachDB has no constraint triggers, no LOCK TABLE and only knows EXECUTE FUNCTION.
+		// The balance check now runs in the trigger maintaining account_balances, after the entry is booked.
+		// Triggers of the same event fire in alphabetical order, as separate triggers check_balance ran before update_account_balances
+		sql := `
+				DROP TRIGGER IF EXISTS check_balance ON transaction_entries;
+				DROP TRIGGER IF EXISTS update_account_balances ON transaction_entries;
+				DROP TRIGGER IF EXISTS check_entry_assets ON transaction_entries;
+				DROP FUNCTION IF EXISTS check_balance();
+
+				CREATE TABLE IF NOT EXISTS account_balances (
+					account_id bigint PRIMARY KEY,
+					balance bigint DEFAULT 0 NOT NULL,
+					updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+					CONSTRAINT fk_account
+						FOREIGN KEY(account_id)
+						REFERENCES accounts(id)
+						ON DELETE CASCADE
+				);
+
+				CREATE OR REPLACE FUNCTION add_account_balance(account BIGINT, delta BIGINT)
+					RETURNS VOID AS $$
+				BEGIN
+					INSERT INTO account_balances (account_id, balance, updated_at)
+					VALUES (account, delta, current_timestamp)
+					ON CONFLICT (account_id) DO UPDATE
+					SET balance = account_balances.balance + EXCLUDED.balance, updated_at = EXCLUDED.updated_at;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				CREATE OR REPLACE FUNCTION book_account_balances(debit_account BIGINT, credit_account BIGINT, amount BIGINT)
+					RETURNS VOID AS $$
+				BEGIN
+					-- IMPORTANT: always update the lower account id first.
+					--   The balance rows stay locked until the end of the transaction,
+					--   a fixed order makes sure two parallel transactions can not deadlock on them
+					IF debit_account < credit_account
+					THEN
+						PERFORM add_account_balance(debit_account, 0 - amount);
+						PERFORM add_account_balance(credit_account, amount);
+					ELSE
+						PERFORM add_account_balance(credit_account, amount);
+						PERFORM add_account_balance(debit_account, 0 - amount);
+					END IF;
+				END;
+				$$ LANGUAGE plpgsql;
+
+			-- make sure that account balances >= 0 (except for incoming and fees accounts)
+				CREATE OR REPLACE FUNCTION check_account_balance(entry_user_id BIGINT, debit_account BIGINT, credit_account BIGINT)
+					RETURNS VOID AS $$
+				DECLARE
+					current_balance BIGINT;
+					debit_account_type VARCHAR;
+					credit_account_type VARCHAR;
+				BEGIN
+					-- LOCK the account if the transaction is not from an incoming account
+					--  (incoming accounts can be negative, so we do not care about those)
+					-- IMPORTANT: lock rows but do not wait for another lock to be released.
+					--   NOWAIT reports an error rather than waiting for the lock to be released
+					--   This can happen when two transactions try to access the same account
+					SELECT type INTO debit_account_type
+					FROM accounts
+					WHERE id = debit_account AND type <> 'incoming'
+					FOR UPDATE NOWAIT;
+
+					-- entries crediting the fees account are not checked
+					SELECT type INTO credit_account_type
+					FROM accounts
+					WHERE id = credit_account AND type <> 'fees'
+					FOR UPDATE NOWAIT;
+
+					IF debit_account_type IS NULL OR credit_account_type IS NULL
+					THEN
+						RETURN;
+					END IF;
+
+					-- The account balance, including all entries of this transaction
+					SELECT balance INTO current_balance
+					FROM account_balances
+					WHERE account_balances.account_id = debit_account;
+
+					IF current_balance < 0
+					THEN
+						RAISE EXCEPTION 'invalid balance [user_id:%] [debit_account_id:%] balance [%]',
+						entry_user_id,
+						debit_account,
+						current_balance;
+					END IF;
+				END;
+				$$ LANGUAGE plpgsql;
+
+			-- keep account_balances in sync with the ledger, within the transaction inserting the entry
+				CREATE OR REPLACE FUNCTION update_account_balances()
+					RETURNS TRIGGER AS $$
+				BEGIN
+					IF TG_OP = 'UPDATE' OR TG_OP = 'DELETE'
+					THEN
+						PERFORM book_account_balances(OLD.debit_account_id, OLD.credit_account_id, 0 - OLD.amount);
+					END IF;
+					IF TG_OP = 'INSERT' OR TG_OP = 'UPDATE'
+					THEN
+						PERFORM book_account_balances(NEW.debit_account_id, NEW.credit_account_id, NEW.amount);
+						PERFORM check_account_balance(NEW.user_id, NEW.debit_account_id, NEW.credit_account_id);
+					END IF;
+					RETURN NULL;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				CREATE TRIGGER update_account_balances
+				AFTER INSERT OR UPDATE OR DELETE ON transaction_entries
+				FOR EACH ROW EXECUTE FUNCTION update_account_balances();
+
+				CREATE OR REPLACE FUNCTION check_entry_assets()
+					RETURNS TRIGGER AS $$
+				BEGIN
+					IF EXISTS (
+						SELECT 1 FROM accounts
+						WHERE id IN (NEW.debit_account_id, NEW.credit_account_id) AND asset <> NEW.asset
+					)
+					THEN
+						RAISE EXCEPTION 'asset mismatch [transaction_entry_id:%] [asset:%] [debit_account_id:%] [credit_account_id:%]',
+						NEW.id,
+						NEW.asset,
+						NEW.debit_account_id,
+						NEW.credit_account_id;
+					END IF;
+					RETURN NEW;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				CREATE TRIGGER check_entry_assets
+				BEFORE INSERT OR UPDATE ON transaction_entries
+				FOR EACH ROW EXECUTE FUNCTION check_entry_assets();
+		`
+		if _, err := db.ExecContext(ctx, sql); err != nil {
+			return err
+		}
+		return nil
+	}, nil)
+}
@@ -0,0 +1,3 @@
+alter table invoices add column label character varying;
+--bun:split
+alter table archived_invoices add column label character varying;
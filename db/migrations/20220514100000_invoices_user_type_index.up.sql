@@ -0,0 +1,4 @@
+-- invoice pages of a user are read by type, newest first
+CREATE INDEX index_invoices_on_user_id_type_id ON public.invoices (user_id, type, id DESC);
+--bun:split
+CREATE INDEX index_archived_invoices_on_user_id_type_id ON public.archived_invoices (user_id, type, id DESC);
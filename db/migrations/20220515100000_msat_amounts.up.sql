@@ -0,0 +1,12 @@
+-- the exact amounts in millisatoshis, the satoshi columns stay the amounts booked in the ledger
+alter table invoices add column amount_msat bigint;
+--bun:split
+alter table invoices add column fee_msat bigint;
+--bun:split
+alter table archived_invoices add column amount_msat bigint;
+--bun:split
+alter table archived_invoices add column fee_msat bigint;
+--bun:split
+update invoices set amount_msat = amount * 1000, fee_msat = fee * 1000;
+--bun:split
+update archived_invoices set amount_msat = amount * 1000, fee_msat = fee * 1000;
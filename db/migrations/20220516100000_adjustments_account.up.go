@@ -0,0 +1,119 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+
+		if db.Dialect().Name().String() != "pg" {
+			fmt.Printf("\033[1;31m%s\033[0m", "You are not using PostgreSQL. DB level checks can not be enabled!\n")
+			return nil
+		}
+		cockroach, err := isCockroachDB(ctx, db)
+		if err != nil {
+			return err
+		}
+		// adjustments accounts book the manual corrections of operators and go negative like incoming accounts
+		// PostgreSQL checks the balance in the constraint trigger check_balance, CockroachDB in the trigger of 20220511100000_ledger_triggers
+		sql := `
+				CREATE OR REPLACE FUNCTION check_balance()
+					RETURNS TRIGGER AS $$
+				DECLARE
+					sum BIGINT;
+					debit_account_type VARCHAR;
+					credit_account_type VARCHAR;
+				BEGIN
+					-- LOCK the account if the transaction is not from an incoming or adjustments account
+					--  (incoming and adjustments accounts can be negative, so we do not care about those)
+					-- IMPORTANT: lock rows but do not wait for another lock to be released.
+					--   NOWAIT reports an error rather than waiting for the lock to be released
+					--   This can happen when two transactions try to access the same account
+					SELECT INTO debit_account_type type
+					FROM accounts
+					WHERE id = NEW.debit_account_id AND type NOT IN ('incoming', 'adjustments')
+					FOR UPDATE NOWAIT;
+
+					-- entries crediting the fees account are not checked
+					SELECT INTO credit_account_type type
+					FROM accounts
+					WHERE id = NEW.credit_account_id AND type <> 'fees'
+					FOR UPDATE NOWAIT;
+
+					IF debit_account_type IS NULL OR credit_account_type IS NULL
+					THEN
+						RETURN NEW;
+					END IF;
+
+					-- The account balance, including all entries of this transaction
+					SELECT INTO sum balance
+					FROM account_balances
+					WHERE account_balances.account_id = NEW.debit_account_id;
+
+					IF sum < 0
+					THEN
+						RAISE EXCEPTION 'invalid balance [user_id:%] [debit_account_id:%] balance [%]',
+						NEW.user_id,
+						NEW.debit_account_id,
+						sum;
+					END IF;
+					RETURN NEW;
+				END;
+				$$ LANGUAGE plpgsql;
+		`
+		if cockroach {
+			sql = `
+				CREATE OR REPLACE FUNCTION check_account_balance(entry_user_id BIGINT, debit_account BIGINT, credit_account BIGINT)
+					RETURNS VOID AS $$
+				DECLARE
+					current_balance BIGINT;
+					debit_account_type VARCHAR;
+					credit_account_type VARCHAR;
+				BEGIN
+					-- LOCK the account if the transaction is not from an incoming or adjustments account
+					--  (incoming and adjustments accounts can be negative, so we do not care about those)
+					-- IMPORTANT: lock rows but do not wait for another lock to be released.
+					--   NOWAIT reports an error rather than waiting for the lock to be released
+					--   This can happen when two transactions try to access the same account
+					SELECT type INTO debit_account_type
+					FROM accounts
+					WHERE id = debit_account AND type NOT IN ('incoming', 'adjustments')
+					FOR UPDATE NOWAIT;
+
+					-- entries crediting the fees account are not checked
+					SELECT type INTO credit_account_type
+					FROM accounts
+					WHERE id = credit_account AND type <> 'fees'
+					FOR UPDATE NOWAIT;
+
+					IF debit_account_type IS NULL OR credit_account_type IS NULL
+					THEN
+						RETURN;
+					END IF;
+
+					-- The account balance, including all entries of this transaction
+					SELECT balance INTO current_balance
+					FROM account_balances
+					WHERE account_balances.account_id = debit_account;
+
+					IF current_balance < 0
+					THEN
+						RAISE EXCEPTION 'invalid balance [user_id:%] [debit_account_id:%] balance [%]',
+						entry_user_id,
+						debit_account,
+						current_balance;
+					END IF;
+				END;
+				$$ LANGUAGE plpgsql;
+		`
+		}
+		if _, err := db.ExecContext(ctx, sql); err != nil {
+			return err
+		}
+		return nil
+	}, nil)
+}
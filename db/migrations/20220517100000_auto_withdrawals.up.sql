@@ -0,0 +1,19 @@
+CREATE TABLE public.auto_withdrawals (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    threshold bigint NOT NULL,
+    destination character varying NOT NULL,
+    enabled boolean DEFAULT true NOT NULL,
+    failures integer DEFAULT 0 NOT NULL,
+    last_error character varying,
+    last_invoice_id bigint,
+    last_run_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_auto_withdrawal_user
+        UNIQUE(user_id)
+);
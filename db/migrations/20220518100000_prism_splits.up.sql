@@ -0,0 +1,13 @@
+CREATE TABLE public.prism_splits (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    destination character varying NOT NULL,
+    percentage integer NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+--bun:split
+CREATE INDEX index_prism_splits_on_user_id ON public.prism_splits (user_id);
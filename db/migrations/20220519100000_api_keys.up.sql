@@ -0,0 +1,18 @@
+CREATE TABLE public.api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    name character varying NOT NULL,
+    key_hash character varying NOT NULL,
+    hint character varying NOT NULL,
+    scopes text NOT NULL,
+    last_used_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_api_key_hash
+        UNIQUE(key_hash)
+);
+--bun:split
+CREATE INDEX index_api_keys_on_user_id ON public.api_keys (user_id);
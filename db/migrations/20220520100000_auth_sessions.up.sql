@@ -0,0 +1,16 @@
+CREATE TABLE public.auth_sessions (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    device character varying,
+    token_id character varying NOT NULL,
+    refreshed_at timestamp with time zone,
+    expires_at timestamp with time zone NOT NULL,
+    revoked_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+--bun:split
+CREATE INDEX index_auth_sessions_on_user_id ON public.auth_sessions (user_id);
@@ -0,0 +1,8 @@
+CREATE TABLE public.revoked_tokens (
+    id SERIAL PRIMARY KEY,
+    token_id character varying NOT NULL UNIQUE,
+    expires_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+--bun:split
+CREATE INDEX index_revoked_tokens_on_expires_at ON public.revoked_tokens (expires_at);
@@ -0,0 +1 @@
+ALTER TABLE public.auth_sessions ADD COLUMN scopes text;
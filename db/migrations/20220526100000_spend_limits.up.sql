@@ -0,0 +1,22 @@
+ALTER TABLE public.api_keys ADD COLUMN max_amount_per_payment bigint;
+--bun:split
+ALTER TABLE public.api_keys ADD COLUMN max_amount_per_day bigint;
+--bun:split
+ALTER TABLE public.auth_sessions ADD COLUMN max_amount_per_payment bigint;
+--bun:split
+ALTER TABLE public.auth_sessions ADD COLUMN max_amount_per_day bigint;
+--bun:split
+CREATE TABLE public.credential_payments (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    credential character varying NOT NULL,
+    invoice_id bigint NOT NULL UNIQUE,
+    amount bigint NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+--bun:split
+CREATE INDEX index_credential_payments_on_credential_created_at ON public.credential_payments (credential, created_at);
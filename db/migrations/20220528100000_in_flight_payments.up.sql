@@ -0,0 +1,19 @@
+CREATE TABLE public.in_flight_payments (
+    id SERIAL PRIMARY KEY,
+    user_id bigint NOT NULL,
+    r_hash character varying NOT NULL,
+    type character varying NOT NULL,
+    invoice_id bigint NOT NULL UNIQUE,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT in_flight_payments_user_id_r_hash_type_key UNIQUE (user_id, r_hash, type),
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+--bun:split
+INSERT INTO public.in_flight_payments (user_id, r_hash, type, invoice_id)
+SELECT invoices.user_id, invoices.r_hash, invoices.type, MIN(invoices.id) FROM public.invoices
+WHERE invoices.type = 'outgoing' AND invoices.state = 'initialized' AND invoices.r_hash IS NOT NULL
+AND EXISTS (SELECT 1 FROM public.transaction_entries WHERE transaction_entries.invoice_id = invoices.id)
+GROUP BY invoices.user_id, invoices.r_hash, invoices.type;
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+
+		if db.Dialect().Name().String() != "pg" {
+			return nil
+		}
+		cockroach, err := isCockroachDB(ctx, db)
+		if err != nil || !cockroach {
+			return err
+		}
+		// CockroachDB fills SERIAL columns with unique_rowid() by default,
+		// those ids do not fit into the numbers of JavaScript clients and are not sequential.
+		// The check runs after the tables were created, the option has to be set before the first migration
+		var serialNormalization string
+		if err := db.QueryRowContext(ctx, "SHOW serial_normalization").Scan(&serialNormalization); err != nil {
+			return err
+		}
+		if serialNormalization != "sql_sequence" {
+			return fmt.Errorf("CockroachDB must use sequences for SERIAL columns, add serial_normalization=sql_sequence to the DATABASE_URI")
+		}
+		return nil
+	}, nil)
+}
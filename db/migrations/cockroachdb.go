@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/uptrace/bun"
+)
+
+// isCockroachDB tells CockroachDB apart from PostgreSQL, both use the pg dialect
+func isCockroachDB(ctx context.Context, db *bun.DB) (bool, error) {
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT version()").Scan(&version); err != nil {
+		return false, err
+	}
+	return strings.Contains(version, "CockroachDB"), nil
+}
+
+// skipOnCockroachDB reports if a PostgreSQL only migration must be skipped
+// Its triggers are installed in a form CockroachDB supports by 20220511100000_ledger_triggers
+func skipOnCockroachDB(ctx context.Context, db *bun.DB) (bool, error) {
+	cockroach, err := isCockroachDB(ctx, db)
+	if err != nil || !cockroach {
+		return false, err
+	}
+	log.Printf("CockroachDB: the ledger triggers are installed by 20220511100000_ledger_triggers")
+	return true, nil
+}
@@ -4,6 +4,9 @@ import (
 	"embed"
 	"log"
 
+	"github.com/getAlby/lndhub.go/db/migrations/mysql"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
 	"github.com/uptrace/bun/migrate"
 )
 
@@ -17,3 +20,12 @@ func init() {
 		log.Fatalf("Error discovering migrations: %v", err)
 	}
 }
+
+// For returns the migrations of the database's dialect
+// MySQL and MariaDB have their own migrations, PostgreSQL and SQLite share these
+func For(db *bun.DB) *migrate.Migrations {
+	if db.Dialect().Name() == dialect.MySQL {
+		return mysql.Migrations
+	}
+	return Migrations
+}
@@ -0,0 +1,544 @@
+-- the schema of the PostgreSQL migrations up to 20220509100000_assets
+-- memos, payment requests and urls can be longer than a varchar, they are stored as text
+CREATE TABLE users (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    email VARCHAR(255) UNIQUE,
+    login VARCHAR(255) NOT NULL UNIQUE,
+    password VARCHAR(255) NOT NULL,
+    frozen_at DATETIME(6),
+    tier VARCHAR(255) DEFAULT 'basic' NOT NULL,
+    memo_public_key VARCHAR(255),
+    display_unit VARCHAR(255) DEFAULT 'sats' NOT NULL,
+    currency VARCHAR(255),
+    timezone VARCHAR(255) DEFAULT 'UTC' NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6)
+);
+
+--bun:split
+
+CREATE TABLE invoices (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    type VARCHAR(255),
+    user_id BIGINT NOT NULL,
+    amount BIGINT NOT NULL,
+    memo TEXT,
+    description_hash VARCHAR(255),
+    payment_request TEXT,
+    destination_pubkey_hex VARCHAR(255) NOT NULL,
+    r_hash VARCHAR(255),
+    preimage VARCHAR(255),
+    internal BOOLEAN,
+    state VARCHAR(255) DEFAULT 'initialized' NOT NULL,
+    error_message TEXT,
+    add_index BIGINT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    expires_at DATETIME(6),
+    updated_at DATETIME(6),
+    settled_at DATETIME(6),
+    fee BIGINT,
+    keysend BOOLEAN,
+    payment_attempts INTEGER,
+    metadata TEXT,
+    service_fee BIGINT,
+    encrypted_memo TEXT,
+    memo_key_hint VARCHAR(255),
+    zap_request TEXT,
+    fee_reserve BIGINT,
+    failure_reason VARCHAR(255),
+    open_counted BOOLEAN DEFAULT false NOT NULL,
+    webhook_url TEXT,
+    asset VARCHAR(255) DEFAULT 'BTC' NOT NULL,
+    CONSTRAINT fk_invoices_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT check_primage_exists
+        CHECK ((state <> 'settled') OR (preimage IS NOT NULL AND state = 'settled'))
+);
+
+--bun:split
+
+CREATE INDEX index_invoices_on_user_id_open_counted ON invoices (user_id, open_counted, expires_at);
+
+--bun:split
+
+CREATE INDEX index_invoices_on_user_id_created_at_id ON invoices (user_id, created_at DESC, id DESC);
+
+--bun:split
+
+CREATE TABLE archived_invoices (
+    id BIGINT PRIMARY KEY,
+    type VARCHAR(255),
+    user_id BIGINT NOT NULL,
+    amount BIGINT NOT NULL,
+    memo TEXT,
+    description_hash VARCHAR(255),
+    payment_request TEXT,
+    destination_pubkey_hex VARCHAR(255) NOT NULL,
+    r_hash VARCHAR(255),
+    preimage VARCHAR(255),
+    internal BOOLEAN,
+    state VARCHAR(255) DEFAULT 'initialized' NOT NULL,
+    error_message TEXT,
+    add_index BIGINT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    expires_at DATETIME(6),
+    updated_at DATETIME(6),
+    settled_at DATETIME(6),
+    fee BIGINT,
+    keysend BOOLEAN,
+    payment_attempts INTEGER,
+    metadata TEXT,
+    service_fee BIGINT,
+    encrypted_memo TEXT,
+    memo_key_hint VARCHAR(255),
+    zap_request TEXT,
+    fee_reserve BIGINT,
+    failure_reason VARCHAR(255),
+    open_counted BOOLEAN DEFAULT false NOT NULL,
+    webhook_url TEXT,
+    archived_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    asset VARCHAR(255) DEFAULT 'BTC' NOT NULL
+);
+
+--bun:split
+
+CREATE INDEX index_archived_invoices_on_user_id ON archived_invoices (user_id);
+
+--bun:split
+
+CREATE TABLE accounts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    asset VARCHAR(255) DEFAULT 'BTC' NOT NULL,
+    CONSTRAINT fk_accounts_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+--bun:split
+
+CREATE TABLE transaction_entries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    invoice_id BIGINT NOT NULL,
+    parent_id BIGINT,
+    credit_account_id BIGINT NOT NULL,
+    debit_account_id BIGINT NOT NULL,
+    amount BIGINT NOT NULL,
+    asset VARCHAR(255) DEFAULT 'BTC' NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_transaction_entries_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_transaction_entries_credit_account
+        FOREIGN KEY(credit_account_id)
+        REFERENCES accounts(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_transaction_entries_debit_account
+        FOREIGN KEY(debit_account_id)
+        REFERENCES accounts(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_transaction_entries_invoice
+        FOREIGN KEY(invoice_id)
+        REFERENCES invoices(id)
+        ON DELETE RESTRICT,
+    CONSTRAINT fk_transaction_entries_parent
+        FOREIGN KEY(parent_id)
+        REFERENCES transaction_entries(id)
+        ON DELETE RESTRICT,
+    CONSTRAINT check_not_same_account
+        CHECK (debit_account_id <> credit_account_id)
+);
+
+--bun:split
+
+CREATE VIEW account_ledgers(
+    account_id,
+    transaction_entry_id,
+    amount
+) AS
+    SELECT
+        transaction_entries.credit_account_id,
+        transaction_entries.id,
+        transaction_entries.amount
+    FROM
+        transaction_entries
+    UNION ALL
+    SELECT
+        transaction_entries.debit_account_id,
+        transaction_entries.id,
+        (0 - transaction_entries.amount)
+    FROM
+        transaction_entries;
+
+--bun:split
+
+CREATE TABLE account_balances (
+    account_id BIGINT PRIMARY KEY,
+    balance BIGINT DEFAULT 0 NOT NULL,
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_account_balances_account
+        FOREIGN KEY(account_id)
+        REFERENCES accounts(id)
+        ON DELETE CASCADE
+);
+
+--bun:split
+
+CREATE TABLE audit_logs (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    action VARCHAR(255) NOT NULL,
+    user_id BIGINT,
+    invoice_id BIGINT,
+    details TEXT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_audit_logs_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE SET NULL,
+    CONSTRAINT fk_audit_logs_invoice
+        FOREIGN KEY(invoice_id)
+        REFERENCES invoices(id)
+        ON DELETE SET NULL
+);
+
+--bun:split
+
+CREATE TABLE webhook_deliveries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    url TEXT NOT NULL,
+    event VARCHAR(255) NOT NULL,
+    payload TEXT NOT NULL,
+    state VARCHAR(255) DEFAULT 'pending' NOT NULL,
+    attempts INTEGER DEFAULT 0 NOT NULL,
+    last_error TEXT,
+    next_attempt_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6),
+    delivered_at DATETIME(6)
+);
+
+--bun:split
+
+CREATE INDEX index_webhook_deliveries_on_state_and_next_attempt_at ON webhook_deliveries (state, next_attempt_at);
+
+--bun:split
+
+CREATE TABLE webhook_delivery_attempts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    webhook_delivery_id BIGINT NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_webhook_delivery_attempts_webhook_delivery
+        FOREIGN KEY(webhook_delivery_id)
+        REFERENCES webhook_deliveries(id)
+        ON DELETE CASCADE
+);
+
+--bun:split
+
+CREATE TABLE keysend_destinations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    destination_pubkey_hex VARCHAR(255) NOT NULL,
+    custom_records TEXT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6),
+    CONSTRAINT fk_keysend_destinations_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_keysend_destination_name
+        UNIQUE(user_id, name)
+);
+
+--bun:split
+
+CREATE TABLE contacts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6),
+    CONSTRAINT fk_contacts_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_contact
+        UNIQUE(user_id, type, value)
+);
+
+--bun:split
+
+CREATE TABLE invoice_presets (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    amount BIGINT DEFAULT 0 NOT NULL,
+    memo TEXT,
+    expiry BIGINT,
+    metadata TEXT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6),
+    CONSTRAINT fk_invoice_presets_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_invoice_preset_name
+        UNIQUE(user_id, name)
+);
+
+--bun:split
+
+CREATE TABLE balance_claims (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    amount BIGINT NOT NULL,
+    state VARCHAR(255) DEFAULT 'open' NOT NULL,
+    invoice_id BIGINT,
+    expires_at DATETIME(6) NOT NULL,
+    redeemed_at DATETIME(6),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6),
+    CONSTRAINT fk_balance_claims_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_balance_claims_invoice
+        FOREIGN KEY(invoice_id)
+        REFERENCES invoices(id)
+        ON DELETE SET NULL
+);
+
+--bun:split
+
+CREATE TABLE integrity_incidents (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(255) NOT NULL,
+    user_id BIGINT,
+    invoice_id BIGINT,
+    transaction_entry_id BIGINT,
+    details TEXT,
+    user_frozen BOOLEAN DEFAULT false NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT unique_integrity_incident_fingerprint
+        UNIQUE(fingerprint)
+);
+
+--bun:split
+
+CREATE TABLE jobs (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    type VARCHAR(255) NOT NULL,
+    payload TEXT NOT NULL,
+    state VARCHAR(255) DEFAULT 'pending' NOT NULL,
+    attempts INTEGER DEFAULT 0 NOT NULL,
+    last_error TEXT,
+    run_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6),
+    completed_at DATETIME(6)
+);
+
+--bun:split
+
+CREATE INDEX index_jobs_on_state_and_run_at ON jobs (state, run_at);
+
+--bun:split
+
+CREATE TABLE swaps (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    swap_id VARCHAR(255) NOT NULL UNIQUE,
+    type VARCHAR(255) NOT NULL,
+    amount BIGINT NOT NULL,
+    state VARCHAR(255) DEFAULT 'pending' NOT NULL,
+    initiator VARCHAR(255) NOT NULL,
+    htlc_address VARCHAR(255),
+    failure_reason TEXT,
+    cost_server BIGINT DEFAULT 0 NOT NULL,
+    cost_onchain BIGINT DEFAULT 0 NOT NULL,
+    cost_offchain BIGINT DEFAULT 0 NOT NULL,
+    invoice_id BIGINT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6),
+    completed_at DATETIME(6),
+    CONSTRAINT fk_swaps_invoice
+        FOREIGN KEY(invoice_id)
+        REFERENCES invoices(id)
+        ON DELETE SET NULL
+);
+
+--bun:split
+
+CREATE INDEX index_swaps_on_state ON swaps (state);
+
+--bun:split
+
+CREATE TABLE invoice_subscription_states (
+    node_pubkey VARCHAR(255) PRIMARY KEY,
+    add_index BIGINT DEFAULT 0 NOT NULL,
+    settle_index BIGINT DEFAULT 0 NOT NULL,
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL
+);
+
+--bun:split
+
+CREATE TABLE backup_runs (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    method VARCHAR(255) NOT NULL,
+    state VARCHAR(255) NOT NULL,
+    error TEXT,
+    integrity_findings INTEGER DEFAULT 0 NOT NULL,
+    started_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    finished_at DATETIME(6)
+);
+
+--bun:split
+
+CREATE TABLE notifications (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    message TEXT NOT NULL,
+    payload JSON,
+    read_at DATETIME(6),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_notifications_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+--bun:split
+
+CREATE TABLE payment_confirmations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    amount BIGINT NOT NULL,
+    destination_pubkey_hex VARCHAR(255) NOT NULL,
+    r_hash VARCHAR(255),
+    expires_at DATETIME(6) NOT NULL,
+    confirmed_at DATETIME(6),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_payment_confirmations_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+--bun:split
+
+CREATE TABLE payment_routes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    invoice_id BIGINT NOT NULL UNIQUE,
+    total_amt_msat BIGINT NOT NULL,
+    total_fees_msat BIGINT NOT NULL,
+    total_time_lock BIGINT NOT NULL,
+    hops JSON,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_payment_routes_invoice
+        FOREIGN KEY(invoice_id)
+        REFERENCES invoices(id)
+        ON DELETE CASCADE
+);
+
+--bun:split
+
+CREATE TABLE donation_pages (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL UNIQUE,
+    slug VARCHAR(255) NOT NULL UNIQUE,
+    display_name VARCHAR(255) NOT NULL,
+    description TEXT,
+    suggested_amounts JSON,
+    enabled BOOLEAN DEFAULT false NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6),
+    CONSTRAINT fk_donation_pages_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+--bun:split
+
+CREATE TABLE invoice_counters (
+    user_id BIGINT PRIMARY KEY,
+    open_invoices BIGINT DEFAULT 0 NOT NULL,
+    settled_invoices BIGINT DEFAULT 0 NOT NULL,
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_invoice_counters_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+--bun:split
+
+CREATE TABLE payment_failure_counters (
+    user_id BIGINT NOT NULL,
+    hour DATETIME(6) NOT NULL,
+    failures BIGINT DEFAULT 0 NOT NULL,
+    PRIMARY KEY (user_id, hour),
+    CONSTRAINT fk_payment_failure_counters_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+--bun:split
+
+CREATE TABLE recurring_invoices (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    amount BIGINT DEFAULT 0 NOT NULL,
+    memo TEXT,
+    `interval` BIGINT NOT NULL,
+    enabled BOOLEAN DEFAULT true NOT NULL,
+    current_invoice_id BIGINT,
+    next_run_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6),
+    CONSTRAINT fk_recurring_invoices_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+--bun:split
+
+CREATE INDEX index_recurring_invoices_on_next_run_at ON recurring_invoices (enabled, next_run_at);
+
+--bun:split
+
+CREATE INDEX index_recurring_invoices_on_current_invoice_id ON recurring_invoices (current_invoice_id);
+
+--bun:split
+
+CREATE TABLE idempotency_keys (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    `key` VARCHAR(255) NOT NULL,
+    request_hash VARCHAR(255) NOT NULL,
+    invoice_id BIGINT,
+    status_code INTEGER,
+    response TEXT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    completed_at DATETIME(6),
+    CONSTRAINT fk_idempotency_keys_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_idempotency_key
+        UNIQUE(user_id, `key`)
+);
@@ -0,0 +1,94 @@
+-- keep account_balances in sync with the ledger and check the balance constraint, within the statement inserting the entry
+-- IMPORTANT: always update the lower account id first.
+--   The balance rows stay locked until the end of the transaction,
+--   a fixed order makes sure two parallel transactions can not deadlock on them
+CREATE PROCEDURE book_account_balances(debit_account BIGINT, credit_account BIGINT, amount BIGINT)
+BEGIN
+    IF debit_account < credit_account
+    THEN
+        INSERT INTO account_balances (account_id, balance) VALUES (debit_account, 0 - amount)
+        ON DUPLICATE KEY UPDATE balance = balance + VALUES(balance), updated_at = CURRENT_TIMESTAMP(6);
+        INSERT INTO account_balances (account_id, balance) VALUES (credit_account, amount)
+        ON DUPLICATE KEY UPDATE balance = balance + VALUES(balance), updated_at = CURRENT_TIMESTAMP(6);
+    ELSE
+        INSERT INTO account_balances (account_id, balance) VALUES (credit_account, amount)
+        ON DUPLICATE KEY UPDATE balance = balance + VALUES(balance), updated_at = CURRENT_TIMESTAMP(6);
+        INSERT INTO account_balances (account_id, balance) VALUES (debit_account, 0 - amount)
+        ON DUPLICATE KEY UPDATE balance = balance + VALUES(balance), updated_at = CURRENT_TIMESTAMP(6);
+    END IF;
+END;
+
+--bun:split
+
+-- the equivalent of the check_balance trigger of PostgreSQL
+--  incoming accounts can be negative and entries crediting the fees account are not checked
+CREATE PROCEDURE check_balance(user_id BIGINT, debit_account BIGINT, credit_account BIGINT)
+BEGIN
+    DECLARE debit_account_type VARCHAR(255);
+    DECLARE credit_account_type VARCHAR(255);
+    DECLARE current_balance BIGINT;
+    DECLARE message VARCHAR(255);
+
+    SELECT type INTO debit_account_type FROM accounts WHERE id = debit_account;
+    SELECT type INTO credit_account_type FROM accounts WHERE id = credit_account;
+    IF debit_account_type <> 'incoming' AND credit_account_type <> 'fees'
+    THEN
+        -- the balance row is locked by book_account_balances, parallel entries of the account wait for this transaction
+        SELECT balance INTO current_balance FROM account_balances WHERE account_id = debit_account;
+        IF current_balance < 0
+        THEN
+            SET message = CONCAT('invalid balance [user_id:', user_id, '] [debit_account_id:', debit_account, '] balance [', current_balance, ']');
+            SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = message;
+        END IF;
+    END IF;
+END;
+
+--bun:split
+
+-- the equivalent of the check_entry_assets trigger of PostgreSQL
+CREATE TRIGGER check_entry_assets
+BEFORE INSERT ON transaction_entries
+FOR EACH ROW
+BEGIN
+    DECLARE message VARCHAR(255);
+    IF EXISTS (
+        SELECT 1 FROM accounts
+        WHERE id IN (NEW.debit_account_id, NEW.credit_account_id) AND asset <> NEW.asset
+    )
+    THEN
+        SET message = CONCAT('asset mismatch [asset:', NEW.asset, '] [debit_account_id:', NEW.debit_account_id, '] [credit_account_id:', NEW.credit_account_id, ']');
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = message;
+    END IF;
+END;
+
+--bun:split
+
+-- MySQL has no deferred triggers, a failed check aborts the statement inserting the entry
+CREATE TRIGGER update_account_balances_insert
+AFTER INSERT ON transaction_entries
+FOR EACH ROW
+BEGIN
+    CALL book_account_balances(NEW.debit_account_id, NEW.credit_account_id, NEW.amount);
+    CALL check_balance(NEW.user_id, NEW.debit_account_id, NEW.credit_account_id);
+END;
+
+--bun:split
+
+CREATE TRIGGER update_account_balances_update
+AFTER UPDATE ON transaction_entries
+FOR EACH ROW
+BEGIN
+    CALL book_account_balances(OLD.debit_account_id, OLD.credit_account_id, 0 - OLD.amount);
+    CALL book_account_balances(NEW.debit_account_id, NEW.credit_account_id, NEW.amount);
+    CALL check_balance(NEW.user_id, NEW.debit_account_id, NEW.credit_account_id);
+END;
+
+--bun:split
+
+-- entries removed by a foreign key cascade do not fire triggers, the integrity check reports the difference
+CREATE TRIGGER update_account_balances_delete
+AFTER DELETE ON transaction_entries
+FOR EACH ROW
+BEGIN
+    CALL book_account_balances(OLD.debit_account_id, OLD.credit_account_id, 0 - OLD.amount);
+END;
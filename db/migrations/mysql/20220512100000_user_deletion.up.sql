@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN deleted_at DATETIME(6);
@@ -0,0 +1,3 @@
+ALTER TABLE invoices ADD COLUMN label VARCHAR(255);
+--bun:split
+ALTER TABLE archived_invoices ADD COLUMN label VARCHAR(255);
@@ -0,0 +1,3 @@
+CREATE INDEX index_invoices_on_user_id_type_id ON invoices (user_id, type, id DESC);
+--bun:split
+CREATE INDEX index_archived_invoices_on_user_id_type_id ON archived_invoices (user_id, type, id DESC);
@@ -0,0 +1,11 @@
+ALTER TABLE invoices ADD COLUMN amount_msat BIGINT;
+--bun:split
+ALTER TABLE invoices ADD COLUMN fee_msat BIGINT;
+--bun:split
+ALTER TABLE archived_invoices ADD COLUMN amount_msat BIGINT;
+--bun:split
+ALTER TABLE archived_invoices ADD COLUMN fee_msat BIGINT;
+--bun:split
+UPDATE invoices SET amount_msat = amount * 1000, fee_msat = fee * 1000;
+--bun:split
+UPDATE archived_invoices SET amount_msat = amount * 1000, fee_msat = fee * 1000;
@@ -0,0 +1,25 @@
+DROP PROCEDURE check_balance;
+
+--bun:split
+
+-- incoming and adjustments accounts can be negative and entries crediting the fees account are not checked
+CREATE PROCEDURE check_balance(user_id BIGINT, debit_account BIGINT, credit_account BIGINT)
+BEGIN
+    DECLARE debit_account_type VARCHAR(255);
+    DECLARE credit_account_type VARCHAR(255);
+    DECLARE current_balance BIGINT;
+    DECLARE message VARCHAR(255);
+
+    SELECT type INTO debit_account_type FROM accounts WHERE id = debit_account;
+    SELECT type INTO credit_account_type FROM accounts WHERE id = credit_account;
+    IF debit_account_type NOT IN ('incoming', 'adjustments') AND credit_account_type <> 'fees'
+    THEN
+        -- the balance row is locked by book_account_balances, parallel entries of the account wait for this transaction
+        SELECT balance INTO current_balance FROM account_balances WHERE account_id = debit_account;
+        IF current_balance < 0
+        THEN
+            SET message = CONCAT('invalid balance [user_id:', user_id, '] [debit_account_id:', debit_account, '] balance [', current_balance, ']');
+            SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = message;
+        END IF;
+    END IF;
+END;
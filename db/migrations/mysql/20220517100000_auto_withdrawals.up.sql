@@ -0,0 +1,19 @@
+CREATE TABLE auto_withdrawals (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    threshold BIGINT NOT NULL,
+    destination VARCHAR(255) NOT NULL,
+    enabled BOOLEAN DEFAULT true NOT NULL,
+    failures INT DEFAULT 0 NOT NULL,
+    last_error TEXT,
+    last_invoice_id BIGINT,
+    last_run_at DATETIME(6),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    updated_at DATETIME(6),
+    CONSTRAINT fk_auto_withdrawals_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT unique_auto_withdrawal_user
+        UNIQUE(user_id)
+);
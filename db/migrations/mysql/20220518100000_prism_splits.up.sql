@@ -0,0 +1,11 @@
+CREATE TABLE prism_splits (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    destination VARCHAR(255) NOT NULL,
+    percentage INT NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_prism_splits_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
@@ -0,0 +1,14 @@
+CREATE TABLE auth_sessions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    device VARCHAR(255),
+    token_id VARCHAR(64) NOT NULL,
+    refreshed_at DATETIME(6),
+    expires_at DATETIME(6) NOT NULL,
+    revoked_at DATETIME(6),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_auth_sessions_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
@@ -0,0 +1,8 @@
+CREATE TABLE revoked_tokens (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    token_id VARCHAR(64) NOT NULL UNIQUE,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL
+);
+--bun:split
+CREATE INDEX index_revoked_tokens_on_expires_at ON revoked_tokens (expires_at);
@@ -0,0 +1 @@
+ALTER TABLE auth_sessions ADD COLUMN scopes TEXT;
@@ -0,0 +1,22 @@
+ALTER TABLE api_keys ADD COLUMN max_amount_per_payment BIGINT;
+--bun:split
+ALTER TABLE api_keys ADD COLUMN max_amount_per_day BIGINT;
+--bun:split
+ALTER TABLE auth_sessions ADD COLUMN max_amount_per_payment BIGINT;
+--bun:split
+ALTER TABLE auth_sessions ADD COLUMN max_amount_per_day BIGINT;
+--bun:split
+CREATE TABLE credential_payments (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    credential VARCHAR(64) NOT NULL,
+    invoice_id BIGINT NOT NULL UNIQUE,
+    amount BIGINT NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT fk_credential_payments_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+--bun:split
+CREATE INDEX index_credential_payments_on_credential_created_at ON credential_payments (credential, created_at);
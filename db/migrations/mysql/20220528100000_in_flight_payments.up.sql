@@ -0,0 +1,19 @@
+CREATE TABLE in_flight_payments (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    r_hash VARCHAR(255) NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    invoice_id BIGINT NOT NULL UNIQUE,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6) NOT NULL,
+    CONSTRAINT in_flight_payments_user_id_r_hash_type_key UNIQUE (user_id, r_hash, type),
+    CONSTRAINT fk_in_flight_payments_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+--bun:split
+INSERT INTO in_flight_payments (user_id, r_hash, type, invoice_id)
+SELECT invoices.user_id, invoices.r_hash, invoices.type, MIN(invoices.id) FROM invoices
+WHERE invoices.type = 'outgoing' AND invoices.state = 'initialized' AND invoices.r_hash IS NOT NULL
+AND EXISTS (SELECT 1 FROM transaction_entries WHERE transaction_entries.invoice_id = invoices.id)
+GROUP BY invoices.user_id, invoices.r_hash, invoices.type;
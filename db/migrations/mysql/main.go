@@ -0,0 +1,22 @@
+// Package mysql has the migrations of MySQL and MariaDB databases
+// The PostgreSQL migrations can not run on MySQL, this set creates the same schema in a single step
+// Schema changes must be added to both sets, a test of the migrations package compares their tables and columns
+package mysql
+
+import (
+	"embed"
+	"log"
+
+	"github.com/uptrace/bun/migrate"
+)
+
+var Migrations = migrate.NewMigrations()
+
+//go:embed *.sql
+var sqlMigrations embed.FS
+
+func init() {
+	if err := Migrations.Discover(sqlMigrations); err != nil {
+		log.Fatalf("Error discovering migrations: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package migrations
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	createTablePattern = regexp.MustCompile(`(?is)^CREATE TABLE (?:IF NOT EXISTS )?(?:public\.)?(\w+)\s*\((.*)\)$`)
+	addColumnPattern   = regexp.MustCompile(`(?is)^ALTER TABLE (?:public\.)?(\w+) ADD (?:COLUMN )?(\w+)`)
+	// lines of a table definition that are no column
+	tableConstraintPattern = regexp.MustCompile(`(?is)^(CONSTRAINT\s|PRIMARY\s+KEY|UNIQUE\s*\w*\s*\(|FOREIGN\s+KEY|CHECK\s*\(|(KEY|INDEX)\s+\w+\s*\()`)
+)
+
+// migratedColumns returns the columns of every table the sql migrations create, in the order the migrations run
+func migratedColumns(t *testing.T, pattern string) map[string][]string {
+	files, err := filepath.Glob(pattern)
+	assert.NoError(t, err)
+	sort.Strings(files)
+	tables := map[string][]string{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		for _, statement := range strings.Split(stripComments(string(content)), ";") {
+			statement = strings.TrimSpace(strings.ReplaceAll(statement, "--bun:split", ""))
+			if match := createTablePattern.FindStringSubmatch(statement); match != nil {
+				tables[match[1]] = tableColumns(match[2], tables)
+			}
+			if match := addColumnPattern.FindStringSubmatch(statement); match != nil && !strings.EqualFold(match[2], "PRIMARY") {
+				tables[match[1]] = append(tables[match[1]], strings.ToLower(match[2]))
+			}
+		}
+	}
+	return tables
+}
+
+func stripComments(sql string) string {
+	lines := []string{}
+	for _, line := range strings.Split(sql, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") || strings.TrimSpace(line) == "--bun:split" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tableColumns splits the definition of a table at the commas outside of parentheses
+// A table created LIKE another one has the columns of the other table
+func tableColumns(definition string, tables map[string][]string) []string {
+	if like := regexp.MustCompile(`(?i)^LIKE (?:public\.)?(\w+)`).FindStringSubmatch(strings.TrimSpace(definition)); like != nil {
+		return append([]string{}, tables[like[1]]...)
+	}
+	columns := []string{}
+	depth, start := 0, 0
+	for i := 0; i <= len(definition); i++ {
+		if i < len(definition) {
+			switch definition[i] {
+			case '(':
+				depth++
+				continue
+			case ')':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		item := strings.TrimSpace(definition[start:i])
+		start = i + 1
+		if item == "" || tableConstraintPattern.MatchString(item) {
+			continue
+		}
+		columns = append(columns, strings.ToLower(strings.Trim(strings.Fields(item)[0], "`\"")))
+	}
+	return columns
+}
+
+// The MySQL migrations are written by hand, every table and column of the PostgreSQL migrations must exist in them as well
+func TestMySQLMigrationsMatchPostgreSQL(t *testing.T) {
+	postgres := migratedColumns(t, "*.sql")
+	mysql := migratedColumns(t, "mysql/*.sql")
+	assert.NotEmpty(t, postgres)
+	for table, columns := range postgres {
+		mysqlColumns, ok := mysql[table]
+		if !assert.True(t, ok, "table %s is missing in the MySQL migrations", table) {
+			continue
+		}
+		assert.ElementsMatch(t, columns, mysqlColumns, "columns of table %s differ", table)
+	}
+}
@@ -1,9 +1,28 @@
 package models
 
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// AssetBTC is the asset of amounts in satoshis, rows without an asset are bitcoin rows
+const AssetBTC = "BTC"
+
 // Account : Account Model
 type Account struct {
 	ID     int64  `bun:",pk,autoincrement"`
 	UserID int64  `bun:",notnull"`
 	User   *User  `bun:"rel:belongs-to,join:user_id=id"`
 	Type   string `bun:",notnull"`
+	Asset  string `bun:",notnull,default:'BTC'"`
 }
+
+func (a *Account) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	if a.Asset == "" {
+		a.Asset = AssetBTC
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*Account)(nil)
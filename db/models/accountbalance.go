@@ -0,0 +1,12 @@
+package models
+
+import (
+	"time"
+)
+
+// AccountBalance : Balance of an account, maintained by a trigger on every transaction entry
+type AccountBalance struct {
+	AccountID int64     `bun:",pk"`
+	Balance   int64     `bun:",notnull"`
+	UpdatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// APIKey : Long-lived credential of a user for server-to-server integrations
+// Only the SHA-256 hash of the key is stored, the hint is the end of the key to tell keys apart
+type APIKey struct {
+	ID      int64    `json:"id" bun:",pk,autoincrement"`
+	UserID  int64    `json:"-" bun:",notnull"`
+	User    *User    `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Name    string   `json:"name" bun:",notnull"`
+	KeyHash string   `json:"-" bun:",unique,notnull"`
+	Hint    string   `json:"hint" bun:",notnull"`
+	Scopes  []string `json:"scopes" bun:",notnull"`
+	// spend limit of the payments made with the key, zero for no limit
+	MaxAmountPerPayment int64        `json:"max_amount_per_payment,omitempty" bun:",nullzero"`
+	MaxAmountPerDay     int64        `json:"max_amount_per_day,omitempty" bun:",nullzero"`
+	LastUsedAt          bun.NullTime `json:"last_used_at"`
+	CreatedAt           time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
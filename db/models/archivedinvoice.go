@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// ArchivedInvoice : Unpaid expired invoice moved out of the invoices table by the retention policy
+type ArchivedInvoice struct {
+	Invoice
+	ArchivedAt time.Time `json:"archived_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog : Record of an administrative action
+type AuditLog struct {
+	ID        int64     `bun:",pk,autoincrement"`
+	Action    string    `bun:",notnull"`
+	UserID    int64     `bun:",nullzero"`
+	InvoiceID int64     `bun:",nullzero"`
+	Details   string    `bun:",nullzero"`
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// AuthSession : Login of a user on a device
+// TokenID is the id of the only refresh token of the session that can still be used, the tokens of a session with
+// scopes can only use the routes of the scopes
+type AuthSession struct {
+	ID      int64    `json:"id" bun:",pk,autoincrement"`
+	UserID  int64    `json:"-" bun:",notnull"`
+	User    *User    `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Device  string   `json:"device" bun:",nullzero"`
+	TokenID string   `json:"-" bun:",notnull"`
+	Scopes  []string `json:"scopes,omitempty"` // empty for full access
+	// spend limit of the payments made with the tokens of the session, zero for no limit
+	MaxAmountPerPayment int64        `json:"max_amount_per_payment,omitempty" bun:",nullzero"`
+	MaxAmountPerDay     int64        `json:"max_amount_per_day,omitempty" bun:",nullzero"`
+	RefreshedAt         bun.NullTime `json:"refreshed_at"`
+	ExpiresAt           time.Time    `json:"expires_at" bun:",notnull"`
+	RevokedAt           bun.NullTime `json:"-"`
+	CreatedAt           time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
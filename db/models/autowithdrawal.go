@@ -0,0 +1,35 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// AutoWithdrawal : Payout of the balance above the threshold to a lightning address
+// Failures counts the consecutive failed payouts, LastInvoiceID is the outgoing invoice of the last payout
+type AutoWithdrawal struct {
+	ID            int64        `json:"-" bun:",pk,autoincrement"`
+	UserID        int64        `json:"-" bun:",notnull"`
+	User          *User        `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Threshold     int64        `json:"threshold" bun:",notnull"`
+	Destination   string       `json:"destination" bun:",notnull"`
+	Enabled       bool         `json:"enabled" bun:",notnull"`
+	Failures      int          `json:"failures" bun:",notnull"`
+	LastError     string       `json:"last_error,omitempty" bun:",nullzero"`
+	LastInvoiceID int64        `json:"last_invoice_id,omitempty" bun:",nullzero"`
+	LastRunAt     bun.NullTime `json:"last_run_at"`
+	CreatedAt     time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt     bun.NullTime `json:"updated_at"`
+}
+
+func (a *AutoWithdrawal) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		a.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*AutoWithdrawal)(nil)
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// BackupRun : Database backup triggered by the hub
+type BackupRun struct {
+	ID                int64        `json:"id" bun:",pk,autoincrement"`
+	Method            string       `json:"method" bun:",notnull"`
+	State             string       `json:"state" bun:",notnull"`
+	Error             string       `json:"error,omitempty" bun:",nullzero"`
+	IntegrityFindings int          `json:"integrity_findings" bun:",notnull"`
+	StartedAt         time.Time    `json:"started_at" bun:",nullzero,notnull,default:current_timestamp"`
+	FinishedAt        bun.NullTime `json:"finished_at"`
+}
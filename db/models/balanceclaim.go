@@ -0,0 +1,33 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// BalanceClaim : One-time claim on a user's balance used to move the balance to another hub
+// InvoiceID references the outgoing invoice that paid the claim out
+type BalanceClaim struct {
+	ID         int64        `json:"id" bun:",pk,autoincrement"`
+	UserID     int64        `json:"user_id" bun:",notnull"`
+	User       *User        `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Amount     int64        `json:"amount" bun:",notnull"`
+	State      string       `json:"state" bun:",notnull,default:'open'"`
+	InvoiceID  int64        `json:"invoice_id" bun:",nullzero"`
+	ExpiresAt  time.Time    `json:"expires_at" bun:",notnull"`
+	RedeemedAt bun.NullTime `json:"redeemed_at"`
+	CreatedAt  time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt  bun.NullTime `json:"updated_at"`
+}
+
+func (b *BalanceClaim) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		b.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*BalanceClaim)(nil)
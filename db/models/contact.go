@@ -0,0 +1,31 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Contact : Counterparty saved by a user
+// Depending on the type the value is a user login, a lightning address or a node pubkey
+type Contact struct {
+	ID        int64        `json:"id" bun:",pk,autoincrement"`
+	UserID    int64        `json:"-" bun:",notnull"`
+	User      *User        `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Name      string       `json:"name" bun:",notnull"`
+	Type      string       `json:"type" bun:",notnull"`
+	Value     string       `json:"value" bun:",notnull"`
+	CreatedAt time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt bun.NullTime `json:"updated_at"`
+}
+
+func (c *Contact) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		c.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*Contact)(nil)
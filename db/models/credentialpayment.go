@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// CredentialPayment : Outgoing payment made with an api key or token that has a spend limit
+// Credential is the api key or the session, the payments of the last 24 hours count against its daily limit
+type CredentialPayment struct {
+	ID         int64     `bun:",pk,autoincrement"`
+	UserID     int64     `bun:",notnull"`
+	Credential string    `bun:",notnull"`
+	InvoiceID  int64     `bun:",unique,notnull"`
+	Amount     int64     `bun:",notnull"`
+	CreatedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
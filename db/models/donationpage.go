@@ -0,0 +1,31 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// DonationPage : Public page a user can receive donations on, only served while enabled
+type DonationPage struct {
+	ID               int64        `json:"-" bun:",pk,autoincrement"`
+	UserID           int64        `json:"-" bun:",notnull"`
+	Slug             string       `json:"slug" bun:",notnull"`
+	DisplayName      string       `json:"display_name" bun:",notnull"`
+	Description      string       `json:"description" bun:",nullzero"`
+	SuggestedAmounts []int64      `json:"suggested_amounts" bun:",nullzero"`
+	Enabled          bool         `json:"enabled" bun:",notnull"`
+	CreatedAt        time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt        bun.NullTime `json:"updated_at"`
+}
+
+func (p *DonationPage) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		p.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*DonationPage)(nil)
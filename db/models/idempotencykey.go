@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// IdempotencyKey : Client supplied key of a write request and the response that is replayed on retries
+// The request is still in progress until the status code is set
+type IdempotencyKey struct {
+	ID          int64        `bun:",pk,autoincrement"`
+	UserID      int64        `bun:",notnull"`
+	Key         string       `bun:",notnull"`
+	RequestHash string       `bun:",notnull"`
+	InvoiceID   int64        `bun:",nullzero"`
+	StatusCode  int          `bun:",nullzero"`
+	Response    string       `bun:",nullzero"`
+	CreatedAt   time.Time    `bun:",nullzero,notnull,default:current_timestamp"`
+	CompletedAt bun.NullTime `bun:",nullzero"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// InFlightPayment : Payment of a user that was booked and not yet settled or failed
+// The unique constraint on user, payment hash and type lets only one payment of an invoice be in flight at a time
+type InFlightPayment struct {
+	ID        int64     `bun:",pk,autoincrement"`
+	UserID    int64     `bun:",notnull"`
+	RHash     string    `bun:",notnull"`
+	Type      string    `bun:",notnull"`
+	InvoiceID int64     `bun:",unique,notnull"`
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
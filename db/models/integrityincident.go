@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// IntegrityIncident : Ledger inconsistency found by the integrity monitor
+// The fingerprint identifies the finding so it is only reported once
+type IntegrityIncident struct {
+	ID                 int64     `json:"id" bun:",pk,autoincrement"`
+	Kind               string    `json:"kind" bun:",notnull"`
+	Fingerprint        string    `json:"fingerprint" bun:",notnull"`
+	UserID             int64     `json:"user_id" bun:",nullzero"`
+	InvoiceID          int64     `json:"invoice_id" bun:",nullzero"`
+	TransactionEntryID int64     `json:"transaction_entry_id" bun:",nullzero"`
+	Details            string    `json:"details" bun:",nullzero"`
+	UserFrozen         bool      `json:"user_frozen" bun:",notnull"`
+	CreatedAt          time.Time `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
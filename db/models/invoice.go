@@ -11,22 +11,37 @@ import (
 type Invoice struct {
 	ID                       int64             `json:"id" bun:",pk,autoincrement"`
 	Type                     string            `json:"type" validate:"required"`
-	UserID                   int64             `json:"user_id" validate:"required"`
-	User                     *User             `bun:"rel:belongs-to,join:user_id=id"`
-	Amount                   int64             `json:"amount" validate:"gte=0"`
+	UserID                   int64             `json:"user_id" validate:"required" bun:",notnull"`
+	User                     *User             `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Amount                   int64             `json:"amount" validate:"gte=0" bun:",notnull"`
+	AmountMsat               int64             `json:"amount_msat" bun:",nullzero"`
+	Asset                    string            `json:"asset" bun:",notnull,default:'BTC'"`
 	Fee                      int64             `json:"fee" bun:",nullzero"`
+	FeeMsat                  int64             `json:"fee_msat" bun:",nullzero"`
+	ServiceFee               int64             `json:"service_fee" bun:",nullzero"`
+	FeeReserve               int64             `json:"fee_reserve" bun:",nullzero"`
 	Memo                     string            `json:"memo" bun:",nullzero"`
+	EncryptedMemo            string            `json:"encrypted_memo" bun:",nullzero"`
+	MemoKeyHint              string            `json:"memo_key_hint" bun:",nullzero"`
+	Label                    string            `json:"label" bun:",nullzero"` // set by the user after the fact, e.g. to categorize transactions
 	DescriptionHash          string            `json:"description_hash" bun:",nullzero"`
 	PaymentRequest           string            `json:"payment_request" bun:",nullzero"`
 	DestinationPubkeyHex     string            `json:"destination_pubkey_hex" bun:",notnull"`
 	DestinationCustomRecords map[uint64][]byte `bun:"-"`
+	OutgoingChanId           uint64            `json:"-" bun:"-"`
+	LastHopPubkey            string            `json:"-" bun:"-"`
 	RHash                    string            `json:"r_hash"`
 	Preimage                 string            `json:"preimage" bun:",nullzero"`
 	Internal                 bool              `json:"internal" bun:",nullzero"`
 	Keysend                  bool              `json:"keysend" bun:",nullzero"`
 	State                    string            `json:"state" bun:",default:'initialized'"`
 	ErrorMessage             string            `json:"error_message" bun:",nullzero"`
+	FailureReason            string            `json:"failure_reason" bun:",nullzero"`
 	AddIndex                 uint64            `json:"add_index" bun:",nullzero"`
+	PaymentAttempts          int               `json:"payment_attempts" bun:",nullzero"`
+	Metadata                 map[string]string `json:"metadata,omitempty" bun:",nullzero"`
+	ZapRequest               string            `json:"-" bun:",nullzero"`
+	WebhookUrl               string            `json:"-" bun:",nullzero"` // receives the settlement and expiry of this invoice in addition to WEBHOOK_URL
 	CreatedAt                time.Time         `bun:",nullzero,notnull,default:current_timestamp"`
 	ExpiresAt                bun.NullTime      `bun:",nullzero"`
 	UpdatedAt                bun.NullTime      `json:"updated_at"`
@@ -34,7 +49,19 @@ type Invoice struct {
 }
 
 func (i *Invoice) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	if i.Asset == "" {
+		i.Asset = AssetBTC
+	}
 	switch query.(type) {
+	case *bun.InsertQuery:
+		// the satoshi amounts are exact unless the millisatoshi amounts were set
+		// updates keep the stored amounts, changed amounts are set explicitly
+		if i.AmountMsat == 0 {
+			i.AmountMsat = i.Amount * 1000
+		}
+		if i.FeeMsat == 0 {
+			i.FeeMsat = i.Fee * 1000
+		}
 	case *bun.UpdateQuery:
 		i.UpdatedAt = bun.NullTime{Time: time.Now()}
 	}
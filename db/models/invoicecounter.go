@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// InvoiceCounter : Counters of a user's invoices, maintained on invoice state transitions
+// SettledInvoices only ever increases and is used as the cursor for unread settlements
+type InvoiceCounter struct {
+	UserID          int64     `bun:",pk"`
+	OpenInvoices    int64     `bun:",notnull"`
+	SettledInvoices int64     `bun:",notnull"`
+	UpdatedAt       time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+// PaymentFailureCounter : Failed payments of a user per hour
+type PaymentFailureCounter struct {
+	UserID   int64     `bun:",pk"`
+	Hour     time.Time `bun:",pk"`
+	Failures int64     `bun:",notnull"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// InvoicePreset : Template a user can create incoming invoices from
+// An amount of 0 leaves the amount open, an expiry of 0 uses the default invoice expiry
+type InvoicePreset struct {
+	ID        int64             `json:"id" bun:",pk,autoincrement"`
+	UserID    int64             `json:"-" bun:",notnull"`
+	User      *User             `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Name      string            `json:"name" bun:",notnull"`
+	Amount    int64             `json:"amount" bun:",notnull"`
+	Memo      string            `json:"memo" bun:",nullzero"`
+	Expiry    int64             `json:"expiry" bun:",nullzero"` // in seconds
+	Metadata  map[string]string `json:"metadata,omitempty" bun:",nullzero"`
+	CreatedAt time.Time         `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt bun.NullTime      `json:"updated_at"`
+}
+
+func (p *InvoicePreset) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		p.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*InvoicePreset)(nil)
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+// InvoiceSubscriptionState : Last add and settle index of the node's invoice subscription that was processed
+type InvoiceSubscriptionState struct {
+	NodePubkey  string    `bun:",pk"`
+	AddIndex    uint64    `bun:",notnull"`
+	SettleIndex uint64    `bun:",notnull"`
+	UpdatedAt   time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Job : Background job of the persistent job queue
+type Job struct {
+	ID          int64        `json:"id" bun:",pk,autoincrement"`
+	Type        string       `json:"type" bun:",notnull"`
+	Payload     string       `json:"payload" bun:",notnull"`
+	State       string       `json:"state" bun:",notnull,default:'pending'"`
+	Attempts    int          `json:"attempts" bun:",notnull"`
+	LastError   string       `json:"last_error" bun:",nullzero"`
+	RunAt       time.Time    `json:"run_at" bun:",nullzero,notnull,default:current_timestamp"`
+	CreatedAt   time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt   bun.NullTime `json:"updated_at"`
+	CompletedAt bun.NullTime `json:"completed_at"`
+}
+
+func (j *Job) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		j.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*Job)(nil)
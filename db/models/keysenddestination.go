@@ -0,0 +1,30 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// KeysendDestination : Saved keysend destination of a user
+type KeysendDestination struct {
+	ID                   int64             `json:"id" bun:",pk,autoincrement"`
+	UserID               int64             `json:"-" bun:",notnull"`
+	User                 *User             `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Name                 string            `json:"name" bun:",notnull"`
+	DestinationPubkeyHex string            `json:"destination" bun:",notnull"`
+	CustomRecords        map[string]string `json:"customRecords" bun:",nullzero"`
+	CreatedAt            time.Time         `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt            bun.NullTime      `json:"updated_at"`
+}
+
+func (d *KeysendDestination) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		d.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*KeysendDestination)(nil)
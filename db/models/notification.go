@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Notification : Message for a user, e.g. about repeatedly failing payments
+type Notification struct {
+	ID        int64                  `json:"id" bun:",pk,autoincrement"`
+	UserID    int64                  `json:"-" bun:",notnull"`
+	Type      string                 `json:"type" bun:",notnull"`
+	Message   string                 `json:"message" bun:",notnull"`
+	Payload   map[string]interface{} `json:"payload,omitempty" bun:",nullzero"`
+	ReadAt    bun.NullTime           `json:"read_at"`
+	CreatedAt time.Time              `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
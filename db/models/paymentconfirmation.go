@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// PaymentConfirmation : One-time confirmation of an unusual payment
+// The confirmation is bound to the amount and destination of the payment it was issued for
+type PaymentConfirmation struct {
+	ID                   int64        `json:"id" bun:",pk,autoincrement"`
+	UserID               int64        `json:"user_id" bun:",notnull"`
+	Amount               int64        `json:"amount" bun:",notnull"`
+	DestinationPubkeyHex string       `json:"destination_pubkey_hex" bun:",notnull"`
+	RHash                string       `json:"r_hash" bun:",nullzero"`
+	ExpiresAt            time.Time    `json:"expires_at" bun:",notnull"`
+	ConfirmedAt          bun.NullTime `json:"confirmed_at"`
+	CreatedAt            time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
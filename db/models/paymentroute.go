@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// PaymentRoute : Route a successful outgoing payment took through the network
+type PaymentRoute struct {
+	ID            int64      `json:"id" bun:",pk,autoincrement"`
+	InvoiceID     int64      `json:"invoice_id" bun:",notnull"`
+	TotalAmtMsat  int64      `json:"total_amt_msat" bun:",notnull"`
+	TotalFeesMsat int64      `json:"total_fees_msat" bun:",notnull"`
+	TotalTimeLock uint32     `json:"total_time_lock" bun:",notnull"`
+	Hops          []RouteHop `json:"hops" bun:",nullzero"`
+	CreatedAt     time.Time  `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+// RouteHop : A channel of a payment route, the fee is charged by the node forwarding into the channel
+type RouteHop struct {
+	ChanId           uint64 `json:"chan_id"`
+	PubKey           string `json:"pub_key"`
+	AmtToForwardMsat int64  `json:"amt_to_forward_msat"`
+	FeeMsat          int64  `json:"fee_msat"`
+	Expiry           uint32 `json:"expiry"`
+}
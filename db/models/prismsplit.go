@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// PrismSplit : Share of every incoming payment that is forwarded to another user of the hub or a lightning address
+type PrismSplit struct {
+	ID          int64     `json:"-" bun:",pk,autoincrement"`
+	UserID      int64     `json:"-" bun:",notnull"`
+	User        *User     `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Destination string    `json:"destination" bun:",notnull"` // login of a user of this hub or a lightning address
+	Percentage  int64     `json:"percentage" bun:",notnull"`
+	CreatedAt   time.Time `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
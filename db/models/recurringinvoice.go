@@ -0,0 +1,34 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// RecurringInvoice : Template of an invoice that is generated again every interval
+// An amount of 0 leaves the amount open, the current invoice is the one generated for the running period
+type RecurringInvoice struct {
+	ID               int64        `json:"id" bun:",pk,autoincrement"`
+	UserID           int64        `json:"-" bun:",notnull"`
+	User             *User        `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Amount           int64        `json:"amount" bun:",notnull"`
+	Memo             string       `json:"memo" bun:",nullzero"`
+	Interval         int64        `json:"interval" bun:",notnull"` // in seconds
+	Enabled          bool         `json:"enabled" bun:",notnull"`
+	CurrentInvoiceID int64        `json:"current_invoice_id,omitempty" bun:",nullzero"`
+	NextRunAt        time.Time    `json:"next_run_at" bun:",notnull"`
+	CreatedAt        time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt        bun.NullTime `json:"updated_at"`
+}
+
+func (r *RecurringInvoice) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		r.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*RecurringInvoice)(nil)
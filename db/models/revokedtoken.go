@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// RevokedToken : Access token that was revoked by a logout before it expired
+// Rows can be deleted once the token expired, the token is rejected by its expiry from then on
+type RevokedToken struct {
+	ID        int64     `bun:",pk,autoincrement"`
+	TokenID   string    `bun:",notnull"`
+	ExpiresAt time.Time `bun:",notnull"`
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
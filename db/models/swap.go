@@ -0,0 +1,42 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Swap : Submarine swap started to rebalance the node's liquidity
+type Swap struct {
+	ID            int64        `json:"id" bun:",pk,autoincrement"`
+	SwapID        string       `json:"swap_id" bun:",unique,notnull"`
+	Type          string       `json:"type" bun:",notnull"`
+	Amount        int64        `json:"amount" bun:",notnull"`
+	State         string       `json:"state" bun:",notnull,default:'pending'"`
+	Initiator     string       `json:"initiator" bun:",notnull"`
+	HtlcAddress   string       `json:"htlc_address" bun:",nullzero"`
+	FailureReason string       `json:"failure_reason" bun:",nullzero"`
+	CostServer    int64        `json:"cost_server" bun:",notnull"`
+	CostOnchain   int64        `json:"cost_onchain" bun:",notnull"`
+	CostOffchain  int64        `json:"cost_offchain" bun:",notnull"`
+	InvoiceID     int64        `json:"invoice_id" bun:",nullzero"`
+	CreatedAt     time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt     bun.NullTime `json:"updated_at"`
+	CompletedAt   bun.NullTime `json:"completed_at"`
+}
+
+// TotalCost is the sum of the swap's server, on-chain and off-chain costs
+func (s *Swap) TotalCost() int64 {
+	return s.CostServer + s.CostOnchain + s.CostOffchain
+}
+
+func (s *Swap) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		s.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*Swap)(nil)
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"context"
 	"time"
+
+	"github.com/uptrace/bun"
 )
 
 // TransactionEntry : Transaction Entries Model
@@ -18,5 +21,15 @@ type TransactionEntry struct {
 	DebitAccountID  int64             `bun:",notnull"`
 	DebitAccount    *Account          `bun:"rel:belongs-to,join:debit_account_id=id"`
 	Amount          int64             `bun:",notnull"`
+	Asset           string            `bun:",notnull,default:'BTC'"`
 	CreatedAt       time.Time         `bun:",nullzero,notnull,default:current_timestamp"`
 }
+
+func (e *TransactionEntry) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	if e.Asset == "" {
+		e.Asset = AssetBTC
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*TransactionEntry)(nil)
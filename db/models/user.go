@@ -10,14 +10,21 @@ import (
 
 // User : User Model
 type User struct {
-	ID        int64          `bun:",pk,autoincrement"`
-	Email     sql.NullString `bun:",unique"`
-	Login     string         `bun:",unique,notnull"`
-	Password  string         `bun:",notnull"`
-	CreatedAt time.Time      `bun:",nullzero,notnull,default:current_timestamp"`
-	UpdatedAt bun.NullTime
-	Invoices  []*Invoice `bun:"rel:has-many,join:id=user_id"`
-	Accounts  []*Account `bun:"rel:has-many,join:id=user_id"`
+	ID            int64          `bun:",pk,autoincrement"`
+	Email         sql.NullString `bun:",unique"`
+	Login         string         `bun:",unique,notnull"`
+	Password      string         `bun:",notnull"`
+	CreatedAt     time.Time      `bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt     bun.NullTime
+	FrozenAt      bun.NullTime
+	DeletedAt     bun.NullTime
+	Tier          string     `bun:",notnull,default:'basic'"`
+	MemoPublicKey string     `bun:",nullzero"`
+	DisplayUnit   string     `bun:",notnull,default:'sats'"`
+	Currency      string     `bun:",nullzero"`
+	Timezone      string     `bun:",notnull,default:'UTC'"`
+	Invoices      []*Invoice `bun:"rel:has-many,join:id=user_id"`
+	Accounts      []*Account `bun:"rel:has-many,join:id=user_id"`
 }
 
 func (u *User) BeforeAppendModel(ctx context.Context, query bun.Query) error {
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// WebhookDelivery : Webhook Delivery Model
+type WebhookDelivery struct {
+	ID            int64                     `json:"id" bun:",pk,autoincrement"`
+	URL           string                    `json:"url" bun:",notnull"`
+	Event         string                    `json:"event" bun:",notnull"`
+	Payload       string                    `json:"payload" bun:",notnull"`
+	State         string                    `json:"state" bun:",notnull,default:'pending'"`
+	Attempts      int                       `json:"attempts" bun:",notnull"`
+	LastError     string                    `json:"last_error" bun:",nullzero"`
+	NextAttemptAt time.Time                 `json:"next_attempt_at" bun:",nullzero,notnull,default:current_timestamp"`
+	CreatedAt     time.Time                 `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt     bun.NullTime              `json:"updated_at"`
+	DeliveredAt   bun.NullTime              `json:"delivered_at"`
+	AttemptLog    []*WebhookDeliveryAttempt `json:"attempt_log,omitempty" bun:"rel:has-many,join:id=webhook_delivery_id"`
+}
+
+// WebhookDeliveryAttempt : Webhook Delivery Attempt Model
+type WebhookDeliveryAttempt struct {
+	ID                int64     `json:"id" bun:",pk,autoincrement"`
+	WebhookDeliveryID int64     `json:"webhook_delivery_id" bun:",notnull"`
+	StatusCode        int       `json:"status_code" bun:",nullzero"`
+	Error             string    `json:"error" bun:",nullzero"`
+	CreatedAt         time.Time `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+func (d *WebhookDelivery) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		d.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*WebhookDelivery)(nil)
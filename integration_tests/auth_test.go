@@ -17,6 +17,7 @@ import (
 	"github.com/getAlby/lndhub.go/lib/service"
 	"github.com/getAlby/lndhub.go/lib/tokens"
 	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -111,7 +112,7 @@ func (suite *UserAuthTestSuite) TestAuthWithExpiredRefreshToken() {
 	user, _ := suite.Service.FindUser(context.Background(), userId)
 
 	// expire in 0 seconds, with correct secret and user
-	expiredRefreshToken, _ := tokens.GenerateRefreshToken(suite.Service.Config.JWTSecret, 0, user)
+	expiredRefreshToken, _ := tokens.GenerateRefreshToken(suite.Service.Config.JWTSecret, 0, user, "expired", tokens.AccessTokenOptions{SessionID: 1})
 
 	// login again with only expired refresh token
 	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(&controllers.AuthRequestBody{
@@ -157,7 +158,7 @@ func (suite *UserAuthTestSuite) TestAuthWithInvalidSecretRefreshToken() {
 	user, _ := suite.Service.FindUser(context.Background(), userId)
 
 	// only secret is invalid here
-	expiredRefreshToken, _ := tokens.GenerateRefreshToken([]byte("INVALID SECRET"), suite.Service.Config.JWTRefreshTokenExpiry, user)
+	expiredRefreshToken, _ := tokens.GenerateRefreshToken([]byte("INVALID SECRET"), suite.Service.Config.JWTRefreshTokenExpiry, user, "invalid", tokens.AccessTokenOptions{SessionID: 1})
 
 	// login again with only refresh token
 	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(&controllers.AuthRequestBody{
@@ -199,7 +200,7 @@ func (suite *UserAuthTestSuite) TestAuthWithInvalidUserIdRefreshToken() {
 	userId := getUserIdFromToken(responseBody.AccessToken)
 	user, _ := suite.Service.FindUser(context.Background(), userId+1)
 
-	expiredRefreshToken, _ := tokens.GenerateRefreshToken(suite.Service.Config.JWTSecret, suite.Service.Config.JWTRefreshTokenExpiry, user)
+	expiredRefreshToken, _ := tokens.GenerateRefreshToken(suite.Service.Config.JWTSecret, suite.Service.Config.JWTRefreshTokenExpiry, user, "unknown", tokens.AccessTokenOptions{SessionID: 1})
 
 	// login again with only refresh token
 	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(&controllers.AuthRequestBody{
@@ -256,6 +257,141 @@ func (suite *UserAuthTestSuite) TestAuthWithAccessToken() {
 	assert.Equal(suite.T(), responses.BadAuthError.Error, errorResponse.Error)
 }
 
+func (suite *UserAuthTestSuite) authWithRefreshToken(refreshToken string) (*httptest.ResponseRecorder, *controllers.AuthResponseBody) {
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(&controllers.AuthRequestBody{
+		RefreshToken: refreshToken,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/auth", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := suite.echo.NewContext(req, rec)
+	assert.NoError(suite.T(), controllers.NewAuthController(suite.Service).Auth(c))
+	responseBody := &controllers.AuthResponseBody{}
+	if rec.Code == http.StatusOK {
+		assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(responseBody))
+	}
+	return rec, responseBody
+}
+
+func (suite *UserAuthTestSuite) TestRefreshTokenRotation() {
+	_, refreshToken, err := suite.Service.GenerateToken(context.Background(), suite.userLogin.Login, suite.userLogin.Password, "", "test device")
+	assert.NoError(suite.T(), err)
+
+	// every refresh returns a new refresh token
+	rec, rotated := suite.authWithRefreshToken(refreshToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.NotEqual(suite.T(), refreshToken, rotated.RefreshToken)
+
+	// the replaced token can not be used again, using it revokes the session
+	rec, _ = suite.authWithRefreshToken(refreshToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	rec, _ = suite.authWithRefreshToken(rotated.RefreshToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+func (suite *UserAuthTestSuite) TestRevokeSession() {
+	accessToken, refreshToken, err := suite.Service.GenerateToken(context.Background(), suite.userLogin.Login, suite.userLogin.Password, "", "lost phone")
+	assert.NoError(suite.T(), err)
+	userId := getUserIdFromToken(accessToken)
+	sessions, err := suite.Service.SessionsFor(context.Background(), userId)
+	assert.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), sessions)
+	session := sessions[len(sessions)-1]
+	assert.Equal(suite.T(), "lost phone", session.Device)
+
+	assert.NoError(suite.T(), suite.Service.RevokeSession(context.Background(), userId, session.ID))
+	rec, _ := suite.authWithRefreshToken(refreshToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+func (suite *UserAuthTestSuite) TestReadOnlyTokenStaysReadOnly() {
+	accessToken, _, err := suite.Service.GenerateToken(context.Background(), suite.userLogin.Login, suite.userLogin.Password, "", "")
+	assert.NoError(suite.T(), err)
+	readOnlyAccessToken, readOnlyRefreshToken, err := suite.Service.CreateReadOnlyToken(context.Background(), getUserIdFromToken(accessToken), "dashboard")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []interface{}{tokens.ScopeAccountRead}, getScopesFromToken(readOnlyAccessToken))
+
+	rec, refreshed := suite.authWithRefreshToken(readOnlyRefreshToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Equal(suite.T(), []interface{}{tokens.ScopeAccountRead}, getScopesFromToken(refreshed.AccessToken))
+	// tokens of a login with the password have full access
+	assert.Nil(suite.T(), getScopesFromToken(accessToken))
+}
+
+func (suite *UserAuthTestSuite) TestSpendLimitedRefreshTokenKeepsLimit() {
+	accessToken, _, err := suite.Service.GenerateToken(context.Background(), suite.userLogin.Login, suite.userLogin.Password, "", "")
+	assert.NoError(suite.T(), err)
+	_, limitedRefreshToken, err := suite.Service.CreateSpendLimitedToken(context.Background(), getUserIdFromToken(accessToken), "automation", 100, 1000)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), float64(100), getClaimFromToken(limitedRefreshToken, "max_amount_per_payment"))
+
+	// the refresh token can not pay past the limit as an access token
+	e := echo.New()
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	secured := e.Group("", tokens.Middleware(suite.Service.TokenMiddlewareOptions(tokens.RouteScopes{"POST /payinvoice": tokens.ScopePaymentSend})))
+	secured.POST("/payinvoice", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/payinvoice", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+limitedRefreshToken)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+
+	// the refreshed tokens keep the limit
+	rec, refreshed := suite.authWithRefreshToken(limitedRefreshToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Equal(suite.T(), float64(100), getClaimFromToken(refreshed.AccessToken, "max_amount_per_payment"))
+	assert.Equal(suite.T(), float64(1000), getClaimFromToken(refreshed.AccessToken, "max_amount_per_day"))
+	assert.Equal(suite.T(), float64(100), getClaimFromToken(refreshed.RefreshToken, "max_amount_per_payment"))
+}
+
+func getClaimFromToken(token, claim string) interface{} {
+	parsedToken, _, _ := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	claims, _ := parsedToken.Claims.(jwt.MapClaims)
+	return claims[claim]
+}
+
+func getScopesFromToken(token string) interface{} {
+	parsedToken, _, _ := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	claims, _ := parsedToken.Claims.(jwt.MapClaims)
+	return claims["scopes"]
+}
+
+func (suite *UserAuthTestSuite) TestLogout() {
+	firstAccessToken, firstRefreshToken, err := suite.Service.GenerateToken(context.Background(), suite.userLogin.Login, suite.userLogin.Password, "", "")
+	assert.NoError(suite.T(), err)
+	rec, refreshed := suite.authWithRefreshToken(firstRefreshToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	accessToken, refreshToken := refreshed.AccessToken, refreshed.RefreshToken
+	e := echo.New()
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	secured := e.Group("", tokens.Middleware(suite.Service.TokenMiddlewareOptions(tokens.RouteScopes{})))
+	secured.POST("/auth/logout", controllers.NewAuthController(suite.Service).Logout)
+	secured.GET("/balance", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	request := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(suite.T(), http.StatusOK, request(http.MethodGet, "/balance", firstAccessToken))
+	assert.Equal(suite.T(), http.StatusNoContent, request(http.MethodPost, "/auth/logout", accessToken))
+	// the access token and the refresh token of the session can not be used anymore
+	assert.Equal(suite.T(), http.StatusBadRequest, request(http.MethodPost, "/auth/logout", accessToken))
+	rec, _ = suite.authWithRefreshToken(refreshToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	// neither as bearer tokens, nor the access tokens the session was issued before
+	assert.Equal(suite.T(), http.StatusUnauthorized, request(http.MethodGet, "/balance", refreshToken))
+	assert.Equal(suite.T(), http.StatusBadRequest, request(http.MethodGet, "/balance", firstAccessToken))
+}
+
 func (suite *UserAuthTestSuite) TestAuthWithNotParseableRefreshToken() {
 	var buf bytes.Buffer
 	// login with random not parseable refresh token
@@ -0,0 +1,44 @@
+package integration_tests
+
+import (
+	"context"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *PaymentBookingTestSuite) TestBalanceImportRequiresPublicHttpsHub() {
+	ctx := context.Background()
+	user, _ := suite.fundedUsers(0)
+	claim, err := tokens.GenerateBalanceClaim([]byte("other hub"), 1, 1000, time.Now().Add(time.Hour))
+	assert.NoError(suite.T(), err)
+
+	for _, hubUrl := range []string{"http://hub.example.com", "https://localhost:3000", "https://127.0.0.1", "https://10.0.0.1", "https://169.254.169.254"} {
+		_, err := suite.service.ImportBalance(ctx, user, hubUrl, claim)
+		assert.ErrorIs(suite.T(), err, service.ErrInvalidHubUrl, hubUrl)
+	}
+	// no invoice is created for rejected hubs
+	invoices, err := suite.service.InvoicesFor(ctx, user, common.InvoiceTypeIncoming)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), invoices)
+}
+
+func (suite *PaymentBookingTestSuite) TestFailedBalanceImportCancelsInvoice() {
+	ctx := context.Background()
+	user, _ := suite.fundedUsers(0)
+	claim, err := tokens.GenerateBalanceClaim([]byte("other hub"), 1, 1000, time.Now().Add(time.Hour))
+	assert.NoError(suite.T(), err)
+
+	// .invalid hosts never resolve, the hub can not be reached
+	_, err = suite.service.ImportBalance(ctx, user, "https://hub.invalid", claim)
+	assert.ErrorIs(suite.T(), err, service.ErrBalanceImportFailed)
+
+	// the invoice for the unverified amount of the claim can not be paid anymore
+	invoices, err := suite.service.InvoicesFor(ctx, user, common.InvoiceTypeIncoming)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(invoices))
+	assert.Equal(suite.T(), common.InvoiceStateCanceled, invoices[0].State)
+}
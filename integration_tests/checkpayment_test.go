@@ -65,7 +65,7 @@ func (suite *CheckPaymentTestSuite) SetupSuite() {
 	assert.Equal(suite.T(), 1, len(userTokens))
 	suite.userLogin = users[0]
 	suite.userToken = userTokens[0]
-	suite.echo.Use(tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+	suite.echo.Use(tokens.Middleware(suite.service.TokenMiddlewareOptions(tokens.RouteScopes{})))
 	suite.echo.POST("/addinvoice", controllers.NewAddInvoiceController(suite.service).AddInvoice)
 	suite.echo.POST("/payinvoice", controllers.NewPayInvoiceController(suite.service).PayInvoice)
 	suite.echo.GET("/checkpayment/:payment_hash", controllers.NewCheckPaymentController(suite.service).CheckPayment)
@@ -0,0 +1,114 @@
+package integration_tests
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/uptrace/bun"
+)
+
+// ExpiryAndClaimsTestSuite runs the updates that return the updated rows, they are written differently for MySQL
+// The MySQL job of the integration tests runs it with TEST_DATABASE_URI
+type ExpiryAndClaimsTestSuite struct {
+	suite.Suite
+	service *service.LndhubService
+	stub    *LNDStub
+}
+
+func (suite *ExpiryAndClaimsTestSuite) SetupSuite() {
+	suite.stub = &LNDStub{}
+	svc, err := LndHubTestServiceInit(suite.stub)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	// the claim is paid without retries, its amount leaves the fee reserve of a single attempt
+	svc.Config.PaymentMaxRetries = 0
+	suite.service = svc
+}
+
+func (suite *ExpiryAndClaimsTestSuite) TearDownTest() {
+	suite.stub.SendPayment = nil
+}
+
+func (suite *ExpiryAndClaimsTestSuite) TestExpireInvoices() {
+	ctx := context.Background()
+	_, userTokens, err := createUsers(suite.service, 1)
+	assert.NoError(suite.T(), err)
+	userId := getUserIdFromToken(userTokens[0])
+	invoice := func(rHash string, expiresAt time.Time) *models.Invoice {
+		invoice := &models.Invoice{
+			Type:                 common.InvoiceTypeIncoming,
+			UserID:               userId,
+			Amount:               10,
+			RHash:                rHash,
+			State:                common.InvoiceStateOpen,
+			DestinationPubkeyHex: simnetLnd1PubKey,
+			ExpiresAt:            bun.NullTime{Time: expiresAt},
+		}
+		_, err := suite.service.DB.NewInsert().Model(invoice).Exec(ctx)
+		assert.NoError(suite.T(), err)
+		return invoice
+	}
+	expired := invoice(hex.EncodeToString(randomBytes(32)), time.Now().Add(-time.Minute))
+	open := invoice(hex.EncodeToString(randomBytes(32)), time.Now().Add(time.Hour))
+
+	count, err := suite.service.ExpireInvoices(ctx)
+	assert.NoError(suite.T(), err)
+	assert.GreaterOrEqual(suite.T(), count, 1)
+	for _, check := range []struct {
+		invoice *models.Invoice
+		state   string
+	}{{expired, common.InvoiceStateExpired}, {open, common.InvoiceStateOpen}} {
+		var stored models.Invoice
+		assert.NoError(suite.T(), suite.service.DB.NewSelect().Model(&stored).Where("id = ?", check.invoice.ID).Scan(ctx))
+		assert.Equal(suite.T(), check.state, stored.State)
+	}
+	// expired invoices are not expired again
+	count, err = suite.service.ExpireInvoices(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, count)
+}
+
+func (suite *ExpiryAndClaimsTestSuite) TestRedeemBalanceClaim() {
+	ctx := context.Background()
+	_, userTokens, err := createUsers(suite.service, 1)
+	assert.NoError(suite.T(), err)
+	userId := getUserIdFromToken(userTokens[0])
+	_, err = suite.service.AdjustBalance(ctx, userId, 10000, "test", "funding")
+	assert.NoError(suite.T(), err)
+	claim, token, err := suite.service.ExportBalance(ctx, userId)
+	assert.NoError(suite.T(), err)
+
+	// the invoice of the other hub
+	preimage := randomBytes(32)
+	paymentHash := sha256.Sum256(preimage)
+	suite.stub.SendPayment = func(req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+		return &lnrpc.SendResponse{PaymentPreimage: preimage, PaymentHash: paymentHash[:], PaymentRoute: &lnrpc.Route{TotalAmt: req.Amt}}, nil
+	}
+	payReq := &lnrpc.PayReq{Destination: simnetLnd2PubKey, PaymentHash: hex.EncodeToString(paymentHash[:]), NumSatoshis: claim.Amount}
+
+	_, err = suite.service.RedeemBalanceClaim(ctx, token, "lnbcrtclaim", payReq)
+	assert.NoError(suite.T(), err)
+	var stored models.BalanceClaim
+	assert.NoError(suite.T(), suite.service.DB.NewSelect().Model(&stored).Where("id = ?", claim.ID).Scan(ctx))
+	assert.Equal(suite.T(), common.BalanceClaimStateRedeemed, stored.State)
+	assert.NotZero(suite.T(), stored.InvoiceID)
+
+	// a claim is redeemed once
+	_, err = suite.service.RedeemBalanceClaim(ctx, token, "lnbcrtclaim", payReq)
+	assert.ErrorIs(suite.T(), err, service.ErrInvalidBalanceClaim)
+}
+
+func TestExpiryAndClaimsTestSuite(t *testing.T) {
+	suite.Run(t, new(ExpiryAndClaimsTestSuite))
+}
@@ -47,7 +47,7 @@ func (suite *GetInfoTestSuite) SetupSuite() {
 	assert.Equal(suite.T(), 1, len(userTokens))
 	suite.userLogin = users[0]
 	suite.userToken = userTokens[0]
-	suite.echo.Use(tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+	suite.echo.Use(tokens.Middleware(suite.service.TokenMiddlewareOptions(tokens.RouteScopes{})))
 	suite.echo.GET("/getinfo", controllers.NewGetInfoController(svc).GetInfo)
 }
 
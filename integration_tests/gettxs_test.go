@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/getAlby/lndhub.go/common"
 	"github.com/getAlby/lndhub.go/controllers"
 	"github.com/getAlby/lndhub.go/lib"
 	"github.com/getAlby/lndhub.go/lib/responses"
@@ -61,7 +62,7 @@ func (suite *GetTxTestSuite) SetupSuite() {
 	e.HTTPErrorHandler = responses.HTTPErrorHandler
 	e.Validator = &lib.CustomValidator{Validator: validator.New()}
 	suite.echo = e
-	suite.echo.Use(tokens.Middleware([]byte(suite.Service.Config.JWTSecret)))
+	suite.echo.Use(tokens.Middleware(suite.Service.TokenMiddlewareOptions(tokens.RouteScopes{})))
 	suite.echo.GET("/gettxs", controllers.NewGetTXSController(suite.Service).GetTXS)
 	suite.echo.GET("/getuserinvoices", controllers.NewGetTXSController(svc).GetUserInvoices)
 	suite.echo.POST("/addinvoice", controllers.NewAddInvoiceController(suite.Service).AddInvoice)
@@ -140,3 +141,87 @@ func (suite *GetTxTestSuite) TestGetIncomingInvoices() {
 func TestGetTXsTestSuite(t *testing.T) {
 	suite.Run(t, new(GetTxTestSuite))
 }
+
+// PendingTxTestSuite lists pending transactions, it does not need a lightning node
+type PendingTxTestSuite struct {
+	suite.Suite
+	service *service.LndhubService
+	echo    *echo.Echo
+}
+
+func (suite *PendingTxTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(&LNDStub{})
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	suite.service = svc
+	e := echo.New()
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	e.Use(tokens.Middleware(svc.TokenMiddlewareOptions(tokens.RouteScopes{})))
+	e.GET("/gettxs", controllers.NewGetTXSController(svc).GetTXS)
+	suite.echo = e
+}
+
+func (suite *PendingTxTestSuite) getTxs(token, query string) []controllers.OutgoingInvoice {
+	req := httptest.NewRequest(http.MethodGet, "/gettxs"+query, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rec := httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, req)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	txs := []controllers.OutgoingInvoice{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&txs))
+	return txs
+}
+
+func (suite *PendingTxTestSuite) TestIncludePending() {
+	ctx := context.Background()
+	logins, userTokens, err := createUsers(suite.service, 2)
+	assert.NoError(suite.T(), err)
+	userId := getUserIdFromToken(userTokens[0])
+	_, err = suite.service.AdjustBalance(ctx, userId, 1000, "test", "funding")
+	assert.NoError(suite.T(), err)
+
+	transfer, err := suite.service.AddTransferInvoice(ctx, userId, logins[1].Login, 100, "settled")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, transfer)
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.AddIncomingInvoice(ctx, userId, 200, "open", "", 3600, "", "", "")
+	assert.NoError(suite.T(), err)
+	expired, err := suite.service.AddIncomingInvoice(ctx, userId, 300, "expired", "", 3600, "", "", "")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.DB.NewUpdate().Model(expired).Set("expires_at = ?", time.Now().Add(-time.Minute)).WherePK().Exec(ctx)
+	assert.NoError(suite.T(), err)
+	stuckPayment(suite.T(), suite.service, userId, 40)
+	// created but never paid, the balance was not debited
+	_, err = suite.service.AddTransferInvoice(ctx, userId, logins[1].Login, 50, "unpaid")
+	assert.NoError(suite.T(), err)
+
+	txs := suite.getTxs(userTokens[0], "")
+	assert.Len(suite.T(), txs, 1)
+	assert.Equal(suite.T(), "settled", txs[0].Memo)
+	assert.False(suite.T(), txs[0].Pending)
+
+	txs = suite.getTxs(userTokens[0], "?include_pending=true")
+	pending := map[int64]controllers.OutgoingInvoice{}
+	for _, tx := range txs {
+		if tx.Pending {
+			pending[tx.Value] = tx
+		}
+	}
+	assert.Len(suite.T(), txs, 3)
+	assert.Len(suite.T(), pending, 2)
+	assert.Equal(suite.T(), "open", pending[200].Memo)
+	assert.Equal(suite.T(), common.InvoiceTypeUser, pending[200].Type)
+	assert.Equal(suite.T(), common.InvoiceTypePaid, pending[40].Type)
+
+	// pending transactions are only part of the first page
+	cursor := service.TransactionCursor{CreatedAt: time.Now().Add(time.Hour), ID: transfer.ID + 1}
+	txs = suite.getTxs(userTokens[0], "?include_pending=true&cursor="+cursor.String())
+	assert.Len(suite.T(), txs, 1)
+	assert.False(suite.T(), txs[0].Pending)
+}
+
+func TestPendingTxTestSuite(t *testing.T) {
+	suite.Run(t, new(PendingTxTestSuite))
+}
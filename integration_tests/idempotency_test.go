@@ -0,0 +1,177 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/controllers"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/uptrace/bun"
+)
+
+type IdempotencyTestSuite struct {
+	suite.Suite
+	service *service.LndhubService
+	stub    *LNDStub
+	echo    *echo.Echo
+}
+
+func (suite *IdempotencyTestSuite) SetupSuite() {
+	suite.stub = &LNDStub{}
+	svc, err := LndHubTestServiceInit(suite.stub)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	suite.service = svc
+	e := echo.New()
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	secured := e.Group("", tokens.Middleware(svc.TokenMiddlewareOptions(tokens.RouteScopes{})))
+	idempotencyMiddleware := controllers.IdempotencyMiddleware(svc)
+	secured.POST("/v2/transfer", controllers.NewPayInvoiceController(svc).Transfer, idempotencyMiddleware)
+	secured.POST("/keysend", controllers.NewKeySendController(svc).KeySend, idempotencyMiddleware)
+	suite.echo = e
+}
+
+func (suite *IdempotencyTestSuite) TearDownTest() {
+	suite.stub.SendPayment = nil
+}
+
+// fundedUser creates a user with the balance and a recipient for transfers
+func (suite *IdempotencyTestSuite) fundedUser(balance int64) (userId int64, token, recipientLogin string) {
+	logins, userTokens, err := createUsers(suite.service, 2)
+	assert.NoError(suite.T(), err)
+	userId = getUserIdFromToken(userTokens[0])
+	_, err = suite.service.AdjustBalance(context.Background(), userId, balance, "test", "funding")
+	assert.NoError(suite.T(), err)
+	return userId, userTokens[0], logins[1].Login
+}
+
+func (suite *IdempotencyTestSuite) post(path, token, key string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	req := httptest.NewRequest(http.MethodPost, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	req.Header.Set(controllers.IdempotencyKeyHeader, key)
+	rec := httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func (suite *IdempotencyTestSuite) TestReplayTransfer() {
+	userId, token, recipient := suite.fundedUser(100)
+	body := &controllers.TransferRequestBody{Login: recipient, Amount: 10}
+
+	first := suite.post("/v2/transfer", token, "transfer", body)
+	assert.Equal(suite.T(), http.StatusOK, first.Code)
+	replayed := suite.post("/v2/transfer", token, "transfer", body)
+	assert.Equal(suite.T(), http.StatusOK, replayed.Code)
+	assert.Equal(suite.T(), "true", replayed.Header().Get(controllers.IdempotentReplayedHeader))
+	assert.Equal(suite.T(), first.Body.String(), replayed.Body.String())
+	balance, err := suite.service.CurrentUserBalance(context.Background(), userId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(90), balance)
+
+	// the key can not be used for another request
+	mismatch := suite.post("/v2/transfer", token, "transfer", &controllers.TransferRequestBody{Login: recipient, Amount: 20})
+	assert.Equal(suite.T(), http.StatusUnprocessableEntity, mismatch.Code)
+}
+
+func (suite *IdempotencyTestSuite) TestReplayFailedPayment() {
+	// the balance covers the payment and its fee reserve
+	userId, token, _ := suite.fundedUser(2000)
+	payments := 0
+	suite.stub.SendPayment = func(req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+		payments++
+		return nil, errors.New("payment failed")
+	}
+	body := &controllers.KeySendRequestBody{Amount: 10, Destination: simnetLnd2PubKey}
+
+	first := suite.post("/keysend", token, "keysend", body)
+	assert.NotEqual(suite.T(), http.StatusOK, first.Code)
+	replayed := suite.post("/keysend", token, "keysend", body)
+	assert.Equal(suite.T(), first.Code, replayed.Code)
+	assert.Equal(suite.T(), "true", replayed.Header().Get(controllers.IdempotentReplayedHeader))
+	assert.Equal(suite.T(), first.Body.String(), replayed.Body.String())
+	// the payment was sent to the node once and refunded
+	assert.Equal(suite.T(), 1, payments)
+	balance, err := suite.service.CurrentUserBalance(context.Background(), userId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(2000), balance)
+}
+
+func (suite *IdempotencyTestSuite) TestPendingKeyLease() {
+	ctx := context.Background()
+	userId, token, recipient := suite.fundedUser(100)
+	body := &controllers.TransferRequestBody{Login: recipient, Amount: 10}
+	encoded, err := json.Marshal(body)
+	assert.NoError(suite.T(), err)
+	requestHash := service.IdempotencyRequestHash(http.MethodPost, "/v2/transfer", append(encoded, '\n'))
+
+	// a request that is still running holds its key
+	_, err = suite.service.DB.NewInsert().Model(&models.IdempotencyKey{UserID: userId, Key: "running", RequestHash: requestHash}).Exec(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusConflict, suite.post("/v2/transfer", token, "running", body).Code)
+
+	// the key of a request that did not finish within the lease is released
+	_, err = suite.service.DB.NewInsert().Model(&models.IdempotencyKey{UserID: userId, Key: "stale", RequestHash: requestHash, CreatedAt: time.Now().Add(-time.Hour)}).Exec(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, suite.post("/v2/transfer", token, "stale", body).Code)
+}
+
+func (suite *IdempotencyTestSuite) TestKeyOfBookedPaymentIsNotReleased() {
+	ctx := context.Background()
+	userId, token, recipient := suite.fundedUser(100)
+	body := &controllers.TransferRequestBody{Login: recipient, Amount: 10}
+	encoded, err := json.Marshal(body)
+	assert.NoError(suite.T(), err)
+	requestHash := service.IdempotencyRequestHash(http.MethodPost, "/v2/transfer", append(encoded, '\n'))
+
+	// a slow request that booked its payment holds the key past the lease, its payment may still be in flight
+	invoice, err := suite.service.AddTransferInvoice(ctx, userId, recipient, 10, "")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.DB.NewInsert().Model(&models.IdempotencyKey{UserID: userId, Key: "slow", RequestHash: requestHash, InvoiceID: invoice.ID, CreatedAt: time.Now().Add(-time.Hour)}).Exec(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusConflict, suite.post("/v2/transfer", token, "slow", body).Code)
+	balance, err := suite.service.CurrentUserBalance(ctx, userId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(90), balance)
+}
+
+func (suite *IdempotencyTestSuite) TestInvoiceIsLinkedBeforePaymentIsSent() {
+	ctx := context.Background()
+	userId, token, _ := suite.fundedUser(2000)
+	var pending models.IdempotencyKey
+	suite.stub.SendPayment = func(req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+		err := suite.service.DB.NewSelect().Model(&pending).Where("user_id = ? AND ? = ?", userId, bun.Ident("key"), "in-flight").Scan(ctx)
+		assert.NoError(suite.T(), err)
+		return nil, errors.New("payment failed")
+	}
+	suite.post("/keysend", token, "in-flight", &controllers.KeySendRequestBody{Amount: 10, Destination: simnetLnd2PubKey})
+	// while the payment is sent the key already knows its invoice
+	assert.NotZero(suite.T(), pending.InvoiceID)
+	assert.Zero(suite.T(), pending.StatusCode)
+}
+
+func TestIdempotencyTestSuite(t *testing.T) {
+	suite.Run(t, new(IdempotencyTestSuite))
+}
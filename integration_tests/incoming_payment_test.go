@@ -77,7 +77,7 @@ func (suite *IncomingPaymentTestSuite) TestIncomingPayment() {
 	req := httptest.NewRequest(http.MethodGet, "/balance", &buf)
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", suite.userToken))
 	rec := httptest.NewRecorder()
-	suite.echo.Use(tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+	suite.echo.Use(tokens.Middleware(suite.service.TokenMiddlewareOptions(tokens.RouteScopes{})))
 	suite.echo.GET("/balance", controllers.NewBalanceController(suite.service).Balance)
 	suite.echo.POST("/addinvoice", controllers.NewAddInvoiceController(suite.service).AddInvoice)
 	suite.echo.ServeHTTP(rec, req)
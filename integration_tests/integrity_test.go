@@ -0,0 +1,69 @@
+package integration_tests
+
+import (
+	"context"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *PaymentBookingTestSuite) TestFeeReserveSettlementHasNoIntegrityFindings() {
+	ctx := context.Background()
+	sender, _ := suite.fundedUsers(10000)
+	stub := suite.service.LndClient.(*LNDStub)
+	stub.SendPayment = func(req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+		return &lnrpc.SendResponse{
+			PaymentPreimage: req.DestCustomRecords[service.KEYSEND_CUSTOM_RECORD],
+			PaymentHash:     req.PaymentHash,
+			PaymentRoute:    &lnrpc.Route{TotalAmt: req.Amt + 1, TotalFees: 1, TotalFeesMsat: 1000},
+		}, nil
+	}
+	defer func() { stub.SendPayment = nil }()
+
+	invoice, err := suite.service.AddOutgoingInvoice(ctx, sender, "", &lnd.LNPayReq{
+		PayReq:  &lnrpc.PayReq{Destination: simnetLnd2PubKey, NumSatoshis: 10},
+		Keysend: true,
+	})
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+
+	// the fee is taken from the reserve in the in-flight account and the rest of the reserve is refunded
+	booked := models.Invoice{}
+	assert.NoError(suite.T(), suite.service.DB.NewSelect().Model(&booked).Where("id = ?", invoice.ID).Scan(ctx))
+	assert.Equal(suite.T(), int64(1), booked.Fee)
+	assert.Greater(suite.T(), booked.FeeReserve, booked.Fee)
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(9989), balance)
+
+	findings, err := suite.service.FindIntegrityViolations(ctx)
+	assert.NoError(suite.T(), err)
+	for _, finding := range findings {
+		assert.NotEqual(suite.T(), sender, finding.UserID, "unexpected %s finding for entry %d", finding.Kind, finding.TransactionEntryID)
+	}
+}
+
+func (suite *PaymentBookingTestSuite) TestFrozenUserCanNotUseAPIKeysOrExportBalance() {
+	ctx := context.Background()
+	user, _ := suite.fundedUsers(10000)
+	_, apiKey, err := suite.service.CreateAPIKey(ctx, user, "test", tokens.ReadOnlyScopes, 0, 0)
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.AuthenticateAPIKey(ctx, apiKey)
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), suite.service.FreezeUser(ctx, user, "test"))
+	_, err = suite.service.AuthenticateAPIKey(ctx, apiKey)
+	assert.Error(suite.T(), err)
+	_, _, err = suite.service.ExportBalance(ctx, user)
+	assert.ErrorIs(suite.T(), err, service.ErrAccountFrozen)
+
+	// the api keys work again after the freeze was lifted
+	assert.NoError(suite.T(), suite.service.UnfreezeUser(ctx, user, "test"))
+	_, err = suite.service.AuthenticateAPIKey(ctx, apiKey)
+	assert.NoError(suite.T(), err)
+}
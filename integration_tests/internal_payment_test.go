@@ -67,10 +67,11 @@ func (suite *PaymentTestSuite) SetupSuite() {
 	suite.aliceToken = userTokens[0]
 	suite.bobLogin = users[1]
 	suite.bobToken = userTokens[1]
-	suite.echo.Use(tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+	suite.echo.Use(tokens.Middleware(suite.service.TokenMiddlewareOptions(tokens.RouteScopes{})))
 	suite.echo.GET("/balance", controllers.NewBalanceController(suite.service).Balance)
 	suite.echo.POST("/addinvoice", controllers.NewAddInvoiceController(suite.service).AddInvoice)
 	suite.echo.POST("/payinvoice", controllers.NewPayInvoiceController(suite.service).PayInvoice)
+	suite.echo.POST("/payinvoice/bulk", controllers.NewPayInvoiceController(suite.service).BulkPayInvoice)
 }
 
 func (suite *PaymentTestSuite) TearDownSuite() {
@@ -117,11 +118,13 @@ func (suite *PaymentTestSuite) TestInternalPayment() {
 
 	transactonEntriesAlice, _ := suite.service.TransactionEntriesFor(context.Background(), aliceId)
 	aliceBalance, _ := suite.service.CurrentUserBalance(context.Background(), aliceId)
-	assert.Equal(suite.T(), 3, len(transactonEntriesAlice))
+	assert.Equal(suite.T(), 4, len(transactonEntriesAlice))
 	assert.Equal(suite.T(), int64(aliceFundingSats), transactonEntriesAlice[0].Amount)
 	assert.Equal(suite.T(), int64(bobSatRequested), transactonEntriesAlice[1].Amount)
 	assert.Equal(suite.T(), int64(fee), transactonEntriesAlice[2].Amount)
 	assert.Equal(suite.T(), transactonEntriesAlice[1].ID, transactonEntriesAlice[2].ParentID)
+	assert.Equal(suite.T(), int64(bobSatRequested), transactonEntriesAlice[3].Amount)
+	assert.Equal(suite.T(), transactonEntriesAlice[1].ID, transactonEntriesAlice[3].ParentID)
 	assert.Equal(suite.T(), int64(aliceFundingSats-bobSatRequested-fee), aliceBalance)
 
 	bobBalance, _ := suite.service.CurrentUserBalance(context.Background(), bobId)
@@ -176,19 +179,47 @@ func (suite *PaymentTestSuite) TestInternalPaymentFail() {
 		fmt.Printf("Error when getting balance %v\n", err.Error())
 	}
 
-	// check if there are 5 transaction entries, with reversed credit and debit account ids for last 2
-	assert.Equal(suite.T(), 5, len(transactonEntries))
+	// check if there are 6 transaction entries, with reversed credit and debit account ids for last 2
+	assert.Equal(suite.T(), 6, len(transactonEntries))
 	assert.Equal(suite.T(), int64(aliceFundingSats), transactonEntries[0].Amount)
 	assert.Equal(suite.T(), int64(bobSatRequested), transactonEntries[1].Amount)
 	assert.Equal(suite.T(), int64(fee), transactonEntries[2].Amount)
-	assert.Equal(suite.T(), transactonEntries[3].CreditAccountID, transactonEntries[4].DebitAccountID)
-	assert.Equal(suite.T(), transactonEntries[3].DebitAccountID, transactonEntries[4].CreditAccountID)
-	assert.Equal(suite.T(), transactonEntries[3].Amount, int64(bobSatRequested))
+	assert.Equal(suite.T(), int64(bobSatRequested), transactonEntries[3].Amount)
+	assert.Equal(suite.T(), transactonEntries[4].CreditAccountID, transactonEntries[5].DebitAccountID)
+	assert.Equal(suite.T(), transactonEntries[4].DebitAccountID, transactonEntries[5].CreditAccountID)
 	assert.Equal(suite.T(), transactonEntries[4].Amount, int64(bobSatRequested))
+	assert.Equal(suite.T(), transactonEntries[5].Amount, int64(bobSatRequested))
 	// assert that balance was reduced only once
 	assert.Equal(suite.T(), int64(aliceFundingSats)-int64(bobSatRequested+fee), int64(aliceBalance))
 }
 
+func (suite *PaymentTestSuite) TestInternalPaymentToSelf() {
+	aliceFundingSats := 1000
+	//fund alice account
+	invoiceResponse := suite.createAddInvoiceReq(aliceFundingSats, "integration test internal payment alice", suite.aliceToken)
+	sendPaymentRequest := lnrpc.SendRequest{
+		PaymentRequest: invoiceResponse.PayReq,
+		FeeLimit:       nil,
+	}
+	_, err := suite.fundingClient.SendPaymentSync(context.Background(), &sendPaymentRequest)
+	assert.NoError(suite.T(), err)
+
+	//wait a bit for the callback event to hit
+	time.Sleep(100 * time.Millisecond)
+
+	//alice tries to pay her own invoice
+	aliceInvoice := suite.createAddInvoiceReq(500, "integration test internal payment alice to self", suite.aliceToken)
+	errorResp := suite.createPayInvoiceReqError(aliceInvoice.PayReq, suite.aliceToken)
+	assert.Equal(suite.T(), responses.SelfPaymentError.Code, errorResp.Code)
+
+	//no funds were moved
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	transactonEntriesAlice, _ := suite.service.TransactionEntriesFor(context.Background(), aliceId)
+	aliceBalance, _ := suite.service.CurrentUserBalance(context.Background(), aliceId)
+	assert.Equal(suite.T(), 1, len(transactonEntriesAlice))
+	assert.Equal(suite.T(), int64(aliceFundingSats), aliceBalance)
+}
+
 func TestInternalPaymentTestSuite(t *testing.T) {
 	suite.Run(t, new(PaymentTestSuite))
 }
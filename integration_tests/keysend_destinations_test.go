@@ -0,0 +1,158 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/controllers"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// KeysendDestinationsTestSuite pays saved keysend destinations, it does not need a lightning node
+type KeysendDestinationsTestSuite struct {
+	suite.Suite
+	service  *service.LndhubService
+	stub     *LNDStub
+	echo     *echo.Echo
+	requests []*lnrpc.SendRequest
+}
+
+func (suite *KeysendDestinationsTestSuite) SetupSuite() {
+	suite.stub = &LNDStub{}
+	svc, err := LndHubTestServiceInit(suite.stub)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	suite.service = svc
+	e := echo.New()
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	e.Use(tokens.Middleware(svc.TokenMiddlewareOptions(tokens.RouteScopes{})))
+	destinationsController := controllers.NewKeysendDestinationsController(svc)
+	e.GET("/keysend/destinations", destinationsController.GetDestinations)
+	e.POST("/keysend/destinations", destinationsController.SaveDestination)
+	e.DELETE("/keysend/destinations/:name", destinationsController.DeleteDestination)
+	e.POST("/keysend", controllers.NewKeySendController(svc).KeySend)
+	suite.echo = e
+}
+
+func (suite *KeysendDestinationsTestSuite) SetupTest() {
+	suite.requests = nil
+	suite.stub.SendPayment = func(req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+		suite.requests = append(suite.requests, req)
+		return &lnrpc.SendResponse{
+			PaymentPreimage: req.DestCustomRecords[service.KEYSEND_CUSTOM_RECORD],
+			PaymentHash:     req.PaymentHash,
+			PaymentRoute:    &lnrpc.Route{TotalAmt: req.Amt + 1, TotalFees: 1},
+		}, nil
+	}
+}
+
+func (suite *KeysendDestinationsTestSuite) TearDownTest() {
+	suite.stub.SendPayment = nil
+}
+
+func (suite *KeysendDestinationsTestSuite) request(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	rec := httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func (suite *KeysendDestinationsTestSuite) destinations(token string) []controllers.KeysendDestinationResponseBody {
+	rec := suite.request(http.MethodGet, "/keysend/destinations", token, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	destinations := []controllers.KeysendDestinationResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&destinations))
+	return destinations
+}
+
+func (suite *KeysendDestinationsTestSuite) TestPaySavedDestination() {
+	_, userTokens, err := createUsers(suite.service, 2)
+	assert.NoError(suite.T(), err)
+	token := userTokens[0]
+	// the balance covers the payment and its fee reserve
+	_, err = suite.service.AdjustBalance(context.Background(), getUserIdFromToken(token), 2000, "test", "funding")
+	assert.NoError(suite.T(), err)
+
+	rec := suite.request(http.MethodPost, "/keysend/destinations", token, &controllers.SaveKeysendDestinationRequestBody{
+		Name:        "podcast",
+		Destination: simnetLnd2PubKey,
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	// saving the name again updates the destination
+	rec = suite.request(http.MethodPost, "/keysend/destinations", token, &controllers.SaveKeysendDestinationRequestBody{
+		Name:          "podcast",
+		Destination:   simnetLnd3PubKey,
+		CustomRecords: map[string]string{"696969": "default", "7629169": "episode"},
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	destinations := suite.destinations(token)
+	assert.Len(suite.T(), destinations, 1)
+	assert.Equal(suite.T(), simnetLnd3PubKey, destinations[0].Destination)
+
+	// the records of the request take precedence over the saved ones
+	rec = suite.request(http.MethodPost, "/keysend", token, &controllers.KeySendRequestBody{
+		Amount:          100,
+		DestinationName: "podcast",
+		CustomRecords:   map[string]string{"696969": "override"},
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Len(suite.T(), suite.requests, 1)
+	assert.Equal(suite.T(), simnetLnd3PubKey, fmt.Sprintf("%x", suite.requests[0].Dest))
+	assert.Equal(suite.T(), []byte("override"), suite.requests[0].DestCustomRecords[696969])
+	assert.Equal(suite.T(), []byte("episode"), suite.requests[0].DestCustomRecords[7629169])
+
+	destinations = suite.destinations(token)
+	assert.Equal(suite.T(), int64(1), destinations[0].PaymentCount)
+	assert.Equal(suite.T(), int64(100), destinations[0].TotalAmount)
+	assert.Equal(suite.T(), int64(1), destinations[0].TotalFees)
+
+	// saved destinations are private to the user
+	assert.Empty(suite.T(), suite.destinations(userTokens[1]))
+	rec = suite.request(http.MethodPost, "/keysend", userTokens[1], &controllers.KeySendRequestBody{Amount: 100, DestinationName: "podcast"})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	assert.Len(suite.T(), suite.requests, 1)
+
+	rec = suite.request(http.MethodDelete, "/keysend/destinations/podcast", token, nil)
+	assert.Equal(suite.T(), http.StatusNoContent, rec.Code)
+	assert.Empty(suite.T(), suite.destinations(token))
+	rec = suite.request(http.MethodPost, "/keysend", token, &controllers.KeySendRequestBody{Amount: 100, DestinationName: "podcast"})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+func (suite *KeysendDestinationsTestSuite) TestInvalidDestinationIsNotSaved() {
+	_, userTokens, err := createUsers(suite.service, 1)
+	assert.NoError(suite.T(), err)
+
+	rec := suite.request(http.MethodPost, "/keysend/destinations", userTokens[0], &controllers.SaveKeysendDestinationRequestBody{
+		Name:        "typo",
+		Destination: "025c1d5d",
+	})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	assert.Empty(suite.T(), suite.destinations(userTokens[0]))
+}
+
+func TestKeysendDestinationsTestSuite(t *testing.T) {
+	suite.Run(t, new(KeysendDestinationsTestSuite))
+}
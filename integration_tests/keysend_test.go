@@ -1,13 +1,19 @@
 package integration_tests
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/getAlby/lndhub.go/common"
 	"github.com/getAlby/lndhub.go/controllers"
+	"github.com/getAlby/lndhub.go/db/models"
 	"github.com/getAlby/lndhub.go/lib"
 	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/getAlby/lndhub.go/lib/service"
@@ -62,11 +68,12 @@ func (suite *KeySendTestSuite) SetupSuite() {
 	assert.Equal(suite.T(), 1, len(userTokens))
 	suite.aliceLogin = users[0]
 	suite.aliceToken = userTokens[0]
-	suite.echo.Use(tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+	suite.echo.Use(tokens.Middleware(suite.service.TokenMiddlewareOptions(tokens.RouteScopes{})))
 	suite.echo.GET("/balance", controllers.NewBalanceController(suite.service).Balance)
 	suite.echo.POST("/addinvoice", controllers.NewAddInvoiceController(suite.service).AddInvoice)
 	suite.echo.POST("/payinvoice", controllers.NewPayInvoiceController(suite.service).PayInvoice)
 	suite.echo.POST("/keysend", controllers.NewKeySendController(suite.service).KeySend)
+	suite.echo.POST("/keysend/multi", controllers.NewKeySendController(suite.service).MultiKeySend)
 }
 
 func (suite *KeySendTestSuite) TearDownTest() {
@@ -79,7 +86,8 @@ func (suite *KeySendTestSuite) TearDownSuite() {
 }
 
 func (suite *KeySendTestSuite) TestKeysendPayment() {
-	aliceFundingSats := 1000
+	// the balance covers the payment and its fee reserve
+	aliceFundingSats := 2000
 	externalSatRequested := 500
 	// 1 sat + 1 ppm
 	fee := 1
@@ -124,6 +132,67 @@ func (suite *KeySendTestSuite) TestKeysendPaymentNonExistentDestination() {
 	suite.createKeySendReqError(int64(externalSatRequested), "key send test", "12345", suite.aliceToken)
 }
 
+func (suite *KeySendTestSuite) TestKeysendInvalidRoutingConstraintsCreateNoInvoice() {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	// ALLOW_ROUTING_CONSTRAINTS is not enabled
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(&controllers.KeySendRequestBody{
+		Amount:         10,
+		Destination:    simnetLnd3PubKey,
+		OutgoingChanId: 12345,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/keysend", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", suite.aliceToken))
+	suite.echo.ServeHTTP(rec, req)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	invoices, err := suite.service.DB.NewSelect().Model((*models.Invoice)(nil)).
+		Where("user_id = ? AND type = ?", getUserIdFromToken(suite.aliceToken), common.InvoiceTypeOutgoing).
+		Count(context.Background())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, invoices)
+}
+
+func (suite *KeySendTestSuite) TestMultiKeysendPayment() {
+	// the balance covers the payment and its fee reserve
+	aliceFundingSats := 2000
+	externalSatRequested := 200
+	//fund alice account
+	invoiceResponse := suite.createAddInvoiceReq(aliceFundingSats, "integration test external payment alice", suite.aliceToken)
+	sendPaymentRequest := lnrpc.SendRequest{
+		PaymentRequest: invoiceResponse.PayReq,
+		FeeLimit:       nil,
+	}
+	_, err := suite.fundingClient.SendPaymentSync(context.Background(), &sendPaymentRequest)
+	assert.NoError(suite.T(), err)
+
+	//wait a bit for the callback event to hit
+	time.Sleep(100 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(&controllers.MultiKeySendRequestBody{
+		Keysends: []controllers.KeySendRequestBody{
+			{Amount: int64(externalSatRequested), Destination: simnetLnd3PubKey, Memo: "multi key send test"},
+			{Amount: int64(externalSatRequested), Destination: "12345", Memo: "multi key send test"},
+		},
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/keysend/multi", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", suite.aliceToken))
+	suite.echo.ServeHTTP(rec, req)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	multiKeySendResponse := &controllers.MultiKeySendResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(multiKeySendResponse))
+	assert.Equal(suite.T(), 2, len(multiKeySendResponse.Keysends))
+	assert.NotNil(suite.T(), multiKeySendResponse.Keysends[0].Keysend)
+	assert.Nil(suite.T(), multiKeySendResponse.Keysends[0].Error)
+	assert.Nil(suite.T(), multiKeySendResponse.Keysends[1].Keysend)
+	assert.NotNil(suite.T(), multiKeySendResponse.Keysends[1].Error)
+}
+
 func TestKeySendTestSuite(t *testing.T) {
 	suite.Run(t, new(KeySendTestSuite))
 }
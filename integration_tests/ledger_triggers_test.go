@@ -0,0 +1,80 @@
+package integration_tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun/dialect"
+)
+
+// The ledger triggers of the PostgreSQL migrations, CockroachDB gets its own form of them
+func TestLedgerTriggers(t *testing.T) {
+	svc, err := LndHubTestServiceInit(&LNDStub{})
+	assert.NoError(t, err)
+	if svc.DB.Dialect().Name() != dialect.PG {
+		t.Skip("the ledger triggers are installed on PostgreSQL")
+	}
+	ctx := context.Background()
+	var version string
+	assert.NoError(t, svc.DB.QueryRowContext(ctx, "SELECT version()").Scan(&version))
+	if strings.Contains(version, "CockroachDB") {
+		t.Skip("CockroachDB has no constraint triggers")
+	}
+
+	rows, err := svc.DB.QueryContext(ctx, "SELECT tgname, tgdeferrable FROM pg_trigger WHERE tgrelid = 'transaction_entries'::regclass AND NOT tgisinternal")
+	assert.NoError(t, err)
+	defer rows.Close()
+	deferrable := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var isDeferrable bool
+		assert.NoError(t, rows.Scan(&name, &isDeferrable))
+		deferrable[name] = isDeferrable
+	}
+	assert.NoError(t, rows.Err())
+	// the balance is checked at commit, after all entries of the transaction are booked
+	assert.Contains(t, deferrable, "check_balance")
+	assert.True(t, deferrable["check_balance"])
+	assert.Contains(t, deferrable, "update_account_balances")
+	assert.Contains(t, deferrable, "check_entry_assets")
+
+	// the adjustments account goes negative when a user is credited
+	_, userTokens, err := createUsers(svc, 1)
+	assert.NoError(t, err)
+	userId := getUserIdFromToken(userTokens[0])
+	_, err = svc.AdjustBalance(ctx, userId, 100, "test", "credit")
+	assert.NoError(t, err)
+	// the current account can not
+	_, err = svc.DB.ExecContext(ctx, `INSERT INTO transaction_entries (user_id, invoice_id, credit_account_id, debit_account_id, amount)
+		SELECT entry.user_id, entry.invoice_id, entry.debit_account_id, entry.credit_account_id, 1000
+		FROM transaction_entries AS entry WHERE entry.user_id = ? ORDER BY entry.id DESC LIMIT 1`, userId)
+	assert.Error(t, err)
+}
+
+// Invoices with transaction entries can not be deleted, the ledger keeps every entry
+func TestStrictForeignKeys(t *testing.T) {
+	svc, err := LndHubTestServiceInit(&LNDStub{})
+	assert.NoError(t, err)
+	if svc.DB.Dialect().Name() != dialect.PG {
+		t.Skip("the foreign keys of the ledger are added on PostgreSQL")
+	}
+	ctx := context.Background()
+	_, userTokens, err := createUsers(svc, 1)
+	assert.NoError(t, err)
+	invoice, err := svc.AdjustBalance(ctx, getUserIdFromToken(userTokens[0]), 100, "test", "credit")
+	assert.NoError(t, err)
+
+	_, err = svc.DB.ExecContext(ctx, "DELETE FROM invoices WHERE id = ?", invoice.ID)
+	assert.Error(t, err)
+	entries, err := svc.DB.NewSelect().Table("transaction_entries").Where("invoice_id = ?", invoice.ID).Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, entries)
+
+	// rows that broke the constraints when they were added were moved to the quarantine tables
+	for _, table := range []string{"quarantined_invoices", "quarantined_transaction_entries"} {
+		_, err := svc.DB.NewSelect().Table(table).Count(ctx)
+		assert.NoError(t, err, table)
+	}
+}
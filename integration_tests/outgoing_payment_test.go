@@ -1,20 +1,30 @@
 package integration_tests
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"time"
 
 	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/controllers"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/labstack/echo/v4"
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/stretchr/testify/assert"
 )
 
 func (suite *PaymentTestSuite) TestOutGoingPayment() {
-	aliceFundingSats := 1000
+	// the balance covers the payment and the fee reserve
+	aliceFundingSats := 2000
 	externalSatRequested := 500
 	// 1 sat + 1 ppm
 	fee := 1
+	// the fee limit of the last attempt: 300 sats doubled for each of the 2 retries
+	feeReserve := 1200
 	//fund alice account
 	invoiceResponse := suite.createAddInvoiceReq(aliceFundingSats, "integration test external payment alice", suite.aliceToken)
 	sendPaymentRequest := lnrpc.SendRequest{
@@ -38,7 +48,7 @@ func (suite *PaymentTestSuite) TestOutGoingPayment() {
 	payResponse := suite.createPayInvoiceReq(invoice.PaymentRequest, suite.aliceToken)
 	assert.NotEmpty(suite.T(), payResponse.PaymentPreimage)
 
-	// check that balance was reduced
+	// check that balance was reduced by the amount and the routing fee, the unused fee reserve is refunded
 	userId := getUserIdFromToken(suite.aliceToken)
 	aliceBalance, err := suite.service.CurrentUserBalance(context.Background(), userId)
 	if err != nil {
@@ -46,7 +56,6 @@ func (suite *PaymentTestSuite) TestOutGoingPayment() {
 	}
 	assert.Equal(suite.T(), int64(aliceFundingSats)-int64(externalSatRequested+fee), aliceBalance)
 
-	// check that no additional transaction entry was created
 	transactonEntries, err := suite.service.TransactionEntriesFor(context.Background(), userId)
 	if err != nil {
 		fmt.Printf("Error when getting transaction entries %v\n", err.Error())
@@ -54,6 +63,7 @@ func (suite *PaymentTestSuite) TestOutGoingPayment() {
 	// verify transaction entries data
 	feeAccount, _ := suite.service.AccountFor(context.Background(), common.AccountTypeFees, userId)
 	incomingAccount, _ := suite.service.AccountFor(context.Background(), common.AccountTypeIncoming, userId)
+	inFlightAccount, _ := suite.service.AccountFor(context.Background(), common.AccountTypeInFlight, userId)
 	outgoingAccount, _ := suite.service.AccountFor(context.Background(), common.AccountTypeOutgoing, userId)
 	currentAccount, _ := suite.service.AccountFor(context.Background(), common.AccountTypeCurrent, userId)
 
@@ -61,8 +71,10 @@ func (suite *PaymentTestSuite) TestOutGoingPayment() {
 	incomingInvoices, _ := suite.service.InvoicesFor(context.Background(), userId, common.InvoiceTypeIncoming)
 	assert.Equal(suite.T(), 1, len(outgoingInvoices))
 	assert.Equal(suite.T(), 1, len(incomingInvoices))
+	assert.Equal(suite.T(), int64(feeReserve), outgoingInvoices[0].FeeReserve)
+	assert.Equal(suite.T(), int64(fee), outgoingInvoices[0].Fee)
 
-	assert.Equal(suite.T(), 3, len(transactonEntries))
+	assert.Equal(suite.T(), 6, len(transactonEntries))
 
 	assert.Equal(suite.T(), int64(aliceFundingSats), transactonEntries[0].Amount)
 	assert.Equal(suite.T(), currentAccount.ID, transactonEntries[0].CreditAccountID)
@@ -71,26 +83,43 @@ func (suite *PaymentTestSuite) TestOutGoingPayment() {
 	assert.Equal(suite.T(), incomingInvoices[0].ID, transactonEntries[0].InvoiceID)
 
 	assert.Equal(suite.T(), int64(externalSatRequested), transactonEntries[1].Amount)
-	assert.Equal(suite.T(), outgoingAccount.ID, transactonEntries[1].CreditAccountID)
+	assert.Equal(suite.T(), inFlightAccount.ID, transactonEntries[1].CreditAccountID)
 	assert.Equal(suite.T(), currentAccount.ID, transactonEntries[1].DebitAccountID)
 	assert.Equal(suite.T(), int64(0), transactonEntries[1].ParentID)
 	assert.Equal(suite.T(), outgoingInvoices[0].ID, transactonEntries[1].InvoiceID)
 
-	assert.Equal(suite.T(), int64(fee), transactonEntries[2].Amount)
-	assert.Equal(suite.T(), feeAccount.ID, transactonEntries[2].CreditAccountID)
+	// the fee reserve is moved to the in-flight account together with the amount
+	assert.Equal(suite.T(), int64(feeReserve), transactonEntries[2].Amount)
+	assert.Equal(suite.T(), inFlightAccount.ID, transactonEntries[2].CreditAccountID)
 	assert.Equal(suite.T(), currentAccount.ID, transactonEntries[2].DebitAccountID)
+	assert.Equal(suite.T(), transactonEntries[1].ID, transactonEntries[2].ParentID)
 	assert.Equal(suite.T(), outgoingInvoices[0].ID, transactonEntries[2].InvoiceID)
 
-	// make sure fee entry parent id is previous entry
-	assert.Equal(suite.T(), transactonEntries[1].ID, transactonEntries[2].ParentID)
+	// the unused part of the reserve is refunded to the current account
+	assert.Equal(suite.T(), int64(feeReserve-fee), transactonEntries[3].Amount)
+	assert.Equal(suite.T(), currentAccount.ID, transactonEntries[3].CreditAccountID)
+	assert.Equal(suite.T(), inFlightAccount.ID, transactonEntries[3].DebitAccountID)
+	assert.Equal(suite.T(), transactonEntries[1].ID, transactonEntries[3].ParentID)
+	assert.Equal(suite.T(), outgoingInvoices[0].ID, transactonEntries[3].InvoiceID)
+
+	// the routing fee is taken from the reserve
+	assert.Equal(suite.T(), int64(fee), transactonEntries[4].Amount)
+	assert.Equal(suite.T(), feeAccount.ID, transactonEntries[4].CreditAccountID)
+	assert.Equal(suite.T(), inFlightAccount.ID, transactonEntries[4].DebitAccountID)
+	assert.Equal(suite.T(), transactonEntries[1].ID, transactonEntries[4].ParentID)
+	assert.Equal(suite.T(), outgoingInvoices[0].ID, transactonEntries[4].InvoiceID)
+
+	// the settled amount is moved from the in-flight to the outgoing account
+	assert.Equal(suite.T(), int64(externalSatRequested), transactonEntries[5].Amount)
+	assert.Equal(suite.T(), outgoingAccount.ID, transactonEntries[5].CreditAccountID)
+	assert.Equal(suite.T(), inFlightAccount.ID, transactonEntries[5].DebitAccountID)
+	assert.Equal(suite.T(), transactonEntries[1].ID, transactonEntries[5].ParentID)
 }
 
-func (suite *PaymentTestSuite) TestOutGoingPaymentWithNegativeBalance() {
-	// this will cause balance to go to -1
+func (suite *PaymentTestSuite) TestOutGoingPaymentWithoutBalanceForFeeReserve() {
+	// the balance covers the amount but not the fee reserve, so the routing fee can not take it below 0
 	aliceFundingSats := 1000
 	externalSatRequested := 1000
-	// 1 sat + 1 ppm
-	fee := 1
 	//fund alice account
 	invoiceResponse := suite.createAddInvoiceReq(aliceFundingSats, "integration test external payment alice", suite.aliceToken)
 	sendPaymentRequest := lnrpc.SendRequest{
@@ -111,54 +140,134 @@ func (suite *PaymentTestSuite) TestOutGoingPaymentWithNegativeBalance() {
 	invoice, err := suite.fundingClient.AddInvoice(context.Background(), &externalInvoice)
 	assert.NoError(suite.T(), err)
 	//pay external from alice
-	payResponse := suite.createPayInvoiceReq(invoice.PaymentRequest, suite.aliceToken)
-	assert.NotEmpty(suite.T(), payResponse.PaymentPreimage)
+	errorResponse := suite.createPayInvoiceReqError(invoice.PaymentRequest, suite.aliceToken)
+	assert.Equal(suite.T(), responses.NotEnoughBalanceError.Code, errorResponse.Code)
 
-	// check that balance was reduced
+	// nothing was booked, the balance is unchanged
 	userId := getUserIdFromToken(suite.aliceToken)
-
 	aliceBalance, err := suite.service.CurrentUserBalance(context.Background(), userId)
 	if err != nil {
 		fmt.Printf("Error when getting balance %v\n", err.Error())
 	}
-	assert.Equal(suite.T(), int64(aliceFundingSats)-int64(externalSatRequested+fee), aliceBalance)
-	assert.Equal(suite.T(), int64(-1), aliceBalance)
+	assert.Equal(suite.T(), int64(aliceFundingSats), aliceBalance)
 
-	// check that no additional transaction entry was created
 	transactonEntries, err := suite.service.TransactionEntriesFor(context.Background(), userId)
 	if err != nil {
 		fmt.Printf("Error when getting transaction entries %v\n", err.Error())
 	}
-	// verify transaction entries data
-	feeAccount, _ := suite.service.AccountFor(context.Background(), common.AccountTypeFees, userId)
-	incomingAccount, _ := suite.service.AccountFor(context.Background(), common.AccountTypeIncoming, userId)
-	outgoingAccount, _ := suite.service.AccountFor(context.Background(), common.AccountTypeOutgoing, userId)
-	currentAccount, _ := suite.service.AccountFor(context.Background(), common.AccountTypeCurrent, userId)
+	assert.Equal(suite.T(), 1, len(transactonEntries))
+	assert.Equal(suite.T(), int64(aliceFundingSats), transactonEntries[0].Amount)
+}
+
+func (suite *PaymentTestSuite) TestBulkPaymentWithoutBalanceForFeeReserves() {
+	// the balance covers the amounts but not the fee reserves of both payments
+	aliceFundingSats := 1000
+	externalSatRequested := 300
+	invoiceResponse := suite.createAddInvoiceReq(aliceFundingSats, "integration test external payment alice", suite.aliceToken)
+	_, err := suite.fundingClient.SendPaymentSync(context.Background(), &lnrpc.SendRequest{PaymentRequest: invoiceResponse.PayReq})
+	assert.NoError(suite.T(), err)
 
+	//wait a bit for the callback event to hit
+	time.Sleep(100 * time.Millisecond)
+
+	paymentRequests := []string{}
+	for i := 0; i < 2; i++ {
+		invoice, err := suite.fundingClient.AddInvoice(context.Background(), &lnrpc.Invoice{
+			Memo:  fmt.Sprintf("integration tests: bulk pay from alice %d", i),
+			Value: int64(externalSatRequested),
+		})
+		assert.NoError(suite.T(), err)
+		paymentRequests = append(paymentRequests, invoice.PaymentRequest)
+	}
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(&controllers.BulkPayInvoiceRequestBody{Invoices: paymentRequests}))
+	req := httptest.NewRequest(http.MethodPost, "/payinvoice/bulk", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", suite.aliceToken))
+	rec := httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, req)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	errorResponse := &responses.ErrorResponse{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(errorResponse))
+	assert.Equal(suite.T(), responses.NotEnoughBalanceError.Code, errorResponse.Code)
+
+	// none of the payments was sent
+	userId := getUserIdFromToken(suite.aliceToken)
 	outgoingInvoices, _ := suite.service.InvoicesFor(context.Background(), userId, common.InvoiceTypeOutgoing)
-	incomingInvoices, _ := suite.service.InvoicesFor(context.Background(), userId, common.InvoiceTypeIncoming)
-	assert.Equal(suite.T(), 1, len(outgoingInvoices))
-	assert.Equal(suite.T(), 1, len(incomingInvoices))
+	assert.Equal(suite.T(), 0, len(outgoingInvoices))
+	aliceBalance, err := suite.service.CurrentUserBalance(context.Background(), userId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(aliceFundingSats), aliceBalance)
+}
 
-	assert.Equal(suite.T(), 3, len(transactonEntries))
+func (suite *PaymentTestSuite) TestBulkPaymentOfUnusualAmountIsConfirmedPerInvoice() {
+	suite.service.Config.UnusualPaymentMultiplier = 2
+	defer func() { suite.service.Config.UnusualPaymentMultiplier = 0 }()
+	aliceFundingSats := 5000
+	invoiceResponse := suite.createAddInvoiceReq(aliceFundingSats, "integration test external payment alice", suite.aliceToken)
+	_, err := suite.fundingClient.SendPaymentSync(context.Background(), &lnrpc.SendRequest{PaymentRequest: invoiceResponse.PayReq})
+	assert.NoError(suite.T(), err)
 
-	assert.Equal(suite.T(), int64(aliceFundingSats), transactonEntries[0].Amount)
-	assert.Equal(suite.T(), currentAccount.ID, transactonEntries[0].CreditAccountID)
-	assert.Equal(suite.T(), incomingAccount.ID, transactonEntries[0].DebitAccountID)
-	assert.Equal(suite.T(), int64(0), transactonEntries[0].ParentID)
-	assert.Equal(suite.T(), incomingInvoices[0].ID, transactonEntries[0].InvoiceID)
+	//wait a bit for the callback event to hit
+	time.Sleep(100 * time.Millisecond)
 
-	assert.Equal(suite.T(), int64(externalSatRequested), transactonEntries[1].Amount)
-	assert.Equal(suite.T(), outgoingAccount.ID, transactonEntries[1].CreditAccountID)
-	assert.Equal(suite.T(), currentAccount.ID, transactonEntries[1].DebitAccountID)
-	assert.Equal(suite.T(), int64(0), transactonEntries[1].ParentID)
-	assert.Equal(suite.T(), outgoingInvoices[0].ID, transactonEntries[1].InvoiceID)
+	// a first payment sets the usual payment amount
+	invoice, err := suite.fundingClient.AddInvoice(context.Background(), &lnrpc.Invoice{Memo: "integration tests: usual payment from alice", Value: 100})
+	assert.NoError(suite.T(), err)
+	payResponse := suite.createPayInvoiceReq(invoice.PaymentRequest, suite.aliceToken)
+	assert.NotEmpty(suite.T(), payResponse.PaymentPreimage)
 
-	assert.Equal(suite.T(), int64(fee), transactonEntries[2].Amount)
-	assert.Equal(suite.T(), feeAccount.ID, transactonEntries[2].CreditAccountID)
-	assert.Equal(suite.T(), currentAccount.ID, transactonEntries[2].DebitAccountID)
-	assert.Equal(suite.T(), outgoingInvoices[0].ID, transactonEntries[2].InvoiceID)
+	paymentRequests := []string{}
+	for i, amount := range []int64{50, 1000} {
+		invoice, err := suite.fundingClient.AddInvoice(context.Background(), &lnrpc.Invoice{
+			Memo:  fmt.Sprintf("integration tests: bulk pay from alice %d", i),
+			Value: amount,
+		})
+		assert.NoError(suite.T(), err)
+		paymentRequests = append(paymentRequests, invoice.PaymentRequest)
+	}
+	bulkPay := func(reqBody *controllers.BulkPayInvoiceRequestBody) *controllers.BulkPayInvoiceResponseBody {
+		var buf bytes.Buffer
+		assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(reqBody))
+		req := httptest.NewRequest(http.MethodPost, "/payinvoice/bulk", &buf)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", suite.aliceToken))
+		rec := httptest.NewRecorder()
+		suite.echo.ServeHTTP(rec, req)
+		assert.Equal(suite.T(), http.StatusOK, rec.Code)
+		responseBody := &controllers.BulkPayInvoiceResponseBody{}
+		assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(responseBody))
+		return responseBody
+	}
 
-	// make sure fee entry parent id is previous entry
-	assert.Equal(suite.T(), transactonEntries[1].ID, transactonEntries[2].ParentID)
+	// the unusual payment of the batch needs a confirmation
+	responseBody := bulkPay(&controllers.BulkPayInvoiceRequestBody{Invoices: paymentRequests})
+	assert.Equal(suite.T(), 2, len(responseBody.Payments))
+	assert.Nil(suite.T(), responseBody.Payments[0].Error)
+	assert.NotEmpty(suite.T(), responseBody.Payments[0].Payment.PaymentPreimage)
+	assert.Nil(suite.T(), responseBody.Payments[1].Payment)
+	errorBody, ok := responseBody.Payments[1].Error.(map[string]interface{})
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), float64(responses.PaymentConfirmationRequiredError.Code), errorBody["code"])
+	confirmationToken, _ := errorBody["confirmation_token"].(string)
+	assert.NotEmpty(suite.T(), confirmationToken)
+
+	// sending it again with the token at the index of its invoice confirms it
+	responseBody = bulkPay(&controllers.BulkPayInvoiceRequestBody{
+		Invoices:           paymentRequests[1:],
+		ConfirmationTokens: []string{confirmationToken},
+	})
+	assert.Equal(suite.T(), 1, len(responseBody.Payments))
+	assert.Nil(suite.T(), responseBody.Payments[0].Error)
+	assert.NotEmpty(suite.T(), responseBody.Payments[0].Payment.PaymentPreimage)
+
+	userId := getUserIdFromToken(suite.aliceToken)
+	settledInvoices := 0
+	outgoingInvoices, _ := suite.service.InvoicesFor(context.Background(), userId, common.InvoiceTypeOutgoing)
+	for _, outgoingInvoice := range outgoingInvoices {
+		if outgoingInvoice.State == common.InvoiceStateSettled {
+			settledInvoices++
+		}
+	}
+	assert.Equal(suite.T(), 3, settledInvoices)
 }
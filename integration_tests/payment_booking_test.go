@@ -0,0 +1,616 @@
+package integration_tests
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// PaymentBookingTestSuite books payments between users of the hub, it does not need a lightning node
+type PaymentBookingTestSuite struct {
+	TestSuite
+	service *service.LndhubService
+}
+
+func (suite *PaymentBookingTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(&LNDStub{})
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	suite.service = svc
+}
+
+// fundedUsers creates a user with the balance and a recipient without balance
+func (suite *PaymentBookingTestSuite) fundedUsers(balance int64) (sender int64, recipientLogin string) {
+	logins, userTokens, err := createUsers(suite.service, 2)
+	assert.NoError(suite.T(), err)
+	sender = getUserIdFromToken(userTokens[0])
+	_, err = suite.service.AdjustBalance(context.Background(), sender, balance, "test", "funding")
+	assert.NoError(suite.T(), err)
+	return sender, logins[1].Login
+}
+
+func (suite *PaymentBookingTestSuite) TestNotEnoughBalanceBooksNothing() {
+	ctx := context.Background()
+	sender, recipient := suite.fundedUsers(100)
+
+	invoice, err := suite.service.AddTransferInvoice(ctx, sender, recipient, 150, "too much")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.ErrorIs(suite.T(), err, service.ErrNotEnoughBalance)
+
+	entries, err := suite.service.DB.NewSelect().Model((*models.TransactionEntry)(nil)).Where("invoice_id = ?", invoice.ID).Count(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, entries)
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), balance)
+
+	invoice, err = suite.service.AddTransferInvoice(ctx, sender, recipient, 100, "everything")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+	balance, err = suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(0), balance)
+}
+
+func (suite *PaymentBookingTestSuite) TestRoutingFeeIsRoundedUp() {
+	ctx := context.Background()
+	// the balance covers the payment and its fee reserve
+	sender, _ := suite.fundedUsers(2000)
+	stub := suite.service.LndClient.(*LNDStub)
+	var paymentHash []byte
+	stub.SendPayment = func(req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+		paymentHash = req.PaymentHash
+		preimage := req.DestCustomRecords[service.KEYSEND_CUSTOM_RECORD]
+		return &lnrpc.SendResponse{
+			PaymentPreimage: preimage,
+			PaymentHash:     req.PaymentHash,
+			PaymentRoute:    &lnrpc.Route{TotalAmt: req.Amt + 1, TotalFees: 1, TotalFeesMsat: 1500},
+		}, nil
+	}
+	defer func() { stub.SendPayment = nil }()
+
+	invoice, err := suite.service.AddOutgoingInvoice(ctx, sender, "", &lnd.LNPayReq{
+		PayReq:  &lnrpc.PayReq{Destination: simnetLnd2PubKey, NumSatoshis: 10},
+		Keysend: true,
+	})
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+
+	booked := models.Invoice{}
+	assert.NoError(suite.T(), suite.service.DB.NewSelect().Model(&booked).Where("id = ?", invoice.ID).Scan(ctx))
+	assert.Equal(suite.T(), int64(2), booked.Fee)
+	assert.Equal(suite.T(), int64(1500), booked.FeeMsat)
+	assert.Equal(suite.T(), int64(10000), booked.AmountMsat)
+	assert.Equal(suite.T(), hex.EncodeToString(paymentHash), booked.RHash)
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(1988), balance)
+}
+
+func (suite *PaymentBookingTestSuite) TestNoRouteRetriesChangeRouteRestrictions() {
+	ctx := context.Background()
+	sender, _ := suite.fundedUsers(10000)
+	stub := suite.service.LndClient.(*LNDStub)
+	requests := []*lnrpc.SendRequest{}
+	stub.SendPayment = func(req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+		requests = append(requests, req)
+		if len(requests) == 1 {
+			return nil, fmt.Errorf("%w: no_route", lnd.ErrNoRoute)
+		}
+		return &lnrpc.SendResponse{
+			PaymentPreimage: req.DestCustomRecords[service.KEYSEND_CUSTOM_RECORD],
+			PaymentHash:     req.PaymentHash,
+			PaymentRoute:    &lnrpc.Route{TotalAmt: req.Amt},
+		}, nil
+	}
+	suite.service.Config.PaymentOutgoingChanId = 12345
+	defer func() {
+		stub.SendPayment = nil
+		suite.service.Config.PaymentOutgoingChanId = 0
+	}()
+
+	invoice, err := suite.service.AddOutgoingInvoice(ctx, sender, "", &lnd.LNPayReq{
+		PayReq:  &lnrpc.PayReq{Destination: simnetLnd2PubKey, NumSatoshis: 10},
+		Keysend: true,
+	})
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+
+	assert.Len(suite.T(), requests, 2)
+	assert.Equal(suite.T(), uint64(12345), requests[0].OutgoingChanId)
+	assert.Equal(suite.T(), uint64(0), requests[1].OutgoingChanId)
+	assert.Greater(suite.T(), requests[1].FeeLimit.GetFixed(), requests[0].FeeLimit.GetFixed())
+	assert.Equal(suite.T(), 2, invoice.PaymentAttempts)
+}
+
+func (suite *PaymentBookingTestSuite) TestOtherFailuresAreNotRetried() {
+	ctx := context.Background()
+	sender, _ := suite.fundedUsers(10000)
+	stub := suite.service.LndClient.(*LNDStub)
+	attempts := 0
+	stub.SendPayment = func(req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+		attempts++
+		// the message of a no-route failure without the error of the client is not retried
+		return nil, errors.New("no_route")
+	}
+	defer func() { stub.SendPayment = nil }()
+
+	invoice, err := suite.service.AddOutgoingInvoice(ctx, sender, "", &lnd.LNPayReq{
+		PayReq:  &lnrpc.PayReq{Destination: simnetLnd2PubKey, NumSatoshis: 10},
+		Keysend: true,
+	})
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.Error(suite.T(), err)
+	assert.Equal(suite.T(), 1, attempts)
+}
+
+func (suite *PaymentBookingTestSuite) TestDestinationNotAllowedCreatesNoInvoice() {
+	ctx := context.Background()
+	sender, _ := suite.fundedUsers(100)
+	suite.service.Config.DestinationDenylist = []string{simnetLnd2PubKey}
+	defer func() { suite.service.Config.DestinationDenylist = nil }()
+
+	_, err := suite.service.AddOutgoingInvoice(ctx, sender, "", &lnd.LNPayReq{
+		PayReq:  &lnrpc.PayReq{Destination: simnetLnd2PubKey, NumSatoshis: 10},
+		Keysend: true,
+	})
+	assert.ErrorIs(suite.T(), err, service.ErrDestinationNotAllowed)
+	invoices, err := suite.service.DB.NewSelect().Model((*models.Invoice)(nil)).Where("user_id = ? AND type = ?", sender, common.InvoiceTypeOutgoing).Count(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, invoices)
+}
+
+func (suite *PaymentBookingTestSuite) TestMaxSendAmountIsEnforced() {
+	ctx := context.Background()
+	// the balance covers the payments and their fee reserve
+	sender, _ := suite.fundedUsers(2000)
+	stub := suite.service.LndClient.(*LNDStub)
+	attempts := 0
+	stub.SendPayment = func(req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+		attempts++
+		return &lnrpc.SendResponse{
+			PaymentPreimage: req.DestCustomRecords[service.KEYSEND_CUSTOM_RECORD],
+			PaymentHash:     req.PaymentHash,
+			PaymentRoute:    &lnrpc.Route{TotalAmt: req.Amt},
+		}, nil
+	}
+	suite.service.Config.MaxSendAmount = 100
+	defer func() {
+		stub.SendPayment = nil
+		suite.service.Config.MaxSendAmount = 0
+	}()
+
+	invoice, err := suite.service.AddOutgoingInvoice(ctx, sender, "", &lnd.LNPayReq{
+		PayReq:  &lnrpc.PayReq{Destination: simnetLnd2PubKey, NumSatoshis: 101},
+		Keysend: true,
+	})
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.ErrorIs(suite.T(), err, service.ErrMaxSendAmountExceeded)
+	assert.Equal(suite.T(), 0, attempts)
+	entries, err := suite.service.DB.NewSelect().Model((*models.TransactionEntry)(nil)).Where("invoice_id = ?", invoice.ID).Count(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, entries)
+
+	// the maximum itself can be sent
+	invoice, err = suite.service.AddOutgoingInvoice(ctx, sender, "", &lnd.LNPayReq{
+		PayReq:  &lnrpc.PayReq{Destination: simnetLnd2PubKey, NumSatoshis: 100},
+		Keysend: true,
+	})
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, attempts)
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(1900), balance)
+}
+
+func (suite *PaymentBookingTestSuite) TestMaxSendAmountOfTheTier() {
+	ctx := context.Background()
+	sender, recipient := suite.fundedUsers(1000)
+	max := int64(50)
+	suite.service.Config.MaxSendAmount = 100
+	suite.service.Config.AccountTiers = service.AccountTiers{common.UserTierVerified: {MaxSendAmount: &max}}
+	defer func() {
+		suite.service.Config.MaxSendAmount = 0
+		suite.service.Config.AccountTiers = nil
+	}()
+	assert.NoError(suite.T(), suite.service.SetUserTier(ctx, sender, common.UserTierVerified))
+
+	invoice, err := suite.service.AddTransferInvoice(ctx, sender, recipient, 51, "above the tier maximum")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.ErrorIs(suite.T(), err, service.ErrMaxSendAmountExceeded)
+
+	invoice, err = suite.service.AddTransferInvoice(ctx, sender, recipient, 50, "the tier maximum")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *PaymentBookingTestSuite) TestConcurrentPaymentsDoNotOverspend() {
+	ctx := context.Background()
+	sender, recipient := suite.fundedUsers(100)
+
+	invoices := []*models.Invoice{}
+	for i := 0; i < 2; i++ {
+		invoice, err := suite.service.AddTransferInvoice(ctx, sender, recipient, 100, "concurrent")
+		assert.NoError(suite.T(), err)
+		invoices = append(invoices, invoice)
+	}
+	errs := suite.payConcurrently(invoices)
+
+	assert.Equal(suite.T(), 1, countNil(errs))
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(0), balance)
+}
+
+func (suite *PaymentBookingTestSuite) TestConcurrentPaymentsOfTheSameInvoice() {
+	ctx := context.Background()
+	sender, recipient := suite.fundedUsers(200)
+
+	invoice, err := suite.service.AddTransferInvoice(ctx, sender, recipient, 100, "same invoice")
+	assert.NoError(suite.T(), err)
+	// a second outgoing invoice of the same payment hash, as if the payment request was submitted twice
+	duplicate := *invoice
+	duplicate.ID = 0
+	_, err = suite.service.DB.NewInsert().Model(&duplicate).Exec(ctx)
+	assert.NoError(suite.T(), err)
+	errs := suite.payConcurrently([]*models.Invoice{invoice, &duplicate})
+
+	assert.Equal(suite.T(), 1, countNil(errs))
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), balance)
+	inFlight, err := suite.service.DB.NewSelect().Model((*models.InFlightPayment)(nil)).Where("user_id = ?", sender).Count(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, inFlight)
+}
+
+func (suite *PaymentBookingTestSuite) TestInFlightPaymentIsUnique() {
+	ctx := context.Background()
+	sender, recipient := suite.fundedUsers(0)
+	invoice, err := suite.service.AddTransferInvoice(ctx, sender, recipient, 100, "")
+	assert.NoError(suite.T(), err)
+
+	_, err = suite.service.DB.NewInsert().Model(&models.InFlightPayment{UserID: sender, RHash: invoice.RHash, Type: invoice.Type, InvoiceID: invoice.ID}).Exec(ctx)
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.DB.NewInsert().Model(&models.InFlightPayment{UserID: sender, RHash: invoice.RHash, Type: invoice.Type, InvoiceID: invoice.ID + 1}).Exec(ctx)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *PaymentBookingTestSuite) TestDailySendLimit() {
+	ctx := context.Background()
+	// the balance covers the fee reserve of the failed payment
+	sender, recipient := suite.fundedUsers(2000)
+	stub := suite.service.LndClient.(*LNDStub)
+	stub.SendPayment = func(req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+		return nil, errors.New("incorrect_payment_details")
+	}
+	suite.service.Config.DailySendLimit = 100
+	defer func() {
+		stub.SendPayment = nil
+		suite.service.Config.DailySendLimit = 0
+	}()
+
+	invoice, err := suite.service.AddTransferInvoice(ctx, sender, recipient, 60, "")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+
+	invoice, err = suite.service.AddTransferInvoice(ctx, sender, recipient, 50, "")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	var limitErr *service.SendLimitExceededError
+	assert.ErrorAs(suite.T(), err, &limitErr)
+	assert.Equal(suite.T(), service.SendLimitPeriodDaily, limitErr.Period)
+	assert.Equal(suite.T(), int64(100), limitErr.Limit)
+	assert.WithinDuration(suite.T(), time.Now().Add(24*time.Hour), limitErr.ResetAt, time.Minute)
+
+	// failed payments are refunded and do not count
+	failed, err := suite.service.AddOutgoingInvoice(ctx, sender, "", &lnd.LNPayReq{
+		PayReq:  &lnrpc.PayReq{Destination: simnetLnd2PubKey, NumSatoshis: 30},
+		Keysend: true,
+	})
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, failed)
+	assert.Error(suite.T(), err)
+	assert.NotErrorIs(suite.T(), err, service.ErrNotEnoughBalance)
+	invoice, err = suite.service.AddTransferInvoice(ctx, sender, recipient, 40, "")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *PaymentBookingTestSuite) TestWeeklySendLimit() {
+	ctx := context.Background()
+	sender, recipient := suite.fundedUsers(1000)
+	suite.service.Config.DailySendLimit = 100
+	suite.service.Config.WeeklySendLimit = 150
+	defer func() {
+		suite.service.Config.DailySendLimit = 0
+		suite.service.Config.WeeklySendLimit = 0
+	}()
+
+	invoice, err := suite.service.AddTransferInvoice(ctx, sender, recipient, 60, "")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+	// the payment was made two days ago, it only counts for the weekly limit
+	paidAt := time.Now().Add(-48 * time.Hour)
+	_, err = suite.service.DB.NewUpdate().Model((*models.TransactionEntry)(nil)).Set("created_at = ?", paidAt).Where("invoice_id = ?", invoice.ID).Exec(ctx)
+	assert.NoError(suite.T(), err)
+
+	invoice, err = suite.service.AddTransferInvoice(ctx, sender, recipient, 90, "")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+
+	invoice, err = suite.service.AddTransferInvoice(ctx, sender, recipient, 1, "")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	var limitErr *service.SendLimitExceededError
+	assert.ErrorAs(suite.T(), err, &limitErr)
+	assert.Equal(suite.T(), service.SendLimitPeriodWeekly, limitErr.Period)
+	// the limit resets when the oldest payment of the week is a week old
+	assert.WithinDuration(suite.T(), paidAt.Add(7*24*time.Hour), limitErr.ResetAt, time.Minute)
+}
+
+func (suite *PaymentBookingTestSuite) TestConcurrentPaymentsDoNotExceedTheSendLimit() {
+	ctx := context.Background()
+	sender, recipient := suite.fundedUsers(1000)
+	suite.service.Config.DailySendLimit = 100
+	defer func() { suite.service.Config.DailySendLimit = 0 }()
+
+	invoices := []*models.Invoice{}
+	for i := 0; i < 2; i++ {
+		invoice, err := suite.service.AddTransferInvoice(ctx, sender, recipient, 60, "concurrent")
+		assert.NoError(suite.T(), err)
+		invoices = append(invoices, invoice)
+	}
+	errs := suite.payConcurrently(invoices)
+
+	assert.Equal(suite.T(), 1, countNil(errs))
+	for _, err := range errs {
+		if err != nil {
+			var limitErr *service.SendLimitExceededError
+			assert.ErrorAs(suite.T(), err, &limitErr)
+		}
+	}
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(940), balance)
+}
+
+func (suite *PaymentBookingTestSuite) TestRefundOfAnOlderPaymentDoesNotAddAllowance() {
+	ctx := context.Background()
+	sender, recipient := suite.fundedUsers(1000)
+	suite.service.Config.DailySendLimit = 100
+	defer func() { suite.service.Config.DailySendLimit = 0 }()
+
+	// a payment debited two days ago is refunded today
+	invoice := stuckPayment(suite.T(), suite.service, sender, 80)
+	_, err := suite.service.DB.NewUpdate().Model((*models.TransactionEntry)(nil)).
+		Set("created_at = ?", time.Now().Add(-48*time.Hour)).
+		Where("invoice_id = ?", invoice.ID).
+		Exec(ctx)
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.ForceFailOutgoingInvoice(ctx, invoice.ID, "stuck")
+	assert.NoError(suite.T(), err)
+	volume, err := suite.service.OutgoingVolumeSince(ctx, sender, time.Now().Add(-24*time.Hour))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(0), volume)
+
+	invoice, err = suite.service.AddTransferInvoice(ctx, sender, recipient, 150, "")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	var limitErr *service.SendLimitExceededError
+	assert.ErrorAs(suite.T(), err, &limitErr)
+}
+
+func (suite *PaymentBookingTestSuite) TestForceFailAsksTheNode() {
+	ctx := context.Background()
+	sender, _ := suite.fundedUsers(100)
+	stub := suite.service.LndClient.(*LNDStub)
+	invoice := stuckPayment(suite.T(), suite.service, sender, 40)
+	stub.Payments = map[string]*lnrpc.Payment{invoice.RHash: {Status: lnrpc.Payment_IN_FLIGHT}}
+	defer func() { stub.Payments = nil }()
+
+	_, err := suite.service.ForceFailOutgoingInvoice(ctx, invoice.ID, "stuck")
+	assert.ErrorIs(suite.T(), err, service.ErrPaymentNotFailed)
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(60), balance)
+
+	stub.Payments[invoice.RHash] = &lnrpc.Payment{Status: lnrpc.Payment_SUCCEEDED}
+	_, err = suite.service.ForceFailOutgoingInvoice(ctx, invoice.ID, "stuck")
+	assert.ErrorIs(suite.T(), err, service.ErrPaymentNotFailed)
+
+	stub.Payments[invoice.RHash] = &lnrpc.Payment{Status: lnrpc.Payment_FAILED}
+	failed, err := suite.service.ForceFailOutgoingInvoice(ctx, invoice.ID, "stuck")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), common.InvoiceStateError, failed.State)
+	balance, err = suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), balance)
+	auditLogs, err := suite.service.DB.NewSelect().Model((*models.AuditLog)(nil)).
+		Where("invoice_id = ? AND action = ?", invoice.ID, service.AuditActionForceFailInvoice).Count(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, auditLogs)
+	inFlight, err := suite.service.DB.NewSelect().Model((*models.InFlightPayment)(nil)).Where("invoice_id = ?", invoice.ID).Count(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, inFlight)
+
+	// a failed invoice is not refunded twice
+	_, err = suite.service.ForceFailOutgoingInvoice(ctx, invoice.ID, "stuck")
+	assert.ErrorIs(suite.T(), err, service.ErrInvoiceNotInFlight)
+}
+
+func (suite *PaymentBookingTestSuite) TestForceFailPaymentTheNodeNeverSent() {
+	ctx := context.Background()
+	sender, _ := suite.fundedUsers(100)
+	invoice := stuckPayment(suite.T(), suite.service, sender, 40)
+
+	_, err := suite.service.ForceFailOutgoingInvoice(ctx, invoice.ID, "never sent")
+	assert.NoError(suite.T(), err)
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), balance)
+}
+
+func (suite *PaymentBookingTestSuite) TestForceFailInternalPaymentOfCreditedRecipient() {
+	ctx := context.Background()
+	sender, recipient := suite.fundedUsers(100)
+
+	// the recipient was credited, settling the payment failed afterwards
+	invoice, err := suite.service.AddTransferInvoice(ctx, sender, recipient, 40, "")
+	assert.NoError(suite.T(), err)
+	stuckDebit(suite.T(), suite.service, invoice)
+	_, err = suite.service.DB.NewUpdate().Model((*models.Invoice)(nil)).
+		Set("state = ?", common.InvoiceStateSettled).
+		Where("type = ? AND r_hash = ?", common.InvoiceTypeIncoming, invoice.RHash).
+		Exec(ctx)
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.ForceFailOutgoingInvoice(ctx, invoice.ID, "stuck")
+	assert.ErrorIs(suite.T(), err, service.ErrPaymentNotFailed)
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(60), balance)
+
+	// the recipient's invoice is still open, nobody was credited
+	invoice, err = suite.service.AddTransferInvoice(ctx, sender, recipient, 20, "")
+	assert.NoError(suite.T(), err)
+	stuckDebit(suite.T(), suite.service, invoice)
+	_, err = suite.service.ForceFailOutgoingInvoice(ctx, invoice.ID, "stuck")
+	assert.NoError(suite.T(), err)
+	balance, err = suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(60), balance)
+}
+
+func (suite *PaymentBookingTestSuite) TestForceFailRetriedPayment() {
+	ctx := context.Background()
+	sender, _ := suite.fundedUsers(100)
+	invoice := stuckPayment(suite.T(), suite.service, sender, 40)
+	_, err := suite.service.ForceFailOutgoingInvoice(ctx, invoice.ID, "first attempt")
+	assert.NoError(suite.T(), err)
+
+	// the retry debits the amount and a fee reserve again
+	_, err = suite.service.DB.NewUpdate().Model((*models.Invoice)(nil)).
+		Set("state = ?, fee_reserve = ?", common.InvoiceStateInitialized, 5).
+		Where("id = ?", invoice.ID).
+		Exec(ctx)
+	assert.NoError(suite.T(), err)
+	retry := stuckDebit(suite.T(), suite.service, invoice)
+	_, err = suite.service.DB.NewInsert().Model(&models.TransactionEntry{
+		UserID:          sender,
+		InvoiceID:       invoice.ID,
+		CreditAccountID: retry.CreditAccountID,
+		DebitAccountID:  retry.DebitAccountID,
+		Amount:          5,
+		ParentID:        retry.ID,
+	}).Exec(ctx)
+	assert.NoError(suite.T(), err)
+
+	_, err = suite.service.ForceFailOutgoingInvoice(ctx, invoice.ID, "second attempt")
+	assert.NoError(suite.T(), err)
+	balance, err := suite.service.CurrentUserBalance(ctx, sender)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), balance)
+	// the refund of the reserve points at the debit of the retry
+	refunds, err := suite.service.DB.NewSelect().Model((*models.TransactionEntry)(nil)).
+		Where("invoice_id = ? AND parent_id = ? AND credit_account_id = ?", invoice.ID, retry.ID, retry.DebitAccountID).
+		Count(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, refunds)
+}
+
+// stuckPayment books the debit of an outgoing payment like a process that stopped before the node answered
+func stuckPayment(t *testing.T, svc *service.LndhubService, userId, amount int64) *models.Invoice {
+	ctx := context.Background()
+	invoice, err := svc.AddOutgoingInvoice(ctx, userId, "", &lnd.LNPayReq{
+		PayReq:  &lnrpc.PayReq{Destination: simnetLnd2PubKey, NumSatoshis: amount},
+		Keysend: true,
+	})
+	assert.NoError(t, err)
+	// the payment hash the keysend attempt recorded before it was sent
+	invoice.RHash = hex.EncodeToString(randomBytes(32))
+	_, err = svc.DB.NewUpdate().Model(invoice).Column("r_hash").WherePK().Exec(ctx)
+	assert.NoError(t, err)
+	stuckDebit(t, svc, invoice)
+	return invoice
+}
+
+// stuckDebit moves the amount of the invoice to the in-flight account and returns the debit entry
+func stuckDebit(t *testing.T, svc *service.LndhubService, invoice *models.Invoice) models.TransactionEntry {
+	ctx := context.Background()
+	current, err := svc.AccountFor(ctx, common.AccountTypeCurrent, invoice.UserID)
+	assert.NoError(t, err)
+	inFlight, err := svc.AccountFor(ctx, common.AccountTypeInFlight, invoice.UserID)
+	assert.NoError(t, err)
+	entry := models.TransactionEntry{
+		UserID:          invoice.UserID,
+		InvoiceID:       invoice.ID,
+		CreditAccountID: inFlight.ID,
+		DebitAccountID:  current.ID,
+		Amount:          invoice.Amount,
+	}
+	_, err = svc.DB.NewInsert().Model(&entry).Exec(ctx)
+	assert.NoError(t, err)
+	_, err = svc.DB.NewInsert().Model(&models.InFlightPayment{UserID: invoice.UserID, RHash: invoice.RHash, Type: invoice.Type, InvoiceID: invoice.ID}).Exec(ctx)
+	assert.NoError(t, err)
+	return entry
+}
+
+// payConcurrently pays the invoices at the same time and returns the error of every payment
+func (suite *PaymentBookingTestSuite) payConcurrently(invoices []*models.Invoice) []error {
+	errs := make([]error, len(invoices))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, invoice := range invoices {
+		wg.Add(1)
+		go func(i int, invoice *models.Invoice) {
+			defer wg.Done()
+			<-start
+			_, errs[i] = suite.service.PayInvoice(context.Background(), invoice)
+		}(i, invoice)
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+func countNil(errs []error) int {
+	count := 0
+	for _, err := range errs {
+		if err == nil {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPaymentBookingTestSuite(t *testing.T) {
+	suite.Run(t, new(PaymentBookingTestSuite))
+}
@@ -75,15 +75,18 @@ func (suite *PaymentTestAsyncErrorsSuite) SetupSuite() {
 	assert.Equal(suite.T(), 1, len(userTokens))
 	suite.userLogin = users[0]
 	suite.userToken = userTokens[0]
-	suite.echo.Use(tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+	suite.echo.Use(tokens.Middleware(suite.service.TokenMiddlewareOptions(tokens.RouteScopes{})))
 	suite.echo.GET("/balance", controllers.NewBalanceController(suite.service).Balance)
 	suite.echo.POST("/addinvoice", controllers.NewAddInvoiceController(suite.service).AddInvoice)
 	suite.echo.POST("/payinvoice", controllers.NewPayInvoiceController(suite.service).PayInvoice)
 }
 
 func (suite *PaymentTestAsyncErrorsSuite) TestExternalAsyncFailingInvoice() {
-	userFundingSats := 1000
+	// the balance covers the payment and its fee reserve
+	userFundingSats := 2000
 	externalSatRequested := 500
+	// the fee limit of the last attempt: 300 sats doubled for each of the 2 retries
+	feeReserve := 1200
 	// fund user account
 	invoiceResponse := suite.createAddInvoiceReq(userFundingSats, "integration test external payment user", suite.userToken)
 	sendPaymentRequest := lnrpc.SendRequest{
@@ -115,7 +118,7 @@ func (suite *PaymentTestAsyncErrorsSuite) TestExternalAsyncFailingInvoice() {
 	if err != nil {
 		fmt.Printf("Error when getting balance %v\n", err.Error())
 	}
-	assert.Equal(suite.T(), int64(userFundingSats-externalSatRequested), userBalance)
+	assert.Equal(suite.T(), int64(userFundingSats-externalSatRequested-feeReserve), userBalance)
 
 	// fail payment and wait a bit
 	suite.serviceClient.FailPayment(SendPaymentMockError)
@@ -140,12 +143,18 @@ func (suite *PaymentTestAsyncErrorsSuite) TestExternalAsyncFailingInvoice() {
 	if err != nil {
 		fmt.Printf("Error when getting transaction entries %v\n", err.Error())
 	}
-	// check if there are 3 transaction entries, with reversed credit and debit account ids
-	assert.Equal(suite.T(), 3, len(transactonEntries))
-	assert.Equal(suite.T(), transactonEntries[1].CreditAccountID, transactonEntries[2].DebitAccountID)
-	assert.Equal(suite.T(), transactonEntries[1].DebitAccountID, transactonEntries[2].CreditAccountID)
-	assert.Equal(suite.T(), transactonEntries[1].Amount, int64(externalSatRequested))
-	assert.Equal(suite.T(), transactonEntries[2].Amount, int64(externalSatRequested))
+	// the amount and the fee reserve are booked and both are refunded
+	assert.Equal(suite.T(), 5, len(transactonEntries))
+	assert.Equal(suite.T(), int64(externalSatRequested), transactonEntries[1].Amount)
+	assert.Equal(suite.T(), int64(feeReserve), transactonEntries[2].Amount)
+	assert.Equal(suite.T(), transactonEntries[1].ID, transactonEntries[2].ParentID)
+	assert.Equal(suite.T(), transactonEntries[1].CreditAccountID, transactonEntries[3].DebitAccountID)
+	assert.Equal(suite.T(), transactonEntries[1].DebitAccountID, transactonEntries[3].CreditAccountID)
+	assert.Equal(suite.T(), int64(externalSatRequested), transactonEntries[3].Amount)
+	assert.Equal(suite.T(), transactonEntries[2].CreditAccountID, transactonEntries[4].DebitAccountID)
+	assert.Equal(suite.T(), transactonEntries[2].DebitAccountID, transactonEntries[4].CreditAccountID)
+	assert.Equal(suite.T(), int64(feeReserve), transactonEntries[4].Amount)
+	assert.Equal(suite.T(), transactonEntries[1].ID, transactonEntries[4].ParentID)
 }
 
 func (suite *PaymentTestAsyncErrorsSuite) TearDownSuite() {
@@ -0,0 +1,124 @@
+package integration_tests
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/controllers"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type UserDeletionTestSuite struct {
+	suite.Suite
+	service *service.LndhubService
+	echo    *echo.Echo
+}
+
+func (suite *UserDeletionTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(&LNDStub{})
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	suite.service = svc
+	e := echo.New()
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	secured := e.Group("", tokens.Middleware(svc.TokenMiddlewareOptions(tokens.RouteScopes{})))
+	secured.GET("/balance", controllers.NewBalanceController(svc).Balance)
+	suite.echo = e
+}
+
+func (suite *UserDeletionTestSuite) getBalance(token string) int {
+	req := httptest.NewRequest(http.MethodGet, "/balance", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec := httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func (suite *UserDeletionTestSuite) TestDeleteUserWithBalance() {
+	ctx := context.Background()
+	logins, userTokens, err := createUsers(suite.service, 1)
+	assert.NoError(suite.T(), err)
+	userId := getUserIdFromToken(userTokens[0])
+	_, err = suite.service.AdjustBalance(ctx, userId, 100, "test", "funding")
+	assert.NoError(suite.T(), err)
+
+	err = suite.service.DeleteUser(ctx, userId, "wrong")
+	assert.ErrorIs(suite.T(), err, service.ErrWrongPassword)
+	err = suite.service.DeleteUser(ctx, userId, logins[0].Password)
+	assert.ErrorIs(suite.T(), err, service.ErrAccountNotEmpty)
+
+	// the user is kept and can still use the account
+	user, err := suite.service.FindUser(ctx, userId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), logins[0].Login, user.Login)
+	assert.True(suite.T(), user.DeletedAt.IsZero())
+	assert.Equal(suite.T(), http.StatusOK, suite.getBalance(userTokens[0]))
+}
+
+func (suite *UserDeletionTestSuite) TestDeleteUser() {
+	ctx := context.Background()
+	logins, userTokens, err := createUsers(suite.service, 2)
+	assert.NoError(suite.T(), err)
+	userId := getUserIdFromToken(userTokens[0])
+	_, refreshToken, err := suite.service.GenerateToken(ctx, logins[0].Login, logins[0].Password, "", "")
+	assert.NoError(suite.T(), err)
+	_, apiKey, err := suite.service.CreateAPIKey(ctx, userId, "test", tokens.Scopes, 0, 0)
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.CreateContact(ctx, userId, "friend", common.ContactTypeUser, logins[1].Login)
+	assert.NoError(suite.T(), err)
+	// a payment received and sent again, the ledger keeps both
+	_, err = suite.service.AdjustBalance(ctx, userId, 100, "test", "funding")
+	assert.NoError(suite.T(), err)
+	invoice, err := suite.service.AddTransferInvoice(ctx, userId, logins[1].Login, 100, "secret memo")
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.PayInvoice(ctx, invoice)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, suite.getBalance(userTokens[0]))
+	assert.Equal(suite.T(), http.StatusOK, suite.getBalance(apiKey))
+
+	err = suite.service.DeleteUser(ctx, userId, logins[0].Password)
+	assert.NoError(suite.T(), err)
+
+	user, err := suite.service.FindUser(ctx, userId)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), strings.HasPrefix(user.Login, "deleted-"))
+	assert.False(suite.T(), user.DeletedAt.IsZero())
+	_, _, err = suite.service.GenerateToken(ctx, logins[0].Login, logins[0].Password, "", "")
+	assert.Error(suite.T(), err)
+
+	// the tokens, sessions and api keys of the user can not be used anymore
+	assert.Equal(suite.T(), http.StatusBadRequest, suite.getBalance(userTokens[0]))
+	assert.Equal(suite.T(), http.StatusBadRequest, suite.getBalance(apiKey))
+	_, _, err = suite.service.GenerateToken(ctx, "", "", refreshToken, "")
+	assert.Error(suite.T(), err)
+	// the user's saved data is deleted, the ledger is kept without the memos
+	contacts, err := suite.service.DB.NewSelect().Model((*models.Contact)(nil)).Where("user_id = ?", userId).Count(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, contacts)
+	var paid models.Invoice
+	err = suite.service.DB.NewSelect().Model(&paid).Where("id = ?", invoice.ID).Scan(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), common.InvoiceStateSettled, paid.State)
+	assert.Empty(suite.T(), paid.Memo)
+	entries, err := suite.service.DB.NewSelect().Model((*models.TransactionEntry)(nil)).Where("user_id = ?", userId).Count(ctx)
+	assert.NoError(suite.T(), err)
+	assert.NotZero(suite.T(), entries)
+	// the tokens of other users are not affected
+	assert.Equal(suite.T(), http.StatusOK, suite.getBalance(userTokens[1]))
+}
+
+func TestUserDeletionTestSuite(t *testing.T) {
+	suite.Run(t, new(UserDeletionTestSuite))
+}
@@ -0,0 +1,115 @@
+package integration_tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// WebhookTestSuite delivers webhooks to a local receiver, like several instances sharing the database
+type WebhookTestSuite struct {
+	suite.Suite
+	service *service.LndhubService
+}
+
+func (suite *WebhookTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(&LNDStub{})
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	svc.Config.WebhookMaxAttempts = 10
+	suite.service = svc
+}
+
+func (suite *WebhookTestSuite) SetupTest() {
+	assert.NoError(suite.T(), clearTable(suite.service, "webhook_delivery_attempts"))
+	assert.NoError(suite.T(), clearTable(suite.service, "webhook_deliveries"))
+}
+
+func (suite *WebhookTestSuite) TestConcurrentDispatchersDeliverOnce() {
+	ctx := context.Background()
+	var mu sync.Mutex
+	received := map[string]int{}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received[string(body)]++
+		mu.Unlock()
+		// slow receivers keep the deliveries of one dispatcher in progress while the other one runs
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer receiver.Close()
+	// the receiver listens on a loopback address, only the operator's WEBHOOK_URL may be internal
+	suite.service.Config.WebhookUrl = receiver.URL
+	defer func() { suite.service.Config.WebhookUrl = "" }()
+
+	const deliveries = 20
+	for i := 0; i < deliveries; i++ {
+		assert.NoError(suite.T(), suite.service.EnqueueWebhook(ctx, receiver.URL, &service.WebhookPayload{Event: fmt.Sprintf("test.%d", i)}))
+	}
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			assert.NoError(suite.T(), suite.service.DispatchDueWebhooks(ctx))
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Len(suite.T(), received, deliveries)
+	for body, count := range received {
+		assert.Equal(suite.T(), 1, count, body)
+	}
+	delivered, err := suite.service.DB.NewSelect().Model((*models.WebhookDelivery)(nil)).
+		Where("state = ? AND attempts = 1", common.WebhookDeliveryStateDelivered).Count(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), deliveries, delivered)
+
+	// delivered webhooks are not claimed again
+	assert.NoError(suite.T(), suite.service.DispatchDueWebhooks(ctx))
+	assert.Len(suite.T(), received, deliveries)
+}
+
+func (suite *WebhookTestSuite) TestFailedDeliveryIsRetriedLater() {
+	ctx := context.Background()
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+	// the receiver listens on a loopback address, only the operator's WEBHOOK_URL may be internal
+	suite.service.Config.WebhookUrl = receiver.URL
+	defer func() { suite.service.Config.WebhookUrl = "" }()
+
+	assert.NoError(suite.T(), suite.service.EnqueueWebhook(ctx, receiver.URL, &service.WebhookPayload{Event: "test.failing"}))
+	assert.NoError(suite.T(), suite.service.DispatchDueWebhooks(ctx))
+	assert.NoError(suite.T(), suite.service.DispatchDueWebhooks(ctx))
+	assert.Equal(suite.T(), 1, attempts)
+
+	delivery := models.WebhookDelivery{}
+	assert.NoError(suite.T(), suite.service.DB.NewSelect().Model(&delivery).Where("event = ?", "test.failing").Scan(ctx))
+	assert.Equal(suite.T(), common.WebhookDeliveryStatePending, delivery.State)
+	assert.Equal(suite.T(), 1, delivery.Attempts)
+	assert.True(suite.T(), delivery.NextAttemptAt.After(time.Now()))
+}
+
+func TestWebhookTestSuite(t *testing.T) {
+	suite.Run(t, new(WebhookTestSuite))
+}
@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrAmountOverflow is returned when amount arithmetic would not fit into an int64
+var ErrAmountOverflow = errors.New("amount out of range")
+
+// AddAmounts returns a + b or ErrAmountOverflow if the result would wrap
+func AddAmounts(a, b int64) (int64, error) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, ErrAmountOverflow
+	}
+	return a + b, nil
+}
+
+// MultiplyAmount returns amount * factor or ErrAmountOverflow if the result would wrap
+func MultiplyAmount(amount, factor int64) (int64, error) {
+	if amount == 0 || factor == 0 {
+		return 0, nil
+	}
+	result := amount * factor
+	if result/factor != amount || (amount == -1 && factor == math.MinInt64) || (factor == -1 && amount == math.MinInt64) {
+		return 0, ErrAmountOverflow
+	}
+	return result, nil
+}
+
+// FloatToAmount converts a JSON number to an amount without silently wrapping
+func FloatToAmount(value float64) (int64, error) {
+	// float64(math.MaxInt64) rounds up to 2^63 which itself is out of range
+	if math.IsNaN(value) || value >= math.MaxInt64 || value < math.MinInt64 {
+		return 0, ErrAmountOverflow
+	}
+	return int64(value), nil
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddAmounts(t *testing.T) {
+	sum, err := AddAmounts(1000, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1010), sum)
+
+	_, err = AddAmounts(math.MaxInt64, 1)
+	assert.ErrorIs(t, err, ErrAmountOverflow)
+	_, err = AddAmounts(math.MinInt64, -1)
+	assert.ErrorIs(t, err, ErrAmountOverflow)
+
+	sum, err = AddAmounts(math.MaxInt64, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64-1), sum)
+}
+
+func TestMultiplyAmount(t *testing.T) {
+	result, err := MultiplyAmount(300, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), result)
+
+	_, err = MultiplyAmount(math.MaxInt64/2+1, 2)
+	assert.ErrorIs(t, err, ErrAmountOverflow)
+	_, err = MultiplyAmount(math.MinInt64, -1)
+	assert.ErrorIs(t, err, ErrAmountOverflow)
+}
+
+func TestFloatToAmount(t *testing.T) {
+	amount, err := FloatToAmount(1000)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), amount)
+
+	_, err = FloatToAmount(math.MaxInt64)
+	assert.ErrorIs(t, err, ErrAmountOverflow)
+	_, err = FloatToAmount(1e30)
+	assert.ErrorIs(t, err, ErrAmountOverflow)
+	_, err = FloatToAmount(math.NaN())
+	assert.ErrorIs(t, err, ErrAmountOverflow)
+}
@@ -0,0 +1,28 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"regexp"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+var keyPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,128}$`)
+
+// Store keeps large blobs outside of the database, only their keys are stored in Postgres
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// ValidKey makes sure keys can be used as file names and object keys as they are
+func ValidKey(key string) error {
+	if !keyPattern.MatchString(key) {
+		return ErrInvalidKey
+	}
+	return nil
+}
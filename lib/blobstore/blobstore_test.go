@@ -0,0 +1,62 @@
+package blobstore
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	assert.NoError(t, store.Put(ctx, "abc123", []byte("zap request")))
+	data, err := store.Get(ctx, "abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, "zap request", string(data))
+
+	_, err = store.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, store.Put(ctx, "../escape", []byte("x")), ErrInvalidKey)
+	_, err = store.Get(ctx, "a/b")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	testStore(t, store)
+}
+
+func TestS3Store(t *testing.T) {
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") || !strings.Contains(auth, "/eu-central-1/s3/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, sha256Hex(body), r.Header.Get("X-Amz-Content-Sha256"))
+			objects[r.URL.Path] = body
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(body)
+		}
+	}))
+	defer server.Close()
+
+	store, err := NewS3Store(S3Options{Endpoint: server.URL, Bucket: "blobs", Region: "eu-central-1", AccessKeyID: "key", SecretAccessKey: "secret"})
+	assert.NoError(t, err)
+	testStore(t, store)
+	assert.Contains(t, objects, "/blobs/abc123")
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// ErrorLog keeps the most recent error log lines in memory, e.g. for support bundles
+type ErrorLog struct {
+	mu    sync.Mutex
+	size  int
+	lines []string
+}
+
+func NewErrorLog(size int) *ErrorLog {
+	return &ErrorLog{size: size}
+}
+
+// Write keeps the line if its level is error or worse, the logger writes one JSON line per call
+func (l *ErrorLog) Write(p []byte) (int, error) {
+	var line struct {
+		Level string `json:"level"`
+	}
+	if json.Unmarshal(p, &line) != nil {
+		return len(p), nil
+	}
+	switch line.Level {
+	case "error", "fatal", "panic":
+	default:
+		return len(p), nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, string(bytes.TrimSpace(p)))
+	if len(l.lines) > l.size {
+		l.lines = l.lines[len(l.lines)-l.size:]
+	}
+	return len(p), nil
+}
+
+// Lines returns the kept lines, oldest first
+func (l *ErrorLog) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.lines...)
+}
@@ -0,0 +1,19 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorLog(t *testing.T) {
+	errorLog := NewErrorLog(2)
+	errorLog.Write([]byte(`{"level":"info","message":"started"}` + "\n"))
+	errorLog.Write([]byte("not json\n"))
+	errorLog.Write([]byte(`{"level":"error","message":"first"}` + "\n"))
+	assert.Equal(t, []string{`{"level":"error","message":"first"}`}, errorLog.Lines())
+
+	errorLog.Write([]byte(`{"level":"error","message":"second"}`))
+	errorLog.Write([]byte(`{"level":"fatal","message":"third"}`))
+	assert.Equal(t, []string{`{"level":"error","message":"second"}`, `{"level":"fatal","message":"third"}`}, errorLog.Lines())
+}
@@ -0,0 +1,126 @@
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// FormatVersion is bumped whenever columns are added, removed or change their meaning
+const FormatVersion = "2"
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+
+	ChecksumAlgorithm = "sha256"
+)
+
+// Manifest describes an export so consumers can validate its integrity and detect truncation
+type Manifest struct {
+	FormatVersion     string    `json:"format_version"`
+	Format            string    `json:"format"`
+	Columns           []string  `json:"columns"`
+	RowCount          int       `json:"row_count"`
+	ChecksumAlgorithm string    `json:"checksum_algorithm"`
+	Checksum          string    `json:"checksum"` // hex encoded checksum of the exported data
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Writer streams rows in a stable column order and keeps track of the row count and checksum
+type Writer struct {
+	format    string
+	columns   []string
+	out       io.Writer
+	hash      hash.Hash
+	csvWriter *csv.Writer
+	rowCount  int
+	closed    bool
+}
+
+func NewWriter(w io.Writer, format string, columns []string) (*Writer, error) {
+	if format != FormatCSV && format != FormatJSON {
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+	h := sha256.New()
+	writer := &Writer{
+		format:  format,
+		columns: columns,
+		hash:    h,
+		out:     io.MultiWriter(w, h),
+	}
+	if format == FormatCSV {
+		writer.csvWriter = csv.NewWriter(writer.out)
+		if err := writer.csvWriter.Write(columns); err != nil {
+			return nil, err
+		}
+		return writer, nil
+	}
+	if _, err := io.WriteString(writer.out, "["); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// WriteRow writes one row, values must be in the same order as the columns
+func (w *Writer) WriteRow(values []string) error {
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("row has %d values, expected %d", len(values), len(w.columns))
+	}
+	if w.format == FormatCSV {
+		if err := w.csvWriter.Write(values); err != nil {
+			return err
+		}
+		w.csvWriter.Flush()
+		if err := w.csvWriter.Error(); err != nil {
+			return err
+		}
+		w.rowCount++
+		return nil
+	}
+
+	// encoding/json sorts map keys, so objects are written by hand to keep the column order
+	separator := ","
+	if w.rowCount == 0 {
+		separator = ""
+	}
+	row := separator + "{"
+	for i, column := range w.columns {
+		key, _ := json.Marshal(column)
+		value, _ := json.Marshal(values[i])
+		if i > 0 {
+			row += ","
+		}
+		row += string(key) + ":" + string(value)
+	}
+	row += "}"
+	if _, err := io.WriteString(w.out, row); err != nil {
+		return err
+	}
+	w.rowCount++
+	return nil
+}
+
+// Close finishes the export and returns its manifest
+func (w *Writer) Close() (*Manifest, error) {
+	if !w.closed && w.format == FormatJSON {
+		if _, err := io.WriteString(w.out, "]"); err != nil {
+			return nil, err
+		}
+	}
+	w.closed = true
+	return &Manifest{
+		FormatVersion:     FormatVersion,
+		Format:            w.format,
+		Columns:           w.columns,
+		RowCount:          w.rowCount,
+		ChecksumAlgorithm: ChecksumAlgorithm,
+		Checksum:          hex.EncodeToString(w.hash.Sum(nil)),
+		CreatedAt:         time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,68 @@
+package export
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testColumns = []string{"id", "amount", "memo"}
+
+func TestCSVExport(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatCSV, testColumns)
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteRow([]string{"1", "1000", "coffee, please"}))
+	assert.NoError(t, w.WriteRow([]string{"2", "21", ""}))
+	manifest, err := w.Close()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "id,amount,memo\n1,1000,\"coffee, please\"\n2,21,\n", buf.String())
+	sum := sha256.Sum256(buf.Bytes())
+	assert.Equal(t, hex.EncodeToString(sum[:]), manifest.Checksum)
+	assert.Equal(t, 2, manifest.RowCount)
+	assert.Equal(t, FormatVersion, manifest.FormatVersion)
+}
+
+func TestJSONExportKeepsColumnOrder(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatJSON, testColumns)
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteRow([]string{"1", "1000", "\"quoted\""}))
+	manifest, err := w.Close()
+	assert.NoError(t, err)
+
+	assert.Equal(t, `[{"id":"1","amount":"1000","memo":"\"quoted\""}]`, buf.String())
+	assert.Equal(t, 1, manifest.RowCount)
+}
+
+func TestExportUnicodeMemos(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatCSV, testColumns)
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteRow([]string{"1", "1000", "咖啡 ☕️"}))
+	assert.NoError(t, w.WriteRow([]string{"2", "21", "family 👨‍👩‍👧"}))
+	_, err = w.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "id,amount,memo\n1,1000,咖啡 ☕️\n2,21,family 👨‍👩‍👧\n", buf.String())
+
+	buf.Reset()
+	w, err = NewWriter(&buf, FormatJSON, testColumns)
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteRow([]string{"1", "1000", "咖啡 ☕️"}))
+	_, err = w.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"id":"1","amount":"1000","memo":"咖啡 ☕️"}]`, buf.String())
+}
+
+func TestExportRejectsInvalidRows(t *testing.T) {
+	_, err := NewWriter(&bytes.Buffer{}, "xml", testColumns)
+	assert.Error(t, err)
+
+	w, err := NewWriter(&bytes.Buffer{}, FormatCSV, testColumns)
+	assert.NoError(t, err)
+	assert.Error(t, w.WriteRow([]string{"1"}))
+}
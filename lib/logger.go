@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -9,9 +10,10 @@ import (
 	"github.com/ziflex/lecho/v3"
 )
 
-func Logger(logFilePath string) *lecho.Logger {
+// Logger logs to STDOUT or the log file, errors are also kept in the error log if one is given
+func Logger(logFilePath string, errorLog *ErrorLog) *lecho.Logger {
 	logger := lecho.New(
-		os.Stdout, // default to STDOUT
+		withErrorLog(os.Stdout, errorLog), // default to STDOUT
 		lecho.WithLevel(log.DEBUG),
 		lecho.WithTimestamp(),
 	)
@@ -21,12 +23,19 @@ func Logger(logFilePath string) *lecho.Logger {
 		if err != nil {
 			logger.Errorf("failed to create logging file: %v", err)
 		}
-		logger.SetOutput(file)
+		logger.SetOutput(withErrorLog(file, errorLog))
 	}
 
 	return logger
 }
 
+func withErrorLog(out io.Writer, errorLog *ErrorLog) io.Writer {
+	if errorLog == nil {
+		return out
+	}
+	return io.MultiWriter(out, errorLog)
+}
+
 func GetLoggingFile(path string) (*os.File, error) {
 	extension := filepath.Ext(path)
 	if extension == "" {
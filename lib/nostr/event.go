@@ -0,0 +1,113 @@
+package nostr
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	KindZapRequest = 9734
+	KindZapReceipt = 9735
+)
+
+var ErrInvalidEvent = errors.New("invalid nostr event")
+
+type Tag []string
+
+// Event is a NIP-01 nostr event
+type Event struct {
+	ID        string `json:"id"`
+	PubKey    string `json:"pubkey"`
+	CreatedAt int64  `json:"created_at"`
+	Kind      int    `json:"kind"`
+	Tags      []Tag  `json:"tags"`
+	Content   string `json:"content"`
+	Sig       string `json:"sig"`
+}
+
+// marshalJSON encodes without HTML escaping, as NIP-01 requires for the serialized event
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Hash returns the sha256 hash of the serialized event, the event id
+func (e *Event) Hash() ([]byte, error) {
+	tags := e.Tags
+	if tags == nil {
+		tags = []Tag{}
+	}
+	serialized, err := marshalJSON([]interface{}{0, e.PubKey, e.CreatedAt, e.Kind, tags, e.Content})
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(serialized)
+	return hash[:], nil
+}
+
+// Sign sets the public key, id and signature of the event
+func (e *Event) Sign(privateKey []byte) error {
+	e.PubKey = hex.EncodeToString(PublicKey(privateKey))
+	if e.Tags == nil {
+		e.Tags = []Tag{}
+	}
+	id, err := e.Hash()
+	if err != nil {
+		return err
+	}
+	auxRand := make([]byte, 32)
+	if _, err := rand.Read(auxRand); err != nil {
+		return err
+	}
+	sig, err := Sign(privateKey, id, auxRand)
+	if err != nil {
+		return err
+	}
+	e.ID = hex.EncodeToString(id)
+	e.Sig = hex.EncodeToString(sig)
+	return nil
+}
+
+// Verify checks the id and the signature of the event
+func (e *Event) Verify() error {
+	id, err := e.Hash()
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(id) != e.ID {
+		return ErrInvalidEvent
+	}
+	pubKey, err := hex.DecodeString(e.PubKey)
+	if err != nil {
+		return ErrInvalidEvent
+	}
+	sig, err := hex.DecodeString(e.Sig)
+	if err != nil {
+		return ErrInvalidEvent
+	}
+	return Verify(pubKey, id, sig)
+}
+
+// Serialize returns the JSON encoding of the event
+func (e *Event) Serialize() ([]byte, error) {
+	return marshalJSON(e)
+}
+
+// FirstTag returns the first tag with the name, nil if the event has none
+func (e *Event) FirstTag(name string) Tag {
+	for _, tag := range e.Tags {
+		if len(tag) > 1 && tag[0] == name {
+			return tag
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const relayTimeout = 10 * time.Second
+
+// Publish sends the event to the relay and waits for the relay's OK message (NIP-20)
+func Publish(ctx context.Context, relayUrl string, event *Event) error {
+	ctx, cancel := context.WithTimeout(ctx, relayTimeout)
+	defer cancel()
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, relayUrl, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetReadDeadline(deadline)
+	conn.SetWriteDeadline(deadline)
+
+	if err := conn.WriteJSON([]interface{}{"EVENT", event}); err != nil {
+		return err
+	}
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var response []json.RawMessage
+		if err := json.Unmarshal(message, &response); err != nil || len(response) < 3 {
+			continue
+		}
+		var messageType, eventId string
+		var accepted bool
+		if json.Unmarshal(response[0], &messageType) != nil || messageType != "OK" {
+			continue
+		}
+		if json.Unmarshal(response[1], &eventId) != nil || eventId != event.ID {
+			continue
+		}
+		if json.Unmarshal(response[2], &accepted) != nil || !accepted {
+			reason := ""
+			if len(response) > 3 {
+				json.Unmarshal(response[3], &reason)
+			}
+			return fmt.Errorf("event rejected by relay: %s", reason)
+		}
+		return nil
+	}
+}
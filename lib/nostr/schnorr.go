@@ -0,0 +1,94 @@
+package nostr
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+var ErrInvalidSignature = errors.New("invalid schnorr signature")
+
+// taggedHash is the BIP-340 hash of msg with the tag
+func taggedHash(tag string, msg ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, m := range msg {
+		h.Write(m)
+	}
+	return h.Sum(nil)
+}
+
+func bytes32(i *big.Int) []byte {
+	b := make([]byte, 32)
+	return i.FillBytes(b)
+}
+
+// PublicKey returns the x-only public key of the private key
+func PublicKey(privateKey []byte) []byte {
+	curve := btcec.S256()
+	px, _ := curve.ScalarBaseMult(privateKey)
+	return bytes32(px)
+}
+
+// Sign creates a BIP-340 schnorr signature of the 32 byte message
+func Sign(privateKey, msg, auxRand []byte) ([]byte, error) {
+	curve := btcec.S256()
+	n := curve.N
+	d := new(big.Int).SetBytes(privateKey)
+	if d.Sign() == 0 || d.Cmp(n) >= 0 {
+		return nil, errors.New("invalid private key")
+	}
+	px, py := curve.ScalarBaseMult(bytes32(d))
+	if py.Bit(0) == 1 {
+		d.Sub(n, d)
+	}
+	t := new(big.Int).Xor(d, new(big.Int).SetBytes(taggedHash("BIP0340/aux", auxRand)))
+	k := new(big.Int).SetBytes(taggedHash("BIP0340/nonce", bytes32(t), bytes32(px), msg))
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		return nil, errors.New("invalid nonce")
+	}
+	rx, ry := curve.ScalarBaseMult(bytes32(k))
+	if ry.Bit(0) == 1 {
+		k.Sub(n, k)
+	}
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", bytes32(rx), bytes32(px), msg))
+	e.Mod(e, n)
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+	return append(bytes32(rx), bytes32(s)...), nil
+}
+
+// Verify checks a BIP-340 schnorr signature of the 32 byte message by the x-only public key
+func Verify(publicKey, msg, signature []byte) error {
+	curve := btcec.S256()
+	if len(publicKey) != 32 || len(signature) != 64 {
+		return ErrInvalidSignature
+	}
+	// a compressed key with an even y coordinate is the lifted x-only key
+	pubKey, err := btcec.ParsePubKey(append([]byte{0x02}, publicKey...), curve)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if r.Cmp(curve.P) >= 0 || s.Cmp(curve.N) >= 0 {
+		return ErrInvalidSignature
+	}
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", signature[:32], publicKey, msg))
+	e.Mod(e, curve.N)
+	e.Sub(curve.N, e)
+	// R = s*G - e*P
+	sx, sy := curve.ScalarBaseMult(bytes32(s))
+	ex, ey := curve.ScalarMult(pubKey.X, pubKey.Y, bytes32(e))
+	rx, ry := curve.Add(sx, sy, ex, ey)
+	if (rx.Sign() == 0 && ry.Sign() == 0) || ry.Bit(0) == 1 || rx.Cmp(r) != 0 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
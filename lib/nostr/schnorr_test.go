@@ -0,0 +1,72 @@
+package nostr
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.NoError(t, err)
+	return b
+}
+
+// test vectors from https://github.com/bitcoin/bips/blob/master/bip-0340/test-vectors.csv
+func TestSign(t *testing.T) {
+	vectors := []struct {
+		secretKey string
+		publicKey string
+		auxRand   string
+		message   string
+		signature string
+	}{
+		{
+			"0000000000000000000000000000000000000000000000000000000000000003",
+			"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		},
+		{
+			"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+			"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+			"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		},
+		{
+			"C90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B14E5C9",
+			"DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8",
+			"C87AA53824B4D7AE2EB035A2B5BBBCCC080E76CDC6D1692C4B0B62D798E6D906",
+			"7E2D58D8B3BCDF1ABADEC7829054F90DDA9805AAB56C77333024B9D0A508B75C",
+			"5831AAEED7B44BB74E5EAB94BA9D4294C49BCF2A60728D8B4C200F50DD313C1BAB745879A5AD954A72C45A91C3A51D3C7ADEA98D82F8481E0E1E03674A6F3FB7",
+		},
+	}
+	for _, v := range vectors {
+		secretKey := decodeHex(t, v.secretKey)
+		message := decodeHex(t, v.message)
+		assert.Equal(t, strings.ToLower(v.publicKey), hex.EncodeToString(PublicKey(secretKey)))
+		signature, err := Sign(secretKey, message, decodeHex(t, v.auxRand))
+		assert.NoError(t, err)
+		assert.Equal(t, strings.ToLower(v.signature), hex.EncodeToString(signature))
+		assert.NoError(t, Verify(decodeHex(t, v.publicKey), message, signature))
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	// public key not on the curve
+	assert.ErrorIs(t, Verify(
+		decodeHex(t, "EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34"),
+		decodeHex(t, "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89"),
+		decodeHex(t, "6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B"),
+	), ErrInvalidSignature)
+	// negated message
+	assert.ErrorIs(t, Verify(
+		decodeHex(t, "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659"),
+		decodeHex(t, "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89"),
+		decodeHex(t, "1FA62E331EDBC21C394792D2AB1100A7B432B013DF3F6FF4F99FCB33E0E1515F28890B3EDB6E7189B630448B515CE4F8622A954CFE545735AAEA5134FCCDB2BD"),
+	), ErrInvalidSignature)
+}
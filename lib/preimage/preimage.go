@@ -0,0 +1,61 @@
+package preimage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Size is the length in bytes of preimages and payment hashes
+const Size = 32
+
+var ErrInvalid = errors.New("preimage or payment hash is invalid")
+var ErrMismatch = errors.New("payment preimage does not match the payment hash")
+
+// New returns a random preimage from the cryptographically secure random source
+func New() ([]byte, error) {
+	preimage := make([]byte, Size)
+	if _, err := rand.Read(preimage); err != nil {
+		return nil, err
+	}
+	return preimage, nil
+}
+
+// Hash returns the payment hash of the preimage
+func Hash(preimage []byte) []byte {
+	hash := sha256.Sum256(preimage)
+	return hash[:]
+}
+
+// HashHex returns the hex encoded payment hash of the preimage
+func HashHex(preimage []byte) string {
+	return hex.EncodeToString(Hash(preimage))
+}
+
+// Parse decodes a hex encoded preimage or payment hash of Size bytes
+func Parse(hexStr string) ([]byte, error) {
+	b, err := hex.DecodeString(hexStr)
+	if err != nil || len(b) != Size {
+		return nil, ErrInvalid
+	}
+	return b, nil
+}
+
+// Verify checks that the sha256 hash of the preimage is the hex encoded payment hash
+func Verify(preimage []byte, paymentHash string) error {
+	if paymentHash == "" || HashHex(preimage) != strings.ToLower(paymentHash) {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// VerifyHex checks a hex encoded preimage against the hex encoded payment hash
+func VerifyHex(preimageHex, paymentHash string) error {
+	preimage, err := hex.DecodeString(preimageHex)
+	if err != nil {
+		return ErrMismatch
+	}
+	return Verify(preimage, paymentHash)
+}
@@ -0,0 +1,44 @@
+package preimage
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	first, err := New()
+	assert.NoError(t, err)
+	assert.Len(t, first, Size)
+	second, err := New()
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
+
+func TestVerify(t *testing.T) {
+	preimage := []byte("0123456789abcdef0123456789abcdef")
+	paymentHash := HashHex(preimage)
+
+	assert.NoError(t, Verify(preimage, paymentHash))
+	assert.NoError(t, Verify(preimage, strings.ToUpper(paymentHash)))
+	assert.ErrorIs(t, Verify([]byte("another preimage"), paymentHash), ErrMismatch)
+	assert.ErrorIs(t, Verify(preimage, ""), ErrMismatch)
+	assert.ErrorIs(t, Verify(nil, paymentHash), ErrMismatch)
+
+	assert.NoError(t, VerifyHex(hex.EncodeToString(preimage), paymentHash))
+	assert.ErrorIs(t, VerifyHex("not hex", paymentHash), ErrMismatch)
+}
+
+func TestParse(t *testing.T) {
+	// sha256 of 32 zero bytes
+	zero, err := Parse(strings.Repeat("00", Size))
+	assert.NoError(t, err)
+	assert.Equal(t, "66687aadf862bd776c8fc18b8e9f8e20089714856ee233b3902a591d0d5f2925", HashHex(zero))
+
+	_, err = Parse(strings.Repeat("00", Size-1))
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = Parse("xyz")
+	assert.ErrorIs(t, err, ErrInvalid)
+}
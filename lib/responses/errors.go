@@ -38,6 +38,192 @@ var NotEnoughBalanceError = ErrorResponse{
 	Message: "not enough balance. Make sure you have at least 1%% reserved for potential fees",
 }
 
+var DestinationNotAllowedError = ErrorResponse{
+	Error:   true,
+	Code:    11,
+	Message: "payments to this destination are not allowed",
+}
+
+var MaxSendAmountExceededError = ErrorResponse{
+	Error:   true,
+	Code:    12,
+	Message: "payment amount exceeds the maximum send amount",
+}
+
+var SendLimitExceededError = ErrorResponse{
+	Error:   true,
+	Code:    13,
+	Message: "send volume limit exceeded",
+}
+
+var SelfPaymentError = ErrorResponse{
+	Error:   true,
+	Code:    14,
+	Message: "paying your own invoice is not possible",
+}
+
+var OutboundLiquidityLowError = ErrorResponse{
+	Error:   true,
+	Code:    15,
+	Message: "outgoing payments are temporarily paused, please try again later",
+}
+
+var InboundLiquidityInsufficientError = ErrorResponse{
+	Error:   true,
+	Code:    16,
+	Message: "invoice amount exceeds what the node can currently receive",
+}
+
+var InvalidBalanceClaimError = ErrorResponse{
+	Error:   true,
+	Code:    17,
+	Message: "balance claim is invalid, expired or already redeemed",
+}
+
+var BalanceImportFailedError = ErrorResponse{
+	Error:   true,
+	Code:    18,
+	Message: "the issuing hub did not pay out the balance claim",
+}
+
+var AccountFrozenError = ErrorResponse{
+	Error:   true,
+	Code:    19,
+	Message: "account is frozen, please contact support",
+}
+
+var SwapTooExpensiveError = ErrorResponse{
+	Error:   true,
+	Code:    20,
+	Message: "quoted swap costs exceed the configured maximum",
+}
+
+var ReceivingPausedError = ErrorResponse{
+	Error:   true,
+	Code:    21,
+	Message: "receiving is temporarily paused, please try again later",
+}
+
+var EncryptedMemoNotInternalError = ErrorResponse{
+	Error:   true,
+	Code:    22,
+	Message: "encrypted memos are only supported for payments to users of this hub",
+}
+
+var LnurlPayFailedError = ErrorResponse{
+	Error:   true,
+	Code:    23,
+	Message: "could not get an invoice from the lightning address or lnurl",
+}
+
+var InvoiceQuotaExceededError = ErrorResponse{
+	Error:   true,
+	Code:    24,
+	Message: "invoice quota exceeded, please pay or let open invoices expire",
+}
+
+var InvoiceExpiredError = ErrorResponse{
+	Error:   true,
+	Code:    25,
+	Message: "invoice has expired",
+}
+
+var PaymentConfirmationRequiredError = ErrorResponse{
+	Error:   true,
+	Code:    26,
+	Message: "payment is unusually large, send it again with the confirmation token to confirm it",
+}
+
+var InvalidPaymentConfirmationError = ErrorResponse{
+	Error:   true,
+	Code:    27,
+	Message: "payment confirmation is invalid, expired or already used",
+}
+
+var PaymentNotRetryableError = ErrorResponse{
+	Error:   true,
+	Code:    28,
+	Message: "only failed invoice payments can be retried",
+}
+
+var AmountOutOfRangeError = ErrorResponse{
+	Error:   true,
+	Code:    29,
+	Message: "amount is outside of the allowed limits, see amount_limits of /getinfo",
+}
+
+var InvalidInvoiceExpiryError = ErrorResponse{
+	Error:   true,
+	Code:    30,
+	Message: "invoice expiry is outside of the allowed range",
+}
+
+var InvalidPreimageError = ErrorResponse{
+	Error:   true,
+	Code:    31,
+	Message: "preimage or payment hash is invalid or already used",
+}
+
+var HoldInvoiceNotFoundError = ErrorResponse{
+	Error:   true,
+	Code:    32,
+	Message: "hold invoice not found or already settled or canceled",
+}
+
+var InvalidRecurringIntervalError = ErrorResponse{
+	Error:   true,
+	Code:    33,
+	Message: "recurring invoice interval is too short",
+}
+
+var InvalidWebhookUrlError = ErrorResponse{
+	Error:   true,
+	Code:    34,
+	Message: "webhook url must be an http or https url",
+}
+
+var InvoiceNotCancelableError = ErrorResponse{
+	Error:   true,
+	Code:    35,
+	Message: "invoice not found or not open",
+}
+
+var PaymentInProgressError = ErrorResponse{
+	Error:   true,
+	Code:    36,
+	Message: "a payment of this invoice is already in progress",
+}
+
+var IdempotencyKeyInUseError = ErrorResponse{
+	Error:   true,
+	Code:    37,
+	Message: "a request with this idempotency key is still in progress",
+}
+
+var IdempotencyKeyMismatchError = ErrorResponse{
+	Error:   true,
+	Code:    38,
+	Message: "the idempotency key was already used for a different request",
+}
+
+var AccountNotEmptyError = ErrorResponse{
+	Error:   true,
+	Code:    39,
+	Message: "withdraw the balance and wait for pending payments before deleting the account",
+}
+
+var TransferRecipientNotFoundError = ErrorResponse{
+	Error:   true,
+	Code:    40,
+	Message: "recipient not found or can not receive transfers",
+}
+
+var ScopeNotGrantedError = ErrorResponse{
+	Error:   true,
+	Code:    41,
+	Message: "the credentials do not grant access to this request",
+}
+
 func HTTPErrorHandler(err error, c echo.Context) {
 	if c.Response().Committed {
 		return
@@ -58,3 +244,15 @@ func HTTPErrorHandler(err error, c echo.Context) {
 	}
 	// TODO: use an error matching the error code
 }
+
+var SpendLimitExceededError = ErrorResponse{
+	Error:   true,
+	Code:    42,
+	Message: "the payment exceeds the spend limit of the api key or token",
+}
+
+var PaymentNotFailedError = ErrorResponse{
+	Error:   true,
+	Code:    43,
+	Message: "the node has not failed the payment, it can not be force failed",
+}
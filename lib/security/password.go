@@ -1,9 +1,17 @@
 package security
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
+// LegacyPasswordPrefix marks password hashes imported from the Node.js LndHub, the hex encoded sha256 of the password
+const LegacyPasswordPrefix = "lndhub-sha256:"
+
 // HashPassword : Hash Password
 func HashPassword(password string) string {
 	bytes, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -11,3 +19,20 @@ func HashPassword(password string) string {
 
 	return password
 }
+
+// LegacyPasswordHash returns the hash of an imported LndHub password from the sha256 stored by LndHub
+func LegacyPasswordHash(sha256Hex string) string {
+	return LegacyPasswordPrefix + strings.ToLower(sha256Hex)
+}
+
+// CheckPassword compares the password with a bcrypt hash or an imported LndHub hash
+// rehash reports that the stored hash is an imported one and should be replaced with HashPassword
+func CheckPassword(hash, password string) (ok, rehash bool) {
+	if strings.HasPrefix(hash, LegacyPasswordPrefix) {
+		sum := sha256.Sum256([]byte(password))
+		expected := strings.TrimPrefix(hash, LegacyPasswordPrefix)
+		ok = subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(expected)) == 1
+		return ok, ok
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, false
+}
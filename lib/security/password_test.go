@@ -0,0 +1,24 @@
+package security
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPassword(t *testing.T) {
+	ok, rehash := CheckPassword(HashPassword("secret"), "secret")
+	assert.True(t, ok)
+	assert.False(t, rehash)
+	ok, _ = CheckPassword(HashPassword("secret"), "wrong")
+	assert.False(t, ok)
+
+	// sha256("secret") as stored by LndHub in the user_<login>_<hash> key
+	legacy := LegacyPasswordHash("2BB80D537B1DA3E38BD30361AA855686BDE0EACD7162FEF6A25FE97BF527A25B")
+	ok, rehash = CheckPassword(legacy, "secret")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+	ok, rehash = CheckPassword(legacy, "wrong")
+	assert.False(t, ok)
+	assert.False(t, rehash)
+}
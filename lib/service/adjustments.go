@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/preimage"
+	"github.com/uptrace/bun"
+)
+
+const AuditActionAdjustBalance = "adjust_balance"
+
+// shown to the user if the operator does not give a memo
+const defaultAdjustmentMemo = "Balance adjustment"
+
+var ErrInvalidAdjustment = errors.New("amount must not be 0 and a reason is required")
+var ErrAdjustmentExceedsBalance = errors.New("debit is larger than the balance")
+
+// adjustmentInvoice is the settled invoice the entries of an adjustment are booked for
+// Credits are incoming and debits outgoing invoices, so they are listed with the user's transactions
+func adjustmentInvoice(userId, amount int64, memo string, now time.Time) (*models.Invoice, error) {
+	invoicePreimage, err := preimage.New()
+	if err != nil {
+		return nil, err
+	}
+	invoiceType := common.InvoiceTypeIncoming
+	if amount < 0 {
+		invoiceType = common.InvoiceTypeOutgoing
+		amount = -amount
+	}
+	if memo == "" {
+		memo = defaultAdjustmentMemo
+	}
+	return &models.Invoice{
+		Type:      invoiceType,
+		UserID:    userId,
+		Amount:    amount,
+		Memo:      memo,
+		RHash:     preimage.HashHex(invoicePreimage),
+		Preimage:  hex.EncodeToString(invoicePreimage),
+		Internal:  true,
+		State:     common.InvoiceStateSettled,
+		Metadata:  map[string]string{common.InvoiceMetadataSource: common.InvoiceSourceAdjustment},
+		CreatedAt: now,
+		SettledAt: bun.NullTime{Time: now},
+	}, nil
+}
+
+// adjustmentsAccountFor returns the user's adjustments account, it is created on first use
+func (svc *LndhubService) adjustmentsAccountFor(ctx context.Context, db bun.IDB, userId int64) (*models.Account, error) {
+	account, err := svc.AccountFor(ctx, common.AccountTypeAdjustments, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		account = models.Account{UserID: userId, Type: common.AccountTypeAdjustments}
+		_, err = db.NewInsert().Model(&account).Exec(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// AdjustBalance credits a positive or debits a negative amount to the user's balance, e.g. for support refunds and
+// corrections. The amount is booked against the user's adjustments account for a settled invoice with the memo, the
+// reason is recorded in the audit log. Debits can not exceed the balance
+func (svc *LndhubService) AdjustBalance(ctx context.Context, userId, amount int64, reason, memo string) (*models.Invoice, error) {
+	if amount == 0 || reason == "" {
+		return nil, ErrInvalidAdjustment
+	}
+	currentAccount, err := svc.AccountFor(ctx, common.AccountTypeCurrent, userId)
+	if err != nil {
+		return nil, err
+	}
+	invoice, err := adjustmentInvoice(userId, amount, svc.sanitizeMemo(memo), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		// payments lock the current account as well, the balance can not change until the debit is booked
+		if err := lockAccount(ctx, tx, currentAccount.ID); err != nil {
+			return err
+		}
+		if amount < 0 {
+			balance, err := svc.accountBalance(ctx, tx, currentAccount.ID)
+			if err != nil {
+				return err
+			}
+			if balance < -amount {
+				return ErrAdjustmentExceedsBalance
+			}
+		}
+		adjustmentsAccount, err := svc.adjustmentsAccountFor(ctx, tx, userId)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.NewInsert().Model(invoice).Exec(ctx); err != nil {
+			return err
+		}
+		entry := models.TransactionEntry{
+			UserID:          userId,
+			InvoiceID:       invoice.ID,
+			DebitAccountID:  adjustmentsAccount.ID,
+			CreditAccountID: currentAccount.ID,
+			Amount:          invoice.Amount,
+		}
+		if amount < 0 {
+			entry.DebitAccountID, entry.CreditAccountID = currentAccount.ID, adjustmentsAccount.ID
+		}
+		if _, err := tx.NewInsert().Model(&entry).Exec(ctx); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	svc.Logger.Infof("Balance adjusted by admin user_id:%v invoice_id:%v amount:%v", userId, invoice.ID, amount)
+	err = svc.AddAuditLog(ctx, AuditActionAdjustBalance, userId, invoice.ID, fmt.Sprintf("%+d: %s", amount, reason))
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/lib/preimage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdjustmentInvoice(t *testing.T) {
+	now := time.Now()
+	credit, err := adjustmentInvoice(3, 500, "", now)
+	assert.NoError(t, err)
+	assert.Equal(t, common.InvoiceTypeIncoming, credit.Type)
+	assert.Equal(t, int64(500), credit.Amount)
+	assert.Equal(t, defaultAdjustmentMemo, credit.Memo)
+	assert.Equal(t, common.InvoiceStateSettled, credit.State)
+	assert.Equal(t, common.InvoiceSourceAdjustment, credit.Metadata[common.InvoiceMetadataSource])
+	assert.Equal(t, now, credit.SettledAt.Time)
+	assert.NoError(t, preimage.VerifyHex(credit.Preimage, credit.RHash))
+
+	debit, err := adjustmentInvoice(3, -200, "Refund reverted", now)
+	assert.NoError(t, err)
+	assert.Equal(t, common.InvoiceTypeOutgoing, debit.Type)
+	assert.Equal(t, int64(200), debit.Amount)
+	assert.Equal(t, "Refund reverted", debit.Memo)
+	assert.NotEqual(t, credit.RHash, debit.RHash)
+}
+
+func TestAdjustBalanceValidation(t *testing.T) {
+	svc := &LndhubService{Config: &Config{}}
+	_, err := svc.AdjustBalance(context.Background(), 1, 0, "refund", "")
+	assert.ErrorIs(t, err, ErrInvalidAdjustment)
+	_, err = svc.AdjustBalance(context.Background(), 1, 100, "", "")
+	assert.ErrorIs(t, err, ErrInvalidAdjustment)
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/uptrace/bun"
+)
+
+const AuditActionForceFailInvoice = "force_fail_invoice"
+
+var ErrInvoiceNotInFlight = errors.New("invoice is not an in-flight outgoing invoice")
+var ErrPaymentNotFailed = errors.New("the node has not failed the payment")
+
+func (svc *LndhubService) AddAuditLog(ctx context.Context, action string, userId, invoiceId int64, details string) error {
+	return addAuditLog(ctx, svc.DB, action, userId, invoiceId, details)
+}
+
+// addAuditLog inserts the audit log with db, a transaction commits it together with the logged change
+func addAuditLog(ctx context.Context, db bun.IDB, action string, userId, invoiceId int64, details string) error {
+	auditLog := models.AuditLog{
+		Action:    action,
+		UserID:    userId,
+		InvoiceID: invoiceId,
+		Details:   details,
+	}
+	_, err := db.NewInsert().Model(&auditLog).Exec(ctx)
+	return err
+}
+
+// ForceFailOutgoingInvoice fails an outgoing invoice that is stuck in-flight
+// The node is asked first, payments it still has in flight or has sent can not be failed
+// The user's debit is reverted in the same way as for any other failed payment, together with the audit log
+func (svc *LndhubService) ForceFailOutgoingInvoice(ctx context.Context, invoiceId int64, reason string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := svc.DB.NewSelect().Model(&invoice).Where("id = ?", invoiceId).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.Type != common.InvoiceTypeOutgoing || invoice.State != common.InvoiceStateInitialized {
+		return nil, ErrInvoiceNotInFlight
+	}
+	if err := svc.checkNodePaymentFailed(ctx, &invoice); err != nil {
+		return nil, err
+	}
+
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		// the payment may have been settled or failed since it was loaded
+		if err := forUpdate(tx, tx.NewSelect().Model(&invoice).WherePK()).Scan(ctx); err != nil {
+			return err
+		}
+		if invoice.State != common.InvoiceStateInitialized {
+			return ErrInvoiceNotInFlight
+		}
+		// the latest entry debiting the user's current account that was not reverted yet, fee entries have a parent
+		// Retried payments keep the invoice, the debits of the earlier attempts were reverted when they failed
+		currentAccount, err := svc.AccountFor(ctx, common.AccountTypeCurrent, invoice.UserID)
+		if err != nil {
+			return err
+		}
+		var entry models.TransactionEntry
+		err = tx.NewSelect().Model(&entry).
+			Where("transaction_entry.invoice_id = ? AND transaction_entry.parent_id IS NULL AND transaction_entry.debit_account_id = ?", invoice.ID, currentAccount.ID).
+			Where(`NOT EXISTS (SELECT 1 FROM transaction_entries AS reversal WHERE reversal.invoice_id = transaction_entry.invoice_id
+				AND reversal.parent_id IS NULL AND reversal.credit_account_id = transaction_entry.debit_account_id AND reversal.id > transaction_entry.id)`).
+			OrderExpr("transaction_entry.id DESC").Limit(1).Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if err := svc.revertFailedPayment(ctx, tx, &invoice, entry, fmt.Errorf("force failed by admin: %s", reason)); err != nil {
+			return err
+		}
+		return addAuditLog(ctx, tx, AuditActionForceFailInvoice, invoice.UserID, invoice.ID, reason)
+	})
+	if err != nil {
+		svc.Logger.Errorf("Could not force fail invoice user_id:%v invoice_id:%v %v", invoice.UserID, invoice.ID, err)
+		return nil, err
+	}
+	svc.onPaymentFailed(ctx, &invoice)
+	return &invoice, nil
+}
+
+// checkNodePaymentFailed returns ErrPaymentNotFailed unless the node failed the payment of the invoice or never sent it
+// Keysend invoices get their payment hash before they are sent
+func (svc *LndhubService) checkNodePaymentFailed(ctx context.Context, invoice *models.Invoice) error {
+	if invoice.RHash == "" {
+		return nil
+	}
+	if invoice.DestinationPubkeyHex == svc.IdentityPubkey {
+		return svc.checkInternalPaymentFailed(ctx, invoice)
+	}
+	paymentHash, err := hex.DecodeString(invoice.RHash)
+	if err != nil {
+		return err
+	}
+	payment, err := svc.LndClient.TrackPayment(ctx, paymentHash)
+	if errors.Is(err, lnd.ErrPaymentNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if payment.Status != lnrpc.Payment_FAILED {
+		svc.Logger.Errorf("Node payment is not failed invoice_id:%v status:%v", invoice.ID, payment.Status)
+		return fmt.Errorf("%w: the node reports %v", ErrPaymentNotFailed, payment.Status)
+	}
+	return nil
+}
+
+// checkInternalPaymentFailed returns ErrPaymentNotFailed if the recipient's invoice of an internal payment is settled
+// Internal payments are not sent by the node, the recipient is credited before the payment is settled
+func (svc *LndhubService) checkInternalPaymentFailed(ctx context.Context, invoice *models.Invoice) error {
+	settled, err := svc.DB.NewSelect().Model((*models.Invoice)(nil)).
+		Where("type = ? AND state = ? AND r_hash = ?", common.InvoiceTypeIncoming, common.InvoiceStateSettled, invoice.RHash).
+		Exists(ctx)
+	if err != nil {
+		return err
+	}
+	if settled {
+		svc.Logger.Errorf("Internal payment recipient was credited invoice_id:%v", invoice.ID)
+		return fmt.Errorf("%w: the recipient's invoice is settled", ErrPaymentNotFailed)
+	}
+	return nil
+}
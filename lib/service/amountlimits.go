@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+)
+
+// lnurlMaxSendable caps the maxSendable of LNURL-pay responses if the hub has no receive limit
+const lnurlMaxSendable = 100000000 // in satoshis
+
+var ErrAmountOutOfRange = errors.New("amount is outside of the allowed limits")
+
+// ReceiveAmountError is returned for invoice amounts outside of the receive limits, it matches ErrAmountOutOfRange
+type ReceiveAmountError struct {
+	Amount        int64
+	MinReceivable int64
+	MaxReceivable int64
+}
+
+func (e *ReceiveAmountError) Error() string {
+	if e.Amount < e.MinReceivable {
+		return fmt.Sprintf("invoice amount must be at least %d sats", e.MinReceivable)
+	}
+	return fmt.Sprintf("invoice amount must be at most %d sats", e.MaxReceivable)
+}
+
+func (e *ReceiveAmountError) Is(target error) bool {
+	return target == ErrAmountOutOfRange
+}
+
+// AmountLimits are the amounts in satoshis that can be sent and received, a maximum of 0 means no limit
+// The same limits are validated by the API, announced in LNURL-pay responses and shown in getinfo
+type AmountLimits struct {
+	MinSendable   int64 `json:"min_sendable"`
+	MaxSendable   int64 `json:"max_sendable"`
+	MinReceivable int64 `json:"min_receivable"`
+	MaxReceivable int64 `json:"max_receivable"`
+}
+
+// AmountLimits returns the limits of the tier, the hub-wide limits if tier is nil
+func (svc *LndhubService) AmountLimits(tier *TierSettings) AmountLimits {
+	limits := AmountLimits{
+		MinSendable:   1,
+		MaxSendable:   svc.Config.MaxSendAmount,
+		MinReceivable: svc.Config.MinReceiveAmount,
+		MaxReceivable: svc.Config.MaxReceiveAmount,
+	}
+	if tier != nil {
+		limits.MaxSendable = tier.MaxSendAmount
+	}
+	if limits.MinReceivable < 1 {
+		limits.MinReceivable = 1
+	}
+	return limits
+}
+
+// CheckSendAmount returns ErrMaxSendAmountExceeded for payments above the maximum
+func (l AmountLimits) CheckSendAmount(amount int64) error {
+	if amount < l.MinSendable {
+		return ErrAmountOutOfRange
+	}
+	if l.MaxSendable > 0 && amount > l.MaxSendable {
+		return ErrMaxSendAmountExceeded
+	}
+	return nil
+}
+
+// CheckReceiveAmount validates the amount of a new invoice, 0 creates an invoice without an amount
+func (l AmountLimits) CheckReceiveAmount(amount int64) error {
+	if amount == 0 {
+		return nil
+	}
+	if amount < l.MinReceivable || (l.MaxReceivable > 0 && amount > l.MaxReceivable) {
+		return &ReceiveAmountError{Amount: amount, MinReceivable: l.MinReceivable, MaxReceivable: l.MaxReceivable}
+	}
+	return nil
+}
+
+// LnurlSendable returns the minSendable and maxSendable in millisatoshis of LNURL-pay responses (LUD-06)
+func (l AmountLimits) LnurlSendable() (int64, int64) {
+	max := l.MaxReceivable
+	if max <= 0 || max > lnurlMaxSendable {
+		max = lnurlMaxSendable
+	}
+	return l.MinReceivable * 1000, max * 1000
+}
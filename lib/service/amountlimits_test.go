@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAmountLimits(t *testing.T) {
+	limits := AmountLimits{MinSendable: 1, MaxSendable: 1000, MinReceivable: 10, MaxReceivable: 5000}
+	assert.NoError(t, limits.CheckSendAmount(1000))
+	assert.ErrorIs(t, limits.CheckSendAmount(1001), ErrMaxSendAmountExceeded)
+	assert.ErrorIs(t, limits.CheckSendAmount(0), ErrAmountOutOfRange)
+
+	assert.NoError(t, limits.CheckReceiveAmount(0))
+	assert.NoError(t, limits.CheckReceiveAmount(10))
+	assert.ErrorIs(t, limits.CheckReceiveAmount(9), ErrAmountOutOfRange)
+	assert.ErrorIs(t, limits.CheckReceiveAmount(5001), ErrAmountOutOfRange)
+	assert.EqualError(t, limits.CheckReceiveAmount(9), "invoice amount must be at least 10 sats")
+	assert.EqualError(t, limits.CheckReceiveAmount(5001), "invoice amount must be at most 5000 sats")
+
+	minSendable, maxSendable := limits.LnurlSendable()
+	assert.Equal(t, int64(10000), minSendable)
+	assert.Equal(t, int64(5000000), maxSendable)
+
+	// without a limit LNURL-pay responses announce the LNURL maximum
+	unlimited := AmountLimits{MinSendable: 1, MinReceivable: 1}
+	assert.NoError(t, unlimited.CheckSendAmount(1000000))
+	_, maxSendable = unlimited.LnurlSendable()
+	assert.Equal(t, int64(lnurlMaxSendable*1000), maxSendable)
+}
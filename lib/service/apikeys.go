@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/uptrace/bun"
+)
+
+const maxAPIKeysPerUser = 20
+
+// the last use of a key is recorded at most once per interval, not on every request
+const apiKeyLastUsedInterval = time.Minute
+
+var ErrInvalidAPIKey = errors.New("an api key needs a name and at least one valid scope")
+var ErrTooManyAPIKeys = errors.New("too many api keys")
+
+// validateAPIKeyScopes checks that there is at least one scope and that all scopes are known
+func validateAPIKeyScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return ErrInvalidAPIKey
+	}
+	for _, scope := range scopes {
+		if !tokens.ValidScope(scope) {
+			return ErrInvalidAPIKey
+		}
+	}
+	return nil
+}
+
+// CreateAPIKey creates an api key with the scopes, the key is only returned here and can not be shown again
+// Payments made with the key are limited by the spend limit, limits of zero are not enforced
+func (svc *LndhubService) CreateAPIKey(ctx context.Context, userId int64, name string, scopes []string, maxAmountPerPayment, maxAmountPerDay int64) (*models.APIKey, string, error) {
+	if name == "" {
+		return nil, "", ErrInvalidAPIKey
+	}
+	if err := validateAPIKeyScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	if err := validateSpendLimit(maxAmountPerPayment, maxAmountPerDay); err != nil {
+		return nil, "", err
+	}
+	count, err := svc.DB.NewSelect().Model((*models.APIKey)(nil)).Where("user_id = ?", userId).Count(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if count >= maxAPIKeysPerUser {
+		return nil, "", ErrTooManyAPIKeys
+	}
+	key, hash, hint, err := tokens.GenerateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	apiKey := &models.APIKey{
+		UserID:              userId,
+		Name:                name,
+		KeyHash:             hash,
+		Hint:                hint,
+		Scopes:              scopes,
+		MaxAmountPerPayment: maxAmountPerPayment,
+		MaxAmountPerDay:     maxAmountPerDay,
+	}
+	if _, err := svc.DB.NewInsert().Model(apiKey).Returning("*").Exec(ctx); err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+func (svc *LndhubService) APIKeysFor(ctx context.Context, userId int64) ([]models.APIKey, error) {
+	apiKeys := []models.APIKey{}
+	err := svc.DB.NewSelect().Model(&apiKeys).Where("user_id = ?", userId).OrderExpr("id ASC").Scan(ctx)
+	return apiKeys, err
+}
+
+// DeleteAPIKey revokes the api key, requests with it are rejected right away
+func (svc *LndhubService) DeleteAPIKey(ctx context.Context, userId, apiKeyId int64) error {
+	_, err := svc.DB.NewDelete().Model((*models.APIKey)(nil)).Where("id = ? AND user_id = ?", apiKeyId, userId).Exec(ctx)
+	return err
+}
+
+// AuthenticateAPIKey returns the user, the scopes and the spend limit of an api key, keys of deleted users are rejected
+func (svc *LndhubService) AuthenticateAPIKey(ctx context.Context, key string) (*tokens.APIKeyCredentials, error) {
+	var apiKey models.APIKey
+	err := svc.DB.NewSelect().Model(&apiKey).
+		Where("key_hash = ?", tokens.HashAPIKey(key)).
+		Where("user_id IN (?)", svc.DB.NewSelect().Model((*models.User)(nil)).Column("id").Where("deleted_at IS NULL AND frozen_at IS NULL")).
+		Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if apiKey.LastUsedAt.IsZero() || now.Sub(apiKey.LastUsedAt.Time) > apiKeyLastUsedInterval {
+		_, err := svc.DB.NewUpdate().Model(&apiKey).Set("last_used_at = ?", bun.NullTime{Time: now}).WherePK().Exec(ctx)
+		if err != nil {
+			svc.Logger.Errorf("Could not record the use of api key api_key_id:%v %v", apiKey.ID, err)
+		}
+	}
+	return &tokens.APIKeyCredentials{
+		UserID: apiKey.UserID,
+		Scopes: apiKey.Scopes,
+		SpendLimit: &tokens.SpendLimit{
+			Credential:          tokens.APIKeyCredential(apiKey.ID),
+			MaxAmountPerPayment: apiKey.MaxAmountPerPayment,
+			MaxAmountPerDay:     apiKey.MaxAmountPerDay,
+		},
+	}, nil
+}
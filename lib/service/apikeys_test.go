@@ -0,0 +1,19 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAPIKeyScopes(t *testing.T) {
+	assert.NoError(t, validateAPIKeyScopes([]string{tokens.ScopeInvoiceCreate, tokens.ScopeAccountRead}))
+	assert.ErrorIs(t, validateAPIKeyScopes(nil), ErrInvalidAPIKey)
+	assert.ErrorIs(t, validateAPIKeyScopes([]string{tokens.ScopeAccountRead, "admin"}), ErrInvalidAPIKey)
+
+	svc := &LndhubService{Config: &Config{}}
+	_, _, err := svc.CreateAPIKey(context.Background(), 1, "", []string{tokens.ScopeAccountRead}, 0, 0)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/getsentry/sentry-go"
+	"github.com/uptrace/bun"
+)
+
+// an auto-withdrawal is disabled after this many payouts failed in a row, the user has to enable it again
+const maxAutoWithdrawFailures = 3
+
+// sent as LNURL comment with every payout
+const autoWithdrawComment = "Auto-withdraw"
+
+var ErrInvalidAutoWithdrawal = errors.New("threshold must not be negative and the destination must be a lightning address")
+
+// SetAutoWithdrawal configures the payout of the balance above the threshold to the lightning address
+// Enabling it again resets the failures of earlier payouts
+func (svc *LndhubService) SetAutoWithdrawal(ctx context.Context, userId, threshold int64, destination string, enabled bool) (*models.AutoWithdrawal, error) {
+	destination = strings.TrimSpace(destination)
+	if _, err := LnurlPayUrl(destination); threshold < 0 || err != nil || !strings.Contains(destination, "@") {
+		return nil, ErrInvalidAutoWithdrawal
+	}
+	autoWithdrawal, err := svc.AutoWithdrawalFor(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		autoWithdrawal = &models.AutoWithdrawal{UserID: userId}
+	} else if err != nil {
+		return nil, err
+	}
+	if enabled && !autoWithdrawal.Enabled {
+		autoWithdrawal.Failures = 0
+		autoWithdrawal.LastError = ""
+	}
+	autoWithdrawal.Threshold = threshold
+	autoWithdrawal.Destination = destination
+	autoWithdrawal.Enabled = enabled
+	if autoWithdrawal.ID == 0 {
+		_, err = svc.DB.NewInsert().Model(autoWithdrawal).Returning("*").Exec(ctx)
+	} else {
+		_, err = svc.DB.NewUpdate().Model(autoWithdrawal).WherePK().Exec(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return autoWithdrawal, nil
+}
+
+func (svc *LndhubService) AutoWithdrawalFor(ctx context.Context, userId int64) (*models.AutoWithdrawal, error) {
+	var autoWithdrawal models.AutoWithdrawal
+	err := svc.DB.NewSelect().Model(&autoWithdrawal).Where("user_id = ?", userId).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &autoWithdrawal, nil
+}
+
+func (svc *LndhubService) DeleteAutoWithdrawal(ctx context.Context, userId int64) error {
+	_, err := svc.DB.NewDelete().Model((*models.AutoWithdrawal)(nil)).Where("user_id = ?", userId).Exec(ctx)
+	return err
+}
+
+// autoWithdrawAmount returns the largest amount that can be paid out of the excess balance together with its fees
+// Fees grow with the amount, the amount is lowered by the fees until both fit
+func autoWithdrawAmount(excess int64, feesFor func(amount int64) (int64, error)) (int64, error) {
+	amount := excess
+	for amount > 0 {
+		fees, err := feesFor(amount)
+		if err != nil {
+			return 0, err
+		}
+		if amount+fees <= excess {
+			return amount, nil
+		}
+		if next := excess - fees; next < amount {
+			amount = next
+		} else {
+			amount--
+		}
+	}
+	return 0, nil
+}
+
+// RunAutoWithdrawals pays out the balance above the threshold of the enabled auto-withdrawals
+// It returns the number of payouts that were made
+func (svc *LndhubService) RunAutoWithdrawals(ctx context.Context) (int, error) {
+	autoWithdrawals := []models.AutoWithdrawal{}
+	err := svc.DB.NewSelect().Model(&autoWithdrawals).Where("enabled").OrderExpr("id ASC").Scan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	paid := 0
+	for i := range autoWithdrawals {
+		autoWithdrawal := &autoWithdrawals[i]
+		invoice, err := svc.autoWithdraw(ctx, autoWithdrawal)
+		if invoice == nil && err == nil {
+			continue
+		}
+		autoWithdrawal.LastRunAt = bun.NullTime{Time: time.Now()}
+		if invoice != nil {
+			autoWithdrawal.LastInvoiceID = invoice.ID
+		}
+		if err != nil {
+			svc.Logger.Errorf("Auto-withdrawal failed user_id:%v destination:%s %v", autoWithdrawal.UserID, autoWithdrawal.Destination, err)
+			autoWithdrawal.Failures++
+			autoWithdrawal.LastError = err.Error()
+			autoWithdrawal.Enabled = autoWithdrawal.Failures < maxAutoWithdrawFailures
+		} else {
+			autoWithdrawal.Failures = 0
+			autoWithdrawal.LastError = ""
+			paid++
+		}
+		if _, err := svc.DB.NewUpdate().Model(autoWithdrawal).WherePK().Exec(ctx); err != nil {
+			svc.Logger.Errorf("Could not update auto-withdrawal user_id:%v %v", autoWithdrawal.UserID, err)
+		}
+	}
+	return paid, nil
+}
+
+// autoWithdraw pays the balance above the threshold to the destination with an LNURL-pay invoice
+// Nothing is paid while the excess is below AUTO_WITHDRAW_MIN_AMOUNT. The payout is a normal outgoing invoice, the
+// invoice is returned for failed payments as well
+func (svc *LndhubService) autoWithdraw(ctx context.Context, autoWithdrawal *models.AutoWithdrawal) (*models.Invoice, error) {
+	balance, err := svc.CurrentUserBalance(ctx, autoWithdrawal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	excess := balance - autoWithdrawal.Threshold
+	if excess < svc.Config.AutoWithdrawMinAmount || excess <= 0 {
+		return nil, nil
+	}
+	tier, err := svc.UserTierSettings(ctx, autoWithdrawal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := autoWithdrawAmount(excess, func(amount int64) (int64, error) {
+		// the fee reserve of a payment to another node, payouts to this hub reserve nothing
+		feeReserve, err := svc.FeeReserveFor(&models.Invoice{Amount: amount})
+		return tier.OutgoingServiceFeeFor(amount) + feeReserve, err
+	})
+	if err != nil || amount <= 0 {
+		return nil, err
+	}
+
+	params, err := svc.ResolveLnurlPay(ctx, autoWithdrawal.Destination)
+	if err != nil {
+		return nil, err
+	}
+	paymentRequest, decodedPaymentRequest, err := svc.FetchLnurlPayInvoice(ctx, params, amount, autoWithdrawComment)
+	if err != nil {
+		return nil, err
+	}
+	invoice, err := svc.AddOutgoingInvoice(ctx, autoWithdrawal.UserID, paymentRequest, &lnd.LNPayReq{PayReq: decodedPaymentRequest})
+	if err != nil {
+		return nil, err
+	}
+	invoice.Metadata = map[string]string{common.InvoiceMetadataSource: common.InvoiceSourceAutoWithdraw}
+	if _, err := svc.DB.NewUpdate().Model(invoice).Column("metadata").WherePK().Exec(ctx); err != nil {
+		return invoice, err
+	}
+	svc.Logger.Infof("Auto-withdrawing user_id:%v invoice_id:%v amount:%v destination:%s", autoWithdrawal.UserID, invoice.ID, amount, autoWithdrawal.Destination)
+	_, err = svc.PayInvoice(ctx, invoice)
+	return invoice, err
+}
+
+// StartAutoWithdrawScheduler pays out the balances above the auto-withdrawal thresholds every AUTO_WITHDRAW_INTERVAL
+func (svc *LndhubService) StartAutoWithdrawScheduler(ctx context.Context) {
+	if svc.Config.AutoWithdrawInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(svc.Config.AutoWithdrawInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		paid, err := svc.RunAutoWithdrawals(ctx)
+		if err != nil {
+			svc.Logger.Errorf("Error running auto-withdrawals: %v", err)
+			sentry.CaptureException(err)
+		}
+		if paid > 0 {
+			svc.Logger.Infof("Made %v auto-withdrawals", paid)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoWithdrawAmount(t *testing.T) {
+	// 1% fees, at least 1 sat
+	percentFees := func(amount int64) (int64, error) {
+		fees := amount / 100
+		if fees < 1 {
+			fees = 1
+		}
+		return fees, nil
+	}
+	amount, err := autoWithdrawAmount(10000, percentFees)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9900), amount)
+	fees, _ := percentFees(amount)
+	assert.LessOrEqual(t, amount+fees, int64(10000))
+
+	amount, err = autoWithdrawAmount(1, percentFees)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), amount)
+
+	amount, err = autoWithdrawAmount(500, func(amount int64) (int64, error) { return 0, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), amount)
+
+	_, err = autoWithdrawAmount(500, func(amount int64) (int64, error) { return 0, errors.New("no fee limit") })
+	assert.Error(t, err)
+}
+
+func TestSetAutoWithdrawalValidation(t *testing.T) {
+	svc := &LndhubService{Config: &Config{}}
+	_, err := svc.SetAutoWithdrawal(context.Background(), 1, -1, "satoshi@example.com", true)
+	assert.ErrorIs(t, err, ErrInvalidAutoWithdrawal)
+	_, err = svc.SetAutoWithdrawal(context.Background(), 1, 1000, "not an address", true)
+	assert.ErrorIs(t, err, ErrInvalidAutoWithdrawal)
+}
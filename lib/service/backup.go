@@ -0,0 +1,218 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/uptrace/bun"
+)
+
+const (
+	backupPollInterval   = time.Minute
+	backupCommandTimeout = time.Hour
+	backupWebhookTimeout = 30 * time.Second
+	backupOutputLimit    = 1000
+	// payments in flight for longer, e.g. to hold invoices or with unresolved HTLCs, do not delay the backup
+	backupStalePaymentAge = time.Hour
+)
+
+var ErrBackupsDisabled = errors.New("backups are not configured")
+
+// backupStatus is exported by expvar with the state and times of the last backup run
+var backupStatus = expvar.NewMap("backup")
+
+// BackupDue reports if the nightly backup has not run yet today and BACKUP_HOUR has passed
+func (svc *LndhubService) BackupDue(ctx context.Context, now time.Time) (bool, error) {
+	now = now.UTC()
+	if now.Hour() < svc.Config.BackupHour {
+		return false, nil
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	ran, err := svc.DB.NewSelect().Model((*models.BackupRun)(nil)).Where("started_at >= ?", today).Exists(ctx)
+	return !ran, err
+}
+
+// paymentsInFlight counts the outgoing payments that were debited since the given time but are not completed yet
+func (svc *LndhubService) paymentsInFlight(ctx context.Context, since time.Time) (int, error) {
+	return svc.DB.NewSelect().Model((*models.Invoice)(nil)).
+		Where("type = ? AND state = ?", common.InvoiceTypeOutgoing, common.InvoiceStateInitialized).
+		Where("EXISTS (SELECT 1 FROM transaction_entries WHERE transaction_entries.invoice_id = invoice.id AND transaction_entries.created_at > ?)", since).
+		Count(ctx)
+}
+
+// backupWaitOver reports if the due backup stops waiting for the payments in flight, it waits at most BACKUP_MAX_WAIT
+func (svc *LndhubService) backupWaitOver(waitingSince, now time.Time) bool {
+	return !now.Before(waitingSince.Add(time.Duration(svc.Config.BackupMaxWait) * time.Second))
+}
+
+// RunBackup verifies the ledger and then triggers the backup with BACKUP_COMMAND or BACKUP_WEBHOOK_URL
+// Integrity findings are reported and recorded with the run but do not prevent the backup
+func (svc *LndhubService) RunBackup(ctx context.Context) (*models.BackupRun, error) {
+	run := &models.BackupRun{Method: common.BackupMethodCommand, State: common.BackupStateRunning, StartedAt: time.Now()}
+	switch {
+	case svc.Config.BackupCommand != "":
+	case svc.Config.BackupWebhookUrl != "":
+		run.Method = common.BackupMethodWebhook
+	default:
+		return nil, ErrBackupsDisabled
+	}
+
+	findings, err := svc.FindIntegrityViolations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := svc.ReportIntegrityIncidents(ctx, findings); err != nil {
+		return nil, err
+	}
+	run.IntegrityFindings = len(findings)
+
+	if _, err := svc.DB.NewInsert().Model(run).Exec(ctx); err != nil {
+		return nil, err
+	}
+	backupStatus.Set("state", expvarString(run.State))
+	backupStatus.Set("started_at", expvarString(run.StartedAt.Format(time.RFC3339)))
+
+	if run.Method == common.BackupMethodCommand {
+		err = svc.runBackupCommand(ctx)
+	} else {
+		err = svc.postBackupWebhook(ctx, run)
+	}
+	run.State = common.BackupStateSucceeded
+	if err != nil {
+		run.State = common.BackupStateFailed
+		run.Error = err.Error()
+		svc.Logger.Errorf("Backup failed backup_id:%v %v", run.ID, err)
+		sentry.CaptureException(fmt.Errorf("backup failed: %w", err))
+	} else {
+		svc.Logger.Infof("Backup completed backup_id:%v method:%s integrity_findings:%v", run.ID, run.Method, run.IntegrityFindings)
+	}
+	run.FinishedAt = bun.NullTime{Time: time.Now()}
+	backupStatus.Set("state", expvarString(run.State))
+	backupStatus.Set("finished_at", expvarString(run.FinishedAt.Time.Format(time.RFC3339)))
+	backupStatus.Set("error", expvarString(run.Error))
+
+	_, updateErr := svc.DB.NewUpdate().Model(run).WherePK().Exec(ctx)
+	if updateErr != nil {
+		return run, updateErr
+	}
+	return run, nil
+}
+
+// runBackupCommand runs BACKUP_COMMAND in a shell with DATABASE_URI set
+func (svc *LndhubService) runBackupCommand(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, backupCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", svc.Config.BackupCommand)
+	cmd.Env = append(os.Environ(), "DATABASE_URI="+svc.Config.DatabaseUri)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(output) > backupOutputLimit {
+			output = output[len(output)-backupOutputLimit:]
+		}
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// postBackupWebhook asks an external service to take the backup, any 2xx response counts as success
+func (svc *LndhubService) postBackupWebhook(ctx context.Context, run *models.BackupRun) error {
+	ctx, cancel := context.WithTimeout(ctx, backupWebhookTimeout)
+	defer cancel()
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":              "backup.requested",
+		"backup_id":          run.ID,
+		"integrity_findings": run.IntegrityFindings,
+		"started_at":         run.StartedAt,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, svc.Config.BackupWebhookUrl, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// BackupRuns returns the latest backup runs
+func (svc *LndhubService) BackupRuns(ctx context.Context) ([]models.BackupRun, error) {
+	runs := []models.BackupRun{}
+	err := svc.DB.NewSelect().Model(&runs).OrderExpr("id DESC").Limit(30).Scan(ctx)
+	return runs, err
+}
+
+// StartBackupScheduler runs the nightly backup once BACKUP_HOUR has passed and no payment is in flight
+// Payments stuck in flight delay the backup by at most BACKUP_MAX_WAIT
+func (svc *LndhubService) StartBackupScheduler(ctx context.Context) {
+	if svc.Config.BackupCommand == "" && svc.Config.BackupWebhookUrl == "" {
+		return
+	}
+	ticker := time.NewTicker(backupPollInterval)
+	defer ticker.Stop()
+	var waitingSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		due, err := svc.BackupDue(ctx, now)
+		if err != nil {
+			svc.Logger.Errorf("Error checking backup schedule: %v", err)
+			continue
+		}
+		if !due {
+			waitingSince = time.Time{}
+			continue
+		}
+		// wait for a quiet moment, the backup is retried on the next tick
+		inFlight, err := svc.paymentsInFlight(ctx, now.Add(-backupStalePaymentAge))
+		if err != nil {
+			svc.Logger.Errorf("Error counting payments in flight for the backup: %v", err)
+			continue
+		}
+		if inFlight > 0 {
+			if waitingSince.IsZero() {
+				waitingSince = now
+				svc.Logger.Infof("Backup waits for payments in flight payments:%v", inFlight)
+			}
+			if !svc.backupWaitOver(waitingSince, now) {
+				continue
+			}
+			svc.Logger.Warnf("Backup runs with payments in flight after waiting since %v payments:%v", waitingSince.Format(time.RFC3339), inFlight)
+			sentry.CaptureMessage(fmt.Sprintf("Backup runs with %d payments in flight", inFlight))
+		}
+		waitingSince = time.Time{}
+		if _, err := svc.RunBackup(ctx); err != nil {
+			svc.Logger.Errorf("Error running backup: %v", err)
+			sentry.CaptureException(err)
+		}
+	}
+}
+
+func expvarString(value string) *expvar.String {
+	s := new(expvar.String)
+	s.Set(value)
+	return s
+}
@@ -0,0 +1,20 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupWaitOver(t *testing.T) {
+	svc := &LndhubService{Config: &Config{BackupMaxWait: 3600}}
+	waitingSince := time.Date(2022, 1, 10, 3, 0, 0, 0, time.UTC)
+	assert.False(t, svc.backupWaitOver(waitingSince, waitingSince))
+	assert.False(t, svc.backupWaitOver(waitingSince, waitingSince.Add(59*time.Minute)))
+	assert.True(t, svc.backupWaitOver(waitingSince, waitingSince.Add(time.Hour)))
+
+	// without a maximum wait the backup does not wait for payments in flight
+	svc.Config.BackupMaxWait = 0
+	assert.True(t, svc.backupWaitOver(waitingSince, waitingSince))
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getsentry/sentry-go"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// BalanceAudit compares what the hub owes according to the ledger with what the node holds
+// Only bitcoin accounts are compared. The hub owes the balances of the users, the payments in-flight and the collected service fees.
+// Routing fees already left the node and are only reported.
+// A negative delta means the node holds less than the ledger accounts for.
+type BalanceAudit struct {
+	UserBalances   int64     `json:"user_balances"`
+	InFlight       int64     `json:"in_flight"`
+	ServiceFees    int64     `json:"service_fees"`
+	RoutingFees    int64     `json:"routing_fees"`
+	Liabilities    int64     `json:"liabilities"`
+	ChannelBalance int64     `json:"channel_balance"`
+	OnchainBalance int64     `json:"onchain_balance"`
+	NodeBalance    int64     `json:"node_balance"`
+	Delta          int64     `json:"delta"`
+	CheckedAt      time.Time `json:"checked_at"`
+}
+
+type accountTypeBalance struct {
+	Type    string `bun:"type"`
+	Balance int64  `bun:"balance"`
+}
+
+// The sums return lib.ErrAmountOverflow instead of wrapping, a wrapped sum would hide a broken ledger
+func newBalanceAudit(balances []accountTypeBalance, channelBalance, onchainBalance int64) (BalanceAudit, error) {
+	audit := BalanceAudit{
+		ChannelBalance: channelBalance,
+		OnchainBalance: onchainBalance,
+		CheckedAt:      time.Now(),
+	}
+	var err error
+	if audit.NodeBalance, err = lib.AddAmounts(channelBalance, onchainBalance); err != nil {
+		return audit, err
+	}
+	for _, balance := range balances {
+		var sum *int64
+		switch balance.Type {
+		case common.AccountTypeCurrent:
+			sum = &audit.UserBalances
+		case common.AccountTypeInFlight:
+			sum = &audit.InFlight
+		case common.AccountTypeServiceFees:
+			sum = &audit.ServiceFees
+		case common.AccountTypeFees:
+			sum = &audit.RoutingFees
+		default:
+			continue
+		}
+		if *sum, err = lib.AddAmounts(*sum, balance.Balance); err != nil {
+			return audit, err
+		}
+	}
+	for _, liability := range []int64{audit.UserBalances, audit.InFlight, audit.ServiceFees} {
+		if audit.Liabilities, err = lib.AddAmounts(audit.Liabilities, liability); err != nil {
+			return audit, err
+		}
+	}
+	audit.Delta, err = lib.AddAmounts(audit.NodeBalance, -audit.Liabilities)
+	return audit, err
+}
+
+// AuditBalances compares the ledger with the local balance of the node's channels and its on-chain wallet
+// A negative delta is logged and reported to Sentry
+func (svc *LndhubService) AuditBalances(ctx context.Context) (*BalanceAudit, error) {
+	balances := []accountTypeBalance{}
+	err := svc.DB.NewSelect().
+		TableExpr("account_ledgers AS ledger").
+		Join("JOIN accounts AS account ON account.id = ledger.account_id").
+		ColumnExpr("account.type, COALESCE(SUM(ledger.amount), 0) AS balance").
+		Where("account.asset = ?", models.AssetBTC).
+		GroupExpr("account.type").
+		Scan(ctx, &balances)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := svc.LndClient.ListChannels(ctx, &lnrpc.ListChannelsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	var channelBalance int64
+	for _, ch := range channels.Channels {
+		if channelBalance, err = lib.AddAmounts(channelBalance, ch.LocalBalance); err != nil {
+			return nil, err
+		}
+	}
+	wallet, err := svc.LndClient.WalletBalance(ctx, &lnrpc.WalletBalanceRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := newBalanceAudit(balances, channelBalance, wallet.TotalBalance)
+	if err != nil {
+		return nil, err
+	}
+	if audit.Delta < 0 {
+		svc.Logger.Errorf("Node holds less than the ledger accounts for liabilities:%v node_balance:%v delta:%v", audit.Liabilities, audit.NodeBalance, audit.Delta)
+		sentry.CaptureMessage(fmt.Sprintf("Balance audit: node holds %d sats less than the ledger accounts for", -audit.Delta))
+	}
+	return &audit, nil
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"math"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBalanceAudit(t *testing.T) {
+	balances := []accountTypeBalance{
+		{Type: common.AccountTypeIncoming, Balance: -15000},
+		{Type: common.AccountTypeCurrent, Balance: 9000},
+		{Type: common.AccountTypeInFlight, Balance: 1000},
+		{Type: common.AccountTypeOutgoing, Balance: 4800},
+		{Type: common.AccountTypeFees, Balance: 150},
+		{Type: common.AccountTypeServiceFees, Balance: 50},
+	}
+	audit, err := newBalanceAudit(balances, 8000, 2500)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10050), audit.Liabilities)
+	assert.Equal(t, int64(150), audit.RoutingFees)
+	assert.Equal(t, int64(10500), audit.NodeBalance)
+	assert.Equal(t, int64(450), audit.Delta)
+
+	// the node lost funds the users still own
+	audit, err = newBalanceAudit(balances, 8000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-2050), audit.Delta)
+
+	_, err = newBalanceAudit(append(balances, accountTypeBalance{Type: common.AccountTypeCurrent, Balance: math.MaxInt64}), 8000, 0)
+	assert.ErrorIs(t, err, lib.ErrAmountOverflow)
+	_, err = newBalanceAudit(balances, math.MaxInt64, 1)
+	assert.ErrorIs(t, err, lib.ErrAmountOverflow)
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/uptrace/bun"
+)
+
+const (
+	balanceClaimExpiry         = 24 * time.Hour
+	balanceClaimRedeemTimeout  = 2 * time.Minute
+	balanceClaimRedeemPath     = "/migration/redeem"
+	balanceImportInvoiceMemo   = "Balance import"
+	balanceImportErrorBodySize = 1024
+)
+
+var ErrBalanceTooLow = errors.New("balance is too low to cover the routing fee reserve")
+var ErrInvalidBalanceClaim = errors.New("balance claim is invalid, expired or already redeemed")
+var ErrBalanceImportFailed = errors.New("balance import failed")
+var ErrInvalidHubUrl = errors.New("hub url must be a public https url")
+
+type BalanceClaimRedeemRequest struct {
+	Claim   string `json:"claim"`
+	Invoice string `json:"invoice"`
+}
+
+// ExportBalance creates a one-time claim on the user's balance minus a routing fee reserve
+// Previously exported claims of the user that were not redeemed are canceled, frozen users can not export their balance
+func (svc *LndhubService) ExportBalance(ctx context.Context, userId int64) (*models.BalanceClaim, string, error) {
+	if err := svc.CheckUserNotFrozen(ctx, userId); err != nil {
+		return nil, "", err
+	}
+	balance, err := svc.CurrentUserBalance(ctx, userId)
+	if err != nil {
+		return nil, "", err
+	}
+	feeReserve, err := svc.feeLimitFor(balance)
+	if err != nil {
+		return nil, "", err
+	}
+	if balance-feeReserve <= 0 {
+		return nil, "", ErrBalanceTooLow
+	}
+
+	_, err = svc.DB.NewUpdate().Model((*models.BalanceClaim)(nil)).
+		Set("state = ?", common.BalanceClaimStateCanceled).
+		Set("updated_at = current_timestamp").
+		Where("user_id = ? AND state = ?", userId, common.BalanceClaimStateOpen).
+		Exec(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	claim := &models.BalanceClaim{
+		UserID:    userId,
+		Amount:    balance - feeReserve,
+		State:     common.BalanceClaimStateOpen,
+		ExpiresAt: time.Now().Add(balanceClaimExpiry),
+	}
+	if _, err := svc.DB.NewInsert().Model(claim).Exec(ctx); err != nil {
+		return nil, "", err
+	}
+	token, err := tokens.GenerateBalanceClaim(svc.Config.JWTSecret, claim.ID, claim.Amount, claim.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return claim, token, nil
+}
+
+// RedeemBalanceClaim pays the invoice of the destination hub from the balance of the claim's user
+// The claim is reopened if the payment fails
+func (svc *LndhubService) RedeemBalanceClaim(ctx context.Context, token, paymentRequest string, decodedPaymentRequest *lnrpc.PayReq) (*SendPaymentResponse, error) {
+	claimToken, err := tokens.ParseBalanceClaim(svc.Config.JWTSecret, token)
+	if err != nil {
+		return nil, ErrInvalidBalanceClaim
+	}
+	if decodedPaymentRequest.NumSatoshis != claimToken.Amount {
+		return nil, ErrInvalidBalanceClaim
+	}
+
+	// MySQL can not return the rows of an update, the open claim is selected and locked first
+	var claim models.BalanceClaim
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		q := tx.NewSelect().Model(&claim).
+			Where("id = ? AND state = ? AND expires_at > current_timestamp", claimToken.ClaimID, common.BalanceClaimStateOpen).
+			Limit(1)
+		if err := forUpdate(tx, q).Scan(ctx); err != nil {
+			return err
+		}
+		claim.State = common.BalanceClaimStateRedeemed
+		_, err := tx.NewUpdate().Model(&claim).
+			Set("state = ?", common.BalanceClaimStateRedeemed).
+			Set("redeemed_at = current_timestamp").
+			Set("updated_at = current_timestamp").
+			WherePK().
+			Exec(ctx)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidBalanceClaim
+	}
+	if err != nil {
+		return nil, err
+	}
+	svc.Logger.Infof("Redeeming balance claim claim_id:%v user_id:%v amount:%v", claim.ID, claim.UserID, claim.Amount)
+
+	invoice, err := svc.AddOutgoingInvoice(ctx, claim.UserID, paymentRequest, &lnd.LNPayReq{PayReq: decodedPaymentRequest})
+	if err == nil {
+		var paymentResponse *SendPaymentResponse
+		paymentResponse, err = svc.PayInvoice(ctx, invoice)
+		if err == nil {
+			_, err = svc.DB.NewUpdate().Model(&claim).Set("invoice_id = ?", invoice.ID).WherePK().Exec(ctx)
+			if err != nil {
+				svc.Logger.Errorf("Could not link balance claim to invoice claim_id:%v invoice_id:%v: %v", claim.ID, invoice.ID, err)
+			}
+			return paymentResponse, nil
+		}
+	}
+
+	svc.Logger.Errorf("Balance claim payment failed claim_id:%v user_id:%v: %v", claim.ID, claim.UserID, err)
+	_, reopenErr := svc.DB.NewUpdate().Model(&claim).
+		Set("state = ?", common.BalanceClaimStateOpen).
+		Set("redeemed_at = NULL").
+		WherePK().
+		Exec(context.Background())
+	if reopenErr != nil {
+		svc.Logger.Errorf("Could not reopen balance claim claim_id:%v: %v", claim.ID, reopenErr)
+	}
+	return nil, err
+}
+
+// ImportBalance creates an invoice for the claimed amount and asks the issuing hub to pay it
+// The amount of the claim can only be verified by the issuing hub, the invoice is canceled if the import fails
+func (svc *LndhubService) ImportBalance(ctx context.Context, userId int64, hubUrl, token string) (*models.Invoice, error) {
+	claimToken, err := tokens.ParseBalanceClaimUnverified(token)
+	if err != nil || claimToken.Amount <= 0 {
+		return nil, ErrInvalidBalanceClaim
+	}
+	if err := validateHubUrl(hubUrl); err != nil {
+		return nil, err
+	}
+	invoice, err := svc.AddIncomingInvoice(ctx, userId, claimToken.Amount, balanceImportInvoiceMemo, "", 0, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.redeemBalanceClaim(ctx, hubUrl, token, invoice); err != nil {
+		// the issuing hub may still be paying the invoice, the node only cancels it if it was not settled
+		if cancelErr := svc.cancelIncomingInvoice(context.Background(), invoice); cancelErr != nil {
+			svc.Logger.Errorf("Could not cancel the invoice of a failed balance import user_id:%v invoice_id:%v: %v", userId, invoice.ID, cancelErr)
+		}
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// redeemBalanceClaim asks the issuing hub to pay the invoice for the claim
+// The hub url is chosen by the user, it is requested with the client that only connects to public addresses
+func (svc *LndhubService) redeemBalanceClaim(ctx context.Context, hubUrl, token string, invoice *models.Invoice) error {
+
+	payload, err := json.Marshal(&BalanceClaimRedeemRequest{Claim: token, Invoice: invoice.PaymentRequest})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, balanceClaimRedeemTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(hubUrl, "/")+balanceClaimRedeemPath, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := publicWebhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBalanceImportFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, balanceImportErrorBodySize))
+		svc.Logger.Errorf("Balance import failed user_id:%v invoice_id:%v status:%v body:%s", invoice.UserID, invoice.ID, resp.StatusCode, body)
+		return fmt.Errorf("%w: unexpected status code %d", ErrBalanceImportFailed, resp.StatusCode)
+	}
+	return nil
+}
+
+// validateHubUrl only accepts https urls of hosts that are not internal addresses
+func validateHubUrl(hubUrl string) error {
+	u, err := url.ParseRequestURI(hubUrl)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return ErrInvalidHubUrl
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrInvalidHubUrl
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return ErrInvalidHubUrl
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+)
+
+// histories without a start cover the last year
+const defaultBalanceHistoryRange = 365 * 24 * time.Hour
+
+// longer histories have to be requested with a coarser granularity
+const maxBalanceHistoryPoints = 1000
+
+var ErrInvalidBalanceHistory = errors.New("granularity must be day, week or month and the history at most 1000 points long")
+
+// BalanceHistory is the balance of the user at the end of every period between From and To
+type BalanceHistory struct {
+	Granularity string                `json:"granularity"`
+	From        time.Time             `json:"from"`
+	To          time.Time             `json:"to"`
+	Points      []BalanceHistoryPoint `json:"points"`
+}
+
+// BalanceHistoryPoint is the balance at the end of the period starting at Start, or at To for the last period
+type BalanceHistoryPoint struct {
+	Start   time.Time `json:"start"`
+	Balance int64     `json:"balance"`
+}
+
+type balanceHistoryEntry struct {
+	CreatedAt time.Time
+	Amount    int64
+}
+
+// nextPeriodStart returns the start of the period after the one starting at start
+func nextPeriodStart(start time.Time, period string) time.Time {
+	switch period {
+	case FeeReportPeriodWeek:
+		return start.AddDate(0, 0, 7)
+	case FeeReportPeriodMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// balanceHistoryPoints adds up the opening balance and the signed entries, ordered by time, to a point per period
+// Periods without entries repeat the balance of the period before, so charts need no gaps filled
+func balanceHistoryPoints(history *BalanceHistory, opening int64, entries []balanceHistoryEntry, location *time.Location) error {
+	starts := []time.Time{}
+	for start := feeReportPeriodStart(history.From, history.Granularity, location); start.Before(history.To); start = nextPeriodStart(start, history.Granularity) {
+		if len(starts) == maxBalanceHistoryPoints {
+			return ErrInvalidBalanceHistory
+		}
+		starts = append(starts, start)
+	}
+	balance := opening
+	for i, start := range starts {
+		end := history.To
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		for len(entries) > 0 && entries[0].CreatedAt.Before(end) {
+			balance += entries[0].Amount
+			entries = entries[1:]
+		}
+		history.Points = append(history.Points, BalanceHistoryPoint{Start: start, Balance: balance})
+	}
+	return nil
+}
+
+// BalanceHistory derives the balance of the user per day, week or month between from and to from the ledger
+// Periods start in the location, a zero from returns the year before to
+func (svc *LndhubService) BalanceHistory(ctx context.Context, userId int64, granularity string, from, to time.Time, location *time.Location) (*BalanceHistory, error) {
+	switch granularity {
+	case FeeReportPeriodDay, FeeReportPeriodWeek, FeeReportPeriodMonth:
+	default:
+		return nil, ErrInvalidBalanceHistory
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultBalanceHistoryRange)
+	}
+	history := &BalanceHistory{Granularity: granularity, From: from.In(location), To: to.In(location), Points: []BalanceHistoryPoint{}}
+
+	account, err := svc.AccountFor(ctx, common.AccountTypeCurrent, userId)
+	if err != nil {
+		return nil, err
+	}
+	db := svc.readDB(ctx)
+	var opening int64
+	err = db.NewSelect().
+		TableExpr("transaction_entries AS entry").
+		ColumnExpr("COALESCE(SUM(CASE WHEN entry.credit_account_id = ? THEN entry.amount ELSE -entry.amount END), 0)", account.ID).
+		Where("(entry.credit_account_id = ? OR entry.debit_account_id = ?) AND entry.created_at < ?", account.ID, account.ID, from).
+		Scan(ctx, &opening)
+	if err != nil {
+		return nil, err
+	}
+	entries := []balanceHistoryEntry{}
+	err = db.NewSelect().
+		TableExpr("transaction_entries AS entry").
+		ColumnExpr("entry.created_at").
+		ColumnExpr("CASE WHEN entry.credit_account_id = ? THEN entry.amount ELSE -entry.amount END AS amount", account.ID).
+		Where("(entry.credit_account_id = ? OR entry.debit_account_id = ?)", account.ID, account.ID).
+		Where("entry.created_at >= ? AND entry.created_at < ?", from, to).
+		OrderExpr("entry.created_at ASC, entry.id ASC").
+		Scan(ctx, &entries)
+	if err != nil {
+		return nil, err
+	}
+	if err := balanceHistoryPoints(history, opening, entries, location); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalanceHistoryPoints(t *testing.T) {
+	from := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	history := &BalanceHistory{Granularity: FeeReportPeriodDay, From: from, To: from.AddDate(0, 0, 3)}
+	entries := []balanceHistoryEntry{
+		{CreatedAt: from.Add(time.Hour), Amount: 100},
+		{CreatedAt: from.Add(2 * time.Hour), Amount: -30},
+		{CreatedAt: from.AddDate(0, 0, 2), Amount: 50},
+	}
+	err := balanceHistoryPoints(history, 10, entries, time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, []BalanceHistoryPoint{
+		{Start: time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC), Balance: 80},
+		{Start: time.Date(2022, 5, 2, 0, 0, 0, 0, time.UTC), Balance: 80},
+		{Start: time.Date(2022, 5, 3, 0, 0, 0, 0, time.UTC), Balance: 130},
+		{Start: time.Date(2022, 5, 4, 0, 0, 0, 0, time.UTC), Balance: 130},
+	}, history.Points)
+
+	monthly := &BalanceHistory{Granularity: FeeReportPeriodMonth, From: from, To: from.AddDate(0, 2, 0)}
+	assert.NoError(t, balanceHistoryPoints(monthly, 0, entries, time.UTC))
+	assert.Len(t, monthly.Points, 3)
+	assert.Equal(t, int64(120), monthly.Points[0].Balance)
+
+	long := &BalanceHistory{Granularity: FeeReportPeriodDay, From: from.AddDate(-3, 0, 0), To: from}
+	assert.ErrorIs(t, balanceHistoryPoints(long, 0, nil, time.UTC), ErrInvalidBalanceHistory)
+}
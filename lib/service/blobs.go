@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/blobstore"
+)
+
+const (
+	BlobStoreFilesystem = "filesystem"
+	BlobStoreS3         = "s3"
+
+	blobReferencePrefix = "blob:sha256:"
+)
+
+var (
+	ErrBlobStoreNotConfigured = errors.New("invoice references a blob but no blob store is configured")
+	ErrBlobChecksumMismatch   = errors.New("blob does not match its checksum")
+)
+
+var blobReferencePattern = regexp.MustCompile(`^blob:sha256:([0-9a-f]{64})$`)
+
+// NewBlobStore creates the blob store configured by BLOB_STORE, nil if large values are kept in Postgres
+func NewBlobStore(c *Config) (blobstore.Store, error) {
+	switch c.BlobStore {
+	case "":
+		return nil, nil
+	case BlobStoreFilesystem:
+		return blobstore.NewFileStore(c.BlobStoreDir)
+	case BlobStoreS3:
+		return blobstore.NewS3Store(blobstore.S3Options{
+			Endpoint:        c.BlobStoreS3Endpoint,
+			Bucket:          c.BlobStoreS3Bucket,
+			Region:          c.BlobStoreS3Region,
+			AccessKeyID:     c.BlobStoreS3AccessKeyID,
+			SecretAccessKey: c.BlobStoreS3SecretAccessKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown blob store: %s", c.BlobStore)
+	}
+}
+
+// offloadBlob moves values of at least BLOB_STORE_MIN_SIZE bytes to the blob store and returns the reference kept in their place
+// Blobs are content addressed, storing the same value twice is a no-op
+func (svc *LndhubService) offloadBlob(ctx context.Context, value string) (string, error) {
+	if svc.BlobStore == nil || len(value) < svc.Config.BlobStoreMinSize {
+		return value, nil
+	}
+	hash := sha256.Sum256([]byte(value))
+	key := hex.EncodeToString(hash[:])
+	if err := svc.BlobStore.Put(ctx, key, []byte(value)); err != nil {
+		return "", err
+	}
+	return blobReferencePrefix + key, nil
+}
+
+// ResolveBlob returns the value of a blob reference, any other value is returned as is
+func (svc *LndhubService) ResolveBlob(ctx context.Context, value string) (string, error) {
+	match := blobReferencePattern.FindStringSubmatch(value)
+	if match == nil {
+		return value, nil
+	}
+	if svc.BlobStore == nil {
+		return "", ErrBlobStoreNotConfigured
+	}
+	data, err := svc.BlobStore.Get(ctx, match[1])
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	if hex.EncodeToString(hash[:]) != match[1] {
+		return "", ErrBlobChecksumMismatch
+	}
+	return string(data), nil
+}
+
+// offloadInvoiceBlobs replaces the large zap request and metadata values of a new invoice by blob references
+func (svc *LndhubService) offloadInvoiceBlobs(ctx context.Context, invoice *models.Invoice) error {
+	zapRequest, err := svc.offloadBlob(ctx, invoice.ZapRequest)
+	if err != nil {
+		return err
+	}
+	invoice.ZapRequest = zapRequest
+	for key, value := range invoice.Metadata {
+		if invoice.Metadata[key], err = svc.offloadBlob(ctx, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveInvoiceBlobs loads the zap request and metadata values of the invoice that were moved to the blob store
+func (svc *LndhubService) ResolveInvoiceBlobs(ctx context.Context, invoice *models.Invoice) error {
+	zapRequest, err := svc.ResolveBlob(ctx, invoice.ZapRequest)
+	if err != nil {
+		return err
+	}
+	invoice.ZapRequest = zapRequest
+	for key, value := range invoice.Metadata {
+		if invoice.Metadata[key], err = svc.ResolveBlob(ctx, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/blobstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoiceBlobs(t *testing.T) {
+	ctx := context.Background()
+	store, err := blobstore.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	svc := &LndhubService{Config: &Config{BlobStoreMinSize: 16}, BlobStore: store}
+
+	zapRequest := strings.Repeat("z", 64)
+	invoice := &models.Invoice{ZapRequest: zapRequest, Metadata: map[string]string{"source": "short", "payerdata": strings.Repeat("p", 32)}}
+	assert.NoError(t, svc.offloadInvoiceBlobs(ctx, invoice))
+	assert.True(t, strings.HasPrefix(invoice.ZapRequest, blobReferencePrefix))
+	assert.True(t, strings.HasPrefix(invoice.Metadata["payerdata"], blobReferencePrefix))
+	assert.Equal(t, "short", invoice.Metadata["source"])
+
+	assert.NoError(t, svc.ResolveInvoiceBlobs(ctx, invoice))
+	assert.Equal(t, zapRequest, invoice.ZapRequest)
+	assert.Equal(t, strings.Repeat("p", 32), invoice.Metadata["payerdata"])
+
+	// tampered blobs are not returned
+	reference, err := svc.offloadBlob(ctx, zapRequest)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Put(ctx, strings.TrimPrefix(reference, blobReferencePrefix), []byte("tampered")))
+	_, err = svc.ResolveBlob(ctx, reference)
+	assert.ErrorIs(t, err, ErrBlobChecksumMismatch)
+
+	// references can not be resolved without the blob store
+	_, err = (&LndhubService{Config: &Config{}}).ResolveBlob(ctx, reference)
+	assert.ErrorIs(t, err, ErrBlobStoreNotConfigured)
+}
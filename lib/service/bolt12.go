@@ -19,7 +19,11 @@ func (svc *LndhubService) PayBolt12Invoice(ctx context.Context, invoice *lnd.Bol
 func (svc *LndhubService) TransformBolt12(bolt12 *lnd.Bolt12) (*lnd.LNPayReq, error) {
 
 	//todo see if CLN really can't return an int here
-	msatAmt, err := strconv.Atoi(strings.Trim(bolt12.AmountMsat, "msat"))
+	msatAmt, err := strconv.ParseInt(strings.Trim(bolt12.AmountMsat, "msat"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	satAmt, err := lnd.MsatToSat(msatAmt)
 	if err != nil {
 		return nil, err
 	}
@@ -27,12 +31,12 @@ func (svc *LndhubService) TransformBolt12(bolt12 *lnd.Bolt12) (*lnd.LNPayReq, er
 		PayReq: &lnrpc.PayReq{
 			Destination:     bolt12.NodeID,
 			PaymentHash:     bolt12.PaymentHash,
-			NumSatoshis:     int64(msatAmt / lnd.MSAT_PER_SAT),
+			NumSatoshis:     satAmt,
 			Timestamp:       bolt12.Timestamp,
 			Expiry:          bolt12.RelativeExpiry,
 			Description:     bolt12.Description,
 			DescriptionHash: "", // not supported by bolt 12?
-			NumMsat:         int64(msatAmt),
+			NumMsat:         msatAmt,
 		},
 		Keysend: false,
 	}, nil
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/getsentry/sentry-go"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+const (
+	channelEventsBaseBackoff = time.Second
+	channelEventsMaxBackoff  = time.Minute
+)
+
+// StartChannelEventSubscription refreshes the liquidity snapshot whenever a channel opens, closes,
+// goes active or inactive, so receiving and sending are gated without waiting for the next periodic check
+// Nodes without channel events are only checked periodically by the liquidity monitor
+func (svc *LndhubService) StartChannelEventSubscription(ctx context.Context) {
+	if svc.Config.MinOutboundLiquidity <= 0 && svc.Config.InboundLiquidityCheck == "" && !svc.Config.PauseReceivingWithoutInbound {
+		return
+	}
+	attempts := 0
+	for {
+		connectedAt := time.Now()
+		stream, err := svc.LndClient.SubscribeChannelEvents(ctx, &lnrpc.ChannelEventSubscription{})
+		if errors.Is(err, lnd.ErrChannelEventsNotSupported) {
+			svc.Logger.Infof("Channel events are not supported by the node, relying on periodic liquidity checks")
+			return
+		}
+		if err == nil {
+			err = svc.processChannelEvents(ctx, stream)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(connectedAt) > channelEventsMaxBackoff {
+			attempts = 0
+		}
+		attempts++
+		backoff := exponentialBackoff(channelEventsBaseBackoff, channelEventsMaxBackoff, attempts)
+		svc.Logger.Errorf("Channel event subscription failed, reconnecting in %v attempt:%v %v", backoff, attempts, err)
+		sentry.CaptureException(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (svc *LndhubService) processChannelEvents(ctx context.Context, stream lnd.SubscribeChannelEventsWrapper) error {
+	// channels may have changed while the subscription was down
+	if _, err := svc.CheckLiquidity(ctx); err != nil {
+		svc.Logger.Errorf("Error checking node liquidity: %v", err)
+	}
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		svc.Logger.Infof("Channel event type:%v, checking node liquidity", event.Type)
+		if _, err := svc.CheckLiquidity(ctx); err != nil {
+			svc.Logger.Errorf("Error checking node liquidity: %v", err)
+			sentry.CaptureException(err)
+		}
+	}
+}
@@ -1,18 +1,96 @@
 package service
 
 type Config struct {
-	DatabaseUri           string `envconfig:"DATABASE_URI" required:"true"`
-	SentryDSN             string `envconfig:"SENTRY_DSN"`
-	LogFilePath           string `envconfig:"LOG_FILE_PATH"`
-	JWTSecret             []byte `envconfig:"JWT_SECRET" required:"true"`
-	JWTRefreshTokenExpiry int    `envconfig:"JWT_REFRESH_EXPIRY" default:"604800"` // in seconds, default 7 days
-	JWTAccessTokenExpiry  int    `envconfig:"JWT_ACCESS_EXPIRY" default:"172800"`  // in seconds, default 2 days
-	LNDAddress            string `envconfig:"LND_ADDRESS" required:"true"`
-	LNDMacaroonHex        string `envconfig:"LND_MACAROON_HEX" required:"true"`
-	LNDCertHex            string `envconfig:"LND_CERT_HEX"`
-	CustomName            string `envconfig:"CUSTOM_NAME"`
-	Port                  int    `envconfig:"PORT" default:"3000"`
-	DefaultRateLimit      int    `envconfig:"DEFAULT_RATE_LIMIT" default:"10"`
-	StrictRateLimit       int    `envconfig:"STRICT_RATE_LIMIT" default:"10"`
-	BurstRateLimit        int    `envconfig:"BURST_RATE_LIMIT" default:"1"`
+	DatabaseUri                   string        `envconfig:"DATABASE_URI" required:"true"`
+	DatabaseReplicaUri            string        `envconfig:"DATABASE_REPLICA_URI"` // read replica serving the balance and transaction history, everything is read from DATABASE_URI if not set
+	SentryDSN                     string        `envconfig:"SENTRY_DSN"`
+	LogFilePath                   string        `envconfig:"LOG_FILE_PATH"`
+	JWTSecret                     []byte        `envconfig:"JWT_SECRET" required:"true"`
+	JWTRefreshTokenExpiry         int           `envconfig:"JWT_REFRESH_EXPIRY" default:"604800"` // in seconds, default 7 days
+	JWTAccessTokenExpiry          int           `envconfig:"JWT_ACCESS_EXPIRY" default:"172800"`  // in seconds, default 2 days
+	LNDAddress                    string        `envconfig:"LND_ADDRESS" required:"true"`
+	LNDMacaroonHex                string        `envconfig:"LND_MACAROON_HEX" required:"true"`
+	LNDCertHex                    string        `envconfig:"LND_CERT_HEX"`
+	CustomName                    string        `envconfig:"CUSTOM_NAME"`
+	Port                          int           `envconfig:"PORT" default:"3000"`
+	DefaultRateLimit              int           `envconfig:"DEFAULT_RATE_LIMIT" default:"10"`
+	StrictRateLimit               int           `envconfig:"STRICT_RATE_LIMIT" default:"10"`
+	BurstRateLimit                int           `envconfig:"BURST_RATE_LIMIT" default:"1"`
+	PaymentFeeLimit               int64         `envconfig:"PAYMENT_FEE_LIMIT" default:"300"`        // in satoshis, fee limit of the first payment attempt
+	PaymentMaxRetries             int           `envconfig:"PAYMENT_MAX_RETRIES" default:"2"`        // retries after a no-route failure
+	PaymentRetryFeeFactor         int64         `envconfig:"PAYMENT_RETRY_FEE_FACTOR" default:"2"`   // fee limit multiplier for every retry
+	FeeLimitTiers                 FeeLimitTiers `envconfig:"FEE_LIMIT_TIERS"`                        // fee limits by payment amount, falls back to PAYMENT_FEE_LIMIT
+	DestinationAllowlist          []string      `envconfig:"DESTINATION_ALLOWLIST"`                  // comma separated node pubkeys, if set only these destinations can be paid
+	DestinationDenylist           []string      `envconfig:"DESTINATION_DENYLIST"`                   // comma separated node pubkeys that can not be paid
+	MaxSendAmount                 int64         `envconfig:"MAX_SEND_AMOUNT"`                        // in satoshis, 0 means no limit
+	AdminToken                    string        `envconfig:"ADMIN_TOKEN"`                            // admin endpoints are disabled if not set
+	DailySendLimit                int64         `envconfig:"DAILY_SEND_LIMIT"`                       // in satoshis per rolling 24 hours, 0 means no limit
+	WeeklySendLimit               int64         `envconfig:"WEEKLY_SEND_LIMIT"`                      // in satoshis per rolling 7 days, 0 means no limit
+	WebhookUrl                    string        `envconfig:"WEBHOOK_URL"`                            // receives a POST request for every settled incoming invoice
+	WebhookSecret                 string        `envconfig:"WEBHOOK_SECRET"`                         // signs the webhook payloads if set
+	WebhookMaxAttempts            int           `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"10"`      // deliveries are dead-lettered after this many attempts
+	WebhookMaxBackoff             int           `envconfig:"WEBHOOK_MAX_BACKOFF" default:"3600"`     // in seconds, upper bound of the retry backoff
+	MinOutboundLiquidity          int64         `envconfig:"MIN_OUTBOUND_LIQUIDITY"`                 // in satoshis, outgoing payments are paused below this, 0 disables the check
+	LiquidityCheckInterval        int           `envconfig:"LIQUIDITY_CHECK_INTERVAL" default:"60"`  // in seconds
+	InboundLiquidityCheck         string        `envconfig:"INBOUND_LIQUIDITY_CHECK"`                // "warn" or "reject" invoices exceeding the inbound liquidity, disabled if empty
+	IntegrityCheckInterval        int           `envconfig:"INTEGRITY_CHECK_INTERVAL" default:"300"` // in seconds, 0 disables the ledger integrity monitor
+	IntegrityAutoFreeze           bool          `envconfig:"INTEGRITY_AUTO_FREEZE"`                  // freeze users affected by an integrity incident
+	ServiceFeeOutgoingBase        int64         `envconfig:"SERVICE_FEE_OUTGOING_BASE"`              // in satoshis, charged on top of every outgoing payment
+	ServiceFeeOutgoingPercent     float64       `envconfig:"SERVICE_FEE_OUTGOING_PERCENT"`           // percentage of the amount charged on top of every outgoing payment
+	ServiceFeeIncomingBase        int64         `envconfig:"SERVICE_FEE_INCOMING_BASE"`              // in satoshis, deducted from every settled incoming invoice
+	ServiceFeeIncomingPercent     float64       `envconfig:"SERVICE_FEE_INCOMING_PERCENT"`           // percentage of the amount deducted from every settled incoming invoice
+	InvoiceMemoTemplate           string        `envconfig:"INVOICE_MEMO_TEMPLATE"`                  // memo of incoming invoices with {memo}, {login}, {user_id} and {hub} placeholders
+	DebugPaymentTimings           bool          `envconfig:"DEBUG_PAYMENT_TIMINGS"`                  // include the timings of the payment stages in payinvoice and keysend responses
+	SettlementQueue               bool          `envconfig:"SETTLEMENT_QUEUE"`                       // run settlement side effects like webhooks from the persistent job queue
+	JobMaxAttempts                int           `envconfig:"JOB_MAX_ATTEMPTS" default:"10"`          // queued jobs are marked as failed after this many attempts
+	LoopAddress                   string        `envconfig:"LOOP_ADDRESS"`                           // host:port of the loop daemon's REST API, swaps are disabled if not set
+	LoopMacaroonHex               string        `envconfig:"LOOP_MACAROON_HEX"`
+	LoopCertHex                   string        `envconfig:"LOOP_CERT_HEX"`
+	LoopMaxCostPercent            float64       `envconfig:"LOOP_MAX_COST_PERCENT" default:"1"`            // swaps quoted above this percentage of the amount are not started
+	LoopAutoAmount                int64         `envconfig:"LOOP_AUTO_AMOUNT"`                             // in satoshis, amount of automatic swaps, 0 disables automatic swaps
+	LoopMinInboundLiquidity       int64         `envconfig:"LOOP_MIN_INBOUND_LIQUIDITY"`                   // in satoshis, an automatic loop out is started below this
+	OperatorLogin                 string        `envconfig:"OPERATOR_LOGIN" default:"operator"`            // login of the user whose ledger books the swap costs
+	AccountTiers                  AccountTiers  `envconfig:"ACCOUNT_TIERS"`                                // JSON object overriding limits, service fees and capabilities by user tier
+	MemoMaxLength                 int           `envconfig:"MEMO_MAX_LENGTH" default:"640"`                // in characters, longer memos are truncated, 0 means no limit
+	PauseReceivingWithoutInbound  bool          `envconfig:"PAUSE_RECEIVING_WITHOUT_INBOUND"`              // deny new invoices while the node has no active channels or inbound liquidity
+	BackupCommand                 string        `envconfig:"BACKUP_COMMAND"`                               // shell command creating a database backup, e.g. pg_dump "$DATABASE_URI" > /backups/lndhub.sql
+	BackupWebhookUrl              string        `envconfig:"BACKUP_WEBHOOK_URL"`                           // receives a POST request when a backup should be taken, used if BACKUP_COMMAND is not set
+	BackupHour                    int           `envconfig:"BACKUP_HOUR" default:"3"`                      // UTC hour after which the nightly backup runs
+	BackupMaxWait                 int           `envconfig:"BACKUP_MAX_WAIT" default:"3600"`               // in seconds, the backup runs anyway if payments are still in flight after this
+	ReconcileInterval             int           `envconfig:"RECONCILE_INTERVAL" default:"3600"`            // in seconds, 0 disables backfilling settlements missed by the invoice subscription
+	MaxOpenInvoices               int64         `envconfig:"MAX_OPEN_INVOICES"`                            // unpaid and unexpired invoices per user, 0 means no limit
+	InvoiceCreationPerHour        int64         `envconfig:"INVOICE_CREATION_PER_HOUR"`                    // invoices a user can create per hour, 0 means no limit
+	FiatRatesUrl                  string        `envconfig:"FIAT_RATES_URL"`                               // returns a JSON object of bitcoin prices by currency code, e.g. {"USD": 43000.5}
+	PaymentFailureNotifyThreshold int           `envconfig:"PAYMENT_FAILURE_NOTIFY_THRESHOLD" default:"3"` // consecutive failed payments to a destination before the user is notified, 0 disables the notifications
+	PaymentFailureNotifyOperator  bool          `envconfig:"PAYMENT_FAILURE_NOTIFY_OPERATOR"`              // also send repeated payment failures to WEBHOOK_URL
+	NegativeBalanceAutoFreeze     bool          `envconfig:"NEGATIVE_BALANCE_AUTO_FREEZE" default:"true"`  // freeze users whose balance is negative after a payment
+	NostrPrivateKey               string        `envconfig:"NOSTR_PRIVATE_KEY"`                            // hex encoded key signing NIP-57 zap receipts, zaps are disabled if not set
+	MaxInFlightExposure           int64         `envconfig:"MAX_IN_FLIGHT_EXPOSURE"`                       // in satoshis, /readyz reports not ready above this, 0 disables the check
+	PaymentOutgoingChanId         uint64        `envconfig:"PAYMENT_OUTGOING_CHAN_ID"`                     // channel id outgoing payments are pinned to unless the caller pins another one
+	AllowRoutingConstraints       bool          `envconfig:"ALLOW_ROUTING_CONSTRAINTS" default:"false"`    // allow API callers to pin the first hop channel and the last hop of their payments
+	UnusualPaymentMultiplier      float64       `envconfig:"UNUSUAL_PAYMENT_MULTIPLIER"`                   // payments above this multiple of the user's 30 day average need a confirmation, 0 disables confirmations
+	UnusualPaymentMinAmount       int64         `envconfig:"UNUSUAL_PAYMENT_MIN_AMOUNT"`                   // in satoshis, smaller payments never need a confirmation
+	BlobStore                     string        `envconfig:"BLOB_STORE"`                                   // "filesystem" or "s3", large invoice metadata is kept in Postgres if not set
+	BlobStoreMinSize              int           `envconfig:"BLOB_STORE_MIN_SIZE" default:"1024"`           // in bytes, smaller values are kept in Postgres
+	BlobStoreDir                  string        `envconfig:"BLOB_STORE_DIR" default:"./blobs"`
+	BlobStoreS3Endpoint           string        `envconfig:"BLOB_STORE_S3_ENDPOINT"` // e.g. https://s3.eu-central-1.amazonaws.com
+	BlobStoreS3Bucket             string        `envconfig:"BLOB_STORE_S3_BUCKET"`
+	BlobStoreS3Region             string        `envconfig:"BLOB_STORE_S3_REGION" default:"us-east-1"`
+	BlobStoreS3AccessKeyID        string        `envconfig:"BLOB_STORE_S3_ACCESS_KEY_ID"`
+	BlobStoreS3SecretAccessKey    string        `envconfig:"BLOB_STORE_S3_SECRET_ACCESS_KEY"`
+	RetentionArchiveDays          int           `envconfig:"RETENTION_ARCHIVE_DAYS"`                  // unpaid incoming invoices are moved to archived_invoices this many days after they expired, 0 keeps them in invoices
+	RetentionExpiredInvoicesDays  int           `envconfig:"RETENTION_EXPIRED_INVOICES_DAYS"`         // unpaid incoming invoices are deleted this many days after they expired, 0 keeps them
+	RetentionMemoDays             int           `envconfig:"RETENTION_MEMO_DAYS"`                     // memos of invoices older than this many days are removed, 0 keeps them
+	RetentionInterval             int           `envconfig:"RETENTION_INTERVAL" default:"86400"`      // in seconds, 0 disables the scheduled retention runs
+	RetentionDryRun               bool          `envconfig:"RETENTION_DRY_RUN" default:"false"`       // scheduled retention runs only log what they would change
+	RetentionBatchSize            int           `envconfig:"RETENTION_BATCH_SIZE" default:"10000"`    // invoices changed per statement, smaller batches hold locks on invoices for a shorter time
+	MinReceiveAmount              int64         `envconfig:"MIN_RECEIVE_AMOUNT" default:"1"`          // in satoshis, smallest amount of invoices with an amount
+	MaxReceiveAmount              int64         `envconfig:"MAX_RECEIVE_AMOUNT"`                      // in satoshis, 0 means no limit
+	InvoiceExpiry                 int64         `envconfig:"INVOICE_EXPIRY" default:"86400"`          // in seconds, expiry of invoices that do not request one
+	InvoiceMinExpiry              int64         `envconfig:"INVOICE_MIN_EXPIRY" default:"60"`         // in seconds, shortest expiry an invoice can request
+	InvoiceMaxExpiry              int64         `envconfig:"INVOICE_MAX_EXPIRY" default:"604800"`     // in seconds, longest expiry an invoice can request, 0 means no limit
+	InvoiceExpiryInterval         int           `envconfig:"INVOICE_EXPIRY_INTERVAL" default:"60"`    // in seconds, 0 disables marking expired invoices as expired
+	RecurringInvoiceInterval      int           `envconfig:"RECURRING_INVOICE_INTERVAL" default:"60"` // in seconds, 0 disables generating recurring invoices
+	AutoWithdrawInterval          int           `envconfig:"AUTO_WITHDRAW_INTERVAL" default:"300"`    // in seconds, 0 disables auto-withdrawals
+	AutoWithdrawMinAmount         int64         `envconfig:"AUTO_WITHDRAW_MIN_AMOUNT" default:"1000"` // in satoshis, smallest balance above the threshold that is paid out
 }
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+)
+
+var ErrInvalidContact = errors.New("invalid contact")
+
+// ContactStats aggregates the settled payments of a user to one contact
+type ContactStats struct {
+	PaymentCount int64     `bun:"payment_count"`
+	TotalAmount  int64     `bun:"total_amount"`
+	TotalFees    int64     `bun:"total_fees"`
+	LastPaidAt   time.Time `bun:"last_paid_at"`
+}
+
+func (svc *LndhubService) CreateContact(ctx context.Context, userId int64, name, contactType, value string) (*models.Contact, error) {
+	switch contactType {
+	case common.ContactTypeUser:
+		if _, err := svc.FindUserByLogin(ctx, value); err != nil {
+			return nil, ErrInvalidContact
+		}
+	case common.ContactTypeLightningAddress, common.ContactTypePubkey:
+	default:
+		return nil, ErrInvalidContact
+	}
+	contact := &models.Contact{
+		UserID: userId,
+		Name:   name,
+		Type:   contactType,
+		Value:  value,
+	}
+	_, err := svc.DB.NewInsert().Model(contact).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+func (svc *LndhubService) ContactsFor(ctx context.Context, userId int64) ([]models.Contact, error) {
+	contacts := []models.Contact{}
+	err := svc.DB.NewSelect().Model(&contacts).Where("user_id = ?", userId).OrderExpr("name ASC").Scan(ctx)
+	return contacts, err
+}
+
+func (svc *LndhubService) FindContact(ctx context.Context, userId, contactId int64) (*models.Contact, error) {
+	var contact models.Contact
+	err := svc.DB.NewSelect().Model(&contact).Where("id = ? AND user_id = ?", contactId, userId).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+func (svc *LndhubService) DeleteContact(ctx context.Context, userId, contactId int64) error {
+	_, err := svc.DB.NewDelete().Model((*models.Contact)(nil)).Where("id = ? AND user_id = ?", contactId, userId).Exec(ctx)
+	return err
+}
+
+// contactPaymentsQuery filters the settled outgoing invoices of the user paid to the contact
+// Lightning address payments can not be attributed yet and never match
+func (svc *LndhubService) contactPaymentsQuery(ctx context.Context, query *bun.SelectQuery, contact *models.Contact) (*bun.SelectQuery, error) {
+	query = query.Where("invoice.user_id = ? AND invoice.type = ? AND invoice.state = ?", contact.UserID, common.InvoiceTypeOutgoing, common.InvoiceStateSettled)
+	switch contact.Type {
+	case common.ContactTypePubkey:
+		return query.Where("invoice.destination_pubkey_hex = ?", contact.Value), nil
+	case common.ContactTypeUser:
+		contactUser, err := svc.FindUserByLogin(ctx, contact.Value)
+		if err != nil {
+			return nil, err
+		}
+		// internal payments settle an incoming invoice of the contact with the same payment request
+		return query.Where("EXISTS (SELECT 1 FROM invoices incoming WHERE incoming.type = ? AND incoming.user_id = ? AND incoming.payment_request = invoice.payment_request)",
+			common.InvoiceTypeIncoming, contactUser.ID), nil
+	default:
+		return query.Where("1 = 0"), nil
+	}
+}
+
+func (svc *LndhubService) ContactStatsFor(ctx context.Context, contact *models.Contact) (*ContactStats, error) {
+	stats := ContactStats{}
+	query := svc.DB.NewSelect().
+		TableExpr("invoices AS invoice").
+		ColumnExpr("COUNT(*) AS payment_count").
+		ColumnExpr("COALESCE(SUM(invoice.amount), 0) AS total_amount").
+		ColumnExpr("COALESCE(SUM(invoice.fee), 0) AS total_fees").
+		ColumnExpr("MAX(invoice.settled_at) AS last_paid_at")
+	query, err := svc.contactPaymentsQuery(ctx, query, contact)
+	if err != nil {
+		return nil, err
+	}
+	err = query.Scan(ctx, &stats)
+	return &stats, err
+}
+
+func (svc *LndhubService) ContactPayments(ctx context.Context, contact *models.Contact) ([]models.Invoice, error) {
+	invoices := []models.Invoice{}
+	query, err := svc.contactPaymentsQuery(ctx, svc.DB.NewSelect().Model(&invoices), contact)
+	if err != nil {
+		return nil, err
+	}
+	err = query.OrderExpr("invoice.id DESC").Limit(100).Scan(ctx)
+	return invoices, err
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// The queries are written for PostgreSQL, these helpers spell the statements MySQL does not share
+
+func isMySQL(db bun.IDB) bool {
+	return db.Dialect().Name() == dialect.MySQL
+}
+
+func isSQLite(db bun.IDB) bool {
+	return db.Dialect().Name() == dialect.SQLite
+}
+
+// forUpdate locks the selected rows until the transaction commits
+// SQLite has no row locks, its transactions are serialized by the database lock
+func forUpdate(db bun.IDB, q *bun.SelectQuery) *bun.SelectQuery {
+	if isSQLite(db) {
+		return q
+	}
+	return q.For("UPDATE")
+}
+
+// forUpdateSkipLocked locks the selected rows and leaves out the rows other transactions locked
+// SQLite has no row locks, a single instance works through the rows alone
+func forUpdateSkipLocked(db bun.IDB, q *bun.SelectQuery) *bun.SelectQuery {
+	if isSQLite(db) {
+		return q
+	}
+	return q.For("UPDATE SKIP LOCKED")
+}
+
+// lockAccount locks the account row until the transaction commits
+func lockAccount(ctx context.Context, tx bun.Tx, accountId int64) error {
+	if isSQLite(tx) {
+		return nil
+	}
+	_, err := forUpdate(tx, tx.NewSelect().Model((*models.Account)(nil)).Column("id").Where("id = ?", accountId)).Exec(ctx)
+	return err
+}
+
+// onConflictUpdate updates the existing row when the insert conflicts on the unique columns
+// MySQL updates the row of any conflicting unique key, the columns are only used by PostgreSQL and SQLite
+func onConflictUpdate(db bun.IDB, q *bun.InsertQuery, columns string) *bun.InsertQuery {
+	if isMySQL(db) {
+		return q.On("DUPLICATE KEY UPDATE")
+	}
+	return q.On("CONFLICT (" + columns + ") DO UPDATE")
+}
+
+// excluded references the value the conflicting insert tried to write
+func excluded(db bun.IDB, column string) string {
+	if isMySQL(db) {
+		return "VALUES(" + column + ")"
+	}
+	return "EXCLUDED." + column
+}
+
+// existing references the value of the existing row, alias is the alias of the model
+func existing(db bun.IDB, alias, column string) string {
+	if isMySQL(db) {
+		return column
+	}
+	return alias + "." + column
+}
+
+// jsonField extracts the text of a key from a json column, the key is passed as the query argument
+func jsonField(db bun.IDB, column string) string {
+	if isMySQL(db) {
+		return "JSON_UNQUOTE(JSON_EXTRACT(" + column + ", CONCAT('$.', ?)))"
+	}
+	return column + "::jsonb ->> ?"
+}
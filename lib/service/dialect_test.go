@@ -0,0 +1,61 @@
+package service
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/mysqldialect"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/driver/sqliteshim"
+)
+
+func TestOnConflictUpdate(t *testing.T) {
+	pgConn := sql.OpenDB(pgdriver.NewConnector())
+	defer pgConn.Close()
+	pg := bun.NewDB(pgConn, pgdialect.New())
+	// the connection is never used, the dialect only fails to detect the server version
+	mysqlConn, err := sql.Open("mysql", "user:password@tcp(127.0.0.1:1)/lndhub")
+	assert.NoError(t, err)
+	defer mysqlConn.Close()
+	mysql := bun.NewDB(mysqlConn, mysqldialect.New())
+
+	upsert := func(db *bun.DB) string {
+		counter := models.InvoiceCounter{UserID: 1, OpenInvoices: 1}
+		q := onConflictUpdate(db, db.NewInsert().Model(&counter), "user_id").
+			Set("open_invoices = " + existing(db, "invoice_counter", "open_invoices") + " + " + excluded(db, "open_invoices"))
+		b, err := q.AppendQuery(db.Formatter(), nil)
+		assert.NoError(t, err)
+		return string(b)
+	}
+	assert.Contains(t, upsert(pg), "ON CONFLICT (user_id) DO UPDATE SET open_invoices = invoice_counter.open_invoices + EXCLUDED.open_invoices")
+	assert.Contains(t, upsert(mysql), "ON DUPLICATE KEY UPDATE open_invoices = open_invoices + VALUES(open_invoices)")
+
+	assert.Equal(t, "metadata::jsonb ->> ?", jsonField(pg, "metadata"))
+	assert.Equal(t, "JSON_UNQUOTE(JSON_EXTRACT(metadata, CONCAT('$.', ?)))", jsonField(mysql, "metadata"))
+}
+
+func TestForUpdateSkipLocked(t *testing.T) {
+	pgConn := sql.OpenDB(pgdriver.NewConnector())
+	defer pgConn.Close()
+	pg := bun.NewDB(pgConn, pgdialect.New())
+	sqliteConn, err := sql.Open(sqliteshim.ShimName, "file::memory:")
+	assert.NoError(t, err)
+	defer sqliteConn.Close()
+	sqlite := bun.NewDB(sqliteConn, sqlitedialect.New())
+
+	claim := func(db *bun.DB) string {
+		q := forUpdateSkipLocked(db, db.NewSelect().Model((*models.WebhookDelivery)(nil)).Where("state = ?", "pending"))
+		b, err := q.AppendQuery(db.Formatter(), nil)
+		assert.NoError(t, err)
+		return string(b)
+	}
+	assert.Contains(t, claim(pg), "FOR UPDATE SKIP LOCKED")
+	// SQLite has no row locks and does not know the clause
+	assert.NotContains(t, claim(sqlite), "FOR UPDATE")
+}
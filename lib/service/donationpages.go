@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+)
+
+const (
+	donationPageMaxSuggestedAmounts = 6
+	// supporters are counted over this window, without revealing who they are
+	donationSupportersWindow = 30 * 24 * time.Hour
+)
+
+var ErrInvalidDonationPage = errors.New("invalid slug, display name, description or suggested amounts")
+var ErrDonationSlugTaken = errors.New("donation page slug is already taken")
+
+var donationSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{2,31}$`)
+
+// ValidateDonationPage checks the slug, the lengths of the texts and that the suggested amounts can be received
+func ValidateDonationPage(page *models.DonationPage, limits AmountLimits) error {
+	if !donationSlugPattern.MatchString(page.Slug) {
+		return ErrInvalidDonationPage
+	}
+	if page.DisplayName == "" || len(page.DisplayName) > 64 || len(page.Description) > 280 {
+		return ErrInvalidDonationPage
+	}
+	if len(page.SuggestedAmounts) > donationPageMaxSuggestedAmounts {
+		return ErrInvalidDonationPage
+	}
+	minSendable, maxSendable := limits.LnurlSendable()
+	for _, amount := range page.SuggestedAmounts {
+		if amount*1000 < minSendable || amount*1000 > maxSendable {
+			return ErrInvalidDonationPage
+		}
+	}
+	return nil
+}
+
+// SaveDonationPage creates or updates the donation page of the user
+func (svc *LndhubService) SaveDonationPage(ctx context.Context, page *models.DonationPage) (*models.DonationPage, error) {
+	page.Slug = strings.ToLower(page.Slug)
+	if err := ValidateDonationPage(page, svc.AmountLimits(nil)); err != nil {
+		return nil, err
+	}
+	taken, err := svc.DB.NewSelect().Model((*models.DonationPage)(nil)).Where("slug = ? AND user_id <> ?", page.Slug, page.UserID).Exists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrDonationSlugTaken
+	}
+	_, err = onConflictUpdate(svc.DB, svc.DB.NewInsert().Model(page), "user_id").
+		Set("slug = " + excluded(svc.DB, "slug")).
+		Set("display_name = " + excluded(svc.DB, "display_name")).
+		Set("description = " + excluded(svc.DB, "description")).
+		Set("suggested_amounts = " + excluded(svc.DB, "suggested_amounts")).
+		Set("enabled = " + excluded(svc.DB, "enabled")).
+		Set("updated_at = current_timestamp").
+		Returning("*").
+		Exec(ctx)
+	if err == nil && isMySQL(svc.DB) {
+		// MySQL returns no row for an upsert that updated the existing row
+		err = svc.DB.NewSelect().Model(page).Where("user_id = ?", page.UserID).Limit(1).Scan(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (svc *LndhubService) DonationPageFor(ctx context.Context, userId int64) (*models.DonationPage, error) {
+	var page models.DonationPage
+	err := svc.DB.NewSelect().Model(&page).Where("user_id = ?", userId).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// PublicDonationPage returns the enabled donation page with the slug
+func (svc *LndhubService) PublicDonationPage(ctx context.Context, slug string) (*models.DonationPage, error) {
+	var page models.DonationPage
+	err := svc.DB.NewSelect().Model(&page).Where("slug = ? AND enabled", strings.ToLower(slug)).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// DonationSupporterCount counts the settled donations of the page in the last 30 days
+func (svc *LndhubService) DonationSupporterCount(ctx context.Context, page *models.DonationPage) (int, error) {
+	return svc.DB.NewSelect().Model((*models.Invoice)(nil)).
+		Where("user_id = ? AND type = ? AND state = ? AND settled_at > ?", page.UserID, common.InvoiceTypeIncoming, common.InvoiceStateSettled, time.Now().Add(-donationSupportersWindow)).
+		Where(jsonField(svc.DB, "metadata")+" = ?", common.InvoiceMetadataSource, common.InvoiceSourceDonationPage).
+		Count(ctx)
+}
+
+// DonationLnurlMetadata is the LNURL-pay metadata (LUD-06) the donation invoices commit to
+func DonationLnurlMetadata(page *models.DonationPage) (string, error) {
+	metadata, err := json.Marshal([][]string{{"text/plain", "Donation to " + page.DisplayName}})
+	if err != nil {
+		return "", err
+	}
+	return string(metadata), nil
+}
+
+// AddDonationInvoice creates an invoice for a donation, the description hash commits to the LNURL-pay metadata
+func (svc *LndhubService) AddDonationInvoice(ctx context.Context, page *models.DonationPage, amount int64) (*models.Invoice, error) {
+	minSendable, maxSendable := svc.AmountLimits(nil).LnurlSendable()
+	if amount*1000 < minSendable || amount*1000 > maxSendable {
+		return nil, &ReceiveAmountError{Amount: amount, MinReceivable: minSendable / 1000, MaxReceivable: maxSendable / 1000}
+	}
+	metadata, err := DonationLnurlMetadata(page)
+	if err != nil {
+		return nil, err
+	}
+	descriptionHash := sha256.Sum256([]byte(metadata))
+	invoice := models.Invoice{
+		UserID:          page.UserID,
+		Amount:          amount,
+		DescriptionHash: hex.EncodeToString(descriptionHash[:]),
+		Metadata:        map[string]string{common.InvoiceMetadataSource: common.InvoiceSourceDonationPage},
+	}
+	expiry, err := svc.InvoiceExpiry(0)
+	if err != nil {
+		return nil, err
+	}
+	return svc.addIncomingInvoice(ctx, &invoice, expiry)
+}
+
+// EncodeLnurl encodes the URL as bech32 LNURL (LUD-01)
+func EncodeLnurl(url string) (string, error) {
+	data, err := bech32.ConvertBits([]byte(url), 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := bech32.Encode("lnurl", data)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(encoded), nil
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDonationPage(t *testing.T) {
+	limits := AmountLimits{MinReceivable: 1, MaxReceivable: 100000}
+	page := &models.DonationPage{Slug: "satoshi_21", DisplayName: "Satoshi", SuggestedAmounts: []int64{1000, 21000}}
+	assert.NoError(t, ValidateDonationPage(page, limits))
+
+	for _, slug := range []string{"", "ab", "-abc", "Satoshi", "satoshi nakamoto", strings.Repeat("a", 33)} {
+		invalid := *page
+		invalid.Slug = slug
+		assert.ErrorIs(t, ValidateDonationPage(&invalid, limits), ErrInvalidDonationPage, slug)
+	}
+	invalid := *page
+	invalid.DisplayName = ""
+	assert.ErrorIs(t, ValidateDonationPage(&invalid, limits), ErrInvalidDonationPage)
+	invalid = *page
+	invalid.SuggestedAmounts = []int64{0}
+	assert.ErrorIs(t, ValidateDonationPage(&invalid, limits), ErrInvalidDonationPage)
+	invalid = *page
+	invalid.SuggestedAmounts = []int64{100001}
+	assert.ErrorIs(t, ValidateDonationPage(&invalid, limits), ErrInvalidDonationPage)
+	invalid = *page
+	invalid.SuggestedAmounts = []int64{1, 2, 3, 4, 5, 6, 7}
+	assert.ErrorIs(t, ValidateDonationPage(&invalid, limits), ErrInvalidDonationPage)
+}
+
+func TestEncodeLnurl(t *testing.T) {
+	payUrl := "https://hub.example.com/donate/satoshi_21/lnurlp"
+	lnurl, err := EncodeLnurl(payUrl)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(lnurl, "LNURL1"))
+
+	hrp, data, err := bech32.DecodeNoLimit(strings.ToLower(lnurl))
+	assert.NoError(t, err)
+	assert.Equal(t, "lnurl", hrp)
+	decoded, err := bech32.ConvertBits(data, 5, 8, false)
+	assert.NoError(t, err)
+	assert.Equal(t, payUrl, string(decoded))
+}
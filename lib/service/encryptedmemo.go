@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+)
+
+const (
+	memoPublicKeyLength    = 32
+	encryptedMemoMaxLength = 4096
+)
+
+var ErrInvalidMemoPublicKey = errors.New("memo public key must be 32 base64 encoded bytes")
+var ErrInvalidEncryptedMemo = errors.New("encrypted memo must be base64 encoded and at most 4096 bytes")
+var ErrEncryptedMemoNotInternal = errors.New("encrypted memos are only supported for payments to users of this hub")
+var ErrNoMemoPublicKey = errors.New("recipient has no memo public key")
+var ErrMemoKeyHintMismatch = errors.New("memo key hint does not match the recipient's memo public key")
+
+// MemoKeyHint identifies a memo public key without revealing it: the hex encoded first 8 bytes of its sha256 hash
+// Wallets use the hint to pick the private key that decrypts a memo, e.g. after the key was rotated
+func MemoKeyHint(publicKey string) string {
+	raw, _ := base64.StdEncoding.DecodeString(publicKey)
+	hash := sha256.Sum256(raw)
+	return hex.EncodeToString(hash[:8])
+}
+
+// SetMemoPublicKey stores the public key other users encrypt memos to, the hub never sees the private key
+func (svc *LndhubService) SetMemoPublicKey(ctx context.Context, userId int64, publicKey string) error {
+	raw, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(raw) != memoPublicKeyLength {
+		return ErrInvalidMemoPublicKey
+	}
+	_, err = svc.DB.NewUpdate().Model((*models.User)(nil)).
+		Set("memo_public_key = ?", publicKey).
+		Set("updated_at = current_timestamp").
+		Where("id = ?", userId).
+		Exec(ctx)
+	return err
+}
+
+// RecipientMemoPublicKey returns the memo public key of the user who created the open incoming invoice
+func (svc *LndhubService) RecipientMemoPublicKey(ctx context.Context, paymentRequest string) (string, error) {
+	var publicKey sql.NullString
+	err := svc.DB.NewSelect().Model((*models.Invoice)(nil)).
+		Join("JOIN users ON users.id = invoice.user_id").
+		ColumnExpr("users.memo_public_key").
+		Where("invoice.type = ? AND invoice.payment_request = ? AND invoice.state = ?", common.InvoiceTypeIncoming, paymentRequest, common.InvoiceStateOpen).
+		Limit(1).
+		Scan(ctx, &publicKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrEncryptedMemoNotInternal
+	}
+	if err != nil {
+		return "", err
+	}
+	if !publicKey.Valid {
+		return "", ErrNoMemoPublicKey
+	}
+	return publicKey.String, nil
+}
+
+// AttachEncryptedMemo adds a client side encrypted memo to an outgoing payment to another user of the hub
+// The hub only checks the format and that the memo is encrypted to the recipient's current key,
+// the ciphertext is passed to the recipient's invoice when the payment settles
+func (svc *LndhubService) AttachEncryptedMemo(ctx context.Context, invoice *models.Invoice, encryptedMemo, keyHint string) error {
+	raw, err := base64.StdEncoding.DecodeString(encryptedMemo)
+	if err != nil || len(raw) == 0 || len(raw) > encryptedMemoMaxLength {
+		return ErrInvalidEncryptedMemo
+	}
+	if invoice.DestinationPubkeyHex != svc.IdentityPubkey || invoice.Keysend {
+		return ErrEncryptedMemoNotInternal
+	}
+	publicKey, err := svc.RecipientMemoPublicKey(ctx, invoice.PaymentRequest)
+	if err != nil {
+		return err
+	}
+	if MemoKeyHint(publicKey) != keyHint {
+		return ErrMemoKeyHintMismatch
+	}
+	invoice.EncryptedMemo = encryptedMemo
+	invoice.MemoKeyHint = keyHint
+	_, err = svc.DB.NewUpdate().Model(invoice).Column("encrypted_memo", "memo_key_hint", "updated_at").WherePK().Exec(ctx)
+	return err
+}
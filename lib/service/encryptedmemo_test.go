@@ -0,0 +1,14 @@
+package service
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoKeyHint(t *testing.T) {
+	publicKey := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	assert.Equal(t, "66687aadf862bd77", MemoKeyHint(publicKey))
+	assert.NotEqual(t, MemoKeyHint(publicKey), MemoKeyHint(base64.StdEncoding.EncodeToString([]byte("another 32 byte memo public key!"))))
+}
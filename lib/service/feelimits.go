@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getAlby/lndhub.go/lib"
+)
+
+// FeeLimitTier applies to payments up to MaxAmount satoshis
+// The limit is either a fixed amount in satoshis or a share of the payment amount in parts per million
+type FeeLimitTier struct {
+	MaxAmount int64
+	Fixed     int64
+	PPM       int64
+}
+
+// FeeLimitTiers are configured as comma separated "<max amount>:<limit>" pairs
+// The limit is either in satoshis or a percentage and "*" matches all amounts, e.g. "1000:10,100000:0.5%,*:0.3%"
+type FeeLimitTiers []FeeLimitTier
+
+// Decode implements envconfig.Decoder
+func (tiers *FeeLimitTiers) Decode(value string) error {
+	result := FeeLimitTiers{}
+	for _, rawTier := range strings.Split(value, ",") {
+		rawTier = strings.TrimSpace(rawTier)
+		if rawTier == "" {
+			continue
+		}
+		parts := strings.Split(rawTier, ":")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid fee limit tier: %s", rawTier)
+		}
+		tier := FeeLimitTier{MaxAmount: math.MaxInt64}
+		if parts[0] != "*" {
+			maxAmount, err := strconv.ParseInt(parts[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid fee limit tier amount: %s", rawTier)
+			}
+			tier.MaxAmount = maxAmount
+		}
+		if strings.HasSuffix(parts[1], "%") {
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(parts[1], "%"), 64)
+			if err != nil || percent < 0 || percent > 100 {
+				return fmt.Errorf("invalid fee limit tier percentage: %s", rawTier)
+			}
+			tier.PPM = int64(math.Round(percent * 10000))
+		} else {
+			fixed, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil || fixed < 0 {
+				return fmt.Errorf("invalid fee limit tier limit: %s", rawTier)
+			}
+			tier.Fixed = fixed
+		}
+		result = append(result, tier)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].MaxAmount < result[j].MaxAmount })
+	*tiers = result
+	return nil
+}
+
+// FeeLimitFor returns the fee limit of the first tier that covers the amount
+// ok is false if no tier matches
+func (tiers FeeLimitTiers) FeeLimitFor(amount int64) (feeLimit int64, ok bool, err error) {
+	for _, tier := range tiers {
+		if amount > tier.MaxAmount {
+			continue
+		}
+		if tier.PPM == 0 {
+			return tier.Fixed, true, nil
+		}
+		scaled, err := lib.MultiplyAmount(amount, tier.PPM)
+		if err != nil {
+			return 0, false, err
+		}
+		return scaled / 1000000, true, nil
+	}
+	return 0, false, nil
+}
+
+// feeLimitFor returns the fee limit of the first payment attempt
+func (svc *LndhubService) feeLimitFor(amount int64) (int64, error) {
+	feeLimit, ok, err := svc.Config.FeeLimitTiers.FeeLimitFor(amount)
+	if err != nil || !ok {
+		return svc.Config.PaymentFeeLimit, err
+	}
+	return feeLimit, nil
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeeLimitTiers(t *testing.T) {
+	tiers := FeeLimitTiers{}
+	assert.NoError(t, tiers.Decode("100000:0.5%, *:0.3%,1000:10"))
+	assert.Equal(t, 3, len(tiers))
+
+	cases := []struct {
+		amount   int64
+		feeLimit int64
+	}{
+		{1, 10},
+		{1000, 10},
+		{1001, 5},
+		{100000, 500},
+		{1000000, 3000},
+	}
+	for _, c := range cases {
+		feeLimit, ok, err := tiers.FeeLimitFor(c.amount)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, c.feeLimit, feeLimit, "amount %v", c.amount)
+	}
+}
+
+func TestFeeLimitTiersWithoutCatchAll(t *testing.T) {
+	tiers := FeeLimitTiers{}
+	assert.NoError(t, tiers.Decode("1000:10"))
+	_, ok, err := tiers.FeeLimitFor(1001)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestFeeLimitTiersInvalid(t *testing.T) {
+	tiers := FeeLimitTiers{}
+	assert.Error(t, tiers.Decode("1000"))
+	assert.Error(t, tiers.Decode("abc:10"))
+	assert.Error(t, tiers.Decode("1000:101%"))
+	assert.Error(t, tiers.Decode("1000:-1"))
+}
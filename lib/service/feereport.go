@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/lib"
+)
+
+const (
+	FeeReportPeriodDay   = "day"
+	FeeReportPeriodWeek  = "week"
+	FeeReportPeriodMonth = "month"
+)
+
+// reports without a start cover the last year
+const defaultFeeReportRange = 365 * 24 * time.Hour
+
+var ErrInvalidFeeReportPeriod = errors.New("period must be day, week or month")
+
+// FeeReport is the routing fees paid per period, periods without fees are left out
+type FeeReport struct {
+	Period   string            `json:"period"`
+	From     time.Time         `json:"from"`
+	To       time.Time         `json:"to"`
+	Fees     int64             `json:"fees"`
+	Payments int               `json:"payments"`
+	Periods  []FeeReportPeriod `json:"periods"`
+}
+
+// FeeReportPeriod is a period of a fee report, Payments counts the payments that paid a routing fee
+type FeeReportPeriod struct {
+	Start    time.Time `json:"start"`
+	Fees     int64     `json:"fees"`
+	Payments int       `json:"payments"`
+}
+
+type feeReportEntry struct {
+	CreatedAt time.Time
+	Amount    int64
+	InvoiceID int64
+	Credited  bool
+}
+
+// feeReportPeriodStart returns the start of the day, week (starting on Monday) or month of t in the location
+func feeReportPeriodStart(t time.Time, period string, location *time.Location) time.Time {
+	t = t.In(location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+	switch period {
+	case FeeReportPeriodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case FeeReportPeriodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, location)
+	default:
+		return day
+	}
+}
+
+// aggregateFeeReport sums up the entries of the fees accounts per period, the entries are ordered by time
+// Entries debiting a fees account take a charged fee back and are subtracted
+func aggregateFeeReport(report *FeeReport, entries []feeReportEntry, location *time.Location) error {
+	periodIndex := map[time.Time]int{}
+	periodPayments := map[time.Time]map[int64]bool{}
+	payments := map[int64]bool{}
+	for _, entry := range entries {
+		start := feeReportPeriodStart(entry.CreatedAt, report.Period, location)
+		i, ok := periodIndex[start]
+		if !ok {
+			i = len(report.Periods)
+			periodIndex[start] = i
+			periodPayments[start] = map[int64]bool{}
+			report.Periods = append(report.Periods, FeeReportPeriod{Start: start})
+		}
+		amount := entry.Amount
+		if entry.Credited {
+			periodPayments[start][entry.InvoiceID] = true
+			payments[entry.InvoiceID] = true
+		} else {
+			amount = -amount
+		}
+		var err error
+		if report.Periods[i].Fees, err = lib.AddAmounts(report.Periods[i].Fees, amount); err != nil {
+			return err
+		}
+		report.Periods[i].Payments = len(periodPayments[start])
+		if report.Fees, err = lib.AddAmounts(report.Fees, amount); err != nil {
+			return err
+		}
+	}
+	report.Payments = len(payments)
+	return nil
+}
+
+// RoutingFeeReport sums up the routing fees the user paid per period between from and to, a userId of 0 reports
+// the fees of all users. Periods start in the location, a zero from reports the year before to
+func (svc *LndhubService) RoutingFeeReport(ctx context.Context, userId int64, period string, from, to time.Time, location *time.Location) (*FeeReport, error) {
+	switch period {
+	case FeeReportPeriodDay, FeeReportPeriodWeek, FeeReportPeriodMonth:
+	default:
+		return nil, ErrInvalidFeeReportPeriod
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultFeeReportRange)
+	}
+	report := &FeeReport{Period: period, From: from.In(location), To: to.In(location), Periods: []FeeReportPeriod{}}
+
+	entries := []feeReportEntry{}
+	q := svc.readDB(ctx).NewSelect().
+		TableExpr("transaction_entries AS entry").
+		Join("JOIN accounts AS credit_account ON credit_account.id = entry.credit_account_id").
+		Join("JOIN accounts AS debit_account ON debit_account.id = entry.debit_account_id").
+		ColumnExpr("entry.created_at, entry.amount, entry.invoice_id").
+		ColumnExpr("CASE WHEN credit_account.type = ? THEN 1 ELSE 0 END AS credited", common.AccountTypeFees).
+		Where("credit_account.type = ? OR debit_account.type = ?", common.AccountTypeFees, common.AccountTypeFees).
+		Where("entry.created_at >= ? AND entry.created_at < ?", from, to).
+		OrderExpr("entry.created_at ASC, entry.id ASC")
+	if userId != 0 {
+		q = q.Where("entry.user_id = ?", userId)
+	}
+	if err := q.Scan(ctx, &entries); err != nil {
+		return nil, err
+	}
+	if err := aggregateFeeReport(report, entries, location); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
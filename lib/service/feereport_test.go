@@ -0,0 +1,47 @@
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeeReportPeriodStart(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+	// Thursday 23:30 UTC is already Friday in Berlin
+	at := time.Date(2022, 5, 12, 23, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2022, 5, 12, 0, 0, 0, 0, time.UTC), feeReportPeriodStart(at, FeeReportPeriodDay, time.UTC))
+	assert.Equal(t, time.Date(2022, 5, 13, 0, 0, 0, 0, berlin), feeReportPeriodStart(at, FeeReportPeriodDay, berlin))
+	assert.Equal(t, time.Date(2022, 5, 9, 0, 0, 0, 0, time.UTC), feeReportPeriodStart(at, FeeReportPeriodWeek, time.UTC))
+	assert.Equal(t, time.Date(2022, 5, 9, 0, 0, 0, 0, time.UTC), feeReportPeriodStart(time.Date(2022, 5, 15, 12, 0, 0, 0, time.UTC), FeeReportPeriodWeek, time.UTC))
+	assert.Equal(t, time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC), feeReportPeriodStart(at, FeeReportPeriodMonth, time.UTC))
+}
+
+func TestAggregateFeeReport(t *testing.T) {
+	report := &FeeReport{Period: FeeReportPeriodMonth, Periods: []FeeReportPeriod{}}
+	err := aggregateFeeReport(report, []feeReportEntry{
+		{CreatedAt: time.Date(2022, 4, 2, 0, 0, 0, 0, time.UTC), Amount: 3, InvoiceID: 1, Credited: true},
+		{CreatedAt: time.Date(2022, 4, 20, 0, 0, 0, 0, time.UTC), Amount: 5, InvoiceID: 2, Credited: true},
+		{CreatedAt: time.Date(2022, 4, 20, 0, 0, 0, 0, time.UTC), Amount: 1, InvoiceID: 2, Credited: true},
+		{CreatedAt: time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC), Amount: 7, InvoiceID: 3, Credited: true},
+		{CreatedAt: time.Date(2022, 5, 2, 0, 0, 0, 0, time.UTC), Amount: 2, InvoiceID: 3, Credited: false},
+	}, time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(14), report.Fees)
+	assert.Equal(t, 3, report.Payments)
+	assert.Equal(t, []FeeReportPeriod{
+		{Start: time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC), Fees: 9, Payments: 2},
+		{Start: time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC), Fees: 5, Payments: 1},
+	}, report.Periods)
+
+	report = &FeeReport{Period: FeeReportPeriodMonth, Periods: []FeeReportPeriod{}}
+	err = aggregateFeeReport(report, []feeReportEntry{
+		{CreatedAt: time.Date(2022, 4, 2, 0, 0, 0, 0, time.UTC), Amount: math.MaxInt64, InvoiceID: 1, Credited: true},
+		{CreatedAt: time.Date(2022, 5, 2, 0, 0, 0, 0, time.UTC), Amount: 1, InvoiceID: 2, Credited: true},
+	}, time.UTC)
+	assert.ErrorIs(t, err, lib.ErrAmountOverflow)
+}
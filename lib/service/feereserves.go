@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/uptrace/bun"
+)
+
+// FeeReserveFor returns the routing fee reserved upfront for the invoice, the fee limit of the last payment attempt
+// Internal payments do not pay routing fees
+func (svc *LndhubService) FeeReserveFor(invoice *models.Invoice) (int64, error) {
+	if svc.IdentityPubkey == invoice.DestinationPubkeyHex {
+		return 0, nil
+	}
+	feeReserve, err := svc.feeLimitFor(invoice.Amount)
+	if err != nil {
+		return 0, err
+	}
+	for attempt := 0; attempt < svc.Config.PaymentMaxRetries; attempt++ {
+		feeReserve, err = lib.MultiplyAmount(feeReserve, svc.Config.PaymentRetryFeeFactor)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return feeReserve, nil
+}
+
+// insertFeeReserveEntry moves the invoice's fee reserve from the user's current account to the account of the payment entry
+func (svc *LndhubService) insertFeeReserveEntry(ctx context.Context, db bun.IDB, invoice *models.Invoice, parentEntry models.TransactionEntry) error {
+	if invoice.FeeReserve <= 0 {
+		return nil
+	}
+	entry := models.TransactionEntry{
+		UserID:          invoice.UserID,
+		InvoiceID:       invoice.ID,
+		CreditAccountID: parentEntry.CreditAccountID,
+		DebitAccountID:  parentEntry.DebitAccountID,
+		Amount:          invoice.FeeReserve,
+		ParentID:        parentEntry.ID,
+	}
+	// persisted upfront, payments failed later on, e.g. by an admin, refund the reserve as well
+	_, err := db.NewUpdate().Model(invoice).Column("fee_reserve").WherePK().Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewInsert().Model(&entry).Exec(ctx)
+	if err != nil {
+		svc.Logger.Errorf("Could not insert fee reserve transaction entry user_id:%v invoice_id:%v %v", invoice.UserID, invoice.ID, err)
+	}
+	return err
+}
+
+// revertFeeReserveEntry refunds the fee reserve of a failed outgoing payment
+func (svc *LndhubService) revertFeeReserveEntry(ctx context.Context, db bun.IDB, invoice *models.Invoice, parentEntry models.TransactionEntry) error {
+	if invoice.FeeReserve <= 0 {
+		return nil
+	}
+	entry := models.TransactionEntry{
+		UserID:          invoice.UserID,
+		InvoiceID:       invoice.ID,
+		CreditAccountID: parentEntry.DebitAccountID,
+		DebitAccountID:  parentEntry.CreditAccountID,
+		Amount:          invoice.FeeReserve,
+		ParentID:        parentEntry.ID,
+	}
+	_, err := db.NewInsert().Model(&entry).Exec(ctx)
+	if err != nil {
+		svc.Logger.Errorf("Could not revert fee reserve transaction entry user_id:%v invoice_id:%v %v", invoice.UserID, invoice.ID, err)
+	}
+	return err
+}
+
+// routingFeeEntries books the routing fee of a successful payment
+// The fee is taken from the reserve and the unused reserve is refunded to the current account
+// Fees above the reserve, and the fees of payments without a reserve, are debited from the current account
+func routingFeeEntries(invoice *models.Invoice, parentEntry models.TransactionEntry, feeAccountID int64) []models.TransactionEntry {
+	newEntry := func(creditAccountID, debitAccountID, amount int64) models.TransactionEntry {
+		return models.TransactionEntry{
+			UserID:          invoice.UserID,
+			InvoiceID:       invoice.ID,
+			CreditAccountID: creditAccountID,
+			DebitAccountID:  debitAccountID,
+			Amount:          amount,
+			ParentID:        parentEntry.ID,
+		}
+	}
+	if invoice.FeeReserve <= 0 {
+		return []models.TransactionEntry{newEntry(feeAccountID, parentEntry.DebitAccountID, invoice.Fee)}
+	}
+	if invoice.Fee >= invoice.FeeReserve {
+		entries := []models.TransactionEntry{newEntry(feeAccountID, parentEntry.CreditAccountID, invoice.FeeReserve)}
+		if invoice.Fee > invoice.FeeReserve {
+			entries = append(entries, newEntry(feeAccountID, parentEntry.DebitAccountID, invoice.Fee-invoice.FeeReserve))
+		}
+		return entries
+	}
+	entries := []models.TransactionEntry{newEntry(parentEntry.DebitAccountID, parentEntry.CreditAccountID, invoice.FeeReserve-invoice.Fee)}
+	if invoice.Fee > 0 {
+		entries = append(entries, newEntry(feeAccountID, parentEntry.CreditAccountID, invoice.Fee))
+	}
+	return entries
+}
+
+// insertRoutingFeeEntries charges the routing fee of a successful payment and releases its fee reserve
+func (svc *LndhubService) insertRoutingFeeEntries(ctx context.Context, db bun.IDB, invoice *models.Invoice, parentEntry models.TransactionEntry) error {
+	feeAccount, err := svc.AccountFor(ctx, common.AccountTypeFees, invoice.UserID)
+	if err != nil {
+		svc.Logger.Errorf("Could not find fees account user_id:%v", invoice.UserID)
+		return err
+	}
+	for _, entry := range routingFeeEntries(invoice, parentEntry, feeAccount.ID) {
+		if _, err := db.NewInsert().Model(&entry).Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingFeeEntries(t *testing.T) {
+	const currentAccountID, inFlightAccountID, feeAccountID = 1, 2, 3
+	parentEntry := models.TransactionEntry{ID: 10, CreditAccountID: inFlightAccountID, DebitAccountID: currentAccountID}
+	balances := func(entries []models.TransactionEntry) map[int64]int64 {
+		result := map[int64]int64{}
+		for _, entry := range entries {
+			assert.Equal(t, int64(10), entry.ParentID)
+			result[entry.CreditAccountID] += entry.Amount
+			result[entry.DebitAccountID] -= entry.Amount
+		}
+		return result
+	}
+
+	// the unused reserve is refunded
+	entries := routingFeeEntries(&models.Invoice{Fee: 3, FeeReserve: 10}, parentEntry, feeAccountID)
+	assert.Equal(t, map[int64]int64{currentAccountID: 7, inFlightAccountID: -10, feeAccountID: 3}, balances(entries))
+
+	// no fee, the whole reserve is refunded
+	entries = routingFeeEntries(&models.Invoice{Fee: 0, FeeReserve: 10}, parentEntry, feeAccountID)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, map[int64]int64{currentAccountID: 10, inFlightAccountID: -10}, balances(entries))
+
+	// the reserve is used up
+	entries = routingFeeEntries(&models.Invoice{Fee: 10, FeeReserve: 10}, parentEntry, feeAccountID)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, map[int64]int64{inFlightAccountID: -10, feeAccountID: 10}, balances(entries))
+
+	// fees above the reserve are debited from the current account
+	entries = routingFeeEntries(&models.Invoice{Fee: 12, FeeReserve: 10}, parentEntry, feeAccountID)
+	assert.Equal(t, map[int64]int64{currentAccountID: -2, inFlightAccountID: -10, feeAccountID: 12}, balances(entries))
+
+	// payments without a reserve
+	entries = routingFeeEntries(&models.Invoice{Fee: 5}, parentEntry, feeAccountID)
+	assert.Equal(t, map[int64]int64{currentAccountID: -5, feeAccountID: 5}, balances(entries))
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/preimage"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"github.com/uptrace/bun"
+)
+
+var ErrInvalidPreimage = errors.New("preimage or payment hash is invalid or already used")
+var ErrHoldInvoiceNotFound = errors.New("hold invoice not found or already settled or canceled")
+
+// parseInvoicePreimage validates a client supplied preimage or, for hold invoices, payment hash
+// It returns the hex encoded preimage and payment hash, the preimage is empty for hold invoices
+func parseInvoicePreimage(preimageHex, rHashHex string) (string, string, error) {
+	if preimageHex != "" && rHashHex != "" {
+		return "", "", ErrInvalidPreimage
+	}
+	if preimageHex != "" {
+		invoicePreimage, err := preimage.Parse(preimageHex)
+		if err != nil {
+			return "", "", ErrInvalidPreimage
+		}
+		return hex.EncodeToString(invoicePreimage), preimage.HashHex(invoicePreimage), nil
+	}
+	if rHashHex != "" {
+		rHash, err := preimage.Parse(rHashHex)
+		if err != nil {
+			return "", "", ErrInvalidPreimage
+		}
+		return "", hex.EncodeToString(rHash), nil
+	}
+	return "", "", nil
+}
+
+// invoicePreimage returns the preimage of a new invoice, a random one unless the client supplied the preimage or payment hash
+// Hold invoices have no preimage, a client supplied payment hash may not be used by another invoice
+func (svc *LndhubService) invoicePreimage(ctx context.Context, invoice *models.Invoice) ([]byte, error) {
+	if invoice.RHash == "" {
+		return preimage.New()
+	}
+	used, err := svc.DB.NewSelect().Model((*models.Invoice)(nil)).Where("r_hash = ?", invoice.RHash).Exists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if used {
+		return nil, ErrInvalidPreimage
+	}
+	if invoice.Preimage == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(invoice.Preimage)
+}
+
+// addHoldInvoice adds an invoice for the payment hash that the node only settles once SettleHoldInvoice reveals the preimage
+func (svc *LndhubService) addHoldInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, rHash string) (*lnrpc.AddInvoiceResponse, error) {
+	hash, err := hex.DecodeString(rHash)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := svc.LndClient.AddHoldInvoice(ctx, &invoicesrpc.AddHoldInvoiceRequest{
+		Memo:            lnInvoice.Memo,
+		Hash:            hash,
+		Value:           lnInvoice.Value,
+		DescriptionHash: lnInvoice.DescriptionHash,
+		Expiry:          lnInvoice.Expiry,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lnrpc.AddInvoiceResponse{RHash: hash, PaymentRequest: resp.PaymentRequest, AddIndex: resp.AddIndex}, nil
+}
+
+// holdInvoice finds an unsettled hold invoice of the user
+// Invoices that expired can still be settled while the node holds a payment for them
+func (svc *LndhubService) holdInvoice(ctx context.Context, userId int64, rHash string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := svc.DB.NewSelect().Model(&invoice).
+		Where("user_id = ? AND type = ? AND r_hash = ? AND preimage IS NULL AND state IN (?)",
+			userId, common.InvoiceTypeIncoming, strings.ToLower(rHash), bun.In([]string{common.InvoiceStateOpen, common.InvoiceStateExpired})).
+		Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, ErrHoldInvoiceNotFound
+	}
+	return &invoice, nil
+}
+
+// SettleHoldInvoice reveals the preimage of a hold invoice to the node, which settles the payment it holds
+// The user is credited when the invoice subscription receives the settlement
+func (svc *LndhubService) SettleHoldInvoice(ctx context.Context, userId int64, rHash, preimageHex string) (*models.Invoice, error) {
+	invoice, err := svc.holdInvoice(ctx, userId, rHash)
+	if err != nil {
+		return nil, err
+	}
+	holdPreimage, err := preimage.Parse(preimageHex)
+	if err != nil || preimage.Verify(holdPreimage, invoice.RHash) != nil {
+		return nil, ErrInvalidPreimage
+	}
+	if _, err := svc.LndClient.SettleInvoice(ctx, &invoicesrpc.SettleInvoiceMsg{Preimage: holdPreimage}); err != nil {
+		return nil, err
+	}
+	invoice.Preimage = hex.EncodeToString(holdPreimage)
+	_, err = svc.DB.NewUpdate().Model(invoice).Column("preimage").WherePK().Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc.Logger.Infof("Hold invoice settled by the user user_id:%v invoice_id:%v", userId, invoice.ID)
+	return invoice, nil
+}
+
+// CancelHoldInvoice cancels a hold invoice, a payment the node holds for it is returned to the payer
+func (svc *LndhubService) CancelHoldInvoice(ctx context.Context, userId int64, rHash string) (*models.Invoice, error) {
+	invoice, err := svc.holdInvoice(ctx, userId, rHash)
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.cancelIncomingInvoice(ctx, invoice); err != nil {
+		return nil, err
+	}
+	svc.Logger.Infof("Hold invoice canceled by the user user_id:%v invoice_id:%v", userId, invoice.ID)
+	return invoice, nil
+}
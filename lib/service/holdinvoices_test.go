@@ -0,0 +1,33 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInvoicePreimage(t *testing.T) {
+	preimage, rHash, err := parseInvoicePreimage("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "", preimage)
+	assert.Equal(t, "", rHash)
+
+	// sha256 of 32 zero bytes
+	preimage, rHash, err = parseInvoicePreimage(strings.Repeat("00", 32), "")
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("00", 32), preimage)
+	assert.Equal(t, "66687aadf862bd776c8fc18b8e9f8e20089714856ee233b3902a591d0d5f2925", rHash)
+
+	preimage, rHash, err = parseInvoicePreimage("", strings.Repeat("AB", 32))
+	assert.NoError(t, err)
+	assert.Equal(t, "", preimage)
+	assert.Equal(t, strings.Repeat("ab", 32), rHash)
+
+	_, _, err = parseInvoicePreimage(strings.Repeat("00", 31), "")
+	assert.ErrorIs(t, err, ErrInvalidPreimage)
+	_, _, err = parseInvoicePreimage("", "xyz")
+	assert.ErrorIs(t, err, ErrInvalidPreimage)
+	_, _, err = parseInvoicePreimage(strings.Repeat("00", 32), strings.Repeat("ab", 32))
+	assert.ErrorIs(t, err, ErrInvalidPreimage)
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+)
+
+// idempotencyKeyTTL is how long responses are replayed, afterwards a key can be used for a new request
+const idempotencyKeyTTL = 24 * time.Hour
+
+// idempotencyKeyLease is how long a request can hold its key, keys of requests that did not finish in time are released
+// unless the request booked a payment, the payment may still be in flight and a retry must not send it again
+const idempotencyKeyLease = 5 * time.Minute
+
+var ErrIdempotencyKeyInUse = errors.New("a request with this idempotency key is still in progress")
+var ErrIdempotencyKeyMismatch = errors.New("the idempotency key was used for a different request")
+
+// IdempotencyRequestHash identifies the request a key was used for, a key can only be replayed for the same request
+func IdempotencyRequestHash(method, path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// BeginIdempotentRequest claims the key for the request
+// If the key was already used for the same request the stored record is returned with replay set, its response is sent again
+func (svc *LndhubService) BeginIdempotentRequest(ctx context.Context, userId int64, key, requestHash string) (record *models.IdempotencyKey, replay bool, err error) {
+	now := time.Now()
+	_, err = svc.DB.NewDelete().Model((*models.IdempotencyKey)(nil)).
+		Where("user_id = ? AND created_at < ?", userId, now.Add(-idempotencyKeyTTL)).
+		Exec(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := svc.releaseExpiredIdempotencyLeases(ctx, userId, now); err != nil {
+		return nil, false, err
+	}
+	record = &models.IdempotencyKey{UserID: userId, Key: key, RequestHash: requestHash}
+	res, err := svc.DB.NewInsert().Model(record).Ignore().Returning("id").Exec(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if inserted, _ := res.RowsAffected(); inserted == 1 {
+		return record, false, nil
+	}
+	existing := &models.IdempotencyKey{}
+	err = svc.DB.NewSelect().Model(existing).Where("user_id = ? AND ? = ?", userId, bun.Ident("key"), key).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, true, checkIdempotencyReplay(existing, requestHash)
+}
+
+// releaseExpiredIdempotencyLeases releases the keys of requests that did not finish within the lease
+// Keys of requests that booked a payment stay in use until the key expires
+func (svc *LndhubService) releaseExpiredIdempotencyLeases(ctx context.Context, userId int64, now time.Time) error {
+	var pending []models.IdempotencyKey
+	err := svc.DB.NewSelect().Model(&pending).
+		Where("user_id = ? AND status_code IS NULL AND created_at < ?", userId, now.Add(-idempotencyKeyLease)).
+		Scan(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range pending {
+		sent, err := svc.IdempotentPaymentSent(ctx, pending[i].InvoiceID)
+		if err != nil {
+			return err
+		}
+		if sent {
+			continue
+		}
+		_, err = svc.DB.NewDelete().Model(&pending[i]).WherePK().Where("status_code IS NULL").Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkIdempotencyReplay(record *models.IdempotencyKey, requestHash string) error {
+	if record.RequestHash != requestHash {
+		return ErrIdempotencyKeyMismatch
+	}
+	if record.StatusCode == 0 {
+		return ErrIdempotencyKeyInUse
+	}
+	return nil
+}
+
+// AttachIdempotentInvoice records the invoice of the request while it is in progress
+// The lease of the key is not released once the payment of the invoice was booked
+func (svc *LndhubService) AttachIdempotentInvoice(ctx context.Context, record *models.IdempotencyKey, invoiceId int64) error {
+	record.InvoiceID = invoiceId
+	_, err := svc.DB.NewUpdate().Model(record).Column("invoice_id").WherePK().Exec(ctx)
+	return err
+}
+
+// CompleteIdempotentRequest stores the response and the invoice the request created for replays
+func (svc *LndhubService) CompleteIdempotentRequest(ctx context.Context, record *models.IdempotencyKey, invoiceId int64, statusCode int, response []byte) error {
+	record.InvoiceID = invoiceId
+	record.StatusCode = statusCode
+	record.Response = string(response)
+	record.CompletedAt = bun.NullTime{Time: time.Now()}
+	_, err := svc.DB.NewUpdate().Model(record).Column("invoice_id", "status_code", "response", "completed_at").WherePK().Exec(ctx)
+	return err
+}
+
+// IdempotentPaymentSent returns whether the request booked the payment of the invoice, i.e. it may have been sent to the node
+// The key of such a request is kept even if the request failed, a retry must not send the payment again
+func (svc *LndhubService) IdempotentPaymentSent(ctx context.Context, invoiceId int64) (bool, error) {
+	if invoiceId == 0 {
+		return false, nil
+	}
+	return svc.DB.NewSelect().Model((*models.TransactionEntry)(nil)).
+		Join("JOIN invoices AS invoice ON invoice.id = transaction_entry.invoice_id").
+		Where("invoice.id = ? AND invoice.type = ?", invoiceId, common.InvoiceTypeOutgoing).
+		Exists(ctx)
+}
+
+// AbortIdempotentRequest releases the key of a request that failed without a result, the client can retry it
+func (svc *LndhubService) AbortIdempotentRequest(ctx context.Context, record *models.IdempotencyKey) error {
+	_, err := svc.DB.NewDelete().Model(record).WherePK().Exec(ctx)
+	return err
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyRequestHash(t *testing.T) {
+	hash := IdempotencyRequestHash("POST", "/payinvoice", []byte(`{"invoice":"lnbc1"}`))
+	assert.Equal(t, hash, IdempotencyRequestHash("POST", "/payinvoice", []byte(`{"invoice":"lnbc1"}`)))
+	assert.NotEqual(t, hash, IdempotencyRequestHash("POST", "/payinvoice", []byte(`{"invoice":"lnbc2"}`)))
+	assert.NotEqual(t, hash, IdempotencyRequestHash("POST", "/keysend", []byte(`{"invoice":"lnbc1"}`)))
+}
+
+func TestCheckIdempotencyReplay(t *testing.T) {
+	completed := &models.IdempotencyKey{RequestHash: "abc", StatusCode: 200}
+	assert.NoError(t, checkIdempotencyReplay(completed, "abc"))
+	assert.ErrorIs(t, checkIdempotencyReplay(completed, "def"), ErrIdempotencyKeyMismatch)
+	inProgress := &models.IdempotencyKey{RequestHash: "abc"}
+	assert.ErrorIs(t, checkIdempotencyReplay(inProgress, "abc"), ErrIdempotencyKeyInUse)
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+)
+
+var ErrInFlightExposureHigh = errors.New("amount locked in in-flight payments exceeds the configured maximum")
+
+// InFlightExposure is the amount locked in outgoing payments that are neither settled nor failed yet
+type InFlightExposure struct {
+	Amount   int64 `json:"amount" bun:"amount"`
+	Payments int64 `json:"payments" bun:"payments"`
+}
+
+// settleInFlightEntry moves the amount of a settled payment from the user's in-flight to the outgoing account
+// Payments debited before the in-flight account existed were moved to the outgoing account directly
+func (svc *LndhubService) settleInFlightEntry(ctx context.Context, db bun.IDB, invoice *models.Invoice, parentEntry models.TransactionEntry) error {
+	inFlightAccount, err := svc.AccountFor(ctx, common.AccountTypeInFlight, invoice.UserID)
+	if err != nil {
+		return err
+	}
+	if parentEntry.CreditAccountID != inFlightAccount.ID {
+		return nil
+	}
+	outgoingAccount, err := svc.AccountFor(ctx, common.AccountTypeOutgoing, invoice.UserID)
+	if err != nil {
+		return err
+	}
+	entry := models.TransactionEntry{
+		UserID:          invoice.UserID,
+		InvoiceID:       invoice.ID,
+		CreditAccountID: outgoingAccount.ID,
+		DebitAccountID:  inFlightAccount.ID,
+		Amount:          parentEntry.Amount,
+		ParentID:        parentEntry.ID,
+	}
+	_, err = db.NewInsert().Model(&entry).Exec(ctx)
+	return err
+}
+
+// InFlightExposure sums the balances of all in-flight accounts by payment
+func (svc *LndhubService) InFlightExposure(ctx context.Context) (*InFlightExposure, error) {
+	payments := svc.DB.NewSelect().
+		TableExpr("account_ledgers AS ledger").
+		Join("JOIN accounts AS account ON account.id = ledger.account_id").
+		Join("JOIN transaction_entries AS entry ON entry.id = ledger.transaction_entry_id").
+		ColumnExpr("entry.invoice_id, SUM(ledger.amount) AS amount").
+		Where("account.type = ?", common.AccountTypeInFlight).
+		GroupExpr("entry.invoice_id").
+		Having("SUM(ledger.amount) <> 0")
+	exposure := InFlightExposure{}
+	err := svc.DB.NewSelect().
+		TableExpr("(?) AS payment", payments).
+		ColumnExpr("COALESCE(SUM(payment.amount), 0) AS amount, COUNT(*) AS payments").
+		Scan(ctx, &exposure)
+	if err != nil {
+		return nil, err
+	}
+	return &exposure, nil
+}
+
+// CheckInFlightExposure fails if MAX_IN_FLIGHT_EXPOSURE is set and more is locked in in-flight payments
+func (svc *LndhubService) CheckInFlightExposure(ctx context.Context) (*InFlightExposure, error) {
+	exposure, err := svc.InFlightExposure(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if max := svc.Config.MaxInFlightExposure; max > 0 && exposure.Amount > max {
+		return exposure, ErrInFlightExposureHigh
+	}
+	return exposure, nil
+}
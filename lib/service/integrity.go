@@ -0,0 +1,399 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+)
+
+const (
+	AuditActionFreezeUser   = "freeze_user"
+	AuditActionUnfreezeUser = "unfreeze_user"
+)
+
+var ErrAccountFrozen = errors.New("account is frozen")
+
+// integrityFindingMetrics is exported by expvar with the number of findings of the last check by kind
+// integrityIncidentMetrics counts the reported incidents by kind since the start
+var integrityFindingMetrics = expvar.NewMap("integrity_findings")
+var integrityIncidentMetrics = expvar.NewMap("integrity_incidents")
+
+var integrityIncidentKinds = []string{
+	common.IntegrityIncidentDuplicateSettlement,
+	common.IntegrityIncidentWrongAccountType,
+	common.IntegrityIncidentOrphanedFee,
+	common.IntegrityIncidentBalanceMismatch,
+	common.IntegrityIncidentMissingSettlement,
+	common.IntegrityIncidentUnbalancedInvoice,
+	common.IntegrityIncidentOrphanedEntry,
+}
+
+// IntegrityFinding is a ledger inconsistency found by one of the integrity checks
+type IntegrityFinding struct {
+	Kind               string `bun:"kind"`
+	UserID             int64  `bun:"user_id"`
+	InvoiceID          int64  `bun:"invoice_id"`
+	TransactionEntryID int64  `bun:"transaction_entry_id"`
+	EntryCount         int64  `bun:"entry_count"`
+	DebitAccountType   string `bun:"debit_account_type"`
+	CreditAccountType  string `bun:"credit_account_type"`
+	InvoiceType        string `bun:"invoice_type"`
+	InvoiceState       string `bun:"invoice_state"`
+	AccountID          int64  `bun:"account_id"`
+	StoredBalance      int64  `bun:"stored_balance"`
+	LedgerBalance      int64  `bun:"ledger_balance"`
+}
+
+func (f *IntegrityFinding) fingerprint() string {
+	if f.Kind == common.IntegrityIncidentBalanceMismatch {
+		// a mismatch is only reported again if the difference changes, not for every new entry of the account
+		return fmt.Sprintf("%s:%d:%d", f.Kind, f.AccountID, f.StoredBalance-f.LedgerBalance)
+	}
+	return fmt.Sprintf("%s:%d:%d", f.Kind, f.InvoiceID, f.TransactionEntryID)
+}
+
+func (f *IntegrityFinding) details() string {
+	switch f.Kind {
+	case common.IntegrityIncidentDuplicateSettlement:
+		return fmt.Sprintf("invoice %d was credited %d times", f.InvoiceID, f.EntryCount)
+	case common.IntegrityIncidentWrongAccountType:
+		return fmt.Sprintf("entry %d moves funds from %s to %s for a %s invoice or references accounts of another user", f.TransactionEntryID, f.DebitAccountType, f.CreditAccountType, f.InvoiceType)
+	case common.IntegrityIncidentOrphanedFee:
+		return fmt.Sprintf("fee entry %d has no matching parent entry or belongs to a %s invoice", f.TransactionEntryID, f.InvoiceState)
+	case common.IntegrityIncidentBalanceMismatch:
+		return fmt.Sprintf("account %d has a stored balance of %d but its entries sum up to %d", f.AccountID, f.StoredBalance, f.LedgerBalance)
+	case common.IntegrityIncidentMissingSettlement:
+		return fmt.Sprintf("%s invoice %d is settled but has no transaction entries", f.InvoiceType, f.InvoiceID)
+	case common.IntegrityIncidentUnbalancedInvoice:
+		return fmt.Sprintf("%s invoice %d still has %d locked in the in-flight account", f.InvoiceState, f.InvoiceID, f.LedgerBalance)
+	case common.IntegrityIncidentOrphanedEntry:
+		return fmt.Sprintf("entry %d references an invoice or account that does not exist", f.TransactionEntryID)
+	}
+	return ""
+}
+
+// FindIntegrityViolations looks for invoices settled more than once, entries between unexpected accounts, orphaned fee entries,
+// account balances that do not match the sum of their entries, settled invoices without entries, finished payments
+// with funds left in-flight and entries referencing missing invoices or accounts
+func (svc *LndhubService) FindIntegrityViolations(ctx context.Context) ([]IntegrityFinding, error) {
+	return svc.findIntegrityViolations(ctx, 0)
+}
+
+// findIntegrityViolations runs the checks, the balances are only compared for the accounts with entries after the entry id
+// Summing the entries of all accounts is expensive, an entry id of 0 checks all accounts
+func (svc *LndhubService) findIntegrityViolations(ctx context.Context, afterEntryId int64) ([]IntegrityFinding, error) {
+	findings := []IntegrityFinding{}
+
+	duplicateSettlements := []IntegrityFinding{}
+	err := svc.DB.NewSelect().
+		TableExpr("transaction_entries AS entry").
+		Join("JOIN accounts AS debit_account ON debit_account.id = entry.debit_account_id").
+		ColumnExpr("? AS kind", common.IntegrityIncidentDuplicateSettlement).
+		ColumnExpr("MIN(entry.user_id) AS user_id").
+		ColumnExpr("entry.invoice_id").
+		ColumnExpr("MAX(entry.id) AS transaction_entry_id").
+		ColumnExpr("COUNT(*) AS entry_count").
+		Where("debit_account.type = ?", common.AccountTypeIncoming).
+		GroupExpr("entry.invoice_id").
+		Having("COUNT(*) > 1").
+		Scan(ctx, &duplicateSettlements)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, duplicateSettlements...)
+
+	// Valid entries: settlement of incoming invoices, debit, settlement and revert of outgoing payments, their fees paid from the
+	// current account or the fee reserve, service fees, swap costs and balance adjustments
+	wrongAccountTypes := []IntegrityFinding{}
+	err = svc.DB.NewSelect().
+		TableExpr("transaction_entries AS entry").
+		Join("JOIN accounts AS debit_account ON debit_account.id = entry.debit_account_id").
+		Join("JOIN accounts AS credit_account ON credit_account.id = entry.credit_account_id").
+		Join("JOIN invoices AS invoice ON invoice.id = entry.invoice_id").
+		ColumnExpr("? AS kind", common.IntegrityIncidentWrongAccountType).
+		ColumnExpr("entry.user_id, entry.invoice_id, entry.id AS transaction_entry_id").
+		ColumnExpr("debit_account.type AS debit_account_type, credit_account.type AS credit_account_type, invoice.type AS invoice_type").
+		Where(`NOT (
+			debit_account.user_id = entry.user_id AND credit_account.user_id = entry.user_id AND invoice.user_id = entry.user_id AND (
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?) OR
+				(debit_account.type = ? AND credit_account.type = ? AND invoice.type = ?)))`,
+			common.AccountTypeIncoming, common.AccountTypeCurrent, common.InvoiceTypeIncoming,
+			common.AccountTypeCurrent, common.AccountTypeOutgoing, common.InvoiceTypeOutgoing,
+			common.AccountTypeOutgoing, common.AccountTypeCurrent, common.InvoiceTypeOutgoing,
+			common.AccountTypeCurrent, common.AccountTypeInFlight, common.InvoiceTypeOutgoing,
+			common.AccountTypeInFlight, common.AccountTypeCurrent, common.InvoiceTypeOutgoing,
+			common.AccountTypeInFlight, common.AccountTypeOutgoing, common.InvoiceTypeOutgoing,
+			common.AccountTypeCurrent, common.AccountTypeFees, common.InvoiceTypeOutgoing,
+			common.AccountTypeInFlight, common.AccountTypeFees, common.InvoiceTypeOutgoing,
+			common.AccountTypeCurrent, common.AccountTypeServiceFees,
+			common.AccountTypeServiceFees, common.AccountTypeCurrent, common.InvoiceTypeOutgoing,
+			common.AccountTypeIncoming, common.AccountTypeSwapCosts, common.InvoiceTypeSwapCost,
+			common.AccountTypeAdjustments, common.AccountTypeCurrent, common.InvoiceTypeIncoming,
+			common.AccountTypeCurrent, common.AccountTypeAdjustments, common.InvoiceTypeOutgoing).
+		Scan(ctx, &wrongAccountTypes)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, wrongAccountTypes...)
+
+	// Fees are only booked for settled outgoing payments and reference the entry debiting the payment amount
+	orphanedFees := []IntegrityFinding{}
+	err = svc.DB.NewSelect().
+		TableExpr("transaction_entries AS entry").
+		Join("JOIN accounts AS credit_account ON credit_account.id = entry.credit_account_id").
+		Join("JOIN invoices AS invoice ON invoice.id = entry.invoice_id").
+		Join("LEFT JOIN transaction_entries AS parent ON parent.id = entry.parent_id").
+		ColumnExpr("? AS kind", common.IntegrityIncidentOrphanedFee).
+		ColumnExpr("entry.user_id, entry.invoice_id, entry.id AS transaction_entry_id").
+		ColumnExpr("invoice.state AS invoice_state").
+		Where("credit_account.type = ?", common.AccountTypeFees).
+		Where("parent.id IS NULL OR parent.invoice_id <> entry.invoice_id OR invoice.state <> ?", common.InvoiceStateSettled).
+		Scan(ctx, &orphanedFees)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, orphanedFees...)
+
+	// account_balances is maintained by a trigger on transaction_entries, both are read from the same snapshot
+	balanceMismatches := []IntegrityFinding{}
+	balanceQuery := svc.DB.NewSelect().
+		TableExpr("accounts AS account").
+		Join("LEFT JOIN account_ledgers AS ledger ON ledger.account_id = account.id").
+		Join("LEFT JOIN account_balances AS account_balance ON account_balance.account_id = account.id").
+		ColumnExpr("? AS kind", common.IntegrityIncidentBalanceMismatch).
+		ColumnExpr("account.user_id, account.id AS account_id").
+		ColumnExpr("COALESCE(MAX(ledger.transaction_entry_id), 0) AS transaction_entry_id").
+		ColumnExpr("COALESCE(account_balance.balance, 0) AS stored_balance").
+		ColumnExpr("COALESCE(SUM(ledger.amount), 0) AS ledger_balance").
+		GroupExpr("account.id, account_balance.balance").
+		Having("COALESCE(account_balance.balance, 0) <> COALESCE(SUM(ledger.amount), 0)")
+	if afterEntryId > 0 {
+		balanceQuery = balanceQuery.Where("account.id IN (SELECT debit_account_id FROM transaction_entries WHERE id > ?) OR account.id IN (SELECT credit_account_id FROM transaction_entries WHERE id > ?)", afterEntryId, afterEntryId)
+	}
+	err = balanceQuery.Scan(ctx, &balanceMismatches)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, balanceMismatches...)
+
+	// Settled invoices are credited or debited, zero amount invoices have nothing to book
+	missingSettlements := []IntegrityFinding{}
+	err = svc.DB.NewSelect().
+		TableExpr("invoices AS invoice").
+		ColumnExpr("? AS kind", common.IntegrityIncidentMissingSettlement).
+		ColumnExpr("invoice.user_id, invoice.id AS invoice_id, invoice.type AS invoice_type").
+		Where("invoice.state = ? AND invoice.type IN (?, ?) AND invoice.amount > 0", common.InvoiceStateSettled, common.InvoiceTypeIncoming, common.InvoiceTypeOutgoing).
+		Where("NOT EXISTS (SELECT 1 FROM transaction_entries AS entry WHERE entry.invoice_id = invoice.id)").
+		Scan(ctx, &missingSettlements)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, missingSettlements...)
+
+	// The in-flight debit of a payment is moved to the outgoing account or reverted once the payment settled or failed
+	unbalancedInvoices := []IntegrityFinding{}
+	err = svc.DB.NewSelect().
+		TableExpr("account_ledgers AS ledger").
+		Join("JOIN accounts AS account ON account.id = ledger.account_id").
+		Join("JOIN transaction_entries AS entry ON entry.id = ledger.transaction_entry_id").
+		Join("JOIN invoices AS invoice ON invoice.id = entry.invoice_id").
+		ColumnExpr("? AS kind", common.IntegrityIncidentUnbalancedInvoice).
+		ColumnExpr("invoice.user_id, invoice.id AS invoice_id, invoice.state AS invoice_state").
+		ColumnExpr("MAX(entry.id) AS transaction_entry_id").
+		ColumnExpr("SUM(ledger.amount) AS ledger_balance").
+		Where("account.type = ?", common.AccountTypeInFlight).
+		Where("invoice.state IN (?, ?)", common.InvoiceStateSettled, common.InvoiceStateError).
+		GroupExpr("invoice.user_id, invoice.id, invoice.state").
+		Having("SUM(ledger.amount) <> 0").
+		Scan(ctx, &unbalancedInvoices)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, unbalancedInvoices...)
+
+	// Foreign keys prevent these on PostgreSQL, other databases do not enforce them
+	orphanedEntries := []IntegrityFinding{}
+	err = svc.DB.NewSelect().
+		TableExpr("transaction_entries AS entry").
+		Join("LEFT JOIN invoices AS invoice ON invoice.id = entry.invoice_id").
+		Join("LEFT JOIN accounts AS debit_account ON debit_account.id = entry.debit_account_id").
+		Join("LEFT JOIN accounts AS credit_account ON credit_account.id = entry.credit_account_id").
+		ColumnExpr("? AS kind", common.IntegrityIncidentOrphanedEntry).
+		ColumnExpr("entry.user_id, entry.invoice_id, entry.id AS transaction_entry_id").
+		Where("invoice.id IS NULL OR debit_account.id IS NULL OR credit_account.id IS NULL").
+		Scan(ctx, &orphanedEntries)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, orphanedEntries...)
+
+	return findings, nil
+}
+
+// observeIntegrityFindings exports the number of findings of a check by kind
+func observeIntegrityFindings(findings []IntegrityFinding) {
+	counts := map[string]int64{}
+	for _, finding := range findings {
+		counts[finding.Kind]++
+	}
+	for _, kind := range integrityIncidentKinds {
+		count := new(expvar.Int)
+		count.Set(counts[kind])
+		integrityFindingMetrics.Set(kind, count)
+	}
+}
+
+// ReportIntegrityIncidents records new findings as incidents, alerts the operator
+// and freezes the affected users if INTEGRITY_AUTO_FREEZE is enabled
+func (svc *LndhubService) ReportIntegrityIncidents(ctx context.Context, findings []IntegrityFinding) ([]models.IntegrityIncident, error) {
+	incidents := []models.IntegrityIncident{}
+	for i := range findings {
+		finding := &findings[i]
+		incident := models.IntegrityIncident{
+			Kind:               finding.Kind,
+			Fingerprint:        finding.fingerprint(),
+			UserID:             finding.UserID,
+			InvoiceID:          finding.InvoiceID,
+			TransactionEntryID: finding.TransactionEntryID,
+			Details:            finding.details(),
+			UserFrozen:         svc.Config.IntegrityAutoFreeze && finding.UserID > 0,
+		}
+		res, err := svc.DB.NewInsert().Model(&incident).Ignore().Exec(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			// already reported
+			continue
+		}
+
+		integrityIncidentMetrics.Add(incident.Kind, 1)
+		svc.Logger.Errorf("Integrity incident kind:%s user_id:%v invoice_id:%v entry_id:%v %s", incident.Kind, incident.UserID, incident.InvoiceID, incident.TransactionEntryID, incident.Details)
+		sentry.WithScope(func(scope *sentry.Scope) {
+			scope.SetTag("integrity_incident", incident.Kind)
+			scope.SetExtra("user_id", incident.UserID)
+			scope.SetExtra("invoice_id", incident.InvoiceID)
+			scope.SetExtra("transaction_entry_id", incident.TransactionEntryID)
+			sentry.CaptureMessage(fmt.Sprintf("Integrity incident: %s", incident.Details))
+		})
+		if incident.UserFrozen {
+			if err := svc.FreezeUser(ctx, incident.UserID, fmt.Sprintf("integrity incident %s", incident.Fingerprint)); err != nil {
+				svc.Logger.Errorf("Could not freeze user user_id:%v: %v", incident.UserID, err)
+			}
+		}
+		incidents = append(incidents, incident)
+	}
+	return incidents, nil
+}
+
+// CheckIntegrity runs all integrity checks and reports new incidents
+func (svc *LndhubService) CheckIntegrity(ctx context.Context) ([]models.IntegrityIncident, error) {
+	return svc.checkIntegrity(ctx, 0)
+}
+
+// checkIntegrity runs the checks with the balances of the accounts with entries after the entry id and reports new incidents
+func (svc *LndhubService) checkIntegrity(ctx context.Context, afterEntryId int64) ([]models.IntegrityIncident, error) {
+	findings, err := svc.findIntegrityViolations(ctx, afterEntryId)
+	if err != nil {
+		return nil, err
+	}
+	observeIntegrityFindings(findings)
+	return svc.ReportIntegrityIncidents(ctx, findings)
+}
+
+// lastTransactionEntryID returns the id of the latest transaction entry, 0 if there are none
+func (svc *LndhubService) lastTransactionEntryID(ctx context.Context) (int64, error) {
+	var id int64
+	err := svc.DB.NewSelect().Model((*models.TransactionEntry)(nil)).ColumnExpr("COALESCE(MAX(id), 0)").Scan(ctx, &id)
+	return id, err
+}
+
+// StartIntegrityMonitor periodically checks the ledger integrity until the context is canceled
+// The first check compares the balances of all accounts, later checks only the accounts with new entries
+func (svc *LndhubService) StartIntegrityMonitor(ctx context.Context) {
+	if svc.Config.IntegrityCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(svc.Config.IntegrityCheckInterval) * time.Second)
+	defer ticker.Stop()
+	afterEntryId := int64(0)
+	for {
+		// entries booked while the check runs are checked again the next time
+		lastEntryId, err := svc.lastTransactionEntryID(ctx)
+		if err == nil {
+			_, err = svc.checkIntegrity(ctx, afterEntryId)
+		}
+		if err != nil {
+			svc.Logger.Errorf("Error checking ledger integrity: %v", err)
+			sentry.CaptureException(err)
+		} else {
+			afterEntryId = lastEntryId
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (svc *LndhubService) IntegrityIncidents(ctx context.Context) ([]models.IntegrityIncident, error) {
+	incidents := []models.IntegrityIncident{}
+	err := svc.DB.NewSelect().Model(&incidents).OrderExpr("id DESC").Limit(100).Scan(ctx)
+	return incidents, err
+}
+
+// FreezeUser blocks authentication, payments, keysend and invoice creation of the user
+// The user's data is kept and the user can be unfrozen again
+func (svc *LndhubService) FreezeUser(ctx context.Context, userId int64, reason string) error {
+	_, err := svc.DB.NewUpdate().Model((*models.User)(nil)).
+		Set("frozen_at = current_timestamp").
+		Set("updated_at = current_timestamp").
+		Where("id = ? AND frozen_at IS NULL", userId).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.AddAuditLog(ctx, AuditActionFreezeUser, userId, 0, reason)
+}
+
+// UnfreezeUser lifts the freeze of the user, deleted users stay frozen
+func (svc *LndhubService) UnfreezeUser(ctx context.Context, userId int64, reason string) error {
+	_, err := svc.DB.NewUpdate().Model((*models.User)(nil)).
+		Set("frozen_at = NULL").
+		Set("updated_at = current_timestamp").
+		Where("id = ? AND frozen_at IS NOT NULL AND deleted_at IS NULL", userId).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.AddAuditLog(ctx, AuditActionUnfreezeUser, userId, 0, reason)
+}
+
+func (svc *LndhubService) CheckUserNotFrozen(ctx context.Context, userId int64) error {
+	frozen, err := svc.DB.NewSelect().Model((*models.User)(nil)).Where("id = ? AND frozen_at IS NOT NULL", userId).Exists(ctx)
+	if err != nil {
+		return err
+	}
+	if frozen {
+		return ErrAccountFrozen
+	}
+	return nil
+}
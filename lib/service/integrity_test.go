@@ -0,0 +1,56 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalanceMismatchFinding(t *testing.T) {
+	finding := IntegrityFinding{
+		Kind:               common.IntegrityIncidentBalanceMismatch,
+		UserID:             1,
+		AccountID:          4,
+		TransactionEntryID: 12,
+		StoredBalance:      1000,
+		LedgerBalance:      900,
+	}
+	assert.Equal(t, "balance_mismatch:4:100", finding.fingerprint())
+	assert.Equal(t, "account 4 has a stored balance of 1000 but its entries sum up to 900", finding.details())
+
+	// new entries of the account do not report the same mismatch again, a different mismatch is reported
+	later := finding
+	later.TransactionEntryID = 13
+	later.StoredBalance, later.LedgerBalance = 1100, 1000
+	assert.Equal(t, finding.fingerprint(), later.fingerprint())
+	later.LedgerBalance = 1050
+	assert.NotEqual(t, finding.fingerprint(), later.fingerprint())
+
+	// the debit and credit account of the same entry are reported separately
+	other := finding
+	other.AccountID = 5
+	assert.NotEqual(t, finding.fingerprint(), other.fingerprint())
+}
+
+func TestUnbalancedInvoiceFinding(t *testing.T) {
+	finding := IntegrityFinding{
+		Kind:               common.IntegrityIncidentUnbalancedInvoice,
+		UserID:             1,
+		InvoiceID:          7,
+		TransactionEntryID: 21,
+		InvoiceState:       common.InvoiceStateError,
+		LedgerBalance:      1010,
+	}
+	assert.Equal(t, "unbalanced_invoice:7:21", finding.fingerprint())
+	assert.Equal(t, "error invoice 7 still has 1010 locked in the in-flight account", finding.details())
+}
+
+func TestObserveIntegrityFindings(t *testing.T) {
+	observeIntegrityFindings([]IntegrityFinding{
+		{Kind: common.IntegrityIncidentOrphanedEntry, TransactionEntryID: 3},
+		{Kind: common.IntegrityIncidentOrphanedEntry, TransactionEntryID: 4},
+	})
+	assert.Equal(t, "2", integrityFindingMetrics.Get(common.IntegrityIncidentOrphanedEntry).String())
+	assert.Equal(t, "0", integrityFindingMetrics.Get(common.IntegrityIncidentMissingSettlement).String())
+}
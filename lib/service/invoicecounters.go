@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+)
+
+const failedPaymentsWindow = 24 * time.Hour
+
+// InvoiceCounts are the badge counts of a user
+// Cursor is the user's settled invoice count, sent back as ?cursor= to count the settlements since
+type InvoiceCounts struct {
+	OpenInvoices          int64 `json:"open_invoices"`
+	UnreadSettledInvoices int64 `json:"unread_settled_invoices"`
+	FailedPayments24h     int64 `json:"failed_payments_24h"`
+	Cursor                int64 `json:"cursor"`
+}
+
+// unreadSettledInvoices counts the settlements since the cursor, all settlements without a cursor
+func unreadSettledInvoices(settledInvoices, cursor int64) int64 {
+	if cursor > settledInvoices {
+		return 0
+	}
+	return settledInvoices - cursor
+}
+
+// addInvoiceCounts adds the deltas to the user's counters, creating them on first use
+func addInvoiceCounts(ctx context.Context, db bun.IDB, userId, openInvoices, settledInvoices int64) error {
+	counter := models.InvoiceCounter{UserID: userId, OpenInvoices: openInvoices, SettledInvoices: settledInvoices}
+	_, err := onConflictUpdate(db, db.NewInsert().Model(&counter), "user_id").
+		Set("open_invoices = GREATEST(" + existing(db, "invoice_counter", "open_invoices") + " + " + excluded(db, "open_invoices") + ", 0)").
+		Set("settled_invoices = " + existing(db, "invoice_counter", "settled_invoices") + " + " + excluded(db, "settled_invoices")).
+		Set("updated_at = current_timestamp").
+		Exec(ctx)
+	return err
+}
+
+// countOpenInvoice counts an invoice that was just opened
+func (svc *LndhubService) countOpenInvoice(ctx context.Context, invoice *models.Invoice) error {
+	res, err := svc.DB.NewUpdate().Model((*models.Invoice)(nil)).
+		Set("open_counted = true").
+		Where("id = ? AND NOT open_counted", invoice.ID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil
+	}
+	return addInvoiceCounts(ctx, svc.DB, invoice.UserID, 1, 0)
+}
+
+// countClosedInvoice removes a settled or expired invoice from the open count and counts settlements
+// An invoice settled after it expired was already removed from the open count
+func (svc *LndhubService) countClosedInvoice(ctx context.Context, invoice *models.Invoice) error {
+	res, err := svc.DB.NewUpdate().Model((*models.Invoice)(nil)).
+		Set("open_counted = false").
+		Where("id = ? AND open_counted", invoice.ID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	var opened, settled int64
+	if rows, _ := res.RowsAffected(); rows == 1 {
+		opened = -1
+	}
+	if invoice.State == common.InvoiceStateSettled {
+		settled = 1
+	}
+	if opened == 0 && settled == 0 {
+		return nil
+	}
+	return addInvoiceCounts(ctx, svc.DB, invoice.UserID, opened, settled)
+}
+
+// countExpiredInvoices removes the invoices that expired since the last call from the open counts, of all users for user id 0
+// Expiry is no event of its own, so it is applied when the counts are read and before expired invoices are purged
+func (svc *LndhubService) countExpiredInvoices(ctx context.Context, userId int64) error {
+	// MySQL can not return the rows of an update, the expired invoices are selected and locked first
+	return svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		expiredInvoices := []models.Invoice{}
+		query := tx.NewSelect().Model(&expiredInvoices).Column("id", "user_id").
+			Where("open_counted AND expires_at <= current_timestamp")
+		if userId != 0 {
+			query = query.Where("user_id = ?", userId)
+		}
+		if err := forUpdate(tx, query).Scan(ctx); err != nil || len(expiredInvoices) == 0 {
+			return err
+		}
+		ids := make([]int64, len(expiredInvoices))
+		expired := map[int64]int64{}
+		for i, invoice := range expiredInvoices {
+			ids[i] = invoice.ID
+			expired[invoice.UserID]++
+		}
+		_, err := tx.NewUpdate().Model((*models.Invoice)(nil)).
+			Set("open_counted = false").
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		for id, count := range expired {
+			if err := addInvoiceCounts(ctx, tx, id, -count, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// countFailedPayment adds a failed payment to the user's hourly count and drops the counts outside the window
+func (svc *LndhubService) countFailedPayment(ctx context.Context, userId int64) error {
+	now := time.Now()
+	counter := models.PaymentFailureCounter{UserID: userId, Hour: now.Truncate(time.Hour), Failures: 1}
+	_, err := onConflictUpdate(svc.DB, svc.DB.NewInsert().Model(&counter), "user_id, hour").
+		Set("failures = " + existing(svc.DB, "payment_failure_counter", "failures") + " + 1").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = svc.DB.NewDelete().Model((*models.PaymentFailureCounter)(nil)).
+		Where("user_id = ? AND hour <= ?", userId, now.Add(-failedPaymentsWindow).Truncate(time.Hour)).
+		Exec(ctx)
+	return err
+}
+
+// InvoiceCounts returns the user's open invoices, settlements since the cursor and failed payments of the last 24 hours
+// The failed payments are counted in hourly buckets, the oldest bucket may start up to an hour before the window
+func (svc *LndhubService) InvoiceCounts(ctx context.Context, userId, cursor int64) (*InvoiceCounts, error) {
+	if err := svc.countExpiredInvoices(ctx, userId); err != nil {
+		return nil, err
+	}
+	counter := models.InvoiceCounter{}
+	err := svc.DB.NewSelect().Model(&counter).Where("user_id = ?", userId).Limit(1).Scan(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	counts := InvoiceCounts{
+		OpenInvoices:          counter.OpenInvoices,
+		UnreadSettledInvoices: unreadSettledInvoices(counter.SettledInvoices, cursor),
+		Cursor:                counter.SettledInvoices,
+	}
+	err = svc.DB.NewSelect().Model((*models.PaymentFailureCounter)(nil)).
+		ColumnExpr("COALESCE(SUM(failures), 0)").
+		Where("user_id = ? AND hour > ?", userId, time.Now().Add(-failedPaymentsWindow).Truncate(time.Hour)).
+		Scan(ctx, &counts.FailedPayments24h)
+	if err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
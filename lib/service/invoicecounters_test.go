@@ -0,0 +1,15 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnreadSettledInvoices(t *testing.T) {
+	assert.Equal(t, int64(5), unreadSettledInvoices(5, 0))
+	assert.Equal(t, int64(2), unreadSettledInvoices(5, 3))
+	assert.Equal(t, int64(0), unreadSettledInvoices(5, 5))
+	// a cursor of another account or a reset counter
+	assert.Equal(t, int64(0), unreadSettledInvoices(5, 8))
+}
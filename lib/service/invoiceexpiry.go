@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/uptrace/bun"
+)
+
+// ExpireInvoices marks the open incoming invoices past their expiry as expired
+// It returns the number of invoices that expired
+func (svc *LndhubService) ExpireInvoices(ctx context.Context) (int, error) {
+	invoices, err := svc.expireOpenInvoices(ctx, "expires_at <= ?", time.Now())
+	if err != nil {
+		return 0, err
+	}
+	for i := range invoices {
+		svc.onInvoiceExpired(ctx, &invoices[i])
+	}
+	return len(invoices), nil
+}
+
+// expireCanceledInvoice marks an open incoming invoice the node canceled as expired
+// The node cancels invoices once they expire, so this usually runs right before ExpireInvoices would
+func (svc *LndhubService) expireCanceledInvoice(ctx context.Context, rHash string) error {
+	invoices, err := svc.expireOpenInvoices(ctx, "r_hash = ?", rHash)
+	if err != nil {
+		return err
+	}
+	for i := range invoices {
+		svc.Logger.Infof("Invoice canceled by the node, marked as expired invoice_id:%v", invoices[i].ID)
+		svc.onInvoiceExpired(ctx, &invoices[i])
+	}
+	return nil
+}
+
+// expireOpenInvoices marks the open incoming invoices that match the condition as expired and returns them
+// MySQL can not return the rows of an update, the invoices are selected and locked first
+func (svc *LndhubService) expireOpenInvoices(ctx context.Context, condition string, args ...interface{}) ([]models.Invoice, error) {
+	invoices := []models.Invoice{}
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		q := tx.NewSelect().Model(&invoices).
+			Where("type = ? AND state = ?", common.InvoiceTypeIncoming, common.InvoiceStateOpen).
+			Where(condition, args...)
+		if err := forUpdate(tx, q).Scan(ctx); err != nil || len(invoices) == 0 {
+			return err
+		}
+		ids := make([]int64, len(invoices))
+		for i := range invoices {
+			ids[i] = invoices[i].ID
+			invoices[i].State = common.InvoiceStateExpired
+		}
+		_, err := tx.NewUpdate().Model((*models.Invoice)(nil)).
+			Set("state = ?", common.InvoiceStateExpired).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// onInvoiceExpired updates the user's counters and emits the invoice.expired event
+// Subscribers that are not listening right now miss the event, it must not block the expiry of other invoices
+func (svc *LndhubService) onInvoiceExpired(ctx context.Context, invoice *models.Invoice) {
+	if err := svc.countClosedInvoice(ctx, invoice); err != nil {
+		svc.Logger.Errorf("Could not count expired invoice invoice_id:%v %v", invoice.ID, err)
+	}
+	if sub, ok := svc.InvoiceSubscribers[invoice.UserID]; ok {
+		select {
+		case sub <- *invoice:
+		default:
+		}
+	}
+	svc.EnqueueInvoiceWebhook(ctx, invoice.WebhookUrl, common.WebhookEventInvoiceExpired, invoice)
+	svc.EnqueueInvoiceWebhook(ctx, svc.Config.WebhookUrl, common.WebhookEventInvoiceExpired, invoice)
+}
+
+// StartInvoiceExpiryWorker expires invoices every INVOICE_EXPIRY_INTERVAL
+func (svc *LndhubService) StartInvoiceExpiryWorker(ctx context.Context) {
+	if svc.Config.InvoiceExpiryInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(svc.Config.InvoiceExpiryInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		expired, err := svc.ExpireInvoices(ctx)
+		if err != nil {
+			svc.Logger.Errorf("Error expiring invoices: %v", err)
+			sentry.CaptureException(err)
+		}
+		if expired > 0 {
+			svc.Logger.Infof("Expired %v invoices", expired)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
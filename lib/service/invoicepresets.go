@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+
+	"github.com/getAlby/lndhub.go/db/models"
+)
+
+// SaveInvoicePreset creates a named preset or updates the existing one with the same name
+func (svc *LndhubService) SaveInvoicePreset(ctx context.Context, preset *models.InvoicePreset) (*models.InvoicePreset, error) {
+	if _, err := svc.InvoiceExpiry(preset.Expiry); err != nil {
+		return nil, err
+	}
+	_, err := onConflictUpdate(svc.DB, svc.DB.NewInsert().Model(preset), "user_id, name").
+		Set("amount = " + excluded(svc.DB, "amount")).
+		Set("memo = " + excluded(svc.DB, "memo")).
+		Set("expiry = " + excluded(svc.DB, "expiry")).
+		Set("metadata = " + excluded(svc.DB, "metadata")).
+		Set("updated_at = current_timestamp").
+		Returning("*").
+		Exec(ctx)
+	if err == nil && isMySQL(svc.DB) {
+		// MySQL returns no row for an upsert that updated the existing row
+		err = svc.DB.NewSelect().Model(preset).Where("user_id = ? AND name = ?", preset.UserID, preset.Name).Limit(1).Scan(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return preset, nil
+}
+
+func (svc *LndhubService) FindInvoicePreset(ctx context.Context, userId, presetId int64) (*models.InvoicePreset, error) {
+	var preset models.InvoicePreset
+	err := svc.DB.NewSelect().Model(&preset).Where("id = ? AND user_id = ?", presetId, userId).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+func (svc *LndhubService) InvoicePresetsFor(ctx context.Context, userId int64) ([]models.InvoicePreset, error) {
+	presets := []models.InvoicePreset{}
+	err := svc.DB.NewSelect().Model(&presets).Where("user_id = ?", userId).OrderExpr("name ASC").Scan(ctx)
+	return presets, err
+}
+
+func (svc *LndhubService) DeleteInvoicePreset(ctx context.Context, userId, presetId int64) error {
+	_, err := svc.DB.NewDelete().Model((*models.InvoicePreset)(nil)).Where("id = ? AND user_id = ?", presetId, userId).Exec(ctx)
+	return err
+}
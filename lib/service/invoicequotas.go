@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+)
+
+const (
+	InvoiceQuotaOpenInvoices = "open_invoices"
+	InvoiceQuotaHourly       = "hourly"
+
+	// buckets of users that did not create invoices for a while are dropped once this many are tracked
+	invoiceBucketPruneThreshold = 10000
+)
+
+// InvoiceQuotaExceededError is returned if a user has too many open invoices or creates invoices too fast
+type InvoiceQuotaExceededError struct {
+	Quota      string
+	Limit      int64
+	RetryAfter time.Duration
+}
+
+func (e *InvoiceQuotaExceededError) Error() string {
+	return fmt.Sprintf("%s invoice quota of %v exceeded, retry after %v", e.Quota, e.Limit, e.RetryAfter)
+}
+
+// tokenBucket allows bursts up to its capacity and refills continuously
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// take removes one token if available, otherwise it returns the time until the next token is available
+func (b *tokenBucket) take(now time.Time, capacity int64, refillPerSecond float64) (bool, time.Duration) {
+	b.refill(now, capacity, refillPerSecond)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration(math.Ceil((1 - b.tokens) / refillPerSecond * float64(time.Second)))
+}
+
+func (b *tokenBucket) refill(now time.Time, capacity int64, refillPerSecond float64) {
+	b.tokens = math.Min(float64(capacity), b.tokens+now.Sub(b.updatedAt).Seconds()*refillPerSecond)
+	b.updatedAt = now
+}
+
+type invoiceQuotaState struct {
+	mu      sync.Mutex
+	buckets map[int64]*tokenBucket
+}
+
+// takeInvoiceToken enforces INVOICE_CREATION_PER_HOUR with a token bucket per user
+// Users can create the full hourly quota at once, afterwards the tokens refill evenly over the hour
+// The buckets are kept in memory, a restart grants every user the full quota again
+func (svc *LndhubService) takeInvoiceToken(userId int64, now time.Time) error {
+	capacity := svc.Config.InvoiceCreationPerHour
+	refillPerSecond := float64(capacity) / time.Hour.Seconds()
+
+	svc.invoiceQuotas.mu.Lock()
+	defer svc.invoiceQuotas.mu.Unlock()
+	if svc.invoiceQuotas.buckets == nil {
+		svc.invoiceQuotas.buckets = map[int64]*tokenBucket{}
+	}
+	if len(svc.invoiceQuotas.buckets) >= invoiceBucketPruneThreshold {
+		for id, bucket := range svc.invoiceQuotas.buckets {
+			if bucket.refill(now, capacity, refillPerSecond); bucket.tokens >= float64(capacity) {
+				delete(svc.invoiceQuotas.buckets, id)
+			}
+		}
+	}
+	bucket, ok := svc.invoiceQuotas.buckets[userId]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(capacity), updatedAt: now}
+		svc.invoiceQuotas.buckets[userId] = bucket
+	}
+	if ok, retryAfter := bucket.take(now, capacity, refillPerSecond); !ok {
+		return &InvoiceQuotaExceededError{Quota: InvoiceQuotaHourly, Limit: capacity, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// CheckInvoiceQuotas returns an InvoiceQuotaExceededError if the user may not create another invoice
+// MAX_OPEN_INVOICES, or the max_open_invoices of the user's tier, limits the unpaid, unexpired invoices
+// and INVOICE_CREATION_PER_HOUR the creation rate
+func (svc *LndhubService) CheckInvoiceQuotas(ctx context.Context, userId int64) error {
+	now := time.Now()
+	maxOpenInvoices := svc.Config.MaxOpenInvoices
+	if len(svc.Config.AccountTiers) > 0 {
+		tier, err := svc.UserTierSettings(ctx, userId)
+		if err != nil {
+			return err
+		}
+		maxOpenInvoices = tier.MaxOpenInvoices
+	}
+	if maxOpenInvoices > 0 {
+		openInvoices, err := svc.DB.NewSelect().Model((*models.Invoice)(nil)).
+			Where("user_id = ? AND type = ? AND state IN (?, ?) AND expires_at > ?",
+				userId, common.InvoiceTypeIncoming, common.InvoiceStateInitialized, common.InvoiceStateOpen, now).
+			Count(ctx)
+		if err != nil {
+			return err
+		}
+		if int64(openInvoices) >= maxOpenInvoices {
+			// the earliest open invoice to expire frees up the quota
+			var nextExpiry time.Time
+			err = svc.DB.NewSelect().Model((*models.Invoice)(nil)).ColumnExpr("MIN(expires_at)").
+				Where("user_id = ? AND type = ? AND state IN (?, ?) AND expires_at > ?",
+					userId, common.InvoiceTypeIncoming, common.InvoiceStateInitialized, common.InvoiceStateOpen, now).
+				Scan(ctx, &nextExpiry)
+			if err != nil {
+				return err
+			}
+			return &InvoiceQuotaExceededError{Quota: InvoiceQuotaOpenInvoices, Limit: maxOpenInvoices, RetryAfter: nextExpiry.Sub(now).Round(time.Second)}
+		}
+	}
+	if svc.Config.InvoiceCreationPerHour > 0 {
+		return svc.takeInvoiceToken(userId, now)
+	}
+	return nil
+}
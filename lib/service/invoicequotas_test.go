@@ -0,0 +1,30 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	refillPerSecond := 60 / time.Hour.Seconds()
+	bucket := &tokenBucket{tokens: 2, updatedAt: now}
+
+	ok, _ := bucket.take(now, 2, refillPerSecond)
+	assert.True(t, ok)
+	ok, _ = bucket.take(now, 2, refillPerSecond)
+	assert.True(t, ok)
+	ok, retryAfter := bucket.take(now, 2, refillPerSecond)
+	assert.False(t, ok)
+	assert.Equal(t, time.Minute, retryAfter)
+
+	// one token per minute is refilled
+	ok, _ = bucket.take(now.Add(time.Minute), 2, refillPerSecond)
+	assert.True(t, ok)
+
+	// the bucket never holds more than its capacity
+	bucket.refill(now.Add(24*time.Hour), 2, refillPerSecond)
+	assert.Equal(t, float64(2), bucket.tokens)
+}
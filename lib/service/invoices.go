@@ -2,19 +2,22 @@ package service
 
 import (
 	"context"
-	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/getAlby/lndhub.go/common"
 	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/preimage"
+	"github.com/getAlby/lndhub.go/lib/tokens"
 	"github.com/getAlby/lndhub.go/lnd"
 	"github.com/getsentry/sentry-go"
-	"github.com/labstack/gommon/random"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/schema"
 )
@@ -22,6 +25,8 @@ import (
 type Route struct {
 	TotalAmt  int64 `json:"total_amt"`
 	TotalFees int64 `json:"total_fees"`
+	// the exact routing fee, total_fees is rounded down to a full satoshi
+	TotalFeesMsat int64 `json:"-"`
 }
 
 type SendPaymentResponse struct {
@@ -35,26 +40,101 @@ type SendPaymentResponse struct {
 	Invoice            *models.Invoice
 }
 
+// FindInvoiceByPaymentHash looks the invoice up in invoices first and in archived_invoices if it is not found there
+// Archived invoices expired unpaid, they are returned as expired
 func (svc *LndhubService) FindInvoiceByPaymentHash(ctx context.Context, userId int64, rHash string) (*models.Invoice, error) {
 	var invoice models.Invoice
 
 	err := svc.DB.NewSelect().Model(&invoice).Where("invoice.user_id = ? AND invoice.r_hash = ?", userId, rHash).Limit(1).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		var archived models.ArchivedInvoice
+		archivedErr := svc.DB.NewSelect().Model(&archived).Where("user_id = ? AND r_hash = ?", userId, rHash).Limit(1).Scan(ctx)
+		if archivedErr == nil {
+			archived.Invoice.State = common.InvoiceStateExpired
+			return &archived.Invoice, nil
+		}
+	}
 	if err != nil {
 		return &invoice, err
 	}
 	return &invoice, nil
 }
 
+var ErrInvoiceNotCancelable = errors.New("invoice not found or not open")
+var ErrInvoiceNotOpen = errors.New("invoice is not open anymore")
+
+// CancelInvoice cancels an open incoming invoice of the user on the node, it can not be paid anymore
+func (svc *LndhubService) CancelInvoice(ctx context.Context, userId int64, rHash string) (*models.Invoice, error) {
+	invoice, err := svc.FindInvoiceByPaymentHash(ctx, userId, strings.ToLower(rHash))
+	if err != nil || invoice.Type != common.InvoiceTypeIncoming || invoice.State != common.InvoiceStateOpen {
+		return nil, ErrInvoiceNotCancelable
+	}
+	if err := svc.cancelIncomingInvoice(ctx, invoice); err != nil {
+		return nil, err
+	}
+	svc.Logger.Infof("Invoice canceled by the user user_id:%v invoice_id:%v", userId, invoice.ID)
+	return invoice, nil
+}
+
+// cancelIncomingInvoice marks the invoice as canceled before the node cancels it
+// Otherwise the invoice subscription could receive the cancellation first and mark the invoice as expired
+func (svc *LndhubService) cancelIncomingInvoice(ctx context.Context, invoice *models.Invoice) error {
+	hash, err := hex.DecodeString(invoice.RHash)
+	if err != nil {
+		return err
+	}
+	previousState := invoice.State
+	res, err := svc.DB.NewUpdate().Model((*models.Invoice)(nil)).
+		Set("state = ?", common.InvoiceStateCanceled).
+		Where("id = ? AND state = ?", invoice.ID, previousState).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if updated, _ := res.RowsAffected(); updated == 0 {
+		// the invoice was settled or expired in the meantime
+		return ErrInvoiceNotCancelable
+	}
+	if _, err := svc.LndClient.CancelInvoice(ctx, &invoicesrpc.CancelInvoiceMsg{PaymentHash: hash}); err != nil {
+		_, revertErr := svc.DB.NewUpdate().Model((*models.Invoice)(nil)).
+			Set("state = ?", previousState).
+			Where("id = ? AND state = ?", invoice.ID, common.InvoiceStateCanceled).
+			Exec(ctx)
+		if revertErr != nil {
+			svc.Logger.Errorf("Could not revert the state of the invoice the node did not cancel invoice_id:%v %v", invoice.ID, revertErr)
+		}
+		return err
+	}
+	invoice.State = common.InvoiceStateCanceled
+	if err := svc.countClosedInvoice(ctx, invoice); err != nil {
+		svc.Logger.Errorf("Could not count canceled invoice invoice_id:%v %v", invoice.ID, err)
+	}
+	return nil
+}
+
 func (svc *LndhubService) SendInternalPayment(ctx context.Context, invoice *models.Invoice) (SendPaymentResponse, error) {
 	sendPaymentResponse := SendPaymentResponse{}
 	// find invoice
 	var incomingInvoice models.Invoice
-	err := svc.DB.NewSelect().Model(&incomingInvoice).Where("type = ? AND payment_request = ? AND state = ? ", common.InvoiceTypeIncoming, invoice.PaymentRequest, common.InvoiceStateOpen).Limit(1).Scan(ctx)
+	query := svc.DB.NewSelect().Model(&incomingInvoice).Where("type = ? AND state = ? AND expires_at > ? AND preimage IS NOT NULL", common.InvoiceTypeIncoming, common.InvoiceStateOpen, time.Now())
+	if invoice.PaymentRequest == "" {
+		// transfers have no payment request, the recipient's invoice is found by the payment hash
+		query = query.Where("r_hash = ? AND internal = ?", invoice.RHash, true)
+	} else {
+		query = query.Where("payment_request = ?", invoice.PaymentRequest)
+	}
+	err := query.Limit(1).Scan(ctx)
 	if err != nil {
-		// invoice not found or already settled
+		// invoice not found, already settled or expired, hold invoices can not be paid internally without the preimage
 		// TODO: logging
 		return sendPaymentResponse, err
 	}
+	// The payer receives the recipient's preimage as proof of payment, it has to match the payment hash
+	if err := preimage.VerifyHex(incomingInvoice.Preimage, invoice.RHash); err != nil {
+		svc.Logger.Errorf("Internal invoice preimage does not match the payment hash invoice_id:%v incoming_invoice_id:%v", invoice.ID, incomingInvoice.ID)
+		sentry.CaptureException(fmt.Errorf("%w incoming_invoice_id:%v", err, incomingInvoice.ID))
+		return sendPaymentResponse, err
+	}
 	// Get the user's current and incoming account for the transaction entry
 	recipientCreditAccount, err := svc.AccountFor(ctx, common.AccountTypeCurrent, incomingInvoice.UserID)
 	if err != nil {
@@ -64,140 +144,430 @@ func (svc *LndhubService) SendInternalPayment(ctx context.Context, invoice *mode
 	if err != nil {
 		return sendPaymentResponse, err
 	}
-	// create recipient entry
-	recipientEntry := models.TransactionEntry{
-		UserID:          incomingInvoice.UserID,
-		InvoiceID:       incomingInvoice.ID,
-		CreditAccountID: recipientCreditAccount.ID,
-		DebitAccountID:  recipientDebitAccount.ID,
-		Amount:          invoice.Amount,
+	recipientTier, err := svc.UserTierSettings(ctx, incomingInvoice.UserID)
+	if err != nil {
+		return sendPaymentResponse, err
 	}
-	_, err = svc.DB.NewInsert().Model(&recipientEntry).Exec(ctx)
+	// amount-less invoices are credited with the amount the payer sent
+	incomingInvoice.Amount = settledAmount(incomingInvoice.Amount, invoice.Amount)
+	incomingInvoice.ServiceFee = recipientTier.IncomingServiceFeeFor(incomingInvoice.Amount)
+	incomingInvoice.Internal = true // mark incoming invoice as internal, just for documentation/debugging
+	incomingInvoice.EncryptedMemo = invoice.EncryptedMemo
+	incomingInvoice.MemoKeyHint = invoice.MemoKeyHint
+	incomingInvoice.State = common.InvoiceStateSettled
+	incomingInvoice.SettledAt = schema.NullTime{Time: time.Now()}
+
+	// The recipient is credited and the invoice settled in one transaction
+	// The invoice is only settled if it is still open, an invoice paid at the same time is not credited twice
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().Model(&incomingInvoice).WherePK().Where("state = ?", common.InvoiceStateOpen).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if updated, _ := res.RowsAffected(); updated == 0 {
+			return ErrInvoiceNotOpen
+		}
+		recipientEntry := models.TransactionEntry{
+			UserID:          incomingInvoice.UserID,
+			InvoiceID:       incomingInvoice.ID,
+			CreditAccountID: recipientCreditAccount.ID,
+			DebitAccountID:  recipientDebitAccount.ID,
+			Amount:          invoice.Amount,
+		}
+		if _, err := tx.NewInsert().Model(&recipientEntry).Exec(ctx); err != nil {
+			return err
+		}
+		return svc.insertServiceFeeEntry(ctx, tx, &incomingInvoice, recipientCreditAccount.ID, recipientEntry.ID)
+	})
 	if err != nil {
+		// could not credit the recipient
 		return sendPaymentResponse, err
 	}
+	svc.onInvoiceSettled(ctx, &incomingInvoice)
 
 	// For internal invoices we know the preimage and we use that as a response
 	// This allows wallets to get the correct preimage for a payment request even though NO lightning transaction was involved
-	preimage, _ := hex.DecodeString(incomingInvoice.Preimage)
+	paymentPreimage, _ := hex.DecodeString(incomingInvoice.Preimage)
 	sendPaymentResponse.PaymentPreimageStr = incomingInvoice.Preimage
-	sendPaymentResponse.PaymentPreimage = preimage
+	sendPaymentResponse.PaymentPreimage = paymentPreimage
 	sendPaymentResponse.Invoice = invoice
 	paymentHash, _ := hex.DecodeString(invoice.RHash)
 	sendPaymentResponse.PaymentHashStr = invoice.RHash
 	sendPaymentResponse.PaymentHash = paymentHash
 	sendPaymentResponse.PaymentRoute = &Route{TotalAmt: invoice.Amount, TotalFees: 0}
 
-	incomingInvoice.Internal = true // mark incoming invoice as internal, just for documentation/debugging
-	incomingInvoice.State = common.InvoiceStateSettled
-	incomingInvoice.SettledAt = schema.NullTime{Time: time.Now()}
-	_, err = svc.DB.NewUpdate().Model(&incomingInvoice).WherePK().Exec(ctx)
-	if err != nil {
-		// could not save the invoice of the recipient
-		return sendPaymentResponse, err
-	}
-
 	return sendPaymentResponse, nil
 }
 
 func (svc *LndhubService) SendPaymentSync(ctx context.Context, invoice *models.Invoice) (SendPaymentResponse, error) {
 	sendPaymentResponse := SendPaymentResponse{}
 
-	sendPaymentRequest, err := createLnRpcSendRequest(invoice)
+	// Retry payments that failed because no route was found with an increasing fee limit
+	// Every attempt is recorded on the invoice
+	feeLimit, err := svc.feeLimitFor(invoice.Amount)
 	if err != nil {
 		return sendPaymentResponse, err
 	}
+	// the operator's channel is used unless the caller pinned another one
+	operatorChannel := invoice.OutgoingChanId == 0
+	if operatorChannel {
+		invoice.OutgoingChanId = svc.Config.PaymentOutgoingChanId
+	}
+	var sendPaymentRequest *lnrpc.SendRequest
+	var sendPaymentResult *lnrpc.SendResponse
+	for attempt := 0; attempt <= svc.Config.PaymentMaxRetries; attempt++ {
+		if attempt > 0 {
+			increasedFeeLimit, err := lib.MultiplyAmount(feeLimit, svc.Config.PaymentRetryFeeFactor)
+			if err != nil {
+				return sendPaymentResponse, err
+			}
+			feeLimit = increasedFeeLimit
+			// the operator's channel may be the one without a route, retries can use every channel
+			if operatorChannel {
+				invoice.OutgoingChanId = 0
+			}
+		}
+		sendPaymentRequest, err = createLnRpcSendRequest(invoice, feeLimit)
+		if err != nil {
+			return sendPaymentResponse, err
+		}
+		if invoice.Keysend {
+			svc.recordKeysendPaymentHash(ctx, invoice, sendPaymentRequest.PaymentHash)
+		}
 
-	// Execute the payment
-	sendPaymentResult, err := svc.LndClient.SendPaymentSync(ctx, sendPaymentRequest)
-	if err != nil {
-		return sendPaymentResponse, err
+		// Execute the payment
+		sendPaymentResult, err = svc.LndClient.SendPaymentSync(ctx, sendPaymentRequest)
+		if err == nil && sendPaymentResult.GetPaymentError() == "" && sendPaymentResult.GetPaymentPreimage() == nil {
+			err = errors.New("no preimage returned")
+		}
+		if err == nil && sendPaymentResult.GetPaymentError() != "" {
+			err = errors.New(sendPaymentResult.GetPaymentError())
+		}
+		svc.recordPaymentAttempt(ctx, invoice, feeLimit, err)
+		if err == nil {
+			break
+		}
+		// Only no-route failures are worth retrying, all other errors are returned right away
+		if !isNoRouteError(err) || attempt == svc.Config.PaymentMaxRetries {
+			return sendPaymentResponse, err
+		}
 	}
 
-	// If there was a payment error we return an error
-	if sendPaymentResult.GetPaymentError() != "" || sendPaymentResult.GetPaymentPreimage() == nil {
-		return sendPaymentResponse, errors.New(sendPaymentResult.GetPaymentError())
+	paymentPreimage := sendPaymentResult.GetPaymentPreimage()
+	// The payment is only settled with a preimage that proves the payment, anything else is treated as a failure
+	expectedPaymentHash := invoice.RHash
+	if invoice.Keysend {
+		expectedPaymentHash = hex.EncodeToString(sendPaymentRequest.PaymentHash)
 	}
-
-	preimage := sendPaymentResult.GetPaymentPreimage()
-	sendPaymentResponse.PaymentPreimage = preimage
-	sendPaymentResponse.PaymentPreimageStr = hex.EncodeToString(preimage[:])
+	if err := preimage.Verify(paymentPreimage, expectedPaymentHash); err != nil {
+		svc.Logger.Errorf("Payment preimage does not match the payment hash invoice_id:%v r_hash:%s preimage:%x", invoice.ID, expectedPaymentHash, paymentPreimage)
+		sentry.CaptureException(fmt.Errorf("%w invoice_id:%v", err, invoice.ID))
+		return sendPaymentResponse, err
+	}
+	sendPaymentResponse.PaymentPreimage = paymentPreimage
+	sendPaymentResponse.PaymentPreimageStr = hex.EncodeToString(paymentPreimage[:])
 	paymentHash := sendPaymentResult.GetPaymentHash()
 	sendPaymentResponse.PaymentHash = paymentHash
 	sendPaymentResponse.PaymentHashStr = hex.EncodeToString(paymentHash[:])
-	sendPaymentResponse.PaymentRoute = &Route{TotalAmt: sendPaymentResult.PaymentRoute.TotalAmt, TotalFees: sendPaymentResult.PaymentRoute.TotalFees}
+	sendPaymentResponse.PaymentRoute = &Route{
+		TotalAmt:      sendPaymentResult.PaymentRoute.TotalAmt,
+		TotalFees:     sendPaymentResult.PaymentRoute.TotalFees,
+		TotalFeesMsat: sendPaymentResult.PaymentRoute.TotalFeesMsat,
+	}
+	svc.recordPaymentRoute(ctx, invoice, sendPaymentResult.PaymentRoute)
 	return sendPaymentResponse, nil
 }
 
-func createLnRpcSendRequest(invoice *models.Invoice) (*lnrpc.SendRequest, error) {
-	// TODO: set dynamic fee limit
+func (svc *LndhubService) recordPaymentAttempt(ctx context.Context, invoice *models.Invoice, feeLimit int64, attemptError error) {
+	invoice.PaymentAttempts++
+	if attemptError != nil {
+		invoice.ErrorMessage = attemptError.Error()
+		svc.Logger.Infof("Payment attempt failed invoice_id:%v attempt:%v fee_limit:%v error:%v", invoice.ID, invoice.PaymentAttempts, feeLimit, attemptError)
+	}
+	_, err := svc.DB.NewUpdate().Model(invoice).Column("payment_attempts", "error_message").WherePK().Exec(ctx)
+	if err != nil {
+		svc.Logger.Errorf("Could not record payment attempt invoice_id:%v %v", invoice.ID, err)
+	}
+}
+
+// recordKeysendPaymentHash stores the payment hash of the keysend attempt before it is sent
+// The node can only be asked for the state of a stuck keysend payment by this hash
+func (svc *LndhubService) recordKeysendPaymentHash(ctx context.Context, invoice *models.Invoice, paymentHash []byte) {
+	invoice.RHash = hex.EncodeToString(paymentHash)
+	_, err := svc.DB.NewUpdate().Model(invoice).Column("r_hash").WherePK().Exec(ctx)
+	if err != nil {
+		svc.Logger.Errorf("Could not record keysend payment hash invoice_id:%v %v", invoice.ID, err)
+	}
+}
+
+func isNoRouteError(err error) bool {
+	return errors.Is(err, lnd.ErrNoRoute)
+}
+
+func createLnRpcSendRequest(invoice *models.Invoice, feeLimitSat int64) (*lnrpc.SendRequest, error) {
 	feeLimit := lnrpc.FeeLimit{
-		//Limit: &lnrpc.FeeLimit_Percent{
-		//	Percent: 2,
-		//},
 		Limit: &lnrpc.FeeLimit_Fixed{
-			Fixed: 300,
+			Fixed: feeLimitSat,
 		},
 	}
+	var lastHopPubkey []byte
+	if invoice.LastHopPubkey != "" {
+		var err error
+		lastHopPubkey, err = hex.DecodeString(invoice.LastHopPubkey)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	if !invoice.Keysend {
 		return &lnrpc.SendRequest{
-			PaymentRequest: invoice.PaymentRequest,
-			Amt:            invoice.Amount,
-			FeeLimit:       &feeLimit,
+			PaymentRequest:    invoice.PaymentRequest,
+			Amt:               invoice.Amount,
+			FeeLimit:          &feeLimit,
+			DestCustomRecords: invoice.DestinationCustomRecords,
+			OutgoingChanId:    invoice.OutgoingChanId,
+			LastHopPubkey:     lastHopPubkey,
 		}, nil
 	}
 
-	preImage := makePreimageHex()
-	pHash := sha256.New()
-	pHash.Write(preImage)
+	keysendPreimage, err := preimage.New()
+	if err != nil {
+		return nil, err
+	}
 	// Prepare the LNRPC call
 	//See: https://github.com/hsjoberg/blixt-wallet/blob/9fcc56a7dc25237bc14b85e6490adb9e044c009c/src/lndmobile/index.ts#L251-L270
 	destBytes, err := hex.DecodeString(invoice.DestinationPubkeyHex)
 	if err != nil {
 		return nil, err
 	}
-	invoice.DestinationCustomRecords[KEYSEND_CUSTOM_RECORD] = preImage
-	return &lnrpc.SendRequest{
+	invoice.DestinationCustomRecords[KEYSEND_CUSTOM_RECORD] = keysendPreimage
+	sendRequest := &lnrpc.SendRequest{
 		Dest:              destBytes,
 		Amt:               invoice.Amount,
-		PaymentHash:       pHash.Sum(nil),
+		PaymentHash:       preimage.Hash(keysendPreimage),
 		FeeLimit:          &feeLimit,
 		DestFeatures:      []lnrpc.FeatureBit{lnrpc.FeatureBit_TLV_ONION_REQ},
 		DestCustomRecords: invoice.DestinationCustomRecords,
-	}, nil
+		OutgoingChanId:    invoice.OutgoingChanId,
+		LastHopPubkey:     lastHopPubkey,
+	}
+	// keysend amounts with a fraction of a satoshi are sent exactly, the user is debited the rounded up amount
+	if invoice.AmountMsat%lnd.MSAT_PER_SAT != 0 {
+		sendRequest.Amt = 0
+		sendRequest.AmtMsat = invoice.AmountMsat
+	}
+	return sendRequest, nil
+}
+
+var ErrRoutingConstraintsNotAllowed = errors.New("routing constraints are not allowed")
+
+// CheckRoutingConstraints validates the routing constraints of a payment, callers check them before the outgoing invoice is created
+// Callers can only set them if ALLOW_ROUTING_CONSTRAINTS is enabled, 0 and "" leave the route unconstrained
+func (svc *LndhubService) CheckRoutingConstraints(outgoingChanId uint64, lastHopPubkey string) error {
+	if outgoingChanId == 0 && lastHopPubkey == "" {
+		return nil
+	}
+	if !svc.Config.AllowRoutingConstraints {
+		return ErrRoutingConstraintsNotAllowed
+	}
+	if lastHopPubkey != "" && !isPubkeyHex(lastHopPubkey) {
+		return fmt.Errorf("invalid last hop pubkey: %s", lastHopPubkey)
+	}
+	return nil
+}
+
+// SetRoutingConstraints pins the payment of the invoice to the first hop channel and the last hop node
+func (svc *LndhubService) SetRoutingConstraints(invoice *models.Invoice, outgoingChanId uint64, lastHopPubkey string) error {
+	if err := svc.CheckRoutingConstraints(outgoingChanId, lastHopPubkey); err != nil {
+		return err
+	}
+	invoice.OutgoingChanId = outgoingChanId
+	invoice.LastHopPubkey = strings.ToLower(lastHopPubkey)
+	return nil
+}
+
+var ErrDestinationNotAllowed = errors.New("payments to this destination are not allowed")
+var ErrMaxSendAmountExceeded = errors.New("payment amount exceeds the maximum send amount")
+var ErrSelfPayment = errors.New("paying your own invoice is not possible")
+var ErrInvoiceExpired = errors.New("invoice has expired")
+var ErrPaymentInProgress = errors.New("a payment of this invoice is already in progress")
+
+var ErrNotEnoughBalance = errors.New("not enough balance for the amount and the fees of the payment")
+
+// paymentRequestExpiresAt returns when the decoded payment request expires
+// Keysend payments and payment requests without an expiry return the zero time
+func paymentRequestExpiresAt(payReq *lnrpc.PayReq) time.Time {
+	if payReq.Timestamp <= 0 || payReq.Expiry <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(payReq.Timestamp, 0).Add(time.Duration(payReq.Expiry) * time.Second)
+}
+
+// checkInvoiceNotExpired fails for outgoing invoices that can no longer be paid
+// Paying them would only debit the user, fail at the node and revert the debit
+func checkInvoiceNotExpired(invoice *models.Invoice, now time.Time) error {
+	if invoice.Keysend || invoice.ExpiresAt.IsZero() {
+		return nil
+	}
+	if !now.Before(invoice.ExpiresAt.Time) {
+		return ErrInvoiceExpired
+	}
+	return nil
+}
+
+// CheckDestinationAllowed enforces the configured destination allow and deny lists
+// Payments to our own node are internal and always allowed
+func (svc *LndhubService) CheckDestinationAllowed(destinationPubkeyHex string) error {
+	destination := strings.ToLower(destinationPubkeyHex)
+	if destination == strings.ToLower(svc.IdentityPubkey) {
+		return nil
+	}
+	for _, denied := range svc.Config.DestinationDenylist {
+		if destination == strings.ToLower(strings.TrimSpace(denied)) {
+			return ErrDestinationNotAllowed
+		}
+	}
+	if len(svc.Config.DestinationAllowlist) == 0 {
+		return nil
+	}
+	for _, allowed := range svc.Config.DestinationAllowlist {
+		if destination == strings.ToLower(strings.TrimSpace(allowed)) {
+			return nil
+		}
+	}
+	return ErrDestinationNotAllowed
 }
 
 func (svc *LndhubService) PayInvoice(ctx context.Context, invoice *models.Invoice) (*SendPaymentResponse, error) {
 	userId := invoice.UserID
+	timer := paymentTimer(ctx)
 
-	// Get the user's current and outgoing account for the transaction entry
-	debitAccount, err := svc.AccountFor(ctx, common.AccountTypeCurrent, userId)
-	if err != nil {
-		svc.Logger.Errorf("Could not find current account user_id:%v", invoice.UserID)
+	if err := checkInvoiceNotExpired(invoice, time.Now()); err != nil {
+		svc.Logger.Errorf("Invoice expired user_id:%v invoice_id:%v expires_at:%v", invoice.UserID, invoice.ID, invoice.ExpiresAt.Time)
 		return nil, err
 	}
-	creditAccount, err := svc.AccountFor(ctx, common.AccountTypeOutgoing, userId)
+	if err := svc.CheckUserNotFrozen(ctx, userId); err != nil {
+		svc.Logger.Errorf("Payment of frozen user denied user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
+		return nil, err
+	}
+	// the lists may have changed since the invoice was created, e.g. before a retry
+	if err := svc.CheckDestinationAllowed(invoice.DestinationPubkeyHex); err != nil {
+		svc.Logger.Errorf("Destination not allowed user_id:%v invoice_id:%v destination:%s", invoice.UserID, invoice.ID, invoice.DestinationPubkeyHex)
+		return nil, err
+	}
+	tier, err := svc.UserTierSettings(ctx, userId)
 	if err != nil {
-		svc.Logger.Errorf("Could not find outgoing account user_id:%v", invoice.UserID)
 		return nil, err
 	}
+	if err := svc.AmountLimits(tier).CheckSendAmount(invoice.Amount); err != nil {
+		svc.Logger.Errorf("Payment amount outside of the send limits user_id:%v invoice_id:%v amount:%v", invoice.UserID, invoice.ID, invoice.Amount)
+		return nil, err
+	}
+	// Paying an invoice created by the same user would only move funds from and to the same current account
+	// Transfers have no payment request, the recipient is checked when the transfer is created
+	if svc.IdentityPubkey == invoice.DestinationPubkeyHex && !invoice.Keysend && invoice.PaymentRequest != "" {
+		ownInvoiceCount, err := svc.DB.NewSelect().Model((*models.Invoice)(nil)).
+			Where("type = ? AND payment_request = ? AND user_id = ?", common.InvoiceTypeIncoming, invoice.PaymentRequest, userId).
+			Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if ownInvoiceCount > 0 {
+			svc.Logger.Errorf("User tried to pay own invoice user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
+			return nil, ErrSelfPayment
+		}
+	}
+	// api keys and tokens with a spend limit pass it with the context of the request
+	spendLimit := tokens.SpendLimitFromContext(ctx)
+	if err := checkPaymentSpendLimit(spendLimit, invoice.Amount); err != nil {
+		svc.Logger.Errorf("Payment above the spend limit of the credentials user_id:%v invoice_id:%v amount:%v", invoice.UserID, invoice.ID, invoice.Amount)
+		return nil, err
+	}
+	// Internal payments do not use the node's channels
+	if svc.IdentityPubkey != invoice.DestinationPubkeyHex {
+		if err := svc.CheckOutboundLiquidity(); err != nil {
+			svc.Logger.Errorf("Outgoing payment denied due to low liquidity user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
+			return nil, err
+		}
+	}
+	timer.Mark(PaymentStageChecks)
 
-	entry := models.TransactionEntry{
-		UserID:          userId,
-		InvoiceID:       invoice.ID,
-		CreditAccountID: creditAccount.ID,
-		DebitAccountID:  debitAccount.ID,
-		Amount:          invoice.Amount,
+	// The service fee is charged together with the payment amount and refunded if the payment fails
+	serviceFee := tier.OutgoingServiceFeeFor(invoice.Amount)
+	// The maximum routing fee is reserved upfront as well, the unused part is refunded when the payment settles
+	feeReserve, err := svc.FeeReserveFor(invoice)
+	if err != nil {
+		return nil, err
 	}
 
-	// The DB constraints make sure the user actually has enough balance for the transaction
-	// If the user does not have enough balance this call fails
-	_, err = svc.DB.NewInsert().Model(&entry).Exec(ctx)
+	// First commit point: the payment amount and the fees are booked in one transaction before the payment is sent
+	// If any of them fails the transaction is rolled back, nothing is booked and the payment is not sent
+	var entry models.TransactionEntry
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		// The amount stays in the user's in-flight account until the payment is settled or failed
+		debitAccount, err := svc.accountFor(ctx, tx, common.AccountTypeCurrent, userId)
+		if err != nil {
+			svc.Logger.Errorf("Could not find current account user_id:%v", invoice.UserID)
+			return err
+		}
+		creditAccount, err := svc.accountFor(ctx, tx, common.AccountTypeInFlight, userId)
+		if err != nil {
+			svc.Logger.Errorf("Could not find in-flight account user_id:%v", invoice.UserID)
+			return err
+		}
+		if err := svc.lockPayment(ctx, tx, invoice, debitAccount.ID); err != nil {
+			return err
+		}
+		// the send volume is read after the current account is locked as well, concurrent payments are checked one after the other
+		if err := svc.checkSendLimits(ctx, tx, userId, invoice.Amount, tier); err != nil {
+			svc.Logger.Errorf("Send limit check failed user_id:%v invoice_id:%v: %v", invoice.UserID, invoice.ID, err)
+			return err
+		}
+		if err := svc.recordSpend(ctx, tx, spendLimit, invoice); err != nil {
+			return err
+		}
+		// the balance is read after the current account is locked, other payments can not spend it until this commits
+		balance, err := svc.accountBalance(ctx, tx, debitAccount.ID)
+		if err != nil {
+			return err
+		}
+		total, err := lib.AddAmounts(invoice.Amount, serviceFee)
+		if err == nil {
+			total, err = lib.AddAmounts(total, feeReserve)
+		}
+		if err != nil {
+			return err
+		}
+		if balance < total {
+			svc.Logger.Errorf("Not enough balance for the payment user_id:%v invoice_id:%v balance:%v total:%v", invoice.UserID, invoice.ID, balance, total)
+			return ErrNotEnoughBalance
+		}
+		entry = models.TransactionEntry{
+			UserID:          userId,
+			InvoiceID:       invoice.ID,
+			CreditAccountID: creditAccount.ID,
+			DebitAccountID:  debitAccount.ID,
+			Amount:          invoice.Amount,
+		}
+		// The DB constraints make sure the user actually has enough balance for the transaction as well
+		if _, err := tx.NewInsert().Model(&entry).Exec(ctx); err != nil {
+			svc.Logger.Errorf("Could not insert transaction entry user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
+			return err
+		}
+		invoice.ServiceFee = serviceFee
+		if err := svc.insertServiceFeeEntry(ctx, tx, invoice, debitAccount.ID, entry.ID); err != nil {
+			return err
+		}
+		invoice.FeeReserve = feeReserve
+		return svc.insertFeeReserveEntry(ctx, tx, invoice, entry)
+	})
 	if err != nil {
-		svc.Logger.Errorf("Could not insert transaction entry user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
+		// nothing was booked, there is nothing to refund
+		invoice.ServiceFee = 0
+		invoice.FeeReserve = 0
 		return nil, err
 	}
+	timer.Mark(PaymentStageLedgerInsert)
 
 	var paymentResponse SendPaymentResponse
 	// Check the destination pubkey if it is an internal invoice and going to our node
@@ -205,12 +575,14 @@ func (svc *LndhubService) PayInvoice(ctx context.Context, invoice *models.Invoic
 	// regardless of if the request's context is canceled or not.
 	if svc.IdentityPubkey == invoice.DestinationPubkeyHex {
 		paymentResponse, err = svc.SendInternalPayment(context.Background(), invoice)
+		timer.Mark(PaymentStageInternalPayment)
 		if err != nil {
 			svc.HandleFailedPayment(context.Background(), invoice, entry, err)
 			return nil, err
 		}
 	} else {
 		paymentResponse, err = svc.SendPaymentSync(context.Background(), invoice)
+		timer.Mark(PaymentStageLndRpc)
 		if err != nil {
 			svc.HandleFailedPayment(context.Background(), invoice, entry, err)
 			return nil, err
@@ -222,12 +594,72 @@ func (svc *LndhubService) PayInvoice(ctx context.Context, invoice *models.Invoic
 	// The payment was successful.
 	// These changes to the invoice are persisted in the `HandleSuccessfulPayment` function
 	invoice.Preimage = paymentResponse.PaymentPreimageStr
-	invoice.Fee = paymentResponse.PaymentRoute.TotalFees
+	invoice.Fee, invoice.FeeMsat = routingFee(paymentResponse.PaymentRoute)
 	err = svc.HandleSuccessfulPayment(context.Background(), invoice, entry)
+	timer.Mark(PaymentStageBookkeeping)
 	return &paymentResponse, err
 }
 
+// routingFee returns the routing fee of a payment in satoshis, rounded up, and in millisatoshis
+// The fee is booked rounded up, so the hub never pays more routing fees than the users were charged
+func routingFee(route *Route) (int64, int64) {
+	if route.TotalFeesMsat == 0 {
+		return route.TotalFees, route.TotalFees * lnd.MSAT_PER_SAT
+	}
+	fee, err := lnd.MsatToSatRoundUp(route.TotalFeesMsat)
+	if err != nil {
+		return route.TotalFees, route.TotalFees * lnd.MSAT_PER_SAT
+	}
+	return fee, route.TotalFeesMsat
+}
+
+// lockPayment locks the user's current account until the transaction commits and makes sure no other payment of the payment hash is in flight
+// The in-flight row is unique per user, payment hash and type, a concurrent payment of the same invoice can not insert its own
+func (svc *LndhubService) lockPayment(ctx context.Context, tx bun.Tx, invoice *models.Invoice, currentAccountID int64) error {
+	if err := lockAccount(ctx, tx, currentAccountID); err != nil {
+		return err
+	}
+	// keysend payments get a new payment hash when they are sent, they can not be paid twice
+	if invoice.RHash == "" {
+		return nil
+	}
+	res, err := tx.NewInsert().Model(&models.InFlightPayment{
+		UserID:    invoice.UserID,
+		RHash:     invoice.RHash,
+		Type:      invoice.Type,
+		InvoiceID: invoice.ID,
+	}).Ignore().Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if inserted, err := res.RowsAffected(); err != nil || inserted == 0 {
+		svc.Logger.Errorf("Payment of the invoice already in progress user_id:%v invoice_id:%v r_hash:%s", invoice.UserID, invoice.ID, invoice.RHash)
+		return ErrPaymentInProgress
+	}
+	return nil
+}
+
+// releasePayment removes the in-flight row of a payment that was settled or failed
+func releasePayment(ctx context.Context, tx bun.Tx, invoice *models.Invoice) error {
+	_, err := tx.NewDelete().Model((*models.InFlightPayment)(nil)).Where("invoice_id = ?", invoice.ID).Exec(ctx)
+	return err
+}
+
+// HandleFailedPayment refunds the payment amount and the fees and marks the invoice as failed in one transaction
 func (svc *LndhubService) HandleFailedPayment(ctx context.Context, invoice *models.Invoice, entryToRevert models.TransactionEntry, failedPaymentError error) error {
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		return svc.revertFailedPayment(ctx, tx, invoice, entryToRevert, failedPaymentError)
+	})
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+	svc.onPaymentFailed(ctx, invoice)
+	return nil
+}
+
+// revertFailedPayment books the refund of a failed payment in the transaction and marks the invoice as failed
+func (svc *LndhubService) revertFailedPayment(ctx context.Context, tx bun.Tx, invoice *models.Invoice, entryToRevert models.TransactionEntry, failedPaymentError error) error {
 	// add transaction entry with reverted credit/debit account id
 	entry := models.TransactionEntry{
 		UserID:          invoice.UserID,
@@ -236,60 +668,72 @@ func (svc *LndhubService) HandleFailedPayment(ctx context.Context, invoice *mode
 		DebitAccountID:  entryToRevert.CreditAccountID,
 		Amount:          invoice.Amount,
 	}
-	_, err := svc.DB.NewInsert().Model(&entry).Exec(ctx)
-	if err != nil {
-		sentry.CaptureException(err)
+	if _, err := tx.NewInsert().Model(&entry).Exec(ctx); err != nil {
 		svc.Logger.Errorf("Could not insert transaction entry user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
 		return err
 	}
+	if err := svc.revertServiceFeeEntry(ctx, tx, invoice, entryToRevert.DebitAccountID, entryToRevert.ID); err != nil {
+		return err
+	}
+	if err := svc.revertFeeReserveEntry(ctx, tx, invoice, entryToRevert); err != nil {
+		return err
+	}
+	if err := releasePayment(ctx, tx, invoice); err != nil {
+		return err
+	}
+	return svc.failPayment(ctx, tx, invoice, failedPaymentError)
+}
 
+// failPayment marks the invoice as failed with the error of the payment
+func (svc *LndhubService) failPayment(ctx context.Context, db bun.IDB, invoice *models.Invoice, failedPaymentError error) error {
 	invoice.State = common.InvoiceStateError
 	if failedPaymentError != nil {
 		invoice.ErrorMessage = failedPaymentError.Error()
+		invoice.FailureReason = ClassifyPaymentFailure(invoice.ErrorMessage)
 	}
-
-	_, err = svc.DB.NewUpdate().Model(invoice).WherePK().Exec(ctx)
+	_, err := db.NewUpdate().Model(invoice).WherePK().Exec(ctx)
 	if err != nil {
-		sentry.CaptureException(err)
 		svc.Logger.Errorf("Could not update failed payment invoice user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
 	}
 	return err
 }
 
+// onPaymentFailed updates the failure counters once the failed payment is committed
+func (svc *LndhubService) onPaymentFailed(ctx context.Context, invoice *models.Invoice) {
+	if err := svc.countFailedPayment(ctx, invoice.UserID); err != nil {
+		svc.Logger.Errorf("Could not count failed payment invoice_id:%v %v", invoice.ID, err)
+	}
+	svc.notifyRepeatedPaymentFailures(ctx, invoice)
+}
+
+// HandleSuccessfulPayment books the routing fee and settles the payment in one transaction
+// This is the commit point after the payment was sent, if it fails the payment stays in flight until an operator resolves it
 func (svc *LndhubService) HandleSuccessfulPayment(ctx context.Context, invoice *models.Invoice, parentEntry models.TransactionEntry) error {
 	invoice.State = common.InvoiceStateSettled
 	invoice.SettledAt = schema.NullTime{Time: time.Now()}
 
-	_, err := svc.DB.NewUpdate().Model(invoice).WherePK().Exec(ctx)
-	if err != nil {
-		sentry.CaptureException(err)
-		svc.Logger.Errorf("Could not update sucessful payment invoice user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
-	}
-
-	// Get the user's fee account for the transaction entry, current account is already there in parent entry
-	feeAccount, err := svc.AccountFor(ctx, common.AccountTypeFees, invoice.UserID)
-	if err != nil {
-		svc.Logger.Errorf("Could not find fees account user_id:%v", invoice.UserID)
-		return err
-	}
-
-	// add transaction entry for fee
-	entry := models.TransactionEntry{
-		UserID:          invoice.UserID,
-		InvoiceID:       invoice.ID,
-		CreditAccountID: feeAccount.ID,
-		DebitAccountID:  parentEntry.DebitAccountID,
-		Amount:          int64(invoice.Fee),
-		ParentID:        parentEntry.ID,
-	}
-	_, err = svc.DB.NewInsert().Model(&entry).Exec(ctx)
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewUpdate().Model(invoice).WherePK().Exec(ctx); err != nil {
+			svc.Logger.Errorf("Could not update sucessful payment invoice user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
+			return err
+		}
+		// add transaction entries for the fee, the unused fee reserve is refunded
+		if err := svc.insertRoutingFeeEntries(ctx, tx, invoice, parentEntry); err != nil {
+			svc.Logger.Errorf("Could not insert fee transaction entry user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
+			return err
+		}
+		if err := svc.settleInFlightEntry(ctx, tx, invoice, parentEntry); err != nil {
+			svc.Logger.Errorf("Could not settle in-flight transaction entry user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
+			return err
+		}
+		return releasePayment(ctx, tx, invoice)
+	})
 	if err != nil {
 		sentry.CaptureException(err)
-		svc.Logger.Errorf("Could not insert fee transaction entry user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
 		return err
 	}
 
-	userBalance, err := svc.CurrentUserBalance(ctx, entry.UserID)
+	userBalance, err := svc.CurrentUserBalance(ctx, invoice.UserID)
 	if err != nil {
 		sentry.CaptureException(err)
 		svc.Logger.Errorf("Could not fetch user balance user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
@@ -297,15 +741,22 @@ func (svc *LndhubService) HandleSuccessfulPayment(ctx context.Context, invoice *
 	}
 
 	if userBalance < 0 {
-		amountMsg := fmt.Sprintf("User balance is negative transaction_entry_id:%v user_id:%v amount:%v", entry.ID, entry.UserID, userBalance)
-		svc.Logger.Info(amountMsg)
-		sentry.CaptureMessage(amountMsg)
+		svc.Logger.Errorf("User balance is negative transaction_entry_id:%v user_id:%v amount:%v", parentEntry.ID, invoice.UserID, userBalance)
+		if err := svc.handleNegativeBalance(ctx, invoice, parentEntry, userBalance); err != nil {
+			sentry.CaptureException(err)
+			svc.Logger.Errorf("Could not handle negative balance user_id:%v invoice_id:%v %v", invoice.UserID, invoice.ID, err)
+		}
 	}
 
 	return nil
 }
 
 func (svc *LndhubService) AddOutgoingInvoice(ctx context.Context, userID int64, paymentRequest string, lnPayReq *lnd.LNPayReq) (*models.Invoice, error) {
+	// payments to destinations that are not allowed are rejected before an invoice is created
+	if err := svc.CheckDestinationAllowed(lnPayReq.PayReq.Destination); err != nil {
+		svc.Logger.Errorf("Destination not allowed user_id:%v destination:%s", userID, lnPayReq.PayReq.Destination)
+		return nil, err
+	}
 	// Initialize new DB invoice
 	invoice := models.Invoice{
 		Type:                 common.InvoiceTypeOutgoing,
@@ -313,12 +764,22 @@ func (svc *LndhubService) AddOutgoingInvoice(ctx context.Context, userID int64,
 		PaymentRequest:       paymentRequest,
 		RHash:                lnPayReq.PayReq.PaymentHash,
 		Amount:               lnPayReq.PayReq.NumSatoshis,
+		AmountMsat:           lnPayReq.PayReq.NumMsat,
 		State:                common.InvoiceStateInitialized,
 		DestinationPubkeyHex: lnPayReq.PayReq.Destination,
 		DescriptionHash:      lnPayReq.PayReq.DescriptionHash,
-		Memo:                 lnPayReq.PayReq.Description,
+		Memo:                 svc.sanitizeMemo(lnPayReq.PayReq.Description),
 		Keysend:              lnPayReq.Keysend,
-		ExpiresAt:            bun.NullTime{Time: time.Unix(lnPayReq.PayReq.Timestamp, 0).Add(time.Duration(lnPayReq.PayReq.Expiry) * time.Second)},
+		ExpiresAt:            bun.NullTime{Time: paymentRequestExpiresAt(lnPayReq.PayReq)},
+	}
+
+	// invoices of a fraction of a satoshi are debited with the amount rounded up
+	if invoice.AmountMsat%lnd.MSAT_PER_SAT != 0 {
+		amount, err := lnd.MsatToSatRoundUp(invoice.AmountMsat)
+		if err != nil {
+			return nil, err
+		}
+		invoice.Amount = amount
 	}
 
 	// Save invoice
@@ -329,40 +790,137 @@ func (svc *LndhubService) AddOutgoingInvoice(ctx context.Context, userID int64,
 	return &invoice, nil
 }
 
-func (svc *LndhubService) AddIncomingInvoice(ctx context.Context, userID int64, amount int64, memo, descriptionHashStr string) (*models.Invoice, error) {
-	preimage := makePreimageHex()
-	expiry := time.Hour * 24 // invoice expires in 24h
-	// Initialize new DB invoice
+const DefaultInvoiceExpiry = time.Hour * 24 // invoices expire in 24h if INVOICE_EXPIRY is not set
+
+var ErrInvalidInvoiceExpiry = errors.New("invoice expiry is outside of the allowed range")
+
+// InvoiceExpiry returns the requested expiry in seconds, INVOICE_EXPIRY if it is 0
+// Requested expiries must be between INVOICE_MIN_EXPIRY and INVOICE_MAX_EXPIRY
+func (svc *LndhubService) InvoiceExpiry(seconds int64) (time.Duration, error) {
+	if seconds == 0 {
+		if svc.Config.InvoiceExpiry <= 0 {
+			return DefaultInvoiceExpiry, nil
+		}
+		return time.Duration(svc.Config.InvoiceExpiry) * time.Second, nil
+	}
+	if seconds < svc.Config.InvoiceMinExpiry || (svc.Config.InvoiceMaxExpiry > 0 && seconds > svc.Config.InvoiceMaxExpiry) {
+		return 0, ErrInvalidInvoiceExpiry
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// AddIncomingInvoice creates an invoice expiring after the expiry in seconds, 0 uses INVOICE_EXPIRY
+// A client supplied preimage is used instead of a random one, a client supplied payment hash makes a hold invoice
+// A webhook URL receives the settlement and expiry of this invoice
+func (svc *LndhubService) AddIncomingInvoice(ctx context.Context, userID int64, amount int64, memo, descriptionHashStr string, expirySeconds int64, preimageHex, rHashHex, webhookUrl string) (*models.Invoice, error) {
+	expiry, err := svc.InvoiceExpiry(expirySeconds)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateWebhookUrl(webhookUrl); err != nil {
+		return nil, err
+	}
+	preimage, rHash, err := parseInvoicePreimage(preimageHex, rHashHex)
+	if err != nil {
+		return nil, err
+	}
 	invoice := models.Invoice{
-		Type:            common.InvoiceTypeIncoming,
 		UserID:          userID,
 		Amount:          amount,
 		Memo:            memo,
 		DescriptionHash: descriptionHashStr,
-		State:           common.InvoiceStateInitialized,
-		ExpiresAt:       bun.NullTime{Time: time.Now().Add(expiry)},
+		Preimage:        preimage,
+		RHash:           rHash,
+		WebhookUrl:      webhookUrl,
+	}
+	return svc.addIncomingInvoice(ctx, &invoice, expiry)
+}
+
+// AddIncomingInvoiceFromPreset creates an invoice with the memo, expiry and metadata of the preset
+// The amount is only used if the preset leaves the amount open
+func (svc *LndhubService) AddIncomingInvoiceFromPreset(ctx context.Context, preset *models.InvoicePreset, amount int64) (*models.Invoice, error) {
+	if preset.Amount > 0 {
+		amount = preset.Amount
+	}
+	expiry, err := svc.InvoiceExpiry(preset.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	invoice := models.Invoice{
+		UserID:   preset.UserID,
+		Amount:   amount,
+		Memo:     preset.Memo,
+		Metadata: preset.Metadata,
+	}
+	return svc.addIncomingInvoice(ctx, &invoice, expiry)
+}
+
+func (svc *LndhubService) addIncomingInvoice(ctx context.Context, invoice *models.Invoice, expiry time.Duration) (*models.Invoice, error) {
+	if err := svc.CheckUserNotFrozen(ctx, invoice.UserID); err != nil {
+		svc.Logger.Errorf("Invoice creation of frozen user denied user_id:%v", invoice.UserID)
+		return nil, err
+	}
+	if err := svc.AmountLimits(nil).CheckReceiveAmount(invoice.Amount); err != nil {
+		svc.Logger.Errorf("Invoice amount outside of the receive limits user_id:%v amount:%v", invoice.UserID, invoice.Amount)
+		return nil, err
+	}
+	if svc.ReceivingPaused() {
+		svc.Logger.Errorf("Invoice creation paused, the node can not receive user_id:%v amount:%v", invoice.UserID, invoice.Amount)
+		return nil, ErrReceivingPaused
+	}
+	if svc.Config.InboundLiquidityCheck == InboundLiquidityCheckReject {
+		if err := svc.CheckInboundLiquidity(ctx, invoice.Amount); err != nil {
+			svc.Logger.Errorf("Invoice amount exceeds inbound liquidity user_id:%v amount:%v", invoice.UserID, invoice.Amount)
+			return nil, err
+		}
+	}
+	if err := svc.CheckInvoiceQuotas(ctx, invoice.UserID); err != nil {
+		svc.Logger.Errorf("Invoice quota exceeded user_id:%v %v", invoice.UserID, err)
+		return nil, err
+	}
+	memo, err := svc.ApplyMemoPolicy(ctx, invoice.UserID, invoice.Memo)
+	if err != nil {
+		return nil, err
+	}
+	invoicePreimage, err := svc.invoicePreimage(ctx, invoice)
+	if err != nil {
+		return nil, err
+	}
+	// Initialize new DB invoice
+	invoice.Memo = memo
+	invoice.Type = common.InvoiceTypeIncoming
+	invoice.State = common.InvoiceStateInitialized
+	invoice.ExpiresAt = bun.NullTime{Time: time.Now().Add(expiry)}
+	if err := svc.offloadInvoiceBlobs(ctx, invoice); err != nil {
+		svc.Logger.Errorf("Could not store invoice blobs user_id:%v %v", invoice.UserID, err)
+		return nil, err
 	}
 
 	// Save invoice - we save the invoice early to have a record in case the LN call fails
-	_, err := svc.DB.NewInsert().Model(&invoice).Exec(ctx)
+	_, err = svc.DB.NewInsert().Model(invoice).Exec(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	descriptionHash, err := hex.DecodeString(descriptionHashStr)
+	descriptionHash, err := hex.DecodeString(invoice.DescriptionHash)
 	if err != nil {
 		return nil, err
 	}
 	// Initialize lnrpc invoice
 	lnInvoice := lnrpc.Invoice{
-		Memo:            memo,
+		Memo:            invoice.Memo,
 		DescriptionHash: descriptionHash,
-		Value:           amount,
-		RPreimage:       preimage,
+		Value:           invoice.Amount,
+		RPreimage:       invoicePreimage,
 		Expiry:          int64(expiry.Seconds()),
 	}
-	// Call LND
-	lnInvoiceResult, err := svc.LndClient.AddInvoice(ctx, &lnInvoice)
+	// Call LND, invoices without a preimage are hold invoices
+	var lnInvoiceResult *lnrpc.AddInvoiceResponse
+	if invoicePreimage == nil {
+		lnInvoiceResult, err = svc.addHoldInvoice(ctx, &lnInvoice, invoice.RHash)
+	} else {
+		lnInvoiceResult, err = svc.LndClient.AddInvoice(ctx, &lnInvoice)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -370,29 +928,24 @@ func (svc *LndhubService) AddIncomingInvoice(ctx context.Context, userID int64,
 	// Update the DB invoice with the data from the LND gRPC call
 	invoice.PaymentRequest = lnInvoiceResult.PaymentRequest
 	invoice.RHash = hex.EncodeToString(lnInvoiceResult.RHash)
-	invoice.Preimage = hex.EncodeToString(preimage)
+	if invoicePreimage != nil {
+		invoice.Preimage = hex.EncodeToString(invoicePreimage)
+	}
 	invoice.AddIndex = lnInvoiceResult.AddIndex
 	invoice.DestinationPubkeyHex = svc.IdentityPubkey // Our node pubkey for incoming invoices
 	invoice.State = common.InvoiceStateOpen
 
-	_, err = svc.DB.NewUpdate().Model(&invoice).WherePK().Exec(ctx)
+	_, err = svc.DB.NewUpdate().Model(invoice).WherePK().Exec(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if err := svc.countOpenInvoice(ctx, invoice); err != nil {
+		svc.Logger.Errorf("Could not count open invoice invoice_id:%v %v", invoice.ID, err)
+	}
 
-	return &invoice, nil
+	return invoice, nil
 }
 
 func (svc *LndhubService) DecodePaymentRequest(ctx context.Context, bolt11 string) (*lnrpc.PayReq, error) {
 	return svc.LndClient.DecodeBolt11(ctx, bolt11)
 }
-
-const hexBytes = random.Hex
-
-func makePreimageHex() []byte {
-	b := make([]byte, 32)
-	for i := range b {
-		b[i] = hexBytes[rand.Intn(len(hexBytes))]
-	}
-	return b
-}
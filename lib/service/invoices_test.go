@@ -0,0 +1,144 @@
+package service
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/preimage"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+func TestCheckInvoiceNotExpired(t *testing.T) {
+	now := time.Unix(1650000000, 0)
+	expiresAt := paymentRequestExpiresAt(&lnrpc.PayReq{Timestamp: now.Unix() - 3000, Expiry: 3600})
+	assert.Equal(t, now.Add(600*time.Second), expiresAt)
+	assert.True(t, paymentRequestExpiresAt(&lnrpc.PayReq{}).IsZero())
+
+	invoice := &models.Invoice{ExpiresAt: bun.NullTime{Time: expiresAt}}
+	assert.NoError(t, checkInvoiceNotExpired(invoice, now))
+	assert.ErrorIs(t, checkInvoiceNotExpired(invoice, expiresAt), ErrInvoiceExpired)
+	assert.ErrorIs(t, checkInvoiceNotExpired(invoice, now.Add(time.Hour)), ErrInvoiceExpired)
+
+	assert.NoError(t, checkInvoiceNotExpired(&models.Invoice{}, now.Add(time.Hour)))
+	invoice.Keysend = true
+	assert.NoError(t, checkInvoiceNotExpired(invoice, now.Add(time.Hour)))
+}
+
+func TestRoutingConstraints(t *testing.T) {
+	lastHop := "02e89ca9e8da72b33d896bae51d20e7e6675aa971f7557500b6591b15429e717f1"
+	invoice := &models.Invoice{PaymentRequest: "lnbc1", Amount: 1000}
+
+	svc := &LndhubService{Config: &Config{}}
+	assert.NoError(t, svc.CheckRoutingConstraints(0, ""))
+	assert.NoError(t, svc.SetRoutingConstraints(invoice, 0, ""))
+	assert.ErrorIs(t, svc.CheckRoutingConstraints(123, ""), ErrRoutingConstraintsNotAllowed)
+	assert.ErrorIs(t, svc.SetRoutingConstraints(invoice, 123, ""), ErrRoutingConstraintsNotAllowed)
+
+	svc.Config.AllowRoutingConstraints = true
+	assert.Error(t, svc.CheckRoutingConstraints(0, "02e89c"))
+	assert.Error(t, svc.SetRoutingConstraints(invoice, 0, "02e89c"))
+	assert.Equal(t, uint64(0), invoice.OutgoingChanId)
+	assert.NoError(t, svc.SetRoutingConstraints(invoice, 123, strings.ToUpper(lastHop)))
+
+	sendRequest, err := createLnRpcSendRequest(invoice, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(123), sendRequest.OutgoingChanId)
+	assert.Equal(t, lastHop, hex.EncodeToString(sendRequest.LastHopPubkey))
+}
+
+func TestKeysendSendRequest(t *testing.T) {
+	invoice := &models.Invoice{
+		Keysend:                  true,
+		Amount:                   1000,
+		DestinationPubkeyHex:     "02e89ca9e8da72b33d896bae51d20e7e6675aa971f7557500b6591b15429e717f1",
+		DestinationCustomRecords: map[uint64][]byte{},
+	}
+	sendRequest, err := createLnRpcSendRequest(invoice, 10)
+	assert.NoError(t, err)
+	keysendPreimage := sendRequest.DestCustomRecords[KEYSEND_CUSTOM_RECORD]
+	assert.Len(t, keysendPreimage, preimage.Size)
+	assert.NoError(t, preimage.Verify(keysendPreimage, hex.EncodeToString(sendRequest.PaymentHash)))
+}
+
+func TestKeysendSendRequestMsat(t *testing.T) {
+	invoice := &models.Invoice{
+		Keysend:                  true,
+		Amount:                   2,
+		AmountMsat:               1500,
+		DestinationPubkeyHex:     "02e89ca9e8da72b33d896bae51d20e7e6675aa971f7557500b6591b15429e717f1",
+		DestinationCustomRecords: map[uint64][]byte{},
+	}
+	sendRequest, err := createLnRpcSendRequest(invoice, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), sendRequest.Amt)
+	assert.Equal(t, int64(1500), sendRequest.AmtMsat)
+
+	invoice.Amount = 1
+	invoice.AmountMsat = 1000
+	sendRequest, err = createLnRpcSendRequest(invoice, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), sendRequest.Amt)
+	assert.Equal(t, int64(0), sendRequest.AmtMsat)
+}
+
+func TestCheckDestinationAllowed(t *testing.T) {
+	own := "02e89ca9e8da72b33d896bae51d20e7e6675aa971f7557500b6591b15429e717f1"
+	allowed := "03a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	denied := "02f1e2d3c4b5a69788796a5b4c3d2e1f0f1e2d3c4b5a69788796a5b4c3d2e1f0f1"
+	svc := &LndhubService{Config: &Config{DestinationDenylist: []string{denied}}, IdentityPubkey: own}
+	assert.NoError(t, svc.CheckDestinationAllowed(allowed))
+	assert.ErrorIs(t, svc.CheckDestinationAllowed(strings.ToUpper(denied)), ErrDestinationNotAllowed)
+
+	svc.Config.DestinationAllowlist = []string{" " + strings.ToUpper(allowed)}
+	assert.NoError(t, svc.CheckDestinationAllowed(allowed))
+	assert.ErrorIs(t, svc.CheckDestinationAllowed(denied), ErrDestinationNotAllowed)
+	// payments to the hub's own node are internal
+	svc.Config.DestinationDenylist = []string{own}
+	assert.NoError(t, svc.CheckDestinationAllowed(own))
+}
+
+func TestIsNoRouteError(t *testing.T) {
+	assert.True(t, isNoRouteError(fmt.Errorf("%w: no_route", lnd.ErrNoRoute)))
+	assert.False(t, isNoRouteError(errors.New("no_route")))
+	assert.False(t, isNoRouteError(errors.New("invoice expired")))
+}
+
+func TestRoutingFee(t *testing.T) {
+	fee, feeMsat := routingFee(&Route{TotalFees: 1, TotalFeesMsat: 1500})
+	assert.Equal(t, int64(2), fee)
+	assert.Equal(t, int64(1500), feeMsat)
+	fee, feeMsat = routingFee(&Route{TotalFees: 2, TotalFeesMsat: 2000})
+	assert.Equal(t, int64(2), fee)
+	assert.Equal(t, int64(2000), feeMsat)
+	// nodes that report the fee in satoshis only
+	fee, feeMsat = routingFee(&Route{TotalFees: 3})
+	assert.Equal(t, int64(3), fee)
+	assert.Equal(t, int64(3000), feeMsat)
+}
+
+func TestInvoiceExpiry(t *testing.T) {
+	svc := &LndhubService{Config: &Config{InvoiceExpiry: 3600, InvoiceMinExpiry: 60, InvoiceMaxExpiry: 86400}}
+	expiry, err := svc.InvoiceExpiry(0)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, expiry)
+	expiry, err = svc.InvoiceExpiry(600)
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, expiry)
+	_, err = svc.InvoiceExpiry(59)
+	assert.ErrorIs(t, err, ErrInvalidInvoiceExpiry)
+	_, err = svc.InvoiceExpiry(86401)
+	assert.ErrorIs(t, err, ErrInvalidInvoiceExpiry)
+
+	svc.Config.InvoiceExpiry = 0
+	expiry, err = svc.InvoiceExpiry(0)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultInvoiceExpiry, expiry)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/hex"
+	"expvar"
 	"strings"
 	"time"
 
@@ -15,20 +16,40 @@ import (
 	"github.com/uptrace/bun"
 )
 
+// settledAmount is the amount credited for a settled invoice
+// Amount-less invoices are credited with the amount the payer sent, other invoices with their amount
+func settledAmount(invoiceAmount, amtPaidSat int64) int64 {
+	if invoiceAmount == 0 {
+		return amtPaidSat
+	}
+	return invoiceAmount
+}
+
 func (svc *LndhubService) ProcessInvoiceUpdate(ctx context.Context, rawInvoice *lnrpc.Invoice) error {
 	var invoice models.Invoice
 	rHashStr := hex.EncodeToString(rawInvoice.RHash)
 
 	svc.Logger.Infof("Invoice update: r_hash:%s state:%v", rHashStr, rawInvoice.State.String())
 
+	if rawInvoice.State == lnrpc.Invoice_CANCELED {
+		return svc.expireCanceledInvoice(ctx, rHashStr)
+	}
+
 	// Search for an incoming invoice with the r_hash that is NOT settled in our DB
-	err := svc.DB.NewSelect().Model(&invoice).Where("type = ? AND r_hash = ? AND state <> ? AND expires_at > ?",
+	// Settlements are processed even if the invoice expired since, the node only settles invoices paid in time
+	query := svc.DB.NewSelect().Model(&invoice).Where("type = ? AND r_hash = ? AND state <> ?",
 		common.InvoiceTypeIncoming,
 		rHashStr,
-		common.InvoiceStateSettled,
-		time.Now()).Limit(1).Scan(ctx)
+		common.InvoiceStateSettled)
+	if !rawInvoice.Settled {
+		query.Where("expires_at > ?", time.Now())
+	}
+	err := query.Limit(1).Scan(ctx)
 	if err != nil {
 		svc.Logger.Infof("Invoice not found. Ignoring. r_hash:%s", rHashStr)
+		if rawInvoice.Settled {
+			return svc.saveInvoiceSubscriptionIndexes(ctx, svc.DB, rawInvoice)
+		}
 		return nil
 	}
 
@@ -50,6 +71,11 @@ func (svc *LndhubService) ProcessInvoiceUpdate(ctx context.Context, rawInvoice *
 		svc.Logger.Errorf("Could not find incoming account user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
 		return err
 	}
+	tier, err := svc.UserTierSettings(ctx, invoice.UserID)
+	if err != nil {
+		svc.Logger.Errorf("Could not find tier user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
+		return err
+	}
 
 	// Process any update in a DB transaction
 	tx, err := svc.DB.BeginTx(ctx, &sql.TxOptions{})
@@ -67,12 +93,24 @@ func (svc *LndhubService) ProcessInvoiceUpdate(ctx context.Context, rawInvoice *
 		// if the invoice is settled we update the state and create an transaction entry to the current account
 		invoice.SettledAt = bun.NullTime{Time: time.Unix(rawInvoice.SettleDate, 0)}
 		invoice.State = common.InvoiceStateSettled
-		_, err = tx.NewUpdate().Model(&invoice).WherePK().Exec(ctx)
+		invoice.Amount = settledAmount(invoice.Amount, rawInvoice.AmtPaidSat)
+		// amount-less invoices record the exact amount the payer sent
+		if invoice.AmountMsat == 0 {
+			invoice.AmountMsat = rawInvoice.AmtPaidMsat
+		}
+		invoice.ServiceFee = tier.IncomingServiceFeeFor(invoice.Amount)
+		// The state condition makes sure concurrent updates, e.g. of the subscription and the reconciler, settle only once
+		res, err := tx.NewUpdate().Model(&invoice).WherePK().Where("state <> ?", common.InvoiceStateSettled).Exec(ctx)
 		if err != nil {
 			tx.Rollback()
 			svc.Logger.Errorf("Could not update invoice invoice_id:%v", invoice.ID)
 			return err
 		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			tx.Rollback()
+			svc.Logger.Infof("Invoice already settled. Ignoring. invoice_id:%v", invoice.ID)
+			return nil
+		}
 
 		// Transfer the amount from the user's incoming account to the user's current account
 		entry := models.TransactionEntry{
@@ -90,6 +128,18 @@ func (svc *LndhubService) ProcessInvoiceUpdate(ctx context.Context, rawInvoice *
 			svc.Logger.Errorf("Could not create incoming->current transaction user_id:%v invoice_id:%v  %v", invoice.UserID, invoice.ID, err)
 			return err
 		}
+		err = svc.insertServiceFeeEntry(ctx, tx, &invoice, creditAccount.ID, entry.ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		// The indexes are stored with the settlement so a restart resumes exactly after the last processed update
+		err = svc.saveInvoiceSubscriptionIndexes(ctx, tx, rawInvoice)
+		if err != nil {
+			tx.Rollback()
+			svc.Logger.Errorf("Could not save invoice subscription indexes invoice_id:%v %v", invoice.ID, err)
+			return err
+		}
 	}
 	// Commit the DB transaction. Done, everything worked
 	err = tx.Commit()
@@ -100,10 +150,29 @@ func (svc *LndhubService) ProcessInvoiceUpdate(ctx context.Context, rawInvoice *
 	if sub, ok := svc.InvoiceSubscribers[invoice.UserID]; ok {
 		sub <- invoice
 	}
+	if invoice.State == common.InvoiceStateSettled {
+		svc.onInvoiceSettled(ctx, &invoice)
+	}
 
 	return nil
 }
 
+// saveInvoiceSubscriptionIndexes stores the indexes of a processed invoice update, the stored indexes never decrease
+func (svc *LndhubService) saveInvoiceSubscriptionIndexes(ctx context.Context, db bun.IDB, rawInvoice *lnrpc.Invoice) error {
+	state := models.InvoiceSubscriptionState{
+		NodePubkey:  svc.IdentityPubkey,
+		AddIndex:    rawInvoice.AddIndex,
+		SettleIndex: rawInvoice.SettleIndex,
+		UpdatedAt:   time.Now(),
+	}
+	_, err := onConflictUpdate(db, db.NewInsert().Model(&state), "node_pubkey").
+		Set("add_index = GREATEST(" + existing(db, "invoice_subscription_state", "add_index") + ", " + excluded(db, "add_index") + ")").
+		Set("settle_index = GREATEST(" + existing(db, "invoice_subscription_state", "settle_index") + ", " + excluded(db, "settle_index") + ")").
+		Set("updated_at = " + excluded(db, "updated_at")).
+		Exec(ctx)
+	return err
+}
+
 func (svc *LndhubService) ConnectInvoiceSubscription(ctx context.Context) (lnd.SubscribeInvoicesWrapper, error) {
 	var invoice models.Invoice
 	invoiceSubscriptionOptions := lnrpc.InvoiceSubscription{}
@@ -113,28 +182,74 @@ func (svc *LndhubService) ConnectInvoiceSubscription(ctx context.Context) (lnd.S
 	if err == nil {
 		invoiceSubscriptionOptions = lnrpc.InvoiceSubscription{AddIndex: invoice.AddIndex - 1} // -1 because we want updates for that invoice already
 	}
-	svc.Logger.Infof("Starting invoice subscription from index: %v", invoiceSubscriptionOptions.AddIndex)
+	// Invoices settled after the last processed settle index are replayed, including those settled while lndhub was down
+	var state models.InvoiceSubscriptionState
+	err = svc.DB.NewSelect().Model(&state).Where("node_pubkey = ?", svc.IdentityPubkey).Limit(1).Scan(ctx)
+	if err == nil {
+		invoiceSubscriptionOptions.SettleIndex = state.SettleIndex
+	}
+	svc.Logger.Infof("Starting invoice subscription from add index: %v settle index: %v", invoiceSubscriptionOptions.AddIndex, invoiceSubscriptionOptions.SettleIndex)
 	return svc.LndClient.SubscribeInvoices(ctx, &invoiceSubscriptionOptions)
 }
 
+const (
+	invoiceSubscriptionBaseBackoff = time.Second
+	invoiceSubscriptionMaxBackoff  = time.Minute
+)
+
+// invoiceSubscriptionHealth is exported by expvar, connected is 0 while the subscription reconnects
+var invoiceSubscriptionHealth = expvar.NewMap("invoice_subscription")
+
+func init() {
+	invoiceSubscriptionHealth.Set("connected", new(expvar.Int))
+	invoiceSubscriptionHealth.Set("reconnects", new(expvar.Int))
+	invoiceSubscriptionHealth.Set("connected_since", new(expvar.String))
+	invoiceSubscriptionHealth.Set("last_error", new(expvar.String))
+}
+
+// InvoiceUpdateSubscription processes invoice updates until the context is canceled
+// The subscription reconnects with exponential backoff if the stream fails, e.g. during an LND restart,
+// and resumes from the oldest open invoice so no settlement is missed
 func (svc *LndhubService) InvoiceUpdateSubscription(ctx context.Context) error {
-	invoiceSubscriptionStream, err := svc.ConnectInvoiceSubscription(ctx)
-	if err != nil {
+	attempts := 0
+	for {
+		connectedAt := time.Now()
+		invoiceSubscriptionStream, err := svc.ConnectInvoiceSubscription(ctx)
+		if err == nil {
+			invoiceSubscriptionHealth.Get("connected").(*expvar.Int).Set(1)
+			invoiceSubscriptionHealth.Get("connected_since").(*expvar.String).Set(connectedAt.Format(time.RFC3339))
+			err = svc.processInvoiceUpdates(ctx, invoiceSubscriptionStream)
+		}
+		invoiceSubscriptionHealth.Get("connected").(*expvar.Int).Set(0)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		invoiceSubscriptionHealth.Get("last_error").(*expvar.String).Set(err.Error())
+		invoiceSubscriptionHealth.Add("reconnects", 1)
+
+		// a subscription that was up for a while starts over with the shortest backoff
+		if time.Since(connectedAt) > invoiceSubscriptionMaxBackoff {
+			attempts = 0
+		}
+		attempts++
+		backoff := exponentialBackoff(invoiceSubscriptionBaseBackoff, invoiceSubscriptionMaxBackoff, attempts)
+		svc.Logger.Errorf("Invoice subscription failed, reconnecting in %v attempt:%v %v", backoff, attempts, err)
 		sentry.CaptureException(err)
-		return err
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
 	}
+}
+
+// processInvoiceUpdates processes the updates of the stream until receiving fails
+func (svc *LndhubService) processInvoiceUpdates(ctx context.Context, invoiceSubscriptionStream lnd.SubscribeInvoicesWrapper) error {
 	for {
 		// receive the next invoice update
 		rawInvoice, err := invoiceSubscriptionStream.Recv()
 		if err != nil {
-			svc.Logger.Errorf("Error processing invoice update subscription: %v", err)
-			sentry.CaptureException(err)
-			// TODO: close the stream somehoe before retrying?
-			// Wait 30 seconds and try to reconnect
-			// TODO: implement some backoff
-			time.Sleep(30 * time.Second)
-			invoiceSubscriptionStream, _ = svc.ConnectInvoiceSubscription(ctx)
-			continue
+			return err
 		}
 
 		// Ignore updates for open invoices
@@ -0,0 +1,14 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettledAmount(t *testing.T) {
+	assert.Equal(t, int64(1000), settledAmount(1000, 1000))
+	// fixed amount invoices are credited with their amount even if the payer sent more
+	assert.Equal(t, int64(1000), settledAmount(1000, 1200))
+	assert.Equal(t, int64(2100), settledAmount(0, 2100))
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/uptrace/bun"
+)
+
+const (
+	jobPollInterval = time.Second
+	jobBaseBackoff  = 5 * time.Second
+	jobMaxBackoff   = time.Hour
+	jobBatchSize    = 50
+)
+
+// jobHandlers run the jobs of the persistent queue by job type
+var jobHandlers = map[string]func(svc *LndhubService, ctx context.Context, payload []byte) error{
+	common.JobTypeInvoiceSettled: handleInvoiceSettledJob,
+}
+
+// prism payouts settle invoices, which queues jobs again, their handler is registered in init to avoid an initialization cycle
+func init() {
+	jobHandlers[common.JobTypePrismSplits] = handlePrismSplitsJob
+}
+
+type InvoiceJobPayload struct {
+	InvoiceID int64 `json:"invoice_id"`
+}
+
+// EnqueueJob persists a job, the job worker runs it in the background
+func (svc *LndhubService) EnqueueJob(ctx context.Context, jobType string, payload interface{}) error {
+	if _, ok := jobHandlers[jobType]; !ok {
+		return fmt.Errorf("unknown job type: %s", jobType)
+	}
+	encodedPayload, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	job := models.Job{
+		Type:    jobType,
+		Payload: string(encodedPayload),
+		State:   common.JobStatePending,
+		RunAt:   time.Now(),
+	}
+	_, err = svc.DB.NewInsert().Model(&job).Exec(ctx)
+	if err != nil {
+		svc.Logger.Errorf("Could not enqueue job type:%s %v", jobType, err)
+	}
+	return err
+}
+
+// StartJobWorker runs due jobs until the context is canceled
+func (svc *LndhubService) StartJobWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := svc.RunDueJobs(ctx); err != nil {
+				svc.Logger.Errorf("Error running jobs: %v", err)
+				sentry.CaptureException(err)
+			}
+		}
+	}
+}
+
+func (svc *LndhubService) RunDueJobs(ctx context.Context) error {
+	jobs := []models.Job{}
+	err := svc.DB.NewSelect().Model(&jobs).
+		Where("state = ? AND run_at <= ?", common.JobStatePending, time.Now()).
+		OrderExpr("id ASC").Limit(jobBatchSize).Scan(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		svc.runJob(ctx, &jobs[i])
+	}
+	return nil
+}
+
+func (svc *LndhubService) runJob(ctx context.Context, job *models.Job) {
+	// claim the job so concurrent workers of other instances skip it
+	res, err := svc.DB.NewUpdate().Model(job).
+		Set("state = ?", common.JobStateRunning).
+		Set("updated_at = current_timestamp").
+		Where("id = ? AND state = ?", job.ID, common.JobStatePending).
+		Exec(ctx)
+	if err != nil {
+		svc.Logger.Errorf("Could not claim job job_id:%v %v", job.ID, err)
+		return
+	}
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return
+	}
+
+	handler := jobHandlers[job.Type]
+	if handler == nil {
+		err = fmt.Errorf("unknown job type: %s", job.Type)
+	} else {
+		err = handler(svc, ctx, []byte(job.Payload))
+	}
+	job.Attempts++
+
+	if err == nil {
+		job.State = common.JobStateCompleted
+		job.CompletedAt = bun.NullTime{Time: time.Now()}
+	} else {
+		job.LastError = err.Error()
+		if job.Attempts >= svc.Config.JobMaxAttempts {
+			job.State = common.JobStateFailed
+			svc.Logger.Errorf("Job failed job_id:%v type:%s attempts:%v error:%v", job.ID, job.Type, job.Attempts, err)
+			sentry.CaptureException(err)
+		} else {
+			job.State = common.JobStatePending
+			job.RunAt = time.Now().Add(exponentialBackoff(jobBaseBackoff, jobMaxBackoff, job.Attempts))
+		}
+	}
+	if _, err := svc.DB.NewUpdate().Model(job).WherePK().Exec(ctx); err != nil {
+		svc.Logger.Errorf("Could not update job job_id:%v %v", job.ID, err)
+	}
+}
+
+// onInvoiceSettled runs the side effects of a settled incoming invoice
+// With SETTLEMENT_QUEUE enabled they are queued as a job instead of running on the settlement path
+func (svc *LndhubService) onInvoiceSettled(ctx context.Context, invoice *models.Invoice) {
+	if err := svc.countClosedInvoice(ctx, invoice); err != nil {
+		svc.Logger.Errorf("Could not count settled invoice invoice_id:%v %v", invoice.ID, err)
+	}
+	// splits pay other users and nodes, they always run in the background and never on the settlement path
+	if err := svc.enqueuePrismSplits(ctx, invoice); err != nil {
+		svc.Logger.Errorf("Could not enqueue prism splits invoice_id:%v %v", invoice.ID, err)
+	}
+	if svc.Config.SettlementQueue {
+		svc.EnqueueJob(ctx, common.JobTypeInvoiceSettled, &InvoiceJobPayload{InvoiceID: invoice.ID})
+		return
+	}
+	if err := svc.invoiceSettledSideEffects(ctx, invoice); err != nil {
+		svc.Logger.Errorf("Settlement side effects failed invoice_id:%v %v", invoice.ID, err)
+	}
+}
+
+func (svc *LndhubService) invoiceSettledSideEffects(ctx context.Context, invoice *models.Invoice) error {
+	svc.publishZapReceipt(invoice)
+	if err := svc.recurringInvoiceSettled(ctx, invoice); err != nil {
+		svc.Logger.Errorf("Could not check recurring invoice invoice_id:%v %v", invoice.ID, err)
+	}
+	if err := svc.EnqueueInvoiceWebhook(ctx, invoice.WebhookUrl, common.WebhookEventInvoiceSettled, invoice); err != nil {
+		return err
+	}
+	return svc.EnqueueInvoiceWebhook(ctx, svc.Config.WebhookUrl, common.WebhookEventInvoiceSettled, invoice)
+}
+
+func handleInvoiceSettledJob(svc *LndhubService, ctx context.Context, payload []byte) error {
+	var jobPayload InvoiceJobPayload
+	if err := json.Unmarshal(payload, &jobPayload); err != nil {
+		return err
+	}
+	var invoice models.Invoice
+	err := svc.DB.NewSelect().Model(&invoice).Where("id = ?", jobPayload.InvoiceID).Limit(1).Scan(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.invoiceSettledSideEffects(ctx, &invoice)
+}
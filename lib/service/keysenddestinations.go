@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+)
+
+// KeysendDestinationStats aggregates the settled keysend payments of a user to one destination
+type KeysendDestinationStats struct {
+	DestinationPubkeyHex string `bun:"destination_pubkey_hex"`
+	PaymentCount         int64  `bun:"payment_count"`
+	TotalAmount          int64  `bun:"total_amount"`
+	TotalFees            int64  `bun:"total_fees"`
+}
+
+// SaveKeysendDestination creates a named destination or updates the existing one with the same name
+func (svc *LndhubService) SaveKeysendDestination(ctx context.Context, userId int64, name, pubkeyHex string, customRecords map[string]string) (*models.KeysendDestination, error) {
+	destination := &models.KeysendDestination{
+		UserID:               userId,
+		Name:                 name,
+		DestinationPubkeyHex: pubkeyHex,
+		CustomRecords:        customRecords,
+	}
+	_, err := onConflictUpdate(svc.DB, svc.DB.NewInsert().Model(destination), "user_id, name").
+		Set("destination_pubkey_hex = " + excluded(svc.DB, "destination_pubkey_hex")).
+		Set("custom_records = " + excluded(svc.DB, "custom_records")).
+		Set("updated_at = current_timestamp").
+		Returning("*").
+		Exec(ctx)
+	if err == nil && isMySQL(svc.DB) {
+		// MySQL returns no row for an upsert that updated the existing row
+		err = svc.DB.NewSelect().Model(destination).Where("user_id = ? AND name = ?", destination.UserID, destination.Name).Limit(1).Scan(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return destination, nil
+}
+
+func (svc *LndhubService) FindKeysendDestination(ctx context.Context, userId int64, name string) (*models.KeysendDestination, error) {
+	var destination models.KeysendDestination
+	err := svc.DB.NewSelect().Model(&destination).Where("user_id = ? AND name = ?", userId, name).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &destination, nil
+}
+
+func (svc *LndhubService) KeysendDestinationsFor(ctx context.Context, userId int64) ([]models.KeysendDestination, error) {
+	destinations := []models.KeysendDestination{}
+	err := svc.DB.NewSelect().Model(&destinations).Where("user_id = ?", userId).OrderExpr("name ASC").Scan(ctx)
+	return destinations, err
+}
+
+func (svc *LndhubService) DeleteKeysendDestination(ctx context.Context, userId int64, name string) error {
+	_, err := svc.DB.NewDelete().Model((*models.KeysendDestination)(nil)).Where("user_id = ? AND name = ?", userId, name).Exec(ctx)
+	return err
+}
+
+// KeysendStatsFor returns the settled keysend payment stats of a user grouped by destination
+func (svc *LndhubService) KeysendStatsFor(ctx context.Context, userId int64) (map[string]KeysendDestinationStats, error) {
+	stats := []KeysendDestinationStats{}
+	err := svc.DB.NewSelect().
+		TableExpr("invoices").
+		ColumnExpr("destination_pubkey_hex").
+		ColumnExpr("COUNT(*) AS payment_count").
+		ColumnExpr("COALESCE(SUM(amount), 0) AS total_amount").
+		ColumnExpr("COALESCE(SUM(fee), 0) AS total_fees").
+		Where("user_id = ? AND type = ? AND keysend = ? AND state = ?", userId, common.InvoiceTypeOutgoing, true, common.InvoiceStateSettled).
+		GroupExpr("destination_pubkey_hex").
+		Scan(ctx, &stats)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]KeysendDestinationStats{}
+	for _, s := range stats {
+		result[s.DestinationPubkeyHex] = s
+	}
+	return result, nil
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/getAlby/lndhub.go/db/models"
+)
+
+// MaxLabelLength is the maximum number of characters of a transaction label
+const MaxLabelLength = 255
+
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
+// SetInvoiceLabel sets the label of the user's invoices with the payment hash, an empty label removes it
+// The label applies to all invoices of the payment hash, e.g. to the failed attempts of a payment as well
+func (svc *LndhubService) SetInvoiceLabel(ctx context.Context, userId int64, rHash, label string) (string, error) {
+	label = SanitizeMemo(label, MaxLabelLength)
+	res, err := svc.DB.NewUpdate().Model((*models.Invoice)(nil)).
+		Set("label = ?", nullIfEmpty(label)).
+		Set("updated_at = current_timestamp").
+		Where("user_id = ? AND r_hash = ?", userId, strings.ToLower(rHash)).
+		Exec(ctx)
+	if err != nil {
+		return "", err
+	}
+	if updated, _ := res.RowsAffected(); updated == 0 {
+		return "", ErrInvoiceNotFound
+	}
+	return label, nil
+}
+
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
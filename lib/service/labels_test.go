@@ -0,0 +1,16 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelValues(t *testing.T) {
+	assert.Nil(t, nullIfEmpty(""))
+	assert.Equal(t, "groceries", nullIfEmpty("groceries"))
+	// labels are sanitized like memos, an empty label after sanitizing removes the label
+	assert.Nil(t, nullIfEmpty(SanitizeMemo(" ‮\n", MaxLabelLength)))
+	assert.Len(t, []rune(SanitizeMemo(strings.Repeat("ä", 300), MaxLabelLength)), MaxLabelLength)
+}
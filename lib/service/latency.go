@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	PaymentStageDecode          = "decode"
+	PaymentStageBalanceCheck    = "balance_check"
+	PaymentStageChecks          = "checks"
+	PaymentStageLedgerInsert    = "ledger_insert"
+	PaymentStageLndRpc          = "lnd_rpc"
+	PaymentStageInternalPayment = "internal_payment"
+	PaymentStageBookkeeping     = "settlement_bookkeeping"
+)
+
+// latency buckets in milliseconds of the exported stage histograms
+var paymentStageBuckets = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+// paymentStageMetrics is exported by expvar as cumulative histograms per stage, e.g.
+// {"lnd_rpc": {"count": 3, "sum_ms": 2150, "le_1000": 1, "le_2500": 3, ..., "le_inf": 3}}
+var paymentStageMetrics = expvar.NewMap("payment_stage_latency")
+var paymentStageMetricsLock sync.Mutex
+
+type paymentTimerKey struct{}
+
+// PaymentStageTiming is the duration of one stage of the payment critical path
+type PaymentStageTiming struct {
+	Stage      string  `json:"stage"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// PaymentTimer measures the stages of one payment
+type PaymentTimer struct {
+	mu     sync.Mutex
+	last   time.Time
+	Stages []PaymentStageTiming
+}
+
+// PaymentTimerFromContext returns the payment timer of the context or adds a new one
+func PaymentTimerFromContext(ctx context.Context) (context.Context, *PaymentTimer) {
+	if timer, ok := ctx.Value(paymentTimerKey{}).(*PaymentTimer); ok {
+		return ctx, timer
+	}
+	timer := &PaymentTimer{last: time.Now()}
+	return context.WithValue(ctx, paymentTimerKey{}, timer), timer
+}
+
+func paymentTimer(ctx context.Context) *PaymentTimer {
+	timer, _ := ctx.Value(paymentTimerKey{}).(*PaymentTimer)
+	return timer
+}
+
+// Mark records the time since the previous mark as the duration of the stage
+// Marking on a nil timer is a no-op so the service can be used without timing
+func (t *PaymentTimer) Mark(stage string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	now := time.Now()
+	duration := now.Sub(t.last)
+	t.last = now
+	t.Stages = append(t.Stages, PaymentStageTiming{Stage: stage, DurationMs: float64(duration.Microseconds()) / 1000})
+	t.mu.Unlock()
+	observePaymentStage(stage, duration)
+}
+
+// Timings returns a copy of the recorded stages
+func (t *PaymentTimer) Timings() []PaymentStageTiming {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]PaymentStageTiming{}, t.Stages...)
+}
+
+func observePaymentStage(stage string, duration time.Duration) {
+	paymentStageMetricsLock.Lock()
+	defer paymentStageMetricsLock.Unlock()
+	histogram, ok := paymentStageMetrics.Get(stage).(*expvar.Map)
+	if !ok {
+		histogram = new(expvar.Map).Init()
+		paymentStageMetrics.Set(stage, histogram)
+	}
+	ms := duration.Milliseconds()
+	histogram.Add("count", 1)
+	histogram.Add("sum_ms", ms)
+	for _, bucket := range paymentStageBuckets {
+		if ms <= bucket {
+			histogram.Add("le_"+strconv.FormatInt(bucket, 10), 1)
+		}
+	}
+	histogram.Add("le_inf", 1)
+}
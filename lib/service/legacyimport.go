@@ -0,0 +1,477 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/preimage"
+	"github.com/getAlby/lndhub.go/lib/security"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/schema"
+)
+
+// The Node.js LndHub keeps its state in Redis, the import reads a dump of its keys with one JSON object per line:
+// {"key": "balance_for_<userid>", "type": "string", "value": "1000"}
+// {"key": "txs_for_<userid>", "type": "list", "value": ["{...}", "{...}"]}
+
+// legacyMissingPreimage is stored for settled invoices when the dump has no preimage for them
+const legacyMissingPreimage = "0000000000000000000000000000000000000000000000000000000000000000"
+
+const legacyAdjustmentMemo = "Balance imported from LndHub"
+
+var legacyNetworks = []*chaincfg.Params{
+	&chaincfg.MainNetParams,
+	&chaincfg.TestNet3Params,
+	&chaincfg.RegressionNetParams,
+	&chaincfg.SimNetParams,
+}
+
+type LegacyImportReport struct {
+	Users          int   `json:"users"`
+	SkippedUsers   int   `json:"skipped_users"`   // logins that already exist
+	Invoices       int   `json:"invoices"`        // incoming invoices
+	Payments       int   `json:"payments"`        // settled outgoing payments
+	SkippedRecords int   `json:"skipped_records"` // invoices and payments that could not be decoded
+	Balance        int64 `json:"balance"`         // sum of the imported balances
+	Adjustments    int64 `json:"adjustments"`     // sum of the entries booked to match the LndHub balances
+}
+
+type legacyDumpRecord struct {
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type legacyUser struct {
+	Login        string
+	PasswordHash string // hex encoded sha256 of the password
+	LegacyID     string
+}
+
+type legacyDump struct {
+	users   []legacyUser
+	strings map[string]string
+	lists   map[string][]string
+}
+
+// legacyNumber is a number LndHub stores either as a JSON number or as a string
+type legacyNumber int64
+
+func (n *legacyNumber) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*n = legacyNumber(v)
+	return nil
+}
+
+// legacyBytes is a hash or preimage stored as a Node.js Buffer, a hex string or a base64 string
+type legacyBytes string
+
+func (h *legacyBytes) UnmarshalJSON(b []byte) error {
+	var buf struct {
+		Data []byte `json:"data"`
+	}
+	var s string
+	switch {
+	case json.Unmarshal(b, &s) == nil:
+		if _, err := hex.DecodeString(s); err == nil && len(s) == 2*preimage.Size {
+			*h = legacyBytes(strings.ToLower(s))
+		} else if decoded, err := base64.StdEncoding.DecodeString(s); err == nil {
+			*h = legacyBytes(hex.EncodeToString(decoded))
+		}
+	case json.Unmarshal(b, &buf) == nil:
+		if len(buf.Data) > 0 {
+			*h = legacyBytes(hex.EncodeToString(buf.Data))
+		}
+	}
+	return nil
+}
+
+type legacyInvoice struct {
+	PaymentRequest string       `json:"payment_request"`
+	PayReq         string       `json:"pay_req"`
+	AddIndex       legacyNumber `json:"add_index"`
+}
+
+type legacyTransaction struct {
+	Type            string       `json:"type"`
+	Value           legacyNumber `json:"value"`
+	Fee             legacyNumber `json:"fee"`
+	Timestamp       legacyNumber `json:"timestamp"`
+	Memo            string       `json:"memo"`
+	PayReq          string       `json:"pay_req"`
+	PaymentError    string       `json:"payment_error"`
+	PaymentPreimage legacyBytes  `json:"payment_preimage"`
+	PaymentRoute    *struct {
+		TotalAmt  legacyNumber `json:"total_amt"`
+		TotalFees legacyNumber `json:"total_fees"`
+	} `json:"payment_route"`
+	Decoded *struct {
+		NumSatoshis legacyNumber `json:"num_satoshis"`
+	} `json:"decoded"`
+}
+
+// parseLegacyDump reads the users, strings and lists of a Redis dump of the Node.js LndHub
+func parseLegacyDump(r io.Reader) (*legacyDump, error) {
+	dump := &legacyDump{strings: map[string]string{}, lists: map[string][]string{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		record := legacyDumpRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		switch record.Type {
+		case "list":
+			values := []string{}
+			if err := json.Unmarshal(record.Value, &values); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			dump.lists[record.Key] = values
+		case "string", "":
+			var value string
+			if err := json.Unmarshal(record.Value, &value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			dump.strings[record.Key] = value
+			if user, ok := parseLegacyUserKey(record.Key, value); ok {
+				dump.users = append(dump.users, user)
+			}
+		default:
+			// LndHub only uses strings and lists for the keys we import
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(dump.users, func(i, j int) bool { return dump.users[i].Login < dump.users[j].Login })
+	return dump, nil
+}
+
+// parseLegacyUserKey parses the user_<login>_<sha256 of the password> keys, their value is the id of the user
+func parseLegacyUserKey(key, value string) (legacyUser, bool) {
+	if !strings.HasPrefix(key, "user_") {
+		return legacyUser{}, false
+	}
+	rest := strings.TrimPrefix(key, "user_")
+	i := strings.LastIndex(rest, "_")
+	if i <= 0 || value == "" {
+		return legacyUser{}, false
+	}
+	login, hash := rest[:i], rest[i+1:]
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+		return legacyUser{}, false
+	}
+	return legacyUser{Login: login, PasswordHash: hash, LegacyID: value}, true
+}
+
+func decodeLegacyPaymentRequest(paymentRequest string) (*zpay32.Invoice, error) {
+	var err error
+	for _, network := range legacyNetworks {
+		var decoded *zpay32.Invoice
+		if decoded, err = zpay32.Decode(paymentRequest, network); err == nil {
+			return decoded, nil
+		}
+	}
+	return nil, err
+}
+
+func legacyInvoiceFields(invoice *models.Invoice, paymentRequest string, decoded *zpay32.Invoice) {
+	invoice.PaymentRequest = paymentRequest
+	invoice.RHash = hex.EncodeToString(decoded.PaymentHash[:])
+	if decoded.MilliSat != nil {
+		invoice.Amount = int64(decoded.MilliSat.ToSatoshis())
+	}
+	if decoded.Description != nil {
+		invoice.Memo = *decoded.Description
+	}
+	if decoded.DescriptionHash != nil {
+		invoice.DescriptionHash = hex.EncodeToString(decoded.DescriptionHash[:])
+	}
+	invoice.DestinationPubkeyHex = hex.EncodeToString(decoded.Destination.SerializeCompressed())
+	invoice.CreatedAt = decoded.Timestamp
+	invoice.ExpiresAt = bun.NullTime{Time: decoded.Timestamp.Add(decoded.Expiry())}
+	invoice.Metadata = map[string]string{common.InvoiceMetadataSource: common.InvoiceSourceLndhubImport}
+}
+
+// legacyIncomingInvoice converts an entry of userinvoices_for_<userid>
+func legacyIncomingInvoice(dump *legacyDump, raw string, now time.Time) (*models.Invoice, error) {
+	legacy := legacyInvoice{}
+	if err := json.Unmarshal([]byte(raw), &legacy); err != nil {
+		return nil, err
+	}
+	paymentRequest := legacy.PaymentRequest
+	if paymentRequest == "" {
+		paymentRequest = legacy.PayReq
+	}
+	decoded, err := decodeLegacyPaymentRequest(paymentRequest)
+	if err != nil {
+		return nil, err
+	}
+	invoice := &models.Invoice{Type: common.InvoiceTypeIncoming, AddIndex: uint64(legacy.AddIndex)}
+	legacyInvoiceFields(invoice, paymentRequest, decoded)
+
+	// ispaid_ holds the received amount, older LndHub versions only stored true
+	paid, ok := dump.strings["ispaid_"+invoice.RHash]
+	switch {
+	case ok && paid != "" && paid != "0" && paid != "false":
+		if amount, err := strconv.ParseInt(paid, 10, 64); err == nil && amount > 1 && invoice.Amount == 0 {
+			invoice.Amount = amount
+		}
+		if invoice.Amount <= 0 {
+			return nil, fmt.Errorf("unknown amount of paid invoice %s", invoice.RHash)
+		}
+		invoice.State = common.InvoiceStateSettled
+		invoice.SettledAt = schema.NullTime{Time: invoice.CreatedAt}
+		invoice.Preimage = dump.strings["preimage_for_"+invoice.RHash]
+		if invoice.Preimage == "" {
+			invoice.Preimage = legacyMissingPreimage
+		}
+	case invoice.ExpiresAt.Before(now):
+		invoice.State = common.InvoiceStateExpired
+	default:
+		// the invoice is still open on the node, the invoice subscription settles it when it is paid
+		invoice.State = common.InvoiceStateOpen
+	}
+	return invoice, nil
+}
+
+// legacyPayment converts an entry of txs_for_<userid>, internal payments have the type paid_invoice
+func legacyPayment(dump *legacyDump, raw string) (*models.Invoice, error) {
+	legacy := legacyTransaction{}
+	if err := json.Unmarshal([]byte(raw), &legacy); err != nil {
+		return nil, err
+	}
+	if legacy.PaymentError != "" {
+		return nil, errors.New(legacy.PaymentError)
+	}
+	decoded, err := decodeLegacyPaymentRequest(legacy.PayReq)
+	if err != nil {
+		return nil, err
+	}
+	invoice := &models.Invoice{Type: common.InvoiceTypeOutgoing, State: common.InvoiceStateSettled}
+	legacyInvoiceFields(invoice, legacy.PayReq, decoded)
+	invoice.ExpiresAt = bun.NullTime{}
+	if legacy.Type == common.InvoiceTypePaid {
+		// the value of internal payments includes the fee
+		invoice.Internal = true
+		invoice.Fee = int64(legacy.Fee)
+		invoice.Amount = int64(legacy.Value) - invoice.Fee
+		if legacy.Memo != "" {
+			invoice.Memo = legacy.Memo
+		}
+		if legacy.Timestamp > 0 {
+			invoice.CreatedAt = time.Unix(int64(legacy.Timestamp), 0)
+		}
+	} else if legacy.PaymentRoute != nil {
+		invoice.Fee = int64(legacy.PaymentRoute.TotalFees)
+		invoice.Amount = int64(legacy.PaymentRoute.TotalAmt) - invoice.Fee
+	}
+	if invoice.Amount <= 0 && legacy.Decoded != nil {
+		invoice.Amount = int64(legacy.Decoded.NumSatoshis)
+	}
+	if invoice.Amount <= 0 || invoice.Fee < 0 {
+		return nil, fmt.Errorf("invalid amount of payment %s", invoice.RHash)
+	}
+	invoice.SettledAt = schema.NullTime{Time: invoice.CreatedAt}
+	invoice.Preimage = string(legacy.PaymentPreimage)
+	if invoice.Preimage == "" {
+		invoice.Preimage = dump.strings["preimage_for_"+invoice.RHash]
+	}
+	if invoice.Preimage == "" {
+		invoice.Preimage = legacyMissingPreimage
+	}
+	return invoice, nil
+}
+
+// ImportLegacyLndhub imports the users, balances and invoice history of a Redis dump of the Node.js LndHub
+// Users that already exist are skipped, each user is imported in its own transaction
+// The difference between the imported history and the LndHub balance is booked as a settled adjustment invoice
+func (svc *LndhubService) ImportLegacyLndhub(ctx context.Context, r io.Reader) (*LegacyImportReport, error) {
+	dump, err := parseLegacyDump(r)
+	if err != nil {
+		return nil, err
+	}
+	report := &LegacyImportReport{}
+	for _, legacy := range dump.users {
+		exists, err := svc.DB.NewSelect().Model((*models.User)(nil)).Where("login = ?", legacy.Login).Exists(ctx)
+		if err != nil {
+			return report, err
+		}
+		if exists {
+			svc.Logger.Infof("Skipping existing LndHub user login:%s", legacy.Login)
+			report.SkippedUsers++
+			continue
+		}
+		err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+			return svc.importLegacyUser(ctx, tx, dump, legacy, report)
+		})
+		if err != nil {
+			return report, fmt.Errorf("importing LndHub user %s: %w", legacy.Login, err)
+		}
+		report.Users++
+	}
+	return report, nil
+}
+
+func (svc *LndhubService) importLegacyUser(ctx context.Context, tx bun.Tx, dump *legacyDump, legacy legacyUser, report *LegacyImportReport) error {
+	user := &models.User{Login: legacy.Login, Password: security.LegacyPasswordHash(legacy.PasswordHash)}
+	if err := insertUser(ctx, tx, user); err != nil {
+		return err
+	}
+	accounts := []models.Account{}
+	if err := tx.NewSelect().Model(&accounts).Where("user_id = ?", user.ID).Scan(ctx); err != nil {
+		return err
+	}
+	accountIDs := map[string]int64{}
+	for _, account := range accounts {
+		accountIDs[account.Type] = account.ID
+	}
+
+	now := time.Now()
+	incoming := []*models.Invoice{}
+	outgoing := []*models.Invoice{}
+	history := int64(0)
+	for _, raw := range dump.lists["userinvoices_for_"+legacy.LegacyID] {
+		invoice, err := legacyIncomingInvoice(dump, raw, now)
+		if err != nil {
+			svc.Logger.Warnf("Skipping LndHub invoice login:%s: %v", legacy.Login, err)
+			report.SkippedRecords++
+			continue
+		}
+		if invoice.State == common.InvoiceStateSettled {
+			history += invoice.Amount
+		}
+		incoming = append(incoming, invoice)
+	}
+	for _, raw := range dump.lists["txs_for_"+legacy.LegacyID] {
+		invoice, err := legacyPayment(dump, raw)
+		if err != nil {
+			svc.Logger.Warnf("Skipping LndHub payment login:%s: %v", legacy.Login, err)
+			report.SkippedRecords++
+			continue
+		}
+		history -= invoice.Amount + invoice.Fee
+		outgoing = append(outgoing, invoice)
+	}
+
+	// the balance is checked after every entry, a positive adjustment is booked first and a negative one last
+	balance := history
+	if legacyBalance, ok := dump.strings["balance_for_"+legacy.LegacyID]; ok {
+		parsed, err := strconv.ParseFloat(legacyBalance, 64)
+		if err != nil {
+			return fmt.Errorf("invalid balance %q: %w", legacyBalance, err)
+		}
+		balance = int64(parsed)
+	}
+	if balance < 0 {
+		balance = 0
+	}
+	adjustment := balance - history
+
+	book := func(invoice *models.Invoice, debitAccount, creditAccount string, parentID int64, amount int64) (int64, error) {
+		entry := models.TransactionEntry{
+			UserID:          user.ID,
+			InvoiceID:       invoice.ID,
+			ParentID:        parentID,
+			DebitAccountID:  accountIDs[debitAccount],
+			CreditAccountID: accountIDs[creditAccount],
+			Amount:          amount,
+			CreatedAt:       invoice.CreatedAt,
+		}
+		_, err := tx.NewInsert().Model(&entry).Exec(ctx)
+		return entry.ID, err
+	}
+	bookAdjustment := func(invoiceType, debitAccount, creditAccount string, amount int64) error {
+		invoicePreimage, err := preimage.New()
+		if err != nil {
+			return err
+		}
+		invoice := &models.Invoice{
+			Type:      invoiceType,
+			UserID:    user.ID,
+			Amount:    amount,
+			Memo:      legacyAdjustmentMemo,
+			RHash:     preimage.HashHex(invoicePreimage),
+			Preimage:  hex.EncodeToString(invoicePreimage),
+			Internal:  true,
+			State:     common.InvoiceStateSettled,
+			Metadata:  map[string]string{common.InvoiceMetadataSource: common.InvoiceSourceLndhubImport},
+			CreatedAt: now,
+			SettledAt: schema.NullTime{Time: now},
+		}
+		if _, err := tx.NewInsert().Model(invoice).Exec(ctx); err != nil {
+			return err
+		}
+		_, err = book(invoice, debitAccount, creditAccount, 0, amount)
+		return err
+	}
+
+	if adjustment > 0 {
+		if err := bookAdjustment(common.InvoiceTypeIncoming, common.AccountTypeIncoming, common.AccountTypeCurrent, adjustment); err != nil {
+			return err
+		}
+	}
+	for _, invoice := range incoming {
+		invoice.UserID = user.ID
+		if _, err := tx.NewInsert().Model(invoice).Exec(ctx); err != nil {
+			return err
+		}
+		report.Invoices++
+		if invoice.State != common.InvoiceStateSettled {
+			continue
+		}
+		if _, err := book(invoice, common.AccountTypeIncoming, common.AccountTypeCurrent, 0, invoice.Amount); err != nil {
+			return err
+		}
+	}
+	for _, invoice := range outgoing {
+		invoice.UserID = user.ID
+		if _, err := tx.NewInsert().Model(invoice).Exec(ctx); err != nil {
+			return err
+		}
+		report.Payments++
+		entryID, err := book(invoice, common.AccountTypeCurrent, common.AccountTypeOutgoing, 0, invoice.Amount)
+		if err != nil {
+			return err
+		}
+		if invoice.Fee > 0 {
+			if _, err := book(invoice, common.AccountTypeCurrent, common.AccountTypeFees, entryID, invoice.Fee); err != nil {
+				return err
+			}
+		}
+	}
+	if adjustment < 0 {
+		if err := bookAdjustment(common.InvoiceTypeOutgoing, common.AccountTypeCurrent, common.AccountTypeOutgoing, -adjustment); err != nil {
+			return err
+		}
+	}
+	report.Balance += balance
+	report.Adjustments += adjustment
+	return nil
+}
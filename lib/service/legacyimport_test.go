@@ -0,0 +1,112 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"github.com/stretchr/testify/assert"
+)
+
+func legacyTestPaymentRequest(t *testing.T, paymentHash [32]byte, sats int64, created time.Time) string {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	assert.NoError(t, err)
+	invoice, err := zpay32.NewInvoice(&chaincfg.RegressionNetParams, paymentHash, created,
+		zpay32.Amount(lnwire.MilliSatoshi(sats*1000)), zpay32.Description("coffee"))
+	assert.NoError(t, err)
+	paymentRequest, err := invoice.Encode(zpay32.MessageSigner{SignCompact: func(msg []byte) ([]byte, error) {
+		return btcec.SignCompact(btcec.S256(), key, chainhash.HashB(msg), true)
+	}})
+	assert.NoError(t, err)
+	return paymentRequest
+}
+
+func TestImportLegacyDump(t *testing.T) {
+	passwordHash := sha256.Sum256([]byte("password"))
+	created := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	paidHash := sha256.Sum256([]byte("paid"))
+	expiredHash := sha256.Sum256([]byte("expired"))
+	internalHash := sha256.Sum256([]byte("internal"))
+	externalHash := sha256.Sum256([]byte("external"))
+
+	line := func(key, keyType string, value interface{}) string {
+		b, err := json.Marshal(map[string]interface{}{"key": key, "type": keyType, "value": value})
+		assert.NoError(t, err)
+		return string(b)
+	}
+	item := func(value interface{}) string {
+		b, err := json.Marshal(value)
+		assert.NoError(t, err)
+		return string(b)
+	}
+	dump := strings.Join([]string{
+		line("user_alice_"+hex.EncodeToString(passwordHash[:]), "string", "legacy-id"),
+		line("balance_for_legacy-id", "string", "500"),
+		line("ispaid_"+hex.EncodeToString(paidHash[:]), "string", "1000"),
+		line("userinvoices_for_legacy-id", "list", []string{
+			item(map[string]interface{}{"payment_request": legacyTestPaymentRequest(t, paidHash, 1000, created), "add_index": "7"}),
+			item(map[string]interface{}{"pay_req": legacyTestPaymentRequest(t, expiredHash, 300, created)}),
+			"not json",
+		}),
+		line("txs_for_legacy-id", "list", []string{
+			item(map[string]interface{}{"type": "paid_invoice", "value": 101, "fee": 1, "timestamp": created.Unix(), "memo": "lunch", "pay_req": legacyTestPaymentRequest(t, internalHash, 100, created)}),
+			item(map[string]interface{}{
+				"payment_route":    map[string]interface{}{"total_amt": 205, "total_fees": 5},
+				"payment_preimage": map[string]interface{}{"type": "Buffer", "data": []int{1, 2, 3}},
+				"pay_req":          legacyTestPaymentRequest(t, externalHash, 200, created),
+			}),
+		}),
+		"",
+		line("user_bob_not-a-hash", "string", "other-id"),
+	}, "\n")
+
+	parsed, err := parseLegacyDump(strings.NewReader(dump))
+	assert.NoError(t, err)
+	assert.Len(t, parsed.users, 1)
+	assert.Equal(t, "alice", parsed.users[0].Login)
+	assert.Equal(t, hex.EncodeToString(passwordHash[:]), parsed.users[0].PasswordHash)
+	assert.Equal(t, "legacy-id", parsed.users[0].LegacyID)
+	assert.Len(t, parsed.lists["userinvoices_for_legacy-id"], 3)
+
+	invoices := parsed.lists["userinvoices_for_legacy-id"]
+	paid, err := legacyIncomingInvoice(parsed, invoices[0], time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, common.InvoiceStateSettled, paid.State)
+	assert.Equal(t, int64(1000), paid.Amount)
+	assert.Equal(t, uint64(7), paid.AddIndex)
+	assert.Equal(t, "coffee", paid.Memo)
+	assert.Equal(t, hex.EncodeToString(paidHash[:]), paid.RHash)
+	assert.Equal(t, legacyMissingPreimage, paid.Preimage)
+	assert.Equal(t, created, paid.CreatedAt)
+
+	expired, err := legacyIncomingInvoice(parsed, invoices[1], time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, common.InvoiceStateExpired, expired.State)
+	_, err = legacyIncomingInvoice(parsed, invoices[2], time.Now())
+	assert.Error(t, err)
+
+	txs := parsed.lists["txs_for_legacy-id"]
+	internal, err := legacyPayment(parsed, txs[0])
+	assert.NoError(t, err)
+	assert.True(t, internal.Internal)
+	assert.Equal(t, int64(100), internal.Amount)
+	assert.Equal(t, int64(1), internal.Fee)
+	assert.Equal(t, "lunch", internal.Memo)
+	assert.Equal(t, common.InvoiceTypeOutgoing, internal.Type)
+
+	external, err := legacyPayment(parsed, txs[1])
+	assert.NoError(t, err)
+	assert.False(t, external.Internal)
+	assert.Equal(t, int64(200), external.Amount)
+	assert.Equal(t, int64(5), external.Fee)
+	assert.Equal(t, "010203", external.Preimage)
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/uptrace/bun"
+)
+
+const (
+	SendLimitPeriodDaily  = "daily"
+	SendLimitPeriodWeekly = "weekly"
+)
+
+// SendLimitExceededError is returned if a payment would exceed the user's rolling send volume limit
+type SendLimitExceededError struct {
+	Period  string
+	Limit   int64
+	ResetAt time.Time
+}
+
+func (e *SendLimitExceededError) Error() string {
+	return fmt.Sprintf("%s send limit of %v sats exceeded, resets at %v", e.Period, e.Limit, e.ResetAt.Format(time.RFC3339))
+}
+
+// OutgoingVolumeSince returns the net amount moved from the user's current to the in-flight or outgoing account since the given time
+// Reverted payments are subtracted again, moving settled payments from in-flight to outgoing is not counted twice
+func (svc *LndhubService) OutgoingVolumeSince(ctx context.Context, userId int64, since time.Time) (int64, error) {
+	return svc.outgoingVolumeSince(ctx, svc.DB, userId, since)
+}
+
+// outgoingVolumeSince reads the volume with db, a payment transaction reads it after the user's current account is locked
+// Refunds are only subtracted if the debit they refund is within the window, refunds of older payments do not add allowance
+func (svc *LndhubService) outgoingVolumeSince(ctx context.Context, db bun.IDB, userId int64, since time.Time) (int64, error) {
+	var volume int64
+	currentAccount, err := svc.accountFor(ctx, db, common.AccountTypeCurrent, userId)
+	if err != nil {
+		return volume, err
+	}
+	// payments made before the in-flight account existed were moved to the outgoing account directly
+	paymentAccountIds := db.NewSelect().Model((*models.Account)(nil)).Column("id").
+		Where("user_id = ? AND type IN (?, ?)", userId, common.AccountTypeInFlight, common.AccountTypeOutgoing)
+	err = db.NewSelect().
+		TableExpr("transaction_entries AS entry").
+		ColumnExpr("COALESCE(SUM(CASE WHEN entry.debit_account_id = ? THEN entry.amount ELSE 0 - entry.amount END), 0)", currentAccount.ID).
+		Where("(entry.debit_account_id = ? AND entry.credit_account_id IN (?)) OR (entry.credit_account_id = ? AND entry.debit_account_id IN (?) AND EXISTS (?))",
+			currentAccount.ID, paymentAccountIds, currentAccount.ID, paymentAccountIds,
+			// the debit of the same invoice booked before the refund, the fee reserve refunds reference it as their parent
+			db.NewSelect().TableExpr("transaction_entries AS debit").ColumnExpr("1").
+				Where("debit.invoice_id = entry.invoice_id AND debit.id < entry.id").
+				Where("debit.debit_account_id = ? AND debit.credit_account_id IN (?)", currentAccount.ID, paymentAccountIds).
+				Where("debit.created_at > ?", since)).
+		Where("entry.created_at > ?", since).
+		Scan(ctx, &volume)
+	return volume, err
+}
+
+// CheckSendLimits enforces the daily and weekly send volume limits of the user's tier for the given payment amount
+func (svc *LndhubService) CheckSendLimits(ctx context.Context, userId int64, amount int64, tier *TierSettings) error {
+	return svc.checkSendLimits(ctx, svc.DB, userId, amount, tier)
+}
+
+// checkSendLimits checks the limits with db, payments check them in their transaction after the current account is locked
+// so concurrent payments of the user can not both pass with the same volume
+func (svc *LndhubService) checkSendLimits(ctx context.Context, db bun.IDB, userId int64, amount int64, tier *TierSettings) error {
+	limits := []struct {
+		period string
+		limit  int64
+		window time.Duration
+	}{
+		{SendLimitPeriodDaily, tier.DailySendLimit, 24 * time.Hour},
+		{SendLimitPeriodWeekly, tier.WeeklySendLimit, 7 * 24 * time.Hour},
+	}
+	for _, l := range limits {
+		if l.limit <= 0 {
+			continue
+		}
+		since := time.Now().Add(-l.window)
+		volume, err := svc.outgoingVolumeSince(ctx, db, userId, since)
+		if err != nil {
+			return err
+		}
+		total, err := lib.AddAmounts(volume, amount)
+		if err != nil {
+			return err
+		}
+		if total <= l.limit {
+			continue
+		}
+		// the window rolls, so the limit resets once the oldest payment within it is older than the window
+		resetAt := time.Now().Add(l.window)
+		var oldestEntry models.TransactionEntry
+		err = db.NewSelect().Model(&oldestEntry).
+			Where("user_id = ? AND created_at > ?", userId, since).
+			Where("credit_account_id IN (SELECT id FROM accounts WHERE user_id = ? AND type IN (?, ?))", userId, common.AccountTypeInFlight, common.AccountTypeOutgoing).
+			OrderExpr("created_at ASC").Limit(1).Scan(ctx)
+		if err == nil {
+			resetAt = oldestEntry.CreatedAt.Add(l.window)
+		}
+		return &SendLimitExceededError{Period: l.period, Limit: l.limit, ResetAt: resetAt}
+	}
+	return nil
+}
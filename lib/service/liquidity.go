@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+var ErrOutboundLiquidityLow = errors.New("outgoing payments are paused because the node's outbound liquidity is low")
+var ErrInboundLiquidityInsufficient = errors.New("invoice amount exceeds the node's inbound liquidity")
+var ErrReceivingPaused = errors.New("receiving is paused because the node has no active channels or inbound liquidity")
+
+const (
+	InboundLiquidityCheckWarn   = "warn"
+	InboundLiquidityCheckReject = "reject"
+)
+
+// LiquiditySnapshot is the spendable and receivable balance of the node's active channels
+type LiquiditySnapshot struct {
+	Outbound       int64
+	Inbound        int64
+	ActiveChannels int
+	CheckedAt      time.Time
+}
+
+type liquidityState struct {
+	mu       sync.RWMutex
+	snapshot LiquiditySnapshot
+}
+
+// Liquidity returns the last liquidity snapshot, the snapshot is empty until the first check completed
+func (svc *LndhubService) Liquidity() LiquiditySnapshot {
+	svc.liquidity.mu.RLock()
+	defer svc.liquidity.mu.RUnlock()
+	return svc.liquidity.snapshot
+}
+
+// CheckLiquidity fetches the node's channels and updates the liquidity snapshot
+func (svc *LndhubService) CheckLiquidity(ctx context.Context) (LiquiditySnapshot, error) {
+	channels, err := svc.LndClient.ListChannels(ctx, &lnrpc.ListChannelsRequest{ActiveOnly: true})
+	if err != nil {
+		return LiquiditySnapshot{}, err
+	}
+	snapshot := LiquiditySnapshot{CheckedAt: time.Now()}
+	for _, ch := range channels.Channels {
+		if !ch.Active {
+			continue
+		}
+		snapshot.ActiveChannels++
+		if spendable := ch.LocalBalance - ch.LocalChanReserveSat; spendable > 0 {
+			snapshot.Outbound += spendable
+		}
+		if receivable := ch.RemoteBalance - ch.RemoteChanReserveSat; receivable > 0 {
+			snapshot.Inbound += receivable
+		}
+	}
+
+	svc.liquidity.mu.Lock()
+	previous := svc.liquidity.snapshot
+	svc.liquidity.snapshot = snapshot
+	svc.liquidity.mu.Unlock()
+
+	if svc.Config.PauseReceivingWithoutInbound {
+		wasPaused := !previous.CheckedAt.IsZero() && receivingPaused(previous)
+		isPaused := receivingPaused(snapshot)
+		if isPaused && !wasPaused {
+			svc.Logger.Errorf("Node has no active channels or inbound liquidity, pausing invoice creation channels:%v inbound:%v", snapshot.ActiveChannels, snapshot.Inbound)
+			sentry.CaptureMessage("Node has no active channels or inbound liquidity, invoice creation paused")
+		}
+		if !isPaused && wasPaused {
+			svc.Logger.Infof("Inbound liquidity available again, resuming invoice creation channels:%v inbound:%v", snapshot.ActiveChannels, snapshot.Inbound)
+		}
+	}
+	if min := svc.Config.MinOutboundLiquidity; min > 0 {
+		wasLow := !previous.CheckedAt.IsZero() && previous.Outbound < min
+		isLow := snapshot.Outbound < min
+		if isLow && !wasLow {
+			svc.Logger.Errorf("Outbound liquidity below threshold, pausing outgoing payments outbound:%v threshold:%v", snapshot.Outbound, min)
+			sentry.CaptureMessage("Outbound liquidity below threshold, outgoing payments paused")
+		}
+		if !isLow && wasLow {
+			svc.Logger.Infof("Outbound liquidity recovered, resuming outgoing payments outbound:%v threshold:%v", snapshot.Outbound, min)
+		}
+	}
+	return snapshot, nil
+}
+
+// CheckOutboundLiquidity denies outgoing payments while the last check found less than MIN_OUTBOUND_LIQUIDITY
+// Payments are allowed until the first check completed
+func (svc *LndhubService) CheckOutboundLiquidity() error {
+	if svc.Config.MinOutboundLiquidity <= 0 {
+		return nil
+	}
+	snapshot := svc.Liquidity()
+	if snapshot.CheckedAt.IsZero() {
+		return nil
+	}
+	if snapshot.Outbound < svc.Config.MinOutboundLiquidity {
+		return ErrOutboundLiquidityLow
+	}
+	return nil
+}
+
+func receivingPaused(snapshot LiquiditySnapshot) bool {
+	return snapshot.ActiveChannels == 0 || snapshot.Inbound <= 0
+}
+
+// ReceivingPaused reports if PAUSE_RECEIVING_WITHOUT_INBOUND currently denies new invoices
+// Invoices are allowed until the first check completed
+func (svc *LndhubService) ReceivingPaused() bool {
+	if !svc.Config.PauseReceivingWithoutInbound {
+		return false
+	}
+	snapshot := svc.Liquidity()
+	return !snapshot.CheckedAt.IsZero() && receivingPaused(snapshot)
+}
+
+// CheckInboundLiquidity returns ErrInboundLiquidityInsufficient if the node can not receive the amount
+// A stale snapshot is refreshed first, invoices are not blocked if the node can not be queried
+func (svc *LndhubService) CheckInboundLiquidity(ctx context.Context, amount int64) error {
+	if svc.Config.InboundLiquidityCheck == "" || amount <= 0 {
+		return nil
+	}
+	snapshot := svc.Liquidity()
+	if time.Since(snapshot.CheckedAt) > svc.liquidityCheckInterval() {
+		var err error
+		snapshot, err = svc.CheckLiquidity(ctx)
+		if err != nil {
+			svc.Logger.Errorf("Error checking node liquidity: %v", err)
+			return nil
+		}
+	}
+	if amount > snapshot.Inbound {
+		return ErrInboundLiquidityInsufficient
+	}
+	return nil
+}
+
+func (svc *LndhubService) liquidityCheckInterval() time.Duration {
+	if svc.Config.LiquidityCheckInterval <= 0 {
+		return time.Minute
+	}
+	return time.Duration(svc.Config.LiquidityCheckInterval) * time.Second
+}
+
+// StartLiquidityMonitor periodically checks the node's liquidity until the context is canceled
+func (svc *LndhubService) StartLiquidityMonitor(ctx context.Context) {
+	if svc.Config.MinOutboundLiquidity <= 0 && svc.Config.InboundLiquidityCheck == "" && !svc.Config.PauseReceivingWithoutInbound {
+		return
+	}
+	ticker := time.NewTicker(svc.liquidityCheckInterval())
+	defer ticker.Stop()
+	for {
+		if _, err := svc.CheckLiquidity(ctx); err != nil {
+			svc.Logger.Errorf("Error checking node liquidity: %v", err)
+			sentry.CaptureException(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
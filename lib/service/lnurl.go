@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+const (
+	lnurlRequestTimeout = 15 * time.Second
+	lnurlMaxBodySize    = 64 * 1024
+)
+
+var ErrInvalidLnurl = errors.New("invalid lightning address or lnurl")
+var ErrLnurlAddressNotAllowed = fmt.Errorf("%w: internal addresses are not allowed", ErrInvalidLnurl)
+var ErrLnurlAmountOutOfRange = errors.New("amount is outside the range accepted by the recipient")
+var ErrLnurlInvalidInvoice = errors.New("invoice returned by the recipient does not match the pay request")
+
+// LnurlPayParams is the LUD-06 pay request of a lightning address or LNURL-pay link
+type LnurlPayParams struct {
+	Callback       string `json:"callback"`
+	MinSendable    int64  `json:"minSendable"`
+	MaxSendable    int64  `json:"maxSendable"`
+	Metadata       string `json:"metadata"`
+	Tag            string `json:"tag"`
+	CommentAllowed int    `json:"commentAllowed"`
+}
+
+// KeysendAddressParams is the keysend metadata of a lightning address served at /.well-known/keysend/<name>
+type KeysendAddressParams struct {
+	Tag        string `json:"tag"`
+	Pubkey     string `json:"pubkey"`
+	CustomData []struct {
+		CustomKey   string `json:"customKey"`
+		CustomValue string `json:"customValue"`
+	} `json:"customData"`
+}
+
+// CustomRecords returns the custom records the recipient expects with every keysend payment
+func (p *KeysendAddressParams) CustomRecords() map[string]string {
+	records := map[string]string{}
+	for _, data := range p.CustomData {
+		records[data.CustomKey] = data.CustomValue
+	}
+	return records
+}
+
+// publicLnurlClient requests the LNURL services of lightning addresses, LNURLs and their callbacks chosen by users
+// Like the webhooks of users it only connects to public addresses, redirects must stay on https as well
+var publicLnurlClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: lnurlRequestTimeout,
+			Control: checkWebhookDial,
+		}).DialContext,
+		TLSHandshakeTimeout: lnurlRequestTimeout,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return validateLnurlUrl(req.URL)
+	},
+}
+
+// validateLnurlUrl only accepts https URLs, or http for .onion services, of hosts that are not internal addresses
+func validateLnurlUrl(u *url.URL) error {
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" || (u.Scheme != "https" && !(u.Scheme == "http" && strings.HasSuffix(host, ".onion"))) {
+		return ErrInvalidLnurl
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrLnurlAddressNotAllowed
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return ErrLnurlAddressNotAllowed
+	}
+	return nil
+}
+
+type lnurlPayCallbackResponse struct {
+	PaymentRequest string `json:"pr"`
+}
+
+type lnurlErrorResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// LnurlPayUrl returns the URL of the pay request of a lightning address (LUD-16) or a bech32 encoded LNURL (LUD-01)
+func LnurlPayUrl(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	target = strings.TrimPrefix(strings.TrimPrefix(target, "lightning:"), "LIGHTNING:")
+	if parts := strings.SplitN(target, "@", 2); len(parts) == 2 {
+		name, domain := parts[0], parts[1]
+		if name == "" || domain == "" || strings.ContainsAny(domain, "/?#@") {
+			return "", ErrInvalidLnurl
+		}
+		scheme := "https"
+		if strings.HasSuffix(domain, ".onion") {
+			scheme = "http"
+		}
+		payUrl := fmt.Sprintf("%s://%s/.well-known/lnurlp/%s", scheme, domain, url.PathEscape(strings.ToLower(name)))
+		parsed, err := url.Parse(payUrl)
+		if err != nil {
+			return "", ErrInvalidLnurl
+		}
+		if err := validateLnurlUrl(parsed); err != nil {
+			return "", err
+		}
+		return payUrl, nil
+	}
+	hrp, data, err := bech32.DecodeNoLimit(strings.ToLower(target))
+	if err != nil || hrp != "lnurl" {
+		return "", ErrInvalidLnurl
+	}
+	decoded, err := bech32.ConvertBits(data, 5, 8, false)
+	if err != nil {
+		return "", ErrInvalidLnurl
+	}
+	parsed, err := url.Parse(string(decoded))
+	if err != nil {
+		return "", ErrInvalidLnurl
+	}
+	if err := validateLnurlUrl(parsed); err != nil {
+		return "", err
+	}
+	return parsed.String(), nil
+}
+
+// ResolveKeysendAddress fetches the keysend pubkey and custom records of a lightning address
+func (svc *LndhubService) ResolveKeysendAddress(ctx context.Context, address string) (*KeysendAddressParams, error) {
+	payUrl, err := LnurlPayUrl(address)
+	if err != nil || !strings.Contains(address, "@") {
+		return nil, ErrInvalidLnurl
+	}
+	params := KeysendAddressParams{}
+	if err := getLnurlJSON(ctx, strings.Replace(payUrl, "/.well-known/lnurlp/", "/.well-known/keysend/", 1), &params); err != nil {
+		return nil, err
+	}
+	if params.Tag != "keysend" || !isPubkeyHex(params.Pubkey) {
+		return nil, fmt.Errorf("%w: no keysend destination", ErrInvalidLnurl)
+	}
+	return &params, nil
+}
+
+func isPubkeyHex(pubkey string) bool {
+	decoded, err := hex.DecodeString(pubkey)
+	return err == nil && len(decoded) == 33
+}
+
+// ResolveLnurlPay fetches the pay request of a lightning address or LNURL-pay link
+func (svc *LndhubService) ResolveLnurlPay(ctx context.Context, target string) (*LnurlPayParams, error) {
+	payUrl, err := LnurlPayUrl(target)
+	if err != nil {
+		return nil, err
+	}
+	params := LnurlPayParams{}
+	if err := getLnurlJSON(ctx, payUrl, &params); err != nil {
+		return nil, err
+	}
+	if params.Tag != "payRequest" || params.Callback == "" {
+		return nil, fmt.Errorf("%w: not a pay request", ErrInvalidLnurl)
+	}
+	return &params, nil
+}
+
+// FetchLnurlPayInvoice requests an invoice for the amount from the callback of the pay request
+// The invoice must be for the requested amount and commit to the metadata of the pay request
+func (svc *LndhubService) FetchLnurlPayInvoice(ctx context.Context, params *LnurlPayParams, amount int64, comment string) (string, *lnrpc.PayReq, error) {
+	amountMsat, err := lnd.SatToMsat(amount)
+	if err != nil {
+		return "", nil, err
+	}
+	if amountMsat < params.MinSendable || amountMsat > params.MaxSendable {
+		return "", nil, ErrLnurlAmountOutOfRange
+	}
+	callback, err := url.Parse(params.Callback)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: invalid callback", ErrInvalidLnurl)
+	}
+	// the callback is chosen by the recipient's service, it is held to the same rules as the pay request URL
+	if err := validateLnurlUrl(callback); err != nil {
+		return "", nil, fmt.Errorf("%w: callback", err)
+	}
+	query := callback.Query()
+	query.Set("amount", strconv.FormatInt(amountMsat, 10))
+	if comment != "" && params.CommentAllowed > 0 {
+		if len([]rune(comment)) > params.CommentAllowed {
+			comment = string([]rune(comment)[:params.CommentAllowed])
+		}
+		query.Set("comment", comment)
+	}
+	callback.RawQuery = query.Encode()
+
+	response := lnurlPayCallbackResponse{}
+	if err := getLnurlJSON(ctx, callback.String(), &response); err != nil {
+		return "", nil, err
+	}
+	decoded, err := svc.DecodePaymentRequest(ctx, response.PaymentRequest)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrLnurlInvalidInvoice, err)
+	}
+	metadataHash := sha256.Sum256([]byte(params.Metadata))
+	if decoded.NumSatoshis != amount || decoded.DescriptionHash != hex.EncodeToString(metadataHash[:]) {
+		return "", nil, ErrLnurlInvalidInvoice
+	}
+	return response.PaymentRequest, decoded, nil
+}
+
+// getLnurlJSON decodes the JSON response of an LNURL service, an {"status": "ERROR"} response is returned as error
+func getLnurlJSON(ctx context.Context, target string, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, lnurlRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := publicLnurlClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, lnurlMaxBodySize))
+	if err != nil {
+		return err
+	}
+	errorResponse := lnurlErrorResponse{}
+	if json.Unmarshal(body, &errorResponse) == nil && strings.EqualFold(errorResponse.Status, "ERROR") {
+		return fmt.Errorf("lnurl error: %s", errorResponse.Reason)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("lnurl request failed with status code %d", resp.StatusCode)
+	}
+	return json.Unmarshal(body, result)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLnurlPayUrl(t *testing.T) {
+	payUrl, err := LnurlPayUrl("Alice@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/.well-known/lnurlp/alice", payUrl)
+
+	payUrl, err = LnurlPayUrl("bob@hub2xyz.onion")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://hub2xyz.onion/.well-known/lnurlp/bob", payUrl)
+
+	// LUD-01 example
+	payUrl, err = LnurlPayUrl("lightning:LNURL1DP68GURN8GHJ7UM9WFMXJCM99E3K7MF0V9CXJ0M385EKVCENXC6R2C35XVUKXEFCV5MKVV34X5EKZD3EV56NYD3HXQURZEPEXEJXXEPNXSCRVWFNV9NXZCN9XQ6XYEFHVGCXXCMYXYMNSERXFQ5FNS")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://service.com/api?q=3fc3645b439ce8e7f2553a69e5267081d96dcd340693afabe04be7b0ccd178df", payUrl)
+
+	_, err = LnurlPayUrl("@example.com")
+	assert.ErrorIs(t, err, ErrInvalidLnurl)
+	_, err = LnurlPayUrl("alice@example.com/path")
+	assert.ErrorIs(t, err, ErrInvalidLnurl)
+	_, err = LnurlPayUrl("lnbc1notanlnurl")
+	assert.ErrorIs(t, err, ErrInvalidLnurl)
+
+	// lightning addresses and lnurls of internal hosts are rejected
+	for _, target := range []string{"user@localhost:8080", "user@10.0.0.1", "user@127.0.0.1", "user@[::1]", "user@169.254.169.254", "user@api.localhost"} {
+		_, err = LnurlPayUrl(target)
+		assert.ErrorIs(t, err, ErrLnurlAddressNotAllowed, target)
+	}
+	encoded, err := bech32.ConvertBits([]byte("http://service.com/api"), 8, 5, true)
+	assert.NoError(t, err)
+	lnurl, err := bech32.Encode("lnurl", encoded)
+	assert.NoError(t, err)
+	_, err = LnurlPayUrl(lnurl)
+	assert.ErrorIs(t, err, ErrInvalidLnurl)
+}
+
+func TestFetchLnurlPayInvoiceCallback(t *testing.T) {
+	svc := &LndhubService{}
+	for _, callback := range []string{"http://example.com/callback", "https://127.0.0.1/callback", "https://10.0.0.1/callback", "https://localhost:8080/callback", "file:///etc/passwd"} {
+		params := &LnurlPayParams{Callback: callback, MinSendable: 1000, MaxSendable: 100000}
+		_, _, err := svc.FetchLnurlPayInvoice(context.Background(), params, 10, "")
+		assert.ErrorIs(t, err, ErrInvalidLnurl, callback)
+	}
+}
+
+func TestLnurlRequestsAreNotSentToInternalAddresses(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+	// the address is checked when connecting as well, hosts resolving to internal addresses are rejected
+	err := getLnurlJSON(context.Background(), server.URL, &LnurlPayParams{})
+	assert.ErrorIs(t, err, ErrWebhookAddressNotAllowed)
+	assert.False(t, requested)
+}
+
+func TestFetchLnurlPayInvoiceAmountOverflow(t *testing.T) {
+	svc := &LndhubService{}
+	params := &LnurlPayParams{Callback: "https://example.com/lnurlp/callback", MinSendable: 1000, MaxSendable: math.MaxInt64}
+	_, _, err := svc.FetchLnurlPayInvoice(context.Background(), params, math.MaxInt64/100, "")
+	assert.ErrorIs(t, err, lib.ErrAmountOverflow)
+}
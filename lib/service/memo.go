@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// SanitizeMemo normalizes the memo to NFC, drops invalid UTF-8, control characters and bidirectional overrides
+// and truncates it to maxLength characters, 0 means no limit
+// Line breaks and tabs are replaced with spaces so the memo stays a single line in exports
+func SanitizeMemo(memo string, maxLength int) string {
+	memo = norm.NFC.String(strings.ToValidUTF8(memo, ""))
+	var b strings.Builder
+	length := 0
+	for _, r := range memo {
+		if maxLength > 0 && length >= maxLength {
+			break
+		}
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			r = ' '
+		case unicode.IsControl(r), isBidiControl(r):
+			continue
+		}
+		b.WriteRune(r)
+		length++
+	}
+	// a truncated emoji sequence must not end with a zero width joiner
+	return strings.TrimSpace(strings.TrimRight(b.String(), "\u200d"))
+}
+
+// isBidiControl reports the embedding, override and isolate characters that can reorder the displayed text
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+func (svc *LndhubService) sanitizeMemo(memo string) string {
+	return SanitizeMemo(memo, svc.Config.MemoMaxLength)
+}
+
+// renderMemoTemplate replaces the {memo}, {login}, {user_id} and {hub} placeholders of the template
+// A template without {memo} replaces the user's memo entirely
+func renderMemoTemplate(template, memo, login string, userID int64, hub string) string {
+	rendered := strings.NewReplacer(
+		"{memo}", memo,
+		"{login}", login,
+		"{user_id}", strconv.FormatInt(userID, 10),
+		"{hub}", hub,
+	).Replace(template)
+	return strings.TrimSpace(rendered)
+}
+
+// ApplyMemoPolicy returns the sanitized memo of an incoming invoice according to INVOICE_MEMO_TEMPLATE
+func (svc *LndhubService) ApplyMemoPolicy(ctx context.Context, userID int64, memo string) (string, error) {
+	memo = svc.sanitizeMemo(memo)
+	template := svc.Config.InvoiceMemoTemplate
+	if template == "" {
+		return memo, nil
+	}
+	var login string
+	if strings.Contains(template, "{login}") {
+		user, err := svc.FindUser(ctx, userID)
+		if err != nil {
+			return "", err
+		}
+		login = user.Login
+	}
+	return svc.sanitizeMemo(renderMemoTemplate(template, memo, login, userID, svc.Config.CustomName)), nil
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderMemoTemplate(t *testing.T) {
+	assert.Equal(t, "coffee - via MyHub", renderMemoTemplate("{memo} - via {hub}", "coffee", "", 1, "MyHub"))
+	assert.Equal(t, "[alice] coffee", renderMemoTemplate("[{login}] {memo}", "coffee", "alice", 1, ""))
+	assert.Equal(t, "- via MyHub", renderMemoTemplate("{memo} - via {hub}", "", "", 1, "MyHub"))
+	assert.Equal(t, "Payment to user 42", renderMemoTemplate("Payment to user {user_id}", "coffee", "", 42, ""))
+}
+
+func TestSanitizeMemo(t *testing.T) {
+	cases := []struct {
+		memo      string
+		maxLength int
+		sanitized string
+	}{
+		{"coffee ☕️", 0, "coffee ☕️"},
+		{"咖啡 コーヒー 커피", 0, "咖啡 コーヒー 커피"},
+		{"family 👨‍👩‍👧", 0, "family 👨‍👩‍👧"},
+		{"line\nbreak\ttab", 0, "line break tab"},
+		{"bell\x07 null\x00 del\x7f", 0, "bell null del"},
+		{"invalid \xff\xfe utf-8", 0, "invalid  utf-8"},
+		{"evil \u202egnp.exe", 0, "evil gnp.exe"},
+		{"cafe\u0301", 0, "caf\u00e9"},
+		{"咖啡咖啡", 2, "咖啡"},
+		{"family 👨‍👩‍👧", 8, "family 👨"},
+		{"  padded  ", 0, "padded"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.sanitized, SanitizeMemo(c.memo, c.maxLength), "memo %q", c.memo)
+	}
+}
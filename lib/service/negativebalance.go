@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+)
+
+// WebhookNegativeBalancePayload is sent to WEBHOOK_URL when a payment leaves a user with a negative balance
+type WebhookNegativeBalancePayload struct {
+	UserID             int64 `json:"user_id"`
+	InvoiceID          int64 `json:"invoice_id"`
+	TransactionEntryID int64 `json:"transaction_entry_id"`
+	Balance            int64 `json:"balance"`
+	UserFrozen         bool  `json:"user_frozen"`
+}
+
+func negativeBalanceIncident(invoice *models.Invoice, parentEntry models.TransactionEntry, balance int64, freeze bool) models.IntegrityIncident {
+	return models.IntegrityIncident{
+		Kind:               common.IntegrityIncidentNegativeBalance,
+		Fingerprint:        fmt.Sprintf("%s:%d:%d", common.IntegrityIncidentNegativeBalance, invoice.ID, parentEntry.ID),
+		UserID:             invoice.UserID,
+		InvoiceID:          invoice.ID,
+		TransactionEntryID: parentEntry.ID,
+		Details:            fmt.Sprintf("balance of user %d is %d after the payment of invoice %d", invoice.UserID, balance, invoice.ID),
+		UserFrozen:         freeze,
+	}
+}
+
+// handleNegativeBalance reports a balance that is negative after the payment as an integrity incident and alerts the
+// operator with a balance.negative webhook. If NEGATIVE_BALANCE_AUTO_FREEZE is enabled the user is frozen, which blocks
+// further payments until an operator unfreezes the user
+func (svc *LndhubService) handleNegativeBalance(ctx context.Context, invoice *models.Invoice, parentEntry models.TransactionEntry, balance int64) error {
+	incident := negativeBalanceIncident(invoice, parentEntry, balance, svc.Config.NegativeBalanceAutoFreeze)
+	res, err := svc.DB.NewInsert().Model(&incident).Ignore().Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		// already reported
+		return nil
+	}
+	integrityIncidentMetrics.Add(incident.Kind, 1)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("integrity_incident", incident.Kind)
+		scope.SetExtra("user_id", incident.UserID)
+		scope.SetExtra("invoice_id", incident.InvoiceID)
+		scope.SetExtra("transaction_entry_id", incident.TransactionEntryID)
+		sentry.CaptureMessage(fmt.Sprintf("Integrity incident: %s", incident.Details))
+	})
+	if incident.UserFrozen {
+		if err := svc.FreezeUser(ctx, invoice.UserID, fmt.Sprintf("negative balance %s", incident.Fingerprint)); err != nil {
+			return err
+		}
+		svc.Logger.Errorf("Froze user with a negative balance user_id:%v invoice_id:%v balance:%v", invoice.UserID, invoice.ID, balance)
+	}
+	return svc.EnqueueWebhook(ctx, svc.Config.WebhookUrl, &WebhookPayload{
+		Event: common.WebhookEventBalanceNegative,
+		NegativeBalance: &WebhookNegativeBalancePayload{
+			UserID:             invoice.UserID,
+			InvoiceID:          invoice.ID,
+			TransactionEntryID: parentEntry.ID,
+			Balance:            balance,
+			UserFrozen:         incident.UserFrozen,
+		},
+	})
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegativeBalanceIncident(t *testing.T) {
+	invoice := &models.Invoice{ID: 12, UserID: 3}
+	incident := negativeBalanceIncident(invoice, models.TransactionEntry{ID: 40}, -21, true)
+	assert.Equal(t, common.IntegrityIncidentNegativeBalance, incident.Kind)
+	assert.Equal(t, "negative_balance:12:40", incident.Fingerprint)
+	assert.Equal(t, int64(3), incident.UserID)
+	assert.Equal(t, int64(12), incident.InvoiceID)
+	assert.Equal(t, int64(40), incident.TransactionEntryID)
+	assert.Contains(t, incident.Details, "-21")
+	assert.True(t, incident.UserFrozen)
+
+	incident = negativeBalanceIncident(invoice, models.TransactionEntry{ID: 40}, -21, false)
+	assert.False(t, incident.UserFrozen)
+}
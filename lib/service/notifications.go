@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+
+	"github.com/getAlby/lndhub.go/db/models"
+)
+
+// Notifications returns the latest notifications of the user
+func (svc *LndhubService) Notifications(ctx context.Context, userId int64, unreadOnly bool) ([]models.Notification, error) {
+	notifications := []models.Notification{}
+	query := svc.DB.NewSelect().Model(&notifications).Where("user_id = ?", userId)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	err := query.OrderExpr("id DESC").Limit(100).Scan(ctx)
+	return notifications, err
+}
+
+// MarkNotificationsRead marks all notifications of the user as read
+func (svc *LndhubService) MarkNotificationsRead(ctx context.Context, userId int64) error {
+	_, err := svc.DB.NewUpdate().Model((*models.Notification)(nil)).
+		Set("read_at = current_timestamp").
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Exec(ctx)
+	return err
+}
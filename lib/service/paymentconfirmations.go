@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+)
+
+const (
+	paymentConfirmationExpiry = 10 * time.Minute
+	// the user's typical payment is the average of the settled payments in this window
+	paymentPatternWindow = 30 * 24 * time.Hour
+)
+
+var ErrInvalidPaymentConfirmation = errors.New("payment confirmation is invalid, expired or already used")
+
+// PaymentConfirmationRequiredError is returned for unusual payments that were not confirmed yet
+// The payment is made if it is sent again together with the token
+type PaymentConfirmationRequiredError struct {
+	Token         string
+	ExpiresAt     time.Time
+	AverageAmount int64
+}
+
+func (e *PaymentConfirmationRequiredError) Error() string {
+	return fmt.Sprintf("payment exceeds the usual payment amount of %v sats and needs to be confirmed", e.AverageAmount)
+}
+
+// isUnusualPayment compares the amount to the average amount of the user's payments
+// Users without payment history have no pattern to compare to
+func isUnusualPayment(amount, averageAmount int64, multiplier float64, minAmount int64) bool {
+	if multiplier <= 0 || averageAmount <= 0 || amount < minAmount {
+		return false
+	}
+	return float64(amount) > float64(averageAmount)*multiplier
+}
+
+// CheckPaymentConfirmation requires a confirmation for payments above UNUSUAL_PAYMENT_MULTIPLIER times the user's 30 day average
+// Without a valid token a new one-time confirmation bound to the amount and destination of the invoice is issued
+func (svc *LndhubService) CheckPaymentConfirmation(ctx context.Context, invoice *models.Invoice, confirmationToken string) error {
+	if svc.Config.UnusualPaymentMultiplier <= 0 {
+		return nil
+	}
+	var averageAmount float64
+	err := svc.DB.NewSelect().Model((*models.Invoice)(nil)).
+		ColumnExpr("COALESCE(AVG(amount), 0)").
+		Where("user_id = ? AND type = ? AND state = ? AND settled_at > ?", invoice.UserID, common.InvoiceTypeOutgoing, common.InvoiceStateSettled, time.Now().Add(-paymentPatternWindow)).
+		Scan(ctx, &averageAmount)
+	if err != nil {
+		return err
+	}
+	if !isUnusualPayment(invoice.Amount, int64(averageAmount), svc.Config.UnusualPaymentMultiplier, svc.Config.UnusualPaymentMinAmount) {
+		return nil
+	}
+	if confirmationToken != "" {
+		return svc.confirmPayment(ctx, invoice, confirmationToken)
+	}
+
+	confirmation := models.PaymentConfirmation{
+		UserID:               invoice.UserID,
+		Amount:               invoice.Amount,
+		DestinationPubkeyHex: invoice.DestinationPubkeyHex,
+		RHash:                invoice.RHash,
+		ExpiresAt:            time.Now().Add(paymentConfirmationExpiry),
+	}
+	if _, err := svc.DB.NewInsert().Model(&confirmation).Exec(ctx); err != nil {
+		return err
+	}
+	token, err := tokens.GeneratePaymentConfirmation(svc.Config.JWTSecret, confirmation.ID, confirmation.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	svc.Logger.Infof("Unusual payment needs confirmation user_id:%v invoice_id:%v amount:%v average:%v", invoice.UserID, invoice.ID, invoice.Amount, int64(averageAmount))
+	return &PaymentConfirmationRequiredError{Token: token, ExpiresAt: confirmation.ExpiresAt, AverageAmount: int64(averageAmount)}
+}
+
+// confirmPayment uses up the confirmation of the token if it was issued for the invoice's user, amount and destination
+func (svc *LndhubService) confirmPayment(ctx context.Context, invoice *models.Invoice, confirmationToken string) error {
+	claims, err := tokens.ParsePaymentConfirmation(svc.Config.JWTSecret, confirmationToken)
+	if err != nil {
+		return ErrInvalidPaymentConfirmation
+	}
+	res, err := svc.DB.NewUpdate().Model((*models.PaymentConfirmation)(nil)).
+		Set("confirmed_at = current_timestamp").
+		Where("id = ? AND user_id = ? AND amount = ? AND destination_pubkey_hex = ?", claims.ConfirmationID, invoice.UserID, invoice.Amount, invoice.DestinationPubkeyHex).
+		Where("COALESCE(r_hash, '') = ?", invoice.RHash).
+		Where("confirmed_at IS NULL AND expires_at > current_timestamp").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return ErrInvalidPaymentConfirmation
+	}
+	svc.Logger.Infof("Unusual payment confirmed user_id:%v invoice_id:%v confirmation_id:%v", invoice.UserID, invoice.ID, claims.ConfirmationID)
+	return nil
+}
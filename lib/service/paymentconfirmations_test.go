@@ -0,0 +1,17 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsUnusualPayment(t *testing.T) {
+	assert.False(t, isUnusualPayment(10000, 1000, 0, 0))
+	assert.False(t, isUnusualPayment(10000, 0, 5, 0))
+	assert.False(t, isUnusualPayment(5000, 1000, 5, 0))
+	assert.True(t, isUnusualPayment(5001, 1000, 5, 0))
+	assert.True(t, isUnusualPayment(1500, 1000, 1.4, 0))
+	assert.False(t, isUnusualPayment(5001, 1000, 5, 10000))
+	assert.True(t, isUnusualPayment(10000, 1000, 5, 10000))
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/uptrace/bun"
+)
+
+const (
+	PaymentFailureNoRoute                 = "no_route"
+	PaymentFailureIncorrectPaymentDetails = "incorrect_payment_details"
+	PaymentFailureInvoiceExpired          = "invoice_expired"
+	PaymentFailureTimeout                 = "timeout"
+	PaymentFailureInsufficientBalance     = "insufficient_balance"
+	PaymentFailureDestinationNotAllowed   = "destination_not_allowed"
+	PaymentFailureUnknown                 = "unknown"
+
+	// only the latest failures are considered for the dominant reason
+	paymentFailureWindow = 50
+)
+
+// paymentFailureActions suggests what the user can do about a failure reason
+var paymentFailureActions = map[string]string{
+	PaymentFailureNoRoute:                 "The recipient might not have enough inbound liquidity. Try a smaller amount or ask the recipient for another way to pay.",
+	PaymentFailureIncorrectPaymentDetails: "The recipient does not know the invoice or it was already paid. Ask the recipient for a new invoice.",
+	PaymentFailureInvoiceExpired:          "The invoice expired. Ask the recipient for a new invoice.",
+	PaymentFailureTimeout:                 "The recipient's node did not respond in time. Try again later.",
+	PaymentFailureInsufficientBalance:     "The hub can not send this amount right now. Try again later or with a smaller amount.",
+	PaymentFailureDestinationNotAllowed:   "Payments to this destination are not allowed by the hub.",
+	PaymentFailureUnknown:                 "Try again later or contact support if the problem persists.",
+}
+
+// PaymentFailureAction returns what the user can do about the failure reason
+func PaymentFailureAction(reason string) string {
+	if action, ok := paymentFailureActions[reason]; ok {
+		return action
+	}
+	return paymentFailureActions[PaymentFailureUnknown]
+}
+
+type WebhookPaymentFailuresPayload struct {
+	UserID          int64  `json:"user_id"`
+	Destination     string `json:"destination"`
+	Failures        int    `json:"failures"`
+	Reason          string `json:"reason"`
+	SuggestedAction string `json:"suggested_action"`
+	LastError       string `json:"last_error"`
+}
+
+// ClassifyPaymentFailure maps the error message of a failed payment to a failure reason
+func ClassifyPaymentFailure(errorMessage string) string {
+	msg := strings.ToLower(errorMessage)
+	switch {
+	case strings.Contains(msg, "no_route") || strings.Contains(msg, "unable to find a path") || strings.Contains(msg, "no route"):
+		return PaymentFailureNoRoute
+	case strings.Contains(msg, "incorrect_payment_details") || strings.Contains(msg, "incorrect_or_unknown_payment_details") || strings.Contains(msg, "incorrect or unknown payment details") || strings.Contains(msg, "unknown payment hash"):
+		return PaymentFailureIncorrectPaymentDetails
+	case strings.Contains(msg, "expired"):
+		return PaymentFailureInvoiceExpired
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded"):
+		return PaymentFailureTimeout
+	case strings.Contains(msg, "insufficient"):
+		return PaymentFailureInsufficientBalance
+	case strings.Contains(msg, ErrDestinationNotAllowed.Error()):
+		return PaymentFailureDestinationNotAllowed
+	}
+	return PaymentFailureUnknown
+}
+
+// dominantPaymentFailure returns the most frequent reason of the error messages, ordered from newest to oldest
+// Ties are decided by the most recent failure
+func dominantPaymentFailure(errorMessages []string) string {
+	counts := map[string]int{}
+	dominant := PaymentFailureUnknown
+	for _, msg := range errorMessages {
+		reason := ClassifyPaymentFailure(msg)
+		counts[reason]++
+		if counts[reason] > counts[dominant] {
+			dominant = reason
+		}
+	}
+	return dominant
+}
+
+// NotifyRepeatedPaymentFailures notifies the user, and the operator if PAYMENT_FAILURE_NOTIFY_OPERATOR is set,
+// after every PAYMENT_FAILURE_NOTIFY_THRESHOLD consecutive failed payments to the same destination
+// A settled payment to the destination starts the count over
+func (svc *LndhubService) NotifyRepeatedPaymentFailures(ctx context.Context, invoice *models.Invoice) error {
+	threshold := svc.Config.PaymentFailureNotifyThreshold
+	if threshold <= 0 || invoice.DestinationPubkeyHex == "" {
+		return nil
+	}
+	consecutiveFailures := func() *bun.SelectQuery {
+		return svc.DB.NewSelect().Model((*models.Invoice)(nil)).
+			Where("user_id = ? AND type = ? AND destination_pubkey_hex = ? AND state = ?", invoice.UserID, common.InvoiceTypeOutgoing, invoice.DestinationPubkeyHex, common.InvoiceStateError).
+			Where("id > (SELECT COALESCE(MAX(id), 0) FROM invoices WHERE user_id = ? AND type = ? AND destination_pubkey_hex = ? AND state = ?)",
+				invoice.UserID, common.InvoiceTypeOutgoing, invoice.DestinationPubkeyHex, common.InvoiceStateSettled)
+	}
+	failures, err := consecutiveFailures().Count(ctx)
+	if err != nil {
+		return err
+	}
+	if failures == 0 || failures%threshold != 0 {
+		return nil
+	}
+	errorMessages := []string{}
+	err = consecutiveFailures().ColumnExpr("COALESCE(error_message, '')").OrderExpr("id DESC").Limit(paymentFailureWindow).Scan(ctx, &errorMessages)
+	if err != nil {
+		return err
+	}
+
+	reason := dominantPaymentFailure(errorMessages)
+	failurePayload := &WebhookPaymentFailuresPayload{
+		UserID:          invoice.UserID,
+		Destination:     invoice.DestinationPubkeyHex,
+		Failures:        failures,
+		Reason:          reason,
+		SuggestedAction: paymentFailureActions[reason],
+		LastError:       errorMessages[0],
+	}
+	svc.Logger.Infof("Payments repeatedly failing user_id:%v destination:%s failures:%v reason:%s", invoice.UserID, invoice.DestinationPubkeyHex, failures, reason)
+	notification := models.Notification{
+		UserID:  invoice.UserID,
+		Type:    common.NotificationTypePaymentRepeatedlyFailing,
+		Message: fmt.Sprintf("%d payments to %s failed. %s", failures, invoice.DestinationPubkeyHex, failurePayload.SuggestedAction),
+		Payload: map[string]interface{}{
+			"destination":      failurePayload.Destination,
+			"failures":         failurePayload.Failures,
+			"reason":           failurePayload.Reason,
+			"suggested_action": failurePayload.SuggestedAction,
+		},
+	}
+	if _, err := svc.DB.NewInsert().Model(&notification).Exec(ctx); err != nil {
+		return err
+	}
+	if svc.Config.PaymentFailureNotifyOperator {
+		return svc.EnqueueWebhook(ctx, svc.Config.WebhookUrl, &WebhookPayload{
+			Event:           common.WebhookEventPaymentRepeatedlyFailing,
+			PaymentFailures: failurePayload,
+		})
+	}
+	return nil
+}
+
+// notifyRepeatedPaymentFailures is called for every failed payment, errors are only reported
+func (svc *LndhubService) notifyRepeatedPaymentFailures(ctx context.Context, invoice *models.Invoice) {
+	if err := svc.NotifyRepeatedPaymentFailures(ctx, invoice); err != nil {
+		svc.Logger.Errorf("Could not notify about repeated payment failures user_id:%v invoice_id:%v %v", invoice.UserID, invoice.ID, err)
+		sentry.CaptureException(err)
+	}
+}
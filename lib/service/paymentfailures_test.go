@@ -0,0 +1,26 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyPaymentFailure(t *testing.T) {
+	assert.Equal(t, PaymentFailureNoRoute, ClassifyPaymentFailure("FAILURE_REASON_NO_ROUTE"))
+	assert.Equal(t, PaymentFailureNoRoute, ClassifyPaymentFailure("unable to find a path to destination"))
+	assert.Equal(t, PaymentFailureIncorrectPaymentDetails, ClassifyPaymentFailure("FAILURE_REASON_INCORRECT_PAYMENT_DETAILS"))
+	assert.Equal(t, PaymentFailureIncorrectPaymentDetails, ClassifyPaymentFailure("incorrect_or_unknown_payment_details"))
+	assert.Equal(t, PaymentFailureInvoiceExpired, ClassifyPaymentFailure("invoice expired. Valid until 2022-01-01"))
+	assert.Equal(t, PaymentFailureTimeout, ClassifyPaymentFailure("FAILURE_REASON_TIMEOUT"))
+	assert.Equal(t, PaymentFailureInsufficientBalance, ClassifyPaymentFailure("FAILURE_REASON_INSUFFICIENT_BALANCE"))
+	assert.Equal(t, PaymentFailureDestinationNotAllowed, ClassifyPaymentFailure(ErrDestinationNotAllowed.Error()))
+	assert.Equal(t, PaymentFailureUnknown, ClassifyPaymentFailure("something went wrong"))
+}
+
+func TestDominantPaymentFailure(t *testing.T) {
+	assert.Equal(t, PaymentFailureNoRoute, dominantPaymentFailure([]string{"FAILURE_REASON_TIMEOUT", "FAILURE_REASON_NO_ROUTE", "FAILURE_REASON_NO_ROUTE"}))
+	// ties are decided by the most recent failure
+	assert.Equal(t, PaymentFailureTimeout, dominantPaymentFailure([]string{"FAILURE_REASON_TIMEOUT", "FAILURE_REASON_NO_ROUTE"}))
+	assert.Equal(t, PaymentFailureUnknown, dominantPaymentFailure([]string{}))
+}
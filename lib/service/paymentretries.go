@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+)
+
+var ErrPaymentNotRetryable = errors.New("only failed invoice payments can be retried")
+
+// FailedPaymentFor returns the user's latest payment of the payment hash if it failed and the invoice can be paid again
+func (svc *LndhubService) FailedPaymentFor(ctx context.Context, userID int64, paymentHash string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := svc.DB.NewSelect().Model(&invoice).
+		Where("user_id = ? AND type = ? AND r_hash = ?", userID, common.InvoiceTypeOutgoing, paymentHash).
+		OrderExpr("id DESC").Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, ErrPaymentNotRetryable
+	}
+	// keysend payments can not be retried, their custom records are not stored
+	if invoice.State != common.InvoiceStateError || invoice.Keysend || invoice.PaymentRequest == "" {
+		return nil, ErrPaymentNotRetryable
+	}
+	if err := checkInvoiceNotExpired(&invoice, time.Now()); err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// RetryFailedPayment pays the stored invoice of a failed payment again
+// The invoice is only retried if no other payment of the payment hash is in flight or settled
+func (svc *LndhubService) RetryFailedPayment(ctx context.Context, invoice *models.Invoice) (*SendPaymentResponse, error) {
+	res, err := svc.DB.NewUpdate().Model((*models.Invoice)(nil)).
+		Set("state = ?, error_message = NULL, failure_reason = NULL", common.InvoiceStateInitialized).
+		Where("id = ? AND state = ?", invoice.ID, common.InvoiceStateError).
+		Where("NOT EXISTS (SELECT 1 FROM invoices AS other WHERE other.user_id = ? AND other.type = ? AND other.r_hash = ? AND other.state IN (?))",
+			invoice.UserID, common.InvoiceTypeOutgoing, invoice.RHash, bun.In([]string{common.InvoiceStateInitialized, common.InvoiceStateSettled})).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return nil, ErrPaymentNotRetryable
+	}
+	invoice.State = common.InvoiceStateInitialized
+	invoice.ErrorMessage = ""
+	invoice.FailureReason = ""
+	svc.Logger.Infof("Retrying failed payment user_id:%v invoice_id:%v attempts:%v", invoice.UserID, invoice.ID, invoice.PaymentAttempts)
+
+	paymentResponse, err := svc.PayInvoice(ctx, invoice)
+	// payments rejected before the user was debited are marked as failed again, so they can be retried later
+	if err != nil && invoice.State == common.InvoiceStateInitialized {
+		invoice.State = common.InvoiceStateError
+		invoice.ErrorMessage = err.Error()
+		invoice.FailureReason = ClassifyPaymentFailure(invoice.ErrorMessage)
+		_, updateErr := svc.DB.NewUpdate().Model(invoice).Column("state", "error_message", "failure_reason").WherePK().Exec(context.Background())
+		if updateErr != nil {
+			svc.Logger.Errorf("Could not mark rejected payment retry as failed invoice_id:%v %v", invoice.ID, updateErr)
+		}
+	}
+	return paymentResponse, err
+}
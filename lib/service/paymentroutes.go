@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/getsentry/sentry-go"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// paymentRouteFor converts the route returned by the node for the invoice
+func paymentRouteFor(invoice *models.Invoice, route *lnrpc.Route) (*models.PaymentRoute, error) {
+	paymentRoute := &models.PaymentRoute{
+		InvoiceID:     invoice.ID,
+		TotalAmtMsat:  route.TotalAmtMsat,
+		TotalFeesMsat: route.TotalFeesMsat,
+		TotalTimeLock: route.TotalTimeLock,
+		Hops:          make([]models.RouteHop, 0, len(route.Hops)),
+	}
+	// nodes that only report amounts in satoshis, e.g. c-lightning
+	if paymentRoute.TotalAmtMsat == 0 {
+		var err error
+		if paymentRoute.TotalAmtMsat, err = lnd.SatToMsat(route.TotalAmt); err != nil {
+			return nil, err
+		}
+		if paymentRoute.TotalFeesMsat, err = lnd.SatToMsat(route.TotalFees); err != nil {
+			return nil, err
+		}
+	}
+	for _, hop := range route.Hops {
+		paymentRoute.Hops = append(paymentRoute.Hops, models.RouteHop{
+			ChanId:           hop.ChanId,
+			PubKey:           hop.PubKey,
+			AmtToForwardMsat: hop.AmtToForwardMsat,
+			FeeMsat:          hop.FeeMsat,
+			Expiry:           hop.Expiry,
+		})
+	}
+	return paymentRoute, nil
+}
+
+// recordPaymentRoute stores the route of a successful payment, errors are only reported
+func (svc *LndhubService) recordPaymentRoute(ctx context.Context, invoice *models.Invoice, route *lnrpc.Route) {
+	if route == nil {
+		return
+	}
+	paymentRoute, err := paymentRouteFor(invoice, route)
+	if err == nil {
+		_, err = svc.DB.NewInsert().Model(paymentRoute).Exec(ctx)
+	}
+	if err != nil {
+		svc.Logger.Errorf("Could not record payment route invoice_id:%v %v", invoice.ID, err)
+		sentry.CaptureException(err)
+	}
+}
+
+// PaymentRouteFor returns the stored route of an outgoing payment
+func (svc *LndhubService) PaymentRouteFor(ctx context.Context, invoiceId int64) (*models.PaymentRoute, error) {
+	route := models.PaymentRoute{}
+	err := svc.DB.NewSelect().Model(&route).Where("invoice_id = ?", invoiceId).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentRouteFor(t *testing.T) {
+	invoice := &models.Invoice{ID: 42}
+	route, err := paymentRouteFor(invoice, &lnrpc.Route{
+		TotalTimeLock: 720150,
+		TotalAmtMsat:  1002500,
+		TotalFeesMsat: 2500,
+		Hops: []*lnrpc.Hop{
+			{ChanId: 1, PubKey: "02aa", AmtToForwardMsat: 1001000, FeeMsat: 1500, Expiry: 720110},
+			{ChanId: 2, PubKey: "03bb", AmtToForwardMsat: 1000000, FeeMsat: 1000, Expiry: 720070},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), route.InvoiceID)
+	assert.Equal(t, uint32(720150), route.TotalTimeLock)
+	assert.Equal(t, int64(2500), route.TotalFeesMsat)
+	assert.Equal(t, 2, len(route.Hops))
+	assert.Equal(t, models.RouteHop{ChanId: 2, PubKey: "03bb", AmtToForwardMsat: 1000000, FeeMsat: 1000, Expiry: 720070}, route.Hops[1])
+
+	// c-lightning only reports the totals in satoshis
+	route, err = paymentRouteFor(invoice, &lnrpc.Route{TotalAmt: 1003, TotalFees: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1003000), route.TotalAmtMsat)
+	assert.Equal(t, int64(3000), route.TotalFeesMsat)
+	assert.Empty(t, route.Hops)
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+)
+
+// PersonalDataFormatVersion is increased when fields of the personal data export change or are removed
+const PersonalDataFormatVersion = "1"
+
+// PersonalDataExport is everything stored about a user, the password hash is left out
+type PersonalDataExport struct {
+	FormatVersion       string                      `json:"format_version"`
+	GeneratedAt         time.Time                   `json:"generated_at"`
+	User                PersonalDataUser            `json:"user"`
+	Balance             int64                       `json:"balance"`
+	Accounts            []PersonalDataAccount       `json:"accounts"`
+	Invoices            []models.Invoice            `json:"invoices"`
+	ArchivedInvoices    []models.ArchivedInvoice    `json:"archived_invoices"`
+	TransactionEntries  []PersonalDataEntry         `json:"transaction_entries"`
+	Contacts            []models.Contact            `json:"contacts"`
+	KeysendDestinations []models.KeysendDestination `json:"keysend_destinations"`
+	InvoicePresets      []models.InvoicePreset      `json:"invoice_presets"`
+	RecurringInvoices   []models.RecurringInvoice   `json:"recurring_invoices"`
+	DonationPage        *models.DonationPage        `json:"donation_page"`
+	AutoWithdrawal      *models.AutoWithdrawal      `json:"auto_withdrawal"`
+	PrismSplits         []models.PrismSplit         `json:"prism_splits"`
+	APIKeys             []models.APIKey             `json:"api_keys"`
+	AuthSessions        []models.AuthSession        `json:"auth_sessions"`
+	Notifications       []models.Notification       `json:"notifications"`
+	BalanceClaims       []models.BalanceClaim       `json:"balance_claims"`
+}
+
+type PersonalDataUser struct {
+	ID            int64      `json:"id"`
+	Login         string     `json:"login"`
+	Email         string     `json:"email,omitempty"`
+	Tier          string     `json:"tier"`
+	DisplayUnit   string     `json:"display_unit"`
+	Currency      string     `json:"currency,omitempty"`
+	Timezone      string     `json:"timezone"`
+	MemoPublicKey string     `json:"memo_public_key,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at"`
+	FrozenAt      *time.Time `json:"frozen_at"`
+}
+
+type PersonalDataAccount struct {
+	ID    int64  `json:"id"`
+	Type  string `json:"type"`
+	Asset string `json:"asset"`
+}
+
+type PersonalDataEntry struct {
+	ID              int64     `json:"id"`
+	InvoiceID       int64     `json:"invoice_id"`
+	ParentID        int64     `json:"parent_id,omitempty"`
+	DebitAccountID  int64     `json:"debit_account_id"`
+	CreditAccountID int64     `json:"credit_account_id"`
+	Amount          int64     `json:"amount"`
+	Asset           string    `json:"asset"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func nullTimePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func personalDataUser(user *models.User) PersonalDataUser {
+	return PersonalDataUser{
+		ID:            user.ID,
+		Login:         user.Login,
+		Email:         user.Email.String,
+		Tier:          user.Tier,
+		DisplayUnit:   user.DisplayUnit,
+		Currency:      user.Currency,
+		Timezone:      user.Timezone,
+		MemoPublicKey: user.MemoPublicKey,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     nullTimePtr(user.UpdatedAt.Time),
+		FrozenAt:      nullTimePtr(user.FrozenAt.Time),
+	}
+}
+
+// ExportPersonalData collects the account, invoices, ledger entries and saved data of the user
+// All lists are ordered by id, empty lists are exported as empty arrays
+func (svc *LndhubService) ExportPersonalData(ctx context.Context, userId int64) (*PersonalDataExport, error) {
+	db := svc.readDB(ctx)
+	user := models.User{}
+	if err := db.NewSelect().Model(&user).Where("id = ?", userId).Scan(ctx); err != nil {
+		return nil, err
+	}
+	balance, err := svc.CurrentUserBalance(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	export := &PersonalDataExport{
+		FormatVersion:       PersonalDataFormatVersion,
+		GeneratedAt:         time.Now().UTC(),
+		User:                personalDataUser(&user),
+		Balance:             balance,
+		Accounts:            []PersonalDataAccount{},
+		Invoices:            []models.Invoice{},
+		ArchivedInvoices:    []models.ArchivedInvoice{},
+		TransactionEntries:  []PersonalDataEntry{},
+		Contacts:            []models.Contact{},
+		KeysendDestinations: []models.KeysendDestination{},
+		InvoicePresets:      []models.InvoicePreset{},
+		RecurringInvoices:   []models.RecurringInvoice{},
+		PrismSplits:         []models.PrismSplit{},
+		APIKeys:             []models.APIKey{},
+		AuthSessions:        []models.AuthSession{},
+		Notifications:       []models.Notification{},
+		BalanceClaims:       []models.BalanceClaim{},
+	}
+
+	accounts := []models.Account{}
+	if err := db.NewSelect().Model(&accounts).Where("user_id = ?", userId).Order("id").Scan(ctx); err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		export.Accounts = append(export.Accounts, PersonalDataAccount{ID: account.ID, Type: account.Type, Asset: account.Asset})
+	}
+	entries := []models.TransactionEntry{}
+	if err := db.NewSelect().Model(&entries).Where("user_id = ?", userId).Order("id").Scan(ctx); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		export.TransactionEntries = append(export.TransactionEntries, PersonalDataEntry{
+			ID:              entry.ID,
+			InvoiceID:       entry.InvoiceID,
+			ParentID:        entry.ParentID,
+			DebitAccountID:  entry.DebitAccountID,
+			CreditAccountID: entry.CreditAccountID,
+			Amount:          entry.Amount,
+			Asset:           entry.Asset,
+			CreatedAt:       entry.CreatedAt,
+		})
+	}
+
+	lists := []interface{}{
+		&export.Invoices,
+		&export.ArchivedInvoices,
+		&export.Contacts,
+		&export.KeysendDestinations,
+		&export.InvoicePresets,
+		&export.RecurringInvoices,
+		&export.PrismSplits,
+		&export.APIKeys,
+		&export.AuthSessions,
+		&export.Notifications,
+		&export.BalanceClaims,
+	}
+	for _, list := range lists {
+		if err := db.NewSelect().Model(list).Where("user_id = ?", userId).Order("id").Scan(ctx); err != nil {
+			return nil, err
+		}
+	}
+	// metadata moved to the blob store is part of the export as well
+	for i := range export.Invoices {
+		if err := svc.ResolveInvoiceBlobs(ctx, &export.Invoices[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range export.ArchivedInvoices {
+		if err := svc.ResolveInvoiceBlobs(ctx, &export.ArchivedInvoices[i].Invoice); err != nil {
+			return nil, err
+		}
+	}
+
+	donationPages := []models.DonationPage{}
+	if err := db.NewSelect().Model(&donationPages).Where("user_id = ?", userId).Limit(1).Scan(ctx); err != nil {
+		return nil, err
+	}
+	if len(donationPages) > 0 {
+		export.DonationPage = &donationPages[0]
+	}
+	autoWithdrawals := []models.AutoWithdrawal{}
+	if err := db.NewSelect().Model(&autoWithdrawals).Where("user_id = ?", userId).Limit(1).Scan(ctx); err != nil {
+		return nil, err
+	}
+	if len(autoWithdrawals) > 0 {
+		export.AutoWithdrawal = &autoWithdrawals[0]
+	}
+	return export, nil
+}
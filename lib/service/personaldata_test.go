@@ -0,0 +1,37 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+func TestPersonalDataUser(t *testing.T) {
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	user := &models.User{
+		ID:          42,
+		Login:       "alice",
+		Password:    "$2a$10$secret",
+		Email:       sql.NullString{String: "alice@example.com", Valid: true},
+		Tier:        "basic",
+		DisplayUnit: "sats",
+		Timezone:    "Europe/Berlin",
+		CreatedAt:   created,
+		FrozenAt:    bun.NullTime{Time: created.Add(time.Hour)},
+	}
+	exported := personalDataUser(user)
+	assert.Equal(t, "alice", exported.Login)
+	assert.Equal(t, "alice@example.com", exported.Email)
+	assert.Nil(t, exported.UpdatedAt)
+	assert.Equal(t, created.Add(time.Hour), *exported.FrozenAt)
+
+	b, err := json.Marshal(PersonalDataExport{User: exported, Invoices: []models.Invoice{{ID: 1, User: user}}})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "secret")
+	assert.Contains(t, string(b), `"timezone":"Europe/Berlin"`)
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+)
+
+const (
+	fiatRatesCacheDuration = 10 * time.Minute
+	fiatRatesTimeout       = 10 * time.Second
+	satsPerBTC             = 100000000
+)
+
+var ErrInvalidPreferences = errors.New("invalid display unit, currency or timezone")
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// UserPreferences are the display preferences clients and formatted fields use for amounts and times
+type UserPreferences struct {
+	DisplayUnit string `json:"display_unit"`
+	Currency    string `json:"currency,omitempty"`
+	Timezone    string `json:"timezone"`
+}
+
+// Validate checks the unit, the ISO 4217 currency code and the IANA timezone
+// A currency is required for fiat amounts
+func (p *UserPreferences) Validate() error {
+	switch p.DisplayUnit {
+	case common.DisplayUnitSats, common.DisplayUnitBTC:
+	case common.DisplayUnitFiat:
+		if p.Currency == "" {
+			return ErrInvalidPreferences
+		}
+	default:
+		return ErrInvalidPreferences
+	}
+	if p.Currency != "" && !currencyCodePattern.MatchString(p.Currency) {
+		return ErrInvalidPreferences
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return ErrInvalidPreferences
+	}
+	return nil
+}
+
+func (svc *LndhubService) PreferencesFor(ctx context.Context, userId int64) (*UserPreferences, error) {
+	user, err := svc.FindUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return &UserPreferences{DisplayUnit: user.DisplayUnit, Currency: user.Currency, Timezone: user.Timezone}, nil
+}
+
+func (svc *LndhubService) SetPreferences(ctx context.Context, userId int64, preferences *UserPreferences) error {
+	preferences.Currency = strings.ToUpper(preferences.Currency)
+	if err := preferences.Validate(); err != nil {
+		return err
+	}
+	_, err := svc.DB.NewUpdate().Model((*models.User)(nil)).
+		Set("display_unit = ?", preferences.DisplayUnit).
+		Set("currency = NULLIF(?, '')", preferences.Currency).
+		Set("timezone = ?", preferences.Timezone).
+		Set("updated_at = current_timestamp").
+		Where("id = ?", userId).
+		Exec(ctx)
+	return err
+}
+
+// AmountFormatter formats amounts and times with the preferences of a user
+type AmountFormatter struct {
+	preferences UserPreferences
+	location    *time.Location
+	fiatRate    float64
+}
+
+// AmountFormatterFor returns a formatter for the user's preferences
+// Fiat amounts fall back to sats if no exchange rate is available
+func (svc *LndhubService) AmountFormatterFor(ctx context.Context, userId int64) (*AmountFormatter, error) {
+	preferences, err := svc.PreferencesFor(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(preferences.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	formatter := &AmountFormatter{preferences: *preferences, location: location}
+	if preferences.DisplayUnit == common.DisplayUnitFiat {
+		rate, err := svc.FiatRate(ctx, preferences.Currency)
+		if err != nil {
+			svc.Logger.Errorf("Could not get fiat rate currency:%s %v", preferences.Currency, err)
+			formatter.preferences.DisplayUnit = common.DisplayUnitSats
+		}
+		formatter.fiatRate = rate
+	}
+	return formatter, nil
+}
+
+// FormatAmount formats an amount in satoshis, e.g. "1,500 sats", "0.00001500 BTC" or "0.65 EUR"
+func (f *AmountFormatter) FormatAmount(amount int64) string {
+	switch f.preferences.DisplayUnit {
+	case common.DisplayUnitBTC:
+		return strconv.FormatFloat(float64(amount)/satsPerBTC, 'f', 8, 64) + " BTC"
+	case common.DisplayUnitFiat:
+		return strconv.FormatFloat(float64(amount)/satsPerBTC*f.fiatRate, 'f', 2, 64) + " " + f.preferences.Currency
+	}
+	return groupThousands(amount) + " sats"
+}
+
+// FormatTime formats a time in the user's timezone
+func (f *AmountFormatter) FormatTime(t time.Time) string {
+	return t.In(f.location).Format(time.RFC3339)
+}
+
+func groupThousands(amount int64) string {
+	digits := strconv.FormatInt(amount, 10)
+	sign := ""
+	if amount < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return sign + digits
+}
+
+type fiatRatesState struct {
+	mu        sync.Mutex
+	rates     map[string]float64
+	fetchedAt time.Time
+}
+
+// FiatRate returns the price of one bitcoin in the currency
+// The rates are fetched from FIAT_RATES_URL, a JSON object of prices by currency code, and cached for 10 minutes
+func (svc *LndhubService) FiatRate(ctx context.Context, currency string) (float64, error) {
+	if svc.Config.FiatRatesUrl == "" {
+		return 0, errors.New("FIAT_RATES_URL is not configured")
+	}
+	svc.fiatRates.mu.Lock()
+	defer svc.fiatRates.mu.Unlock()
+	if time.Since(svc.fiatRates.fetchedAt) > fiatRatesCacheDuration {
+		rates, err := svc.fetchFiatRates(ctx)
+		if err != nil {
+			return 0, err
+		}
+		svc.fiatRates.rates = rates
+		svc.fiatRates.fetchedAt = time.Now()
+	}
+	rate, ok := svc.fiatRates.rates[currency]
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("no fiat rate for %s", currency)
+	}
+	return rate, nil
+}
+
+func (svc *LndhubService) fetchFiatRates(ctx context.Context) (map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, fiatRatesTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, svc.Config.FiatRatesUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	rates := map[string]float64{}
+	if err := json.NewDecoder(resp.Body).Decode(&rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserPreferencesValidate(t *testing.T) {
+	assert.NoError(t, (&UserPreferences{DisplayUnit: common.DisplayUnitSats, Timezone: "UTC"}).Validate())
+	assert.NoError(t, (&UserPreferences{DisplayUnit: common.DisplayUnitFiat, Currency: "EUR", Timezone: "Europe/Berlin"}).Validate())
+	assert.ErrorIs(t, (&UserPreferences{DisplayUnit: common.DisplayUnitFiat, Timezone: "UTC"}).Validate(), ErrInvalidPreferences)
+	assert.ErrorIs(t, (&UserPreferences{DisplayUnit: "msats", Timezone: "UTC"}).Validate(), ErrInvalidPreferences)
+	assert.ErrorIs(t, (&UserPreferences{DisplayUnit: common.DisplayUnitBTC, Currency: "euro", Timezone: "UTC"}).Validate(), ErrInvalidPreferences)
+	assert.ErrorIs(t, (&UserPreferences{DisplayUnit: common.DisplayUnitBTC, Timezone: "Mars/Olympus"}).Validate(), ErrInvalidPreferences)
+}
+
+func TestAmountFormatter(t *testing.T) {
+	formatter := &AmountFormatter{preferences: UserPreferences{DisplayUnit: common.DisplayUnitSats}, location: time.UTC}
+	assert.Equal(t, "1,234,567 sats", formatter.FormatAmount(1234567))
+	assert.Equal(t, "999 sats", formatter.FormatAmount(999))
+	assert.Equal(t, "-1,000 sats", formatter.FormatAmount(-1000))
+
+	formatter.preferences.DisplayUnit = common.DisplayUnitBTC
+	assert.Equal(t, "0.00001500 BTC", formatter.FormatAmount(1500))
+
+	formatter.preferences = UserPreferences{DisplayUnit: common.DisplayUnitFiat, Currency: "USD"}
+	formatter.fiatRate = 40000
+	assert.Equal(t, "0.60 USD", formatter.FormatAmount(1500))
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	formatter.location = berlin
+	assert.Equal(t, "2022-01-01T01:00:00+01:00", formatter.FormatTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)))
+}
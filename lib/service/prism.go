@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/uptrace/bun"
+)
+
+const maxPrismSplits = 10
+
+// memo of the payouts of a split
+const prismSplitMemo = "Prism split"
+
+var ErrInvalidPrismSplits = errors.New("splits need a login or lightning address and percentages of 1 to 100 that add up to at most 100")
+
+// validatePrismSplits checks the percentages and the destinations, logins are checked when the splits are saved
+func validatePrismSplits(splits []models.PrismSplit) error {
+	if len(splits) > maxPrismSplits {
+		return ErrInvalidPrismSplits
+	}
+	var total int64
+	for _, split := range splits {
+		if split.Percentage < 1 || split.Percentage > 100 || split.Destination == "" {
+			return ErrInvalidPrismSplits
+		}
+		if strings.Contains(split.Destination, "@") {
+			if _, err := LnurlPayUrl(split.Destination); err != nil {
+				return ErrInvalidPrismSplits
+			}
+		}
+		total += split.Percentage
+	}
+	if total > 100 {
+		return ErrInvalidPrismSplits
+	}
+	return nil
+}
+
+// prismSplitAmount is the share of the amount, rounded down to whole satoshis
+func prismSplitAmount(amount, percentage int64) int64 {
+	return amount * percentage / 100
+}
+
+// SetPrismSplits replaces the splits applied to the user's incoming payments, no splits turn the prism off
+func (svc *LndhubService) SetPrismSplits(ctx context.Context, userId int64, splits []models.PrismSplit) ([]models.PrismSplit, error) {
+	for i := range splits {
+		splits[i].ID = 0
+		splits[i].UserID = userId
+		splits[i].Destination = strings.TrimSpace(splits[i].Destination)
+	}
+	if err := validatePrismSplits(splits); err != nil {
+		return nil, err
+	}
+	for _, split := range splits {
+		if strings.Contains(split.Destination, "@") {
+			continue
+		}
+		recipient, err := svc.FindUserByLogin(ctx, split.Destination)
+		if err != nil || recipient.ID == userId || !recipient.DeletedAt.IsZero() {
+			return nil, ErrInvalidPrismSplits
+		}
+	}
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewDelete().Model((*models.PrismSplit)(nil)).Where("user_id = ?", userId).Exec(ctx); err != nil {
+			return err
+		}
+		if len(splits) == 0 {
+			return nil
+		}
+		_, err := tx.NewInsert().Model(&splits).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return splits, nil
+}
+
+func (svc *LndhubService) PrismSplitsFor(ctx context.Context, userId int64) ([]models.PrismSplit, error) {
+	splits := []models.PrismSplit{}
+	err := svc.DB.NewSelect().Model(&splits).Where("user_id = ?", userId).OrderExpr("id ASC").Scan(ctx)
+	return splits, err
+}
+
+// enqueuePrismSplits queues the payouts of the user's splits of a settled incoming invoice
+// Payouts of splits are not split again, otherwise two users splitting to each other would pay back and forth
+func (svc *LndhubService) enqueuePrismSplits(ctx context.Context, invoice *models.Invoice) error {
+	if invoice.Type != common.InvoiceTypeIncoming || invoice.Metadata[common.InvoiceMetadataSource] == common.InvoiceSourcePrism {
+		return nil
+	}
+	hasSplits, err := svc.DB.NewSelect().Model((*models.PrismSplit)(nil)).Where("user_id = ?", invoice.UserID).Exists(ctx)
+	if err != nil || !hasSplits {
+		return err
+	}
+	return svc.EnqueueJob(ctx, common.JobTypePrismSplits, &InvoiceJobPayload{InvoiceID: invoice.ID})
+}
+
+// handlePrismSplitsJob pays out the splits of the amount the user was credited for the invoice
+// Failed payouts are kept as failed outgoing invoices and are not retried, a retry could pay a split twice
+func handlePrismSplitsJob(svc *LndhubService, ctx context.Context, payload []byte) error {
+	var jobPayload InvoiceJobPayload
+	if err := json.Unmarshal(payload, &jobPayload); err != nil {
+		return err
+	}
+	var invoice models.Invoice
+	err := svc.DB.NewSelect().Model(&invoice).Where("id = ?", jobPayload.InvoiceID).Limit(1).Scan(ctx)
+	if err != nil {
+		return err
+	}
+	splits, err := svc.PrismSplitsFor(ctx, invoice.UserID)
+	if err != nil {
+		return err
+	}
+	credited := invoice.Amount - invoice.ServiceFee
+	for i := range splits {
+		amount := prismSplitAmount(credited, splits[i].Percentage)
+		if amount <= 0 {
+			continue
+		}
+		if err := svc.payPrismSplit(ctx, &splits[i], amount); err != nil {
+			svc.Logger.Errorf("Prism split payout failed user_id:%v invoice_id:%v destination:%s amount:%v %v", invoice.UserID, invoice.ID, splits[i].Destination, amount, err)
+		}
+	}
+	return nil
+}
+
+// payPrismSplit transfers the amount to a user of this hub or pays an LNURL-pay invoice of the lightning address
+func (svc *LndhubService) payPrismSplit(ctx context.Context, split *models.PrismSplit, amount int64) error {
+	if !strings.Contains(split.Destination, "@") {
+		invoice, err := svc.addTransferInvoice(ctx, split.UserID, split.Destination, amount, prismSplitMemo, common.InvoiceSourcePrism)
+		if err != nil {
+			return err
+		}
+		_, err = svc.PayInvoice(ctx, invoice)
+		return err
+	}
+	params, err := svc.ResolveLnurlPay(ctx, split.Destination)
+	if err != nil {
+		return err
+	}
+	paymentRequest, decodedPaymentRequest, err := svc.FetchLnurlPayInvoice(ctx, params, amount, prismSplitMemo)
+	if err != nil {
+		return err
+	}
+	invoice, err := svc.AddOutgoingInvoice(ctx, split.UserID, paymentRequest, &lnd.LNPayReq{PayReq: decodedPaymentRequest})
+	if err != nil {
+		return err
+	}
+	invoice.Metadata = map[string]string{common.InvoiceMetadataSource: common.InvoiceSourcePrism}
+	if _, err := svc.DB.NewUpdate().Model(invoice).Column("metadata").WherePK().Exec(ctx); err != nil {
+		return err
+	}
+	_, err = svc.PayInvoice(ctx, invoice)
+	return err
+}
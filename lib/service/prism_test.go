@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePrismSplits(t *testing.T) {
+	assert.NoError(t, validatePrismSplits(nil))
+	assert.NoError(t, validatePrismSplits([]models.PrismSplit{
+		{Destination: "bob", Percentage: 60},
+		{Destination: "satoshi@example.com", Percentage: 40},
+	}))
+	assert.ErrorIs(t, validatePrismSplits([]models.PrismSplit{
+		{Destination: "bob", Percentage: 60},
+		{Destination: "alice", Percentage: 41},
+	}), ErrInvalidPrismSplits)
+	assert.ErrorIs(t, validatePrismSplits([]models.PrismSplit{{Destination: "bob", Percentage: 0}}), ErrInvalidPrismSplits)
+	assert.ErrorIs(t, validatePrismSplits([]models.PrismSplit{{Destination: "", Percentage: 10}}), ErrInvalidPrismSplits)
+	assert.ErrorIs(t, validatePrismSplits([]models.PrismSplit{{Destination: "@example.com", Percentage: 10}}), ErrInvalidPrismSplits)
+
+	tooMany := make([]models.PrismSplit, maxPrismSplits+1)
+	for i := range tooMany {
+		tooMany[i] = models.PrismSplit{Destination: "bob", Percentage: 1}
+	}
+	assert.ErrorIs(t, validatePrismSplits(tooMany), ErrInvalidPrismSplits)
+}
+
+func TestPrismSplitAmount(t *testing.T) {
+	assert.Equal(t, int64(250), prismSplitAmount(1000, 25))
+	assert.Equal(t, int64(3), prismSplitAmount(10, 33))
+	assert.Equal(t, int64(0), prismSplitAmount(1, 50))
+}
+
+func TestEnqueuePrismSplitsSkipsPayoutsOfSplits(t *testing.T) {
+	svc := &LndhubService{Config: &Config{}}
+	outgoing := &models.Invoice{Type: common.InvoiceTypeOutgoing}
+	assert.NoError(t, svc.enqueuePrismSplits(context.Background(), outgoing))
+	payout := &models.Invoice{Type: common.InvoiceTypeIncoming, Metadata: map[string]string{common.InvoiceMetadataSource: common.InvoiceSourcePrism}}
+	assert.NoError(t, svc.enqueuePrismSplits(context.Background(), payout))
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+const reconcilePageSize = 500
+
+// ReconcileSettlements pages through the node's invoices starting at the oldest invoice that is open in the DB
+// and processes the invoices that are settled on the node but not in the DB, e.g. after a long downtime
+// It returns the number of settlements that were backfilled
+func (svc *LndhubService) ReconcileSettlements(ctx context.Context) (int, error) {
+	var oldestOpen models.Invoice
+	err := svc.DB.NewSelect().Model(&oldestOpen).
+		Where("type = ? AND state <> ? AND add_index IS NOT NULL", common.InvoiceTypeIncoming, common.InvoiceStateSettled).
+		OrderExpr("add_index ASC").Limit(1).Scan(ctx)
+	if err != nil {
+		// nothing open, nothing to reconcile
+		return 0, nil
+	}
+
+	backfilled := 0
+	offset := oldestOpen.AddIndex - 1
+	for {
+		resp, err := svc.LndClient.ListInvoices(ctx, &lnrpc.ListInvoiceRequest{IndexOffset: offset, NumMaxInvoices: reconcilePageSize})
+		if err != nil {
+			return backfilled, err
+		}
+		for _, rawInvoice := range resp.Invoices {
+			if rawInvoice.State != lnrpc.Invoice_SETTLED {
+				continue
+			}
+			open, err := svc.DB.NewSelect().Model((*models.Invoice)(nil)).
+				Where("type = ? AND r_hash = ? AND state <> ?", common.InvoiceTypeIncoming, hex.EncodeToString(rawInvoice.RHash), common.InvoiceStateSettled).
+				Exists(ctx)
+			if err != nil {
+				return backfilled, err
+			}
+			if !open {
+				continue
+			}
+			svc.Logger.Infof("Backfilling missed settlement r_hash:%s add_index:%v", hex.EncodeToString(rawInvoice.RHash), rawInvoice.AddIndex)
+			if err := svc.ProcessInvoiceUpdate(ctx, rawInvoice); err != nil {
+				return backfilled, err
+			}
+			backfilled++
+		}
+		if len(resp.Invoices) == 0 || resp.LastIndexOffset <= offset {
+			return backfilled, nil
+		}
+		offset = resp.LastIndexOffset
+	}
+}
+
+// StartSettlementReconciler backfills missed settlements on startup and every RECONCILE_INTERVAL
+func (svc *LndhubService) StartSettlementReconciler(ctx context.Context) {
+	if svc.Config.ReconcileInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(svc.Config.ReconcileInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		backfilled, err := svc.ReconcileSettlements(ctx)
+		if err != nil {
+			svc.Logger.Errorf("Error reconciling settlements: %v", err)
+			sentry.CaptureException(err)
+		}
+		if backfilled > 0 {
+			svc.Logger.Errorf("Backfilled %v settlements missed by the invoice subscription", backfilled)
+			sentry.CaptureMessage("Backfilled settlements missed by the invoice subscription")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+)
+
+const minRecurringInvoiceInterval = 60 // in seconds
+
+var ErrInvalidRecurringInterval = errors.New("invalid recurring invoice interval")
+
+// CreateRecurringInvoice saves the template and generates the invoice of the first period right away
+func (svc *LndhubService) CreateRecurringInvoice(ctx context.Context, recurring *models.RecurringInvoice) (*models.RecurringInvoice, error) {
+	if err := svc.validateRecurringInterval(recurring.Interval); err != nil {
+		return nil, err
+	}
+	invoice, err := svc.addRecurringInvoice(ctx, recurring)
+	if err != nil {
+		return nil, err
+	}
+	recurring.Enabled = true
+	recurring.CurrentInvoiceID = invoice.ID
+	recurring.NextRunAt = time.Now().Add(time.Duration(recurring.Interval) * time.Second)
+	if _, err := svc.DB.NewInsert().Model(recurring).Returning("*").Exec(ctx); err != nil {
+		return nil, err
+	}
+	return recurring, nil
+}
+
+// UpdateRecurringInvoice changes the amount, memo and enabled state of the template
+// Changes apply from the next period on, a re-enabled template starts a new period right away
+func (svc *LndhubService) UpdateRecurringInvoice(ctx context.Context, userId, recurringId int64, amount int64, memo string, enabled bool) (*models.RecurringInvoice, error) {
+	recurring, err := svc.FindRecurringInvoice(ctx, userId, recurringId)
+	if err != nil {
+		return nil, err
+	}
+	if enabled && !recurring.Enabled {
+		// periods while the template was disabled are not reported as missed
+		recurring.CurrentInvoiceID = 0
+		recurring.NextRunAt = time.Now()
+	}
+	recurring.Amount = amount
+	recurring.Memo = memo
+	recurring.Enabled = enabled
+	if _, err := svc.DB.NewUpdate().Model(recurring).WherePK().Exec(ctx); err != nil {
+		return nil, err
+	}
+	return recurring, nil
+}
+
+func (svc *LndhubService) FindRecurringInvoice(ctx context.Context, userId, recurringId int64) (*models.RecurringInvoice, error) {
+	var recurring models.RecurringInvoice
+	err := svc.DB.NewSelect().Model(&recurring).Where("id = ? AND user_id = ?", recurringId, userId).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &recurring, nil
+}
+
+func (svc *LndhubService) RecurringInvoicesFor(ctx context.Context, userId int64) ([]models.RecurringInvoice, error) {
+	recurring := []models.RecurringInvoice{}
+	err := svc.DB.NewSelect().Model(&recurring).Where("user_id = ?", userId).OrderExpr("id ASC").Scan(ctx)
+	return recurring, err
+}
+
+func (svc *LndhubService) DeleteRecurringInvoice(ctx context.Context, userId, recurringId int64) error {
+	_, err := svc.DB.NewDelete().Model((*models.RecurringInvoice)(nil)).Where("id = ? AND user_id = ?", recurringId, userId).Exec(ctx)
+	return err
+}
+
+// CurrentRecurringInvoice returns the invoice generated for the running period
+func (svc *LndhubService) CurrentRecurringInvoice(ctx context.Context, recurring *models.RecurringInvoice) (*models.Invoice, error) {
+	if recurring.CurrentInvoiceID == 0 {
+		return nil, sql.ErrNoRows
+	}
+	var invoice models.Invoice
+	err := svc.DB.NewSelect().Model(&invoice).Where("id = ? AND user_id = ?", recurring.CurrentInvoiceID, recurring.UserID).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// GenerateRecurringInvoices starts a new period for the enabled templates that are due
+// It returns the number of invoices that were generated
+func (svc *LndhubService) GenerateRecurringInvoices(ctx context.Context) (int, error) {
+	due := []models.RecurringInvoice{}
+	err := svc.DB.NewSelect().Model(&due).Where("enabled AND next_run_at <= ?", time.Now()).OrderExpr("next_run_at ASC").Scan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	generated := 0
+	for i := range due {
+		if err := svc.rollRecurringInvoice(ctx, &due[i]); err != nil {
+			svc.Logger.Errorf("Could not generate recurring invoice recurring_invoice_id:%v %v", due[i].ID, err)
+			continue
+		}
+		generated++
+	}
+	return generated, nil
+}
+
+// rollRecurringInvoice reports the invoice of the ended period if it was not paid and generates the invoice of the next one
+// If the invoice can not be generated the template is retried on the next run
+func (svc *LndhubService) rollRecurringInvoice(ctx context.Context, recurring *models.RecurringInvoice) error {
+	if recurring.CurrentInvoiceID != 0 {
+		previous, err := svc.CurrentRecurringInvoice(ctx, recurring)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if previous != nil && previous.State != common.InvoiceStateSettled {
+			svc.Logger.Infof("Recurring invoice missed recurring_invoice_id:%v invoice_id:%v", recurring.ID, previous.ID)
+			svc.enqueueRecurringInvoiceWebhook(ctx, common.WebhookEventRecurringInvoiceMissed, recurring, previous)
+		}
+		recurring.CurrentInvoiceID = 0
+	}
+	invoice, err := svc.addRecurringInvoice(ctx, recurring)
+	if err == nil {
+		recurring.CurrentInvoiceID = invoice.ID
+		recurring.NextRunAt = nextRecurringRun(recurring.NextRunAt, recurring.Interval, time.Now())
+	}
+	if _, updateErr := svc.DB.NewUpdate().Model(recurring).WherePK().Exec(ctx); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+func (svc *LndhubService) addRecurringInvoice(ctx context.Context, recurring *models.RecurringInvoice) (*models.Invoice, error) {
+	invoice := models.Invoice{
+		UserID: recurring.UserID,
+		Amount: recurring.Amount,
+		Memo:   recurring.Memo,
+	}
+	return svc.addIncomingInvoice(ctx, &invoice, recurringInvoiceExpiry(recurring.Interval, svc.Config.InvoiceMaxExpiry))
+}
+
+// recurringInvoiceSettled emits the recurring_invoice.paid event if the invoice is the current invoice of a template
+func (svc *LndhubService) recurringInvoiceSettled(ctx context.Context, invoice *models.Invoice) error {
+	var recurring models.RecurringInvoice
+	err := svc.DB.NewSelect().Model(&recurring).Where("current_invoice_id = ?", invoice.ID).Limit(1).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return svc.enqueueRecurringInvoiceWebhook(ctx, common.WebhookEventRecurringInvoicePaid, &recurring, invoice)
+}
+
+func (svc *LndhubService) enqueueRecurringInvoiceWebhook(ctx context.Context, event string, recurring *models.RecurringInvoice, invoice *models.Invoice) error {
+	err := svc.EnqueueWebhook(ctx, svc.Config.WebhookUrl, &WebhookPayload{
+		Event:            event,
+		Invoice:          webhookInvoicePayload(invoice),
+		RecurringInvoice: recurring,
+	})
+	if err != nil {
+		svc.Logger.Errorf("Could not enqueue webhook recurring_invoice_id:%v event:%s %v", recurring.ID, event, err)
+	}
+	return err
+}
+
+func (svc *LndhubService) validateRecurringInterval(interval int64) error {
+	if interval < minRecurringInvoiceInterval || interval < svc.Config.InvoiceMinExpiry {
+		return ErrInvalidRecurringInterval
+	}
+	return nil
+}
+
+// recurringInvoiceExpiry lets the invoice expire with its period, unless the period is longer than INVOICE_MAX_EXPIRY
+func recurringInvoiceExpiry(interval, maxExpiry int64) time.Duration {
+	if maxExpiry > 0 && interval > maxExpiry {
+		interval = maxExpiry
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// nextRecurringRun returns the start of the next period after now
+// Periods that passed while the generator was not running are skipped
+func nextRecurringRun(nextRunAt time.Time, interval int64, now time.Time) time.Time {
+	period := time.Duration(interval) * time.Second
+	next := nextRunAt.Add(period)
+	if next.After(now) {
+		return next
+	}
+	skipped := now.Sub(next)/period + 1
+	return next.Add(skipped * period)
+}
+
+// StartRecurringInvoiceScheduler generates the invoices of new periods every RECURRING_INVOICE_INTERVAL
+func (svc *LndhubService) StartRecurringInvoiceScheduler(ctx context.Context) {
+	if svc.Config.RecurringInvoiceInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(svc.Config.RecurringInvoiceInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		generated, err := svc.GenerateRecurringInvoices(ctx)
+		if err != nil {
+			svc.Logger.Errorf("Error generating recurring invoices: %v", err)
+			sentry.CaptureException(err)
+		}
+		if generated > 0 {
+			svc.Logger.Infof("Generated %v recurring invoices", generated)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextRecurringRun(t *testing.T) {
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	hour := int64(3600)
+	// the next period starts one interval after the current one
+	assert.Equal(t, start.Add(time.Hour), nextRecurringRun(start, hour, start.Add(time.Minute)))
+	// periods that passed while the generator was not running are skipped
+	assert.Equal(t, start.Add(4*time.Hour), nextRecurringRun(start, hour, start.Add(3*time.Hour+time.Minute)))
+	// the next period always starts after now
+	assert.Equal(t, start.Add(3*time.Hour), nextRecurringRun(start, hour, start.Add(2*time.Hour)))
+}
+
+func TestRecurringInvoiceExpiry(t *testing.T) {
+	assert.Equal(t, time.Hour, recurringInvoiceExpiry(3600, 604800))
+	assert.Equal(t, 604800*time.Second, recurringInvoiceExpiry(2592000, 604800))
+	assert.Equal(t, 2592000*time.Second, recurringInvoiceExpiry(2592000, 0))
+}
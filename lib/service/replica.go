@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+type readReplicaKey struct{}
+
+// WithReadReplica marks a read-only request, its queries can be served by the read replica
+// The replica lags behind the primary, requests deciding on a balance must not use it
+func WithReadReplica(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readReplicaKey{}, true)
+}
+
+// readDB returns the read replica for requests marked with WithReadReplica and the primary otherwise
+func (svc *LndhubService) readDB(ctx context.Context) *bun.DB {
+	if replica, _ := ctx.Value(readReplicaKey{}).(bool); replica && svc.ReplicaDB != nil {
+		return svc.ReplicaDB
+	}
+	return svc.DB
+}
@@ -0,0 +1,20 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+func TestReadDB(t *testing.T) {
+	primary, replica := &bun.DB{}, &bun.DB{}
+	svc := &LndhubService{DB: primary}
+	ctx := context.Background()
+	assert.Same(t, primary, svc.readDB(WithReadReplica(ctx)))
+
+	svc.ReplicaDB = replica
+	assert.Same(t, primary, svc.readDB(ctx))
+	assert.Same(t, replica, svc.readDB(WithReadReplica(ctx)))
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/uptrace/bun"
+)
+
+const (
+	AuditActionApplyRetention = "apply_retention"
+
+	RetentionRuleArchiveExpiredInvoices = "archive_expired_invoices"
+	RetentionRuleExpiredInvoices        = "purge_expired_invoices"
+	RetentionRuleArchivedInvoices       = "purge_archived_invoices"
+	RetentionRuleMemos                  = "anonymize_memos"
+)
+
+// RetentionResult reports the invoices a retention rule affected, or would affect in a dry run
+type RetentionResult struct {
+	Rule     string    `json:"rule"`
+	Days     int       `json:"days"`
+	Cutoff   time.Time `json:"cutoff"`
+	Affected int64     `json:"affected"`
+	DryRun   bool      `json:"dry_run"`
+}
+
+// retentionRule selects the invoices older than the configured number of days with the condition and changes them with apply
+// The rule works on the invoices table unless it has a different model
+type retentionRule struct {
+	name      string
+	days      int
+	model     interface{}
+	condition func(cutoff time.Time) (string, []interface{})
+	apply     func(ctx context.Context, condition string, args []interface{}) (sql.Result, error)
+}
+
+// target returns the model of the table the rule works on
+func (rule retentionRule) target() interface{} {
+	if rule.model == nil {
+		return (*models.Invoice)(nil)
+	}
+	return rule.model
+}
+
+// retentionBatchCondition limits the condition of a rule to a batch of ids
+// The condition is kept, so invoices that changed since the batch was selected are left alone
+func retentionBatchCondition(condition string, args []interface{}, ids []int64) (string, []interface{}) {
+	batchArgs := append(append([]interface{}{}, args...), bun.In(ids))
+	return "(" + condition + ") AND id IN (?)", batchArgs
+}
+
+// applyRetentionRule applies the rule to batches of RETENTION_BATCH_SIZE invoices, oldest first, and returns the number
+// of invoices it changed. Every batch is a short statement of its own, so a run over millions of invoices does not
+// hold locks on the invoices table that would stall addinvoice and payments
+func (svc *LndhubService) applyRetentionRule(ctx context.Context, rule retentionRule, condition string, args []interface{}) (int64, error) {
+	var affected int64
+	for {
+		ids := []int64{}
+		query := svc.DB.NewSelect().Model(rule.target()).Column("id").Where(condition, args...).OrderExpr("id ASC")
+		if svc.Config.RetentionBatchSize > 0 {
+			query = query.Limit(svc.Config.RetentionBatchSize)
+		}
+		if err := query.Scan(ctx, &ids); err != nil || len(ids) == 0 {
+			return affected, err
+		}
+		batchCondition, batchArgs := retentionBatchCondition(condition, args, ids)
+		res, err := rule.apply(ctx, batchCondition, batchArgs)
+		if err != nil {
+			return affected, err
+		}
+		changed, _ := res.RowsAffected()
+		affected += changed
+		// a batch without changes would be selected again
+		if changed == 0 || svc.Config.RetentionBatchSize <= 0 || len(ids) < svc.Config.RetentionBatchSize {
+			return affected, nil
+		}
+	}
+}
+
+// expiredInvoicesCondition selects the unpaid incoming invoices that expired before the cutoff
+// Unpaid incoming invoices never have transaction entries, the check only guards the ledger
+func expiredInvoicesCondition(cutoff time.Time) (string, []interface{}) {
+	return "type = ? AND state IN (?) AND expires_at < ? AND NOT EXISTS (SELECT 1 FROM transaction_entries WHERE transaction_entries.invoice_id = invoice.id)",
+		[]interface{}{common.InvoiceTypeIncoming, bun.In([]string{common.InvoiceStateOpen, common.InvoiceStateInitialized, common.InvoiceStateExpired}), cutoff}
+}
+
+func (svc *LndhubService) retentionRules() []retentionRule {
+	return []retentionRule{
+		{
+			name:      RetentionRuleArchiveExpiredInvoices,
+			days:      svc.Config.RetentionArchiveDays,
+			condition: expiredInvoicesCondition,
+			apply: func(ctx context.Context, condition string, args []interface{}) (sql.Result, error) {
+				// archived invoices can not be removed from the open counts later
+				if err := svc.countExpiredInvoices(ctx, 0); err != nil {
+					return nil, err
+				}
+				// the row is copied as is, archived_invoices has all columns of invoices
+				// columns added later are appended after archived_at, so the columns are listed by name
+				columns := svc.invoiceColumns()
+				if isMySQL(svc.DB) {
+					return svc.archiveInvoicesMySQL(ctx, columns, condition, args)
+				}
+				return svc.DB.ExecContext(ctx, "WITH archived AS (DELETE FROM invoices AS invoice WHERE "+condition+" RETURNING invoice.*) "+
+					"INSERT INTO archived_invoices ("+columns+", archived_at) SELECT "+columns+", current_timestamp FROM archived", args...)
+			},
+		},
+		{
+			name:      RetentionRuleExpiredInvoices,
+			days:      svc.Config.RetentionExpiredInvoicesDays,
+			condition: expiredInvoicesCondition,
+			apply: func(ctx context.Context, condition string, args []interface{}) (sql.Result, error) {
+				// purged invoices can not be removed from the open counts later
+				if err := svc.countExpiredInvoices(ctx, 0); err != nil {
+					return nil, err
+				}
+				return svc.DB.NewDelete().Model((*models.Invoice)(nil)).Where(condition, args...).Exec(ctx)
+			},
+		},
+		{
+			// archived invoices are deleted once they are as old as the expired invoices that are purged
+			name:  RetentionRuleArchivedInvoices,
+			days:  svc.Config.RetentionExpiredInvoicesDays,
+			model: (*models.ArchivedInvoice)(nil),
+			condition: func(cutoff time.Time) (string, []interface{}) {
+				return "expires_at < ?", []interface{}{cutoff}
+			},
+			apply: func(ctx context.Context, condition string, args []interface{}) (sql.Result, error) {
+				return svc.DB.NewDelete().Model((*models.ArchivedInvoice)(nil)).Where(condition, args...).Exec(ctx)
+			},
+		},
+		{
+			name: RetentionRuleMemos,
+			days: svc.Config.RetentionMemoDays,
+			condition: func(cutoff time.Time) (string, []interface{}) {
+				return "created_at < ? AND (memo IS NOT NULL OR encrypted_memo IS NOT NULL OR memo_key_hint IS NOT NULL)", []interface{}{cutoff}
+			},
+			apply: func(ctx context.Context, condition string, args []interface{}) (sql.Result, error) {
+				return svc.DB.NewUpdate().Model((*models.Invoice)(nil)).
+					Set("memo = NULL, encrypted_memo = NULL, memo_key_hint = NULL").
+					Where(condition, args...).Exec(ctx)
+			},
+		},
+	}
+}
+
+// ApplyRetentionPolicy runs the enabled retention rules
+// A dry run only counts the invoices the rules would purge or anonymize
+func (svc *LndhubService) ApplyRetentionPolicy(ctx context.Context, dryRun bool) ([]RetentionResult, error) {
+	results := []RetentionResult{}
+	for _, rule := range svc.retentionRules() {
+		if rule.days <= 0 {
+			continue
+		}
+		result := RetentionResult{Rule: rule.name, Days: rule.days, Cutoff: time.Now().AddDate(0, 0, -rule.days), DryRun: dryRun}
+		condition, args := rule.condition(result.Cutoff)
+		if dryRun {
+			count, err := svc.DB.NewSelect().Model(rule.target()).Where(condition, args...).Count(ctx)
+			if err != nil {
+				return results, err
+			}
+			result.Affected = int64(count)
+		} else {
+			affected, err := svc.applyRetentionRule(ctx, rule, condition, args)
+			result.Affected = affected
+			if err != nil {
+				return results, err
+			}
+			if result.Affected > 0 {
+				err = svc.AddAuditLog(ctx, AuditActionApplyRetention, 0, 0, fmt.Sprintf("%s: %d invoices older than %d days", rule.name, result.Affected, rule.days))
+				if err != nil {
+					return results, err
+				}
+			}
+		}
+		svc.Logger.Infof("Retention rule %s days:%v affected:%v dry_run:%v", rule.name, rule.days, result.Affected, dryRun)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// StartRetentionScheduler applies the retention policy on startup and every RETENTION_INTERVAL
+// With RETENTION_DRY_RUN the scheduled runs only report what they would change
+func (svc *LndhubService) StartRetentionScheduler(ctx context.Context) {
+	if svc.Config.RetentionInterval <= 0 || (svc.Config.RetentionArchiveDays <= 0 && svc.Config.RetentionExpiredInvoicesDays <= 0 && svc.Config.RetentionMemoDays <= 0) {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(svc.Config.RetentionInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		if _, err := svc.ApplyRetentionPolicy(ctx, svc.Config.RetentionDryRun); err != nil {
+			svc.Logger.Errorf("Error applying the retention policy: %v", err)
+			sentry.CaptureException(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archiveInvoicesMySQL moves the invoices to archived_invoices in a transaction, MySQL can not delete the rows in a CTE
+func (svc *LndhubService) archiveInvoicesMySQL(ctx context.Context, columns, condition string, args []interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		ids := []int64{}
+		err := tx.NewSelect().Model((*models.Invoice)(nil)).Column("id").Where(condition, args...).For("UPDATE").Scan(ctx, &ids)
+		if err != nil || len(ids) == 0 {
+			res = driver.RowsAffected(0)
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO archived_invoices ("+columns+", archived_at) SELECT "+columns+", current_timestamp FROM invoices WHERE id IN (?)", bun.In(ids))
+		if err != nil {
+			return err
+		}
+		res, err = tx.NewDelete().Model((*models.Invoice)(nil)).Where("id IN (?)", bun.In(ids)).Exec(ctx)
+		return err
+	})
+	return res, err
+}
+
+// invoiceColumns returns the comma separated, quoted columns of the invoices table
+func (svc *LndhubService) invoiceColumns() string {
+	table := svc.DB.Table(reflect.TypeOf((*models.Invoice)(nil)).Elem())
+	columns := make([]string, len(table.Fields))
+	for i, field := range table.Fields {
+		columns[i] = string(field.SQLName)
+	}
+	return strings.Join(columns, ", ")
+}
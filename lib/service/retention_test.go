@@ -0,0 +1,28 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+func TestRetentionBatchCondition(t *testing.T) {
+	cutoff := time.Now()
+	args := []interface{}{cutoff}
+	ids := []int64{3, 5}
+	condition, batchArgs := retentionBatchCondition("expires_at < ?", args, ids)
+	assert.Equal(t, "(expires_at < ?) AND id IN (?)", condition)
+	assert.Len(t, batchArgs, 2)
+	assert.Equal(t, cutoff, batchArgs[0])
+	assert.IsType(t, bun.In(ids), batchArgs[1])
+	// the arguments of the rule are not changed
+	assert.Len(t, args, 1)
+}
+
+func TestRetentionRuleTarget(t *testing.T) {
+	assert.Equal(t, (*models.Invoice)(nil), retentionRule{}.target())
+	assert.Equal(t, (*models.ArchivedInvoice)(nil), retentionRule{model: (*models.ArchivedInvoice)(nil)}.target())
+}
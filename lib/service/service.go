@@ -6,12 +6,14 @@ import (
 	"strconv"
 
 	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/blobstore"
+	"github.com/getAlby/lndhub.go/lib/security"
 	"github.com/getAlby/lndhub.go/lib/tokens"
 	"github.com/getAlby/lndhub.go/lnd"
 	"github.com/labstack/gommon/random"
 	"github.com/uptrace/bun"
 	"github.com/ziflex/lecho/v3"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const alphaNumBytes = random.Alphanumeric
@@ -19,14 +21,25 @@ const alphaNumBytes = random.Alphanumeric
 type LndhubService struct {
 	Config             *Config
 	DB                 *bun.DB
+	ReplicaDB          *bun.DB // read replica of DB, nil if DATABASE_REPLICA_URI is not set
 	LndClient          lnd.LightningClientWrapper
+	SwapClient         lnd.SwapClientWrapper
+	BlobStore          blobstore.Store
 	Logger             *lecho.Logger
+	ErrorLog           *lib.ErrorLog
 	IdentityPubkey     string
 	InvoiceSubscribers map[int64]chan models.Invoice
+	liquidity          liquidityState
+	invoiceQuotas      invoiceQuotaState
+	fiatRates          fiatRatesState
+	revocations        revocationState
 }
 
-func (svc *LndhubService) GenerateToken(ctx context.Context, login, password, inRefreshToken string) (accessToken, refreshToken string, err error) {
+// GenerateToken issues an access and a refresh token for the login and password or for a refresh token
+// A login starts a session of the device, a refresh token can only be used once and is replaced by the new one
+func (svc *LndhubService) GenerateToken(ctx context.Context, login, password, inRefreshToken, device string) (accessToken, refreshToken string, err error) {
 	var user models.User
+	var parsedRefreshToken *tokens.RefreshToken
 
 	switch {
 	case login != "" || password != "":
@@ -34,18 +47,26 @@ func (svc *LndhubService) GenerateToken(ctx context.Context, login, password, in
 			if err := svc.DB.NewSelect().Model(&user).Where("login = ?", login).Scan(ctx); err != nil {
 				return "", "", fmt.Errorf("bad auth")
 			}
-			if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			ok, rehash := security.CheckPassword(user.Password, password)
+			if !ok {
 				return "", "", fmt.Errorf("bad auth")
 			}
+			// passwords imported from LndHub are only hashed with sha256
+			if rehash {
+				user.Password = security.HashPassword(password)
+				if _, err := svc.DB.NewUpdate().Model(&user).Column("password", "updated_at").WherePK().Exec(ctx); err != nil {
+					svc.Logger.Errorf("Could not rehash imported password user_id:%v: %v", user.ID, err)
+				}
+			}
 		}
 	case inRefreshToken != "":
 		{
-			userId, err := tokens.GetUserIdFromToken(svc.Config.JWTSecret, inRefreshToken)
+			parsedRefreshToken, err = tokens.ParseRefreshToken(svc.Config.JWTSecret, inRefreshToken)
 			if err != nil {
 				return "", "", fmt.Errorf("bad auth")
 			}
 
-			if err := svc.DB.NewSelect().Model(&user).Where("id = ?", userId).Scan(ctx); err != nil {
+			if err := svc.DB.NewSelect().Model(&user).Where("id = ?", parsedRefreshToken.UserID).Scan(ctx); err != nil {
 				return "", "", fmt.Errorf("bad auth")
 			}
 		}
@@ -55,12 +76,53 @@ func (svc *LndhubService) GenerateToken(ctx context.Context, login, password, in
 		}
 	}
 
-	accessToken, err = tokens.GenerateAccessToken(svc.Config.JWTSecret, svc.Config.JWTAccessTokenExpiry, &user)
+	if !user.DeletedAt.IsZero() {
+		return "", "", fmt.Errorf("bad auth")
+	}
+	// frozen users can not get new tokens, tokens issued before the freeze can not pay or create invoices
+	if !user.FrozenAt.IsZero() {
+		return "", "", ErrAccountFrozen
+	}
+
+	var session *models.AuthSession
+	if parsedRefreshToken != nil {
+		session, err = svc.rotateSession(ctx, parsedRefreshToken)
+		if err == nil {
+			err = restrictSession(session, parsedRefreshToken)
+		}
+	} else {
+		session = &models.AuthSession{UserID: user.ID, Device: device}
+		err = svc.createSession(ctx, session)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return svc.issueTokens(&user, session)
+}
+
+// issueTokens returns an access token with the scopes and the spend limit of the session and the current refresh token of the session
+func (svc *LndhubService) issueTokens(user *models.User, session *models.AuthSession) (accessToken, refreshToken string, err error) {
+	accessToken, err = tokens.GenerateAccessToken(svc.Config.JWTSecret, svc.Config.JWTAccessTokenExpiry, user, tokens.AccessTokenOptions{
+		SessionID: session.ID,
+		Scopes:    session.Scopes,
+		SpendLimit: &tokens.SpendLimit{
+			Credential:          tokens.SessionCredential(session.ID),
+			MaxAmountPerPayment: session.MaxAmountPerPayment,
+			MaxAmountPerDay:     session.MaxAmountPerDay,
+		},
+	})
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshToken, err = tokens.GenerateRefreshToken(svc.Config.JWTSecret, svc.Config.JWTRefreshTokenExpiry, &user)
+	refreshToken, err = tokens.GenerateRefreshToken(svc.Config.JWTSecret, svc.Config.JWTRefreshTokenExpiry, user, session.TokenID, tokens.AccessTokenOptions{
+		SessionID: session.ID,
+		Scopes:    session.Scopes,
+		SpendLimit: &tokens.SpendLimit{
+			MaxAmountPerPayment: session.MaxAmountPerPayment,
+			MaxAmountPerDay:     session.MaxAmountPerDay,
+		},
+	})
 	if err != nil {
 		return "", "", err
 	}
@@ -70,7 +132,7 @@ func (svc *LndhubService) GenerateToken(ctx context.Context, login, password, in
 func (svc *LndhubService) ParseInt(value interface{}) (int64, error) {
 	switch v := value.(type) {
 	case float64:
-		return int64(v), nil
+		return lib.FloatToAmount(v)
 	case string:
 		c, err := strconv.ParseInt(v, 10, 64)
 		if err != nil {
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"math"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/uptrace/bun"
+)
+
+// calculateServiceFee returns the base fee plus the percentage of the amount, rounded up to the next satoshi
+// A fee that does not fit into an int64 is capped, no balance can pay it
+func calculateServiceFee(amount, base int64, percent float64) int64 {
+	if amount <= 0 || (base <= 0 && percent <= 0) {
+		return 0
+	}
+	fee := base
+	if percent > 0 {
+		percentage, err := lib.FloatToAmount(math.Ceil(float64(amount) * percent / 100))
+		if err != nil {
+			return math.MaxInt64
+		}
+		if fee, err = lib.AddAmounts(fee, percentage); err != nil {
+			return math.MaxInt64
+		}
+	}
+	return fee
+}
+
+// insertServiceFeeEntry books the invoice's service fee from the user's current account to the service fees account
+func (svc *LndhubService) insertServiceFeeEntry(ctx context.Context, db bun.IDB, invoice *models.Invoice, currentAccountID, parentEntryID int64) error {
+	if invoice.ServiceFee <= 0 {
+		return nil
+	}
+	serviceFeeAccount, err := svc.AccountFor(ctx, common.AccountTypeServiceFees, invoice.UserID)
+	if err != nil {
+		svc.Logger.Errorf("Could not find service fees account user_id:%v", invoice.UserID)
+		return err
+	}
+	entry := models.TransactionEntry{
+		UserID:          invoice.UserID,
+		InvoiceID:       invoice.ID,
+		CreditAccountID: serviceFeeAccount.ID,
+		DebitAccountID:  currentAccountID,
+		Amount:          invoice.ServiceFee,
+		ParentID:        parentEntryID,
+	}
+	_, err = db.NewInsert().Model(&entry).Exec(ctx)
+	if err != nil {
+		svc.Logger.Errorf("Could not insert service fee transaction entry user_id:%v invoice_id:%v %v", invoice.UserID, invoice.ID, err)
+	}
+	return err
+}
+
+// revertServiceFeeEntry refunds the service fee of a failed outgoing payment
+func (svc *LndhubService) revertServiceFeeEntry(ctx context.Context, db bun.IDB, invoice *models.Invoice, currentAccountID, parentEntryID int64) error {
+	if invoice.ServiceFee <= 0 {
+		return nil
+	}
+	serviceFeeAccount, err := svc.AccountFor(ctx, common.AccountTypeServiceFees, invoice.UserID)
+	if err != nil {
+		svc.Logger.Errorf("Could not find service fees account user_id:%v", invoice.UserID)
+		return err
+	}
+	entry := models.TransactionEntry{
+		UserID:          invoice.UserID,
+		InvoiceID:       invoice.ID,
+		CreditAccountID: currentAccountID,
+		DebitAccountID:  serviceFeeAccount.ID,
+		Amount:          invoice.ServiceFee,
+		ParentID:        parentEntryID,
+	}
+	_, err = db.NewInsert().Model(&entry).Exec(ctx)
+	if err != nil {
+		svc.Logger.Errorf("Could not revert service fee transaction entry user_id:%v invoice_id:%v %v", invoice.UserID, invoice.ID, err)
+	}
+	return err
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateServiceFee(t *testing.T) {
+	cases := []struct {
+		amount  int64
+		base    int64
+		percent float64
+		fee     int64
+	}{
+		{1000, 0, 0, 0},
+		{1000, 1, 0, 1},
+		{1000, 0, 0.5, 5},
+		{1001, 0, 0.5, 6},
+		{1000, 2, 1, 12},
+		{0, 2, 1, 0},
+		{math.MaxInt64, 0, 200, math.MaxInt64},
+		{math.MaxInt64, math.MaxInt64, 1, math.MaxInt64},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.fee, calculateServiceFee(c.amount, c.base, c.percent), "amount %v base %v percent %v", c.amount, c.base, c.percent)
+	}
+}
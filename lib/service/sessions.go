@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/uptrace/bun"
+)
+
+// device names are cut to the length of the column
+const maxSessionDeviceLength = 255
+
+const (
+	// access tokens are checked against the database at most this often, revocations on other instances take up to this long
+	revocationCacheTTL = 10 * time.Second
+	// expired checks are dropped once this many tokens are cached
+	revocationCachePruneThreshold = 10000
+)
+
+var ErrRefreshTokenReused = errors.New("refresh token was already used")
+var ErrSessionNotActive = errors.New("session is revoked or expired")
+
+func sessionDevice(device string) string {
+	if runes := []rune(device); len(runes) > maxSessionDeviceLength {
+		return string(runes[:maxSessionDeviceLength])
+	}
+	return device
+}
+
+// createSession starts the session of a device, the session holds the id of its first refresh token
+// The tokens of a session with scopes can only use the routes of the scopes, their payments are limited by its spend limit
+func (svc *LndhubService) createSession(ctx context.Context, session *models.AuthSession) error {
+	tokenID, err := tokens.NewTokenID()
+	if err != nil {
+		return err
+	}
+	session.Device = sessionDevice(session.Device)
+	session.TokenID = tokenID
+	session.ExpiresAt = time.Now().Add(time.Duration(svc.Config.JWTRefreshTokenExpiry) * time.Second)
+	_, err = svc.DB.NewInsert().Model(session).Exec(ctx)
+	return err
+}
+
+// rotateSession replaces the refresh token of the session, the used token can not be used again
+// A token that was already replaced means it was copied, the session is revoked so neither copy can be refreshed
+func (svc *LndhubService) rotateSession(ctx context.Context, refreshToken *tokens.RefreshToken) (*models.AuthSession, error) {
+	tokenID, err := tokens.NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &models.AuthSession{
+		ID:          refreshToken.SessionID,
+		UserID:      refreshToken.UserID,
+		TokenID:     tokenID,
+		RefreshedAt: bun.NullTime{Time: now},
+		ExpiresAt:   now.Add(time.Duration(svc.Config.JWTRefreshTokenExpiry) * time.Second),
+	}
+	res, err := svc.DB.NewUpdate().Model(session).
+		Column("token_id", "refreshed_at", "expires_at").
+		Where("id = ? AND user_id = ? AND token_id = ?", refreshToken.SessionID, refreshToken.UserID, refreshToken.TokenID).
+		Where("revoked_at IS NULL AND expires_at > ?", now).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 1 {
+		// the scopes of the session are kept for its new tokens
+		err = svc.DB.NewSelect().Model(session).WherePK().Scan(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
+
+	reused, err := svc.DB.NewUpdate().Model((*models.AuthSession)(nil)).
+		Set("revoked_at = ?", now).
+		Where("id = ? AND user_id = ? AND token_id <> ?", refreshToken.SessionID, refreshToken.UserID, refreshToken.TokenID).
+		Where("revoked_at IS NULL AND expires_at > ?", now).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := reused.RowsAffected(); rows == 1 {
+		svc.Logger.Errorf("Refresh token used twice, session revoked user_id:%v session_id:%v", refreshToken.UserID, refreshToken.SessionID)
+		return nil, ErrRefreshTokenReused
+	}
+	return nil, ErrSessionNotActive
+}
+
+// SessionsFor lists the sessions of the user that can still be refreshed
+func (svc *LndhubService) SessionsFor(ctx context.Context, userId int64) ([]models.AuthSession, error) {
+	sessions := []models.AuthSession{}
+	err := svc.DB.NewSelect().Model(&sessions).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userId, time.Now()).
+		OrderExpr("id ASC").Scan(ctx)
+	return sessions, err
+}
+
+// RevokeSession signs the device of the session out, its refresh token can not be used anymore
+func (svc *LndhubService) RevokeSession(ctx context.Context, userId, sessionId int64) error {
+	_, err := svc.DB.NewUpdate().Model((*models.AuthSession)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionId, userId).
+		Exec(ctx)
+	return err
+}
+
+// CreateReadOnlyToken starts a session of the device with read-only tokens, for dashboards and accounting tools
+// Its tokens can read the balance and the transactions but not pay or create invoices, refreshing them keeps them read-only
+func (svc *LndhubService) CreateReadOnlyToken(ctx context.Context, userId int64, device string) (accessToken, refreshToken string, err error) {
+	return svc.createTokens(ctx, userId, &models.AuthSession{Device: device, Scopes: tokens.ReadOnlyScopes})
+}
+
+// CreateSpendLimitedToken starts a session of the device with tokens for automations, at least one limit is required
+// Its tokens can use the routes of all api key scopes, payments made with them are limited by the spend limit. Other
+// routes, e.g. creating tokens or api keys, are rejected so the limit can not be lifted with the token
+func (svc *LndhubService) CreateSpendLimitedToken(ctx context.Context, userId int64, device string, maxAmountPerPayment, maxAmountPerDay int64) (accessToken, refreshToken string, err error) {
+	if err := validateSpendLimit(maxAmountPerPayment, maxAmountPerDay); err != nil {
+		return "", "", err
+	}
+	if maxAmountPerPayment == 0 && maxAmountPerDay == 0 {
+		return "", "", ErrInvalidSpendLimit
+	}
+	return svc.createTokens(ctx, userId, &models.AuthSession{
+		Device:              device,
+		Scopes:              tokens.Scopes,
+		MaxAmountPerPayment: maxAmountPerPayment,
+		MaxAmountPerDay:     maxAmountPerDay,
+	})
+}
+
+// createTokens starts the session for a logged in user and returns its first tokens
+func (svc *LndhubService) createTokens(ctx context.Context, userId int64, session *models.AuthSession) (accessToken, refreshToken string, err error) {
+	user, err := svc.FindUser(ctx, userId)
+	if err != nil {
+		return "", "", err
+	}
+	if !user.FrozenAt.IsZero() {
+		return "", "", ErrAccountFrozen
+	}
+	session.UserID = user.ID
+	if err := svc.createSession(ctx, session); err != nil {
+		return "", "", err
+	}
+	return svc.issueTokens(user, session)
+}
+
+// restrictSession keeps the scopes and the spend limit the refresh token was issued with
+// when they are stricter than the ones stored for its session
+func restrictSession(session *models.AuthSession, refreshToken *tokens.RefreshToken) error {
+	if len(refreshToken.Scopes) > 0 {
+		if len(session.Scopes) == 0 {
+			session.Scopes = refreshToken.Scopes
+		} else {
+			scopes := []string{}
+			for _, scope := range session.Scopes {
+				if tokens.HasScope(refreshToken.Scopes, scope) {
+					scopes = append(scopes, scope)
+				}
+			}
+			// tokens without scopes can use every route
+			if len(scopes) == 0 {
+				return fmt.Errorf("bad auth")
+			}
+			session.Scopes = scopes
+		}
+	}
+	if refreshToken.SpendLimit != nil {
+		session.MaxAmountPerPayment = stricterLimit(session.MaxAmountPerPayment, refreshToken.SpendLimit.MaxAmountPerPayment)
+		session.MaxAmountPerDay = stricterLimit(session.MaxAmountPerDay, refreshToken.SpendLimit.MaxAmountPerDay)
+	}
+	return nil
+}
+
+// stricterLimit returns the lower of two limits, 0 is no limit
+func stricterLimit(a, b int64) int64 {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// Logout revokes the access token and signs the device of its session out
+// Access tokens issued before tokens had an id can not be revoked, they stay valid until they expire
+func (svc *LndhubService) Logout(ctx context.Context, accessToken *tokens.AccessToken) error {
+	if accessToken.TokenID != "" {
+		// revoked tokens that expired are rejected by their expiry, they are cleaned up with every logout
+		_, err := svc.DB.NewDelete().Model((*models.RevokedToken)(nil)).Where("expires_at < ?", time.Now()).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		revokedToken := &models.RevokedToken{TokenID: accessToken.TokenID, ExpiresAt: accessToken.ExpiresAt}
+		if _, err := svc.DB.NewInsert().Model(revokedToken).Ignore().Exec(ctx); err != nil {
+			return err
+		}
+	}
+	svc.revocations.revoke(accessToken)
+	if accessToken.SessionID == 0 {
+		return nil
+	}
+	return svc.RevokeSession(ctx, accessToken.UserID, accessToken.SessionID)
+}
+
+// TokenMiddlewareOptions authenticates requests with the tokens and api keys of the hub, limited to the scopes of the routes
+func (svc *LndhubService) TokenMiddlewareOptions(routeScopes tokens.RouteScopes) tokens.MiddlewareOptions {
+	return tokens.MiddlewareOptions{
+		Secret:        svc.Config.JWTSecret,
+		APIKeys:       svc.AuthenticateAPIKey,
+		RouteScopes:   routeScopes,
+		RevokedTokens: svc.IsAccessTokenRevoked,
+	}
+}
+
+type revocationCheck struct {
+	userId    int64
+	revoked   bool
+	checkedAt time.Time
+}
+
+// revocationState caches whether access tokens were revoked, so not every request queries the database
+type revocationState struct {
+	mu     sync.Mutex
+	checks map[string]revocationCheck
+}
+
+func revocationKey(token *tokens.AccessToken) string {
+	if token.TokenID == "" {
+		return fmt.Sprintf("user:%d", token.UserID)
+	}
+	return token.TokenID
+}
+
+func (state *revocationState) get(token *tokens.AccessToken, now time.Time) (revoked, ok bool) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	check, ok := state.checks[revocationKey(token)]
+	if !ok || (!check.revoked && now.Sub(check.checkedAt) > revocationCacheTTL) {
+		return false, false
+	}
+	return check.revoked, true
+}
+
+func (state *revocationState) set(token *tokens.AccessToken, revoked bool, now time.Time) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.checks == nil {
+		state.checks = map[string]revocationCheck{}
+	}
+	if len(state.checks) >= revocationCachePruneThreshold {
+		for key, check := range state.checks {
+			if now.Sub(check.checkedAt) > revocationCacheTTL {
+				delete(state.checks, key)
+			}
+		}
+	}
+	state.checks[revocationKey(token)] = revocationCheck{userId: token.UserID, revoked: revoked, checkedAt: now}
+}
+
+// revoke rejects the token on this instance right away
+func (state *revocationState) revoke(token *tokens.AccessToken) {
+	state.set(token, true, time.Now())
+}
+
+// forgetUser checks the tokens of the user against the database again with their next request
+func (state *revocationState) forgetUser(userId int64) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	for key, check := range state.checks {
+		if check.userId == userId {
+			delete(state.checks, key)
+		}
+	}
+}
+
+// IsAccessTokenRevoked returns whether the access token was revoked by a logout, the revocation of its session or the deletion of its user
+// Tokens issued before tokens had an id can only be revoked by deleting the user
+// Valid tokens are checked against the database again after revocationCacheTTL, revoked tokens stay rejected
+func (svc *LndhubService) IsAccessTokenRevoked(ctx context.Context, token *tokens.AccessToken) (bool, error) {
+	now := time.Now()
+	if revoked, ok := svc.revocations.get(token, now); ok {
+		return revoked, nil
+	}
+	revoked, err := svc.isAccessTokenRevoked(ctx, token)
+	if err != nil {
+		return false, err
+	}
+	svc.revocations.set(token, revoked, now)
+	return revoked, nil
+}
+
+func (svc *LndhubService) isAccessTokenRevoked(ctx context.Context, token *tokens.AccessToken) (bool, error) {
+	if token.TokenID != "" {
+		revoked, err := svc.DB.NewSelect().Model((*models.RevokedToken)(nil)).Where("token_id = ?", token.TokenID).Exists(ctx)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+	// a logout or a revoked session signs out every token of the session, not only the one that was revoked
+	if token.SessionID != 0 {
+		revoked, err := svc.DB.NewSelect().Model((*models.AuthSession)(nil)).Where("id = ? AND revoked_at IS NOT NULL", token.SessionID).Exists(ctx)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+	return svc.DB.NewSelect().Model((*models.User)(nil)).Where("id = ? AND deleted_at IS NOT NULL", token.UserID).Exists(ctx)
+}
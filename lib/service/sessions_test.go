@@ -0,0 +1,61 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevocationCache(t *testing.T) {
+	state := revocationState{}
+	now := time.Now()
+	token := &tokens.AccessToken{UserID: 3, TokenID: "a"}
+	_, ok := state.get(token, now)
+	assert.False(t, ok)
+
+	state.set(token, false, now)
+	revoked, ok := state.get(token, now.Add(revocationCacheTTL/2))
+	assert.True(t, ok)
+	assert.False(t, revoked)
+	// valid tokens are checked again after the ttl
+	_, ok = state.get(token, now.Add(2*revocationCacheTTL))
+	assert.False(t, ok)
+
+	// a logout on this instance takes effect right away and stays
+	state.revoke(token)
+	revoked, ok = state.get(token, now.Add(2*revocationCacheTTL))
+	assert.True(t, ok)
+	assert.True(t, revoked)
+
+	// tokens without an id are cached per user
+	legacy := &tokens.AccessToken{UserID: 3}
+	state.set(legacy, false, now)
+	state.forgetUser(3)
+	_, ok = state.get(legacy, now)
+	assert.False(t, ok)
+	_, ok = state.get(token, now)
+	assert.False(t, ok)
+}
+
+func TestRestrictSession(t *testing.T) {
+	// the limits of the refresh token are applied when the session has none
+	session := &models.AuthSession{}
+	assert.NoError(t, restrictSession(session, &tokens.RefreshToken{Scopes: tokens.Scopes, SpendLimit: &tokens.SpendLimit{MaxAmountPerPayment: 1000, MaxAmountPerDay: 5000}}))
+	assert.Equal(t, tokens.Scopes, session.Scopes)
+	assert.Equal(t, int64(1000), session.MaxAmountPerPayment)
+	assert.Equal(t, int64(5000), session.MaxAmountPerDay)
+
+	// the stricter limit and the common scopes are kept
+	session = &models.AuthSession{Scopes: tokens.Scopes, MaxAmountPerPayment: 500, MaxAmountPerDay: 10000}
+	assert.NoError(t, restrictSession(session, &tokens.RefreshToken{Scopes: tokens.ReadOnlyScopes, SpendLimit: &tokens.SpendLimit{MaxAmountPerPayment: 1000, MaxAmountPerDay: 5000}}))
+	assert.Equal(t, tokens.ReadOnlyScopes, session.Scopes)
+	assert.Equal(t, int64(500), session.MaxAmountPerPayment)
+	assert.Equal(t, int64(5000), session.MaxAmountPerDay)
+
+	// scopes without a common scope would give the tokens every route
+	session = &models.AuthSession{Scopes: []string{tokens.ScopePaymentSend}}
+	assert.Error(t, restrictSession(session, &tokens.RefreshToken{Scopes: tokens.ReadOnlyScopes}))
+}
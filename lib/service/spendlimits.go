@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/uptrace/bun"
+)
+
+var ErrSpendLimitExceeded = errors.New("the payment exceeds the spend limit of the api key or token")
+var ErrInvalidSpendLimit = errors.New("invalid spend limit, limits must not be negative")
+
+func validateSpendLimit(maxAmountPerPayment, maxAmountPerDay int64) error {
+	if maxAmountPerPayment < 0 || maxAmountPerDay < 0 {
+		return ErrInvalidSpendLimit
+	}
+	return nil
+}
+
+// checkPaymentSpendLimit rejects payments above the per-payment limit of the credentials the payment is made with
+func checkPaymentSpendLimit(spendLimit *tokens.SpendLimit, amount int64) error {
+	if spendLimit != nil && spendLimit.MaxAmountPerPayment > 0 && amount > spendLimit.MaxAmountPerPayment {
+		return ErrSpendLimitExceeded
+	}
+	return nil
+}
+
+// recordSpend checks the daily limit of the credentials and counts the payment against it
+// It runs in the transaction that books the payment after the user's current account is locked, so concurrent
+// payments with the same credentials can not both pass the limit. Amounts are counted without fees and failed
+// payments are not counted
+func (svc *LndhubService) recordSpend(ctx context.Context, tx bun.Tx, spendLimit *tokens.SpendLimit, invoice *models.Invoice) error {
+	if spendLimit == nil {
+		return nil
+	}
+	if spendLimit.MaxAmountPerDay > 0 {
+		var spent int64
+		err := tx.NewSelect().Model((*models.CredentialPayment)(nil)).
+			ColumnExpr("COALESCE(SUM(amount), 0)").
+			Where("credential = ? AND created_at > ? AND invoice_id <> ?", spendLimit.Credential, time.Now().Add(-24*time.Hour), invoice.ID).
+			Where("invoice_id NOT IN (SELECT id FROM invoices WHERE user_id = ? AND state = ?)", invoice.UserID, common.InvoiceStateError).
+			Scan(ctx, &spent)
+		if err != nil {
+			return err
+		}
+		total, err := lib.AddAmounts(spent, invoice.Amount)
+		if err != nil {
+			return err
+		}
+		if total > spendLimit.MaxAmountPerDay {
+			return ErrSpendLimitExceeded
+		}
+	}
+	_, err := tx.NewInsert().Model(&models.CredentialPayment{
+		UserID:     invoice.UserID,
+		Credential: spendLimit.Credential,
+		InvoiceID:  invoice.ID,
+		Amount:     invoice.Amount,
+	}).Ignore().Exec(ctx)
+	return err
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPaymentSpendLimit(t *testing.T) {
+	assert.NoError(t, checkPaymentSpendLimit(nil, 1000000))
+	assert.NoError(t, checkPaymentSpendLimit(&tokens.SpendLimit{MaxAmountPerDay: 100}, 1000))
+	assert.NoError(t, checkPaymentSpendLimit(&tokens.SpendLimit{MaxAmountPerPayment: 1000}, 1000))
+	assert.ErrorIs(t, checkPaymentSpendLimit(&tokens.SpendLimit{MaxAmountPerPayment: 1000}, 1001), ErrSpendLimitExceeded)
+}
+
+func TestCreateSpendLimitedTokenNeedsALimit(t *testing.T) {
+	svc := &LndhubService{Config: &Config{}}
+	_, _, err := svc.CreateSpendLimitedToken(context.Background(), 1, "shop", 0, 0)
+	assert.ErrorIs(t, err, ErrInvalidSpendLimit)
+	_, _, err = svc.CreateSpendLimitedToken(context.Background(), 1, "shop", -1, 1000)
+	assert.ErrorIs(t, err, ErrInvalidSpendLimit)
+	_, _, err = svc.CreateAPIKey(context.Background(), 1, "shop", []string{tokens.ScopePaymentSend}, 0, -1)
+	assert.ErrorIs(t, err, ErrInvalidSpendLimit)
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+const redacted = "[redacted]"
+
+// config options whose values are never included in a support bundle, only whether they are set
+var secretConfigPattern = regexp.MustCompile(`SECRET|TOKEN|MACAROON|CERT|PRIVATE_KEY|ACCESS_KEY|DSN|DATABASE_URI|PASSWORD|COMMAND`)
+
+// modules whose versions are included in a support bundle
+var supportBundleModules = []string{
+	"github.com/lightningnetwork/lnd",
+	"github.com/uptrace/bun",
+	"github.com/labstack/echo/v4",
+}
+
+// SupportBundle is a diagnostic snapshot for bug reports, free of secrets
+type SupportBundle struct {
+	GeneratedAt  time.Time              `json:"generated_at"`
+	Versions     SupportBundleVersions  `json:"versions"`
+	Config       map[string]string      `json:"config"`
+	Lnd          SupportBundleLnd       `json:"lnd"`
+	Pending      SupportBundlePending   `json:"pending"`
+	Breakers     []SupportBundleBreaker `json:"breakers"`
+	RecentErrors []string               `json:"recent_errors"`
+}
+
+type SupportBundleVersions struct {
+	Go      string            `json:"go"`
+	Lndhub  string            `json:"lndhub"`
+	Modules map[string]string `json:"modules"`
+}
+
+// SupportBundleLnd is the node's connectivity status, Error is set if the node could not be queried
+type SupportBundleLnd struct {
+	Reachable      bool   `json:"reachable"`
+	Error          string `json:"error,omitempty"`
+	Version        string `json:"version,omitempty"`
+	SyncedToChain  bool   `json:"synced_to_chain"`
+	SyncedToGraph  bool   `json:"synced_to_graph"`
+	BlockHeight    uint32 `json:"block_height"`
+	ActiveChannels uint32 `json:"active_channels"`
+	LoopConfigured bool   `json:"loop_configured"`
+}
+
+type SupportBundlePending struct {
+	OpenInvoices    int               `json:"open_invoices"`
+	PendingPayments int               `json:"pending_payments"`
+	InFlight        *InFlightExposure `json:"in_flight"`
+	PendingWebhooks int               `json:"pending_webhooks"`
+	DeadWebhooks    int               `json:"dead_webhooks"`
+	PendingJobs     int               `json:"pending_jobs"`
+	PendingSwaps    int               `json:"pending_swaps"`
+}
+
+// SupportBundleBreaker is a safety switch that rejects requests while it is open
+type SupportBundleBreaker struct {
+	Name   string `json:"name"`
+	Open   bool   `json:"open"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// redactedConfig lists the configuration by environment variable
+// Secrets are replaced and credentials and query parameters are removed from URLs
+func redactedConfig(c *Config) (map[string]string, []string) {
+	config := map[string]string{}
+	secrets := []string{}
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Tag.Get("envconfig")
+		if name == "" {
+			continue
+		}
+		field := value.Field(i)
+		var str string
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8 {
+			str = string(field.Bytes())
+		} else {
+			str = fmt.Sprint(field.Interface())
+		}
+		switch {
+		case secretConfigPattern.MatchString(name):
+			if field.IsZero() {
+				config[name] = ""
+				continue
+			}
+			secrets = append(secrets, str)
+			config[name] = redacted
+		case strings.HasSuffix(name, "_URL") || strings.HasSuffix(name, "_ENDPOINT"):
+			config[name] = redactURL(str)
+		default:
+			config[name] = str
+		}
+	}
+	return config, secrets
+}
+
+func redactURL(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redacted
+	}
+	return u.String()
+}
+
+// redactSecrets replaces the secret values in the line
+func redactSecrets(line string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			line = strings.ReplaceAll(line, secret, redacted)
+		}
+	}
+	return line
+}
+
+func supportBundleVersions() SupportBundleVersions {
+	versions := SupportBundleVersions{Go: runtime.Version(), Modules: map[string]string{}}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return versions
+	}
+	versions.Lndhub = info.Main.Version
+	for _, dep := range info.Deps {
+		for _, module := range supportBundleModules {
+			if dep.Path == module {
+				versions.Modules[module] = dep.Version
+			}
+		}
+	}
+	return versions
+}
+
+func (svc *LndhubService) supportBundleLnd(ctx context.Context) SupportBundleLnd {
+	status := SupportBundleLnd{LoopConfigured: svc.SwapClient != nil}
+	info, err := svc.LndClient.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Reachable = true
+	status.Version = info.Version
+	status.SyncedToChain = info.SyncedToChain
+	status.SyncedToGraph = info.SyncedToGraph
+	status.BlockHeight = info.BlockHeight
+	status.ActiveChannels = info.NumActiveChannels
+	return status
+}
+
+func (svc *LndhubService) supportBundlePending(ctx context.Context) (SupportBundlePending, error) {
+	pending := SupportBundlePending{}
+	counts := []struct {
+		count *int
+		model interface{}
+		where string
+		args  []interface{}
+	}{
+		{&pending.OpenInvoices, (*models.Invoice)(nil), "type = ? AND state = ? AND expires_at > ?", []interface{}{common.InvoiceTypeIncoming, common.InvoiceStateOpen, time.Now()}},
+		{&pending.PendingPayments, (*models.Invoice)(nil), "type = ? AND state = ?", []interface{}{common.InvoiceTypeOutgoing, common.InvoiceStateInitialized}},
+		{&pending.PendingWebhooks, (*models.WebhookDelivery)(nil), "state = ?", []interface{}{common.WebhookDeliveryStatePending}},
+		{&pending.DeadWebhooks, (*models.WebhookDelivery)(nil), "state = ?", []interface{}{common.WebhookDeliveryStateDead}},
+		{&pending.PendingJobs, (*models.Job)(nil), "state IN (?, ?)", []interface{}{common.JobStatePending, common.JobStateRunning}},
+		{&pending.PendingSwaps, (*models.Swap)(nil), "state = ?", []interface{}{common.SwapStatePending}},
+	}
+	for _, c := range counts {
+		count, err := svc.DB.NewSelect().Model(c.model).Where(c.where, c.args...).Count(ctx)
+		if err != nil {
+			return pending, err
+		}
+		*c.count = count
+	}
+	exposure, err := svc.InFlightExposure(ctx)
+	if err != nil {
+		return pending, err
+	}
+	pending.InFlight = exposure
+	return pending, nil
+}
+
+func (svc *LndhubService) supportBundleBreakers(pending SupportBundlePending) []SupportBundleBreaker {
+	outgoing := SupportBundleBreaker{Name: "outgoing_payments"}
+	if err := svc.CheckOutboundLiquidity(); err != nil {
+		outgoing.Open, outgoing.Reason = true, err.Error()
+	}
+	receiving := SupportBundleBreaker{Name: "receiving"}
+	if svc.ReceivingPaused() {
+		receiving.Open, receiving.Reason = true, ErrReceivingPaused.Error()
+	}
+	inFlight := SupportBundleBreaker{Name: "in_flight_exposure"}
+	if max := svc.Config.MaxInFlightExposure; max > 0 && pending.InFlight.Amount > max {
+		inFlight.Open, inFlight.Reason = true, ErrInFlightExposureHigh.Error()
+	}
+	return []SupportBundleBreaker{outgoing, receiving, inFlight}
+}
+
+// SupportBundle collects the configuration, versions, node status, pending work, breaker states and recent errors
+// Secrets are redacted from the configuration and the error log lines
+func (svc *LndhubService) SupportBundle(ctx context.Context) (*SupportBundle, error) {
+	config, secrets := redactedConfig(svc.Config)
+	pending, err := svc.supportBundlePending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bundle := SupportBundle{
+		GeneratedAt:  time.Now(),
+		Versions:     supportBundleVersions(),
+		Config:       config,
+		Lnd:          svc.supportBundleLnd(ctx),
+		Pending:      pending,
+		Breakers:     svc.supportBundleBreakers(pending),
+		RecentErrors: []string{},
+	}
+	if svc.ErrorLog != nil {
+		for _, line := range svc.ErrorLog.Lines() {
+			bundle.RecentErrors = append(bundle.RecentErrors, redactSecrets(line, secrets))
+		}
+	}
+	return &bundle, nil
+}
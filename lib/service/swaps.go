@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/getsentry/sentry-go"
+	"github.com/uptrace/bun"
+)
+
+const swapListLimit = 100
+
+var ErrSwapsDisabled = errors.New("swaps are not configured")
+var ErrSwapTooExpensive = errors.New("quoted swap costs exceed LOOP_MAX_COST_PERCENT")
+
+// SwapQuote returns the maximum costs of a loop out or loop in of the amount
+func (svc *LndhubService) SwapQuote(ctx context.Context, swapType string, amount int64) (*lnd.SwapQuote, error) {
+	if svc.SwapClient == nil {
+		return nil, ErrSwapsDisabled
+	}
+	switch swapType {
+	case common.SwapTypeLoopOut:
+		return svc.SwapClient.LoopOutQuote(ctx, amount)
+	case common.SwapTypeLoopIn:
+		return svc.SwapClient.LoopInQuote(ctx, amount)
+	}
+	return nil, fmt.Errorf("unknown swap type: %s", swapType)
+}
+
+// StartSwap starts a loop out (more inbound liquidity) or a loop in (more outbound liquidity)
+// The quoted costs are the limits of the swap and must not exceed LOOP_MAX_COST_PERCENT of the amount
+func (svc *LndhubService) StartSwap(ctx context.Context, swapType string, amount int64, initiator string) (*models.Swap, error) {
+	quote, err := svc.SwapQuote(ctx, swapType, amount)
+	if err != nil {
+		return nil, err
+	}
+	if float64(quote.Total()) > float64(amount)*svc.Config.LoopMaxCostPercent/100 {
+		svc.Logger.Errorf("Swap quote too expensive type:%s amount:%v cost:%v", swapType, amount, quote.Total())
+		return nil, ErrSwapTooExpensive
+	}
+
+	var status *lnd.SwapStatus
+	if swapType == common.SwapTypeLoopOut {
+		status, err = svc.SwapClient.LoopOut(ctx, amount, quote)
+	} else {
+		status, err = svc.SwapClient.LoopIn(ctx, amount, quote)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	swap := models.Swap{
+		SwapID:      status.ID,
+		Type:        swapType,
+		Amount:      amount,
+		State:       common.SwapStatePending,
+		Initiator:   initiator,
+		HtlcAddress: status.HtlcAddress,
+	}
+	if _, err := svc.DB.NewInsert().Model(&swap).Exec(ctx); err != nil {
+		return nil, err
+	}
+	svc.Logger.Infof("Started swap swap_id:%s type:%s amount:%v initiator:%s", swap.SwapID, swapType, amount, initiator)
+	return &swap, nil
+}
+
+// Swaps returns the latest swaps
+func (svc *LndhubService) Swaps(ctx context.Context) ([]models.Swap, error) {
+	swaps := []models.Swap{}
+	err := svc.DB.NewSelect().Model(&swaps).OrderExpr("id DESC").Limit(swapListLimit).Scan(ctx)
+	return swaps, err
+}
+
+// UpdatePendingSwaps fetches the state of the pending swaps and books the costs of completed swaps
+func (svc *LndhubService) UpdatePendingSwaps(ctx context.Context) error {
+	swaps := []models.Swap{}
+	err := svc.DB.NewSelect().Model(&swaps).Where("state = ?", common.SwapStatePending).OrderExpr("id ASC").Scan(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range swaps {
+		swap := &swaps[i]
+		status, err := svc.SwapClient.SwapInfo(ctx, swap.SwapID)
+		if err != nil {
+			svc.Logger.Errorf("Error fetching swap swap_id:%s %v", swap.SwapID, err)
+			continue
+		}
+		swap.CostServer = status.CostServer
+		swap.CostOnchain = status.CostOnchain
+		swap.CostOffchain = status.CostOffchain
+		switch status.State {
+		case lnd.SwapStateSuccess:
+			swap.State = common.SwapStateSucceeded
+		case lnd.SwapStateFailed:
+			swap.State = common.SwapStateFailed
+			swap.FailureReason = status.FailureReason
+		}
+		if swap.State == common.SwapStatePending {
+			_, err = svc.DB.NewUpdate().Model(swap).Column("cost_server", "cost_onchain", "cost_offchain", "updated_at").WherePK().Exec(ctx)
+		} else {
+			swap.CompletedAt = bun.NullTime{Time: time.Now()}
+			err = svc.completeSwap(ctx, swap)
+		}
+		if err != nil {
+			svc.Logger.Errorf("Error updating swap swap_id:%s %v", swap.SwapID, err)
+			sentry.CaptureException(err)
+		}
+	}
+	return nil
+}
+
+// completeSwap stores the final state of the swap and books its costs against the operator's swap costs account
+// Costs are booked for failed swaps as well, e.g. the on-chain fees of a refunded loop in
+func (svc *LndhubService) completeSwap(ctx context.Context, swap *models.Swap) error {
+	svc.Logger.Infof("Swap completed swap_id:%s state:%s cost:%v", swap.SwapID, swap.State, swap.TotalCost())
+	if swap.TotalCost() <= 0 {
+		_, err := svc.DB.NewUpdate().Model(swap).WherePK().Exec(ctx)
+		return err
+	}
+	incomingAccount, swapCostsAccount, err := svc.operatorSwapAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		invoice := models.Invoice{
+			Type:                 common.InvoiceTypeSwapCost,
+			UserID:               swapCostsAccount.UserID,
+			Amount:               swap.TotalCost(),
+			Memo:                 fmt.Sprintf("%s swap %s", swap.Type, swap.SwapID),
+			DestinationPubkeyHex: svc.IdentityPubkey,
+			RHash:                swap.SwapID,
+			State:                common.InvoiceStateSettled,
+			SettledAt:            bun.NullTime{Time: time.Now()},
+		}
+		if _, err := tx.NewInsert().Model(&invoice).Exec(ctx); err != nil {
+			return err
+		}
+		entry := models.TransactionEntry{
+			UserID:          swapCostsAccount.UserID,
+			InvoiceID:       invoice.ID,
+			DebitAccountID:  incomingAccount.ID,
+			CreditAccountID: swapCostsAccount.ID,
+			Amount:          invoice.Amount,
+		}
+		if _, err := tx.NewInsert().Model(&entry).Exec(ctx); err != nil {
+			return err
+		}
+		swap.InvoiceID = invoice.ID
+		_, err := tx.NewUpdate().Model(swap).WherePK().Exec(ctx)
+		return err
+	})
+}
+
+// operatorSwapAccounts returns the incoming and swap costs accounts of the OPERATOR_LOGIN user
+// The user and the swap costs account are created on first use
+func (svc *LndhubService) operatorSwapAccounts(ctx context.Context) (*models.Account, *models.Account, error) {
+	operator, err := svc.FindUserByLogin(ctx, svc.Config.OperatorLogin)
+	if errors.Is(err, sql.ErrNoRows) {
+		operator, err = svc.CreateUser(ctx, svc.Config.OperatorLogin, "")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	incomingAccount, err := svc.AccountFor(ctx, common.AccountTypeIncoming, operator.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	swapCostsAccount, err := svc.AccountFor(ctx, common.AccountTypeSwapCosts, operator.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		swapCostsAccount = models.Account{UserID: operator.ID, Type: common.AccountTypeSwapCosts}
+		_, err = svc.DB.NewInsert().Model(&swapCostsAccount).Exec(ctx)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &incomingAccount, &swapCostsAccount, nil
+}
+
+// AutoSwap starts a swap of LOOP_AUTO_AMOUNT if the node's liquidity is out of balance
+// Outbound liquidity below MIN_OUTBOUND_LIQUIDITY starts a loop in, inbound liquidity below LOOP_MIN_INBOUND_LIQUIDITY a loop out
+// Only one swap is pending at a time
+func (svc *LndhubService) AutoSwap(ctx context.Context) error {
+	if svc.Config.LoopAutoAmount <= 0 {
+		return nil
+	}
+	pending, err := svc.DB.NewSelect().Model((*models.Swap)(nil)).Where("state = ?", common.SwapStatePending).Count(ctx)
+	if err != nil || pending > 0 {
+		return err
+	}
+	snapshot, err := svc.CheckLiquidity(ctx)
+	if err != nil {
+		return err
+	}
+	swapType := ""
+	switch {
+	case svc.Config.MinOutboundLiquidity > 0 && snapshot.Outbound < svc.Config.MinOutboundLiquidity:
+		swapType = common.SwapTypeLoopIn
+	case svc.Config.LoopMinInboundLiquidity > 0 && snapshot.Inbound < svc.Config.LoopMinInboundLiquidity:
+		swapType = common.SwapTypeLoopOut
+	default:
+		return nil
+	}
+	_, err = svc.StartSwap(ctx, swapType, svc.Config.LoopAutoAmount, common.SwapInitiatorAuto)
+	return err
+}
+
+// StartSwapMonitor tracks pending swaps and starts automatic swaps until the context is canceled
+func (svc *LndhubService) StartSwapMonitor(ctx context.Context) {
+	if svc.SwapClient == nil {
+		return
+	}
+	ticker := time.NewTicker(svc.liquidityCheckInterval())
+	defer ticker.Stop()
+	for {
+		if err := svc.UpdatePendingSwaps(ctx); err != nil {
+			svc.Logger.Errorf("Error updating swaps: %v", err)
+			sentry.CaptureException(err)
+		}
+		if err := svc.AutoSwap(ctx); err != nil {
+			svc.Logger.Errorf("Error starting automatic swap: %v", err)
+			sentry.CaptureException(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
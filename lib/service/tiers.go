@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+)
+
+const AuditActionSetUserTier = "set_user_tier"
+
+var ErrUnknownTier = errors.New("unknown account tier")
+var ErrCapabilityNotAvailable = errors.New("feature is not available for the account tier")
+
+// UserTiers are the tiers a user can be assigned to
+var UserTiers = []string{common.UserTierBasic, common.UserTierVerified, common.UserTierMerchant}
+
+// AccountTier overrides the global limits and service fees for the users of a tier
+// Settings that are not set fall back to the global configuration
+type AccountTier struct {
+	MaxSendAmount             *int64   `json:"max_send_amount"`
+	DailySendLimit            *int64   `json:"daily_send_limit"`
+	WeeklySendLimit           *int64   `json:"weekly_send_limit"`
+	ServiceFeeOutgoingBase    *int64   `json:"service_fee_outgoing_base"`
+	ServiceFeeOutgoingPercent *float64 `json:"service_fee_outgoing_percent"`
+	ServiceFeeIncomingBase    *int64   `json:"service_fee_incoming_base"`
+	ServiceFeeIncomingPercent *float64 `json:"service_fee_incoming_percent"`
+	MaxOpenInvoices           *int64   `json:"max_open_invoices"`
+	Capabilities              []string `json:"capabilities"`
+}
+
+// AccountTiers are configured as a JSON object by tier name
+// e.g. {"verified": {"daily_send_limit": 5000000}, "merchant": {"max_send_amount": 0, "capabilities": ["api_keys"]}}
+type AccountTiers map[string]AccountTier
+
+// Decode implements envconfig.Decoder
+func (tiers *AccountTiers) Decode(value string) error {
+	result := AccountTiers{}
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return fmt.Errorf("invalid account tiers: %v", err)
+	}
+	for name, tier := range result {
+		if !isUserTier(name) {
+			return fmt.Errorf("invalid account tiers: unknown tier %s", name)
+		}
+		for _, capability := range tier.Capabilities {
+			if capability != common.CapabilityOnchainWithdrawals && capability != common.CapabilityAPIKeys {
+				return fmt.Errorf("invalid account tiers: unknown capability %s", capability)
+			}
+		}
+	}
+	*tiers = result
+	return nil
+}
+
+// TierSettings are the limits, fees and capabilities that apply to a user
+type TierSettings struct {
+	Tier                      string
+	MaxSendAmount             int64
+	DailySendLimit            int64
+	WeeklySendLimit           int64
+	ServiceFeeOutgoingBase    int64
+	ServiceFeeOutgoingPercent float64
+	ServiceFeeIncomingBase    int64
+	ServiceFeeIncomingPercent float64
+	MaxOpenInvoices           int64
+	Capabilities              []string
+}
+
+// OutgoingServiceFeeFor returns the platform fee charged on top of an outgoing payment
+func (s *TierSettings) OutgoingServiceFeeFor(amount int64) int64 {
+	return calculateServiceFee(amount, s.ServiceFeeOutgoingBase, s.ServiceFeeOutgoingPercent)
+}
+
+// IncomingServiceFeeFor returns the platform fee deducted from a settled incoming invoice
+// The fee never exceeds the invoice amount
+func (s *TierSettings) IncomingServiceFeeFor(amount int64) int64 {
+	fee := calculateServiceFee(amount, s.ServiceFeeIncomingBase, s.ServiceFeeIncomingPercent)
+	if fee > amount {
+		return amount
+	}
+	return fee
+}
+
+func (s *TierSettings) HasCapability(capability string) bool {
+	for _, c := range s.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func isUserTier(tier string) bool {
+	for _, t := range UserTiers {
+		if t == tier {
+			return true
+		}
+	}
+	return false
+}
+
+// TierSettingsFor applies the overrides of the tier to the global configuration
+func (svc *LndhubService) TierSettingsFor(tier string) TierSettings {
+	settings := TierSettings{
+		Tier:                      tier,
+		MaxSendAmount:             svc.Config.MaxSendAmount,
+		DailySendLimit:            svc.Config.DailySendLimit,
+		WeeklySendLimit:           svc.Config.WeeklySendLimit,
+		ServiceFeeOutgoingBase:    svc.Config.ServiceFeeOutgoingBase,
+		ServiceFeeOutgoingPercent: svc.Config.ServiceFeeOutgoingPercent,
+		ServiceFeeIncomingBase:    svc.Config.ServiceFeeIncomingBase,
+		ServiceFeeIncomingPercent: svc.Config.ServiceFeeIncomingPercent,
+		MaxOpenInvoices:           svc.Config.MaxOpenInvoices,
+	}
+	override, ok := svc.Config.AccountTiers[tier]
+	if !ok {
+		return settings
+	}
+	if override.MaxSendAmount != nil {
+		settings.MaxSendAmount = *override.MaxSendAmount
+	}
+	if override.DailySendLimit != nil {
+		settings.DailySendLimit = *override.DailySendLimit
+	}
+	if override.WeeklySendLimit != nil {
+		settings.WeeklySendLimit = *override.WeeklySendLimit
+	}
+	if override.ServiceFeeOutgoingBase != nil {
+		settings.ServiceFeeOutgoingBase = *override.ServiceFeeOutgoingBase
+	}
+	if override.ServiceFeeOutgoingPercent != nil {
+		settings.ServiceFeeOutgoingPercent = *override.ServiceFeeOutgoingPercent
+	}
+	if override.ServiceFeeIncomingBase != nil {
+		settings.ServiceFeeIncomingBase = *override.ServiceFeeIncomingBase
+	}
+	if override.ServiceFeeIncomingPercent != nil {
+		settings.ServiceFeeIncomingPercent = *override.ServiceFeeIncomingPercent
+	}
+	if override.MaxOpenInvoices != nil {
+		settings.MaxOpenInvoices = *override.MaxOpenInvoices
+	}
+	settings.Capabilities = override.Capabilities
+	return settings
+}
+
+// UserTierSettings returns the settings of the user's tier
+func (svc *LndhubService) UserTierSettings(ctx context.Context, userId int64) (*TierSettings, error) {
+	var tier string
+	err := svc.DB.NewSelect().Model((*models.User)(nil)).Column("tier").Where("id = ?", userId).Scan(ctx, &tier)
+	if err != nil {
+		return nil, err
+	}
+	settings := svc.TierSettingsFor(tier)
+	return &settings, nil
+}
+
+// CheckCapability returns ErrCapabilityNotAvailable if the user's tier does not include the capability
+func (svc *LndhubService) CheckCapability(ctx context.Context, userId int64, capability string) error {
+	settings, err := svc.UserTierSettings(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !settings.HasCapability(capability) {
+		return ErrCapabilityNotAvailable
+	}
+	return nil
+}
+
+// SetUserTier assigns the user to the tier and records the change in the audit log
+func (svc *LndhubService) SetUserTier(ctx context.Context, userId int64, tier string) error {
+	if !isUserTier(tier) {
+		return ErrUnknownTier
+	}
+	res, err := svc.DB.NewUpdate().Model((*models.User)(nil)).
+		Set("tier = ?", tier).
+		Set("updated_at = current_timestamp").
+		Where("id = ?", userId).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("user not found: %v", userId)
+	}
+	return svc.AddAuditLog(ctx, AuditActionSetUserTier, userId, 0, tier)
+}
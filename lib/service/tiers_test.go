@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountTiersDecode(t *testing.T) {
+	tiers := AccountTiers{}
+	err := tiers.Decode(`{"merchant": {"max_send_amount": 0, "service_fee_outgoing_percent": 0.2, "capabilities": ["api_keys"]}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), *tiers["merchant"].MaxSendAmount)
+	assert.Nil(t, tiers["merchant"].DailySendLimit)
+
+	assert.Error(t, tiers.Decode(`{"gold": {}}`))
+	assert.Error(t, tiers.Decode(`{"verified": {"capabilities": ["teleport"]}}`))
+	assert.Error(t, tiers.Decode(`verified`))
+}
+
+func TestTierSettingsFor(t *testing.T) {
+	tiers := AccountTiers{}
+	assert.NoError(t, tiers.Decode(`{"merchant": {"max_send_amount": 0, "service_fee_outgoing_percent": 0.2, "max_open_invoices": 1000, "capabilities": ["api_keys"]}}`))
+	svc := &LndhubService{Config: &Config{
+		MaxOpenInvoices:           50,
+		MaxSendAmount:             100000,
+		DailySendLimit:            500000,
+		ServiceFeeOutgoingPercent: 1,
+		AccountTiers:              tiers,
+	}}
+
+	basic := svc.TierSettingsFor("basic")
+	assert.Equal(t, int64(100000), basic.MaxSendAmount)
+	assert.Equal(t, int64(10), basic.OutgoingServiceFeeFor(1000))
+	assert.False(t, basic.HasCapability("api_keys"))
+	assert.Equal(t, int64(50), basic.MaxOpenInvoices)
+
+	merchant := svc.TierSettingsFor("merchant")
+	assert.Equal(t, int64(0), merchant.MaxSendAmount)
+	assert.Equal(t, int64(500000), merchant.DailySendLimit)
+	assert.Equal(t, int64(2), merchant.OutgoingServiceFeeFor(1000))
+	assert.True(t, merchant.HasCapability("api_keys"))
+	assert.Equal(t, int64(1000), merchant.MaxOpenInvoices)
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/export"
+	"github.com/uptrace/bun"
+)
+
+const transactionExportBatchSize = 500
+
+// TransactionExportColumns are the columns of a transaction export, one row per settled invoice
+// balance_change is the effect on the user's balance including all fees, negative for payments
+// amount_msat and fee_msat are the exact amounts, amount and fee the satoshis booked
+var TransactionExportColumns = []string{
+	"id",
+	"created_at",
+	"settled_at",
+	"type",
+	"amount",
+	"fee",
+	"service_fee",
+	"balance_change",
+	"memo",
+	"payment_hash",
+	"destination",
+	"amount_msat",
+	"fee_msat",
+}
+
+func transactionExportRow(invoice *models.Invoice) []string {
+	txType := invoice.Type
+	balanceChange := invoice.Amount - invoice.ServiceFee
+	if invoice.Type == common.InvoiceTypeOutgoing {
+		if invoice.Keysend {
+			txType = TransactionTypeKeysend
+		}
+		balanceChange = -(invoice.Amount + invoice.Fee + invoice.ServiceFee)
+	}
+	settledAt := ""
+	if !invoice.SettledAt.IsZero() {
+		settledAt = invoice.SettledAt.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		strconv.FormatInt(invoice.ID, 10),
+		invoice.CreatedAt.UTC().Format(time.RFC3339),
+		settledAt,
+		txType,
+		strconv.FormatInt(invoice.Amount, 10),
+		strconv.FormatInt(invoice.Fee, 10),
+		strconv.FormatInt(invoice.ServiceFee, 10),
+		strconv.FormatInt(balanceChange, 10),
+		invoice.Memo,
+		invoice.RHash,
+		invoice.DestinationPubkeyHex,
+		strconv.FormatInt(invoice.AmountMsat, 10),
+		strconv.FormatInt(invoice.FeeMsat, 10),
+	}
+}
+
+// ExportTransactions writes all settled incoming and outgoing invoices of the user to the export, oldest first
+// The invoices are read in batches so exports of large accounts are streamed, flush is called after every batch
+func (svc *LndhubService) ExportTransactions(ctx context.Context, userId int64, from, to time.Time, w *export.Writer, flush func()) error {
+	var lastId int64
+	for {
+		invoices := []models.Invoice{}
+		query := svc.readDB(ctx).NewSelect().Model(&invoices).
+			Where("user_id = ? AND state = ? AND id > ?", userId, common.InvoiceStateSettled, lastId).
+			WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Where("type = ?", common.InvoiceTypeIncoming).WhereOr("type = ?", common.InvoiceTypeOutgoing)
+			})
+		if !from.IsZero() {
+			query.Where("created_at >= ?", from)
+		}
+		if !to.IsZero() {
+			query.Where("created_at < ?", to)
+		}
+		if err := query.OrderExpr("id ASC").Limit(transactionExportBatchSize).Scan(ctx); err != nil {
+			return err
+		}
+		for i := range invoices {
+			if err := w.WriteRow(transactionExportRow(&invoices[i])); err != nil {
+				return err
+			}
+		}
+		flush()
+		if len(invoices) < transactionExportBatchSize {
+			return nil
+		}
+		lastId = invoices[len(invoices)-1].ID
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+func TestTransactionExportRow(t *testing.T) {
+	createdAt := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	incoming := &models.Invoice{
+		ID:         1,
+		Type:       common.InvoiceTypeIncoming,
+		Amount:     1000,
+		AmountMsat: 1000400,
+		ServiceFee: 10,
+		Memo:       "coffee",
+		RHash:      "abcd",
+		CreatedAt:  createdAt,
+		SettledAt:  bun.NullTime{Time: createdAt.Add(time.Minute)},
+	}
+	row := transactionExportRow(incoming)
+	assert.Len(t, row, len(TransactionExportColumns))
+	assert.Equal(t, []string{"1", "2022-05-01T12:00:00Z", "2022-05-01T12:01:00Z", "incoming", "1000", "0", "10", "990", "coffee", "abcd", "", "1000400", "0"}, row)
+
+	keysend := &models.Invoice{
+		ID:                   2,
+		Type:                 common.InvoiceTypeOutgoing,
+		Keysend:              true,
+		Amount:               500,
+		Fee:                  3,
+		ServiceFee:           1,
+		DestinationPubkeyHex: "02ab",
+		CreatedAt:            createdAt,
+	}
+	row = transactionExportRow(keysend)
+	assert.Equal(t, "keysend", row[3])
+	assert.Equal(t, "", row[2])
+	assert.Equal(t, "-504", row[7])
+	assert.Equal(t, "02ab", row[10])
+	assert.Equal(t, "0", row[11])
+}
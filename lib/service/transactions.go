@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+)
+
+const (
+	TransactionTypeAll      = "all"
+	TransactionTypeIncoming = "incoming"
+	TransactionTypeOutgoing = "outgoing"
+	TransactionTypeKeysend  = "keysend"
+)
+
+var ErrInvalidTransactionFilter = errors.New("invalid transaction filter")
+
+// TransactionCursor is the position after the last transaction of a page, transactions are ordered by creation time and id
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+// String encodes the cursor for clients, the creation time in microseconds matches the database precision
+func (c *TransactionCursor) String() string {
+	return fmt.Sprintf("%d_%d", c.CreatedAt.UnixMicro(), c.ID)
+}
+
+func ParseTransactionCursor(cursor string) (*TransactionCursor, error) {
+	parts := strings.Split(cursor, "_")
+	if len(parts) != 2 {
+		return nil, ErrInvalidTransactionFilter
+	}
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidTransactionFilter
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || id <= 0 {
+		return nil, ErrInvalidTransactionFilter
+	}
+	return &TransactionCursor{CreatedAt: time.UnixMicro(micros), ID: id}, nil
+}
+
+// TransactionFilter selects a page of a user's settled incoming and sent or failed outgoing invoices, newest first
+type TransactionFilter struct {
+	Type   string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Cursor *TransactionCursor
+}
+
+// Validate checks the filter and applies the defaults, the latest 100 outgoing transactions
+func (f *TransactionFilter) Validate() error {
+	switch f.Type {
+	case "":
+		f.Type = TransactionTypeOutgoing
+	case TransactionTypeAll, TransactionTypeIncoming, TransactionTypeOutgoing, TransactionTypeKeysend:
+	default:
+		return ErrInvalidTransactionFilter
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return ErrInvalidTransactionFilter
+	}
+	if f.Limit < 0 || f.Limit > maxInvoicePageSize {
+		return ErrInvalidTransactionFilter
+	}
+	if f.Limit == 0 {
+		f.Limit = defaultInvoicePageSize
+	}
+	return nil
+}
+
+// TransactionsFor returns a page of the user's transactions and the cursor of the next page, nil on the last page
+// Pages are read with a keyset on (created_at, id), so the response time does not grow with the page number
+func (svc *LndhubService) TransactionsFor(ctx context.Context, userId int64, filter TransactionFilter) ([]models.Invoice, *TransactionCursor, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, nil, err
+	}
+	invoices := []models.Invoice{}
+	query := svc.readDB(ctx).NewSelect().Model(&invoices).Where("user_id = ?", userId)
+	incoming := func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("type = ? AND state = ?", common.InvoiceTypeIncoming, common.InvoiceStateSettled)
+	}
+	outgoing := func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("type = ? AND state <> ?", common.InvoiceTypeOutgoing, common.InvoiceStateInitialized)
+	}
+	switch filter.Type {
+	case TransactionTypeAll:
+		query.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.WhereGroup(" OR ", incoming).WhereGroup(" OR ", outgoing)
+		})
+	case TransactionTypeIncoming:
+		query.WhereGroup(" AND ", incoming)
+	case TransactionTypeOutgoing:
+		query.WhereGroup(" AND ", outgoing)
+	case TransactionTypeKeysend:
+		query.WhereGroup(" AND ", outgoing).Where("keysend = ?", true)
+	}
+	if !filter.From.IsZero() {
+		query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query.Where("created_at < ?", filter.To)
+	}
+	if filter.Cursor != nil {
+		query.Where("(created_at, id) < (?, ?)", filter.Cursor.CreatedAt, filter.Cursor.ID)
+	}
+	err := query.OrderExpr("created_at DESC, id DESC").Limit(filter.Limit).Scan(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(invoices) < filter.Limit {
+		return invoices, nil, nil
+	}
+	last := invoices[len(invoices)-1]
+	return invoices, &TransactionCursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionCursor(t *testing.T) {
+	cursor := &TransactionCursor{CreatedAt: time.Date(2022, 5, 1, 10, 0, 0, 123456000, time.UTC), ID: 42}
+	parsed, err := ParseTransactionCursor(cursor.String())
+	assert.NoError(t, err)
+	assert.True(t, cursor.CreatedAt.Equal(parsed.CreatedAt))
+	assert.Equal(t, int64(42), parsed.ID)
+
+	for _, invalid := range []string{"", "42", "abc_42", "1651399200123456_0", "1_2_3"} {
+		_, err := ParseTransactionCursor(invalid)
+		assert.ErrorIs(t, err, ErrInvalidTransactionFilter)
+	}
+}
+
+func TestTransactionFilterValidate(t *testing.T) {
+	filter := TransactionFilter{}
+	assert.NoError(t, filter.Validate())
+	assert.Equal(t, TransactionTypeOutgoing, filter.Type)
+	assert.Equal(t, defaultInvoicePageSize, filter.Limit)
+
+	filter = TransactionFilter{Type: TransactionTypeKeysend, Limit: 10}
+	assert.NoError(t, filter.Validate())
+
+	now := time.Now()
+	for _, invalid := range []TransactionFilter{
+		{Type: "swap_cost"},
+		{From: now, To: now.Add(-time.Hour)},
+		{Limit: maxInvoicePageSize + 1},
+	} {
+		assert.ErrorIs(t, invalid.Validate(), ErrInvalidTransactionFilter)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/preimage"
+	"github.com/uptrace/bun"
+)
+
+var ErrTransferRecipientNotFound = errors.New("recipient not found or can not receive transfers")
+
+// transferInvoices are the sender's outgoing and the recipient's open incoming invoice of a transfer
+// Both share the payment hash, the internal payment settles the incoming invoice by its payment hash as there is no payment request
+func transferInvoices(senderId, recipientId, amount int64, memo, source, identityPubkey string, expiresAt time.Time) (outgoing, incoming *models.Invoice, err error) {
+	invoicePreimage, err := preimage.New()
+	if err != nil {
+		return nil, nil, err
+	}
+	rHash := preimage.HashHex(invoicePreimage)
+	incoming = &models.Invoice{
+		Type:                 common.InvoiceTypeIncoming,
+		UserID:               recipientId,
+		Amount:               amount,
+		Memo:                 memo,
+		RHash:                rHash,
+		Preimage:             hex.EncodeToString(invoicePreimage),
+		Internal:             true,
+		State:                common.InvoiceStateOpen,
+		DestinationPubkeyHex: identityPubkey,
+		Metadata:             map[string]string{common.InvoiceMetadataSource: source},
+		ExpiresAt:            bun.NullTime{Time: expiresAt},
+	}
+	outgoing = &models.Invoice{
+		Type:                 common.InvoiceTypeOutgoing,
+		UserID:               senderId,
+		Amount:               amount,
+		Memo:                 memo,
+		RHash:                rHash,
+		Internal:             true,
+		State:                common.InvoiceStateInitialized,
+		DestinationPubkeyHex: identityPubkey,
+		Metadata:             map[string]string{common.InvoiceMetadataSource: source},
+		ExpiresAt:            bun.NullTime{Time: expiresAt},
+	}
+	return outgoing, incoming, nil
+}
+
+// AddTransferInvoice prepares a transfer of the amount to the user with the login, no bolt11 invoice is created
+// It returns the sender's outgoing invoice, paying it with PayInvoice books the transfer in the ledger of both users
+// Deleted and frozen users can not receive transfers
+func (svc *LndhubService) AddTransferInvoice(ctx context.Context, senderId int64, recipientLogin string, amount int64, memo string) (*models.Invoice, error) {
+	return svc.addTransferInvoice(ctx, senderId, recipientLogin, amount, memo, common.InvoiceSourceTransfer)
+}
+
+// addTransferInvoice prepares a transfer, the source is stored in the metadata of both invoices
+func (svc *LndhubService) addTransferInvoice(ctx context.Context, senderId int64, recipientLogin string, amount int64, memo, source string) (*models.Invoice, error) {
+	var recipient models.User
+	err := svc.DB.NewSelect().Model(&recipient).
+		Where("login = ? AND deleted_at IS NULL AND frozen_at IS NULL", recipientLogin).
+		Limit(1).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTransferRecipientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if recipient.ID == senderId {
+		return nil, ErrSelfPayment
+	}
+	if err := svc.AmountLimits(nil).CheckReceiveAmount(amount); err != nil {
+		return nil, err
+	}
+	expiry, err := svc.InvoiceExpiry(0)
+	if err != nil {
+		return nil, err
+	}
+	outgoing, incoming, err := transferInvoices(senderId, recipient.ID, amount, svc.sanitizeMemo(memo), source, svc.IdentityPubkey, time.Now().Add(expiry))
+	if err != nil {
+		return nil, err
+	}
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(incoming).Exec(ctx); err != nil {
+			return err
+		}
+		_, err := tx.NewInsert().Model(outgoing).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return outgoing, nil
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/lib/preimage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferInvoices(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	outgoing, incoming, err := transferInvoices(1, 2, 500, "Lunch", common.InvoiceSourceTransfer, "pubkey", expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, common.InvoiceTypeOutgoing, outgoing.Type)
+	assert.Equal(t, int64(1), outgoing.UserID)
+	assert.Equal(t, common.InvoiceStateInitialized, outgoing.State)
+	assert.Equal(t, "", outgoing.PaymentRequest)
+	assert.Equal(t, "", outgoing.Preimage)
+	assert.Equal(t, common.InvoiceTypeIncoming, incoming.Type)
+	assert.Equal(t, int64(2), incoming.UserID)
+	assert.Equal(t, common.InvoiceStateOpen, incoming.State)
+	assert.True(t, incoming.Internal)
+	assert.Equal(t, outgoing.RHash, incoming.RHash)
+	assert.NoError(t, preimage.VerifyHex(incoming.Preimage, outgoing.RHash))
+	assert.Equal(t, "pubkey", outgoing.DestinationPubkeyHex)
+	assert.Equal(t, "pubkey", incoming.DestinationPubkeyHex)
+	assert.Equal(t, int64(500), incoming.Amount)
+	assert.Equal(t, "Lunch", incoming.Memo)
+	assert.Equal(t, common.InvoiceSourceTransfer, incoming.Metadata[common.InvoiceMetadataSource])
+	assert.Equal(t, expiresAt, incoming.ExpiresAt.Time)
+
+	other, _, err := transferInvoices(1, 2, 500, "", common.InvoiceSourceTransfer, "pubkey", expiresAt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, outgoing.RHash, other.RHash)
+}
@@ -3,12 +3,15 @@ package service
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"math/rand"
+	"time"
 
 	"github.com/getAlby/lndhub.go/common"
 	"github.com/getAlby/lndhub.go/db/models"
 	"github.com/getAlby/lndhub.go/lib/security"
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
 )
 
 func (svc *LndhubService) CreateUser(ctx context.Context, login string, password string) (user *models.User, err error) {
@@ -30,31 +33,38 @@ func (svc *LndhubService) CreateUser(ctx context.Context, login string, password
 	user.Password = hashedPassword
 
 	// Create user and the user's accounts
-	// We use double-entry bookkeeping so we use 4 accounts: incoming, current, outgoing and fees
+	// We use double-entry bookkeeping so we use 6 accounts: incoming, current, in-flight, outgoing, fees and service fees
 	// Wrapping this in a transaction in case something fails
 	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
-		if _, err := tx.NewInsert().Model(user).Exec(ctx); err != nil {
-			return err
-		}
-		accountTypes := []string{
-			common.AccountTypeIncoming,
-			common.AccountTypeCurrent,
-			common.AccountTypeOutgoing,
-			common.AccountTypeFees,
-		}
-		for _, accountType := range accountTypes {
-			account := models.Account{UserID: user.ID, Type: accountType}
-			if _, err := tx.NewInsert().Model(&account).Exec(ctx); err != nil {
-				return err
-			}
-		}
-		return nil
+		return insertUser(ctx, tx, user)
 	})
 	//return actual password in the response, not the hashed one
 	user.Password = password
 	return user, err
 }
 
+// insertUser inserts the user and the user's accounts
+func insertUser(ctx context.Context, tx bun.IDB, user *models.User) error {
+	if _, err := tx.NewInsert().Model(user).Exec(ctx); err != nil {
+		return err
+	}
+	accountTypes := []string{
+		common.AccountTypeIncoming,
+		common.AccountTypeCurrent,
+		common.AccountTypeInFlight,
+		common.AccountTypeOutgoing,
+		common.AccountTypeFees,
+		common.AccountTypeServiceFees,
+	}
+	for _, accountType := range accountTypes {
+		account := models.Account{UserID: user.ID, Type: accountType}
+		if _, err := tx.NewInsert().Model(&account).Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (svc *LndhubService) FindUser(ctx context.Context, userId int64) (*models.User, error) {
 	var user models.User
 
@@ -75,20 +85,40 @@ func (svc *LndhubService) FindUserByLogin(ctx context.Context, login string) (*m
 	return &user, nil
 }
 
+// CurrentUserBalance reads the balance maintained alongside the ledger by the update_account_balances trigger
+// SQLite has no such trigger and sums up the ledger
+// Requests marked with WithReadReplica read the balance from the replica, it can lag behind a payment that was just made
 func (svc *LndhubService) CurrentUserBalance(ctx context.Context, userId int64) (int64, error) {
-	var balance int64
-
 	account, err := svc.AccountFor(ctx, common.AccountTypeCurrent, userId)
 	if err != nil {
+		return 0, err
+	}
+	return svc.accountBalance(ctx, svc.readDB(ctx), account.ID)
+}
+
+// accountBalance reads the balance of the account with db, transactions pass themselves to read what they locked
+func (svc *LndhubService) accountBalance(ctx context.Context, db bun.IDB, accountId int64) (int64, error) {
+	var balance int64
+	if db.Dialect().Name() == dialect.SQLite {
+		err := db.NewSelect().Table("account_ledgers").ColumnExpr("sum(account_ledgers.amount) as balance").Where("account_ledgers.account_id = ?", accountId).Scan(ctx, &balance)
 		return balance, err
 	}
-	err = svc.DB.NewSelect().Table("account_ledgers").ColumnExpr("sum(account_ledgers.amount) as balance").Where("account_ledgers.account_id = ?", account.ID).Scan(ctx, &balance)
-	return balance, err
+	accountBalance := models.AccountBalance{}
+	err := db.NewSelect().Model(&accountBalance).Where("account_id = ?", accountId).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		// no entries booked yet
+		return 0, nil
+	}
+	return accountBalance.Balance, err
 }
 
 func (svc *LndhubService) AccountFor(ctx context.Context, accountType string, userId int64) (models.Account, error) {
+	return svc.accountFor(ctx, svc.DB, accountType, userId)
+}
+
+func (svc *LndhubService) accountFor(ctx context.Context, db bun.IDB, accountType string, userId int64) (models.Account, error) {
 	account := models.Account{}
-	err := svc.DB.NewSelect().Model(&account).Where("user_id = ? AND type= ?", userId, accountType).Limit(1).Scan(ctx)
+	err := db.NewSelect().Model(&account).Where("user_id = ? AND type= ? AND asset = ?", userId, accountType, models.AssetBTC).Limit(1).Scan(ctx)
 	return account, err
 }
 
@@ -113,6 +143,119 @@ func (svc *LndhubService) InvoicesFor(ctx context.Context, userId int64, invoice
 	return invoices, nil
 }
 
+const (
+	defaultInvoicePageSize = 100
+	maxInvoicePageSize     = 1000
+)
+
+var ErrInvalidInvoiceFilter = errors.New("invalid invoice filter")
+
+// InvoiceFilter selects a page of a user's invoices, newest first
+// Cursor is the id of the last invoice of the previous page, only older invoices are returned
+// Archived reads the unpaid invoices moved to archived_invoices by the retention policy instead of invoices
+type InvoiceFilter struct {
+	Type     string
+	States   []string
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+	Cursor   int64
+	Archived bool
+}
+
+// Validate checks the filter and applies the default page size
+func (f *InvoiceFilter) Validate() error {
+	if f.Type != common.InvoiceTypeIncoming && f.Type != common.InvoiceTypeOutgoing {
+		return ErrInvalidInvoiceFilter
+	}
+	for _, state := range f.States {
+		switch state {
+		case common.InvoiceStateOpen, common.InvoiceStateSettled, common.InvoiceStateError, common.InvoiceStateExpired, common.InvoiceStateCanceled:
+		default:
+			return ErrInvalidInvoiceFilter
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return ErrInvalidInvoiceFilter
+	}
+	if f.Limit < 0 || f.Limit > maxInvoicePageSize || f.Offset < 0 || f.Cursor < 0 {
+		return ErrInvalidInvoiceFilter
+	}
+	if f.Limit == 0 {
+		f.Limit = defaultInvoicePageSize
+	}
+	return nil
+}
+
+// FilteredInvoicesFor returns a page of the user's invoices and the number of all invoices matching the filter
+func (svc *LndhubService) FilteredInvoicesFor(ctx context.Context, userId int64, filter InvoiceFilter) ([]models.Invoice, int, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, 0, err
+	}
+	invoices := []models.Invoice{}
+	archived := []models.ArchivedInvoice{}
+	var model interface{} = &invoices
+	if filter.Archived {
+		model = &archived
+	}
+	query := svc.readDB(ctx).NewSelect().Model(model).
+		Where("user_id = ? AND type = ? AND state <> ?", userId, filter.Type, common.InvoiceStateInitialized)
+	if filter.Archived {
+		// archived invoices all expired unpaid, whatever state they were archived with
+		expired := len(filter.States) == 0
+		for _, state := range filter.States {
+			expired = expired || state == common.InvoiceStateExpired
+		}
+		if !expired {
+			return invoices, 0, nil
+		}
+	} else if len(filter.States) > 0 {
+		query.Where("state IN (?)", bun.In(filter.States))
+	}
+	if !filter.From.IsZero() {
+		query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query.Where("created_at < ?", filter.To)
+	}
+	total, err := query.Count(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if filter.Cursor > 0 {
+		query.Where("id < ?", filter.Cursor)
+	}
+	err = query.OrderExpr("id DESC").Limit(filter.Limit).Offset(filter.Offset).Scan(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, invoice := range archived {
+		invoice.Invoice.State = common.InvoiceStateExpired
+		invoices = append(invoices, invoice.Invoice)
+	}
+	return invoices, total, nil
+}
+
+// PendingInvoicesFor returns open incoming invoices and outgoing invoices that are in-flight
+// An outgoing invoice is in-flight once the user's balance has been debited and before it is settled or failed
+func (svc *LndhubService) PendingInvoicesFor(ctx context.Context, userId int64) ([]models.Invoice, error) {
+	var invoices []models.Invoice
+
+	err := svc.readDB(ctx).NewSelect().Model(&invoices).
+		Where("user_id = ?", userId).
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.
+				Where("type = ? AND state = ? AND expires_at > ?", common.InvoiceTypeIncoming, common.InvoiceStateOpen, time.Now()).
+				WhereOr("type = ? AND state = ? AND EXISTS (SELECT 1 FROM transaction_entries WHERE transaction_entries.invoice_id = invoice.id)", common.InvoiceTypeOutgoing, common.InvoiceStateInitialized)
+		}).
+		OrderExpr("id DESC").Limit(100).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
 func randStringBytes(n int) string {
 	b := make([]byte, n)
 	for i := range b {
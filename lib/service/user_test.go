@@ -0,0 +1,32 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoiceFilterValidate(t *testing.T) {
+	filter := InvoiceFilter{Type: common.InvoiceTypeIncoming}
+	assert.NoError(t, filter.Validate())
+	assert.Equal(t, defaultInvoicePageSize, filter.Limit)
+
+	filter = InvoiceFilter{Type: common.InvoiceTypeOutgoing, States: []string{common.InvoiceStateSettled, common.InvoiceStateError}, Limit: maxInvoicePageSize}
+	assert.NoError(t, filter.Validate())
+	assert.Equal(t, maxInvoicePageSize, filter.Limit)
+
+	now := time.Now()
+	invalid := []InvoiceFilter{
+		{Type: "swap_cost"},
+		{Type: common.InvoiceTypeIncoming, States: []string{common.InvoiceStateInitialized}},
+		{Type: common.InvoiceTypeIncoming, From: now, To: now.Add(-time.Hour)},
+		{Type: common.InvoiceTypeIncoming, Limit: maxInvoicePageSize + 1},
+		{Type: common.InvoiceTypeIncoming, Offset: -1},
+		{Type: common.InvoiceTypeIncoming, Cursor: -1},
+	}
+	for _, filter := range invalid {
+		assert.ErrorIs(t, filter.Validate(), ErrInvalidInvoiceFilter)
+	}
+}
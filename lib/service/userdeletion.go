@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/security"
+	"github.com/uptrace/bun"
+)
+
+const AuditActionDeleteUser = "delete_user"
+
+// the login of a deleted user is random, no one can create a user with it beforehand
+const deletedLoginPrefix = "deleted-"
+
+// deletedPassword is not a valid bcrypt hash, no password matches it
+const deletedPassword = "deleted"
+
+var ErrWrongPassword = errors.New("password is wrong")
+var ErrAccountNotEmpty = errors.New("account has a balance or payments in flight")
+
+// tables of data the user saved, deleted with the user
+var deletedUserTables = []interface{}{
+	(*models.Contact)(nil),
+	(*models.KeysendDestination)(nil),
+	(*models.InvoicePreset)(nil),
+	(*models.RecurringInvoice)(nil),
+	(*models.AutoWithdrawal)(nil),
+	(*models.PrismSplit)(nil),
+	(*models.APIKey)(nil),
+	(*models.AuthSession)(nil),
+	(*models.CredentialPayment)(nil),
+	(*models.DonationPage)(nil),
+	(*models.Notification)(nil),
+	(*models.IdempotencyKey)(nil),
+	(*models.PaymentConfirmation)(nil),
+	(*models.InvoiceCounter)(nil),
+	(*models.PaymentFailureCounter)(nil),
+	(*models.ArchivedInvoice)(nil),
+}
+
+// DeleteUser deletes the account of the user once the balance is withdrawn
+// Open invoices are canceled and the saved data of the user is deleted. Invoices, accounts and transaction entries
+// are kept for the ledger with their memos and metadata removed, the login and password are replaced by a tombstone
+// The sessions and api keys of the user are deleted, access tokens issued to the user are rejected from then on
+func (svc *LndhubService) DeleteUser(ctx context.Context, userId int64, password string) error {
+	user, err := svc.FindUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if ok, _ := security.CheckPassword(user.Password, password); !ok {
+		return ErrWrongPassword
+	}
+
+	openInvoices := []models.Invoice{}
+	err = svc.DB.NewSelect().Model(&openInvoices).
+		Where("user_id = ? AND type = ? AND state = ?", userId, common.InvoiceTypeIncoming, common.InvoiceStateOpen).
+		Scan(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range openInvoices {
+		// invoices settled in the meantime are caught by the balance check
+		if err := svc.cancelIncomingInvoice(ctx, &openInvoices[i]); err != nil && !errors.Is(err, ErrInvoiceNotCancelable) {
+			return err
+		}
+	}
+
+	currentAccount, err := svc.AccountFor(ctx, common.AccountTypeCurrent, userId)
+	if err != nil {
+		return err
+	}
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		// payments lock the current account as well, no payment can be booked until the user is deleted
+		if err := lockAccount(ctx, tx, currentAccount.ID); err != nil {
+			return err
+		}
+		balance, err := svc.accountBalance(ctx, tx, currentAccount.ID)
+		if err != nil {
+			return err
+		}
+		inFlight, err := tx.NewSelect().Model((*models.Invoice)(nil)).
+			Where("user_id = ? AND type = ? AND state = ?", userId, common.InvoiceTypeOutgoing, common.InvoiceStateInitialized).
+			Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if balance != 0 || inFlight {
+			return ErrAccountNotEmpty
+		}
+
+		_, err = tx.NewUpdate().Model((*models.User)(nil)).
+			Set("login = ?", deletedLoginPrefix+randStringBytes(20)).
+			Set("password = ?", deletedPassword).
+			Set("email = NULL").
+			Set("memo_public_key = NULL").
+			Set("currency = NULL").
+			Set("deleted_at = current_timestamp").
+			Set("frozen_at = COALESCE(frozen_at, current_timestamp)").
+			Set("updated_at = current_timestamp").
+			Where("id = ?", userId).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewUpdate().Model((*models.Invoice)(nil)).
+			Set("memo = NULL").
+			Set("encrypted_memo = NULL").
+			Set("memo_key_hint = NULL").
+			Set("label = NULL").
+			Set("payment_request = NULL").
+			Set("metadata = NULL").
+			Set("zap_request = NULL").
+			Set("webhook_url = NULL").
+			Where("user_id = ?", userId).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewUpdate().Model((*models.BalanceClaim)(nil)).
+			Set("state = ?", common.BalanceClaimStateCanceled).
+			Set("updated_at = current_timestamp").
+			Where("user_id = ? AND state = ?", userId, common.BalanceClaimStateOpen).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		for _, model := range deletedUserTables {
+			if _, err := tx.NewDelete().Model(model).Where("user_id = ?", userId).Exec(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	svc.revocations.forgetUser(userId)
+	return svc.AddAuditLog(ctx, AuditActionDeleteUser, userId, 0, "deleted by the user")
+}
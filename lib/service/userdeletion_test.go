@@ -0,0 +1,16 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/getAlby/lndhub.go/lib/security"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletedPasswordMatchesNoPassword(t *testing.T) {
+	for _, password := range []string{"", deletedPassword, "password"} {
+		ok, rehash := security.CheckPassword(deletedPassword, password)
+		assert.False(t, ok)
+		assert.False(t, rehash)
+	}
+}
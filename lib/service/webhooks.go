@@ -0,0 +1,350 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/uptrace/bun"
+)
+
+const (
+	WebhookSignatureHeader = "X-Lndhub-Signature"
+
+	webhookDispatchInterval = 10 * time.Second
+	webhookBaseBackoff      = 10 * time.Second
+	webhookRequestTimeout   = 10 * time.Second
+	webhookBatchSize        = 50
+	// a dispatcher owns the deliveries it claimed until their batch was delivered
+	// the deliveries of a dispatcher that stopped are claimed again by any instance after the lease
+	webhookClaimLease = webhookBatchSize * webhookRequestTimeout
+)
+
+var ErrInvalidWebhookUrl = errors.New("invalid webhook url")
+var ErrWebhookAddressNotAllowed = errors.New("webhook address is not public")
+
+// publicWebhookClient delivers to the webhook URLs of users, it only connects to public addresses
+// The address is checked after the host was resolved, so hosts resolving to internal addresses and redirects to them are rejected as well
+var publicWebhookClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: webhookRequestTimeout,
+			Control: checkWebhookDial,
+		}).DialContext,
+		TLSHandshakeTimeout: webhookRequestTimeout,
+	},
+}
+
+type WebhookInvoicePayload struct {
+	ID             int64     `json:"id"`
+	Type           string    `json:"type"`
+	UserID         int64     `json:"user_id"`
+	Amount         int64     `json:"amount"`
+	Memo           string    `json:"memo"`
+	RHash          string    `json:"r_hash"`
+	PaymentRequest string    `json:"payment_request"`
+	State          string    `json:"state"`
+	SettledAt      time.Time `json:"settled_at"`
+}
+
+type WebhookPayload struct {
+	Event            string                         `json:"event"`
+	Invoice          *WebhookInvoicePayload         `json:"invoice,omitempty"`
+	PaymentFailures  *WebhookPaymentFailuresPayload `json:"payment_failures,omitempty"`
+	RecurringInvoice *models.RecurringInvoice       `json:"recurring_invoice,omitempty"`
+	NegativeBalance  *WebhookNegativeBalancePayload `json:"negative_balance,omitempty"`
+}
+
+// EnqueueInvoiceWebhook persists a webhook delivery for the invoice, the dispatcher delivers it in the background
+func (svc *LndhubService) EnqueueInvoiceWebhook(ctx context.Context, url, event string, invoice *models.Invoice) error {
+	err := svc.EnqueueWebhook(ctx, url, &WebhookPayload{
+		Event:   event,
+		Invoice: webhookInvoicePayload(invoice),
+	})
+	if err != nil {
+		svc.Logger.Errorf("Could not enqueue webhook invoice_id:%v event:%s %v", invoice.ID, event, err)
+	}
+	return err
+}
+
+func webhookInvoicePayload(invoice *models.Invoice) *WebhookInvoicePayload {
+	return &WebhookInvoicePayload{
+		ID:             invoice.ID,
+		Type:           invoice.Type,
+		UserID:         invoice.UserID,
+		Amount:         invoice.Amount,
+		Memo:           invoice.Memo,
+		RHash:          invoice.RHash,
+		PaymentRequest: invoice.PaymentRequest,
+		State:          invoice.State,
+		SettledAt:      invoice.SettledAt.Time,
+	}
+}
+
+// EnqueueWebhook persists a webhook delivery of the payload, the dispatcher delivers it in the background
+func (svc *LndhubService) EnqueueWebhook(ctx context.Context, url string, webhookPayload *WebhookPayload) error {
+	if url == "" {
+		return nil
+	}
+	payload, err := json.Marshal(webhookPayload)
+	if err != nil {
+		return err
+	}
+	delivery := models.WebhookDelivery{
+		URL:           url,
+		Event:         webhookPayload.Event,
+		Payload:       string(payload),
+		State:         common.WebhookDeliveryStatePending,
+		NextAttemptAt: time.Now(),
+	}
+	_, err = svc.DB.NewInsert().Model(&delivery).Exec(ctx)
+	return err
+}
+
+// StartWebhookDispatcher delivers due webhooks until the context is canceled
+func (svc *LndhubService) StartWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(webhookDispatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := svc.DispatchDueWebhooks(ctx); err != nil {
+				svc.Logger.Errorf("Error dispatching webhooks: %v", err)
+				sentry.CaptureException(err)
+			}
+		}
+	}
+}
+
+func (svc *LndhubService) DispatchDueWebhooks(ctx context.Context) error {
+	deliveries, err := svc.claimDueWebhooks(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range deliveries {
+		svc.deliverWebhook(ctx, &deliveries[i])
+	}
+	return nil
+}
+
+// claimDueWebhooks moves the next attempt of the due deliveries past the claim lease, other instances do not deliver them again
+// Dispatchers that claim at the same time skip the rows locked by each other instead of waiting for them
+func (svc *LndhubService) claimDueWebhooks(ctx context.Context, now time.Time) ([]models.WebhookDelivery, error) {
+	deliveries := []models.WebhookDelivery{}
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		q := tx.NewSelect().Model(&deliveries).
+			Where("state = ? AND next_attempt_at <= ?", common.WebhookDeliveryStatePending, now).
+			OrderExpr("id ASC").Limit(webhookBatchSize)
+		if err := forUpdateSkipLocked(tx, q).Scan(ctx); err != nil || len(deliveries) == 0 {
+			return err
+		}
+		ids := make([]int64, len(deliveries))
+		for i := range deliveries {
+			ids[i] = deliveries[i].ID
+		}
+		_, err := tx.NewUpdate().Model((*models.WebhookDelivery)(nil)).
+			Set("next_attempt_at = ?", now.Add(webhookClaimLease)).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (svc *LndhubService) deliverWebhook(ctx context.Context, delivery *models.WebhookDelivery) {
+	attempt := models.WebhookDeliveryAttempt{WebhookDeliveryID: delivery.ID}
+	attempt.StatusCode, attempt.Error = postWebhook(ctx, svc.webhookClient(delivery.URL), delivery, svc.Config.WebhookSecret)
+	delivery.Attempts++
+
+	if attempt.Error == "" {
+		delivery.State = common.WebhookDeliveryStateDelivered
+		delivery.DeliveredAt = bun.NullTime{Time: time.Now()}
+	} else {
+		delivery.LastError = attempt.Error
+		if delivery.Attempts >= svc.Config.WebhookMaxAttempts {
+			delivery.State = common.WebhookDeliveryStateDead
+			svc.Logger.Errorf("Webhook delivery dead-lettered delivery_id:%v attempts:%v error:%s", delivery.ID, delivery.Attempts, attempt.Error)
+		} else {
+			delivery.NextAttemptAt = time.Now().Add(svc.webhookBackoff(delivery.Attempts))
+		}
+	}
+
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(&attempt).Exec(ctx); err != nil {
+			return err
+		}
+		_, err := tx.NewUpdate().Model(delivery).WherePK().Exec(ctx)
+		return err
+	})
+	if err != nil {
+		svc.Logger.Errorf("Could not update webhook delivery delivery_id:%v %v", delivery.ID, err)
+	}
+}
+
+// webhookBackoff doubles the delay with every attempt, capped at the configured maximum
+func (svc *LndhubService) webhookBackoff(attempts int) time.Duration {
+	return exponentialBackoff(webhookBaseBackoff, time.Duration(svc.Config.WebhookMaxBackoff)*time.Second, attempts)
+}
+
+func exponentialBackoff(base, max time.Duration, attempts int) time.Duration {
+	backoff := base
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// validateWebhookUrl accepts empty URLs, which disable the webhook, and absolute http and https URLs
+// Hosts that are internal addresses are rejected upfront, hosts resolving to them are rejected when the webhook is delivered
+func validateWebhookUrl(rawUrl string) error {
+	if rawUrl == "" {
+		return nil
+	}
+	u, err := url.ParseRequestURI(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookUrl
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrWebhookAddressNotAllowed
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return ErrWebhookAddressNotAllowed
+	}
+	return nil
+}
+
+// webhookClient returns the client delivering to the URL, only WEBHOOK_URL of the operator may be an internal address
+func (svc *LndhubService) webhookClient(url string) *http.Client {
+	if svc.Config.WebhookUrl != "" && url == svc.Config.WebhookUrl {
+		return http.DefaultClient
+	}
+	return publicWebhookClient
+}
+
+// checkWebhookDial rejects connections of the public webhook client to loopback, private, link-local and other internal addresses
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrWebhookAddressNotAllowed, host)
+	}
+	return nil
+}
+
+// reservedNetworks are not covered by the net.IP checks: carrier-grade NAT, benchmarking and IETF protocol assignments
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("192.0.0.0/24"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// signWebhookPayload returns the hex encoded HMAC-SHA256 of the payload, receivers verify it with WEBHOOK_SECRET
+func signWebhookPayload(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhook(ctx context.Context, client *http.Client, delivery *models.WebhookDelivery, secret string) (statusCode int, errorMessage string) {
+	ctx, cancel := context.WithTimeout(ctx, webhookRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, signWebhookPayload(secret, delivery.Payload))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Sprintf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, ""
+}
+
+func (svc *LndhubService) DeadWebhookDeliveries(ctx context.Context) ([]models.WebhookDelivery, error) {
+	deliveries := []models.WebhookDelivery{}
+	err := svc.DB.NewSelect().Model(&deliveries).Relation("AttemptLog").
+		Where("state = ?", common.WebhookDeliveryStateDead).
+		OrderExpr("webhook_delivery.id DESC").Limit(100).Scan(ctx)
+	return deliveries, err
+}
+
+// ReplayWebhookDelivery puts a dead-lettered delivery back into the queue with a fresh attempt budget
+func (svc *LndhubService) ReplayWebhookDelivery(ctx context.Context, id int64) error {
+	return svc.updateDeadWebhookDelivery(ctx, id, func(delivery *models.WebhookDelivery) {
+		delivery.State = common.WebhookDeliveryStatePending
+		delivery.Attempts = 0
+		delivery.NextAttemptAt = time.Now()
+	})
+}
+
+func (svc *LndhubService) DiscardWebhookDelivery(ctx context.Context, id int64) error {
+	return svc.updateDeadWebhookDelivery(ctx, id, func(delivery *models.WebhookDelivery) {
+		delivery.State = common.WebhookDeliveryStateDiscarded
+	})
+}
+
+func (svc *LndhubService) updateDeadWebhookDelivery(ctx context.Context, id int64, update func(delivery *models.WebhookDelivery)) error {
+	var delivery models.WebhookDelivery
+	err := svc.DB.NewSelect().Model(&delivery).Where("id = ? AND state = ?", id, common.WebhookDeliveryStateDead).Limit(1).Scan(ctx)
+	if err != nil {
+		return err
+	}
+	update(&delivery)
+	_, err = svc.DB.NewUpdate().Model(&delivery).WherePK().Exec(ctx)
+	return err
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/db/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateWebhookUrl(t *testing.T) {
+	assert.NoError(t, validateWebhookUrl(""))
+	assert.NoError(t, validateWebhookUrl("https://shop.example.com/lndhub?order=42"))
+	assert.NoError(t, validateWebhookUrl("https://203.0.113.7/hook"))
+	assert.ErrorIs(t, validateWebhookUrl("ftp://example.com/hook"), ErrInvalidWebhookUrl)
+	assert.ErrorIs(t, validateWebhookUrl("example.com/hook"), ErrInvalidWebhookUrl)
+	assert.ErrorIs(t, validateWebhookUrl("https://"), ErrInvalidWebhookUrl)
+
+	// internal addresses of the hub's network
+	assert.ErrorIs(t, validateWebhookUrl("http://localhost:8080/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://api.localhost/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://127.0.0.1/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://[::1]:3000/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://10.0.0.5/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://172.16.3.4/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://192.168.1.1/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://169.254.169.254/latest/meta-data"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://[fd00::1]/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://0.0.0.0/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://100.100.100.200/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://198.18.0.1/hook"), ErrWebhookAddressNotAllowed)
+	assert.ErrorIs(t, validateWebhookUrl("http://192.0.0.170/hook"), ErrWebhookAddressNotAllowed)
+}
+
+func TestCheckWebhookDial(t *testing.T) {
+	assert.NoError(t, checkWebhookDial("tcp4", "203.0.113.7:443", nil))
+	assert.NoError(t, checkWebhookDial("tcp6", "[2001:db8::1]:443", nil))
+	// just outside the reserved networks
+	assert.NoError(t, checkWebhookDial("tcp4", "100.128.0.1:443", nil))
+	assert.NoError(t, checkWebhookDial("tcp4", "198.20.0.1:443", nil))
+	for _, address := range []string{"127.0.0.1:80", "[::1]:80", "10.1.2.3:80", "192.168.0.10:8080", "169.254.169.254:80", "[fe80::1]:80", "0.0.0.0:80",
+		"100.64.0.1:80", "100.127.255.254:80", "198.18.0.1:80", "198.19.255.254:80", "192.0.0.8:80", "[::ffff:100.64.0.1]:80"} {
+		assert.ErrorIs(t, checkWebhookDial("tcp", address, nil), ErrWebhookAddressNotAllowed, address)
+	}
+}
+
+func TestUserWebhooksAreNotDeliveredToInternalAddresses(t *testing.T) {
+	received := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer server.Close()
+	svc := &LndhubService{Config: &Config{WebhookUrl: server.URL + "/operator"}}
+	delivery := &models.WebhookDelivery{URL: server.URL + "/invoice", Payload: "{}"}
+
+	// the server listens on a loopback address
+	statusCode, errorMessage := postWebhook(context.Background(), svc.webhookClient(delivery.URL), delivery, "")
+	assert.Equal(t, 0, statusCode)
+	assert.Contains(t, errorMessage, ErrWebhookAddressNotAllowed.Error())
+	assert.False(t, received)
+
+	// the operator's WEBHOOK_URL may be an internal address
+	delivery.URL = svc.Config.WebhookUrl
+	statusCode, errorMessage = postWebhook(context.Background(), svc.webhookClient(delivery.URL), delivery, "")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Empty(t, errorMessage)
+	assert.True(t, received)
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// echo -n '{"event":"invoice.settled"}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "sha256=9cbf2cf692655253fb089d5c8787d7604b8c0d17a1d600a0c9a0dbfb7e87ea2e", signWebhookPayload("secret", `{"event":"invoice.settled"}`))
+	assert.NotEqual(t, signWebhookPayload("secret", "payload"), signWebhookPayload("other", "payload"))
+}
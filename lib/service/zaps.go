@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/nostr"
+	"github.com/getsentry/sentry-go"
+)
+
+// zap receipts are published to at most this many relays of the zap request
+const zapMaxRelays = 10
+
+var ErrZapsDisabled = errors.New("zaps are not configured")
+var ErrInvalidZapRequest = errors.New("invalid zap request")
+
+// ParseZapRequest validates a NIP-57 zap request for the amount
+// The request must be signed, name exactly one recipient and the relays the receipt is published to
+func ParseZapRequest(zapRequest string, amount int64) (*nostr.Event, error) {
+	event := nostr.Event{}
+	if err := json.Unmarshal([]byte(zapRequest), &event); err != nil {
+		return nil, ErrInvalidZapRequest
+	}
+	if event.Kind != nostr.KindZapRequest || event.Verify() != nil {
+		return nil, ErrInvalidZapRequest
+	}
+	recipients := 0
+	for _, tag := range event.Tags {
+		if len(tag) > 1 && tag[0] == "p" {
+			recipients++
+		}
+	}
+	if recipients != 1 || event.FirstTag("relays") == nil {
+		return nil, ErrInvalidZapRequest
+	}
+	if amountTag := event.FirstTag("amount"); amountTag != nil && amountTag[1] != strconv.FormatInt(amount*1000, 10) {
+		return nil, ErrInvalidZapRequest
+	}
+	return &event, nil
+}
+
+// AddZapInvoice creates an invoice committing to the zap request, a zap receipt is published when it settles
+func (svc *LndhubService) AddZapInvoice(ctx context.Context, userID int64, amount int64, zapRequest string) (*models.Invoice, error) {
+	if svc.Config.NostrPrivateKey == "" {
+		return nil, ErrZapsDisabled
+	}
+	if _, err := ParseZapRequest(zapRequest, amount); err != nil {
+		return nil, err
+	}
+	descriptionHash := sha256.Sum256([]byte(zapRequest))
+	invoice := models.Invoice{
+		UserID:          userID,
+		Amount:          amount,
+		DescriptionHash: hex.EncodeToString(descriptionHash[:]),
+		ZapRequest:      zapRequest,
+	}
+	expiry, err := svc.InvoiceExpiry(0)
+	if err != nil {
+		return nil, err
+	}
+	return svc.addIncomingInvoice(ctx, &invoice, expiry)
+}
+
+// ZapReceipt creates the NIP-57 zap receipt of a settled zap invoice signed with NOSTR_PRIVATE_KEY
+func (svc *LndhubService) ZapReceipt(invoice *models.Invoice) (*nostr.Event, *nostr.Event, error) {
+	privateKey, err := hex.DecodeString(svc.Config.NostrPrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	zapRequest, err := ParseZapRequest(invoice.ZapRequest, invoice.Amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	receipt := nostr.Event{
+		CreatedAt: invoice.SettledAt.Time.Unix(),
+		Kind:      nostr.KindZapReceipt,
+		Tags: []nostr.Tag{
+			zapRequest.FirstTag("p"),
+			{"P", zapRequest.PubKey},
+			{"bolt11", invoice.PaymentRequest},
+			{"description", invoice.ZapRequest},
+			{"preimage", invoice.Preimage},
+		},
+	}
+	for _, name := range []string{"e", "a"} {
+		if tag := zapRequest.FirstTag(name); tag != nil {
+			receipt.Tags = append(receipt.Tags, tag)
+		}
+	}
+	if err := receipt.Sign(privateKey); err != nil {
+		return nil, nil, err
+	}
+	return &receipt, zapRequest, nil
+}
+
+// PublishZapReceipt publishes the zap receipt of the invoice to the relays of the zap request
+// Publishing is best effort, failures of single relays are only logged
+func (svc *LndhubService) PublishZapReceipt(ctx context.Context, invoice *models.Invoice) error {
+	if err := svc.ResolveInvoiceBlobs(ctx, invoice); err != nil {
+		return err
+	}
+	receipt, zapRequest, err := svc.ZapReceipt(invoice)
+	if err != nil {
+		return err
+	}
+	relays := zapRequest.FirstTag("relays")[1:]
+	if len(relays) > zapMaxRelays {
+		relays = relays[:zapMaxRelays]
+	}
+	published := 0
+	for _, relay := range relays {
+		if !strings.HasPrefix(relay, "wss://") && !strings.HasPrefix(relay, "ws://") {
+			continue
+		}
+		if err := nostr.Publish(ctx, relay, receipt); err != nil {
+			svc.Logger.Infof("Could not publish zap receipt invoice_id:%v relay:%s %v", invoice.ID, relay, err)
+			continue
+		}
+		published++
+	}
+	svc.Logger.Infof("Published zap receipt invoice_id:%v event_id:%s relays:%v/%v", invoice.ID, receipt.ID, published, len(relays))
+	if published == 0 {
+		return errors.New("zap receipt was not accepted by any relay")
+	}
+	return nil
+}
+
+// publishZapReceipt is called for every settled invoice
+// Relays are contacted in the background to not hold up the settlement, errors are only reported
+func (svc *LndhubService) publishZapReceipt(invoice *models.Invoice) {
+	if invoice.ZapRequest == "" || svc.Config.NostrPrivateKey == "" {
+		return
+	}
+	go func(invoice models.Invoice) {
+		if err := svc.PublishZapReceipt(context.Background(), &invoice); err != nil {
+			svc.Logger.Errorf("Could not publish zap receipt invoice_id:%v %v", invoice.ID, err)
+			sentry.CaptureException(err)
+		}
+	}(*invoice)
+}
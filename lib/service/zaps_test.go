@@ -0,0 +1,46 @@
+package service
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/lib/nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func signedZapRequest(t *testing.T, tags []nostr.Tag) string {
+	privateKey, _ := hex.DecodeString("b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef")
+	event := nostr.Event{Kind: nostr.KindZapRequest, CreatedAt: 1650000000, Tags: tags}
+	assert.NoError(t, event.Sign(privateKey))
+	serialized, err := event.Serialize()
+	assert.NoError(t, err)
+	return string(serialized)
+}
+
+func TestParseZapRequest(t *testing.T) {
+	recipient := nostr.Tag{"p", "dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659"}
+	relays := nostr.Tag{"relays", "wss://relay.example.com"}
+
+	zapRequest := signedZapRequest(t, []nostr.Tag{recipient, relays, {"amount", "21000"}})
+	event, err := ParseZapRequest(zapRequest, 21)
+	assert.NoError(t, err)
+	assert.Equal(t, recipient, event.FirstTag("p"))
+
+	_, err = ParseZapRequest(zapRequest, 22)
+	assert.ErrorIs(t, err, ErrInvalidZapRequest)
+
+	_, err = ParseZapRequest(signedZapRequest(t, []nostr.Tag{relays}), 21)
+	assert.ErrorIs(t, err, ErrInvalidZapRequest)
+
+	_, err = ParseZapRequest(signedZapRequest(t, []nostr.Tag{recipient}), 21)
+	assert.ErrorIs(t, err, ErrInvalidZapRequest)
+
+	tampered := signedZapRequest(t, []nostr.Tag{recipient, relays})
+	tampered = strings.Replace(tampered, `"content":""`, `"content":"tampered"`, 1)
+	_, err = ParseZapRequest(tampered, 21)
+	assert.ErrorIs(t, err, ErrInvalidZapRequest)
+
+	_, err = ParseZapRequest("not json", 21)
+	assert.ErrorIs(t, err, ErrInvalidZapRequest)
+}
@@ -0,0 +1,15 @@
+package tokens
+
+import (
+	"crypto/subtle"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// AdminMiddleware : Authenticate operator requests with the static admin token
+func AdminMiddleware(adminToken string) echo.MiddlewareFunc {
+	return middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+		return subtle.ConstantTimeCompare([]byte(key), []byte(adminToken)) == 1, nil
+	})
+}
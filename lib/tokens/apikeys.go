@@ -0,0 +1,85 @@
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/labstack/echo/v4"
+)
+
+// APIKeyPrefix starts every api key, it tells api keys and JWTs in the Authorization header apart
+const APIKeyPrefix = "lndhub_"
+
+// the hint shown for a key is its last characters
+const apiKeyHintLength = 4
+
+const (
+	ScopeInvoiceCreate = "invoice:create"
+	ScopePaymentSend   = "payment:send"
+	ScopeAccountRead   = "account:read"
+)
+
+// Scopes are the scopes an api key can be given
+var Scopes = []string{ScopeInvoiceCreate, ScopePaymentSend, ScopeAccountRead}
+
+// RouteScopes maps the method and path of a route, e.g. "POST /addinvoice", to the scope that grants access to it
+// Scoped credentials, api keys and read-only tokens, can only use the routes listed here
+type RouteScopes map[string]string
+
+// APIKeyCredentials are the user, the scopes and the spend limit of an api key
+type APIKeyCredentials struct {
+	UserID     int64
+	Scopes     []string
+	SpendLimit *SpendLimit
+}
+
+// APIKeyAuthenticator returns the credentials of an api key
+type APIKeyAuthenticator func(ctx context.Context, key string) (*APIKeyCredentials, error)
+
+// GenerateAPIKey returns a new random api key, its hash and its hint
+func GenerateAPIKey() (key, hash, hint string, err error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + hex.EncodeToString(random)
+	return key, HashAPIKey(key), key[len(key)-apiKeyHintLength:], nil
+}
+
+// HashAPIKey returns the hash an api key is stored and looked up with
+// Keys are random, a fast hash is enough to keep a leaked database from revealing them
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// ValidScope returns whether an api key can be given the scope
+func ValidScope(scope string) bool {
+	return HasScope(Scopes, scope)
+}
+
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// checkScope lets credentials without scopes use every route and scoped credentials only the routes of their scopes
+func checkScope(c echo.Context, routeScopes RouteScopes) error {
+	scopes, ok := c.Get("Scopes").([]string)
+	if !ok {
+		return nil
+	}
+	scope, scoped := routeScopes[c.Request().Method+" "+c.Path()]
+	if scoped && HasScope(scopes, scope) {
+		return nil
+	}
+	return echo.NewHTTPError(http.StatusForbidden, responses.ScopeNotGrantedError)
+}
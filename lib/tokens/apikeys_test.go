@@ -0,0 +1,69 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, hash, hint, err := GenerateAPIKey()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, APIKeyPrefix))
+	assert.Equal(t, HashAPIKey(key), hash)
+	assert.True(t, strings.HasSuffix(key, hint))
+	other, _, _, err := GenerateAPIKey()
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, other)
+}
+
+func TestMiddlewareAPIKeyScopes(t *testing.T) {
+	secret := []byte("secret")
+	validKey, _, _, _ := GenerateAPIKey()
+	apiKeys := func(ctx context.Context, key string) (*APIKeyCredentials, error) {
+		if key != validKey {
+			return nil, errors.New("unknown key")
+		}
+		return &APIKeyCredentials{UserID: 7, Scopes: []string{ScopeAccountRead}}, nil
+	}
+	e := echo.New()
+	secured := e.Group("", Middleware(MiddlewareOptions{
+		Secret:      secret,
+		APIKeys:     apiKeys,
+		RouteScopes: RouteScopes{"GET /balance": ScopeAccountRead, "POST /payinvoice": ScopePaymentSend},
+	}))
+	handler := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, c.Get("UserID"))
+	}
+	secured.GET("/balance", handler)
+	secured.POST("/payinvoice", handler)
+	secured.GET("/contacts", handler)
+
+	request := func(method, path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+authorization)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "/balance", validKey)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "7\n", rec.Body.String())
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/payinvoice", validKey).Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/contacts", validKey).Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodGet, "/balance", APIKeyPrefix+"unknown").Code)
+
+	// access tokens are not limited to scoped routes
+	token, err := GenerateAccessToken(secret, 60, &models.User{ID: 3}, AccessTokenOptions{SessionID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/contacts", token).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/payinvoice", token).Code)
+}
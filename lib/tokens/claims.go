@@ -0,0 +1,97 @@
+package tokens
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const balanceClaimAudience = "balance_claim"
+
+// BalanceClaim is the signed payload handed to another hub to move a user's balance
+type BalanceClaim struct {
+	ClaimID int64 `json:"claim_id"`
+	Amount  int64 `json:"amount"`
+	jwt.StandardClaims
+}
+
+// GenerateBalanceClaim : Sign a balance claim
+func GenerateBalanceClaim(secret []byte, claimID, amount int64, expiresAt time.Time) (string, error) {
+	claims := &BalanceClaim{
+		ClaimID: claimID,
+		Amount:  amount,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  balanceClaimAudience,
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// ParseBalanceClaim : Verify a balance claim signed by this hub
+func ParseBalanceClaim(secret []byte, token string) (*BalanceClaim, error) {
+	claims := &BalanceClaim{}
+	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !parsedToken.Valid || !claims.VerifyAudience(balanceClaimAudience, true) {
+		return nil, errors.New("Token is invalid")
+	}
+	return claims, nil
+}
+
+// ParseBalanceClaimUnverified reads a claim issued by another hub
+// Only the issuing hub can verify the signature when the claim is redeemed
+func ParseBalanceClaimUnverified(token string) (*BalanceClaim, error) {
+	claims := &BalanceClaim{}
+	_, _, err := new(jwt.Parser).ParseUnverified(token, claims)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+const paymentConfirmationAudience = "payment_confirmation"
+
+// PaymentConfirmation is the signed payload a user sends along to confirm an unusual payment
+type PaymentConfirmation struct {
+	ConfirmationID int64 `json:"confirmation_id"`
+	jwt.StandardClaims
+}
+
+// GeneratePaymentConfirmation : Sign a payment confirmation
+func GeneratePaymentConfirmation(secret []byte, confirmationID int64, expiresAt time.Time) (string, error) {
+	claims := &PaymentConfirmation{
+		ConfirmationID: confirmationID,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  paymentConfirmationAudience,
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// ParsePaymentConfirmation : Verify a payment confirmation signed by this hub
+func ParsePaymentConfirmation(secret []byte, token string) (*PaymentConfirmation, error) {
+	claims := &PaymentConfirmation{}
+	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !parsedToken.Valid || !claims.VerifyAudience(paymentConfirmationAudience, true) {
+		return nil, errors.New("Token is invalid")
+	}
+	return claims, nil
+}
@@ -1,56 +1,210 @@
 package tokens
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
+// ReadOnlyScopes are the scopes of read-only tokens, they can read the balance and the transactions but not pay or create invoices
+var ReadOnlyScopes = []string{ScopeAccountRead}
+
 type jwtCustomClaims struct {
-	ID        int64 `json:"id"`
-	IsRefresh bool  `json:"isRefresh"`
+	ID        int64    `json:"id"`
+	IsRefresh bool     `json:"isRefresh"`
+	SessionID int64    `json:"sid,omitempty"`    // session of the device the tokens were issued to
+	Scopes    []string `json:"scopes,omitempty"` // limits the token to the routes of the scopes, tokens without scopes can use every route
+	// spend limit of the payments made with the tokens of the session
+	MaxAmountPerPayment int64 `json:"max_amount_per_payment,omitempty"`
+	MaxAmountPerDay     int64 `json:"max_amount_per_day,omitempty"`
 	jwt.StandardClaims
 }
 
-func Middleware(secret []byte) echo.MiddlewareFunc {
+// RefreshToken is the content of a verified refresh token
+// The token id changes with every refresh, only the latest token of a session can be used
+type RefreshToken struct {
+	UserID     int64
+	SessionID  int64
+	TokenID    string
+	Scopes     []string
+	SpendLimit *SpendLimit
+}
+
+// AccessToken is the content of a verified access token, the middleware stores it as "AccessToken" in the context
+type AccessToken struct {
+	UserID    int64
+	SessionID int64
+	TokenID   string // empty for tokens issued before tokens could be revoked
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+// RevokedTokenChecker returns whether the access token was revoked by a logout or the deletion of its user
+type RevokedTokenChecker func(ctx context.Context, token *AccessToken) (bool, error)
+
+// MiddlewareOptions configures how the middleware authenticates requests, only the secret is required
+type MiddlewareOptions struct {
+	Secret        []byte
+	APIKeys       APIKeyAuthenticator // api keys are rejected if nil
+	RouteScopes   RouteScopes
+	RevokedTokens RevokedTokenChecker // access tokens are not checked for revocation if nil
+}
+
+// Middleware authenticates requests with a JWT access token or an api key in the Authorization header
+// Api keys and access tokens with scopes are limited to the routes of their scopes, revoked access tokens are rejected
+func Middleware(options MiddlewareOptions) echo.MiddlewareFunc {
 	config := middleware.DefaultJWTConfig
 
 	config.Claims = &jwtCustomClaims{}
 	config.ContextKey = "UserJwt"
-	config.SigningKey = secret
+	config.SigningKey = options.Secret
 	config.ErrorHandlerWithContext = func(err error, c echo.Context) error {
 		c.Logger().Error(err)
-		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
-			"error":   true,
-			"code":    1,
-			"message": "bad auth",
-		})
+		return badAuthError()
 	}
 	config.SuccessHandler = func(c echo.Context) {
 		token := c.Get("UserJwt").(*jwt.Token)
 		claims := token.Claims.(*jwtCustomClaims)
 		c.Set("UserID", claims.ID)
+		c.Set("AccessToken", &AccessToken{
+			UserID:    claims.ID,
+			SessionID: claims.SessionID,
+			TokenID:   claims.Id,
+			Scopes:    claims.Scopes,
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		})
+		if len(claims.Scopes) > 0 {
+			c.Set("Scopes", claims.Scopes)
+		}
+		setSpendLimit(c, &SpendLimit{
+			Credential:          SessionCredential(claims.SessionID),
+			MaxAmountPerPayment: claims.MaxAmountPerPayment,
+			MaxAmountPerDay:     claims.MaxAmountPerDay,
+		})
 	}
+	jwtMiddleware := middleware.JWTWithConfig(config)
 
-	return middleware.JWTWithConfig(config)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		scopedNext := func(c echo.Context) error {
+			if err := checkScope(c, options.RouteScopes); err != nil {
+				return err
+			}
+			return next(c)
+		}
+		jwtNext := jwtMiddleware(func(c echo.Context) error {
+			if err := checkNotRefreshToken(c); err != nil {
+				return err
+			}
+			if err := checkRevoked(c, options.RevokedTokens); err != nil {
+				return err
+			}
+			return scopedNext(c)
+		})
+		return func(c echo.Context) error {
+			key := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if options.APIKeys == nil || !strings.HasPrefix(key, APIKeyPrefix) {
+				return jwtNext(c)
+			}
+			credentials, err := options.APIKeys(c.Request().Context(), key)
+			if err != nil {
+				c.Logger().Errorf("Invalid api key: %v", err)
+				return badAuthError()
+			}
+			c.Set("UserID", credentials.UserID)
+			c.Set("Scopes", credentials.Scopes)
+			setSpendLimit(c, credentials.SpendLimit)
+			return scopedNext(c)
+		}
+	}
 }
 
-// GenerateAccessToken : Generate Access Token
-func GenerateAccessToken(secret []byte, expiryInSeconds int, u *models.User) (string, error) {
+// checkNotRefreshToken rejects refresh tokens, they can only be exchanged for new tokens at /auth
+// They do not carry the scopes and the spend limit of their session's access tokens
+func checkNotRefreshToken(c echo.Context) error {
+	token, ok := c.Get("UserJwt").(*jwt.Token)
+	if !ok {
+		return nil
+	}
+	if claims, ok := token.Claims.(*jwtCustomClaims); ok && claims.IsRefresh {
+		c.Logger().Errorf("Refresh token used as access token user_id:%v", claims.ID)
+		return echo.NewHTTPError(http.StatusUnauthorized, responses.BadAuthError)
+	}
+	return nil
+}
+
+// checkRevoked rejects access tokens that were revoked before they expired
+func checkRevoked(c echo.Context, revokedTokens RevokedTokenChecker) error {
+	token, ok := c.Get("AccessToken").(*AccessToken)
+	if !ok || revokedTokens == nil {
+		return nil
+	}
+	revoked, err := revokedTokens(c.Request().Context(), token)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		c.Logger().Errorf("Revoked access token used user_id:%v", token.UserID)
+		return badAuthError()
+	}
+	return nil
+}
+
+// setSpendLimit passes the spend limit of the credentials to the payment service with the context of the request
+func setSpendLimit(c echo.Context, spendLimit *SpendLimit) {
+	if spendLimit.limited() {
+		c.SetRequest(c.Request().WithContext(WithSpendLimit(c.Request().Context(), spendLimit)))
+	}
+}
+
+func badAuthError() error {
+	return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+		"error":   true,
+		"code":    1,
+		"message": "bad auth",
+	})
+}
+
+// AccessTokenOptions are the session an access token belongs to and the limits of the session
+type AccessTokenOptions struct {
+	SessionID  int64
+	Scopes     []string // the token can use every route if empty
+	SpendLimit *SpendLimit
+}
+
+// GenerateAccessToken : Generate Access Token, every token gets an id it can be revoked with
+// A token with scopes can only use the routes of its scopes, the payments made with it are limited by the spend limit
+func GenerateAccessToken(secret []byte, expiryInSeconds int, u *models.User, options AccessTokenOptions) (string, error) {
+	tokenID, err := NewTokenID()
+	if err != nil {
+		return "", err
+	}
 	claims := &jwtCustomClaims{
 		ID:        u.ID,
 		IsRefresh: false,
+		SessionID: options.SessionID,
+		Scopes:    options.Scopes,
 		StandardClaims: jwt.StandardClaims{
+			Id: tokenID,
 			// one week expiration
 			ExpiresAt: time.Now().Add(time.Second * time.Duration(expiryInSeconds)).Unix(),
 		},
 	}
 
+	if options.SpendLimit != nil {
+		claims.MaxAmountPerPayment = options.SpendLimit.MaxAmountPerPayment
+		claims.MaxAmountPerDay = options.SpendLimit.MaxAmountPerDay
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	t, err := token.SignedString(secret)
@@ -61,17 +215,26 @@ func GenerateAccessToken(secret []byte, expiryInSeconds int, u *models.User) (st
 	return t, nil
 }
 
-// GenerateRefreshToken : Generate Refresh Token
-func GenerateRefreshToken(secret []byte, expiryInSeconds int, u *models.User) (string, error) {
+// GenerateRefreshToken : Generate a one-time refresh token of the session
+// It carries the scopes and the spend limit of the session, the access tokens it is exchanged for keep them
+func GenerateRefreshToken(secret []byte, expiryInSeconds int, u *models.User, tokenID string, options AccessTokenOptions) (string, error) {
 	claims := &jwtCustomClaims{
 		ID:        u.ID,
 		IsRefresh: true,
+		SessionID: options.SessionID,
+		Scopes:    options.Scopes,
 		StandardClaims: jwt.StandardClaims{
+			Id: tokenID,
 			// one week expiration
 			ExpiresAt: time.Now().Add(time.Second * time.Duration(expiryInSeconds)).Unix(),
 		},
 	}
 
+	if options.SpendLimit != nil {
+		claims.MaxAmountPerPayment = options.SpendLimit.MaxAmountPerPayment
+		claims.MaxAmountPerDay = options.SpendLimit.MaxAmountPerDay
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	t, err := token.SignedString(secret)
@@ -81,38 +244,50 @@ func GenerateRefreshToken(secret []byte, expiryInSeconds int, u *models.User) (s
 
 	return t, nil
 }
-func ParseToken(secret []byte, token string) (int64, error) {
-	userIdClaim := "id"
-	claims := jwt.MapClaims{}
+
+// NewTokenID returns a random id for an access or refresh token
+func NewTokenID() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
+
+// ParseRefreshToken : Verify a refresh token, tokens issued without a session are rejected
+func ParseRefreshToken(secret []byte, token string) (*RefreshToken, error) {
+	claims := &jwtCustomClaims{}
 	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
 		return secret, nil
 	})
-
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
-
 	if !parsedToken.Valid {
-		return -1, errors.New("Token is invalid")
+		return nil, errors.New("Token is invalid")
 	}
-
-	var userId interface{}
-	for k, v := range claims {
-		if k == userIdClaim {
-			userId = v.(float64)
-		}
+	if !claims.IsRefresh {
+		return nil, errors.New("This is not a refresh token")
 	}
-
-	if userId == nil {
-		return -1, errors.New("User id claim not found")
+	if claims.SessionID == 0 || claims.Id == "" {
+		return nil, errors.New("Refresh token without session")
 	}
-
-	return int64(userId.(float64)), nil
+	refreshToken := &RefreshToken{UserID: claims.ID, SessionID: claims.SessionID, TokenID: claims.Id, Scopes: claims.Scopes}
+	if claims.MaxAmountPerPayment > 0 || claims.MaxAmountPerDay > 0 {
+		refreshToken.SpendLimit = &SpendLimit{
+			Credential:          SessionCredential(claims.SessionID),
+			MaxAmountPerPayment: claims.MaxAmountPerPayment,
+			MaxAmountPerDay:     claims.MaxAmountPerDay,
+		}
+	}
+	return refreshToken, nil
 }
 
-func GetUserIdFromToken(secret []byte, token string) (int64, error) {
+func ParseToken(secret []byte, token string) (int64, error) {
 	userIdClaim := "id"
-	isRefreshClaim := "isRefresh"
 	claims := jwt.MapClaims{}
 	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
 		return secret, nil
@@ -128,9 +303,6 @@ func GetUserIdFromToken(secret []byte, token string) (int64, error) {
 
 	var userId interface{}
 	for k, v := range claims {
-		if k == isRefreshClaim && v.(bool) == false {
-			return -1, errors.New("This is not a refresh token")
-		}
 		if k == userIdClaim {
 			userId = v.(float64)
 		}
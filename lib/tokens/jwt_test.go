@@ -0,0 +1,147 @@
+package tokens
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRefreshToken(t *testing.T) {
+	secret := []byte("secret")
+	user := &models.User{ID: 7}
+
+	token, err := GenerateRefreshToken(secret, 3600, user, "token-id", AccessTokenOptions{SessionID: 3})
+	assert.NoError(t, err)
+	refreshToken, err := ParseRefreshToken(secret, token)
+	assert.NoError(t, err)
+	assert.Equal(t, &RefreshToken{UserID: 7, SessionID: 3, TokenID: "token-id"}, refreshToken)
+
+	// the scopes and the spend limit of the session are kept in its refresh token
+	token, err = GenerateRefreshToken(secret, 3600, user, "token-id", AccessTokenOptions{SessionID: 3, Scopes: Scopes, SpendLimit: &SpendLimit{MaxAmountPerPayment: 1000, MaxAmountPerDay: 5000}})
+	assert.NoError(t, err)
+	refreshToken, err = ParseRefreshToken(secret, token)
+	assert.NoError(t, err)
+	assert.Equal(t, Scopes, refreshToken.Scopes)
+	assert.Equal(t, &SpendLimit{Credential: SessionCredential(3), MaxAmountPerPayment: 1000, MaxAmountPerDay: 5000}, refreshToken.SpendLimit)
+
+	_, err = ParseRefreshToken([]byte("other secret"), token)
+	assert.Error(t, err)
+
+	accessToken, err := GenerateAccessToken(secret, 3600, user, AccessTokenOptions{SessionID: 3})
+	assert.NoError(t, err)
+	_, err = ParseRefreshToken(secret, accessToken)
+	assert.Error(t, err)
+
+	// refresh tokens issued before sessions existed can not be rotated
+	legacyToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwtCustomClaims{ID: 7, IsRefresh: true}).SignedString(secret)
+	assert.NoError(t, err)
+	_, err = ParseRefreshToken(secret, legacyToken)
+	assert.Error(t, err)
+}
+
+func TestMiddlewareRevokedTokens(t *testing.T) {
+	secret := []byte("secret")
+	revoked := map[string]bool{}
+	e := echo.New()
+	secured := e.Group("", Middleware(MiddlewareOptions{Secret: secret, RevokedTokens: func(ctx context.Context, token *AccessToken) (bool, error) {
+		return revoked[token.TokenID], nil
+	}}))
+	secured.POST("/auth/logout", func(c echo.Context) error {
+		accessToken := c.Get("AccessToken").(*AccessToken)
+		assert.Equal(t, int64(3), accessToken.UserID)
+		assert.Equal(t, int64(5), accessToken.SessionID)
+		revoked[accessToken.TokenID] = true
+		return c.NoContent(http.StatusNoContent)
+	})
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	token, err := GenerateAccessToken(secret, 60, &models.User{ID: 3}, AccessTokenOptions{SessionID: 5})
+	assert.NoError(t, err)
+	otherToken, err := GenerateAccessToken(secret, 60, &models.User{ID: 3}, AccessTokenOptions{SessionID: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, request(token))
+	assert.Equal(t, http.StatusBadRequest, request(token))
+	// other tokens of the user are not revoked
+	assert.Equal(t, http.StatusNoContent, request(otherToken))
+}
+
+func TestMiddlewareReadOnlyTokens(t *testing.T) {
+	secret := []byte("secret")
+	e := echo.New()
+	secured := e.Group("", Middleware(MiddlewareOptions{Secret: secret, RouteScopes: RouteScopes{"GET /balance": ScopeAccountRead, "GET /gettxs": ScopeAccountRead}}))
+	handler := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+	secured.GET("/balance", handler)
+	secured.GET("/gettxs", handler)
+	secured.POST("/payinvoice", handler)
+	secured.POST("/addinvoice", handler)
+	request := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	token, err := GenerateAccessToken(secret, 60, &models.User{ID: 3}, AccessTokenOptions{SessionID: 5, Scopes: ReadOnlyScopes})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/balance", token))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/gettxs", token))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/payinvoice", token))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/addinvoice", token))
+
+	// the refresh token of a read-only session is not accepted as an access token
+	refreshToken, err := GenerateRefreshToken(secret, 60, &models.User{ID: 3}, "token-id", AccessTokenOptions{SessionID: 5, Scopes: ReadOnlyScopes})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/payinvoice", refreshToken))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/balance", refreshToken))
+}
+
+func TestMiddlewareSpendLimit(t *testing.T) {
+	secret := []byte("secret")
+	e := echo.New()
+	secured := e.Group("", Middleware(MiddlewareOptions{Secret: secret, RouteScopes: RouteScopes{"POST /payinvoice": ScopePaymentSend}}))
+	var spendLimit *SpendLimit
+	secured.POST("/payinvoice", func(c echo.Context) error {
+		spendLimit = SpendLimitFromContext(c.Request().Context())
+		return c.NoContent(http.StatusOK)
+	})
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/payinvoice", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	token, err := GenerateAccessToken(secret, 60, &models.User{ID: 3}, AccessTokenOptions{SessionID: 5, Scopes: Scopes, SpendLimit: &SpendLimit{MaxAmountPerPayment: 1000, MaxAmountPerDay: 5000}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request(token))
+	assert.Equal(t, &SpendLimit{Credential: SessionCredential(5), MaxAmountPerPayment: 1000, MaxAmountPerDay: 5000}, spendLimit)
+
+	// tokens without a limit do not pass one to the payment service
+	token, err = GenerateAccessToken(secret, 60, &models.User{ID: 3}, AccessTokenOptions{SessionID: 6, SpendLimit: &SpendLimit{}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request(token))
+	assert.Nil(t, spendLimit)
+
+	// the refresh token of a spend limited session can not pay past the limit as an access token
+	spendLimit = nil
+	refreshToken, err := GenerateRefreshToken(secret, 60, &models.User{ID: 3}, "token-id", AccessTokenOptions{SessionID: 5, Scopes: Scopes, SpendLimit: &SpendLimit{MaxAmountPerPayment: 1000, MaxAmountPerDay: 5000}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, request(refreshToken))
+	assert.Nil(t, spendLimit)
+}
@@ -0,0 +1,41 @@
+package tokens
+
+import (
+	"context"
+	"fmt"
+)
+
+// SpendLimit limits the payments made with an api key or a token, a limit of zero is not enforced
+// Credential identifies the api key or the session the payments of the day are counted for
+type SpendLimit struct {
+	Credential          string
+	MaxAmountPerPayment int64
+	MaxAmountPerDay     int64
+}
+
+type spendLimitKey struct{}
+
+// APIKeyCredential is the credential the payments of an api key are counted for
+func APIKeyCredential(apiKeyId int64) string {
+	return fmt.Sprintf("api_key:%d", apiKeyId)
+}
+
+// SessionCredential is the credential the payments of the tokens of a session are counted for
+func SessionCredential(sessionId int64) string {
+	return fmt.Sprintf("session:%d", sessionId)
+}
+
+// WithSpendLimit returns a context the payment service enforces the spend limit in
+func WithSpendLimit(ctx context.Context, spendLimit *SpendLimit) context.Context {
+	return context.WithValue(ctx, spendLimitKey{}, spendLimit)
+}
+
+// SpendLimitFromContext returns the spend limit of the credentials of the request, nil if they have none
+func SpendLimitFromContext(ctx context.Context) *SpendLimit {
+	spendLimit, _ := ctx.Value(spendLimitKey{}).(*SpendLimit)
+	return spendLimit
+}
+
+func (limit *SpendLimit) limited() bool {
+	return limit != nil && (limit.MaxAmountPerPayment > 0 || limit.MaxAmountPerDay > 0)
+}
@@ -0,0 +1,30 @@
+package lnd
+
+import "github.com/getAlby/lndhub.go/lib"
+
+// SatToMsat converts satoshis to millisatoshis
+func SatToMsat(sat int64) (int64, error) {
+	return lib.MultiplyAmount(sat, MSAT_PER_SAT)
+}
+
+// MsatToSat converts millisatoshis to satoshis, rounding down to a full satoshi
+// Negative amounts are never valid payment amounts
+func MsatToSat(msat int64) (int64, error) {
+	if msat < 0 {
+		return 0, lib.ErrAmountOverflow
+	}
+	return msat / MSAT_PER_SAT, nil
+}
+
+// MsatToSatRoundUp converts millisatoshis to satoshis, rounding up to a full satoshi
+// Amounts debited from users are rounded up, so the hub never pays out more than it booked
+func MsatToSatRoundUp(msat int64) (int64, error) {
+	if msat < 0 {
+		return 0, lib.ErrAmountOverflow
+	}
+	sat := msat / MSAT_PER_SAT
+	if msat%MSAT_PER_SAT != 0 {
+		sat++
+	}
+	return sat, nil
+}
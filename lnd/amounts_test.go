@@ -0,0 +1,39 @@
+package lnd
+
+import (
+	"math"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsatConversions(t *testing.T) {
+	msat, err := SatToMsat(21)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(21000), msat)
+
+	_, err = SatToMsat(math.MaxInt64 / 100)
+	assert.ErrorIs(t, err, lib.ErrAmountOverflow)
+
+	sat, err := MsatToSat(21999)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(21), sat)
+
+	_, err = MsatToSat(-1000)
+	assert.ErrorIs(t, err, lib.ErrAmountOverflow)
+}
+
+func TestMsatToSatRoundUp(t *testing.T) {
+	sat, err := MsatToSatRoundUp(1500)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), sat)
+	sat, err = MsatToSatRoundUp(2000)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), sat)
+	sat, err = MsatToSatRoundUp(0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), sat)
+	_, err = MsatToSatRoundUp(-1)
+	assert.ErrorIs(t, err, lib.ErrAmountOverflow)
+}
@@ -3,6 +3,7 @@ package lnd
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"reflect"
 	"time"
@@ -10,6 +11,8 @@ import (
 	cln "github.com/fiatjaf/lightningd-gjson-rpc"
 	"github.com/gofrs/uuid"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/tidwall/gjson"
 	"google.golang.org/grpc"
 )
@@ -18,6 +21,9 @@ const (
 	MSAT_PER_SAT = 1000
 )
 
+var ErrChannelEventsNotSupported = errors.New("channel event subscriptions are not supported by c-lightning")
+var ErrHoldInvoicesNotSupported = errors.New("hold invoices are not supported by c-lightning")
+
 type CLNClient struct {
 	client  *cln.Client
 	handler *InvoiceHandler
@@ -135,9 +141,24 @@ func (cl *CLNClient) ListChannels(ctx context.Context, req *lnrpc.ListChannelsRe
 	}, nil
 }
 
+// error codes of the pay command when no route was found or all routes were too expensive
+const (
+	clnPayRouteNotFound     = 205
+	clnPayRouteTooExpensive = 206
+)
+
 func (cl *CLNClient) SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest, options ...grpc.CallOption) (*lnrpc.SendResponse, error) {
 	//todo add other options
-	result, err := cl.client.Call("pay", req.PaymentRequest)
+	params := []interface{}{"bolt11", req.PaymentRequest}
+	if fixed := req.GetFeeLimit().GetFixed(); fixed > 0 {
+		// fees up to exemptfee are always accepted, without a percentage it is the fee limit
+		params = append(params, "maxfeepercent", 0, "exemptfee", fixed*MSAT_PER_SAT)
+	}
+	result, err := cl.client.CallNamed("pay", params...)
+	var commandErr cln.ErrorCommand
+	if errors.As(err, &commandErr) && (commandErr.Code == clnPayRouteNotFound || commandErr.Code == clnPayRouteTooExpensive) {
+		return nil, fmt.Errorf("%w: %s", ErrNoRoute, commandErr.Message)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -147,14 +168,19 @@ func (cl *CLNClient) SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest
 		PaymentPreimage: []byte(result.Get("payment_preimage").String()),
 		PaymentHash:     []byte(result.Get("payment_hash").String()),
 		PaymentRoute: &lnrpc.Route{
-			TotalFees: result.Get("msatoshi_sent").Int()/MSAT_PER_SAT - result.Get("msatoshi").Int()/MSAT_PER_SAT,
-			TotalAmt:  result.Get("msatoshi_sent").Int() / MSAT_PER_SAT,
+			TotalFees:     result.Get("msatoshi_sent").Int()/MSAT_PER_SAT - result.Get("msatoshi").Int()/MSAT_PER_SAT,
+			TotalFeesMsat: result.Get("msatoshi_sent").Int() - result.Get("msatoshi").Int(),
+			TotalAmt:      result.Get("msatoshi_sent").Int() / MSAT_PER_SAT,
 		},
 	}, nil
 }
 
 func (cl *CLNClient) FetchBolt12Invoice(ctx context.Context, offer, memo string, amount int64) (result *Bolt12, err error) {
-	res, err := cl.client.CallNamed("fetchinvoice", "offer", offer, "msatoshi", amount*MSAT_PER_SAT, "payer_note", memo)
+	mSatAmt, err := SatToMsat(amount)
+	if err != nil {
+		return nil, err
+	}
+	res, err := cl.client.CallNamed("fetchinvoice", "offer", offer, "msatoshi", mSatAmt, "payer_note", memo)
 	if err != nil {
 		return nil, err
 	}
@@ -166,7 +192,10 @@ func (cl *CLNClient) AddInvoice(ctx context.Context, req *lnrpc.Invoice, options
 	if err != nil {
 		return nil, err
 	}
-	mSatAmt := MSAT_PER_SAT * req.Value
+	mSatAmt, err := SatToMsat(req.Value)
+	if err != nil {
+		return nil, err
+	}
 	methodToCall := "invoice"
 	arg := req.Memo
 	if !reflect.DeepEqual(req.DescriptionHash, []byte("")) {
@@ -183,6 +212,18 @@ func (cl *CLNClient) AddInvoice(ctx context.Context, req *lnrpc.Invoice, options
 	}, nil
 }
 
+func (cl *CLNClient) AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error) {
+	return nil, ErrHoldInvoicesNotSupported
+}
+
+func (cl *CLNClient) SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error) {
+	return nil, ErrHoldInvoicesNotSupported
+}
+
+func (cl *CLNClient) CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error) {
+	return nil, ErrHoldInvoicesNotSupported
+}
+
 // Todo here: make CLNClient implement the interface (Recv())
 // This method will read from a channel or block
 // The handler function publishes on the channel on a received invoice
@@ -193,6 +234,48 @@ func (cl *CLNClient) SubscribeInvoices(ctx context.Context, req *lnrpc.InvoiceSu
 	return cl, nil
 }
 
+// ListInvoices returns all invoices of the node on the first page, c-lightning does not page invoices
+func (cl *CLNClient) ListInvoices(ctx context.Context, req *lnrpc.ListInvoiceRequest, options ...grpc.CallOption) (*lnrpc.ListInvoiceResponse, error) {
+	if req.IndexOffset > 0 {
+		return &lnrpc.ListInvoiceResponse{Invoices: []*lnrpc.Invoice{}, LastIndexOffset: req.IndexOffset}, nil
+	}
+	result, err := cl.client.Call("listinvoices")
+	if err != nil {
+		return nil, err
+	}
+	invoices := []*lnrpc.Invoice{}
+	var lastIndex uint64
+	for _, inv := range result.Get("invoices").Array() {
+		// the same mapping as the invoice handler, so reconciled invoices match the subscription updates
+		invoice := &lnrpc.Invoice{
+			Memo:           inv.Get("description").String(),
+			RHash:          []byte(inv.Get("payment_hash").String()),
+			Value:          inv.Get("amount_msat").Int() / MSAT_PER_SAT,
+			ValueMsat:      inv.Get("amount_msat").Int(),
+			PaymentRequest: inv.Get("bolt11").String(),
+			AddIndex:       inv.Get("pay_index").Uint(),
+			State:          lnrpc.Invoice_OPEN,
+		}
+		if inv.Get("status").String() == "paid" {
+			invoice.Settled = true
+			invoice.State = lnrpc.Invoice_SETTLED
+			invoice.SettleDate = inv.Get("paid_at").Int()
+			invoice.RPreimage = []byte(inv.Get("payment_preimage").String())
+			invoice.AmtPaidSat = inv.Get("msatoshi_received").Int() / MSAT_PER_SAT
+		}
+		if invoice.AddIndex > lastIndex {
+			lastIndex = invoice.AddIndex
+		}
+		invoices = append(invoices, invoice)
+	}
+	return &lnrpc.ListInvoiceResponse{Invoices: invoices, LastIndexOffset: lastIndex}, nil
+}
+
+// SubscribeChannelEvents is not available through spark, channel changes are picked up by polling ListChannels
+func (cl *CLNClient) SubscribeChannelEvents(ctx context.Context, req *lnrpc.ChannelEventSubscription, options ...grpc.CallOption) (SubscribeChannelEventsWrapper, error) {
+	return nil, ErrChannelEventsNotSupported
+}
+
 func (cl *CLNClient) GetInfo(ctx context.Context, req *lnrpc.GetInfoRequest, options ...grpc.CallOption) (*lnrpc.GetInfoResponse, error) {
 	result, err := cl.client.Call("getinfo")
 	if err != nil {
@@ -213,10 +296,11 @@ func (cl *CLNClient) GetInfo(ctx context.Context, req *lnrpc.GetInfoRequest, opt
 		NumInactiveChannels: uint32(result.Get("num_inactive_channels").Int()),
 		NumPeers:            uint32(result.Get("num_peers").Int()),
 		BlockHeight:         uint32(result.Get("blockheight").Int()),
-		// workaround
-		SyncedToChain: true,
-		SyncedToGraph: true,
+		// the sync warnings are only present while c-lightning is catching up
+		SyncedToChain: !result.Get("warning_bitcoind_sync").Exists() && !result.Get("warning_lightningd_sync").Exists(),
+		SyncedToGraph: !result.Get("warning_lightningd_sync").Exists(),
 		Testnet:       false,
+		Features:      parseFeatureBits(result.Get("our_features.node").String()),
 		Chains: []*lnrpc.Chain{
 			{
 				Chain:   "bitcoin",
@@ -227,6 +311,51 @@ func (cl *CLNClient) GetInfo(ctx context.Context, req *lnrpc.GetInfoRequest, opt
 	}, nil
 }
 
+// WalletBalance sums up the on-chain outputs of the c-lightning wallet
+func (cl *CLNClient) WalletBalance(ctx context.Context, req *lnrpc.WalletBalanceRequest, options ...grpc.CallOption) (*lnrpc.WalletBalanceResponse, error) {
+	result, err := cl.client.Call("listfunds")
+	if err != nil {
+		return nil, err
+	}
+	response := &lnrpc.WalletBalanceResponse{}
+	for _, output := range result.Get("outputs").Array() {
+		switch output.Get("status").String() {
+		case "confirmed":
+			response.ConfirmedBalance += output.Get("value").Int()
+		case "unconfirmed":
+			response.UnconfirmedBalance += output.Get("value").Int()
+		}
+	}
+	response.TotalBalance = response.ConfirmedBalance + response.UnconfirmedBalance
+	return response, nil
+}
+
+// parseFeatureBits converts a hex encoded feature bit vector to the feature map of lnd
+func parseFeatureBits(featureHex string) map[uint32]*lnrpc.Feature {
+	features := map[uint32]*lnrpc.Feature{}
+	featureBytes, err := hex.DecodeString(featureHex)
+	if err != nil {
+		return features
+	}
+	for i := range featureBytes {
+		// the vector is big endian, bit 0 is the lowest bit of the last byte
+		b := featureBytes[len(featureBytes)-1-i]
+		for j := 0; j < 8; j++ {
+			if b&(1<<j) == 0 {
+				continue
+			}
+			bit := lnwire.FeatureBit(i*8 + j)
+			name, known := lnwire.Features[bit]
+			features[uint32(bit)] = &lnrpc.Feature{
+				Name:       name,
+				IsRequired: bit.IsRequired(),
+				IsKnown:    known,
+			}
+		}
+	}
+	return features
+}
+
 func (cl *CLNClient) DecodeBolt11(ctx context.Context, bolt11 string, options ...grpc.CallOption) (*lnrpc.PayReq, error) {
 	result, err := cl.client.Call("decode", bolt11)
 	if err != nil {
@@ -244,6 +373,30 @@ func (cl *CLNClient) DecodeBolt11(ctx context.Context, bolt11 string, options ..
 	}, nil
 }
 
+// TrackPayment returns the state of the payment attempts of the hash, one complete attempt settles the payment
+func (cl *CLNClient) TrackPayment(ctx context.Context, paymentHash []byte) (*lnrpc.Payment, error) {
+	result, err := cl.client.CallNamed("listsendpays", "payment_hash", hex.EncodeToString(paymentHash))
+	if err != nil {
+		return nil, err
+	}
+	attempts := result.Get("payments").Array()
+	if len(attempts) == 0 {
+		return nil, ErrPaymentNotFound
+	}
+	payment := &lnrpc.Payment{PaymentHash: hex.EncodeToString(paymentHash), Status: lnrpc.Payment_FAILED}
+	for _, attempt := range attempts {
+		switch attempt.Get("status").String() {
+		case "complete":
+			payment.Status = lnrpc.Payment_SUCCEEDED
+			payment.PaymentPreimage = attempt.Get("payment_preimage").String()
+			return payment, nil
+		case "pending":
+			payment.Status = lnrpc.Payment_IN_FLIGHT
+		}
+	}
+	return payment, nil
+}
+
 func (cl *CLNClient) DecodeBolt12(ctx context.Context, bolt12 string) (decoded *Bolt12, err error) {
 	result, err := cl.client.Call("decode", bolt12)
 	if err != nil {
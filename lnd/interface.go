@@ -2,26 +2,45 @@ package lnd
 
 import (
 	"context"
+	"errors"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"google.golang.org/grpc"
 )
 
+// ErrNoRoute is returned by SendPaymentSync when the node found no route to the destination within the fee limit
+var ErrNoRoute = errors.New("no route found")
+
+// ErrPaymentNotFound is returned by TrackPayment when the node never sent a payment of the hash
+var ErrPaymentNotFound = errors.New("payment not found")
+
 type LightningClientWrapper interface {
 	ListChannels(ctx context.Context, req *lnrpc.ListChannelsRequest, options ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error)
 	SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest, options ...grpc.CallOption) (*lnrpc.SendResponse, error)
 	AddInvoice(ctx context.Context, req *lnrpc.Invoice, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error)
+	AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error)
+	SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error)
+	CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error)
 	SubscribeInvoices(ctx context.Context, req *lnrpc.InvoiceSubscription, options ...grpc.CallOption) (SubscribeInvoicesWrapper, error)
+	ListInvoices(ctx context.Context, req *lnrpc.ListInvoiceRequest, options ...grpc.CallOption) (*lnrpc.ListInvoiceResponse, error)
+	SubscribeChannelEvents(ctx context.Context, req *lnrpc.ChannelEventSubscription, options ...grpc.CallOption) (SubscribeChannelEventsWrapper, error)
 	GetInfo(ctx context.Context, req *lnrpc.GetInfoRequest, options ...grpc.CallOption) (*lnrpc.GetInfoResponse, error)
+	WalletBalance(ctx context.Context, req *lnrpc.WalletBalanceRequest, options ...grpc.CallOption) (*lnrpc.WalletBalanceResponse, error)
 	DecodeBolt12(ctx context.Context, bolt12 string) (*Bolt12, error)
 	FetchBolt12Invoice(ctx context.Context, offer, memo string, amount int64) (*Bolt12, error)
 	DecodeBolt11(ctx context.Context, bolt11 string, options ...grpc.CallOption) (*lnrpc.PayReq, error)
+	TrackPayment(ctx context.Context, paymentHash []byte) (*lnrpc.Payment, error)
 }
 
 type SubscribeInvoicesWrapper interface {
 	Recv() (*lnrpc.Invoice, error)
 }
 
+type SubscribeChannelEventsWrapper interface {
+	Recv() (*lnrpc.ChannelEventUpdate, error)
+}
+
 //Bolt12 can be both an offer or an invoice
 //depending on Type
 type Bolt12 struct {
@@ -9,10 +9,15 @@ import (
 	"fmt"
 	"io/ioutil"
 
+	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/macaroons"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 	"gopkg.in/macaroon.v2"
 )
 
@@ -31,7 +36,9 @@ type LNDoptions struct {
 }
 
 type LNDWrapper struct {
-	client lnrpc.LightningClient
+	client         lnrpc.LightningClient
+	invoicesClient invoicesrpc.InvoicesClient
+	routerClient   routerrpc.RouterClient
 }
 
 func NewLNDclient(lndOptions LNDoptions) (result *LNDWrapper, err error) {
@@ -93,7 +100,9 @@ func NewLNDclient(lndOptions LNDoptions) (result *LNDWrapper, err error) {
 	}
 
 	return &LNDWrapper{
-		client: lnrpc.NewLightningClient(conn),
+		client:         lnrpc.NewLightningClient(conn),
+		invoicesClient: invoicesrpc.NewInvoicesClient(conn),
+		routerClient:   routerrpc.NewRouterClient(conn),
 	}, nil
 }
 
@@ -102,27 +111,75 @@ func (wrapper *LNDWrapper) ListChannels(ctx context.Context, req *lnrpc.ListChan
 }
 
 func (wrapper *LNDWrapper) SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest, options ...grpc.CallOption) (*lnrpc.SendResponse, error) {
-	return wrapper.client.SendPaymentSync(ctx, req, options...)
+	resp, err := wrapper.client.SendPaymentSync(ctx, req, options...)
+	if err != nil {
+		return nil, err
+	}
+	// lnd reports the failure reason of the payment as the payment error
+	if resp.PaymentError == channeldb.FailureReasonNoRoute.Error() {
+		return nil, fmt.Errorf("%w: %s", ErrNoRoute, resp.PaymentError)
+	}
+	return resp, nil
 }
 
 func (wrapper *LNDWrapper) AddInvoice(ctx context.Context, req *lnrpc.Invoice, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
 	return wrapper.client.AddInvoice(ctx, req, options...)
 }
 
+func (wrapper *LNDWrapper) AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error) {
+	return wrapper.invoicesClient.AddHoldInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error) {
+	return wrapper.invoicesClient.SettleInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error) {
+	return wrapper.invoicesClient.CancelInvoice(ctx, req, options...)
+}
+
 func (wrapper *LNDWrapper) SubscribeInvoices(ctx context.Context, req *lnrpc.InvoiceSubscription, options ...grpc.CallOption) (SubscribeInvoicesWrapper, error) {
 	return wrapper.client.SubscribeInvoices(ctx, req, options...)
 }
 
+func (wrapper *LNDWrapper) ListInvoices(ctx context.Context, req *lnrpc.ListInvoiceRequest, options ...grpc.CallOption) (*lnrpc.ListInvoiceResponse, error) {
+	return wrapper.client.ListInvoices(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) SubscribeChannelEvents(ctx context.Context, req *lnrpc.ChannelEventSubscription, options ...grpc.CallOption) (SubscribeChannelEventsWrapper, error) {
+	return wrapper.client.SubscribeChannelEvents(ctx, req, options...)
+}
+
 func (wrapper *LNDWrapper) GetInfo(ctx context.Context, req *lnrpc.GetInfoRequest, options ...grpc.CallOption) (*lnrpc.GetInfoResponse, error) {
 	return wrapper.client.GetInfo(ctx, req, options...)
 }
 
+func (wrapper *LNDWrapper) WalletBalance(ctx context.Context, req *lnrpc.WalletBalanceRequest, options ...grpc.CallOption) (*lnrpc.WalletBalanceResponse, error) {
+	return wrapper.client.WalletBalance(ctx, req, options...)
+}
+
 func (wrapper *LNDWrapper) DecodeBolt11(ctx context.Context, bolt11 string, options ...grpc.CallOption) (*lnrpc.PayReq, error) {
 	return wrapper.client.DecodePayReq(ctx, &lnrpc.PayReqString{
 		PayReq: bolt11,
 	})
 }
 
+// TrackPayment returns the current state of the payment, lnd answers NotFound for payments it never sent
+func (wrapper *LNDWrapper) TrackPayment(ctx context.Context, paymentHash []byte) (*lnrpc.Payment, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := wrapper.routerClient.TrackPaymentV2(ctx, &routerrpc.TrackPaymentRequest{PaymentHash: paymentHash})
+	if err != nil {
+		return nil, err
+	}
+	// the first update is the current state, the stream is closed after it
+	payment, err := stream.Recv()
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrPaymentNotFound
+	}
+	return payment, err
+}
+
 func (wrapper *LNDWrapper) DecodeBolt12(ctx context.Context, bolt12 string) (*Bolt12, error) {
 	return nil, fmt.Errorf("Bolt12 is not supported yet, LL get on with it!")
 }
@@ -0,0 +1,233 @@
+package lnd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SwapTypeLoopOut = "LOOP_OUT"
+	SwapTypeLoopIn  = "LOOP_IN"
+
+	SwapStateSuccess = "SUCCESS"
+	SwapStateFailed  = "FAILED"
+)
+
+// SwapClientWrapper is implemented by the clients of submarine swap services
+type SwapClientWrapper interface {
+	LoopOutQuote(ctx context.Context, amount int64) (*SwapQuote, error)
+	LoopInQuote(ctx context.Context, amount int64) (*SwapQuote, error)
+	LoopOut(ctx context.Context, amount int64, limits *SwapQuote) (*SwapStatus, error)
+	LoopIn(ctx context.Context, amount int64, limits *SwapQuote) (*SwapStatus, error)
+	SwapInfo(ctx context.Context, id string) (*SwapStatus, error)
+}
+
+// LoopOptions are the options for the connection to the REST API of the loop daemon.
+type LoopOptions struct {
+	Address     string
+	CertHex     string
+	MacaroonHex string
+}
+
+// SwapQuote are the costs quoted for a swap, they are used as the limits when the swap is started
+type SwapQuote struct {
+	SwapFee          int64 `json:"swap_fee"`
+	MinerFee         int64 `json:"miner_fee"`
+	PrepayAmount     int64 `json:"prepay_amount,omitempty"`
+	MaxRoutingFee    int64 `json:"max_routing_fee,omitempty"`
+	MaxPrepayRouting int64 `json:"max_prepay_routing_fee,omitempty"`
+}
+
+// Total is the maximum cost of the swap
+func (q *SwapQuote) Total() int64 {
+	return q.SwapFee + q.MinerFee + q.MaxRoutingFee + q.MaxPrepayRouting
+}
+
+// SwapStatus is the state and the costs of a swap
+type SwapStatus struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	State         string `json:"state"`
+	FailureReason string `json:"failure_reason"`
+	Amount        int64  `json:"amt,string"`
+	HtlcAddress   string `json:"htlc_address"`
+	CostServer    int64  `json:"cost_server,string"`
+	CostOnchain   int64  `json:"cost_onchain,string"`
+	CostOffchain  int64  `json:"cost_offchain,string"`
+}
+
+type LoopClient struct {
+	address     string
+	macaroonHex string
+	httpClient  *http.Client
+}
+
+func NewLoopClient(loopOptions LoopOptions) (*LoopClient, error) {
+	if loopOptions.MacaroonHex == "" {
+		return nil, errors.New("loop macaroon is missing")
+	}
+	tlsConfig := &tls.Config{}
+	if loopOptions.CertHex != "" {
+		cert, err := hex.DecodeString(loopOptions.CertHex)
+		if err != nil {
+			return nil, err
+		}
+		cp := x509.NewCertPool()
+		cp.AppendCertsFromPEM(cert)
+		tlsConfig.RootCAs = cp
+	}
+	address := loopOptions.Address
+	if !strings.HasPrefix(address, "http") {
+		address = "https://" + address
+	}
+	return &LoopClient{
+		address:     strings.TrimSuffix(address, "/"),
+		macaroonHex: loopOptions.MacaroonHex,
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+type loopOutQuoteResponse struct {
+	SwapFee      int64 `json:"swap_fee_sat,string"`
+	PrepayAmount int64 `json:"prepay_amt_sat,string"`
+	SweepFee     int64 `json:"htlc_sweep_fee_sat,string"`
+}
+
+type loopInQuoteResponse struct {
+	SwapFee    int64 `json:"swap_fee_sat,string"`
+	PublishFee int64 `json:"htlc_publish_fee_sat,string"`
+}
+
+type loopOutRequest struct {
+	Amount              int64  `json:"amt,string"`
+	MaxSwapRoutingFee   int64  `json:"max_swap_routing_fee,string"`
+	MaxPrepayRoutingFee int64  `json:"max_prepay_routing_fee,string"`
+	MaxSwapFee          int64  `json:"max_swap_fee,string"`
+	MaxPrepayAmount     int64  `json:"max_prepay_amt,string"`
+	MaxMinerFee         int64  `json:"max_miner_fee,string"`
+	Initiator           string `json:"initiator"`
+}
+
+type loopInRequest struct {
+	Amount      int64  `json:"amt,string"`
+	MaxSwapFee  int64  `json:"max_swap_fee,string"`
+	MaxMinerFee int64  `json:"max_miner_fee,string"`
+	Initiator   string `json:"initiator"`
+}
+
+type swapResponse struct {
+	ID          string `json:"id"`
+	HtlcAddress string `json:"htlc_address"`
+}
+
+// maxSwapRoutingFee is the routing fee limit for the swap payments, the same default the loop CLI uses
+func maxSwapRoutingFee(amount int64) int64 {
+	return 10 + amount*2/100
+}
+
+func (client *LoopClient) LoopOutQuote(ctx context.Context, amount int64) (*SwapQuote, error) {
+	resp := loopOutQuoteResponse{}
+	if err := client.do(ctx, http.MethodGet, fmt.Sprintf("/v1/loop/out/quote/%d", amount), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &SwapQuote{
+		SwapFee:          resp.SwapFee,
+		MinerFee:         resp.SweepFee,
+		PrepayAmount:     resp.PrepayAmount,
+		MaxRoutingFee:    maxSwapRoutingFee(amount),
+		MaxPrepayRouting: maxSwapRoutingFee(resp.PrepayAmount),
+	}, nil
+}
+
+func (client *LoopClient) LoopInQuote(ctx context.Context, amount int64) (*SwapQuote, error) {
+	resp := loopInQuoteResponse{}
+	if err := client.do(ctx, http.MethodGet, fmt.Sprintf("/v1/loop/in/quote/%d", amount), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &SwapQuote{
+		SwapFee:  resp.SwapFee,
+		MinerFee: resp.PublishFee,
+	}, nil
+}
+
+func (client *LoopClient) LoopOut(ctx context.Context, amount int64, limits *SwapQuote) (*SwapStatus, error) {
+	req := loopOutRequest{
+		Amount:              amount,
+		MaxSwapRoutingFee:   limits.MaxRoutingFee,
+		MaxPrepayRoutingFee: limits.MaxPrepayRouting,
+		MaxSwapFee:          limits.SwapFee,
+		MaxPrepayAmount:     limits.PrepayAmount,
+		MaxMinerFee:         limits.MinerFee,
+		Initiator:           "lndhub",
+	}
+	resp := swapResponse{}
+	if err := client.do(ctx, http.MethodPost, "/v1/loop/out", req, &resp); err != nil {
+		return nil, err
+	}
+	return &SwapStatus{ID: resp.ID, Type: SwapTypeLoopOut, Amount: amount, HtlcAddress: resp.HtlcAddress}, nil
+}
+
+func (client *LoopClient) LoopIn(ctx context.Context, amount int64, limits *SwapQuote) (*SwapStatus, error) {
+	req := loopInRequest{
+		Amount:      amount,
+		MaxSwapFee:  limits.SwapFee,
+		MaxMinerFee: limits.MinerFee,
+		Initiator:   "lndhub",
+	}
+	resp := swapResponse{}
+	if err := client.do(ctx, http.MethodPost, "/v1/loop/in", req, &resp); err != nil {
+		return nil, err
+	}
+	return &SwapStatus{ID: resp.ID, Type: SwapTypeLoopIn, Amount: amount, HtlcAddress: resp.HtlcAddress}, nil
+}
+
+func (client *LoopClient) SwapInfo(ctx context.Context, id string) (*SwapStatus, error) {
+	resp := SwapStatus{}
+	if err := client.do(ctx, http.MethodGet, "/v1/loop/swap/"+id, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (client *LoopClient) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, client.address+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Grpc-Metadata-macaroon", client.macaroonHex)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("loop request %s %s failed with status %d: %s", method, path, resp.StatusCode, string(respBody))
+	}
+	return json.Unmarshal(respBody, result)
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/migrate"
 	"github.com/ziflex/lecho/v3"
 	"golang.org/x/time/rate"
@@ -37,6 +38,9 @@ import (
 //go:embed templates/index.html
 var indexHtml string
 
+//go:embed templates/donate.html
+var donateHtml string
+
 //go:embed static/*
 var staticContent embed.FS
 
@@ -54,7 +58,9 @@ func main() {
 	}
 
 	// Setup logging to STDOUT or a configrued log file
-	logger := lib.Logger(c.LogFilePath)
+	// The most recent errors are kept for the support bundle
+	errorLog := lib.NewErrorLog(100)
+	logger := lib.Logger(c.LogFilePath, errorLog)
 
 	// Open a DB connection based on the configured DATABASE_URI
 	dbConn, err := db.Open(c.DatabaseUri)
@@ -62,9 +68,18 @@ func main() {
 		logger.Fatalf("Error initializing db connection: %v", err)
 	}
 
+	// Balance and transaction history requests are served by the read replica if configured
+	var replicaConn *bun.DB
+	if c.DatabaseReplicaUri != "" {
+		replicaConn, err = db.Open(c.DatabaseReplicaUri)
+		if err != nil {
+			logger.Fatalf("Error initializing read replica connection: %v", err)
+		}
+	}
+
 	// Migrate the DB
 	ctx := context.Background()
-	migrator := migrate.NewMigrator(dbConn, migrations.Migrations)
+	migrator := migrate.NewMigrator(dbConn, migrations.For(dbConn))
 	err = migrator.Init(ctx)
 	if err != nil {
 		logger.Fatalf("Error initializing db migrator: %v", err)
@@ -127,33 +142,173 @@ func main() {
 	svc := &service.LndhubService{
 		Config:             c,
 		DB:                 dbConn,
+		ReplicaDB:          replicaConn,
 		LndClient:          lndClient,
 		Logger:             logger,
+		ErrorLog:           errorLog,
 		IdentityPubkey:     getInfo.IdentityPubkey,
 		InvoiceSubscribers: map[int64]chan models.Invoice{},
 	}
 
+	// Large invoice metadata is kept outside of Postgres if a blob store is configured
+	svc.BlobStore, err = service.NewBlobStore(c)
+	if err != nil {
+		e.Logger.Fatalf("Error initializing the blob store: %v", err)
+	}
+
+	// Init the loop client for submarine swaps if configured
+	if c.LoopAddress != "" {
+		loopClient, err := lnd.NewLoopClient(lnd.LoopOptions{
+			Address:     c.LoopAddress,
+			MacaroonHex: c.LoopMacaroonHex,
+			CertHex:     c.LoopCertHex,
+		})
+		if err != nil {
+			e.Logger.Fatalf("Error initializing the loop client: %v", err)
+		}
+		svc.SwapClient = loopClient
+	}
+
 	strictRateLimitMiddleware := createRateLimitMiddleware(c.StrictRateLimit, c.BurstRateLimit)
 	// Public endpoints for account creation and authentication
 	e.POST("/auth", controllers.NewAuthController(svc).Auth)
 	e.POST("/create", controllers.NewCreateUserController(svc).CreateUser, strictRateLimitMiddleware)
+	e.POST("/migration/redeem", controllers.NewMigrationController(svc).RedeemClaim, strictRateLimitMiddleware)
 	e.POST("/invoice/:user_login", controllers.NewInvoiceController(svc).Invoice, middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(rate.Limit(c.DefaultRateLimit))))
 
-	// Secured endpoints which require a Authorization token (JWT)
-	secured := e.Group("", tokens.Middleware(c.JWTSecret), middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(rate.Limit(c.DefaultRateLimit))))
-	securedWithStrictRateLimit := e.Group("", tokens.Middleware(c.JWTSecret), strictRateLimitMiddleware)
-	secured.POST("/addinvoice", controllers.NewAddInvoiceController(svc).AddInvoice)
-	securedWithStrictRateLimit.POST("/payinvoice", controllers.NewPayInvoiceController(svc).PayInvoice)
+	// Routes api keys can use with their scopes, all other secured endpoints require a JWT
+	routeScopes := tokens.RouteScopes{
+		"POST /addinvoice":                  tokens.ScopeInvoiceCreate,
+		"DELETE /v2/invoices/:payment_hash": tokens.ScopeInvoiceCreate,
+		"POST /payinvoice":                  tokens.ScopePaymentSend,
+		"POST /keysend":                     tokens.ScopePaymentSend,
+		"POST /v2/payments/lnaddress":       tokens.ScopePaymentSend,
+		"POST /v2/transfer":                 tokens.ScopePaymentSend,
+		"GET /balance":                      tokens.ScopeAccountRead,
+		"GET /v2/balance/history":           tokens.ScopeAccountRead,
+		"GET /gettxs":                       tokens.ScopeAccountRead,
+		"GET /getuserinvoices":              tokens.ScopeAccountRead,
+		"GET /checkpayment/:payment_hash":   tokens.ScopeAccountRead,
+		"GET /v2/transactions/export":       tokens.ScopeAccountRead,
+		"GET /getinfo":                      tokens.ScopeAccountRead,
+	}
+	authMiddleware := tokens.Middleware(svc.TokenMiddlewareOptions(routeScopes))
+
+	// Secured endpoints which require a Authorization token (JWT) or an api key
+	secured := e.Group("", authMiddleware, middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(rate.Limit(c.DefaultRateLimit))))
+	securedWithStrictRateLimit := e.Group("", authMiddleware, strictRateLimitMiddleware)
+	idempotencyMiddleware := controllers.IdempotencyMiddleware(svc)
+	secured.POST("/addinvoice", controllers.NewAddInvoiceController(svc).AddInvoice, idempotencyMiddleware)
+	secured.POST("/v2/invoices/:payment_hash/settle", controllers.NewAddInvoiceController(svc).SettleHoldInvoice)
+	secured.POST("/v2/invoices/:payment_hash/cancel", controllers.NewAddInvoiceController(svc).CancelHoldInvoice)
+	secured.DELETE("/v2/invoices/:payment_hash", controllers.NewAddInvoiceController(svc).CancelInvoice)
+	secured.PATCH("/v2/invoices/:payment_hash", controllers.NewGetTXSController(svc).SetLabel)
+	securedWithStrictRateLimit.POST("/payinvoice", controllers.NewPayInvoiceController(svc).PayInvoice, idempotencyMiddleware)
+	securedWithStrictRateLimit.POST("/payinvoice/bulk", controllers.NewPayInvoiceController(svc).BulkPayInvoice)
+	securedWithStrictRateLimit.POST("/v2/payments/lnaddress", controllers.NewPayInvoiceController(svc).PayLnurl)
+	securedWithStrictRateLimit.POST("/v2/transfer", controllers.NewPayInvoiceController(svc).Transfer, idempotencyMiddleware)
+	securedWithStrictRateLimit.POST("/v2/payments/:payment_hash/retry", controllers.NewPayInvoiceController(svc).RetryPayment)
 	secured.GET("/gettxs", controllers.NewGetTXSController(svc).GetTXS)
 	secured.GET("/getuserinvoices", controllers.NewGetTXSController(svc).GetUserInvoices)
+	secured.GET("/v2/transactions/export", controllers.NewGetTXSController(svc).ExportTransactions)
+	secured.GET("/v2/fees", controllers.NewFeesController(svc).GetFeeReport)
+	securedWithStrictRateLimit.GET("/v2/account/export", controllers.NewPersonalDataController(svc).ExportPersonalData)
+	securedWithStrictRateLimit.POST("/v2/account/delete", controllers.NewPersonalDataController(svc).DeleteAccount)
+	apiKeysController := controllers.NewAPIKeysController(svc)
+	secured.GET("/v2/apikeys", apiKeysController.GetAPIKeys)
+	securedWithStrictRateLimit.POST("/v2/apikeys", apiKeysController.CreateAPIKey)
+	secured.DELETE("/v2/apikeys/:id", apiKeysController.DeleteAPIKey)
+	secured.POST("/auth/logout", controllers.NewAuthController(svc).Logout)
+	sessionsController := controllers.NewSessionsController(svc)
+	secured.GET("/v2/sessions", sessionsController.GetSessions)
+	secured.DELETE("/v2/sessions/:id", sessionsController.RevokeSession)
+	securedWithStrictRateLimit.POST("/v2/tokens/readonly", sessionsController.CreateReadOnlyToken)
+	securedWithStrictRateLimit.POST("/v2/tokens", sessionsController.CreateSpendLimitedToken)
 	secured.GET("/checkpayment/:payment_hash", controllers.NewCheckPaymentController(svc).CheckPayment)
 	secured.GET("/balance", controllers.NewBalanceController(svc).Balance)
+	secured.GET("/v2/balance/history", controllers.NewBalanceController(svc).BalanceHistory)
 	secured.GET("/getinfo", controllers.NewGetInfoController(svc).GetInfo, createCacheClient().Middleware())
-	securedWithStrictRateLimit.POST("/keysend", controllers.NewKeySendController(svc).KeySend)
+	securedWithStrictRateLimit.POST("/keysend", controllers.NewKeySendController(svc).KeySend, idempotencyMiddleware)
+	securedWithStrictRateLimit.POST("/keysend/multi", controllers.NewKeySendController(svc).MultiKeySend)
+	keysendDestinationsController := controllers.NewKeysendDestinationsController(svc)
+	secured.GET("/keysend/destinations", keysendDestinationsController.GetDestinations)
+	secured.POST("/keysend/destinations", keysendDestinationsController.SaveDestination)
+	secured.DELETE("/keysend/destinations/:name", keysendDestinationsController.DeleteDestination)
+	contactsController := controllers.NewContactsController(svc)
+	secured.GET("/contacts", contactsController.GetContacts)
+	secured.POST("/contacts", contactsController.CreateContact)
+	secured.DELETE("/contacts/:id", contactsController.DeleteContact)
+	secured.GET("/contacts/:id/payments", contactsController.GetContactPayments)
+	invoicePresetsController := controllers.NewInvoicePresetsController(svc)
+	secured.GET("/invoicepresets", invoicePresetsController.GetPresets)
+	secured.POST("/invoicepresets", invoicePresetsController.SavePreset)
+	secured.DELETE("/invoicepresets/:id", invoicePresetsController.DeletePreset)
+	secured.POST("/invoicepresets/:id/invoice", invoicePresetsController.AddPresetInvoice)
+	recurringInvoicesController := controllers.NewRecurringInvoicesController(svc)
+	secured.GET("/recurringinvoices", recurringInvoicesController.GetRecurringInvoices)
+	secured.POST("/recurringinvoices", recurringInvoicesController.CreateRecurringInvoice)
+	secured.PUT("/recurringinvoices/:id", recurringInvoicesController.UpdateRecurringInvoice)
+	secured.DELETE("/recurringinvoices/:id", recurringInvoicesController.DeleteRecurringInvoice)
+	secured.GET("/recurringinvoices/:id/invoice", recurringInvoicesController.GetCurrentInvoice)
+	autoWithdrawalController := controllers.NewAutoWithdrawalController(svc)
+	secured.GET("/v2/autowithdraw", autoWithdrawalController.GetAutoWithdrawal)
+	secured.PUT("/v2/autowithdraw", autoWithdrawalController.SetAutoWithdrawal)
+	secured.DELETE("/v2/autowithdraw", autoWithdrawalController.DeleteAutoWithdrawal)
+	prismController := controllers.NewPrismController(svc)
+	secured.GET("/v2/prism", prismController.GetPrismSplits)
+	secured.PUT("/v2/prism", prismController.SetPrismSplits)
+	secured.DELETE("/v2/prism", prismController.DeletePrismSplits)
+	preferencesController := controllers.NewPreferencesController(svc)
+	secured.GET("/preferences", preferencesController.GetPreferences)
+	secured.PUT("/preferences", preferencesController.SetPreferences)
+	notificationsController := controllers.NewNotificationsController(svc)
+	secured.GET("/notifications", notificationsController.GetNotifications)
+	secured.POST("/notifications/read", notificationsController.MarkNotificationsRead)
+	secured.GET("/counters", controllers.NewCountersController(svc).GetCounters)
+	memoKeyController := controllers.NewMemoKeyController(svc)
+	secured.GET("/memokey", memoKeyController.GetMemoKey)
+	secured.PUT("/memokey", memoKeyController.SetMemoKey)
+	secured.GET("/memokey/recipient", memoKeyController.GetRecipientMemoKey)
+	donationPageController := controllers.NewDonationPageController(svc, donateHtml)
+	secured.GET("/donationpage", donationPageController.GetDonationPage)
+	secured.PUT("/donationpage", donationPageController.SaveDonationPage)
+	securedWithStrictRateLimit.POST("/migration/export", controllers.NewMigrationController(svc).ExportBalance)
+	securedWithStrictRateLimit.POST("/migration/import", controllers.NewMigrationController(svc).ImportBalance)
 	secured.GET("/getinfo", controllers.NewGetInfoController(svc).GetInfo)
 	secured.POST("/bolt12/fetchinvoice", controllers.NewBolt12Controller(svc).FetchInvoice)
 	secured.POST("/bolt12/pay", controllers.NewBolt12Controller(svc).PayBolt12)
 
+	// Admin endpoints are only available if an admin token is configured
+	if c.AdminToken != "" {
+		admin := e.Group("/admin", tokens.AdminMiddleware(c.AdminToken))
+		adminController := controllers.NewAdminController(svc)
+		admin.POST("/invoices/:id/fail", adminController.ForceFailInvoice)
+		admin.GET("/invoices/:id/route", adminController.PaymentRoute)
+		admin.GET("/webhooks/dead", adminController.DeadWebhooks)
+		admin.POST("/webhooks/:id/replay", adminController.ReplayWebhook)
+		admin.DELETE("/webhooks/:id", adminController.DiscardWebhook)
+		admin.GET("/incidents", adminController.IntegrityIncidents)
+		admin.GET("/metrics", adminController.Metrics)
+		admin.GET("/stats", adminController.Stats)
+		admin.GET("/fees", adminController.FeeReport)
+		admin.GET("/balance-audit", adminController.BalanceAudit)
+		admin.PUT("/users/:id/tier", adminController.SetUserTier)
+		admin.POST("/users/:id/freeze", adminController.FreezeUser)
+		admin.POST("/users/:id/unfreeze", adminController.UnfreezeUser)
+		admin.POST("/users/:id/adjustments", adminController.AdjustBalance)
+		admin.GET("/backups", adminController.BackupRuns)
+		admin.POST("/reconcile", adminController.ReconcileSettlements)
+		admin.POST("/backups", adminController.RunBackup)
+		admin.GET("/retention", adminController.RetentionReport)
+		admin.POST("/retention", adminController.ApplyRetention)
+		admin.GET("/support-bundle", adminController.SupportBundle)
+		if svc.SwapClient != nil {
+			admin.GET("/swaps", adminController.Swaps)
+			admin.GET("/swaps/quote", adminController.SwapQuote)
+			admin.POST("/swaps", adminController.StartSwap)
+		}
+	}
+
 	// These endpoints are currently not supported and we return a blank response for backwards compatibility
 	blankController := controllers.NewBlankController(svc)
 	secured.GET("/getbtc", blankController.GetBtc)
@@ -167,6 +322,16 @@ func main() {
 	e.GET("/static/css/*", echo.WrapHandler(http.FileServer(http.FS(staticContent))))
 	e.GET("/static/img/*", echo.WrapHandler(http.FileServer(http.FS(staticContent))))
 
+	// Public donation pages of users that enabled them, no Authorization required
+	donationRateLimit := middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(rate.Limit(c.DefaultRateLimit)))
+	e.GET("/donate/:slug", donationPageController.DonationPage, donationRateLimit)
+	e.GET("/donate/:slug/json", donationPageController.DonationPageJSON, donationRateLimit)
+	e.GET("/donate/:slug/lnurlp", donationPageController.LnurlPay, donationRateLimit)
+	e.GET("/donate/:slug/lnurlp/callback", donationPageController.LnurlPayCallback, donationRateLimit)
+
+	// Readiness probe for load balancers and orchestrators, no Authorization required
+	e.GET("/readyz", controllers.NewHealthController(svc).Ready)
+
 	e.GET("/bolt12/decode/:offer", controllers.NewBolt12Controller(svc).Decode)
 	//invoice streaming
 	//Authentication should be done through the query param because this is a websocket
@@ -176,6 +341,41 @@ func main() {
 	// CLN: todo: re-write logic
 	go svc.InvoiceUpdateSubscription(context.Background())
 
+	// Settle invoices that were paid while the subscription was down
+	go svc.StartSettlementReconciler(context.Background())
+
+	// Mark open invoices as expired once they pass their expiry
+	go svc.StartInvoiceExpiryWorker(context.Background())
+
+	// Generate the invoices of recurring invoice templates when a new period starts
+	go svc.StartRecurringInvoiceScheduler(context.Background())
+
+	// Pay out the balances above the users' auto-withdrawal thresholds
+	go svc.StartAutoWithdrawScheduler(context.Background())
+
+	// Deliver queued webhooks and retry failed deliveries in the background
+	go svc.StartWebhookDispatcher(context.Background())
+
+	// Run queued background jobs like settlement side effects
+	go svc.StartJobWorker(context.Background())
+
+	// Check the node's channel liquidity and pause outgoing payments when it runs low
+	go svc.StartLiquidityMonitor(context.Background())
+	// Check the liquidity right away when channels open, close or change their state
+	go svc.StartChannelEventSubscription(context.Background())
+
+	// Look for ledger inconsistencies and report them as incidents
+	go svc.StartIntegrityMonitor(context.Background())
+
+	// Verify the ledger and trigger the nightly database backup
+	go svc.StartBackupScheduler(context.Background())
+
+	// Purge and anonymize old invoices according to the retention policy
+	go svc.StartRetentionScheduler(context.Background())
+
+	// Track pending swaps and rebalance the node's liquidity with automatic swaps
+	go svc.StartSwapMonitor(context.Background())
+
 	// Start server
 	go func() {
 		if err := e.Start(fmt.Sprintf(":%v", c.Port)); err != nil && err != http.ErrServerClosed {